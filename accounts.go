@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+Accounts are extra lit nodes run in the same process, each completely
+separate from the main node and each other: own key, DBs, wallets, peers
+and channels, all under <lit dir>/accounts/<name>.  They connect to the
+same coin nodes as the main node (each with its own connection).
+
+RPC clients pick an account by connecting to /ws/<name> instead of /ws
+(lit-af -account <name>); the main node stays on /ws.  Accounts don't
+listen for peers until told to over RPC, don't run a watchtower, and a
+Stop RPC to an account only stops that account.
+*/
+
+var accountNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]{1,32}$")
+
+// startAccounts starts a node for each account in the config, and returns
+// an rpc handler for each, by name, and the nodes.  Their DBs go under
+// the main node's, mainDirs.
+func startAccounts(conf *config, confPath string, mainDirs qln.DataDirs) (
+	map[string]*litrpc.LitRPC, []*qln.LitNode, error) {
+	rpcs := make(map[string]*litrpc.LitRPC)
+	var nodes []*qln.LitNode
+
+	// accounts never run the tower; that's for the main node
+	aconf := *conf
+	aconf.Tower = false
+
+	for _, name := range conf.Accounts {
+		name := name
+		if !accountNameRegexp.MatchString(name) {
+			return nil, nil, fmt.Errorf("bad account name %q", name)
+		}
+		if _, ok := rpcs[name]; ok {
+			return nil, nil, fmt.Errorf("account %s given twice", name)
+		}
+		dir := filepath.Join(conf.LitHomeDir, "accounts", name)
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fmt.Printf("account %s:\n", name)
+		key, err := lnutil.ReadKeyFile(filepath.Join(dir, defaultKeyFileName))
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs := mainDirs.Account(name)
+		err = qln.PrepareDataDirs(dirs, conf.MoveData)
+		if err != nil {
+			return nil, nil, err
+		}
+		node, err := qln.NewLitNodeDirs(key, dirs, conf.TrackerURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = linkWallets(node, key, &aconf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if conf.BackupDir != "" {
+			node.StartBackups(qln.BackupConfig{
+				Dir:      filepath.Join(conf.BackupDir, "accounts", name),
+				Interval: conf.BackupInterval,
+				Keep:     conf.BackupKeep,
+				Remote:   conf.BackupRemote,
+			})
+		}
+		node.StartFiat(qln.FiatConfig{Currency: conf.Fiat, Sources: conf.FiatSources})
+		node.StartCharts(conf.ChartInterval)
+		if conf.ReplayLog {
+			err = node.StartReplayLog(filepath.Join(dir, "replay.log"))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		applyHotConfig(node, conf)
+
+		rpcl := new(litrpc.LitRPC)
+		rpcl.Node = node
+		rpcl.OffButton = make(chan bool, 1)
+		rpcl.Reload = func() error {
+			return reloadConfig(confPath, node)
+		}
+		rpcl.Policy, err = newSpendPolicy(conf, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		rpcl.ReadOnly = conf.ReadOnly
+		rpcl.Account = name
+		go func() {
+			<-rpcl.OffButton
+			fmt.Printf("Got stop request for account %s\n", name)
+			node.Shutdown()
+		}()
+
+		rpcs[name] = rpcl
+		nodes = append(nodes, node)
+	}
+	return rpcs, nodes, nil
+}
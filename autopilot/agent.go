@@ -0,0 +1,429 @@
+package autopilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+The autopilot agent keeps a node's channels topped up without anyone
+watching it.  Every Interval it:
+
+- tries to reconnect to peers of the channels it opened, which also tells
+  it how often those peers are up
+- closes channels it opened which haven't changed state in Inactive
+- if there are fewer than Channels open channels, or less than Outbound
+  satoshis on our side of them, opens one more channel of ChanSize, as
+  long as the channels it has open stay within Budget
+
+Peers are scored by centrality in the Graph and by how often they've been
+up when we tried them, half each.  Peers we already have a channel with
+are skipped.
+
+The agent only ever closes channels it opened itself; it keeps track of
+those, and of peer uptime, in autopilot.json in the lit folder.
+*/
+
+// Config is everything the agent can be told.  Zero values mean the
+// defaults from DefaultConfig.
+type Config struct {
+	Coin     uint32        // cointype to open channels on
+	Channels int           // number of open channels to keep
+	Outbound int64         // satoshis on our side to keep; 0 to ignore
+	ChanSize int64         // capacity of each channel opened
+	Budget   int64         // most satoshis in open autopilot channels
+	Inactive time.Duration // close channels with no updates for this long
+	Interval time.Duration // how often to check
+}
+
+// DefaultConfig is a small, conservative setup
+func DefaultConfig() Config {
+	return Config{
+		Channels: 3,
+		ChanSize: 2000000,
+		Budget:   6000000,
+		Inactive: 30 * 24 * time.Hour,
+		Interval: 10 * time.Minute,
+	}
+}
+
+const (
+	// leave this much in the wallet for fees, like the fund RPC does
+	walletReserve = 50000
+	// min capacity, same as FundChannel
+	minChanSize = 1000000
+	// give up waiting on a peer to finish funding after this long
+	fundTimeout = 5 * time.Minute
+	// uptime to assume for peers we've never tried
+	unknownUptime = 0.5
+	// most candidates to try dialing per check
+	maxDials = 3
+)
+
+// uptime counts how often a peer was reachable when we looked
+type uptime struct {
+	Tries int
+	Up    int
+}
+
+func (u *uptime) ratio() float64 {
+	if u == nil || u.Tries == 0 {
+		return unknownUptime
+	}
+	return float64(u.Up) / float64(u.Tries)
+}
+
+// activity is the last state number seen on a channel, and when it changed
+type activity struct {
+	StateIdx uint64
+	Since    int64
+}
+
+// agentState is what's kept in autopilot.json
+type agentState struct {
+	Opened   []uint32             // channel indexes we opened
+	Uptime   map[string]*uptime   // by ln address
+	Activity map[uint32]*activity // by channel index
+}
+
+// Agent opens and closes channels for a node
+type Agent struct {
+	Node  *qln.LitNode
+	Cfg   Config
+	Graph Graph
+
+	path  string
+	state agentState
+}
+
+// NewAgent makes an agent for the node, loading any saved state from the
+// node's folder.
+func NewAgent(node *qln.LitNode, cfg Config, graph Graph) (*Agent, error) {
+	def := DefaultConfig()
+	if cfg.Channels == 0 {
+		cfg.Channels = def.Channels
+	}
+	if cfg.ChanSize == 0 {
+		cfg.ChanSize = def.ChanSize
+	}
+	if cfg.Budget == 0 {
+		cfg.Budget = def.Budget
+	}
+	if cfg.Inactive == 0 {
+		cfg.Inactive = def.Inactive
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.ChanSize < minChanSize {
+		return nil, fmt.Errorf("autopilot channel size %d under min %d",
+			cfg.ChanSize, minChanSize)
+	}
+	if cfg.Budget < cfg.ChanSize {
+		return nil, fmt.Errorf("autopilot budget %d can't fit a channel of %d",
+			cfg.Budget, cfg.ChanSize)
+	}
+	if graph == nil {
+		graph = LocalGraph{node}
+	}
+
+	a := &Agent{Node: node, Cfg: cfg, Graph: graph}
+	a.path = filepath.Join(node.LitFolder, "autopilot.json")
+	a.state.Uptime = make(map[string]*uptime)
+	a.state.Activity = make(map[uint32]*activity)
+
+	b, err := ioutil.ReadFile(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &a.state)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", a.path, err.Error())
+		}
+	}
+	return a, nil
+}
+
+// Run checks on the channels every Interval until the node shuts down
+func (a *Agent) Run() {
+	logger.Infof("autopilot on: %d channels of %d, budget %d\n",
+		a.Cfg.Channels, a.Cfg.ChanSize, a.Cfg.Budget)
+	for !a.Node.ShuttingDown() {
+		err := a.check()
+		if err != nil {
+			logger.Errorf("autopilot: %s\n", err.Error())
+		}
+		time.Sleep(a.Cfg.Interval)
+	}
+}
+
+// save writes the agent state out
+func (a *Agent) save() error {
+	b, err := json.MarshalIndent(a.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.path, b, 0600)
+}
+
+func (a *Agent) opened(idx uint32) bool {
+	for _, o := range a.state.Opened {
+		if o == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// check is one pass of the agent
+func (a *Agent) check() error {
+	qcs, err := a.Node.GetAllQchans()
+	if err != nil {
+		return err
+	}
+	peers, err := a.Node.GetAllPeers()
+	if err != nil {
+		return err
+	}
+	byIdx := make(map[uint32]qln.KnownPeer)
+	for _, p := range peers {
+		byIdx[p.Idx] = p
+	}
+
+	now := time.Now()
+	var open []*qln.Qchan
+	for _, q := range qcs {
+		if q.CloseData.Closed || q.Coin() != a.Cfg.Coin {
+			continue
+		}
+		open = append(open, q)
+		if !a.opened(q.Idx()) {
+			continue
+		}
+		p := byIdx[q.Peer()]
+		a.ensureConnected(p)
+
+		act := a.state.Activity[q.Idx()]
+		if act == nil || act.StateIdx != q.State.StateIdx {
+			a.state.Activity[q.Idx()] = &activity{q.State.StateIdx, now.Unix()}
+			continue
+		}
+		if now.Sub(time.Unix(act.Since, 0)) > a.Cfg.Inactive {
+			a.closeInactive(q)
+		}
+	}
+
+	err = a.maybeOpen(open, byIdx)
+	serr := a.save()
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// ensureConnected dials a peer if we're not connected, and notes whether
+// it was up.
+func (a *Agent) ensureConnected(p qln.KnownPeer) bool {
+	adr := lnutil.LitAdrFromPubkey(p.Pub)
+	if a.Node.ConnectedToPeer(p.Idx) {
+		a.noteUp(adr, true)
+		return true
+	}
+	return a.dial(adr, p.Host)
+}
+
+// dial connects to a node and notes whether it worked
+func (a *Agent) dial(adr, host string) bool {
+	if host == "" {
+		return false
+	}
+	err := a.Node.DialPeer(adr + "@" + host)
+	if err != nil {
+		logger.Debugf("autopilot dial %s: %s\n", adr, err.Error())
+	}
+	a.noteUp(adr, err == nil)
+	return err == nil
+}
+
+func (a *Agent) noteUp(adr string, up bool) {
+	u := a.state.Uptime[adr]
+	if u == nil {
+		u = new(uptime)
+		a.state.Uptime[adr] = u
+	}
+	u.Tries++
+	if up {
+		u.Up++
+	}
+}
+
+// closeInactive closes an idle channel, if the peer's around to do it
+// cooperatively.  Breaking it would lock our coins up for a while over
+// what's only idleness, so that's left to the user.
+func (a *Agent) closeInactive(q *qln.Qchan) {
+	if !a.Node.ConnectedToPeer(q.Peer()) {
+		logger.Infof("autopilot: channel %d idle but peer %d offline\n",
+			q.Idx(), q.Peer())
+		return
+	}
+	if spiking, rate := a.Node.FeeSpiking(q.Coin()); spiking {
+		logger.Infof("autopilot: channel %d idle but fee rate %d is high\n",
+			q.Idx(), rate)
+		return
+	}
+	logger.Infof("autopilot: closing channel %d, no updates since %s\n",
+		q.Idx(), time.Unix(a.state.Activity[q.Idx()].Since, 0))
+	err := a.Node.CoopClose(q)
+	if err != nil {
+		logger.Errorf("autopilot: close channel %d: %s\n", q.Idx(), err.Error())
+		return
+	}
+	delete(a.state.Activity, q.Idx())
+}
+
+// maybeOpen opens a channel if we're under target and have the budget
+func (a *Agent) maybeOpen(open []*qln.Qchan, byIdx map[uint32]qln.KnownPeer) error {
+	var outbound, inBudget int64
+	have := make(map[string]bool)
+	for _, q := range open {
+		outbound += q.State.MyAmt
+		if a.opened(q.Idx()) {
+			inBudget += q.Value
+		}
+		have[lnutil.LitAdrFromPubkey(byIdx[q.Peer()].Pub)] = true
+	}
+	if len(open) >= a.Cfg.Channels &&
+		(a.Cfg.Outbound == 0 || outbound >= a.Cfg.Outbound) {
+		return nil
+	}
+	if inBudget+a.Cfg.ChanSize > a.Cfg.Budget {
+		logger.Debugf("autopilot: under target but budget used (%d of %d)\n",
+			inBudget, a.Cfg.Budget)
+		return nil
+	}
+
+	wal, ok := a.Node.SubWallet[a.Cfg.Coin]
+	if !ok {
+		return fmt.Errorf("no wallet of type %d", a.Cfg.Coin)
+	}
+	var utxos portxo.TxoSliceByAmt
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return err
+	}
+	if utxos.SumWitness(wal.CurrentHeight())-walletReserve < a.Cfg.ChanSize {
+		logger.Debugf("autopilot: not enough in wallet for a channel\n")
+		return nil
+	}
+
+	cands, err := a.candidates(have)
+	if err != nil {
+		return err
+	}
+	dials := 0
+	for _, c := range cands {
+		peerIdx, ok := a.connectedIdx(c.Adr)
+		if !ok {
+			if dials >= maxDials {
+				break
+			}
+			dials++
+			if !a.dial(c.Adr, c.Host) {
+				continue
+			}
+			peerIdx, ok = a.connectedIdx(c.Adr)
+			if !ok {
+				continue
+			}
+		}
+		return a.open(peerIdx, c.Adr)
+	}
+	logger.Debugf("autopilot: no peer to open a channel with\n")
+	return nil
+}
+
+// candidate is a node we could open a channel with
+type candidate struct {
+	GraphNode
+	Score float64
+}
+
+// byScore sorts candidates best first
+type byScore []candidate
+
+func (c byScore) Len() int           { return len(c) }
+func (c byScore) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byScore) Less(i, j int) bool { return c[i].Score > c[j].Score }
+
+// candidates returns nodes we don't have channels with, best first
+func (a *Agent) candidates(have map[string]bool) ([]candidate, error) {
+	nodes, err := a.Graph.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	cent := Centrality(nodes)
+
+	var idPub [33]byte
+	copy(idPub[:], a.Node.IdKey().PubKey().SerializeCompressed())
+	me := lnutil.LitAdrFromPubkey(idPub)
+
+	var cands []candidate
+	for _, n := range nodes {
+		if n.Adr == me || have[n.Adr] || !lnutil.LitAdrOK(n.Adr) {
+			continue
+		}
+		score := 0.5*cent[n.Adr] + 0.5*a.state.Uptime[n.Adr].ratio()
+		cands = append(cands, candidate{n, score})
+	}
+	sort.Stable(byScore(cands))
+	return cands, nil
+}
+
+// connectedIdx finds the peer index of a connected node
+func (a *Agent) connectedIdx(adr string) (uint32, bool) {
+	for _, p := range a.Node.GetConnectedPeerList() {
+		pub, _ := a.Node.GetPubHostFromPeerIdx(p.PeerNumber)
+		if lnutil.LitAdrFromPubkey(pub) == adr {
+			return p.PeerNumber, true
+		}
+	}
+	return 0, false
+}
+
+// open funds a channel with a peer, not waiting forever if the peer
+// doesn't answer.
+func (a *Agent) open(peerIdx uint32, adr string) error {
+	logger.Infof("autopilot: opening %d channel with %s\n", a.Cfg.ChanSize, adr)
+
+	type result struct {
+		idx uint32
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		idx, err := a.Node.FundChannel(peerIdx, a.Cfg.Coin, a.Cfg.ChanSize, 0)
+		done <- result{idx, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return r.err
+		}
+		a.state.Opened = append(a.state.Opened, r.idx)
+		logger.Infof("autopilot: opened channel %d with %s\n", r.idx, adr)
+		return nil
+	case <-time.After(fundTimeout):
+		return fmt.Errorf("peer %s didn't finish funding in %s", adr, fundTimeout)
+	}
+}
@@ -0,0 +1,125 @@
+package autopilot
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// GraphNode is a node in the channel graph.  Adr is its ln address, and
+// Channels the addresses of the nodes it has channels with.
+type GraphNode struct {
+	Adr      string   `json:"adr"`
+	Host     string   `json:"host"` // host:port to reach it at, if known
+	Channels []string `json:"channels"`
+}
+
+// Graph is where the agent finds nodes to open channels with.
+type Graph interface {
+	Nodes() ([]GraphNode, error)
+}
+
+// LocalGraph is the graph as this node sees it: every peer we know, and
+// our own channels.  lit doesn't gossip channels, so centrality over this
+// only says which peers we've made the most channels with; for a real view
+// of the network use a FileGraph.
+type LocalGraph struct {
+	Node *qln.LitNode
+}
+
+// Nodes returns our known peers and us
+func (g LocalGraph) Nodes() ([]GraphNode, error) {
+	peers, err := g.Node.GetAllPeers()
+	if err != nil {
+		return nil, err
+	}
+	qcs, err := g.Node.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+
+	var idPub [33]byte
+	copy(idPub[:], g.Node.IdKey().PubKey().SerializeCompressed())
+	me := GraphNode{Adr: lnutil.LitAdrFromPubkey(idPub)}
+
+	adrs := make(map[uint32]string)
+	nodes := make([]GraphNode, 0, len(peers)+1)
+	for _, p := range peers {
+		adr := lnutil.LitAdrFromPubkey(p.Pub)
+		adrs[p.Idx] = adr
+		nodes = append(nodes, GraphNode{Adr: adr, Host: p.Host})
+	}
+	for _, q := range qcs {
+		adr, ok := adrs[q.Peer()]
+		if !ok || q.CloseData.Closed {
+			continue
+		}
+		me.Channels = append(me.Channels, adr)
+	}
+	// edges go both ways
+	for i := range nodes {
+		for _, c := range me.Channels {
+			if c == nodes[i].Adr {
+				nodes[i].Channels = append(nodes[i].Channels, me.Adr)
+			}
+		}
+	}
+	return append(nodes, me), nil
+}
+
+// FileGraph reads the graph from a json file: a list of GraphNodes.  It's
+// re-read every time, so whatever keeps it up to date can just overwrite it.
+type FileGraph struct {
+	Path string
+}
+
+// Nodes reads the file
+func (g FileGraph) Nodes() ([]GraphNode, error) {
+	f, err := os.Open(g.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var nodes []GraphNode
+	err = json.NewDecoder(f).Decode(&nodes)
+	return nodes, err
+}
+
+// Centrality returns the degree centrality of each node by address: the
+// fraction of the other nodes it has channels with.  Channels listed on
+// either end count, and only once.
+func Centrality(nodes []GraphNode) map[string]float64 {
+	edges := make(map[string]map[string]bool)
+	link := func(a, b string) {
+		if a == b {
+			return
+		}
+		if edges[a] == nil {
+			edges[a] = make(map[string]bool)
+		}
+		edges[a][b] = true
+	}
+	for _, n := range nodes {
+		if edges[n.Adr] == nil {
+			edges[n.Adr] = make(map[string]bool)
+		}
+		for _, c := range n.Channels {
+			link(n.Adr, c)
+			link(c, n.Adr)
+		}
+	}
+
+	cent := make(map[string]float64)
+	if len(edges) < 2 {
+		for adr := range edges {
+			cent[adr] = 0
+		}
+		return cent
+	}
+	for adr, e := range edges {
+		cent[adr] = float64(len(e)) / float64(len(edges)-1)
+	}
+	return cent
+}
@@ -0,0 +1,6 @@
+package autopilot
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the autopilot agent; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("autopilot")
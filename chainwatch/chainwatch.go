@@ -0,0 +1,378 @@
+/*
+Package chainwatch tells the rest of lit about chain events it's asked
+about, so the wallet, channels, swaps, the tower client and the like don't
+each keep their own watch on the chain.
+
+Each wallet has a Notifier, fed the txs its chain hook finds and the
+heights it syncs to.  Subsystems subscribe:
+
+  - WatchSpend: an outpoint's been spent
+  - WatchScript: a script's been paid
+  - WatchHeight: the chain's reached a height
+  - WatchBlocks: every new height
+
+each with the confirmations wanted; 0 means as soon as the tx is seen.
+Events come on the Sub's channel.  Spends and payments are sent once per
+tx.  If a reorg takes out a tx that's been sent, a ReorgEvent follows for
+it, and once it's confirmed again it's sent again.
+
+Subscribing registers the outpoint or script with the chain hook, so
+matching txs get found.  Only txs seen after that are matched; if a spend
+might already have happened, check the wallet too.
+*/
+package chainwatch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// EventKind says what a chain event is
+type EventKind uint8
+
+const (
+	SpendEvent   EventKind = iota // a watched outpoint was spent
+	ReceiveEvent                  // a watched script was paid
+	HeightEvent                   // the chain reached a height
+	ReorgEvent                    // a spend or payment sent before was reorged out
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case SpendEvent:
+		return "spend"
+	case ReceiveEvent:
+		return "receive"
+	case HeightEvent:
+		return "height"
+	case ReorgEvent:
+		return "reorg"
+	}
+	return fmt.Sprintf("kind %d", k)
+}
+
+// Event is something that happened on chain
+type Event struct {
+	Kind EventKind
+	// for spends the outpoint spent, for payments the one paying the script
+	Op     wire.OutPoint
+	Tx     *wire.MsgTx // the spending or paying tx; nil for heights
+	Height int32       // block the tx is in, 0 if unconfirmed; or the height reached
+	Confs  int32
+}
+
+// a match is a tx a spend or script sub is interested in
+type match struct {
+	sub    *Sub
+	txid   chainhash.Hash
+	tx     *wire.MsgTx
+	op     wire.OutPoint
+	height int32 // 0 unconfirmed
+	sent   bool
+}
+
+// keep matches this deep after they're sent, in case of reorgs
+const pruneDepth = 144
+
+// Registrar is what a Notifier needs from the chain hook: a way to ask
+// for txs about addresses and outpoints
+type Registrar interface {
+	RegisterAddress(address [20]byte) error
+	RegisterOutPoint(wire.OutPoint) error
+}
+
+// Notifier matches txs and heights against subscriptions
+type Notifier struct {
+	mtx    sync.Mutex
+	reg    Registrar
+	height int32
+
+	spends  map[wire.OutPoint][]*Sub
+	scripts map[string][]*Sub
+	heights []*Sub // WatchHeight subs, which go once
+	blocks  []*Sub
+
+	matches []*match
+}
+
+// New makes a Notifier starting at height.  reg can be nil, for tests or
+// hooks that don't need telling.
+func New(reg Registrar, height int32) *Notifier {
+	n := new(Notifier)
+	n.reg = reg
+	n.height = height
+	n.spends = make(map[wire.OutPoint][]*Sub)
+	n.scripts = make(map[string][]*Sub)
+	return n
+}
+
+// Height is the last height the Notifier was told about
+func (n *Notifier) Height() int32 {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.height
+}
+
+// Sub is a subscription.  Read Events until done with it, then Cancel.
+type Sub struct {
+	Events chan Event
+
+	n      *Notifier
+	kind   EventKind
+	op     wire.OutPoint
+	script string
+	height int32
+	blocks bool
+	confs  int32
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (n *Notifier) newSub(kind EventKind, confs int32) *Sub {
+	if confs < 0 {
+		confs = 0
+	}
+	return &Sub{Events: make(chan Event, 16), n: n, kind: kind, confs: confs,
+		done: make(chan struct{})}
+}
+
+// WatchSpend sends a SpendEvent when op is spent, with confs confirmations
+func (n *Notifier) WatchSpend(op wire.OutPoint, confs int32) (*Sub, error) {
+	if n.reg != nil {
+		err := n.reg.RegisterOutPoint(op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := n.newSub(SpendEvent, confs)
+	s.op = op
+	n.mtx.Lock()
+	n.spends[op] = append(n.spends[op], s)
+	n.mtx.Unlock()
+	return s, nil
+}
+
+// WatchScript sends a ReceiveEvent for each output paying pkScript, with
+// confs confirmations.  Only p2pkh, p2wpkh and p2wsh scripts can be found
+// by the chain hook.
+func (n *Notifier) WatchScript(pkScript []byte, confs int32) (*Sub, error) {
+	kh := lnutil.KeyHashFromPkScript(pkScript)
+	if kh == nil {
+		return nil, fmt.Errorf("can't watch script %x", pkScript)
+	}
+	if n.reg != nil {
+		// the hook matches on 20 bytes; the start of a p2wsh hash will do
+		var adr [20]byte
+		copy(adr[:], kh)
+		err := n.reg.RegisterAddress(adr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := n.newSub(ReceiveEvent, confs)
+	s.script = string(pkScript)
+	n.mtx.Lock()
+	n.scripts[s.script] = append(n.scripts[s.script], s)
+	n.mtx.Unlock()
+	return s, nil
+}
+
+// WatchHeight sends one HeightEvent when the chain reaches height; right
+// away if it already has
+func (n *Notifier) WatchHeight(height int32) *Sub {
+	s := n.newSub(HeightEvent, 0)
+	s.height = height
+	n.mtx.Lock()
+	if n.height >= height {
+		n.mtx.Unlock()
+		s.send(Event{Kind: HeightEvent, Height: n.Height()})
+		return s
+	}
+	n.heights = append(n.heights, s)
+	n.mtx.Unlock()
+	return s
+}
+
+// WatchBlocks sends a HeightEvent for each new height
+func (n *Notifier) WatchBlocks() *Sub {
+	s := n.newSub(HeightEvent, 0)
+	s.blocks = true
+	n.mtx.Lock()
+	n.blocks = append(n.blocks, s)
+	n.mtx.Unlock()
+	return s
+}
+
+// Cancel stops a subscription.  Events already queued may still be read.
+func (s *Sub) Cancel() {
+	s.doneOnce.Do(func() { close(s.done) })
+	n := s.n
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	switch {
+	case s.kind == SpendEvent:
+		n.spends[s.op] = dropSub(n.spends[s.op], s)
+		if len(n.spends[s.op]) == 0 {
+			delete(n.spends, s.op)
+		}
+	case s.kind == ReceiveEvent:
+		n.scripts[s.script] = dropSub(n.scripts[s.script], s)
+		if len(n.scripts[s.script]) == 0 {
+			delete(n.scripts, s.script)
+		}
+	case s.blocks:
+		n.blocks = dropSub(n.blocks, s)
+	default:
+		n.heights = dropSub(n.heights, s)
+	}
+	kept := n.matches[:0]
+	for _, m := range n.matches {
+		if m.sub != s {
+			kept = append(kept, m)
+		}
+	}
+	n.matches = kept
+}
+
+func dropSub(subs []*Sub, s *Sub) []*Sub {
+	for i, x := range subs {
+		if x == s {
+			return append(subs[:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// send blocks until the event's read or the sub's cancelled
+func (s *Sub) send(ev Event) {
+	select {
+	case s.Events <- ev:
+	case <-s.done:
+	}
+}
+
+type delivery struct {
+	sub *Sub
+	ev  Event
+}
+
+// AddTxs tells the Notifier about txs at a height, 0 for unconfirmed
+func (n *Notifier) AddTxs(txs []*wire.MsgTx, height int32) {
+	n.mtx.Lock()
+	for _, tx := range txs {
+		txid := tx.TxHash()
+		for _, in := range tx.TxIn {
+			for _, s := range n.spends[in.PreviousOutPoint] {
+				n.addMatch(s, txid, tx, in.PreviousOutPoint, height)
+			}
+		}
+		for i, out := range tx.TxOut {
+			for _, s := range n.scripts[string(out.PkScript)] {
+				n.addMatch(s, txid, tx, wire.OutPoint{Hash: txid, Index: uint32(i)}, height)
+			}
+		}
+	}
+	ds := n.due()
+	n.mtx.Unlock()
+	deliver(ds)
+}
+
+// addMatch adds a match, or updates the height of one already there
+func (n *Notifier) addMatch(
+	s *Sub, txid chainhash.Hash, tx *wire.MsgTx, op wire.OutPoint, height int32) {
+	for _, m := range n.matches {
+		if m.sub == s && m.txid == txid && m.op == op {
+			if height != 0 {
+				m.height = height
+			}
+			return
+		}
+	}
+	n.matches = append(n.matches,
+		&match{sub: s, txid: txid, tx: tx, op: op, height: height})
+}
+
+// SetHeight tells the Notifier the chain's synced to height.  A lower
+// height than before is a reorg back to it.
+func (n *Notifier) SetHeight(height int32) {
+	n.mtx.Lock()
+	var ds []delivery
+	if height < n.height {
+		for _, m := range n.matches {
+			if m.height <= height {
+				continue
+			}
+			if m.sent {
+				ds = append(ds, delivery{m.sub, Event{Kind: ReorgEvent,
+					Op: m.op, Tx: m.tx, Height: m.height}})
+				m.sent = false
+			}
+			m.height = 0
+		}
+	}
+	if height > n.height {
+		for _, s := range n.blocks {
+			ds = append(ds, delivery{s, Event{Kind: HeightEvent, Height: height}})
+		}
+	}
+	n.height = height
+
+	waiting := n.heights[:0]
+	for _, s := range n.heights {
+		if height >= s.height {
+			ds = append(ds, delivery{s, Event{Kind: HeightEvent, Height: height}})
+		} else {
+			waiting = append(waiting, s)
+		}
+	}
+	n.heights = waiting
+
+	ds = append(ds, n.due()...)
+	n.mtx.Unlock()
+	deliver(ds)
+}
+
+// confs is how many confirmations a tx at height has
+func (n *Notifier) confs(height int32) int32 {
+	if height == 0 {
+		return 0
+	}
+	if height > n.height {
+		return 1
+	}
+	return n.height - height + 1
+}
+
+// due marks matches with enough confirmations sent, prunes deep ones, and
+// returns what to send.  Call with mtx held.
+func (n *Notifier) due() []delivery {
+	var ds []delivery
+	kept := n.matches[:0]
+	for _, m := range n.matches {
+		c := n.confs(m.height)
+		if !m.sent && c >= m.sub.confs {
+			m.sent = true
+			ds = append(ds, delivery{m.sub, Event{Kind: m.sub.kind,
+				Op: m.op, Tx: m.tx, Height: m.height, Confs: c}})
+		}
+		if m.sent && c > pruneDepth {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	n.matches = kept
+	return ds
+}
+
+// deliver sends events, without the lock held, so a slow reader only
+// holds up the chain hook
+func deliver(ds []delivery) {
+	for _, d := range ds {
+		d.sub.send(d.ev)
+	}
+}
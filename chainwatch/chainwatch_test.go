@@ -0,0 +1,206 @@
+package chainwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+type testReg struct {
+	adrs []([20]byte)
+	ops  []wire.OutPoint
+}
+
+func (r *testReg) RegisterAddress(a [20]byte) error {
+	r.adrs = append(r.adrs, a)
+	return nil
+}
+
+func (r *testReg) RegisterOutPoint(op wire.OutPoint) error {
+	r.ops = append(r.ops, op)
+	return nil
+}
+
+func testOp(b byte) wire.OutPoint {
+	var h chainhash.Hash
+	h[0] = b
+	return wire.OutPoint{Hash: h, Index: 1}
+}
+
+func p2wsh(b byte) []byte {
+	s := make([]byte, 34)
+	s[1] = 0x20
+	s[2] = b
+	return s
+}
+
+// spendTx spends op and pays script
+func spendTx(op wire.OutPoint, script []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&op, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(5000, script))
+	return tx
+}
+
+func expect(t *testing.T, s *Sub, kind EventKind, confs int32) Event {
+	select {
+	case ev := <-s.Events:
+		if ev.Kind != kind || ev.Confs != confs {
+			t.Fatalf("got %s event with %d confs, expect %s with %d",
+				ev.Kind, ev.Confs, kind, confs)
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event", kind)
+	}
+	return Event{}
+}
+
+func expectNothing(t *testing.T, s *Sub) {
+	select {
+	case ev := <-s.Events:
+		t.Fatalf("unexpected %s event at %d", ev.Kind, ev.Height)
+	default:
+	}
+}
+
+func TestSpend(t *testing.T) {
+	reg := new(testReg)
+	n := New(reg, 100)
+	op := testOp(1)
+	s, err := n.WatchSpend(op, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.ops) != 1 || reg.ops[0] != op {
+		t.Fatalf("outpoint not registered: %v", reg.ops)
+	}
+
+	tx := spendTx(op, p2wsh(9))
+	n.AddTxs([]*wire.MsgTx{tx}, 0)
+	expectNothing(t, s)
+	n.AddTxs([]*wire.MsgTx{tx}, 101)
+	n.SetHeight(101)
+	n.SetHeight(102)
+	expectNothing(t, s)
+	n.SetHeight(103)
+	ev := expect(t, s, SpendEvent, 3)
+	if ev.Op != op || ev.Height != 101 || ev.Tx.TxHash() != tx.TxHash() {
+		t.Fatalf("got %s at %d", ev.Op.String(), ev.Height)
+	}
+	// only once
+	n.SetHeight(104)
+	n.AddTxs([]*wire.MsgTx{tx}, 101)
+	expectNothing(t, s)
+
+	// unrelated txs don't match
+	n.AddTxs([]*wire.MsgTx{spendTx(testOp(2), p2wsh(9))}, 104)
+	expectNothing(t, s)
+}
+
+func TestScript(t *testing.T) {
+	reg := new(testReg)
+	n := New(reg, 10)
+	script := p2wsh(7)
+	s, err := n.WatchScript(script, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.adrs) != 1 || reg.adrs[0][0] != 7 {
+		t.Fatalf("script not registered: %x", reg.adrs)
+	}
+	tx := spendTx(testOp(3), script)
+	n.AddTxs([]*wire.MsgTx{tx}, 0)
+	ev := expect(t, s, ReceiveEvent, 0)
+	if ev.Op.Hash != tx.TxHash() || ev.Op.Index != 0 {
+		t.Fatalf("got %s", ev.Op.String())
+	}
+
+	_, err = n.WatchScript([]byte{0x6a, 0x01, 0x01}, 0)
+	if err == nil {
+		t.Fatalf("op_return script should error")
+	}
+}
+
+func TestReorg(t *testing.T) {
+	n := New(nil, 200)
+	op := testOp(4)
+	s, _ := n.WatchSpend(op, 1)
+	tx := spendTx(op, p2wsh(1))
+	n.AddTxs([]*wire.MsgTx{tx}, 201)
+	n.SetHeight(201)
+	expect(t, s, SpendEvent, 1)
+
+	// back to 200 takes it out
+	n.SetHeight(200)
+	expect(t, s, ReorgEvent, 0)
+
+	// and back in on the new chain
+	n.AddTxs([]*wire.MsgTx{tx}, 202)
+	n.SetHeight(202)
+	ev := expect(t, s, SpendEvent, 1)
+	if ev.Height != 202 {
+		t.Fatalf("got height %d, expect 202", ev.Height)
+	}
+}
+
+func TestHeights(t *testing.T) {
+	n := New(nil, 50)
+	past := n.WatchHeight(40)
+	expect(t, past, HeightEvent, 0)
+
+	h := n.WatchHeight(52)
+	b := n.WatchBlocks()
+	n.SetHeight(51)
+	expectNothing(t, h)
+	if ev := expect(t, b, HeightEvent, 0); ev.Height != 51 {
+		t.Fatalf("got height %d", ev.Height)
+	}
+	n.SetHeight(52)
+	expect(t, h, HeightEvent, 0)
+	expect(t, b, HeightEvent, 0)
+	n.SetHeight(53)
+	expectNothing(t, h)
+	expect(t, b, HeightEvent, 0)
+
+	b.Cancel()
+	n.SetHeight(54)
+	expectNothing(t, b)
+	if len(n.blocks) != 0 {
+		t.Fatalf("%d block subs after cancel", len(n.blocks))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	n := New(nil, 1)
+	op := testOp(5)
+	s, _ := n.WatchSpend(op, 0)
+	s.Cancel()
+	n.AddTxs([]*wire.MsgTx{spendTx(op, p2wsh(1))}, 0)
+	expectNothing(t, s)
+	if len(n.spends) != 0 || len(n.matches) != 0 {
+		t.Fatalf("sub left after cancel")
+	}
+}
+
+// a full queue doesn't block the notifier once the sub's cancelled
+func TestCancelUnblocks(t *testing.T) {
+	n := New(nil, 1)
+	b := n.WatchBlocks()
+	done := make(chan bool)
+	go func() {
+		for h := int32(2); h < 40; h++ {
+			n.SetHeight(h)
+		}
+		done <- true
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("notifier stuck on a cancelled sub")
+	}
+}
@@ -0,0 +1,58 @@
+package cloudbak
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// execTarget hands files to a command, for anywhere else backups should go
+type execTarget struct {
+	args []string
+}
+
+func newExecTarget(cmd string) (*execTarget, error) {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("exec backup target needs a command")
+	}
+	return &execTarget{args: args}, nil
+}
+
+func (e *execTarget) run(stdin []byte, extra ...string) ([]byte, error) {
+	args := append(append([]string{}, e.args[1:]...), extra...)
+	cmd := exec.Command(e.args[0], args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s %s", e.args[0],
+			strings.Join(extra, " "), err.Error(),
+			strings.TrimSpace(errOut.String()))
+	}
+	return out.Bytes(), nil
+}
+
+func (e *execTarget) Put(name string, data []byte) error {
+	_, err := e.run(data, "put", name)
+	return err
+}
+
+// Get takes empty output to mean the file isn't there
+func (e *execTarget) Get(name string) ([]byte, error) {
+	out, err := e.run(nil, "get", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+func (e *execTarget) String() string {
+	return "exec " + e.args[0]
+}
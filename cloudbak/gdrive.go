@@ -0,0 +1,185 @@
+package cloudbak
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// driveTarget is a Google Drive folder.  Files are found by name in the
+// folder; putting one that's there already replaces its contents.
+type driveTarget struct {
+	folder                    string
+	clientID, secret, refresh string
+
+	// so tests can point it elsewhere
+	tokenURL, apiURL, uploadURL string
+
+	mtx     sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newDriveTarget(folder, clientID, secret, refresh string) (*driveTarget, error) {
+	if folder == "" {
+		return nil, fmt.Errorf("gdrive backup target needs a folder id")
+	}
+	if clientID == "" || secret == "" || refresh == "" {
+		return nil, fmt.Errorf("gdrive backups need LIT_GDRIVE_CLIENT_ID, " +
+			"LIT_GDRIVE_CLIENT_SECRET and LIT_GDRIVE_REFRESH_TOKEN")
+	}
+	return &driveTarget{
+		folder:    folder,
+		clientID:  clientID,
+		secret:    secret,
+		refresh:   refresh,
+		tokenURL:  "https://oauth2.googleapis.com/token",
+		apiURL:    "https://www.googleapis.com/drive/v3",
+		uploadURL: "https://www.googleapis.com/upload/drive/v3",
+	}, nil
+}
+
+// accessToken gets a fresh access token from the refresh token, when the
+// last one is about to run out
+func (d *driveTarget) accessToken() (string, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.token != "" && time.Now().Before(d.expires) {
+		return d.token, nil
+	}
+	form := url.Values{
+		"client_id":     {d.clientID},
+		"client_secret": {d.secret},
+		"refresh_token": {d.refresh},
+		"grant_type":    {"refresh_token"},
+	}
+	req, err := http.NewRequest("POST", d.tokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := do(req)
+	if err != nil {
+		return "", err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &tok)
+	if err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("no access token from google")
+	}
+	d.token = tok.AccessToken
+	// a minute early, so it doesn't run out mid request
+	d.expires = time.Now().Add(time.Duration(tok.ExpiresIn-60) * time.Second)
+	return d.token, nil
+}
+
+// request sends an authorized request to the drive api
+func (d *driveTarget) request(method, u, contentType string,
+	body []byte) ([]byte, error) {
+	tok, err := d.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return do(req)
+}
+
+// fileID finds a file in the folder by name; "" if it isn't there
+func (d *driveTarget) fileID(name string) (string, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false",
+		strings.Replace(name, "'", "\\'", -1), d.folder)
+	u := d.apiURL + "/files?fields=files(id)&q=" + url.QueryEscape(q)
+	body, err := d.request("GET", u, "", nil)
+	if err != nil {
+		return "", err
+	}
+	var list struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return "", err
+	}
+	if len(list.Files) == 0 {
+		return "", nil
+	}
+	return list.Files[0].ID, nil
+}
+
+func (d *driveTarget) Put(name string, data []byte) error {
+	id, err := d.fileID(name)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = d.request("PATCH",
+			d.uploadURL+"/files/"+id+"?uploadType=media",
+			"application/octet-stream", data)
+		return err
+	}
+	// new file: metadata and contents together
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	meta, err := json.Marshal(map[string]interface{}{
+		"name": name, "parents": []string{d.folder}})
+	if err != nil {
+		return err
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return err
+	}
+	part.Write(meta)
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	err = mw.Close()
+	if err != nil {
+		return err
+	}
+	_, err = d.request("POST", d.uploadURL+"/files?uploadType=multipart",
+		"multipart/related; boundary="+mw.Boundary(), buf.Bytes())
+	return err
+}
+
+func (d *driveTarget) Get(name string) ([]byte, error) {
+	id, err := d.fileID(name)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	return d.request("GET", d.apiURL+"/files/"+id+"?alt=media", "", nil)
+}
+
+func (d *driveTarget) String() string {
+	return "gdrive " + d.folder
+}
@@ -0,0 +1,156 @@
+package cloudbak
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Target is a bucket and key prefix on S3 or anything that speaks its
+// API, with requests signed by AWS signature version 4
+type s3Target struct {
+	bucket, prefix string
+	region         string
+	endpoint       string // scheme://host for path style; "" for AWS
+	accessKey      string
+	secretKey      string
+}
+
+func newS3Target(u *url.URL, accessKey, secretKey string) (*s3Target, error) {
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf(
+			"s3 backups need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	s := &s3Target{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    u.Query().Get("region"),
+		endpoint:  strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+		accessKey: accessKey,
+		secretKey: secretKey,
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("s3 backup target needs a bucket")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	return s, nil
+}
+
+func (s *s3Target) objectURL(name string) string {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s.bucket + "/" + key
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s",
+		s.bucket, s.region, key)
+}
+
+func (s *s3Target) Put(name string, data []byte) error {
+	req, err := http.NewRequest("PUT", s.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, data, time.Now())
+	_, err = do(req)
+	return err
+}
+
+func (s *s3Target) Get(name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil, time.Now())
+	return do(req)
+}
+
+func (s *s3Target) String() string {
+	return fmt.Sprintf("s3 %s/%s", s.bucket, s.prefix)
+}
+
+// sign adds the x-amz headers and Authorization to a request.  Every
+// header already set is signed, along with host.
+func (s *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	reqHash := sha256.Sum256([]byte(canonReq))
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hmacSHA256(key, toSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// awsEscapePath escapes everything but unreserved characters and slashes
+func awsEscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' ||
+			c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,52 @@
+package cloudbak
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// davTarget is a WebDAV folder.  Only PUT and GET are used, so any http
+// server that takes PUTs works too.
+type davTarget struct {
+	base *url.URL // folder, with any user:pass
+}
+
+func newDavTarget(u *url.URL) *davTarget {
+	base := *u
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return &davTarget{base: &base}
+}
+
+func (d *davTarget) fileURL(name string) string {
+	u := *d.base
+	u.Path += name
+	return u.String()
+}
+
+func (d *davTarget) Put(name string, data []byte) error {
+	req, err := http.NewRequest("PUT", d.fileURL(name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	_, err = do(req)
+	return err
+}
+
+func (d *davTarget) Get(name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", d.fileURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	return do(req)
+}
+
+func (d *davTarget) String() string {
+	u := *d.base
+	u.User = nil
+	return "webdav " + u.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var auditCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("audit"),
+		lnutil.OptColor("method", "count")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show the latest RPC calls from the audit log, 20 unless count says.",
+		"With a method, only calls to methods with that in their name.",
+		"Needs lit started with rpcaudit."),
+	ShortDescription: "Show RPC calls from the audit log.\n",
+}
+
+func (lc *litAfClient) Audit(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, auditCommand.Format)
+		fmt.Fprintf(color.Output, auditCommand.Description)
+		return nil
+	}
+	args := new(litrpc.AuditLogArgs)
+	args.Max = 20
+	if len(textArgs) > 0 {
+		args.Method = textArgs[0]
+	}
+	if len(textArgs) > 1 {
+		max, err := strconv.ParseUint(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.Max = uint32(max)
+	}
+	reply := new(litrpc.AuditLogReply)
+	err := lc.rpccon.Call("LitRPC.AuditLog", args, reply)
+	if err != nil {
+		return err
+	}
+	for _, e := range reply.Entries {
+		status := lnutil.Green("ok")
+		if !e.OK {
+			status = lnutil.Red(e.Err)
+		}
+		who := e.Caller
+		if e.Account != "" {
+			who += " account " + e.Account
+		}
+		fmt.Fprintf(color.Output, "%s %s %s (%s, %dms) %s\n",
+			time.Unix(0, e.Time).Format("2006-01-02 15:04:05"),
+			lnutil.Header(e.Method), string(e.Params), who, e.Millis, status)
+	}
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 func (lc *litAfClient) completePeers(line string) []string {
 	names := make([]string, 0)
 	pReply := new(litrpc.ListConnectionsReply)
-	err := lc.rpccon.Call("LitRPC.ListConnections", nil, pReply)
+	err := lc.rawcon.Call("LitRPC.ListConnections", nil, pReply)
 	if err != nil {
 		return names
 	}
@@ -27,11 +27,11 @@ func (lc *litAfClient) completeClosedPeers(line string) []string {
 	connectedpeers := make([]string, 0)
 	pReply := new(litrpc.ListConnectionsReply)
 	cReply := new(litrpc.ChannelListReply)
-	err := lc.rpccon.Call("LitRPC.ListConnections", nil, pReply)
+	err := lc.rawcon.Call("LitRPC.ListConnections", nil, pReply)
 	if err != nil {
 		return channelpeers
 	}
-	err = lc.rpccon.Call("LitRPC.ChannelList", nil, cReply)
+	err = lc.rawcon.Call("LitRPC.ChannelList", nil, cReply)
 	if err != nil {
 		return channelpeers
 	}
@@ -60,7 +60,7 @@ func (lc *litAfClient) completeClosedPeers(line string) []string {
 func (lc *litAfClient) completeChannelIdx(line string) []string {
 	names := make([]string, 0)
 	cReply := new(litrpc.ChannelListReply)
-	err := lc.rpccon.Call("LitRPC.ChannelList", nil, cReply)
+	err := lc.rawcon.Call("LitRPC.ChannelList", nil, cReply)
 	if err != nil {
 		return names
 	}
@@ -78,38 +78,163 @@ func (lc *litAfClient) NewAutoCompleter() readline.AutoCompleter {
 	var completer = readline.NewPrefixCompleter(
 		readline.PcItem("help",
 			readline.PcItem("say"),
+			readline.PcItem("custom"),
+			readline.PcItem("towers"),
+			readline.PcItem("toweradmin"),
+			readline.PcItem("towerreport"),
+			readline.PcItem("mytowers"),
 			readline.PcItem("ls"),
+			readline.PcItem("peerreport"),
 			readline.PcItem("con"),
 			readline.PcItem("lis"),
 			readline.PcItem("adr"),
 			readline.PcItem("send"),
 			readline.PcItem("fan"),
 			readline.PcItem("sweep"),
+			readline.PcItem("timelock"),
+			readline.PcItem("reserves"),
 			readline.PcItem("fund"),
+			readline.PcItem("fundext"),
+			readline.PcItem("dryfund"),
+			readline.PcItem("bumpfund"),
+			readline.PcItem("inbound"),
+			readline.PcItem("lease"),
 			readline.PcItem("push"),
+			readline.PcItem("pay"),
+			readline.PcItem("track"),
+			readline.PcItem("invoice"),
 			readline.PcItem("close"),
 			readline.PcItem("break"),
+			readline.PcItem("askbreak"),
+			readline.PcItem("deferred"),
+			readline.PcItem("sweeptargets"),
+			readline.PcItem("sweepkey"),
+			readline.PcItem("heir"),
+			readline.PcItem("commit"),
+			readline.PcItem("arbexport"),
+			readline.PcItem("replay"),
+			readline.PcItem("chanops"),
+			readline.PcItem("recoverkeys"),
+			readline.PcItem("checkdb"),
+			readline.PcItem("checkpoints"),
+			readline.PcItem("rollback"),
+			readline.PcItem("watch"),
+			readline.PcItem("policy"),
+			readline.PcItem("approve"),
+			readline.PcItem("audit"),
+			readline.PcItem("qr"),
+			readline.PcItem("fee"),
+			readline.PcItem("broadcasts"),
+			readline.PcItem("off"),
 			readline.PcItem("stop"),
 			readline.PcItem("exit"),
 		),
 		readline.PcItem("say",
 			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("custom",
+			readline.PcItem("watch"),
+			readline.PcItemDynamic(lc.completePeers)),
 		readline.PcItem("ls"),
+		readline.PcItem("towers"),
+		readline.PcItem("towerreport"),
+		readline.PcItem("peerreport"),
+		readline.PcItem("mytowers",
+			readline.PcItem("add"),
+			readline.PcItem("rm"),
+			readline.PcItem("k")),
+		readline.PcItem("toweradmin",
+			readline.PcItem("propose",
+				readline.PcItem("drop"),
+				readline.PcItem("restore"),
+				readline.PcItem("quota"),
+				readline.PcItem("policy")),
+			readline.PcItem("approve"),
+			readline.PcItem("sign")),
 		readline.PcItem("con",
 			readline.PcItemDynamic(lc.completeClosedPeers)),
-		readline.PcItem("lis"),
-		readline.PcItem("adr"),
+		readline.PcItem("lis",
+			readline.PcItem("--qr")),
+		readline.PcItem("adr",
+			readline.PcItem("--type",
+				readline.PcItem("bech32"),
+				readline.PcItem("legacy")),
+			readline.PcItem("--account"),
+			readline.PcItem("--qr")),
 		readline.PcItem("send"),
 		readline.PcItem("fan"),
 		readline.PcItem("sweep"),
+		readline.PcItem("timelock"),
+		readline.PcItem("reserves",
+			readline.PcItem("verify")),
 		readline.PcItem("fund",
 			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("dryfund",
+			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("fundext",
+			readline.PcItem("verify"),
+			readline.PcItem("finish"),
+			readline.PcItem("cancel"),
+			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("bumpfund",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("inbound",
+			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("lease",
+			readline.PcItem("buy",
+				readline.PcItemDynamic(lc.completePeers))),
 		readline.PcItem("push",
 			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("pay",
+			readline.PcItem("--key"),
+			readline.PcItem("--async")),
+		readline.PcItem("track"),
+		readline.PcItem("invoice",
+			readline.PcItem("--fiat"),
+			readline.PcItem("--meta")),
 		readline.PcItem("close",
 			readline.PcItemDynamic(lc.completeChannelIdx)),
 		readline.PcItem("break",
 			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("askbreak",
+			readline.PcItem("backup"),
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("deferred",
+			readline.PcItem("run"),
+			readline.PcItem("cancel")),
+		readline.PcItem("sweeptargets",
+			readline.PcItem("justice"),
+			readline.PcItem("htlc"),
+			readline.PcItem("tolocal"),
+			readline.PcItem("consolidate")),
+		readline.PcItem("sweepkey"),
+		readline.PcItem("heir",
+			readline.PcItem("set"),
+			readline.PcItem("clear"),
+			readline.PcItem("checkin"),
+			readline.PcItem("export")),
+		readline.PcItem("commit",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("arbexport",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("replay",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("chanops",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("recoverkeys"),
+		readline.PcItem("checkdb",
+			readline.PcItem("repair")),
+		readline.PcItem("checkpoints"),
+		readline.PcItem("rollback"),
+		readline.PcItem("watch"),
+		readline.PcItem("policy"),
+		readline.PcItem("approve"),
+		readline.PcItem("audit"),
+		readline.PcItem("qr"),
+		readline.PcItem("fee"),
+		readline.PcItem("broadcasts",
+			readline.PcItem("drop")),
+		readline.PcItem("dump"),
+		readline.PcItem("off"),
 		readline.PcItem("stop"),
 		readline.PcItem("exit"),
 	)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var broadcastsCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.White("broadcasts"),
+		lnutil.OptColor("drop"), lnutil.OptColor("txid"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"List the txs lit's sent that aren't buried yet.  They're sent again",
+		"until they're in a block; evicted ones seem to have dropped out of",
+		"mempools.  \"drop txid\" stops sending one."),
+	ShortDescription: "List sent txs waiting to confirm, or stop sending one.\n",
+}
+
+func (lc *litAfClient) Broadcasts(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, broadcastsCommand.Format)
+		fmt.Fprintf(color.Output, broadcastsCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) > 0 {
+		if len(textArgs) < 2 || textArgs[0] != "drop" {
+			return fmt.Errorf(broadcastsCommand.Format)
+		}
+		args := litrpc.DropBroadcastArgs{Txid: textArgs[1]}
+		if len(textArgs) > 2 {
+			coin, err := strconv.ParseUint(textArgs[2], 10, 32)
+			if err != nil {
+				return err
+			}
+			args.CoinType = uint32(coin)
+		}
+		reply := new(litrpc.StatusReply)
+		err := lc.rpccon.Call("LitRPC.DropBroadcast", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	reply := new(litrpc.ListBroadcastsReply)
+	err := lc.rpccon.Call("LitRPC.ListBroadcasts", nil, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Txs) == 0 {
+		fmt.Fprintf(color.Output, "nothing waiting\n")
+	}
+	for _, tx := range reply.Txs {
+		fmt.Fprintf(color.Output, "%s (coin %d) %s, sent %d times since %s\n",
+			lnutil.White(tx.Txid), tx.CoinType, tx.State, tx.Sends,
+			time.Unix(tx.FirstSent, 0).Format(time.RFC3339))
+		if tx.Height != 0 {
+			fmt.Fprintf(color.Output, "\tblock %d\n", tx.Height)
+		}
+		if tx.Conflict != "" {
+			fmt.Fprintf(color.Output, "\tdouble spent by %s\n", tx.Conflict)
+		}
+	}
+	return nil
+}
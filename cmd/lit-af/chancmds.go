@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
 )
 
 var fundCommand = &Command{
@@ -19,19 +25,64 @@ var fundCommand = &Command{
 	ShortDescription: "Establish and fund a new lightning channel with the given peer.\n",
 }
 
+var dryFundCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dryfund"),
+		lnutil.ReqColor("peer", "coinType", "capacity", "initialSend")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Check whether fund would go through with these arguments, without",
+		"opening anything: the peer, its features, coins and fee, each side's",
+		"reserve and the spend policy.  Lists every check and how it went."),
+	ShortDescription: "Check a channel could be funded, without funding it.\n",
+}
+
+var fundExtCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("fundext"),
+		lnutil.ReqColor("peer|verify|finish|cancel", "...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Fund a channel from a tx made by another wallet.",
+		"  fundext peer coinType capacity initialSend  -- gives the address & amount to pay",
+		"  fundext verify tx  -- the unsigned funding tx or PSBT (hex or base64)",
+		"  fundext finish tx  -- the signed tx, once verify has gone through; broadcasts it",
+		"  fundext cancel"),
+	ShortDescription: "Fund a channel from a tx made by another wallet.\n",
+}
+
+var bumpFundCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("bumpfund"),
+		lnutil.ReqColor("channel idx", "sat/byte"), lnutil.OptColor("abort")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Replace the funding tx of a channel that hasn't confirmed with one",
+		"paying the given fee rate.  The peer has to be connected to sign for it.",
+		"With abort, give up on the channel instead, double spending the",
+		"funding tx back to the wallet at that fee rate."),
+	ShortDescription: "Bump the fee of, or abort, a stuck funding tx.\n",
+}
+
+var inboundCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("inbound"),
+		lnutil.ReqColor("peer", "coinType", "capacity")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Ask a peer to fund a channel of capacity to us, for inbound capacity.",
+		"Peers that will say so, and how big, in ls; if the peer agrees the",
+		"channel opens as if it had run fund, with nothing pushed."),
+	ShortDescription: "Ask a peer to open a channel to us.\n",
+}
+
 var pushCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("push"), lnutil.ReqColor("channel idx", "amount"), lnutil.OptColor("times")),
-	Description: fmt.Sprintf("%s\n%s\n",
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
 		"Push the given amount (in satoshis) to the other party on the given channel.",
-		"Optionally, the push operation can be repeated <times> number of times."),
+		"Optionally, the push operation can be repeated <times> number of times.",
+		"The channel can be given by index or short channel id, block:tx:output."),
 	ShortDescription: "Push the given amount (in satoshis) to the other party on the given channel.\n",
 }
 
 var closeCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.White("close"), lnutil.ReqColor("channel idx")),
-	Description: fmt.Sprintf("%s\n%s\n%s%s\n",
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s%s\n",
 		"Cooperatively close the channel with the given index by asking",
-		"the other party to finalize the channel pay-out.",
+		"the other party to finalize the channel pay-out.  The channel can",
+		"also be given by short channel id, block:tx:output.",
 		"See also: ", lnutil.White("break")),
 	ShortDescription: "Cooperatively close the channel with the given index by asking\n",
 }
@@ -45,6 +96,92 @@ var breakCommand = &Command{
 	ShortDescription: "Forcibly break the given channel.\n",
 }
 
+var askBreakCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("askbreak"),
+		lnutil.ReqColor("channel idx|backup file outpoint"), lnutil.OptColor("reason")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Ask the peer to force close a channel with its latest state, for when",
+		"ours is lost or its keys may be compromised.  The request is signed",
+		"with the channel's key.  With backup, the channel at outpoint in a",
+		"channel backup file; what comes back is swept to the wallet."),
+	ShortDescription: "Ask the peer to force close a channel.\n",
+}
+
+var commitCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("commit"),
+		lnutil.ReqColor("channel idx"), lnutil.OptColor("remote")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show the current state tx for a channel: the tx, its outputs and scripts.",
+		"Add \"remote\" for the other side's state tx.  Your own comes fully signed;",
+		"broadcasting it breaks the channel, and once the state moves on loses it."),
+	ShortDescription: "Show the current state tx for a channel.\n",
+}
+
+var arbCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("arbexport"),
+		lnutil.ReqColor("channel idx"), lnutil.OptColor("file")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Export a channel's signed state history as json, for a third party to",
+		"settle a disputed close with.  Written to file if given, else shown.",
+		"It has the current fully signed state tx in it; keep it safe."),
+	ShortDescription: "Export a channel's history for arbitration.\n",
+}
+
+var replayCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("replay"),
+		lnutil.ReqColor("channel idx"), lnutil.OptColor("log file")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Replay a channel's updates from the replay log (lit --replaylog), showing",
+		"each message and state, where the update was, and what didn't fit.  With",
+		"a log file, replays that instead, eg one attached to a bug report."),
+	ShortDescription: "Replay a channel's updates to see where one went wrong.\n",
+}
+
+var chanOpsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("chanops"),
+		lnutil.OptColor("channel idx", "timeout secs")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show what each busy channel is running, for how long, and what's waiting",
+		"behind it.  With a channel and timeout, sets how long that channel's ops",
+		"can take before they're given up on and reported stuck; 0 for the default."),
+	ShortDescription: "Show or set what's queued on each channel.\n",
+}
+
+var recoverKeysCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("recoverkeys"),
+		lnutil.ReqColor("cointype", "pkscript", "their pubkey"),
+		lnutil.OptColor("max peer", "max chan")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Find the keys of a channel that's not in the DB, from its funding output",
+		"script and the other side's funding pubkey (both hex), by trying peer",
+		"and channel indexes up to max peer (default 100) and max chan (default 1000).",
+		"The peer can then co-sign a close paying the refund key shown."),
+	ShortDescription: "Find the keys of a channel lost from the DB.\n",
+}
+
+var payCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.White("pay"),
+		lnutil.OptColor("--key key"), lnutil.OptColor("--async"),
+		lnutil.ReqColor("payreq|user@domain"), lnutil.OptColor("amount")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Pay a payment request, or a lightning address like alice@example.com.",
+		"The amount (in satoshis) is needed for lightning addresses and for",
+		"requests which don't specify one.  With --key, running pay again with",
+		"the same key gives the first payment's result instead of paying again,",
+		"so it's safe to retry.  With --async, pay gives a payment id straight",
+		"away and lit keeps trying in the background, through other channels",
+		"if one fails; follow it with track."),
+	ShortDescription: "Pay a payment request or lightning address.\n",
+}
+
+var trackCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("track"), lnutil.OptColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Follow a pay --async payment, showing each try, until it's paid or lit",
+		"gives up.  Without an id, list the background payments."),
+	ShortDescription: "Follow payments made with pay --async.\n",
+}
+
 func (lc *litAfClient) FundChannel(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
 		fmt.Fprintf(color.Output, fundCommand.Format)
@@ -90,6 +227,198 @@ func (lc *litAfClient) FundChannel(textArgs []string) error {
 	return nil
 }
 
+// DryRunFund checks a fund without doing it
+func (lc *litAfClient) DryRunFund(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, dryFundCommand.Format)
+		fmt.Fprintf(color.Output, dryFundCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.FundArgs)
+	reply := new(litrpc.DryRunFundReply)
+
+	if len(textArgs) < 4 {
+		return fmt.Errorf(dryFundCommand.Format)
+	}
+
+	var nums [4]int64
+	for i := range nums {
+		n, err := strconv.ParseInt(textArgs[i], 10, 64)
+		if err != nil {
+			return err
+		}
+		nums[i] = n
+	}
+	args.Peer = uint32(nums[0])
+	args.CoinType = uint32(nums[1])
+	args.Capacity = nums[2]
+	args.InitialSend = nums[3]
+
+	err := lc.rpccon.Call("LitRPC.DryRunFund", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range reply.Checks {
+		status := lnutil.Green("ok  ")
+		if !c.OK {
+			status = lnutil.Red("FAIL")
+		}
+		fmt.Fprintf(color.Output, "%s %-9s %s\n", status, c.Name, c.Detail)
+	}
+	if reply.OK {
+		fmt.Fprintf(color.Output, "fund would go ahead; fee about %s\n",
+			lnutil.SatoshiColor(reply.Fee))
+	} else {
+		fmt.Fprintf(color.Output, "fund would fail\n")
+	}
+	return nil
+}
+
+// RequestInbound asks a peer for a channel to us
+func (lc *litAfClient) RequestInbound(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, inboundCommand.Format)
+		fmt.Fprintf(color.Output, inboundCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.RequestInboundArgs)
+	reply := new(litrpc.StatusReply)
+
+	if len(textArgs) < 3 {
+		return fmt.Errorf(inboundCommand.Format)
+	}
+
+	peer, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	coinType, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	cCap, err := strconv.ParseInt(textArgs[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Peer = uint32(peer)
+	args.CoinType = uint32(coinType)
+	args.Capacity = cCap
+
+	err = lc.rpccon.Call("LitRPC.RequestInbound", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// FundExternal runs the steps of funding a channel from outside the wallet
+func (lc *litAfClient) FundExternal(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, fundExtCommand.Format)
+		fmt.Fprintf(color.Output, fundExtCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(fundExtCommand.Format)
+	}
+
+	reply := new(litrpc.StatusReply)
+	switch textArgs[0] {
+	case "verify", "finish":
+		if len(textArgs) < 2 {
+			return fmt.Errorf("need the tx to %s", textArgs[0])
+		}
+		args := new(litrpc.ExternalTxArgs)
+		args.Tx = textArgs[1]
+		method := "LitRPC.VerifyExternalFund"
+		if textArgs[0] == "finish" {
+			method = "LitRPC.FinishExternalFund"
+		}
+		err := lc.rpccon.Call(method, args, reply)
+		if err != nil {
+			return err
+		}
+	case "cancel":
+		err := lc.rpccon.Call("LitRPC.CancelExternalFund", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+	default:
+		if len(textArgs) < 4 {
+			return fmt.Errorf(fundExtCommand.Format)
+		}
+		var nums [4]int
+		for i := range nums {
+			n, err := strconv.Atoi(textArgs[i])
+			if err != nil {
+				return err
+			}
+			nums[i] = n
+		}
+		args := new(litrpc.FundArgs)
+		args.Peer = uint32(nums[0])
+		args.CoinType = uint32(nums[1])
+		args.Capacity = int64(nums[2])
+		args.InitialSend = int64(nums[3])
+
+		fReply := new(litrpc.FundExternalReply)
+		err := lc.rpccon.Call("LitRPC.FundExternal", args, fReply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "send %s to %s (script %s)\n",
+			lnutil.SatoshiColor(fReply.Amt), lnutil.Address(fReply.Address),
+			fReply.PkScript)
+		fmt.Fprintf(color.Output, "then: fundext verify <unsigned tx>\n")
+		return nil
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// BumpFund replaces or double spends a stuck funding tx
+func (lc *litAfClient) BumpFund(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, bumpFundCommand.Format)
+		fmt.Fprintf(color.Output, bumpFundCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 2 {
+		return fmt.Errorf(bumpFundCommand.Format)
+	}
+
+	args := new(litrpc.FundFeeArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.ChanIdx = uint32(cIdx)
+	args.FeeRate, err = strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	method := "LitRPC.BumpFund"
+	if len(textArgs) > 2 && textArgs[2] == "abort" {
+		method = "LitRPC.AbortFund"
+	}
+	err = lc.rpccon.Call(method, args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
 // Request close of a channel.  Need to pass in peer, channel index
 func (lc *litAfClient) CloseChannel(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
@@ -106,13 +435,12 @@ func (lc *litAfClient) CloseChannel(textArgs []string) error {
 		return fmt.Errorf("need args: close chanIdx")
 	}
 
-	cIdx, err := strconv.Atoi(textArgs[0])
+	var err error
+	args.ChanIdx, args.ShortID, err = parseChanRef(textArgs[0])
 	if err != nil {
 		return err
 	}
 
-	args.ChanIdx = uint32(cIdx)
-
 	err = lc.rpccon.Call("LitRPC.CloseChannel", args, reply)
 	if err != nil {
 		return err
@@ -138,13 +466,12 @@ func (lc *litAfClient) BreakChannel(textArgs []string) error {
 		return fmt.Errorf("need args: break chanIdx")
 	}
 
-	cIdx, err := strconv.Atoi(textArgs[0])
+	var err error
+	args.ChanIdx, args.ShortID, err = parseChanRef(textArgs[0])
 	if err != nil {
 		return err
 	}
 
-	args.ChanIdx = uint32(cIdx)
-
 	err = lc.rpccon.Call("LitRPC.BreakChannel", args, reply)
 	if err != nil {
 		return err
@@ -154,6 +481,114 @@ func (lc *litAfClient) BreakChannel(textArgs []string) error {
 	return nil
 }
 
+// AskBreak asks a channel's peer to force close it
+func (lc *litAfClient) AskBreak(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, askBreakCommand.Format)
+		fmt.Fprintf(color.Output, askBreakCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.ForceCloseReqArgs)
+	reply := new(litrpc.ForceCloseReqReply)
+
+	if len(textArgs) < 1 {
+		return fmt.Errorf(askBreakCommand.Format)
+	}
+
+	var err error
+	if textArgs[0] == "backup" {
+		if len(textArgs) < 3 {
+			return fmt.Errorf(askBreakCommand.Format)
+		}
+		var b []byte
+		b, err = ioutil.ReadFile(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.Backup = hex.EncodeToString(b)
+		args.OutPoint = textArgs[2]
+		textArgs = textArgs[3:]
+	} else {
+		args.ChanIdx, args.ShortID, err = parseChanRef(textArgs[0])
+		if err != nil {
+			return err
+		}
+		textArgs = textArgs[1:]
+	}
+	args.Reason = strings.Join(textArgs, " ")
+
+	err = lc.rpccon.Call("LitRPC.RequestForceClose", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if !reply.Closed {
+		fmt.Fprintf(color.Output, "peer won't: %s\n", reply.Reason)
+		return nil
+	}
+	fmt.Fprintf(color.Output, "peer closed the channel in %s\n",
+		lnutil.OutPoint(reply.Txid))
+	if reply.Reason != "" {
+		fmt.Fprintf(color.Output, "(%s)\n", reply.Reason)
+	}
+	return nil
+}
+
+// DumpCommitment shows a channel's state tx
+func (lc *litAfClient) DumpCommitment(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, commitCommand.Format)
+		fmt.Fprintf(color.Output, commitCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.DumpCommitmentArgs)
+	reply := new(litrpc.DumpCommitmentReply)
+
+	if len(textArgs) < 1 {
+		return fmt.Errorf("need args: commit chanIdx [remote]")
+	}
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.ChanIdx = uint32(cIdx)
+	args.Remote = len(textArgs) > 1 && textArgs[1] == "remote"
+
+	err = lc.rpccon.Call("LitRPC.DumpCommitment", args, reply)
+	if err != nil {
+		return err
+	}
+
+	c := reply.Commitment
+	whose := "my"
+	if c.Remote {
+		whose = "their"
+	}
+	fmt.Fprintf(color.Output, "channel %d %s state %d capacity %s my amt %s fee %s\n",
+		c.ChanIdx, whose, c.StateIdx, lnutil.SatoshiColor(c.Capacity),
+		lnutil.SatoshiColor(c.MyAmt), lnutil.SatoshiColor(c.Fee))
+	fmt.Fprintf(color.Output, "txid %s signed %v\n", c.Txid, c.Signed)
+	for _, o := range c.Outputs {
+		owner := "theirs"
+		if o.Mine {
+			owner = "mine"
+		}
+		fmt.Fprintf(color.Output, "  out %d %s %s (%s) %s\n",
+			o.Index, o.Kind, lnutil.SatoshiColor(o.Value), owner, o.PkScript)
+		if o.Script != "" {
+			fmt.Fprintf(color.Output, "    delay %d script %s\n", o.Delay, o.ScriptDisasm)
+		}
+	}
+	fmt.Fprintf(color.Output, "fund script %s\n", c.FundScript)
+	if c.TheirSig != "" {
+		fmt.Fprintf(color.Output, "their sig %s\n", c.TheirSig)
+	}
+	fmt.Fprintf(color.Output, "tx %s\n", c.Tx)
+	return nil
+}
+
 // Push is the shell command which calls PushChannel
 func (lc *litAfClient) Push(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
@@ -169,8 +604,7 @@ func (lc *litAfClient) Push(textArgs []string) error {
 		return fmt.Errorf("need args: push chanIdx amt (times)")
 	}
 
-	// this stuff is all the same as in cclose, should put into a function...
-	cIdx, err := strconv.Atoi(textArgs[0])
+	cIdx, shortID, err := parseChanRef(textArgs[0])
 	if err != nil {
 		return err
 	}
@@ -187,7 +621,8 @@ func (lc *litAfClient) Push(textArgs []string) error {
 		}
 	}
 
-	args.ChanIdx = uint32(cIdx)
+	args.ChanIdx = cIdx
+	args.ShortID = shortID
 	args.Amt = int64(amt)
 
 	for times > 0 {
@@ -202,6 +637,105 @@ func (lc *litAfClient) Push(textArgs []string) error {
 	return nil
 }
 
+// Pay is the shell command which pays a payment request or lightning address
+func (lc *litAfClient) Pay(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, payCommand.Format)
+		fmt.Fprintf(color.Output, payCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.PayArgs)
+	reply := new(litrpc.PayReply)
+
+	if len(textArgs) > 1 && textArgs[0] == "--key" {
+		args.IdempotencyKey = textArgs[1]
+		textArgs = textArgs[2:]
+	}
+	if len(textArgs) > 0 && textArgs[0] == "--async" {
+		args.Async = true
+		textArgs = textArgs[1:]
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(payCommand.Format)
+	}
+	args.Dest = textArgs[0]
+	if len(textArgs) > 1 {
+		amt, err := strconv.ParseInt(textArgs[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.Amt = amt
+	}
+
+	err := lc.rpccon.Call("LitRPC.Pay", args, reply)
+	if err != nil {
+		return err
+	}
+	if args.Async {
+		fmt.Fprintf(color.Output, "Paying %s in the background as %s\n",
+			args.Dest, lnutil.White(reply.PaymentID))
+		return nil
+	}
+	if reply.Replayed {
+		fmt.Fprintf(color.Output, "Already paid with key %s: ", args.IdempotencyKey)
+	}
+	fmt.Fprintf(color.Output, "Paid %s through channel %s\n",
+		args.Dest, lnutil.White(reply.ChanIdx))
+	return nil
+}
+
+// Track follows a background payment until it's done
+func (lc *litAfClient) Track(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, trackCommand.Format)
+		fmt.Fprintf(color.Output, trackCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) < 1 {
+		reply := new(litrpc.QueuedPaymentsReply)
+		err := lc.rpccon.Call("LitRPC.ListQueuedPayments", nil, reply)
+		if err != nil {
+			return err
+		}
+		for _, p := range reply.Payments {
+			printQueuedPayment(p)
+		}
+		return nil
+	}
+
+	id, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	args := litrpc.TrackPaymentArgs{PaymentID: uint32(id)}
+	for {
+		reply := new(litrpc.TrackPaymentReply)
+		err = lc.rpccon.Call("LitRPC.TrackPayment", args, reply)
+		if err != nil {
+			return err
+		}
+		printQueuedPayment(reply.Payment)
+		if reply.Payment.Final {
+			return nil
+		}
+		args.Seq = reply.Payment.Seq
+	}
+}
+
+func printQueuedPayment(p litrpc.QueuedPaymentInfo) {
+	fmt.Fprintf(color.Output, "%s %s %s, try %d",
+		lnutil.White(p.ID), lnutil.SatoshiColor(p.Amt), p.State, p.Attempts)
+	if p.ChanIdx != 0 {
+		fmt.Fprintf(color.Output, " channel %d", p.ChanIdx)
+	}
+	if p.Err != "" {
+		fmt.Fprintf(color.Output, ": %s", p.Err)
+	}
+	fmt.Fprintf(color.Output, "\n")
+}
+
 func (lc *litAfClient) Dump(textArgs []string) error {
 	pReply := new(litrpc.DumpReply)
 	pArgs := new(litrpc.NoArgs)
@@ -233,3 +767,231 @@ func (lc *litAfClient) Dump(textArgs []string) error {
 
 	return nil
 }
+
+// RecoverKeys searches for a lost channel's key path
+func (lc *litAfClient) RecoverKeys(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, recoverKeysCommand.Format)
+		fmt.Fprintf(color.Output, recoverKeysCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.RecoverKeysArgs)
+	reply := new(litrpc.RecoverKeysReply)
+
+	if len(textArgs) < 3 {
+		return fmt.Errorf("need args: recoverkeys cointype pkscript theirpub")
+	}
+	coin, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.CoinType = uint32(coin)
+	args.PkScript = textArgs[1]
+	args.TheirPub = textArgs[2]
+	if len(textArgs) > 3 {
+		n, err := strconv.ParseUint(textArgs[3], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.MaxPeer = uint32(n)
+	}
+	if len(textArgs) > 4 {
+		n, err := strconv.ParseUint(textArgs[4], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.MaxChan = uint32(n)
+	}
+
+	err = lc.rpccon.Call("LitRPC.RecoverChannelKeys", args, reply)
+	if err != nil {
+		return err
+	}
+
+	k := reply.Keys
+	fmt.Fprintf(color.Output, "peer %d channel %d path %s\n",
+		k.PeerIdx, k.ChanIdx, lnutil.White(k.KeyPath))
+	fmt.Fprintf(color.Output, "fund pub %x\nrefund pub %x\n", k.FundPub, k.RefundPub)
+	fmt.Fprintf(color.Output, "watch refund pub %x\nHAKD base %x\n",
+		k.WatchRefundPub, k.HAKDBase)
+	return nil
+}
+
+// ExportArbitration writes out a channel's arbitration export
+func (lc *litAfClient) ExportArbitration(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, arbCommand.Format)
+		fmt.Fprintf(color.Output, arbCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(arbCommand.Format)
+	}
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ChanArgs)
+	args.ChanIdx = uint32(cIdx)
+	reply := new(litrpc.ArbitrationReply)
+	err = lc.rpccon.Call("LitRPC.ExportArbitration", args, reply)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(reply.Export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(textArgs) < 2 {
+		fmt.Fprintf(color.Output, "%s\n", b)
+		return nil
+	}
+	err = ioutil.WriteFile(textArgs[1], b, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "wrote %d states of channel %d to %s, history hash %s\n",
+		len(reply.Export.States), cIdx, textArgs[1], reply.Export.HistoryHash)
+	return nil
+}
+
+// Replay shows a channel's replayed updates, from the node or a log file
+func (lc *litAfClient) Replay(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, replayCommand.Format)
+		fmt.Fprintf(color.Output, replayCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(replayCommand.Format)
+	}
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+
+	var res *qln.ReplayResult
+	if len(textArgs) > 1 {
+		recs, err := qln.ReadReplayLog(textArgs[1])
+		if err != nil {
+			return err
+		}
+		res = qln.ReplayChannel(recs, uint32(cIdx))
+	} else {
+		args := new(litrpc.ChanArgs)
+		args.ChanIdx = uint32(cIdx)
+		reply := new(litrpc.ReplayReply)
+		err = lc.rpccon.Call("LitRPC.ReplayChannel", args, reply)
+		if err != nil {
+			return err
+		}
+		res = reply.Result
+	}
+
+	for _, s := range res.Steps {
+		what := s.Kind
+		switch s.Kind {
+		case qln.ReplaySend, qln.ReplayRecv:
+			what = fmt.Sprintf("%s %s", s.Kind, lnutil.White(s.Msg))
+			if s.Delta != 0 {
+				what += fmt.Sprintf(" delta %d", s.Delta)
+			}
+		case qln.ReplayState:
+			what = fmt.Sprintf("save state %d amt %d delta %d",
+				s.StateIdx, s.MyAmt, s.Delta)
+			if s.Collision != 0 {
+				what += fmt.Sprintf(" collision %d", s.Collision)
+			}
+		case qln.ReplayEvent:
+			what = "event " + s.Event
+		case qln.ReplayError:
+			what = lnutil.Red("error")
+		}
+		fmt.Fprintf(color.Output, "%d %s %s -> %s\n", s.Seq,
+			time.Unix(0, s.Time).Format("2006-01-02 15:04:05.000"), what, s.Phase)
+		if s.Problem != "" {
+			fmt.Fprintf(color.Output, "\t%s\n", lnutil.Red(s.Problem))
+		}
+	}
+	fmt.Fprintf(color.Output, "%s %d events, %d problems, at state %d, %s\n",
+		lnutil.Header(fmt.Sprintf("channel %d:", cIdx)),
+		len(res.Steps), res.Problems, res.StateIdx, res.Phase)
+	if res.Stuck != "" {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.Red("stuck:"), res.Stuck)
+	}
+	return nil
+}
+
+// ChanOps shows each channel's op queue, or sets a channel's op timeout
+func (lc *litAfClient) ChanOps(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, chanOpsCommand.Format)
+		fmt.Fprintf(color.Output, chanOpsCommand.Description)
+		return nil
+	}
+	if len(textArgs) > 0 {
+		if len(textArgs) < 2 {
+			return fmt.Errorf(chanOpsCommand.Format)
+		}
+		args := new(litrpc.ChanOpTimeoutArgs)
+		var err error
+		args.ChanIdx, args.ShortID, err = parseChanRef(textArgs[0])
+		if err != nil {
+			return err
+		}
+		secs, err := strconv.ParseUint(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.Seconds = uint32(secs)
+		reply := new(litrpc.StatusReply)
+		err = lc.rpccon.Call("LitRPC.SetChanOpTimeout", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	reply := new(litrpc.ChanOpsReply)
+	err := lc.rpccon.Call("LitRPC.ChanOps", nil, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Queues) == 0 {
+		fmt.Fprintf(color.Output, "no channel ops going\n")
+		return nil
+	}
+	for _, q := range reply.Queues {
+		running := "nothing"
+		if q.Running != "" {
+			running = fmt.Sprintf("%s for %s", lnutil.White(q.Running),
+				q.RunningFor-q.RunningFor%time.Millisecond)
+		}
+		if q.Stuck {
+			running += " " + lnutil.Red("stuck")
+		}
+		fmt.Fprintf(color.Output, "%s peer %d: %s, timeout %s\n",
+			lnutil.Header(fmt.Sprintf("channel %d", q.ChanIdx)), q.PeerIdx,
+			running, q.Timeout)
+		if len(q.Waiting) > 0 {
+			fmt.Fprintf(color.Output, "\twaiting: %s\n", strings.Join(q.Waiting, ", "))
+		}
+	}
+	return nil
+}
+
+// parseChanRef reads a channel given as its index, or as a short channel
+// id, block:tx:output
+func parseChanRef(s string) (uint32, string, error) {
+	if strings.ContainsAny(s, ":x") {
+		return 0, s, nil
+	}
+	cIdx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, "", err
+	}
+	return uint32(cIdx), "", nil
+}
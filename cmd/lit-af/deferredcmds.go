@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var deferredCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("deferred"),
+		lnutil.OptColor("run|cancel"), lnutil.OptColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"List the closes and sweeps held back because the fee rate is over",
+		"feeceiling.  They go out by themselves once it comes down; \"run id\"",
+		"sends one now anyway, and \"cancel id\" drops it."),
+	ShortDescription: "List, run or cancel actions waiting for fees to drop.\n",
+}
+
+func (lc *litAfClient) Deferred(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, deferredCommand.Format)
+		fmt.Fprintf(color.Output, deferredCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) > 0 {
+		if len(textArgs) < 2 ||
+			(textArgs[0] != "run" && textArgs[0] != "cancel") {
+			return fmt.Errorf(deferredCommand.Format)
+		}
+		id, err := strconv.ParseUint(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		args := litrpc.DeferredArgs{ID: uint32(id), Cancel: textArgs[0] == "cancel"}
+		reply := new(litrpc.StatusReply)
+		err = lc.rpccon.Call("LitRPC.DeferredRun", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	reply := new(litrpc.DeferredReply)
+	err := lc.rpccon.Call("LitRPC.DeferredList", nil, reply)
+	if err != nil {
+		return err
+	}
+	if reply.Ceiling == 0 {
+		fmt.Fprintf(color.Output, "no fee ceiling set\n")
+	} else {
+		fmt.Fprintf(color.Output, "fee ceiling %d sat/byte\n", reply.Ceiling)
+	}
+	for _, a := range reply.Actions {
+		fmt.Fprintf(color.Output, "#%d %s (coin %d), waiting since %s\n",
+			a.ID, a.What, a.Coin, time.Unix(a.Queued, 0).Format(time.RFC3339))
+		if a.LastErr != "" {
+			fmt.Fprintf(color.Output, "\t%d tries, last error: %s\n",
+				a.Tries, a.LastErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var invoiceCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.White("invoice"),
+		lnutil.ReqColor("amount|--fiat 5.00USD"), lnutil.OptColor("--meta key=value"),
+		lnutil.OptColor("description"), lnutil.OptColor("--qr")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Make an invoice and show its payment request.  The amount is in satoshis,",
+		"or with --fiat in a fiat currency, converted at the node's current rate;",
+		"the node needs fiat rates on for that.  --qr also shows it as a QR code.",
+		"Each --meta, eg --meta order_id=1234, is kept with the invoice for matching",
+		"up payments, and not shown to the payer."),
+	ShortDescription: "Make an invoice to be paid.\n",
+}
+
+// how long lit-af keeps a fiat rate before asking the node again
+const fiatRateCache = time.Minute
+
+// fiatAnnotator shows amounts in a fiat currency, at rates from the node
+type fiatAnnotator struct {
+	lc       *litAfClient
+	currency string
+
+	mtx         sync.Mutex
+	satsPerUnit float64
+	at          time.Time
+}
+
+// startFiat makes every amount lit-af shows also show in currency
+func (lc *litAfClient) startFiat(currency string) {
+	fa := &fiatAnnotator{lc: lc, currency: strings.ToUpper(currency)}
+	lnutil.SatoshiAnnotate = fa.annotate
+}
+
+func (fa *fiatAnnotator) annotate(sats int64) string {
+	fa.mtx.Lock()
+	defer fa.mtx.Unlock()
+	if time.Since(fa.at) > fiatRateCache {
+		args := litrpc.FiatRateArgs{Currency: fa.currency}
+		reply := new(litrpc.FiatRateReply)
+		// rawcon, so --json output isn't cluttered with rates
+		err := fa.lc.rawcon.Call("LitRPC.FiatRate", args, reply)
+		// on error try again next time, not on every amount
+		fa.at = time.Now()
+		if err != nil || reply.SatsPerUnit <= 0 {
+			fa.satsPerUnit = 0
+			return ""
+		}
+		fa.satsPerUnit = reply.SatsPerUnit
+	}
+	if fa.satsPerUnit == 0 {
+		return ""
+	}
+	return lnutil.FormatFiat(
+		int64(math.Round(float64(sats)/fa.satsPerUnit*100)), fa.currency)
+}
+
+func (lc *litAfClient) Invoice(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, invoiceCommand.Format)
+		fmt.Fprintf(color.Output, invoiceCommand.Description)
+		return nil
+	}
+	textArgs, showQR := takeQRFlag(textArgs)
+	if len(textArgs) < 1 {
+		return fmt.Errorf(invoiceCommand.Format)
+	}
+
+	args := new(litrpc.AddInvoiceArgs)
+	reply := new(litrpc.InvoiceReply)
+
+	if textArgs[0] == "--fiat" {
+		if len(textArgs) < 2 {
+			return fmt.Errorf(invoiceCommand.Format)
+		}
+		args.Fiat = textArgs[1]
+		textArgs = textArgs[2:]
+	} else {
+		amt, err := strconv.ParseInt(textArgs[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.Amt = amt
+		textArgs = textArgs[1:]
+	}
+	for len(textArgs) > 1 && textArgs[0] == "--meta" {
+		kv := strings.SplitN(textArgs[1], "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("--meta takes key=value, not %s", textArgs[1])
+		}
+		if args.Metadata == nil {
+			args.Metadata = make(map[string]string)
+		}
+		args.Metadata[kv[0]] = kv[1]
+		textArgs = textArgs[2:]
+	}
+	args.Description = strings.Join(textArgs, " ")
+
+	err := lc.rpccon.Call("LitRPC.AddInvoice", args, reply)
+	if err != nil {
+		return err
+	}
+	inv := reply.Invoice
+	amt := lnutil.SatoshiColor(inv.Amt)
+	if inv.Fiat != "" && lnutil.SatoshiAnnotate == nil {
+		amt += " (" + inv.Fiat + ")"
+	}
+	fmt.Fprintf(color.Output, "Invoice for %s, hash %s, expires %s\n",
+		amt, lnutil.White(inv.PaymentHash),
+		time.Unix(inv.ExpiresAt, 0).Format(time.RFC822))
+	if showQR {
+		err = printQR(inv.PayReq)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(color.Output, "%s\n", inv.PayReq)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var heirCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("heir"),
+		lnutil.OptColor("set addr [delay] | clear [cointype] | checkin | export [cointype]")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Show wallets' heirs.  An heir gets everything in the wallet, in a tx lit",
+		"signs now that can't confirm for delay blocks (about a year if not given).",
+		"Check in and it's pushed back once it's near; if nobody does, lit sends",
+		"it when it can.  Check in does every wallet with an heir.",
+		"export gives the signed tx, to hand to the heir in case this node's gone",
+		"by then.  Once exported, pushing it back or clearing the heir moves the",
+		"wallet's coins to make it no good, which costs a fee; export again when",
+		"the wallet's coins change."),
+	ShortDescription: "Set up an heir for the wallet, or check in.\n",
+}
+
+func (lc *litAfClient) Heir(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, heirCommand.Format)
+		fmt.Fprintf(color.Output, heirCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.HeirsReply)
+		err := lc.rpccon.Call("LitRPC.Heirs", litrpc.NoArgs{}, reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.Heirs) == 0 {
+			fmt.Fprintf(color.Output, "no heirs set\n")
+		}
+		for _, h := range reply.Heirs {
+			fmt.Fprintf(color.Output, "coin %d to %s, delay %d\n",
+				h.CoinType, lnutil.White(h.Heir), h.Delay)
+			switch {
+			case h.Sent:
+				fmt.Fprintf(color.Output, "\tsent after block %d\n", h.LockHeight)
+				continue
+			case h.Txid == "":
+				fmt.Fprintf(color.Output, "\tno tx; no confirmed coins\n")
+			default:
+				fmt.Fprintf(color.Output, "\ttx %s valid after block %d, %d from now\n",
+					h.Txid, h.LockHeight, h.BlocksLeft)
+			}
+			fmt.Fprintf(color.Output, "\tlast check in at %d", h.CheckIn)
+			if h.Due {
+				fmt.Fprintf(color.Output, "; %s", lnutil.Red("check in due"))
+			}
+			if h.Exported {
+				fmt.Fprintf(color.Output, "; exported")
+			}
+			fmt.Fprintf(color.Output, "\n")
+		}
+		return nil
+	}
+
+	var coinArgs litrpc.CoinArgs
+	if len(textArgs) > 1 && textArgs[0] != "set" {
+		coin, err := strconv.ParseUint(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		coinArgs.CoinType = uint32(coin)
+	}
+
+	switch textArgs[0] {
+	case "set":
+		if len(textArgs) < 2 {
+			return fmt.Errorf(heirCommand.Format)
+		}
+		args := litrpc.SetHeirArgs{Address: textArgs[1]}
+		if len(textArgs) > 2 {
+			delay, err := strconv.ParseInt(textArgs[2], 10, 32)
+			if err != nil {
+				return err
+			}
+			args.Delay = int32(delay)
+		}
+		reply := new(litrpc.StatusReply)
+		err := lc.rpccon.Call("LitRPC.SetHeir", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	case "clear":
+		reply := new(litrpc.StatusReply)
+		err := lc.rpccon.Call("LitRPC.ClearHeir", coinArgs, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	case "checkin":
+		reply := new(litrpc.StatusReply)
+		err := lc.rpccon.Call("LitRPC.HeirCheckIn", litrpc.NoArgs{}, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	case "export":
+		reply := new(litrpc.ExportHeirTxReply)
+		err := lc.rpccon.Call("LitRPC.ExportHeirTx", coinArgs, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "tx %s, valid after block %d:\n%s\n",
+			lnutil.White(reply.Txid), reply.LockHeight, reply.Tx)
+	default:
+		return fmt.Errorf(heirCommand.Format)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var leaseCommand = &Command{
+	Format: fmt.Sprintf("%s [%s%s]\n", lnutil.White("lease"),
+		lnutil.White("buy"), lnutil.ReqColor("peer", "coinType", "capacity")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"With no arguments, list the channel leases we've sold.",
+		"\"lease buy\" gets a peer's terms for leasing us a channel of capacity,",
+		"one of the sizes shown for it in ls; pay the fee with pay or send by",
+		"when the terms expire and the peer opens the channel."),
+	ShortDescription: "Buy a channel lease from a peer, or list those sold.\n",
+}
+
+// Lease lists the leases we've sold, or buys one from a peer
+func (lc *litAfClient) Lease(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, leaseCommand.Format)
+		fmt.Fprintf(color.Output, leaseCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.ListLeasesReply)
+		err := lc.rpccon.Call("LitRPC.ListLeases", nil, reply)
+		if err != nil {
+			return err
+		}
+		for _, l := range reply.Leases {
+			fmt.Fprintf(color.Output, "%s peer %d coin %d %s for %d blocks, fee %s",
+				lnutil.White(l.State), l.Peer, l.CoinType,
+				lnutil.SatoshiColor(l.Capacity), l.Blocks,
+				lnutil.SatoshiColor(l.Fee))
+			if l.AmtPaid != 0 {
+				fmt.Fprintf(color.Output, " paid %s", lnutil.SatoshiColor(l.AmtPaid))
+				if l.PaidOnChain {
+					fmt.Fprintf(color.Output, " on chain")
+				}
+			}
+			if l.State == "open" || l.State == "ended" {
+				fmt.Fprintf(color.Output, " channel %d until height %d",
+					l.ChanIdx, l.EndHeight)
+			}
+			fmt.Fprintf(color.Output, "\n\t%s\n", l.LeaseID)
+		}
+		return nil
+	}
+
+	if textArgs[0] != "buy" || len(textArgs) < 4 {
+		return fmt.Errorf(leaseCommand.Format)
+	}
+	peer, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	coinType, err := strconv.Atoi(textArgs[2])
+	if err != nil {
+		return err
+	}
+	cCap, err := strconv.ParseInt(textArgs[3], 10, 64)
+	if err != nil {
+		return err
+	}
+	args := litrpc.BuyLeaseArgs{
+		Peer: uint32(peer), CoinType: uint32(coinType), Capacity: cCap}
+	reply := new(litrpc.BuyLeaseReply)
+	err = lc.rpccon.Call("LitRPC.BuyLease", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "peer %d leases %s for %d blocks, fee %s\n",
+		peer, lnutil.SatoshiColor(cCap), reply.Blocks,
+		lnutil.SatoshiColor(reply.Fee))
+	fmt.Fprintf(color.Output, "pay by %s with either of:\n",
+		time.Unix(reply.Expires, 0).Format(time.RFC822))
+	if reply.PayReq != "" {
+		fmt.Fprintf(color.Output, "\tpay %s\n", reply.PayReq)
+	}
+	fmt.Fprintf(color.Output, "\tsend %s %d\n", reply.Address, reply.Fee)
+	return nil
+}
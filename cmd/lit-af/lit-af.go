@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"os"
@@ -49,9 +51,41 @@ const (
 type litAfClient struct {
 	remote string
 	port   uint16
-	rpccon *rpc.Client
+	socket string       // unix socket to use instead of remote:port
+	rpccon *replyCaller // commands call through this
+	rawcon *rpc.Client  // completion and async messages call through this
 	//httpcon
 	litHomeDir string
+
+	command string // run this and exit, instead of the shell
+	json    bool   // print rpc replies as json
+	account string // node account to use; empty for the main one
+	fiat    string // currency to also show amounts in
+}
+
+// replyCaller makes rpc calls for commands.  With json set it prints each
+// reply as json on stdout.  It remembers if any call failed, so -c can exit
+// with an error.
+type replyCaller struct {
+	*rpc.Client
+	json   bool
+	failed bool
+}
+
+func (c *replyCaller) Call(method string, args interface{}, reply interface{}) error {
+	err := c.Client.Call(method, args, reply)
+	if err != nil {
+		c.failed = true
+		return err
+	}
+	if c.json {
+		b, err := json.MarshalIndent(reply, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", b)
+	}
+	return nil
 }
 
 type Command struct {
@@ -63,13 +97,63 @@ type Command struct {
 func setConfig(lc *litAfClient) {
 	hostptr := flag.String("node", "127.0.0.1", "host to connect to")
 	portptr := flag.Int("p", 8001, "port to connect to")
+	socketptr := flag.String("socket", "", "unix socket to connect to instead, eg lit.sock (relative to -dir)")
 	dirptr := flag.String("dir", filepath.Join(os.Getenv("HOME"), litHomeDirName), "directory to save settings")
+	cmdptr := flag.String("c", "", "run commands (separated by ;) and exit")
+	jsonptr := flag.Bool("json", false, "print rpc replies as json; other output goes to stderr")
+	accountptr := flag.String("account", "", "account on the node to use, if not the main one")
+	fiatptr := flag.String("fiat", "", "also show amounts in this currency, eg USD (node needs fiat rates on)")
 
 	flag.Parse()
 
 	lc.remote = *hostptr
 	lc.port = uint16(*portptr)
 	lc.litHomeDir = *dirptr
+	lc.socket = *socketptr
+	if lc.socket != "" && !filepath.IsAbs(lc.socket) {
+		lc.socket = filepath.Join(lc.litHomeDir, lc.socket)
+	}
+	lc.command = *cmdptr
+	lc.json = *jsonptr
+	lc.account = *accountptr
+	lc.fiat = *fiatptr
+}
+
+// runCommands runs the ; separated commands from -c, and exits.  Exit status
+// is 1 if any rpc call failed.
+func (lc *litAfClient) runCommands() {
+	for _, msg := range strings.Split(lc.command, ";") {
+		cmdslice := strings.Fields(msg)
+		if len(cmdslice) == 0 {
+			continue
+		}
+		err := lc.Shellparse(cmdslice)
+		if err != nil { // exit or off
+			break
+		}
+	}
+	if lc.rpccon.failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// dial opens the websocket to lit, over the unix socket if one's set
+func (lc *litAfClient) dial(urlString, origin string) (*websocket.Conn, error) {
+	if lc.socket == "" {
+		return websocket.Dial(urlString, "", origin)
+	}
+	conn, err := net.Dial("unix", lc.socket)
+	if err != nil {
+		return nil, err
+	}
+	// the host in the url doesn't matter; the socket's already connected
+	cfg, err := websocket.NewConfig(urlString, origin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return websocket.NewClient(cfg, conn)
 }
 
 // for now just testing how to connect and get messages back and forth
@@ -88,19 +172,42 @@ func main() {
 	*/
 
 	//	dialString := fmt.Sprintf("%s:%d", lc.remote, lc.port)
-	origin := "http://127.0.0.1/"
+	// lit only takes websockets from its own origin
+	origin := fmt.Sprintf("http://%s:%d/", lc.remote, lc.port)
 	urlString := fmt.Sprintf("ws://%s:%d/ws", lc.remote, lc.port)
+	if lc.account != "" {
+		urlString += "/" + lc.account
+	}
 	//	url := "ws://127.0.0.1:8000/ws"
-	wsConn, err := websocket.Dial(urlString, "", origin)
+	wsConn, err := lc.dial(urlString, origin)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer wsConn.Close()
 
-	lc.rpccon = jsonrpc.NewClient(wsConn)
+	lc.rawcon = jsonrpc.NewClient(wsConn)
+	lc.rpccon = &replyCaller{Client: lc.rawcon, json: lc.json}
+	if lc.fiat != "" {
+		lc.startFiat(lc.fiat)
+	}
+
+	// with json on, people are piping stdout; keep it just json
+	if lc.json {
+		color.Output = os.Stderr
+	}
+
+	if lc.command != "" {
+		lc.runCommands()
+	}
 
 	go lc.RequestAsync()
 
+	// make sure there's somewhere to keep history
+	err = os.MkdirAll(lc.litHomeDir, 0700)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:       lnutil.Prompt("lit-af") + lnutil.White("# "),
 		HistoryFile:  filepath.Join(lc.litHomeDir, historyFilename),
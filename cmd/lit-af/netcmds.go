@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -16,9 +17,19 @@ var sayCommand = &Command{
 	ShortDescription: "Send a message to a peer.\n",
 }
 
+var customCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("custom"),
+		lnutil.ReqColor("peer", "type", "hexdata")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Send a peer a custom message: bytes of a 16 bit type for an application.",
+		"\"custom watch [type...]\" prints custom messages as they come in, of",
+		"the types given or all of them."),
+	ShortDescription: "Send or watch for custom application messages.\n",
+}
+
 var lisCommand = &Command{
-	Format:           fmt.Sprintf("%s%s\n", lnutil.White("lis"), lnutil.OptColor("port")),
-	Description:      fmt.Sprintf("Start listening for incoming connections. The port number, if omitted, defaults to 2448.\n"),
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("lis"), lnutil.OptColor("port", "--qr")),
+	Description:      fmt.Sprintf("Start listening for incoming connections. The port number, if omitted, defaults to 2448.\n--qr shows the address to connect to as a QR code.\n"),
 	ShortDescription: "Start listening for incoming connections.\n",
 }
 
@@ -31,6 +42,58 @@ var conCommand = &Command{
 	ShortDescription: "Make a connection to another host by connecting to their pubkeyhash\n",
 }
 
+var towersCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("towers"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"List the watchtowers this node has heard of from peers or the tower directory.",
+		"With a coin type, also show which one would be picked to watch that coin."),
+	ShortDescription: "List known watchtowers.\n",
+}
+
+var towerAdminCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("toweradmin"),
+		lnutil.OptColor("propose|approve|sign", "args")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
+		"With no arguments, show the tower's admin policy, quota and ops waiting",
+		"for approval.  Destructive tower ops need M of the operators' approvals:",
+		"  toweradmin propose drop|restore|quota|policy arg  -- channel pkh hex, backup",
+		"      archive sha256, most channels (0 for no limit), or m:pubkey,pubkey,...",
+		"  toweradmin sign id  -- on an operator's own node; gives pubkey and signature",
+		"  toweradmin approve id pubkey signature  -- on the tower"),
+	ShortDescription: "Propose and approve tower admin ops.\n",
+}
+
+var myTowersCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("mytowers"),
+		lnutil.OptColor("add|rm|k", "args")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"With no arguments, list the towers this node uploads channel states to:",
+		"k of them get every state, and the rest stand by to take over from one",
+		"that's gone, getting everything from before.",
+		"  mytowers add pubkey[@host]  -- host can be left out for a known tower",
+		"  mytowers rm pubkey  /  mytowers k n"),
+	ShortDescription: "Add and list the watchtowers this node uploads to.\n",
+}
+
+var towerReportCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("towerreport"), lnutil.OptColor("timeout")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Ask our watchtower what it has for each open channel: the last state it",
+		"got and how many it holds.  States it's missing are sent again; lost ones,",
+		"which it got but can't use any more, can't be.  Waits up to timeout seconds (30)."),
+	ShortDescription: "Check what our watchtower has, and fill any gaps.\n",
+}
+
+var peerReportCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("peerreport"), lnutil.OptColor("days")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Score the peers we've had channels with, best first, on uptime, payments",
+		"through them that failed, force closes and funding fee bumps, and say",
+		"which channels look worth closing or growing.  Payments and failures",
+		"count from days ago (30)."),
+	ShortDescription: "Rank channel partners.\n",
+}
+
 // RequestAsync keeps requesting messages from the server.  The server blocks
 // and will send a response once it gets one.  Once the rpc client receives a
 // response, it will immediately request another.
@@ -39,7 +102,7 @@ func (lc *litAfClient) RequestAsync() {
 		args := new(litrpc.NoArgs)
 		reply := new(litrpc.StatusReply)
 
-		err := lc.rpccon.Call("LitRPC.GetMessages", args, reply)
+		err := lc.rawcon.Call("LitRPC.GetMessages", args, reply)
 		if err != nil {
 			fmt.Fprintf(color.Output, "RequestAsync error %s\n", lnutil.Red(err.Error()))
 			break
@@ -58,6 +121,7 @@ func (lc *litAfClient) Lis(textArgs []string) error {
 		return nil
 	}
 
+	textArgs, showQR := takeQRFlag(textArgs)
 	args := new(litrpc.ListenArgs)
 	reply := new(litrpc.ListeningPortsReply)
 
@@ -78,6 +142,17 @@ func (lc *litAfClient) Lis(textArgs []string) error {
 		return fmt.Errorf("no listening port returned")
 	}
 
+	if showQR {
+		// a bare :port needs a host someone else can reach; leave it off
+		uri := reply.Adr
+		if !strings.HasPrefix(reply.LisIpPorts[0], ":") {
+			uri += "@" + reply.LisIpPorts[0]
+		}
+		err = printQR(uri)
+		if err != nil {
+			return err
+		}
+	}
 	fmt.Fprintf(color.Output, "listening on %s@%s\n", reply.Adr, reply.LisIpPorts[0])
 
 	return nil
@@ -142,3 +217,372 @@ func (lc *litAfClient) Say(textArgs []string) error {
 	fmt.Fprintf(color.Output, "%s\n", reply.Status)
 	return nil
 }
+
+// Custom sends a custom message, or watches for them
+func (lc *litAfClient) Custom(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, customCommand.Format)
+		fmt.Fprintf(color.Output, customCommand.Description)
+		return nil
+	}
+	if len(textArgs) > 0 && textArgs[0] == "watch" {
+		return lc.watchCustom(textArgs[1:])
+	}
+	if len(textArgs) < 3 {
+		return fmt.Errorf(customCommand.Format)
+	}
+
+	peerIdx, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	typ, err := strconv.ParseUint(textArgs[1], 0, 16)
+	if err != nil {
+		return err
+	}
+	args := litrpc.SendCustomMessageArgs{
+		Peer: uint32(peerIdx), Type: uint16(typ), Data: textArgs[2]}
+	reply := new(litrpc.StatusReply)
+	err = lc.rpccon.Call("LitRPC.SendCustomMessage", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// watchCustom prints custom messages as they come in, until one errors
+func (lc *litAfClient) watchCustom(textArgs []string) error {
+	var args litrpc.SubscribeCustomMessagesArgs
+	for _, a := range textArgs {
+		typ, err := strconv.ParseUint(a, 0, 16)
+		if err != nil {
+			return err
+		}
+		args.Types = append(args.Types, uint16(typ))
+	}
+	// start from what comes in next, not what's already there
+	first := new(litrpc.SubscribeCustomMessagesReply)
+	args.Timeout = 1
+	err := lc.rpccon.Call("LitRPC.SubscribeCustomMessages", args, first)
+	if err != nil {
+		return err
+	}
+	args.Seq = first.Seq
+	args.Timeout = 0
+	for {
+		reply := new(litrpc.SubscribeCustomMessagesReply)
+		err = lc.rpccon.Call("LitRPC.SubscribeCustomMessages", args, reply)
+		if err != nil {
+			return err
+		}
+		for _, m := range reply.Messages {
+			fmt.Fprintf(color.Output, "%s peer %s type %d: %s\n",
+				time.Unix(m.Time, 0).Format("15:04:05"), lnutil.White(m.PeerIdx),
+				m.Type, m.Data)
+		}
+		args.Seq = reply.Seq
+	}
+}
+
+// Towers lists the known watchtowers
+func (lc *litAfClient) Towers(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, towersCommand.Format)
+		fmt.Fprintf(color.Output, towersCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.ListKnownTowersArgs)
+	reply := new(litrpc.ListKnownTowersReply)
+	if len(textArgs) > 0 {
+		coin, err := strconv.ParseUint(textArgs[0], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coin)
+	}
+
+	err := lc.rpccon.Call("LitRPC.ListKnownTowers", args, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Towers) == 0 {
+		fmt.Fprintf(color.Output, "no known towers\n")
+		return nil
+	}
+	for _, t := range reply.Towers {
+		mark := " "
+		if t.TowerPub == reply.Pick {
+			mark = lnutil.Green("*")
+		}
+		accepting := ""
+		if t.Features&lnutil.TowerFeatAccepting == 0 {
+			accepting = lnutil.Red(" (full)")
+		}
+		fmt.Fprintf(color.Output, "%s %s@%s\t%d ppm\tcoins %v\t%s %s%s\n",
+			mark, lnutil.White(t.LitAdr), t.Host, t.RewardPPM, t.CoinTypes,
+			t.Source, time.Unix(t.Time, 0).Format("2006-01-02"), accepting)
+	}
+	return nil
+}
+
+// TowerReport shows what our tower has for each channel
+func (lc *litAfClient) TowerReport(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, towerReportCommand.Format)
+		fmt.Fprintf(color.Output, towerReportCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.TowerReportArgs)
+	reply := new(litrpc.TowerReportReply)
+	if len(textArgs) > 0 {
+		secs, err := strconv.ParseInt(textArgs[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.Timeout = secs
+	}
+
+	err := lc.rpccon.Call("LitRPC.TowerReport", args, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Channels) == 0 {
+		fmt.Fprintf(color.Output, "no channels sent to the tower\n")
+		return nil
+	}
+	for _, c := range reply.Channels {
+		if c.TowerPub != "" {
+			fmt.Fprintf(color.Output, "tower %s ", c.TowerPub[:16])
+		}
+		fmt.Fprintf(color.Output, "%s peer %d sent up to %d\t",
+			lnutil.White(c.ChanIdx), c.PeerIdx, c.SentUpTo)
+		e := c.Tower
+		switch {
+		case e.Flags&lnutil.WatchReportKnown == 0:
+			fmt.Fprintf(color.Output, "%s", lnutil.Red("unknown to tower"))
+		case e.Flags&lnutil.WatchReportUnwatched != 0:
+			fmt.Fprintf(color.Output, "unwatched")
+		case e.Flags&lnutil.WatchReportHasStates == 0:
+			fmt.Fprintf(color.Output, "%s", lnutil.Red("no states"))
+		default:
+			fmt.Fprintf(color.Output, "tower has up to %d, holds %d",
+				e.LastState, e.States)
+		}
+		if c.Resent != 0 {
+			fmt.Fprintf(color.Output, ", resent %d", c.Resent)
+		}
+		if c.Lost != 0 {
+			fmt.Fprintf(color.Output, ", %s", lnutil.Red(fmt.Sprintf("%d lost", c.Lost)))
+		}
+		fmt.Fprintf(color.Output, "\n")
+	}
+	return nil
+}
+
+// MyTowers lists, adds and removes the towers we upload to, and sets k
+func (lc *litAfClient) MyTowers(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, myTowersCommand.Format)
+		fmt.Fprintf(color.Output, myTowersCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.ClientTowersReply)
+		err := lc.rpccon.Call("LitRPC.ClientTowers", nil, reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.Towers) == 0 {
+			fmt.Fprintf(color.Output, "no towers added\n")
+			return nil
+		}
+		fmt.Fprintf(color.Output, "%d of %d towers get every state\n",
+			reply.K, len(reply.Towers))
+		for _, t := range reply.Towers {
+			mark, state := " ", "standby"
+			if t.Active {
+				mark = lnutil.Green("*")
+				switch {
+				case t.DownSince != 0:
+					state = lnutil.Red(fmt.Sprintf("away since %s",
+						time.Unix(t.DownSince, 0).Format(time.RFC822)))
+				case !t.Backfilled:
+					state = "backfilling"
+				default:
+					state = fmt.Sprintf("%d waiting", t.Waiting)
+				}
+			} else if t.Connected {
+				state = "standby, connected"
+			}
+			fmt.Fprintf(color.Output, "%s %s@%s\t%s\n",
+				mark, lnutil.White(t.TowerPub), t.Host, state)
+		}
+		return nil
+	}
+
+	reply := new(litrpc.StatusReply)
+	switch textArgs[0] {
+	case "add", "rm":
+		if len(textArgs) < 2 {
+			return fmt.Errorf(myTowersCommand.Format)
+		}
+		method := "LitRPC.AddClientTower"
+		if textArgs[0] == "rm" {
+			method = "LitRPC.RemoveClientTower"
+		}
+		args := litrpc.ClientTowerArgs{Tower: textArgs[1]}
+		err := lc.rpccon.Call(method, args, reply)
+		if err != nil {
+			return err
+		}
+	case "k":
+		if len(textArgs) < 2 {
+			return fmt.Errorf(myTowersCommand.Format)
+		}
+		k, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		err = lc.rpccon.Call("LitRPC.SetTowerK", litrpc.SetTowerKArgs{K: k}, reply)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf(myTowersCommand.Format)
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// TowerAdmin shows, proposes, signs and approves tower admin ops
+func (lc *litAfClient) TowerAdmin(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, towerAdminCommand.Format)
+		fmt.Fprintf(color.Output, towerAdminCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.TowerAdminReply)
+		err := lc.rpccon.Call("LitRPC.TowerAdmin", nil, reply)
+		if err != nil {
+			return err
+		}
+		if reply.Policy == "" {
+			fmt.Fprintf(color.Output, "no admin policy; ops are done when proposed\n")
+		} else {
+			fmt.Fprintf(color.Output, "policy %s\n", reply.Policy)
+		}
+		if reply.Quota != 0 {
+			fmt.Fprintf(color.Output, "quota %d channels\n", reply.Quota)
+		}
+		for _, op := range reply.Ops {
+			state := fmt.Sprintf("%d approvals", op.Approvals)
+			if op.Approved {
+				state = lnutil.Green("approved")
+			}
+			fmt.Fprintf(color.Output, "%s %s\n\t%s, proposed %s\n",
+				lnutil.White(op.ID), op.Op, state,
+				time.Unix(op.Created, 0).Format(time.RFC822))
+		}
+		return nil
+	}
+
+	opReply := new(litrpc.TowerAdminOpReply)
+	switch textArgs[0] {
+	case "propose":
+		if len(textArgs) < 3 {
+			return fmt.Errorf(towerAdminCommand.Format)
+		}
+		args := litrpc.TowerAdminProposeArgs{Kind: textArgs[1], Arg: textArgs[2]}
+		err := lc.rpccon.Call("LitRPC.TowerAdminPropose", args, opReply)
+		if err != nil {
+			return err
+		}
+	case "approve":
+		if len(textArgs) < 4 {
+			return fmt.Errorf(towerAdminCommand.Format)
+		}
+		args := litrpc.TowerAdminApproveArgs{
+			ID: textArgs[1], Pub: textArgs[2], Sig: textArgs[3]}
+		err := lc.rpccon.Call("LitRPC.TowerAdminApprove", args, opReply)
+		if err != nil {
+			return err
+		}
+	case "sign":
+		if len(textArgs) < 2 {
+			return fmt.Errorf(towerAdminCommand.Format)
+		}
+		reply := new(litrpc.TowerAdminSignReply)
+		args := litrpc.TowerAdminSignArgs{ID: textArgs[1]}
+		err := lc.rpccon.Call("LitRPC.TowerAdminSign", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "toweradmin approve %s %s %s\n",
+			textArgs[1], reply.Pub, reply.Sig)
+		return nil
+	default:
+		return fmt.Errorf(towerAdminCommand.Format)
+	}
+
+	if opReply.Done {
+		fmt.Fprintf(color.Output, "%s done\n", opReply.ID)
+	} else {
+		fmt.Fprintf(color.Output, "%s waiting for approvals\n", opReply.ID)
+	}
+	return nil
+}
+
+// PeerReport ranks the peers we've had channels with
+func (lc *litAfClient) PeerReport(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, peerReportCommand.Format)
+		fmt.Fprintf(color.Output, peerReportCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.PeerReportArgs)
+	reply := new(litrpc.PeerReportReply)
+	if len(textArgs) > 0 {
+		days, err := strconv.ParseInt(textArgs[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.Since = time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix()
+	}
+
+	err := lc.rpccon.Call("LitRPC.PeerReport", args, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Peers) == 0 {
+		fmt.Fprintf(color.Output, "no channel partners yet\n")
+		return nil
+	}
+	for _, p := range reply.Peers {
+		fmt.Fprintf(color.Output, "%s score %.2f", lnutil.White(p.PeerIdx), p.Score)
+		if p.Nickname != "" {
+			fmt.Fprintf(color.Output, " (%s)", p.Nickname)
+		}
+		fmt.Fprintf(color.Output, " %d open %d closed, %s of %s ours\n",
+			p.Open, p.Closed, lnutil.SatoshiColor(p.Local),
+			lnutil.SatoshiColor(p.Capacity))
+		fmt.Fprintf(color.Output,
+			"\tup %.0f%%, %d/%d payments failed, %d htlc fails, %d force closes, %d fee bumps",
+			p.Uptime*100, p.FailedPayments, p.Payments, p.HTLCFails,
+			p.ForceCloses, p.FeeBumps)
+		switch p.Suggest {
+		case "close":
+			fmt.Fprintf(color.Output, "  %s", lnutil.Red("close?"))
+		case "grow":
+			fmt.Fprintf(color.Output, "  %s", lnutil.Green("grow?"))
+		}
+		fmt.Fprintf(color.Output, "\n")
+	}
+	return nil
+}
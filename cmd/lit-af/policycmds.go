@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var policyCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("policy")),
+	Description: fmt.Sprintf("%s\n",
+		"Show the node's spending policy and what's been spent in the last day."),
+	ShortDescription: "Show the spending policy.\n",
+}
+
+var approveCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("approve"),
+		lnutil.ReqColor("keyfile"), lnutil.ReqColor("spend")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Approve a spend the policy held back, with the approval key in keyfile.",
+		"spend is as the policy error gave it, eg offchain 500000 to ln1abc.",
+		"The same spend then has to be retried within 10 minutes."),
+	ShortDescription: "Approve a spend over the policy's threshold.\n",
+}
+
+func (lc *litAfClient) Policy(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, policyCommand.Format)
+		fmt.Fprintf(color.Output, policyCommand.Description)
+		return nil
+	}
+	reply := new(litrpc.SpendPolicyReply)
+	err := lc.rpccon.Call("LitRPC.SpendPolicy", nil, reply)
+	if err != nil {
+		return err
+	}
+	if !reply.On {
+		fmt.Fprintf(color.Output, "no spending policy\n")
+		return nil
+	}
+	limit := func(l int64) string {
+		if l == 0 {
+			return "no limit"
+		}
+		return lnutil.SatoshiColor(l)
+	}
+	fmt.Fprintf(color.Output, "%s %s of %s\n", lnutil.Header("On chain today:"),
+		lnutil.SatoshiColor(reply.SpentOnChain), limit(reply.DailyOnChain))
+	fmt.Fprintf(color.Output, "%s %s of %s\n", lnutil.Header("Off chain today:"),
+		lnutil.SatoshiColor(reply.SpentOffChain), limit(reply.DailyOffChain))
+	if reply.ConfirmAbove > 0 {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.Header("Approval above:"),
+			lnutil.SatoshiColor(reply.ConfirmAbove))
+	}
+	for _, a := range reply.Allow {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.Header("Allow:"), lnutil.Address(a))
+	}
+	for _, d := range reply.Deny {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.Header("Deny:"), lnutil.Address(d))
+	}
+	return nil
+}
+
+func (lc *litAfClient) Approve(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, approveCommand.Format)
+		fmt.Fprintf(color.Output, approveCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 2 {
+		return fmt.Errorf(approveCommand.Format)
+	}
+	b, err := ioutil.ReadFile(textArgs[0])
+	if err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("key file %s: %s", textArgs[0], err.Error())
+	}
+
+	args := new(litrpc.ApproveSpendArgs)
+	args.Spend = strings.Trim(strings.Join(textArgs[1:], " "), "\"")
+	args.Approval = litrpc.SpendApproval(key, args.Spend)
+	reply := new(litrpc.StatusReply)
+	err = lc.rpccon.Call("LitRPC.ApproveSpend", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qr"
+)
+
+var qrCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("qr"), lnutil.ReqColor("text")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show a QR code of the text, eg a payment request, in the terminal.",
+		"adr and lis also take --qr, to show the new address or the node address."),
+	ShortDescription: "Show a QR code of some text.\n",
+}
+
+// qrFlag is the flag that adds a QR code to a command's output
+const qrFlag = "--qr"
+
+// takeQRFlag takes --qr out of the args, saying whether it was there
+func takeQRFlag(textArgs []string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, a := range textArgs {
+		if a == qrFlag {
+			found = true
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return rest, found
+}
+
+// printQR draws text as a QR code in the terminal
+func printQR(text string) error {
+	code, err := qr.EncodeString(text)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s", code.Terminal())
+	return nil
+}
+
+// QR shows its args as a QR code
+func (lc *litAfClient) QR(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, qrCommand.Format)
+		fmt.Fprintf(color.Output, qrCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(qrCommand.Format)
+	}
+	text := strings.Join(textArgs, " ")
+	err := printQR(text)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", text)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var reservesCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("reserves"),
+		lnutil.ReqColor("cointype", "challenge"), lnutil.OptColor("file")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Sign for the wallet's confirmed utxos and open channel balances at its",
+		"current height, with the auditor's challenge in every signature, as json.",
+		"Written to file if given, else shown.  \"reserves verify file\" checks",
+		"the signatures and total of a proof; the outputs are up to you."),
+	ShortDescription: "Make or check a proof of reserves.\n",
+}
+
+// Reserves makes or verifies a proof of reserves
+func (lc *litAfClient) Reserves(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, reservesCommand.Format)
+		fmt.Fprintf(color.Output, reservesCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 2 {
+		return fmt.Errorf(reservesCommand.Format)
+	}
+
+	if textArgs[0] == "verify" {
+		b, err := ioutil.ReadFile(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args := new(litrpc.ReservesReply)
+		err = json.Unmarshal(b, &args.Proof)
+		if err != nil {
+			return err
+		}
+		reply := new(litrpc.StatusReply)
+		err = lc.rpccon.Call("LitRPC.VerifyReserves", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	coin, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	args := litrpc.ReservesArgs{CoinType: uint32(coin), Challenge: textArgs[1]}
+	reply := new(litrpc.ReservesReply)
+	err = lc.rpccon.Call("LitRPC.ProofOfReserves", args, reply)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(reply.Proof, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(textArgs) < 3 {
+		fmt.Fprintf(color.Output, "%s\n", b)
+		return nil
+	}
+	err = ioutil.WriteFile(textArgs[2], b, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "wrote proof of %s in %d utxos and %d channels at height %d to %s\n",
+		lnutil.SatoshiColor(reply.Proof.Total), len(reply.Proof.Utxos),
+		len(reply.Proof.Channels), reply.Proof.Height, textArgs[2])
+	return nil
+}
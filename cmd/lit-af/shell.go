@@ -90,6 +90,30 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return nil
 	}
 
+	if cmd == "checkdb" {
+		err = lc.CheckDB(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "checkdb error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "checkpoints" {
+		err = lc.Checkpoints(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "checkpoints error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "rollback" {
+		err = lc.Rollback(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "rollback error: %s\n", err)
+		}
+		return nil
+	}
+
 	if cmd == "off" { // stop remote node
 		// actually returns an error
 		return lc.Stop(args)
@@ -112,6 +136,23 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return nil
 	}
 
+	// pay a payment request or lightning address
+	if cmd == "pay" {
+		err = lc.Pay(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "pay error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "track" {
+		err = lc.Track(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "track error: %s\n", err)
+		}
+		return nil
+	}
+
 	if cmd == "con" { // connect to lnd host
 		err = lc.Connect(args)
 		if err != nil {
@@ -128,6 +169,46 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return nil
 	}
 
+	if cmd == "dryfund" {
+		err = lc.DryRunFund(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "dryfund error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "inbound" {
+		err = lc.RequestInbound(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "inbound error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "lease" {
+		err = lc.Lease(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "lease error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "fundext" {
+		err = lc.FundExternal(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "fundext error: %s\n", err)
+		}
+		return nil
+	}
+
+	if cmd == "bumpfund" {
+		err = lc.BumpFund(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "bumpfund error: %s\n", err)
+		}
+		return nil
+	}
+
 	// cooperateive close of a channel
 	if cmd == "close" {
 		err = lc.CloseChannel(args)
@@ -143,6 +224,62 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		}
 		return nil
 	}
+	if cmd == "askbreak" {
+		err = lc.AskBreak(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "askbreak error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "arbexport" {
+		err = lc.ExportArbitration(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "arbexport error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "reserves" {
+		err = lc.Reserves(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "reserves error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "replay" {
+		err = lc.Replay(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "replay error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "chanops" {
+		err = lc.ChanOps(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "chanops error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "commit" {
+		err = lc.DumpCommitment(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "commit error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "recoverkeys" {
+		err = lc.RecoverKeys(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "recoverkeys error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "watch" {
+		err = lc.Watch(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "watch error: %s\n", err)
+		}
+		return nil
+	}
 	if cmd == "say" {
 		err = lc.Say(args)
 		if err != nil {
@@ -150,6 +287,83 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		}
 		return nil
 	}
+	if cmd == "custom" {
+		err = lc.Custom(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "custom error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "invoice" {
+		err = lc.Invoice(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "invoice error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "policy" {
+		err = lc.Policy(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "policy error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "approve" {
+		err = lc.Approve(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "approve error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "audit" {
+		err = lc.Audit(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "audit error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "qr" {
+		err = lc.QR(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "qr error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "toweradmin" {
+		err = lc.TowerAdmin(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "toweradmin error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "mytowers" {
+		err = lc.MyTowers(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "mytowers error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "towerreport" {
+		err = lc.TowerReport(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "towerreport error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "peerreport" {
+		err = lc.PeerReport(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "peerreport error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "towers" {
+		err = lc.Towers(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "towers error: %s\n", err)
+		}
+		return nil
+	}
 
 	if cmd == "fan" { // fan-out tx
 		err = lc.Fan(args)
@@ -158,6 +372,41 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		}
 		return nil
 	}
+	if cmd == "timelock" {
+		err = lc.TimeLock(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "timelock error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "deferred" {
+		err = lc.Deferred(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "deferred error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "sweeptargets" {
+		err = lc.SweepTargets(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "sweeptargets error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "sweepkey" {
+		err = lc.SweepKey(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "sweepkey error: %s\n", err)
+		}
+		return nil
+	}
+	if cmd == "heir" {
+		err = lc.Heir(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "heir error: %s\n", err)
+		}
+		return nil
+	}
 	if cmd == "fee" { // get fee rate for a wallet
 		err = lc.Fee(args)
 		if err != nil {
@@ -165,6 +414,13 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		}
 		return nil
 	}
+	if cmd == "broadcasts" {
+		err = lc.Broadcasts(args)
+		if err != nil {
+			fmt.Fprintf(color.Output, "broadcasts error: %s\n", err)
+		}
+		return nil
+	}
 	if cmd == "dump" { // dump all private keys
 		err = lc.Dump(args)
 		if err != nil {
@@ -228,8 +484,28 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 	if len(pReply.Connections) > 0 {
 		fmt.Fprintf(color.Output, "\t%s\n", lnutil.Header("Peers:"))
 		for _, peer := range pReply.Connections {
-			fmt.Fprintf(color.Output, "%s %s\n",
+			fmt.Fprintf(color.Output, "%s %s",
 				lnutil.White(peer.PeerNumber), peer.RemoteHost)
+			if peer.Features != "" {
+				fmt.Fprintf(color.Output, " (%s)", peer.Features)
+			}
+			fmt.Fprintf(color.Output, "\n")
+			for _, l := range peer.Liquidity {
+				fmt.Fprintf(color.Output, "\t coin %d in: %s out: %s",
+					l.CoinType, lnutil.SatoshiColor(l.Inbound),
+					lnutil.SatoshiColor(l.Outbound))
+				if l.MaxOpen != 0 {
+					fmt.Fprintf(color.Output, " opens up to %s on request",
+						lnutil.SatoshiColor(l.MaxOpen))
+				}
+				fmt.Fprintf(color.Output, "\n")
+			}
+			for _, t := range peer.LeaseOffers {
+				fmt.Fprintf(color.Output,
+					"\t coin %d leases %s for %d blocks, fee %s\n",
+					t.CoinType, lnutil.SatoshiColor(t.Capacity), t.Blocks,
+					lnutil.SatoshiColor(t.Fee()))
+			}
 		}
 	}
 
@@ -254,6 +530,12 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 			lnutil.OutPoint(c.OutPoint),
 			lnutil.SatoshiColor(c.Capacity), lnutil.SatoshiColor(c.MyBalance),
 			c.Height, c.StateNum)
+		if c.ShortID != "" {
+			fmt.Fprintf(color.Output, "\t id: %s\n", c.ShortID)
+		}
+		if c.MyShutdown != "" {
+			fmt.Fprintf(color.Output, "\t closes to: %s\n", c.MyShutdown)
+		}
 	}
 
 	err = lc.rpccon.Call("LitRPC.TxoList", nil, tReply)
@@ -270,6 +552,9 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 		if t.Delay != 0 {
 			fmt.Fprintf(color.Output, " delay: %d", t.Delay)
 		}
+		if t.LockTime != 0 {
+			fmt.Fprintf(color.Output, " locked until: %d", t.LockTime)
+		}
 		if !t.Witty {
 			fmt.Fprintf(color.Output, " non-witness")
 		}
@@ -312,6 +597,11 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 			lnutil.Header("WitConf:"), lnutil.SatoshiColor(walBal.MatureWitty),
 			lnutil.Header("Channel:"), lnutil.SatoshiColor(walBal.ChanTotal),
 		)
+		if walBal.Reorged != 0 || walBal.SpendPending != 0 {
+			fmt.Fprintf(color.Output, "\t%s %s\t%s %s\n",
+				lnutil.Header("Reorged:"), lnutil.SatoshiColor(walBal.Reorged),
+				lnutil.Header("Spend pending:"), lnutil.SatoshiColor(walBal.SpendPending))
+		}
 	}
 
 	return nil
@@ -342,17 +632,52 @@ func (lc *litAfClient) Help(textArgs []string) error {
 		fmt.Fprintf(color.Output, "commands:\n")
 		fmt.Fprintf(color.Output, "%s\t%s", helpCommand.Format, helpCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", sayCommand.Format, sayCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", customCommand.Format, customCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", towersCommand.Format, towersCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", towerAdminCommand.Format, towerAdminCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", towerReportCommand.Format, towerReportCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", myTowersCommand.Format, myTowersCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", lsCommand.Format, lsCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", peerReportCommand.Format, peerReportCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", addressCommand.Format, addressCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", sendCommand.Format, sendCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", fanCommand.Format, fanCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", sweepCommand.Format, sweepCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", timeLockCommand.Format, timeLockCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", reservesCommand.Format, reservesCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", lisCommand.Format, lisCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", conCommand.Format, conCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", fundCommand.Format, fundCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", fundExtCommand.Format, fundExtCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", dryFundCommand.Format, dryFundCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", bumpFundCommand.Format, bumpFundCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", inboundCommand.Format, inboundCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", leaseCommand.Format, leaseCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", pushCommand.Format, pushCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", payCommand.Format, payCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", trackCommand.Format, trackCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", invoiceCommand.Format, invoiceCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", closeCommand.Format, closeCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", breakCommand.Format, breakCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", askBreakCommand.Format, askBreakCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", deferredCommand.Format, deferredCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", sweepTargetsCommand.Format, sweepTargetsCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", sweepKeyCommand.Format, sweepKeyCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", heirCommand.Format, heirCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", broadcastsCommand.Format, broadcastsCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", commitCommand.Format, commitCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", arbCommand.Format, arbCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", replayCommand.Format, replayCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", chanOpsCommand.Format, chanOpsCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", recoverKeysCommand.Format, recoverKeysCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", checkDBCommand.Format, checkDBCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", checkpointsCommand.Format, checkpointsCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", rollbackCommand.Format, rollbackCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", watchCommand.Format, watchCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", policyCommand.Format, policyCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", approveCommand.Format, approveCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", auditCommand.Format, auditCommand.ShortDescription)
+		fmt.Fprintf(color.Output, "%s\t%s", qrCommand.Format, qrCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", offCommand.Format, offCommand.ShortDescription)
 		fmt.Fprintf(color.Output, "%s\t%s", exitCommand.Format, exitCommand.ShortDescription)
 		return nil
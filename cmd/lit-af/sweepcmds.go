@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var sweepTargetsCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("sweeptargets"),
+		lnutil.OptColor("class"), lnutil.OptColor("blocks")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Show how many blocks each kind of tx lit sends by itself should confirm",
+		"in, and the fee rate that gets now.  Classes are justice, htlc, tolocal",
+		"and consolidate.  With a class and blocks, set its target until lit",
+		"restarts or reloads its config."),
+	ShortDescription: "Show or set confirmation targets for sweeps.\n",
+}
+
+func (lc *litAfClient) SweepTargets(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, sweepTargetsCommand.Format)
+		fmt.Fprintf(color.Output, sweepTargetsCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) > 0 {
+		if len(textArgs) < 2 {
+			return fmt.Errorf(sweepTargetsCommand.Format)
+		}
+		blocks, err := strconv.ParseInt(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		args := litrpc.SetSweepTargetArgs{Class: textArgs[0], Blocks: int32(blocks)}
+		reply := new(litrpc.StatusReply)
+		err = lc.rpccon.Call("LitRPC.SetSweepTarget", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	reply := new(litrpc.SweepTargetsReply)
+	err := lc.rpccon.Call("LitRPC.SweepTargets", litrpc.SweepTargetsArgs{}, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "coin %d\n", reply.CoinType)
+	for _, t := range reply.Targets {
+		fmt.Fprintf(color.Output, "%-12s %4d blocks  %s sat/byte\n",
+			t.Class, t.Blocks, lnutil.White(t.Rate))
+	}
+	return nil
+}
+
+var sweepKeyCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.White("sweepkey"),
+		lnutil.ReqColor("key"), lnutil.OptColor("fromheight"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Move everything paying a private key from outside the wallet, like a",
+		"paper wallet's, into the wallet in one tx.  The key is WIF or 32 bytes",
+		"of hex.  Blocks from fromheight (or where the headers start) on are",
+		"fetched to find its outputs, which takes a while from an old height;",
+		"give the height the key was made at if you know it."),
+	ShortDescription: "Sweep a private key's coins into the wallet.\n",
+}
+
+func (lc *litAfClient) SweepKey(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, sweepKeyCommand.Format)
+		fmt.Fprintf(color.Output, sweepKeyCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(sweepKeyCommand.Format)
+	}
+
+	args := litrpc.SweepKeyArgs{Key: textArgs[0]}
+	if len(textArgs) > 1 {
+		from, err := strconv.ParseInt(textArgs[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.From = int32(from)
+	}
+	if len(textArgs) > 2 {
+		coin, err := strconv.ParseUint(textArgs[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coin)
+	}
+
+	reply := new(litrpc.SweepKeyReply)
+	err := lc.rpccon.Call("LitRPC.SweepKey", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "swept %d outputs, %d sat, fee %d, in %s\n",
+		reply.Outputs, reply.Total, reply.Fee, lnutil.White(reply.Txid))
+	return nil
+}
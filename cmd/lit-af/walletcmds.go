@@ -18,8 +18,14 @@ var sendCommand = &Command{
 
 var addressCommand = &Command{
 	Format: fmt.Sprintf(
-		"%s%s\n", lnutil.White("adr"), lnutil.ReqColor("?amount", "?cointype")),
-	Description:      "Makes new addresses in a specified wallet.\n",
+		"%s%s%s%s%s\n", lnutil.White("adr"), lnutil.ReqColor("?amount", "?cointype"),
+		lnutil.OptColor("--type bech32|legacy"), lnutil.OptColor("--account n"),
+		lnutil.OptColor("--qr")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Makes new addresses in a specified wallet, showing each one's key path.",
+		"--type picks the kind of address (bech32 if not given), and --account",
+		"the account to make them in (0, the standard wallet, if not given).",
+		"--qr shows the first as a QR code.  An amount of 0 lists them all."),
 	ShortDescription: "Makes new addresses.\n",
 }
 
@@ -31,6 +37,16 @@ var fanCommand = &Command{
 	// TODO: Add description.
 }
 
+var timeLockCommand = &Command{
+	Format: fmt.Sprintf(
+		"%s%s\n", lnutil.White("timelock"), lnutil.ReqColor("cointype", "amount", "height")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Send amount to the wallet in an output that can't be spent until the",
+		"block after height.  It's listed in ls with its lock height until then.",
+		"Only this wallet knows the output's script; restoring from the seed won't find it."),
+	ShortDescription: "Lock coins in the wallet until a block height.\n",
+}
+
 var sweepCommand = &Command{
 	Format: fmt.Sprintf(
 		"%s%s%s\n", lnutil.White("sweep"),
@@ -40,6 +56,33 @@ var sweepCommand = &Command{
 	ShortDescription: "Move UTXOs with many 1-in-1-out txs.\n",
 }
 
+var checkDBCommand = &Command{
+	Format: fmt.Sprintf(
+		"%s%s\n", lnutil.White("checkdb"), lnutil.OptColor("repair")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Check the lit, wallet and watchtower databases for records which don't",
+		"deserialize or indexes which don't match.  With \"repair\", put back",
+		"missing index entries and drop utxos already spent; the rest is only reported."),
+	ShortDescription: "Check the databases for inconsistencies.\n",
+}
+
+var checkpointsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("checkpoints")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"List the copies of the lit DB made before force closes and migrations,",
+		"and whether each can still be rolled back."),
+	ShortDescription: "List DB checkpoints.\n",
+}
+
+var rollbackCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("rollback"), lnutil.ReqColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Put a channel back how it was at a checkpoint, undoing a force close",
+		"whose tx never went out.  Refused once the tx was broadcast, or if the",
+		"channel's moved on since."),
+	ShortDescription: "Undo a force close that wasn't broadcast.\n",
+}
+
 // Send sends coins somewhere
 func (lc *litAfClient) Send(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
@@ -114,6 +157,11 @@ func (lc *litAfClient) Sweep(textArgs []string) error {
 	if err != nil {
 		return err
 	}
+	if reply.Deferred != 0 {
+		fmt.Fprintf(color.Output,
+			"Fees over the ceiling; sweep deferred as #%d\n", reply.Deferred)
+		return nil
+	}
 	fmt.Fprintf(color.Output, "Swept\n")
 	for i, t := range reply.Txids {
 		fmt.Fprintf(color.Output, "%d %s\n", i, t)
@@ -168,6 +216,42 @@ func (lc *litAfClient) Fan(textArgs []string) error {
 	return nil
 }
 
+// TimeLock sends coins to the wallet, locked until a block height
+func (lc *litAfClient) TimeLock(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, timeLockCommand.Format)
+		fmt.Fprintf(color.Output, timeLockCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 3 {
+		return fmt.Errorf(timeLockCommand.Format)
+	}
+
+	coin, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	amt, err := strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	height, err := strconv.ParseInt(textArgs[2], 10, 32)
+	if err != nil {
+		return err
+	}
+	args := litrpc.TimeLockArgs{
+		CoinType: uint32(coin), Amt: amt, Height: int32(height)}
+	reply := new(litrpc.TxidsReply)
+	err = lc.rpccon.Call("LitRPC.TimeLock", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "locked %s until height %d in %s\n",
+		lnutil.SatoshiColor(amt), height, lnutil.OutPoint(reply.Txids[0]))
+	return nil
+}
+
 // ------------------ get / set fee
 
 func (lc *litAfClient) Fee(textArgs []string) error {
@@ -213,6 +297,9 @@ func (lc *litAfClient) Fee(textArgs []string) error {
 	}
 
 	fmt.Printf("Current fee rate %d sat / byte\n", reply.CurrentFee)
+	if reply.Ceiling != 0 {
+		fmt.Printf("Closes and sweeps wait while it's over %d\n", reply.Ceiling)
+	}
 
 	return nil
 }
@@ -254,6 +341,33 @@ func (lc *litAfClient) Address(textArgs []string) error {
 		return nil
 	}
 
+	textArgs, showQR := takeQRFlag(textArgs)
+
+	var adrType string
+	var account uint32
+	var rest []string
+	for i := 0; i < len(textArgs); i++ {
+		switch textArgs[i] {
+		case "--type", "--account":
+			if i+1 >= len(textArgs) {
+				return fmt.Errorf(addressCommand.Format)
+			}
+			if textArgs[i] == "--type" {
+				adrType = textArgs[i+1]
+			} else {
+				n, err := strconv.ParseUint(textArgs[i+1], 10, 31)
+				if err != nil {
+					return err
+				}
+				account = uint32(n)
+			}
+			i++
+		default:
+			rest = append(rest, textArgs[i])
+		}
+	}
+	textArgs = rest
+
 	var cointype, numadrs uint32
 
 	// if no arguments given, generate 1 new address.
@@ -284,6 +398,8 @@ func (lc *litAfClient) Address(textArgs []string) error {
 	args := new(litrpc.AddressArgs)
 	args.CoinType = cointype
 	args.NumToMake = numadrs
+	args.Type = adrType
+	args.Account = account
 
 	fmt.Printf("args: %v\n", args)
 	err := lc.rpccon.Call("LitRPC.Address", args, reply)
@@ -291,8 +407,107 @@ func (lc *litAfClient) Address(textArgs []string) error {
 		return err
 	}
 
-	fmt.Fprintf(color.Output, "new adr(s): %s\nold: %s\n",
-		lnutil.Address(reply.WitAddresses), lnutil.Address(reply.LegacyAddresses))
+	if showQR && len(reply.Addresses) > 0 {
+		err = printQR(reply.Addresses[0].Address)
+		if err != nil {
+			return err
+		}
+	}
+	if adrType == "" {
+		fmt.Fprintf(color.Output, "new adr(s): %s\nold: %s\n",
+			lnutil.Address(reply.WitAddresses), lnutil.Address(reply.LegacyAddresses))
+	}
+	for _, a := range reply.Addresses {
+		fmt.Fprintf(color.Output, "%s %s account %d path %s\n",
+			lnutil.Address(a.Address), a.Type, a.Account, a.Path)
+	}
+	return nil
+
+}
+
+// CheckDB checks the node's databases
+func (lc *litAfClient) CheckDB(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, checkDBCommand.Format)
+		fmt.Fprintf(color.Output, checkDBCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.CheckDBArgs)
+	reply := new(litrpc.CheckDBReply)
+	args.Repair = len(textArgs) > 0 && textArgs[0] == "repair"
+
+	err := lc.rpccon.Call("LitRPC.CheckDB", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range reply.Checks {
+		if len(c.Problems) == 0 {
+			fmt.Fprintf(color.Output, "%s db: %s\n", c.DB, lnutil.Green("OK"))
+			continue
+		}
+		fmt.Fprintf(color.Output, "%s db: %s\n", c.DB,
+			lnutil.Red(fmt.Sprintf("%d problems", len(c.Problems))))
+		for _, p := range c.Problems {
+			fmt.Fprintf(color.Output, "  %s\n", p)
+		}
+	}
 	return nil
+}
 
+// Checkpoints lists the DB checkpoints
+func (lc *litAfClient) Checkpoints(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, checkpointsCommand.Format)
+		fmt.Fprintf(color.Output, checkpointsCommand.Description)
+		return nil
+	}
+
+	reply := new(litrpc.CheckpointsReply)
+	err := lc.rpccon.Call("LitRPC.ListCheckpoints", litrpc.NoArgs{}, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Checkpoints) == 0 {
+		fmt.Fprintf(color.Output, "no checkpoints\n")
+		return nil
+	}
+	for _, cp := range reply.Checkpoints {
+		status := lnutil.Green("can roll back")
+		switch {
+		case cp.RolledBack:
+			status = "rolled back"
+		case cp.Broadcast != "":
+			status = lnutil.Red("broadcast " + cp.Broadcast)
+		case cp.Op == "":
+			status = "whole db; by hand"
+		}
+		fmt.Fprintf(color.Output, "%s %s %s\n\t%s\n", lnutil.White(cp.ID),
+			cp.Time.Format("2006-01-02 15:04:05"), cp.Label, status)
+	}
+	return nil
+}
+
+// Rollback puts a channel back to a checkpoint
+func (lc *litAfClient) Rollback(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, rollbackCommand.Format)
+		fmt.Fprintf(color.Output, rollbackCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(rollbackCommand.Format)
+	}
+
+	args := new(litrpc.RollbackArgs)
+	reply := new(litrpc.StatusReply)
+	args.ID = textArgs[0]
+
+	err := lc.rpccon.Call("LitRPC.Rollback", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
 }
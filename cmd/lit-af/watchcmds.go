@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var watchCommand = &Command{
+	Format: fmt.Sprintf(
+		"%s%s\n", lnutil.White("watch"), lnutil.OptColor("seconds")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show a dashboard of balances, sync status and channels,",
+		"redrawn every few seconds (default 10) and whenever something happens",
+		"to the node.  Ctrl-C goes back to the shell."),
+	ShortDescription: "Show a continuously updating dashboard.\n",
+}
+
+// clear the terminal and go to the top left
+const clearScreen = "\x1b[H\x1b[2J"
+
+// Watch redraws the dashboard until interrupted
+func (lc *litAfClient) Watch(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, watchCommand.Format)
+		fmt.Fprintf(color.Output, watchCommand.Description)
+		return nil
+	}
+	interval := int64(10)
+	if len(textArgs) > 0 {
+		secs, err := strconv.ParseInt(textArgs[0], 10, 64)
+		if err != nil || secs < 1 {
+			return fmt.Errorf("bad refresh interval %s", textArgs[0])
+		}
+		interval = secs
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+
+	// wait for events in the background; a timeout is just a redraw
+	done := make(chan struct{})
+	defer close(done)
+	events := make(chan *litrpc.WaitEventReply)
+	go func() {
+		for {
+			reply := new(litrpc.WaitEventReply)
+			err := lc.rawcon.Call(
+				"LitRPC.WaitEvent", litrpc.WaitEventArgs{Timeout: interval}, reply)
+			if err != nil {
+				// old node or lost connection; poll instead
+				reply.TimedOut = true
+				time.Sleep(time.Duration(interval) * time.Second)
+			}
+			select {
+			case events <- reply:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var last string
+	for {
+		lc.drawDashboard(last)
+		select {
+		case <-stop:
+			return nil
+		case ev := <-events:
+			if !ev.TimedOut {
+				last = fmt.Sprintf("%s %s chan %d peer %d %s",
+					time.Unix(ev.Event.Time, 0).Format("15:04:05"),
+					ev.Event.Type, ev.Event.ChanIdx, ev.Event.PeerIdx,
+					lnutil.SatoshiColor(ev.Event.Amt))
+			}
+		}
+	}
+}
+
+// drawDashboard clears the screen and draws everything once.  Errors are
+// shown in place of the section they broke.
+func (lc *litAfClient) drawDashboard(lastEvent string) {
+	out := color.Output
+	fmt.Fprintf(out, clearScreen)
+	fmt.Fprintf(out, "%s  %s\n\n", lnutil.Header("lit-af watch"),
+		time.Now().Format("2006-01-02 15:04:05"))
+
+	iReply := new(litrpc.InfoReply)
+	err := lc.rawcon.Call("LitRPC.GetInfo", litrpc.NoArgs{}, iReply)
+	if err != nil {
+		fmt.Fprintf(out, "info error: %s\n", lnutil.Red(err.Error()))
+	} else {
+		h := iReply.Health
+		state := lnutil.Green("healthy")
+		if !h.Healthy {
+			state = lnutil.Red("unhealthy")
+		}
+		fmt.Fprintf(out, "%s  %d peers  tower %v\n", state, h.Peers, h.TowerActive)
+		for _, c := range h.Coins {
+			sync := lnutil.Green("synced")
+			if !c.Synced {
+				sync = lnutil.Red("syncing")
+			}
+			fmt.Fprintf(out, "coin %d: %s %d / %d, %d pending sweeps\n",
+				c.CoinType, sync, c.SyncHeight, c.HeaderTip, c.PendingSweeps)
+		}
+		for _, l := range iReply.Liquidity {
+			fmt.Fprintf(out, "coin %d: inbound %s outbound %s",
+				l.CoinType, lnutil.SatoshiColor(l.Inbound),
+				lnutil.SatoshiColor(l.Outbound))
+			if l.MaxOpen != 0 {
+				fmt.Fprintf(out, ", opens up to %s on request",
+					lnutil.SatoshiColor(l.MaxOpen))
+			}
+			fmt.Fprintf(out, "\n")
+		}
+		for _, p := range h.Problems {
+			fmt.Fprintf(out, "\t%s\n", lnutil.Red(p))
+		}
+	}
+
+	fmt.Fprintf(out, "\n%s\n", lnutil.Header("Balances:"))
+	bReply := new(litrpc.BalanceReply)
+	err = lc.rawcon.Call("LitRPC.Balance", nil, bReply)
+	if err != nil {
+		fmt.Fprintf(out, "balance error: %s\n", lnutil.Red(err.Error()))
+	}
+	for _, b := range bReply.Balances {
+		fmt.Fprintf(out, "coin %d\tchannels %s\ttxos %s\tspendable %s\n",
+			b.CoinType, lnutil.SatoshiColor(b.ChanTotal),
+			lnutil.SatoshiColor(b.TxoTotal), lnutil.SatoshiColor(b.MatureWitty))
+	}
+
+	fmt.Fprintf(out, "\n%s\n", lnutil.Header("Channels:"))
+	cReply := new(litrpc.ChannelListReply)
+	err = lc.rawcon.Call("LitRPC.ChannelList", litrpc.ChanArgs{}, cReply)
+	if err != nil {
+		fmt.Fprintf(out, "channel error: %s\n", lnutil.Red(err.Error()))
+	}
+	for _, c := range cReply.Channels {
+		if c.Closed {
+			continue
+		}
+		fmt.Fprintf(out, "%s peer %d\tcap %s\tmine %s\tstate %d\n",
+			lnutil.White(c.CIdx), c.PeerIdx, lnutil.SatoshiColor(c.Capacity),
+			lnutil.SatoshiColor(c.MyBalance), c.StateNum)
+	}
+
+	if lastEvent != "" {
+		fmt.Fprintf(out, "\nlast event: %s\n", lastEvent)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+/*
+littower is for watchtower operators.  For now it has one mode:
+
+  littower bench [-channels N] [-states M] [-blocks B] [-blocktxs T]
+                 [-clients C] [-batch delay] [-dir folder] [-store spec] [-keep]
+
+which loads a throwaway tower with N made up channels of M states each,
+sent by C clients at once, and reports how fast states go in, how big the DB gets, and how long
+checking a block's txids takes, to size hardware before watching real
+channels.  See watchtower/bench.go.
+*/
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: littower bench [options]\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "bench":
+		err := bench(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench: %s\n", err.Error())
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func bench(args []string) error {
+	var cfg watchtower.BenchConfig
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.IntVar(&cfg.Channels, "channels", 1000, "channels to watch")
+	fs.IntVar(&cfg.States, "states", 100, "states per channel")
+	fs.IntVar(&cfg.Blocks, "blocks", 100, "blocks to check")
+	fs.IntVar(&cfg.BlockTxs, "blocktxs", 2500, "txids per block")
+	fs.IntVar(&cfg.Clients, "clients", 1, "clients sending states at once")
+	fs.DurationVar(&cfg.Batch, "batch", 0,
+		"longest a state waits to be committed with others; default 10ms, -1ns for none")
+	fs.StringVar(&cfg.Dir, "dir", "",
+		"folder for the bench tower; default a temp folder")
+	fs.StringVar(&cfg.Store, "store", "",
+		"txid store, bolt:<file> or redis://host:port; default in watch.db")
+	keep := fs.Bool("keep", false, "keep the bench tower's files")
+	verbose := fs.Bool("v", false, "show the tower and db logging")
+	fs.Parse(args)
+
+	if !*verbose {
+		lnutil.SetLogLevels("warn")
+	}
+	if cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "littower-bench")
+		if err != nil {
+			return err
+		}
+		cfg.Dir = dir
+	}
+	if !*keep {
+		defer os.RemoveAll(cfg.Dir)
+	}
+
+	res, err := watchtower.Bench(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	st := res.Stats
+	fmt.Printf("\n%d channels x %d states = %d states\n",
+		cfg.Channels, cfg.States, cfg.Channels*cfg.States)
+	fmt.Printf("AddMsg:   %.0f msg/s overall, %s for all, %d clients\n",
+		res.MsgsPerSec, res.AddTime, cfg.Clients)
+	if n := len(res.Samples); n > 1 {
+		fmt.Printf("          %.0f msg/s at the start, %.0f at the end\n",
+			res.Samples[0].MsgsPerSec, res.Samples[n-1].MsgsPerSec)
+	}
+	fmt.Printf("DB:       watch.db %d bytes, %.1f bytes per state\n",
+		st.DBBytes, res.BytesPerState)
+	fmt.Printf("          txids %d bytes, elkrems %d bytes (%d in ram)\n",
+		st.TxidBytes, st.ElkremBytes, st.ElkremMem)
+	if cfg.Blocks > 0 {
+		fmt.Printf("IngestTx: %s per block of %d, %s per txid, slowest %s\n",
+			res.PerBlock, cfg.BlockTxs, res.PerTx, res.SlowestBlock)
+		fmt.Printf("          %d of %d planted breaches found\n",
+			res.Hits, res.Planted)
+	}
+	if *keep {
+		fmt.Printf("bench tower kept in %s\n", cfg.Dir)
+	}
+	return nil
+}
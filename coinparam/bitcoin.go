@@ -117,6 +117,7 @@ var TestNet3Params = Params{
 		0xbf, 0x96, 0x46,
 	},
 	StartHeight:              1032192,
+	BirthHeight:              1210000,
 	FeePerByte:               80,
 	PowLimit:                 testNet3PowLimit,
 	PowLimitBits:             0x1d00ffff,
@@ -177,6 +178,7 @@ var RegressionNetParams = Params{
 	GenesisHash:      &regTestGenesisHash,
 	PoWFunction:      chainhash.DoubleHashH,
 	DiffCalcFunction: diffBitcoin,
+	BirthHeight:      120,
 	//	func(r io.ReadSeeker, height, startheight int32, p *Params) (uint32, error) {
 	//		return diffBTC(r, height, startheight, p, false)
 	//	},
@@ -39,6 +39,7 @@ var LiteCoinTestNet4Params = Params{
 		0xf0, 0xff, 0x0f, 0x1e, 0xe1, 0x79, 0x04, 0x00,
 	},
 	StartHeight:              48384,
+	BirthHeight:              48384,
 	AssumeDiffBefore:         50401,
 	FeePerByte:               800,
 	PowLimit:                 liteCoinTestNet4PowLimit,
@@ -99,6 +100,7 @@ var LiteRegNetParams = Params{
 		return *asChainHash
 	},
 	DiffCalcFunction:         diffBitcoin,
+	BirthHeight:              120,
 	FeePerByte:               800,
 	PowLimit:                 regressionPowLimit,
 	PowLimitBits:             0x207fffff,
@@ -2,7 +2,6 @@ package coinparam
 
 import (
 	"errors"
-	"log"
 	"math/big"
 	"time"
 
@@ -48,6 +47,10 @@ type Params struct {
 	// The height of the StartHash
 	StartHeight int32
 
+	// Height new wallets start looking for their transactions at, unless
+	// told otherwise.  Should be at or after StartHeight.
+	BirthHeight int32
+
 	// Assume the difficulty bits are valid before this header height
 	// This is needed for coins with variable retarget lookbacks that use
 	// StartHeader to offset the beginning of the header chain for SPV
@@ -214,6 +217,7 @@ func Register(params *Params) error {
 		return ErrDuplicateNet
 	}
 	registeredNets[params.HDCoinType] = struct{}{}
+	registeredParams = append(registeredParams, params)
 	bech32Prefixes[params.Bech32Prefix] = params.HDCoinType
 	pubKeyHashAddrIDs[params.PubKeyHashAddrID] = struct{}{}
 	scriptHashAddrIDs[params.ScriptHashAddrID] = struct{}{}
@@ -249,7 +253,6 @@ var (
 // If that prefix isn't registered, it returns an error.
 func PrefixToCoinType(prefix string) (uint32, error) {
 	coinType, ok := bech32Prefixes[prefix]
-	log.Printf("wow: ")
 	if !ok {
 		return 0, ErrUnknownPrefix
 	}
@@ -0,0 +1,63 @@
+package coinparam
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Everything lit needs to know about a chain is in its Params.  To add a
+new bitcoin-like coin, fill in a Params (see vertcoin.go for one that
+isn't bitcoin) and Register it from an init func.  Lit can then run a
+wallet for it with coin=<Name>=host on the command line or in lit.conf,
+and addresses for it will be recognized, without touching wallit or uspv.
+
+HDCoinType is the coin's identity everywhere else in lit, so it needs to
+be unique.
+*/
+
+// ErrUnknownCoin is returned when looking up a coin that isn't registered
+var ErrUnknownCoin = errors.New("unknown coin")
+
+// in the order they were registered
+var registeredParams []*Params
+
+// Registered returns the params for all registered coins, in the order
+// they were registered.
+func Registered() []*Params {
+	ps := make([]*Params, len(registeredParams))
+	copy(ps, registeredParams)
+	return ps
+}
+
+// ByCoinType returns the registered params with the given HDCoinType
+func ByCoinType(coinType uint32) (*Params, error) {
+	for _, p := range registeredParams {
+		if p.HDCoinType == coinType {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%v: cointype %d", ErrUnknownCoin, coinType)
+}
+
+// ByName returns the registered params with the given Name
+func ByName(name string) (*Params, error) {
+	for _, p := range registeredParams {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%v: %s", ErrUnknownCoin, name)
+}
+
+// ByAddrID returns the first registered params using id as the version
+// byte of old style (base58) pubkey hash or script hash addresses.  Lots
+// of test networks share these, so this is only a guess.
+func ByAddrID(id byte) (*Params, error) {
+	for _, p := range registeredParams {
+		if p.PubKeyHashAddrID == id || p.ScriptHashAddrID == id {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%v: address version %x", ErrUnknownCoin, id)
+}
@@ -19,6 +19,7 @@ var VertcoinTestNetParams = Params{
 
 	// Chain parameters
 	DiffCalcFunction: diffVTCdummy,
+	BirthHeight:      0,
 	FeePerByte:       800,
 	GenesisBlock:     &VertcoinTestnetGenesisBlock,
 	GenesisHash:      &VertcoinTestnetGenesisHash,
@@ -88,6 +89,7 @@ var VertcoinParams = Params{
 		0x08, 0x58, 0x3f, 0xf4, 0x4d, 0x1b, 0x42, 0x22, 0x6e, 0x8a,
 	},
 	StartHeight:      598752,
+	BirthHeight:      598752,
 	AssumeDiffBefore: 602784,
 	DiffCalcFunction: diffVTCdummy,
 	FeePerByte:       800,
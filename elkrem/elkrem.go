@@ -149,3 +149,105 @@ func (e *ElkremReceiver) UpTo() uint64 {
 	}
 	return e.s[len(e.s)-1].i
 }
+
+// ReceiverStats is how big a receiver is
+type ReceiverStats struct {
+	Nodes        int    // hashes stored
+	UpTo         uint64 // last index received
+	Bytes        int    // serialized size
+	CompactBytes int    // serialized size in the compact format
+	MemBytes     int    // roughly how much ram it takes
+}
+
+// ram per stored hash: the node (height, index, pointer) and the hash
+const nodeMemBytes = 24 + 32
+
+// Stats says how much space the receiver takes.  It's at most 48 hashes
+// however many states there are.
+func (e *ElkremReceiver) Stats() ReceiverStats {
+	var st ReceiverStats
+	st.Nodes = len(e.s)
+	st.UpTo = e.UpTo()
+	if st.Nodes > 0 {
+		st.Bytes = 1 + 41*st.Nodes
+		st.CompactBytes = 9 + 32*st.Nodes
+	}
+	st.MemBytes = 24 + nodeMemBytes*cap(e.s) // slice header and backing array
+	return st
+}
+
+// Verify checks the receiver's stack is what AddNext would have built: the
+// right nodes at the right heights and indexes for how many hashes it has,
+// all with hashes, and that every one can be descended to its leaves.
+// The hashes themselves can only be checked against each other as they
+// come in, in AddNext; this catches a receiver mangled after that.
+func (e *ElkremReceiver) Verify() error {
+	if e == nil {
+		return fmt.Errorf("nil elkrem receiver")
+	}
+	if len(e.s) == 0 {
+		return nil
+	}
+	shape := stackShape(e.UpTo() + 1)
+	if len(shape) != len(e.s) {
+		return fmt.Errorf("%d nodes up to %d, expect %d",
+			len(e.s), e.UpTo(), len(shape))
+	}
+	var count uint64
+	for j, n := range e.s {
+		count += uint64(1)<<uint(shape[j]+1) - 1
+		if n.h != shape[j] || n.i != count-1 {
+			return fmt.Errorf("node %d is index %d height %d, expect %d height %d",
+				j, n.i, n.h, count-1, shape[j])
+		}
+		if n.sha == nil {
+			return fmt.Errorf("node %d has nil hash", n.i)
+		}
+		// leftmost leaf is the longest way down
+		_, err := descend(n.i+1-(uint64(1)<<uint(n.h+1)-1), n.i, n.h, *n.sha)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtIndexBatch returns hashes start through end inclusive.  Each stored
+// node's subtree is expanded once, so it's about one hash per index rather
+// than up to 48 each.
+func (e *ElkremReceiver) AtIndexBatch(start, end uint64) ([]*chainhash.Hash, error) {
+	if e == nil || len(e.s) == 0 {
+		return nil, fmt.Errorf("nil elkrem receiver")
+	}
+	if start > end {
+		return nil, fmt.Errorf("start %d after end %d", start, end)
+	}
+	if end > e.UpTo() {
+		return nil, fmt.Errorf("receiver has max %d, less than requested %d",
+			e.UpTo(), end)
+	}
+	out := make([]*chainhash.Hash, 0, end-start+1)
+	for _, n := range e.s {
+		out = expand(n.i, n.h, *n.sha, start, end, out)
+	}
+	return out, nil
+}
+
+// expand appends the hashes in the subtree at index i, height h which are
+// in [lo, hi], in index order: left subtree, right subtree, then the root.
+func expand(i uint64, h uint8, sha chainhash.Hash,
+	lo, hi uint64, out []*chainhash.Hash) []*chainhash.Hash {
+	first := i + 1 - (uint64(1)<<uint(h+1) - 1) // leftmost leaf
+	if i < lo || first > hi {
+		return out
+	}
+	if h > 0 {
+		out = expand(i-(1<<h), h-1, LeftSha(sha), lo, hi, out)
+		out = expand(i-1, h-1, RightSha(sha), lo, hi, out)
+	}
+	if i <= hi && i >= lo {
+		s := sha
+		out = append(out, &s)
+	}
+	return out
+}
@@ -99,3 +99,49 @@ func TestFixed(t *testing.T) {
 	}
 
 }
+
+// TestBatchVerify checks AtIndexBatch against AtIndex, and that Verify
+// catches a mangled stack
+func TestBatchVerify(t *testing.T) {
+	sndr := NewElkremSender(chainhash.DoubleHashH([]byte("elkbatch")))
+	var rcv ElkremReceiver
+	for n := uint64(0); n < 1000; n++ {
+		sha, err := sndr.AtIndex(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rcv.AddNext(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n%97 == 0 {
+			err = rcv.Verify()
+			if err != nil {
+				t.Fatalf("state %d: %s", n, err.Error())
+			}
+		}
+	}
+
+	shas, err := rcv.AtIndexBatch(3, 998)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shas) != 996 {
+		t.Fatalf("got %d hashes, expect 996", len(shas))
+	}
+	for j, sha := range shas {
+		want, _ := sndr.AtIndex(uint64(j) + 3)
+		if !sha.IsEqual(want) {
+			t.Fatalf("batch index %d is %s, expect %s", j+3, sha, want)
+		}
+	}
+	_, err = rcv.AtIndexBatch(0, 1000)
+	if err == nil {
+		t.Fatalf("batch past end worked")
+	}
+
+	rcv.s[1].i++
+	if rcv.Verify() == nil {
+		t.Fatalf("Verify missed a bad index")
+	}
+}
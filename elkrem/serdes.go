@@ -12,8 +12,20 @@ import (
 Senders turn into 41 byte long slices.  Receivers are variable length,
 with 41 bytes for each stored hash, up to a maximum of 48.  Receivers are
 prepended with the total number of hashes, so the total max size is 1969 bytes.
+
+The compact receiver format leaves out the heights and indexes, since
+they're fully determined by how many hashes have been received: 0xff, then
+the 8 byte index of the last hash, then the 32 byte hashes.  That's 9 bytes
+plus 32 per stored hash, max 1545.  ElkremReceiverFromBytes reads either.
+
+Receivers don't grow past 48 hashes however many states there are, and
+every stored hash is needed to get back to some earlier state, so there's
+nothing to prune; compacting is as small as they get.
 */
 
+// compactMarker starts a compact receiver; never a valid node count
+const compactMarker = 0xff
+
 // ToBytes turns the Elkrem Receiver into a bunch of bytes in a slice.
 // first the number of nodes (1 byte), then a series of 41 byte long
 // serialized nodes, which are 1 byte height, 8 byte index, 32 byte hash.
@@ -64,11 +76,83 @@ func (e *ElkremReceiver) ToBytes() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ToCompactBytes serializes the receiver in the compact format
+func (e *ElkremReceiver) ToCompactBytes() ([]byte, error) {
+	if len(e.s) == 0 {
+		return nil, nil
+	}
+	shape := stackShape(e.UpTo() + 1)
+	if len(shape) != len(e.s) {
+		return nil, fmt.Errorf("receiver up to %d has %d nodes, expect %d",
+			e.UpTo(), len(e.s), len(shape))
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(compactMarker)
+	binary.Write(&buf, binary.BigEndian, e.UpTo())
+	for _, node := range e.s {
+		if node.sha == nil {
+			return nil, fmt.Errorf("node %d has nil hash", node.i)
+		}
+		buf.Write(node.sha.CloneBytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// stackShape returns the heights of the nodes a receiver holds after n
+// hashes.  Each node is the root of a full subtree of 2**(h+1)-1 hashes,
+// biggest first, and only the smallest can be repeated.
+func stackShape(n uint64) []uint8 {
+	var shape []uint8
+	for h := int(maxHeight); h >= 0; h-- {
+		size := uint64(1)<<uint(h+1) - 1
+		for n >= size {
+			shape = append(shape, uint8(h))
+			n -= size
+		}
+	}
+	return shape
+}
+
+// receiverFromCompact reads the compact format, filling in the heights
+// and indexes
+func receiverFromCompact(b []byte) (*ElkremReceiver, error) {
+	var e ElkremReceiver
+	if len(b) < 9 {
+		return nil, fmt.Errorf("compact receiver %d bytes, too short", len(b))
+	}
+	upTo := binary.BigEndian.Uint64(b[1:9])
+	if upTo > maxIndex {
+		return nil, fmt.Errorf("compact receiver up to %d, max %d", upTo, maxIndex)
+	}
+	shape := stackShape(upTo + 1)
+	if len(b) != 9+32*len(shape) {
+		return nil, fmt.Errorf("compact receiver up to %d is %d bytes, expect %d",
+			upTo, len(b), 9+32*len(shape))
+	}
+	e.s = make([]ElkremNode, len(shape))
+	var count uint64 // hashes covered so far
+	for j, h := range shape {
+		count += uint64(1)<<uint(h+1) - 1
+		e.s[j].h = h
+		e.s[j].i = count - 1
+		e.s[j].sha = new(chainhash.Hash)
+		err := e.s[j].sha.SetBytes(b[9+32*j : 9+32*(j+1)])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// ElkremReceiverFromBytes reads a receiver in either format
 func ElkremReceiverFromBytes(b []byte) (*ElkremReceiver, error) {
 	var e ElkremReceiver
 	if len(b) == 0 { // empty receiver, which is OK
 		return &e, nil
 	}
+	if b[0] == compactMarker {
+		return receiverFromCompact(b)
+	}
 	buf := bytes.NewBuffer(b)
 	// read 1 byte number of nodes stored in receiver
 	numOfNodes, err := buf.ReadByte()
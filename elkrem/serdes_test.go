@@ -3,6 +3,8 @@ package elkrem
 import (
 	"bytes"
 	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 )
 
 func ReceiverSerdesTest(t *testing.T, rcv *ElkremReceiver) {
@@ -49,3 +51,65 @@ func ReceiverSerdesTest(t *testing.T, rcv *ElkremReceiver) {
 //		t.Fatalf("First and second serializations different")
 //	}
 //}
+
+// TestCompact checks the compact format round trips and reads back the
+// same receiver, for every stack shape up to a few hundred states
+func TestCompact(t *testing.T) {
+	sndr := NewElkremSender(chainhash.DoubleHashH([]byte("elkcompact")))
+	var rcv ElkremReceiver
+	for n := uint64(0); n < 300; n++ {
+		sha, err := sndr.AtIndex(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rcv.AddNext(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cb, err := rcv.ToCompactBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := rcv.Stats()
+		if len(cb) != st.CompactBytes {
+			t.Fatalf("state %d: compact %d bytes, stats say %d",
+				n, len(cb), st.CompactBytes)
+		}
+		rcv2, err := ElkremReceiverFromBytes(cb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := rcv.ToBytes()
+		b2, err := rcv2.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b2) {
+			t.Fatalf("state %d: compact round trip changed receiver", n)
+		}
+		if len(b) != st.Bytes {
+			t.Fatalf("state %d: %d bytes, stats say %d", n, len(b), st.Bytes)
+		}
+	}
+	// read back one still works for old states
+	cb, _ := rcv.ToCompactBytes()
+	rcv2, err := ElkremReceiverFromBytes(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := rcv2.AtIndex(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sndr.AtIndex(17)
+	if !sha.IsEqual(want) {
+		t.Fatalf("got %s for 17, expect %s", sha, want)
+	}
+
+	// wrong length for the index
+	_, err = ElkremReceiverFromBytes(cb[:len(cb)-32])
+	if err == nil {
+		t.Fatalf("short compact receiver read OK")
+	}
+}
@@ -0,0 +1,313 @@
+package litbamf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/litrpc"
+)
+
+/*
+The HTTP API the web UI talks to, under /api/v1.  Everything is json.
+
+	POST /api/v1/login          {"Token": "..."}  ->  {"CSRF": "..."}
+	                            sets the session cookie
+	GET  /api/v1/session        ->  {"CSRF": "..."} if logged in, else 401
+	POST /api/v1/logout
+	POST /api/v1/call/<Method>  body is the args of LitRPC.<Method>, the
+	                            same as over /ws; replies with its reply
+	GET  /api/v1/events         server-sent events, one "data: <NodeEvent
+	                            json>" per node event, typed by event type
+	GET  /api/v1/qr             QR codes; see qr.go
+
+The token is in <lit dir>/bamf.token, made the first time lit runs with
+this, and is what you paste into the UI to log in; delete the file to
+change it.  Logging in gives a session cookie (http only, same site) and a
+CSRF token, which every POST after login has to send back in the
+X-CSRF-Token header.  Sessions last sessionLifetime from their last use.
+
+Errors come back as {"Error": "..."}: 401 not logged in, 403 bad CSRF
+token, 400 bad request or the RPC returned an error.
+*/
+
+const (
+	apiPrefix       = "/api/v1/"
+	tokenFileName   = "bamf.token"
+	sessionCookie   = "bamf_session"
+	csrfHeader      = "X-CSRF-Token"
+	sessionLifetime = 12 * time.Hour
+
+	// sse comment sent this often so proxies don't close idle streams
+	eventKeepalive = 30 * time.Second
+)
+
+type session struct {
+	csrf    string
+	expires time.Time
+}
+
+// apiServer serves the API for one node
+type apiServer struct {
+	rpcl  *litrpc.LitRPC
+	token string
+
+	// calls go through net/rpc in process, so the args and replies are
+	// exactly what /ws gives
+	client *rpc.Client
+
+	mtx      sync.Mutex
+	sessions map[string]*session
+}
+
+func newAPIServer(rpcl *litrpc.LitRPC, litHomeDir string) (*apiServer, error) {
+	token, err := loadToken(filepath.Join(litHomeDir, tokenFileName))
+	if err != nil {
+		return nil, err
+	}
+	srv := rpc.NewServer()
+	err = srv.RegisterName("LitRPC", rpcl)
+	if err != nil {
+		return nil, err
+	}
+	us, them := net.Pipe()
+	go srv.ServeCodec(rpcl.ServerCodec(jsonrpc.NewServerCodec(them), "api"))
+
+	return &apiServer{
+		rpcl:     rpcl,
+		token:    token,
+		client:   jsonrpc.NewClient(us),
+		sessions: make(map[string]*session),
+	}, nil
+}
+
+// loadToken reads the login token, making one if there isn't one yet
+func loadToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("%s is empty", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	token := randHex(32)
+	err = ioutil.WriteFile(path, []byte(token+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("made litbamf login token in %s\n", path)
+	return token, nil
+}
+
+func randHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err) // no randomness, no point going on
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *apiServer) register(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"login", s.serveLogin)
+	mux.HandleFunc(apiPrefix+"session", s.serveSession)
+	mux.HandleFunc(apiPrefix+"logout", s.serveLogout)
+	mux.HandleFunc(apiPrefix+"call/", s.serveCall)
+	mux.HandleFunc(apiPrefix+"events", s.serveEvents)
+	mux.HandleFunc(apiPrefix+"qr", s.serveQR)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeErr(w http.ResponseWriter, status int, err string) {
+	writeJSON(w, status, struct{ Error string }{err})
+}
+
+// getSession returns the request's session and its id, extending it, or
+// nil if there isn't a live one
+func (s *apiServer) getSession(r *http.Request) (string, *session) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	// drop expired ones while we're here
+	for id, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	sess, ok := s.sessions[c.Value]
+	if !ok {
+		return "", nil
+	}
+	sess.expires = now.Add(sessionLifetime)
+	return c.Value, sess
+}
+
+// authed checks for a session, and for POSTs the CSRF token, writing the
+// error if either is missing
+func (s *apiServer) authed(w http.ResponseWriter, r *http.Request) bool {
+	_, sess := s.getSession(r)
+	if sess == nil {
+		writeErr(w, http.StatusUnauthorized, "not logged in")
+		return false
+	}
+	if r.Method != http.MethodGet &&
+		subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get(csrfHeader)), []byte(sess.csrf)) != 1 {
+		writeErr(w, http.StatusForbidden, "bad or missing "+csrfHeader)
+		return false
+	}
+	return true
+}
+
+func (s *apiServer) serveLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	var args struct{ Token string }
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(args.Token), []byte(s.token)) != 1 {
+		time.Sleep(time.Second) // slow down guessing
+		writeErr(w, http.StatusUnauthorized, "wrong token")
+		return
+	}
+	id := randHex(32)
+	sess := &session{csrf: randHex(32), expires: time.Now().Add(sessionLifetime)}
+	s.mtx.Lock()
+	s.sessions[id] = sess
+	s.mtx.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, http.StatusOK, struct{ CSRF string }{sess.csrf})
+}
+
+func (s *apiServer) serveSession(w http.ResponseWriter, r *http.Request) {
+	_, sess := s.getSession(r)
+	if sess == nil {
+		writeErr(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{ CSRF string }{sess.csrf})
+}
+
+func (s *apiServer) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	if !s.authed(w, r) {
+		return
+	}
+	id, _ := s.getSession(r)
+	s.mtx.Lock()
+	delete(s.sessions, id)
+	s.mtx.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// serveCall calls LitRPC.<Method> with the body as args
+func (s *apiServer) serveCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	if !s.authed(w, r) {
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, apiPrefix+"call/")
+	if method == "" || strings.Contains(method, "/") {
+		writeErr(w, http.StatusNotFound, "no method given")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	args := json.RawMessage(body)
+	if len(strings.TrimSpace(string(body))) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var reply json.RawMessage
+	err = s.client.Call("LitRPC."+method, args, &reply)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// serveEvents streams node events until the client goes away
+func (s *apiServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !s.authed(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErr(w, http.StatusInternalServerError, "can't stream")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := s.rpcl.Node.SubscribeEvents()
+	defer s.rpcl.Node.UnsubscribeEvents(sub)
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+		case ev := <-sub:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+		}
+		flusher.Flush()
+	}
+}
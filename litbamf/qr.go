@@ -0,0 +1,119 @@
+package litbamf
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/qr"
+)
+
+/*
+GET /api/v1/qr draws a QR code, as svg, or png with format=png.  What it
+draws is one of:
+
+	data=<text>       the text
+	address=<coin>    a new receive address for that coin (0 for default)
+	node=1            this node's lit address, @host:port if listening on one
+	invoice=<hash>    the payment request of the invoice with that hash
+
+scale is pixels (png) or units (svg) per module; the default is 4.
+*/
+
+// most that can go in data, so nobody makes us draw something huge
+const qrMaxData = 2048
+
+func (s *apiServer) serveQR(w http.ResponseWriter, r *http.Request) {
+	if !s.authed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	text, err := s.qrText(q.Get("data"), q.Get("address"), q.Get("node"), q.Get("invoice"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scale := 4
+	if sc := q.Get("scale"); sc != "" {
+		scale, err = strconv.Atoi(sc)
+		if err != nil || scale < 1 || scale > 32 {
+			writeErr(w, http.StatusBadRequest, "scale should be 1 to 32")
+			return
+		}
+	}
+
+	code, err := qr.EncodeString(text)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch q.Get("format") {
+	case "", "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, code.SVG(scale))
+	case "png":
+		img, err := code.PNG(scale)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	default:
+		writeErr(w, http.StatusBadRequest, "format should be svg or png")
+	}
+}
+
+// qrText works out what to draw; exactly one of the args should be set
+func (s *apiServer) qrText(data, address, node, invoice string) (string, error) {
+	switch {
+	case data != "":
+		if len(data) > qrMaxData {
+			return "", fmt.Errorf("data over %d bytes", qrMaxData)
+		}
+		return data, nil
+
+	case address != "":
+		coin, err := strconv.ParseUint(address, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad coin type %s", address)
+		}
+		reply := new(litrpc.AddressReply)
+		err = s.rpcl.Address(
+			&litrpc.AddressArgs{NumToMake: 1, CoinType: uint32(coin)}, reply)
+		if err != nil {
+			return "", err
+		}
+		if len(reply.WitAddresses) == 0 {
+			return "", fmt.Errorf("no address made")
+		}
+		return reply.WitAddresses[0], nil
+
+	case node != "":
+		reply := new(litrpc.ListeningPortsReply)
+		err := s.rpcl.GetListeningPorts(litrpc.NoArgs{}, reply)
+		if err != nil {
+			return "", err
+		}
+		// a bare :port doesn't say where to connect
+		for _, lis := range reply.LisIpPorts {
+			if !strings.HasPrefix(lis, ":") {
+				return reply.Adr + "@" + lis, nil
+			}
+		}
+		return reply.Adr, nil
+
+	case invoice != "":
+		reply := new(litrpc.InvoiceReply)
+		err := s.rpcl.LookupInvoice(
+			litrpc.InvoiceHashArgs{PaymentHash: invoice}, reply)
+		if err != nil {
+			return "", err
+		}
+		return reply.Invoice.PayReq, nil
+	}
+	return "", fmt.Errorf("give one of data, address, node or invoice")
+}
@@ -1,29 +1,35 @@
 package litbamf
 
 import (
-	"fmt"
 	"net/http"
 	"regexp"
-)
+	"strings"
 
-func BamfListen(bamfport uint16, litHomeDir string) {
-	listenString := fmt.Sprintf("http://127.0.0.1:%d", bamfport)
+	"github.com/mit-dci/lit/litrpc"
+)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		jsExpr, _ := regexp.Compile("/js.*")
-		imagesExpr, _ := regexp.Compile("/images.*")
-		styleExpr, _ := regexp.Compile("/style.*")
+var staticExpr = regexp.MustCompile("^/(js|images|style)")
 
-		path := []byte(r.URL.Path)
+// BamfListen adds the web UI and its API (see api.go) to the default mux,
+// which the RPC listener serves.  Anything that isn't a static file or
+// the API gets index.html, so the UI can do its own routing.
+func BamfListen(rpcl *litrpc.LitRPC, litHomeDir string) error {
+	api, err := newAPIServer(rpcl, litHomeDir)
+	if err != nil {
+		return err
+	}
+	api.register(http.DefaultServeMux)
 
-		if r.URL.Path == "/ws" {
+	files := http.FileServer(http.Dir(litHomeDir + "/litbamf/"))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
 			return
-		} else if jsExpr.Match(path) || imagesExpr.Match(path) || styleExpr.Match(path) {
-		} else {
+		}
+		if !staticExpr.MatchString(r.URL.Path) {
 			r.URL.Path = "/"
 		}
-
-		http.FileServer(http.Dir(litHomeDir+"/litbamf/")).ServeHTTP(w, r)
+		files.ServeHTTP(w, r)
 	})
-	go http.ListenAndServe(listenString, nil)
+	return nil
 }
@@ -2,12 +2,13 @@ package litrpc
 
 import (
 	"log"
-	"strings"
 
 	"github.com/adiabat/bech32"
 	"github.com/adiabat/btcd/chaincfg"
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcutil"
+	"github.com/adiabat/btcutil/base58"
+	"github.com/mit-dci/lit/coinparam"
 )
 
 /*
@@ -58,18 +59,17 @@ func CoinTypeFromAdr(adr string) uint32 {
 		// well that's not even an address
 		return 12345
 	}
-	if strings.HasPrefix(adr, "m") || strings.HasPrefix(adr, "n") {
-		// guess testnet; could be regtest
+	// old style addresses; go by the version byte.  Testnets mostly
+	// share version bytes, so this will guess testnet for regtest
+	_, version, err := base58.CheckDecode(adr)
+	if err != nil {
+		return 1
+	}
+	p, err := coinparam.ByAddrID(version)
+	if err != nil {
 		return 1
 	}
-  if strings.HasPrefix(adr, "V") {
-    return 28
-  }
-  if strings.HasPrefix(adr, "X") || strings.HasPrefix(adr, "W") {
-    return 65536
-  }
-	// add other prefixes here...
-	return 1
+	return p.HDCoinType
 }
 
 // Gives the cointype from an address string (if known)
@@ -78,5 +78,5 @@ func CoinTypeFromBechAdr(adr string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	return chaincfg.PrefixToCoinType(hrp)
+	return coinparam.PrefixToCoinType(hrp)
 }
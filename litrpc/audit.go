@@ -0,0 +1,287 @@
+package litrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+With rpcaudit set, every RPC call is written to audit.log in the lit dir:
+when, who, which method, its arguments, and how it went.  It's for
+security reviews: what was done to the node, and from where.
+
+Who is how the call came in: "tcp <address>" or "unix" for /ws and the
+unix socket, "api" for litbamf's token logins, plus the account if it was
+to one.  There's no other login to go by (see unixsock.go).
+
+Arguments are written as json with anything secret in them replaced:
+fields named like keys, WIFs, preimages, approvals, passwords, tokens and
+seeds.  Replies aren't written at all, since some have keys in them.
+
+The log's only ever appended to, one json line per call, and rotates like
+lit.log, keeping auditLogKeep old files.  AuditLog reads it back; an
+account only sees calls made to it.  Calls are logged after they're
+answered, so a call that never returns (lit stopping, say) isn't there.
+*/
+
+const (
+	auditLogName    = "audit.log"
+	auditLogMaxSize = 10 * 1024 * 1024
+	auditLogKeep    = 10
+)
+
+// field names in arguments that never go in the audit log, lower case.
+// Fields with any of auditSecretParts in their name don't either.
+var (
+	auditSecretFields = map[string]bool{
+		"key": true, "wif": true, "preimage": true, "approval": true,
+	}
+	auditSecretParts = []string{
+		"priv", "secret", "passw", "token", "seed", "mnemonic",
+	}
+)
+
+// AuditEntry is one RPC call
+type AuditEntry struct {
+	Time    int64  // unix nanoseconds it came in
+	Caller  string // how it came in
+	Account string `json:",omitempty"`
+	Method  string
+	Params  json.RawMessage `json:",omitempty"` // arguments, secrets taken out
+	OK      bool
+	Err     string `json:",omitempty"`
+	Millis  int64  // how long it took to answer
+}
+
+// AuditLog is where RPC calls are written
+type AuditLog struct {
+	log *lnutil.RotatingLog
+	mtx sync.Mutex
+}
+
+// OpenAuditLog opens, or starts, the audit log in dir
+func OpenAuditLog(dir string) (*AuditLog, error) {
+	l, err := lnutil.NewRotatingLog(
+		filepath.Join(dir, auditLogName), auditLogMaxSize, auditLogKeep)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{log: l}, nil
+}
+
+func (a *AuditLog) write(e AuditEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("audit %s: %s\n", e.Method, err.Error())
+		return
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	_, err = a.log.Write(append(b, '\n'))
+	if err != nil {
+		log.Printf("audit %s: %s\n", e.Method, err.Error())
+	}
+}
+
+// read goes through the log's entries oldest first, rotated files and all
+func (a *AuditLog) read(f func(AuditEntry)) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	paths := []string{a.log.Path}
+	for i := 1; i <= a.log.Keep; i++ {
+		paths = append([]string{fmt.Sprintf("%s.%d", a.log.Path, i)}, paths...)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scan := bufio.NewScanner(file)
+		scan.Buffer(nil, 1<<20)
+		for scan.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(scan.Bytes(), &e) == nil {
+				f(e)
+			}
+		}
+		err = scan.Err()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditParams is args as json with the secrets taken out
+func auditParams(args interface{}) json.RawMessage {
+	if args == nil {
+		return nil
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return nil
+	}
+	b, _ = json.Marshal(auditScrub(v))
+	if string(b) == "null" || string(b) == "{}" {
+		return nil
+	}
+	return b
+}
+
+// auditScrub replaces secret fields in decoded json
+func auditScrub(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range t {
+			if auditSecret(k) {
+				t[k] = "(secret)"
+			} else {
+				t[k] = auditScrub(sub)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = auditScrub(t[i])
+		}
+	}
+	return v
+}
+
+func auditSecret(field string) bool {
+	field = strings.ToLower(field)
+	if auditSecretFields[field] {
+		return true
+	}
+	for _, part := range auditSecretParts {
+		if strings.Contains(field, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestCaller is who a call over http came in from, for the audit log
+func RequestCaller(req *http.Request) string {
+	if addr, ok := req.Context().Value(
+		http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return "unix"
+	}
+	return "tcp " + req.RemoteAddr
+}
+
+// auditCodec writes each call to the audit log once it's answered
+type auditCodec struct {
+	rpc.ServerCodec
+	log     *AuditLog
+	caller  string
+	account string
+
+	mtx     sync.Mutex
+	pending map[uint64]*AuditEntry // by seq
+	last    *AuditEntry            // the header just read; the body's next
+}
+
+func (c *auditCodec) ReadRequestHeader(req *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(req)
+	if err != nil {
+		return err
+	}
+	e := &AuditEntry{
+		Time:    time.Now().UnixNano(),
+		Caller:  c.caller,
+		Account: c.account,
+		Method:  strings.TrimPrefix(req.ServiceMethod, "LitRPC."),
+	}
+	c.mtx.Lock()
+	c.pending[req.Seq] = e
+	c.last = e
+	c.mtx.Unlock()
+	return nil
+}
+
+func (c *auditCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	c.mtx.Lock()
+	if c.last != nil && err == nil {
+		c.last.Params = auditParams(body)
+	}
+	c.last = nil
+	c.mtx.Unlock()
+	return err
+}
+
+func (c *auditCodec) WriteResponse(resp *rpc.Response, body interface{}) error {
+	c.mtx.Lock()
+	e, ok := c.pending[resp.Seq]
+	delete(c.pending, resp.Seq)
+	c.mtx.Unlock()
+	if ok {
+		e.OK = resp.Error == ""
+		e.Err = resp.Error
+		e.Millis = (time.Now().UnixNano() - e.Time) / 1e6
+		c.log.write(*e)
+	}
+	return c.ServerCodec.WriteResponse(resp, body)
+}
+
+// ------------------------- auditlog
+// AuditLogArgs picks calls from the audit log.  Start and End are unix
+// seconds; End of 0 is now.  Method and Caller match if they're in the
+// entry's.  Max of 0 means no limit; otherwise the latest Max are given.
+type AuditLogArgs struct {
+	Start  int64
+	End    int64
+	Method string
+	Caller string
+	Max    uint32
+}
+
+type AuditLogReply struct {
+	Entries []AuditEntry // oldest first
+}
+
+// AuditLog returns calls from the audit log.  Accounts only get calls made
+// to them.
+func (r *LitRPC) AuditLog(args AuditLogArgs, reply *AuditLogReply) error {
+	if r.Audit == nil {
+		return fmt.Errorf("no audit log; start lit with rpcaudit")
+	}
+	start := args.Start * 1e9
+	end := int64(1<<63 - 1)
+	if args.End != 0 {
+		end = args.End * 1e9
+	}
+	return r.Audit.read(func(e AuditEntry) {
+		if e.Time < start || e.Time > end ||
+			(r.Account != "" && e.Account != r.Account) ||
+			!strings.Contains(e.Method, args.Method) ||
+			!strings.Contains(e.Caller, args.Caller) {
+			return
+		}
+		reply.Entries = append(reply.Entries, e)
+		if args.Max != 0 && uint32(len(reply.Entries)) > args.Max {
+			reply.Entries = reply.Entries[1:]
+		}
+	})
+}
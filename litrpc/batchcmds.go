@@ -0,0 +1,96 @@
+package litrpc
+
+import (
+	"fmt"
+)
+
+/*
+Batched RPCs let programmatic users queue up several of the same operation
+in one round trip.  Each item is run in order using the regular single-item
+RPC, and a failure of one item does not stop the rest; every item gets its
+own result with either the normal reply or the error string.
+
+There's no batch address import: the wallit only tracks addresses it has
+the keys for, so there's nothing to import addresses into.
+*/
+
+// BatchResult is the outcome of a single item in a batch call.
+type BatchResult struct {
+	Index  int    // position of the item in the request
+	OK     bool   // true if this item succeeded
+	Err    string // error message if it didn't
+	Status string // short human readable result if it did
+}
+
+// BatchReply is the reply for all batch calls.  Succeeded and Failed are
+// counts, Results has one entry per requested item in request order.
+type BatchReply struct {
+	Succeeded uint32
+	Failed    uint32
+	Results   []BatchResult
+}
+
+// add records the outcome of item i in the batch reply
+func (b *BatchReply) add(i int, status string, err error) {
+	res := BatchResult{Index: i}
+	if err != nil {
+		res.Err = err.Error()
+		b.Failed++
+	} else {
+		res.OK = true
+		res.Status = status
+		b.Succeeded++
+	}
+	b.Results = append(b.Results, res)
+}
+
+// ------------------------- batch fund
+type BatchFundArgs struct {
+	Funds []FundArgs
+}
+
+// BatchFundChannel opens channels to several peers in one call.  Funding
+// is still done one channel at a time (the node only has one funding in
+// progress), so each item waits for the previous one to finish.
+func (r *LitRPC) BatchFundChannel(args BatchFundArgs, reply *BatchReply) error {
+	if len(args.Funds) == 0 {
+		return fmt.Errorf("no channels specified")
+	}
+
+	for i, f := range args.Funds {
+		var sr StatusReply
+		err := r.FundChannel(f, &sr)
+		reply.add(i, sr.Status, err)
+	}
+	return nil
+}
+
+// ------------------------- batch pay
+type BatchPayArgs struct {
+	Payments []PayArgs
+}
+type BatchPayReply struct {
+	BatchReply
+	Payments []PayReply // same order as Payments; empty if that one failed
+}
+
+// BatchPay pays several payment requests or lightning addresses in one
+// call.  Each is a Pay, so idempotency keys and Async work as they do
+// there.
+func (r *LitRPC) BatchPay(args BatchPayArgs, reply *BatchPayReply) error {
+	if len(args.Payments) == 0 {
+		return fmt.Errorf("no payments specified")
+	}
+
+	reply.Payments = make([]PayReply, len(args.Payments))
+	for i, p := range args.Payments {
+		pr := &reply.Payments[i]
+		err := r.Pay(p, pr)
+		status := fmt.Sprintf("paid on chan %d", pr.ChanIdx)
+		if p.Async {
+			status = fmt.Sprintf("payment %d", pr.PaymentID)
+		}
+		reply.add(i, status, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package litrpc
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+// ------------------------- listbroadcasts
+type BroadcastInfo struct {
+	CoinType  uint32
+	Txid      string
+	State     string // pending, confirmed, evicted or double-spent
+	FirstSent int64  // unix time
+	LastSent  int64
+	Sends     uint32
+	Height    int32  // block it, or the double spend, is in
+	Conflict  string // the double spend, if there is one
+}
+
+type ListBroadcastsReply struct {
+	Txs []BroadcastInfo
+}
+
+// ListBroadcasts shows the txs lit's sent that aren't buried yet, which it
+// keeps sending until they're in a block; see wallit/rebroadcast.go
+func (r *LitRPC) ListBroadcasts(args NoArgs, reply *ListBroadcastsReply) error {
+	for coin, wal := range r.Node.SubWallet {
+		ots, err := wal.Broadcasts()
+		if err != nil {
+			return err
+		}
+		for _, ot := range ots {
+			bi := BroadcastInfo{
+				CoinType:  coin,
+				Txid:      ot.Txid.String(),
+				State:     ot.State.String(),
+				FirstSent: ot.FirstSent,
+				LastSent:  ot.LastSent,
+				Sends:     ot.Sends,
+				Height:    ot.Height,
+			}
+			if ot.Conflict != (chainhash.Hash{}) {
+				bi.Conflict = ot.Conflict.String()
+			}
+			reply.Txs = append(reply.Txs, bi)
+		}
+	}
+	return nil
+}
+
+// ------------------------- dropbroadcast
+type DropBroadcastArgs struct {
+	CoinType uint32 // 0 for the node's default coin
+	Txid     string
+}
+
+// DropBroadcast stops lit sending a tx again.  It doesn't take it back
+// from nodes that have it.
+func (r *LitRPC) DropBroadcast(args DropBroadcastArgs, reply *StatusReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	txid, err := chainhash.NewHashFromStr(args.Txid)
+	if err != nil {
+		return err
+	}
+	err = wal.DropBroadcast(txid)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("no longer sending %s", txid.String())
+	return nil
+}
@@ -1,10 +1,18 @@
 package litrpc
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
+	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/qln"
 )
@@ -19,6 +27,13 @@ type ChannelInfo struct {
 	StateNum      uint64 // Most recent commit number
 	PeerIdx, CIdx uint32
 	PeerID        string
+	KeyPath       string // where the channel's keys come from in the seed
+	// block:tx:output of the funding output; how other nodes know the
+	// channel.  Empty until confirmed.
+	ShortID string
+	// hex upfront shutdown scripts, where a coop close pays each side;
+	// empty if they didn't set one
+	MyShutdown, TheirShutdown string
 }
 type ChannelListReply struct {
 	Channels []ChannelInfo
@@ -30,6 +45,10 @@ func (r *LitRPC) ChannelList(args ChanArgs, reply *ChannelListReply) error {
 	var err error
 	var qcs []*qln.Qchan
 
+	args.ChanIdx, err = r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
 	if args.ChanIdx == 0 {
 		qcs, err = r.Node.GetAllQchans()
 		if err != nil {
@@ -55,10 +74,33 @@ func (r *LitRPC) ChannelList(args ChanArgs, reply *ChannelListReply) error {
 		reply.Channels[i].StateNum = q.State.StateIdx
 		reply.Channels[i].PeerIdx = q.KeyGen.Step[3] & 0x7fffffff
 		reply.Channels[i].CIdx = q.KeyGen.Step[4] & 0x7fffffff
+		reply.Channels[i].KeyPath = q.KeyGen.String()
+		if q.ShortID != 0 {
+			reply.Channels[i].ShortID = q.ShortID.String()
+		}
+		reply.Channels[i].MyShutdown = hex.EncodeToString(q.MyShutdown)
+		reply.Channels[i].TheirShutdown = hex.EncodeToString(q.TheirShutdown)
 	}
 	return nil
 }
 
+// chanIdx returns the index of the channel an RPC refers to: by short
+// channel id if one's given, otherwise the index itself
+func (r *LitRPC) chanIdx(idx uint32, shortID string) (uint32, error) {
+	if shortID == "" {
+		return idx, nil
+	}
+	sid, err := lnutil.ParseShortChanID(shortID)
+	if err != nil {
+		return 0, err
+	}
+	qc, err := r.Node.GetQchanByShortID(sid)
+	if err != nil {
+		return 0, err
+	}
+	return qc.Idx(), nil
+}
+
 // ------------------------- fund
 type FundArgs struct {
 	Peer        uint32 // who to make the channel with
@@ -108,8 +150,15 @@ func (r *LitRPC) FundChannel(args FundArgs, reply *StatusReply) error {
 			args.Capacity, spendable-50000)
 	}
 
+	// the channel is still ours; only the initial send is spent
+	done, err := r.Policy.spend(SpendOffChain, args.InitialSend,
+		r.peerDest(args.Peer))
+	if err != nil {
+		return err
+	}
 	idx, err := r.Node.FundChannel(
 		args.Peer, args.CoinType, args.Capacity, args.InitialSend)
+	done(err == nil)
 	if err != nil {
 		return err
 	}
@@ -119,9 +168,172 @@ func (r *LitRPC) FundChannel(args FundArgs, reply *StatusReply) error {
 	return nil
 }
 
+// ------------------------- dryrunfund
+type DryRunFundReply struct {
+	qln.FundReport
+}
+
+// DryRunFund checks everything FundChannel would, and the spend policy,
+// without opening anything, and says how each check went
+func (r *LitRPC) DryRunFund(args FundArgs, reply *DryRunFundReply) error {
+	rep, err := r.Node.DryRunFund(
+		args.Peer, args.CoinType, args.Capacity, args.InitialSend)
+	if err != nil {
+		return err
+	}
+	reply.FundReport = rep
+
+	// the channel is still ours; only the initial send is spent
+	err = r.Policy.check(SpendOffChain, args.InitialSend, r.peerDest(args.Peer))
+	if err != nil {
+		reply.FundReport.Checks = append(reply.FundReport.Checks,
+			qln.FundCheck{Name: "policy", Detail: err.Error()})
+		reply.OK = false
+	} else {
+		reply.FundReport.Checks = append(reply.FundReport.Checks,
+			qln.FundCheck{Name: "policy", OK: true, Detail: "allowed"})
+	}
+	return nil
+}
+
+// ------------------------- requestinbound
+type RequestInboundArgs struct {
+	Peer     uint32
+	CoinType uint32
+	Capacity int64
+	Timeout  int64 // seconds to wait for an answer; 0 for 30
+}
+
+// RequestInbound asks a peer to open a channel to us, for inbound
+// capacity.  The reply says if it will; the channel then comes the usual
+// way.
+func (r *LitRPC) RequestInbound(args RequestInboundArgs, reply *StatusReply) error {
+	wait := 30 * time.Second
+	if args.Timeout > 0 {
+		wait = time.Duration(args.Timeout) * time.Second
+	}
+	resp, err := r.Node.RequestInbound(
+		args.Peer, args.CoinType, args.Capacity, wait)
+	if err != nil {
+		return err
+	}
+	if !resp.Accepted {
+		return fmt.Errorf("peer %d won't: %s", args.Peer, resp.Reason)
+	}
+	reply.Status = fmt.Sprintf("peer %d is opening a %d channel on coin %d",
+		args.Peer, args.Capacity, args.CoinType)
+	return nil
+}
+
+// ------------------------- fundexternal
+type FundExternalReply struct {
+	Address  string // where the funding tx has to send Amt
+	PkScript string // hex
+	Amt      int64
+}
+
+// FundExternal starts a channel funded by a tx from another wallet.  Make a
+// tx paying Amt to Address, pass it unsigned to VerifyExternalFund, then
+// signed to FinishExternalFund.
+func (r *LitRPC) FundExternal(args FundArgs, reply *FundExternalReply) error {
+	wal := r.Node.SubWallet[args.CoinType]
+	if wal == nil {
+		return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
+	}
+
+	txo, err := r.Node.FundChannelExternal(
+		args.Peer, args.CoinType, args.Capacity, args.InitialSend)
+	if err != nil {
+		return err
+	}
+
+	// p2wsh script is 0x00 0x20 then the script hash
+	reply.Address, err = bech32.SegWitV0Encode(
+		wal.Params().Bech32Prefix, txo.PkScript[2:])
+	if err != nil {
+		return err
+	}
+	reply.PkScript = hex.EncodeToString(txo.PkScript)
+	reply.Amt = txo.Value
+	return nil
+}
+
+// ------------------------- verifyexternalfund
+type ExternalTxArgs struct {
+	Tx string // hex raw tx, or hex / base64 PSBT
+}
+
+// VerifyExternalFund takes the unsigned funding tx for FundExternal
+func (r *LitRPC) VerifyExternalFund(args ExternalTxArgs, reply *StatusReply) error {
+	op, err := r.Node.VerifyExternalFund(args.Tx)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf(
+		"channel outpoint %s; sign the tx and call FinishExternalFund", op)
+	return nil
+}
+
+// ------------------------- finishexternalfund
+// FinishExternalFund broadcasts the signed funding tx for FundExternal
+func (r *LitRPC) FinishExternalFund(args ExternalTxArgs, reply *StatusReply) error {
+	idx, err := r.Node.FinishExternalFund(args.Tx)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("funded channel %d", idx)
+	return nil
+}
+
+// ------------------------- cancelexternalfund
+func (r *LitRPC) CancelExternalFund(args NoArgs, reply *StatusReply) error {
+	err := r.Node.CancelExternalFund()
+	if err != nil {
+		return err
+	}
+	reply.Status = "external funding cancelled"
+	return nil
+}
+
+// ------------------------- bumpfund / abortfund
+type FundFeeArgs struct {
+	ChanIdx uint32
+	FeeRate int64 // sat per byte for the new tx
+}
+
+// BumpFund replaces an unconfirmed channel's funding tx with one paying
+// FeeRate.  The peer has to be connected, to sign for the new one.
+func (r *LitRPC) BumpFund(args FundFeeArgs, reply *StatusReply) error {
+	if args.FeeRate < 1 {
+		return fmt.Errorf("need a fee rate")
+	}
+	op, err := r.Node.BumpFund(args.ChanIdx, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("channel %d funding now %s", args.ChanIdx, op)
+	return nil
+}
+
+// AbortFund gives up on an unconfirmed channel, double spending its
+// funding tx back to the wallet at FeeRate
+func (r *LitRPC) AbortFund(args FundFeeArgs, reply *StatusReply) error {
+	if args.FeeRate < 1 {
+		return fmt.Errorf("need a fee rate")
+	}
+	txid, err := r.Node.AbortFund(args.ChanIdx, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("channel %d aborted; funds back in %s",
+		args.ChanIdx, txid)
+	return nil
+}
+
 // ------------------------- push
 type PushArgs struct {
 	ChanIdx uint32
+	ShortID string // block:tx:output; used instead of ChanIdx if given
 	Amt     int64
 }
 type PushReply struct {
@@ -132,7 +344,40 @@ type PushReply struct {
 // Currently waits for the process to complete before returning.
 // Will change to .. tries to send, but may not complete.
 
-func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
+func (r *LitRPC) Push(args PushArgs, reply *PushReply) (err error) {
+	args.ChanIdx, err = r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	// record the attempt in the payment history, however it turns out
+	defer func() {
+		var rec qln.PaymentRecord
+		rec.Amt = args.Amt
+		rec.Route = []uint32{args.ChanIdx}
+		rec.StateIdx = reply.StateIndex
+		rec.OK = err == nil
+		if err != nil {
+			rec.Code = qln.PushFailCode(err)
+			rec.Err = err.Error()
+		}
+		qc, qerr := r.Node.GetQchanByIdx(args.ChanIdx)
+		if qerr == nil {
+			rec.PeerIdx = qc.Peer()
+		}
+		herr := r.Node.RecordPayment(rec)
+		if herr != nil {
+			log.Printf("RecordPayment error %s\n", herr.Error())
+		}
+		if err == nil {
+			return
+		}
+		herr = r.Node.RecordHTLCFailure(qln.HTLCFailRecord{
+			ChanIdx: args.ChanIdx, PeerIdx: rec.PeerIdx, Amt: args.Amt,
+			Code: rec.Code, Err: rec.Err})
+		if herr != nil {
+			log.Printf("RecordHTLCFailure error %s\n", herr.Error())
+		}
+	}()
 
 	if args.Amt > 100000000 || args.Amt < 1 {
 		return fmt.Errorf(
@@ -152,6 +397,13 @@ func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
 		return fmt.Errorf("Can't push; channel %d closed", args.ChanIdx)
 	}
 
+	done, err := r.Policy.spend(SpendOffChain, args.Amt,
+		r.peerDest(dummyqc.Peer()))
+	if err != nil {
+		return err
+	}
+	defer func() { done(err == nil) }()
+
 	// but we want to reference the qc that's already in ram
 	// first see if we're connected to that peer
 
@@ -192,21 +444,39 @@ func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
 // ------------------------- cclose
 type ChanArgs struct {
 	ChanIdx uint32
+	ShortID string // block:tx:output; used instead of ChanIdx if given
 }
 
 // reply with status string
 // CloseChannel is a cooperative closing of a channel to a specified address.
 func (r *LitRPC) CloseChannel(args ChanArgs, reply *StatusReply) error {
-
-	qc, err := r.Node.GetQchanByIdx(args.ChanIdx)
+	idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	qc, err := r.Node.GetQchanByIdx(idx)
 	if err != nil {
 		return err
 	}
 
-	err = r.Node.CoopClose(qc)
+	// closes can wait out a fee spike; see qln/feeguard.go
+	act, err := r.Node.NonUrgent(qc.Coin(), fmt.Sprintf("close channel %d", idx),
+		func() error {
+			// the state may have moved on while it waited
+			qc, err := r.Node.GetQchanByIdx(idx)
+			if err != nil {
+				return err
+			}
+			return r.Node.CoopClose(qc)
+		})
 	if err != nil {
 		return err
 	}
+	if act != nil {
+		reply.Status = fmt.Sprintf(
+			"fees over the ceiling; close deferred as #%d", act.ID)
+		return nil
+	}
 	reply.Status = "OK closed"
 
 	return nil
@@ -214,14 +484,233 @@ func (r *LitRPC) CloseChannel(args ChanArgs, reply *StatusReply) error {
 
 // ------------------------- break
 func (r *LitRPC) BreakChannel(args ChanArgs, reply *StatusReply) error {
-
-	qc, err := r.Node.GetQchanByIdx(args.ChanIdx)
+	idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	qc, err := r.Node.GetQchanByIdx(idx)
 	if err != nil {
 		return err
 	}
 	return r.Node.BreakChannel(qc)
 }
 
+// ------------------------- forceclosereq
+// ForceCloseReqArgs picks a channel to ask the peer to break: ChanIdx or
+// ShortID for one in the DB, or with Backup (a sealed channel backup, hex)
+// the channel in it at OutPoint, txid:index.
+type ForceCloseReqArgs struct {
+	ChanIdx  uint32
+	ShortID  string
+	Backup   string
+	OutPoint string
+	Reason   string // told to the peer
+	Timeout  int64  // seconds to wait for an answer; 0 for 30
+}
+
+type ForceCloseReqReply struct {
+	Closed bool
+	Txid   string // the close tx, if closed
+	Reason string // why not, or anything else the peer said
+}
+
+// RequestForceClose asks a channel's peer to break it with its latest
+// state, for when ours is lost or its keys may be out.  See
+// qln/forceclose.go.
+func (r *LitRPC) RequestForceClose(
+	args ForceCloseReqArgs, reply *ForceCloseReqReply) error {
+
+	wait := 30 * time.Second
+	if args.Timeout > 0 {
+		wait = time.Duration(args.Timeout) * time.Second
+	}
+	var resp lnutil.ForceCloseRespMsg
+	if args.Backup != "" {
+		sealed, err := hex.DecodeString(args.Backup)
+		if err != nil {
+			return fmt.Errorf("backup: %s", err.Error())
+		}
+		op, err := parseOutPoint(args.OutPoint)
+		if err != nil {
+			return err
+		}
+		resp, err = r.Node.RequestForceCloseFromBackup(sealed, op, args.Reason, wait)
+		if err != nil {
+			return err
+		}
+	} else {
+		idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+		if err != nil {
+			return err
+		}
+		qc, err := r.Node.GetQchanByIdx(idx)
+		if err != nil {
+			return err
+		}
+		resp, err = r.Node.RequestForceClose(qc, args.Reason, wait)
+		if err != nil {
+			return err
+		}
+	}
+	reply.Closed = resp.Closed
+	if resp.Closed {
+		reply.Txid = resp.Txid.String()
+	}
+	reply.Reason = resp.Reason
+	return nil
+}
+
+// parseOutPoint reads an outpoint written txid:index
+func parseOutPoint(s string) (wire.OutPoint, error) {
+	var op wire.OutPoint
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return op, fmt.Errorf("outpoint %q isn't txid:index", s)
+	}
+	hash, err := chainhash.NewHashFromStr(s[:i])
+	if err != nil {
+		return op, fmt.Errorf("outpoint %q: %s", s, err.Error())
+	}
+	n, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return op, fmt.Errorf("outpoint %q: bad index", s)
+	}
+	return *wire.NewOutPoint(hash, uint32(n)), nil
+}
+
+// ------------------------- dumpcommitment
+type DumpCommitmentArgs struct {
+	ChanIdx uint32
+	Remote  bool // their state tx instead of mine
+}
+
+type DumpCommitmentReply struct {
+	Commitment *qln.CommitmentDump
+}
+
+// DumpCommitment returns a channel's current state tx and its outputs
+func (r *LitRPC) DumpCommitment(args DumpCommitmentArgs, reply *DumpCommitmentReply) error {
+	var err error
+	reply.Commitment, err = r.Node.DumpCommitment(args.ChanIdx, args.Remote)
+	return err
+}
+
+// ------------------------- exportarbitration
+type ArbitrationReply struct {
+	Export *qln.ArbitrationExport
+}
+
+// ExportArbitration gives a channel's signed state history, for a third
+// party settling a disputed close.  See qln/arbitration.go.
+func (r *LitRPC) ExportArbitration(args ChanArgs, reply *ArbitrationReply) error {
+	idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	reply.Export, err = r.Node.ExportArbitration(idx)
+	return err
+}
+
+// ------------------------- replay
+type ReplayReply struct {
+	Result *qln.ReplayResult
+}
+
+// ReplayChannel runs a channel's replay log through the state machine, to
+// show how an update got stuck.  See qln/replaylog.go.
+func (r *LitRPC) ReplayChannel(args ChanArgs, reply *ReplayReply) error {
+	idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	reply.Result, err = r.Node.ReplayLogChannel(idx)
+	return err
+}
+
+// ------------------------- chanops
+type ChanOpsReply struct {
+	Queues []qln.ChanOpStatus
+}
+
+// ChanOps says what each channel with ops queued is running and has
+// waiting, to see what a busy or stuck channel's doing.  See
+// qln/chanqueue.go.
+func (r *LitRPC) ChanOps(args NoArgs, reply *ChanOpsReply) error {
+	reply.Queues = r.Node.ChanOpStatuses()
+	return nil
+}
+
+type ChanOpTimeoutArgs struct {
+	ChanIdx uint32
+	ShortID string // block:tx:output; used instead of ChanIdx if given
+	Seconds uint32 // 0 for the default
+}
+
+// SetChanOpTimeout sets how long a channel's ops can take before they're
+// taken as stuck
+func (r *LitRPC) SetChanOpTimeout(
+	args ChanOpTimeoutArgs, reply *StatusReply) error {
+
+	idx, err := r.chanIdx(args.ChanIdx, args.ShortID)
+	if err != nil {
+		return err
+	}
+	qc, err := r.Node.GetQchanByIdx(idx)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SetChanOpTimeout(qc, time.Duration(args.Seconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("channel %d op timeout %ds", idx, args.Seconds)
+	if args.Seconds == 0 {
+		reply.Status = fmt.Sprintf("channel %d op timeout back to default", idx)
+	}
+	return nil
+}
+
+// ------------------------- recoverkeys
+type RecoverKeysArgs struct {
+	CoinType uint32
+	PkScript string // hex; the channel's funding output
+	TheirPub string // hex; the other side's funding pubkey
+	MaxPeer  uint32 // how far to search; defaults to 100
+	MaxChan  uint32 // defaults to 1000
+}
+
+type RecoverKeysReply struct {
+	Keys *qln.ChannelKeys
+}
+
+// RecoverChannelKeys finds the seed path of a channel that isn't in the DB
+// any more, from its funding output and the peer's funding pubkey
+func (r *LitRPC) RecoverChannelKeys(args RecoverKeysArgs, reply *RecoverKeysReply) error {
+	pkScript, err := hex.DecodeString(args.PkScript)
+	if err != nil {
+		return err
+	}
+	pubBytes, err := hex.DecodeString(args.TheirPub)
+	if err != nil {
+		return err
+	}
+	if len(pubBytes) != 33 {
+		return fmt.Errorf("their pubkey is %d bytes, need 33", len(pubBytes))
+	}
+	var theirPub [33]byte
+	copy(theirPub[:], pubBytes)
+
+	if args.MaxPeer == 0 {
+		args.MaxPeer = 100
+	}
+	if args.MaxChan == 0 {
+		args.MaxChan = 1000
+	}
+	reply.Keys, err = r.Node.FindChannelKeys(
+		args.CoinType, pkScript, theirPub, args.MaxPeer, args.MaxChan)
+	return err
+}
+
 // ------------------------- dumpPriv
 type PrivInfo struct {
 	OutPoint string
@@ -0,0 +1,102 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- sendcustommessage
+type SendCustomMessageArgs struct {
+	Peer uint32
+	Type uint16
+	Data string // hex
+}
+
+// SendCustomMessage sends an application's typed message to a connected
+// peer; see qln/custommsg.go
+func (r *LitRPC) SendCustomMessage(
+	args SendCustomMessageArgs, reply *StatusReply) error {
+	data, err := hex.DecodeString(args.Data)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SendCustomMessage(args.Peer, args.Type, data)
+	if err != nil {
+		return err
+	}
+	reply.Status = "sent"
+	return nil
+}
+
+// ------------------------- subscribecustommessages
+type SubscribeCustomMessagesArgs struct {
+	// the Seq of the last reply; waits for messages after it.  0 returns
+	// whatever's in the inbox.
+	Seq     uint64
+	Types   []uint16 // only these types; all of them if empty
+	Timeout int64    // seconds; 0 waits forever
+}
+
+type CustomMessageInfo struct {
+	Seq     uint64
+	Time    int64
+	PeerIdx uint32
+	Type    uint16
+	Data    string // hex
+}
+
+type SubscribeCustomMessagesReply struct {
+	Messages []CustomMessageInfo
+	Seq      uint64 // ask after this next time
+	TimedOut bool
+}
+
+// SubscribeCustomMessages returns the custom messages received since Seq,
+// waiting for one if there aren't any.  Call it again with the Seq it
+// gives for the ones after that.
+func (r *LitRPC) SubscribeCustomMessages(
+	args SubscribeCustomMessagesArgs, reply *SubscribeCustomMessagesReply) error {
+
+	// subscribed before looking, so a message in between isn't missed
+	sub := r.Node.SubscribeEvents()
+	defer r.Node.UnsubscribeEvents(sub)
+
+	var timeout <-chan time.Time
+	if args.Timeout > 0 {
+		timeout = time.After(time.Duration(args.Timeout) * time.Second)
+	}
+	for {
+		cms, seq := r.Node.CustomMessages(args.Seq, args.Types)
+		if args.Seq > seq {
+			// lit restarted since, and the inbox with it
+			args.Seq = 0
+			continue
+		}
+		reply.Seq = seq
+		if len(cms) > 0 {
+			for _, cm := range cms {
+				reply.Messages = append(reply.Messages, CustomMessageInfo{
+					Seq:     cm.Seq,
+					Time:    cm.Time,
+					PeerIdx: cm.PeerIdx,
+					Type:    cm.Type,
+					Data:    hex.EncodeToString(cm.Data),
+				})
+			}
+			return nil
+		}
+		// events can be dropped, so look again every so often anyway
+		select {
+		case <-timeout:
+			reply.TimedOut = true
+			return nil
+		case ev := <-sub:
+			if ev.Type != qln.EventCustomMessage {
+				continue
+			}
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package litrpc
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- checkdb
+type CheckDBArgs struct {
+	Repair bool // fix what can be fixed safely
+}
+
+type CheckDBReply struct {
+	Checks []qln.DBCheck
+}
+
+// CheckDB checks every DB for things that don't deserialize or don't agree
+// with each other
+func (r *LitRPC) CheckDB(args CheckDBArgs, reply *CheckDBReply) error {
+	var err error
+	reply.Checks, err = r.Node.CheckDB(args.Repair)
+	return err
+}
+
+// ------------------------- backup
+type BackupArgs struct {
+	Dir string // defaults to backups in the lit folder
+}
+
+// Backup writes a backup of every DB now
+func (r *LitRPC) Backup(args BackupArgs, reply *StatusReply) error {
+	dir := args.Dir
+	if dir == "" {
+		dir = filepath.Join(r.Node.LitFolder, "backups")
+	}
+	path, err := r.Node.Backup(dir)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("backed up to %s", path)
+	return nil
+}
+
+// ------------------------- cloudbackupstatus
+type CloudBackupStatusReply struct {
+	Status qln.CloudBackupStatus
+}
+
+// CloudBackupStatus shows how uploading channel backups is going
+func (r *LitRPC) CloudBackupStatus(
+	args NoArgs, reply *CloudBackupStatusReply) error {
+	status, ok := r.Node.GetCloudBackupStatus()
+	if !ok {
+		return fmt.Errorf("no cloud backup target; set cloudbackup")
+	}
+	reply.Status = status
+	return nil
+}
+
+// ------------------------- checkpoints
+type CheckpointsReply struct {
+	Checkpoints []qln.Checkpoint
+}
+
+// ListCheckpoints shows the DB copies made before force closes and
+// migrations; see qln/checkpoint.go
+func (r *LitRPC) ListCheckpoints(args NoArgs, reply *CheckpointsReply) error {
+	var err error
+	reply.Checkpoints, err = r.Node.ListCheckpoints()
+	return err
+}
+
+// ------------------------- rollback
+type RollbackArgs struct {
+	ID string
+}
+
+// Rollback puts a channel back how it was at a checkpoint, if the tx it
+// was for never went out
+func (r *LitRPC) Rollback(args RollbackArgs, reply *StatusReply) error {
+	cp, err := r.Node.Rollback(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("rolled back channel %d to before %q",
+		cp.ChanIdx, cp.Label)
+	return nil
+}
@@ -0,0 +1,137 @@
+package litrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- feereport
+type FeeReportReply struct {
+	Report qln.FeeReport
+}
+
+// FeeReport shows the fee policy of each open channel.
+func (r *LitRPC) FeeReport(args NoArgs, reply *FeeReportReply) error {
+	rep, err := r.Node.FeeReport()
+	if err != nil {
+		return err
+	}
+	reply.Report = *rep
+	return nil
+}
+
+// ------------------------- setfeepolicy
+type SetFeePolicyArgs struct {
+	ChanIdx uint32 // 0 sets the default for channels without their own
+	BaseFee int64  // satoshis
+	FeeRate uint32 // millionths
+}
+
+func (r *LitRPC) SetFeePolicy(args SetFeePolicyArgs, reply *StatusReply) error {
+	p := qln.FeePolicy{BaseFee: args.BaseFee, FeeRate: args.FeeRate}
+	err := r.Node.SetFeePolicy(args.ChanIdx, p)
+	if err != nil {
+		return err
+	}
+	which := "default"
+	if args.ChanIdx != 0 {
+		which = fmt.Sprintf("channel %d", args.ChanIdx)
+	}
+	reply.Status = fmt.Sprintf("%s fee policy: base %d rate %d ppm",
+		which, p.BaseFee, p.FeeRate)
+	return nil
+}
+
+// ------------------------- deferred
+type DeferredReply struct {
+	Ceiling int64 // sat/byte; 0 if the guard's off
+	Actions []qln.DeferredAction
+}
+
+// DeferredList shows the on chain actions waiting for fees to come down
+func (r *LitRPC) DeferredList(args NoArgs, reply *DeferredReply) error {
+	reply.Ceiling = r.Node.FeeCeiling()
+	reply.Actions = r.Node.DeferredActions()
+	return nil
+}
+
+type DeferredArgs struct {
+	ID     uint32
+	Cancel bool // drop it instead of running it
+}
+
+// DeferredRun runs a deferred action now, whatever fees are, or cancels it
+func (r *LitRPC) DeferredRun(args DeferredArgs, reply *StatusReply) error {
+	if args.Cancel {
+		err := r.Node.CancelDeferred(args.ID)
+		if err != nil {
+			return err
+		}
+		reply.Status = fmt.Sprintf("cancelled #%d", args.ID)
+		return nil
+	}
+	err := r.Node.RunDeferred(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("ran #%d", args.ID)
+	return nil
+}
+
+// ------------------------- sweeptargets
+type SweepTargetsArgs struct {
+	CoinType uint32 // for the rates; 0 for the node's default coin
+}
+
+type SweepTargetInfo struct {
+	Class  string
+	Blocks int32
+	Rate   int64 // sat/byte it'd go out at now
+}
+
+type SweepTargetsReply struct {
+	CoinType uint32
+	Targets  []SweepTargetInfo
+}
+
+// SweepTargets shows the confirmation target of each class of tx lit
+// sends by itself, and the fee rate that gets now; see qln/sweepfees.go
+func (r *LitRPC) SweepTargets(
+	args SweepTargetsArgs, reply *SweepTargetsReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	targets := r.Node.SweepTargets()
+	for i, blocks := range targets {
+		class := qln.SweepClass(i)
+		rate, err := r.Node.SweepFeeRate(args.CoinType, class, 0)
+		if err != nil {
+			return err
+		}
+		reply.Targets = append(reply.Targets,
+			SweepTargetInfo{Class: class.String(), Blocks: blocks, Rate: rate})
+	}
+	reply.CoinType = args.CoinType
+	return nil
+}
+
+type SetSweepTargetArgs struct {
+	Class  string // justice, htlc, tolocal or consolidate
+	Blocks int32
+}
+
+// SetSweepTarget sets a class's confirmation target until restart or
+// reload
+func (r *LitRPC) SetSweepTarget(args SetSweepTargetArgs, reply *StatusReply) error {
+	class, err := qln.ParseSweepClass(args.Class)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SetSweepTarget(class, args.Blocks)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("%s txs target %d blocks", class, args.Blocks)
+	return nil
+}
@@ -0,0 +1,38 @@
+package litrpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ------------------------- fiatrate
+type FiatRateArgs struct {
+	Currency string // empty for the node's own
+}
+type FiatRateReply struct {
+	Currency    string
+	Price       float64 // of one bitcoin
+	SatsPerUnit float64
+}
+
+// FiatRate gives the current exchange rate, if the node has fiat rates on
+func (r *LitRPC) FiatRate(args FiatRateArgs, reply *FiatRateReply) error {
+	if r.Node.FiatRate == nil {
+		return fmt.Errorf("fiat rates are off; start lit with --fiat")
+	}
+	cur := strings.ToUpper(args.Currency)
+	if cur == "" {
+		cur = r.Node.FiatCurrency
+	}
+	if cur == "" {
+		return fmt.Errorf("no currency given")
+	}
+	rate, err := r.Node.FiatRate(cur)
+	if err != nil {
+		return err
+	}
+	reply.Currency = cur
+	reply.SatsPerUnit = rate
+	reply.Price = 1e8 / rate
+	return nil
+}
@@ -0,0 +1,208 @@
+package litrpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/qln"
+)
+
+// about a year of blocks, for SetHeir without a delay
+const defaultHeirDelay = 52560
+
+// ------------------------- setheir
+type SetHeirArgs struct {
+	Address string
+	Delay   int32 // blocks; 0 for about a year
+}
+
+// SetHeir has a wallet's coins go to an heir's address if its owner stops
+// checking in; see wallit/heir.go.  The wallet's the one for the address's
+// coin.  The spending policy sees the whole wallet going to the heir.
+func (r *LitRPC) SetHeir(args SetHeirArgs, reply *StatusReply) (err error) {
+	coinType := CoinTypeFromAdr(args.Address)
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for address %s type %d",
+			args.Address, coinType)
+	}
+	script, err := AdrStringToOutscript(args.Address)
+	if err != nil {
+		return err
+	}
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, u := range utxos {
+		total += u.Value
+	}
+	done, err := r.Policy.spend(SpendOnChain, total, args.Address)
+	if err != nil {
+		return err
+	}
+	defer func() { done(err == nil) }()
+
+	if args.Delay == 0 {
+		args.Delay = defaultHeirDelay
+	}
+	err = wal.SetHeir(script, args.Delay)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("coin %d goes to %s after block %d "+
+		"without a check in", coinType, args.Address,
+		wal.CurrentHeight()+args.Delay)
+	return nil
+}
+
+// ------------------------- clearheir
+// ClearHeir takes a wallet's heir away.  If its tx was exported, the coins
+// it spends are moved so it can't be sent.
+func (r *LitRPC) ClearHeir(args CoinArgs, reply *StatusReply) error {
+	wal, err := r.heirWallet(args.CoinType)
+	if err != nil {
+		return err
+	}
+	err = wal.ClearHeir()
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("no heir for coin %d", wal.Params().HDCoinType)
+	return nil
+}
+
+// ------------------------- heircheckin
+// HeirCheckIn says the owner's still around, for every wallet with an heir
+func (r *LitRPC) HeirCheckIn(args NoArgs, reply *StatusReply) error {
+	var done []string
+	for coin, wal := range r.Node.SubWallet {
+		h, err := wal.Heir()
+		if err != nil {
+			return err
+		}
+		if h == nil || h.Sent {
+			continue
+		}
+		err = wal.HeirCheckIn()
+		if err != nil {
+			return fmt.Errorf("coin %d: %s", coin, err.Error())
+		}
+		done = append(done, fmt.Sprintf("%d", coin))
+	}
+	if len(done) == 0 {
+		return fmt.Errorf("no wallet has an heir to check in for")
+	}
+	reply.Status = fmt.Sprintf("checked in for coin %s",
+		strings.Join(done, ", "))
+	return nil
+}
+
+// ------------------------- heirs
+type HeirInfo struct {
+	CoinType   uint32
+	Heir       string // address, or script hex if it's not segwit
+	Delay      int32
+	LockHeight int32 // the tx is valid in the block after this
+	BlocksLeft int32
+	CheckIn    int32 // height of the last check in
+	Due        bool  // needs a check in to be pushed back
+	Exported   bool
+	Sent       bool
+	Txid       string // the heir tx, if there are coins to send
+}
+
+type HeirsReply struct {
+	Heirs []HeirInfo
+}
+
+// Heirs shows the wallets with heirs and where their heir txs are at
+func (r *LitRPC) Heirs(args NoArgs, reply *HeirsReply) error {
+	for coin, wal := range r.Node.SubWallet {
+		h, err := wal.Heir()
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			continue
+		}
+		height := wal.CurrentHeight()
+		hi := HeirInfo{
+			CoinType:   coin,
+			Heir:       hex.EncodeToString(h.Script),
+			Delay:      h.Delay,
+			LockHeight: h.LockHeight,
+			BlocksLeft: h.LockHeight - height,
+			CheckIn:    h.CheckIn,
+			Due:        h.Due(height),
+			Exported:   h.Exported,
+			Sent:       h.Sent,
+		}
+		// segwit v0 scripts are 0x00, a push, then the hash
+		if len(h.Script) > 2 && h.Script[0] == 0 {
+			adr, err := bech32.SegWitV0Encode(
+				wal.Params().Bech32Prefix, h.Script[2:])
+			if err == nil {
+				hi.Heir = adr
+			}
+		}
+		if len(h.Tx) > 0 {
+			tx := wire.NewMsgTx()
+			err = tx.Deserialize(bytes.NewReader(h.Tx))
+			if err != nil {
+				return err
+			}
+			hi.Txid = tx.TxHash().String()
+		}
+		reply.Heirs = append(reply.Heirs, hi)
+	}
+	return nil
+}
+
+// ------------------------- exportheirtx
+type ExportHeirTxReply struct {
+	Txid       string
+	Tx         string // hex
+	LockHeight int32
+}
+
+// ExportHeirTx gives a wallet's signed heir tx, for the heir to keep and
+// send after its lock height if this node doesn't.  Pushing the lock
+// height back after an export moves the wallet's coins, to make this one
+// no good.
+func (r *LitRPC) ExportHeirTx(args CoinArgs, reply *ExportHeirTxReply) error {
+	wal, err := r.heirWallet(args.CoinType)
+	if err != nil {
+		return err
+	}
+	tx, err := wal.ExportHeirTx()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tx.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	reply.Txid = tx.TxHash().String()
+	reply.Tx = hex.EncodeToString(buf.Bytes())
+	reply.LockHeight = int32(tx.LockTime)
+	return nil
+}
+
+// heirWallet is the wallet for a coin, the default for 0
+func (r *LitRPC) heirWallet(coin uint32) (qln.UWallet, error) {
+	if coin == 0 {
+		coin = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[coin]
+	if !ok {
+		return nil, fmt.Errorf("no connnected wallet for coin type %d", coin)
+	}
+	return wal, nil
+}
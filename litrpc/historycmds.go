@@ -0,0 +1,70 @@
+package litrpc
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- history
+// HistoryArgs selects a time range, in unix seconds.  End of 0 means now
+// (well, forever).  Max of 0 means no limit.
+type HistoryArgs struct {
+	Start int64
+	End   int64
+	Max   uint32
+}
+
+// nanoRange turns second-resolution args into the nanosecond range the
+// history db uses
+func (a *HistoryArgs) nanoRange() (int64, int64) {
+	end := int64(math.MaxInt64)
+	if a.End != 0 && a.End < math.MaxInt64/int64(time.Second) {
+		end = a.End * 1e9
+	}
+	return a.Start * 1e9, end
+}
+
+type PaymentsReply struct {
+	Payments []qln.PaymentRecord
+}
+
+// ListPayments returns outgoing payment attempts in a time range
+func (r *LitRPC) ListPayments(args HistoryArgs, reply *PaymentsReply) error {
+	start, end := args.nanoRange()
+	ps, err := r.Node.ListPayments(start, end, args.Max)
+	if err != nil {
+		return err
+	}
+	reply.Payments = ps
+	return nil
+}
+
+// ------------------------- charts
+// ChartArgs is a time range like HistoryArgs, and Step, in seconds, to
+// thin it out to at most one point per step; 0 for every point.
+type ChartArgs struct {
+	HistoryArgs
+	Step int64
+}
+
+type ChartReply struct {
+	Points []qln.ChartPoint
+}
+
+// ChartData returns the node's balances over a time range; see
+// qln/charts.go
+func (r *LitRPC) ChartData(args ChartArgs, reply *ChartReply) error {
+	if args.Step < 0 || args.Step > math.MaxInt64/int64(time.Second) {
+		return fmt.Errorf("bad step %d", args.Step)
+	}
+	start, end := args.nanoRange()
+	ps, err := r.Node.ChartData(start, end, args.Step*1e9, args.Max)
+	if err != nil {
+		return err
+	}
+	reply.Points = ps
+	return nil
+}
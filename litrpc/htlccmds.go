@@ -0,0 +1,19 @@
+package litrpc
+
+import (
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- listhtlcfailures
+type HTLCFailuresReply struct {
+	Failures []qln.HTLCFailRecord
+}
+
+// ListHTLCFailures returns failed HTLCs and pushes in a time range, each
+// with its failure code
+func (r *LitRPC) ListHTLCFailures(args HistoryArgs, reply *HTLCFailuresReply) error {
+	start, end := args.nanoRange()
+	var err error
+	reply.Failures, err = r.Node.ListHTLCFailures(start, end, args.Max)
+	return err
+}
@@ -0,0 +1,92 @@
+package litrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- getinfo
+type InfoReply struct {
+	LisIpPorts []string
+	Adr        string
+	Health     qln.NodeHealth
+	// what our channels can send and receive by coin, and what we'd open
+	// on request, as advertised to peers
+	Liquidity []lnutil.LiquidityCoin
+	// only the RPCs that look at things are answered; see readonly.go
+	ReadOnly bool
+}
+
+// GetInfo reports the node's address, listening ports, health (sync
+// status per coin, peers, tower, and pending sweeps) and liquidity.
+func (r *LitRPC) GetInfo(args NoArgs, reply *InfoReply) error {
+	reply.Adr, reply.LisIpPorts = r.Node.GetLisAddressAndPorts()
+	reply.Health = r.Node.Health()
+	reply.ReadOnly = r.ReadOnly
+	var err error
+	reply.Liquidity, err = r.Node.Liquidity()
+	return err
+}
+
+// serveHealthz is the http /healthz endpoint, for things like container
+// liveness probes.  Responds 200 if healthy and 503 if not, with the
+// health info as json either way.
+func (r *LitRPC) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	h := r.Node.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// ------------------------- waitevent
+type WaitEventArgs struct {
+	Timeout int64 // seconds; 0 waits forever
+}
+type WaitEventReply struct {
+	Event    qln.NodeEvent
+	TimedOut bool
+}
+
+// WaitEvent blocks until the next node event (channel opened or closed,
+// breach, payment, invoice) or the timeout, then returns.  Call it again for
+// the one after that (like GetMessages); events between calls are missed.
+func (r *LitRPC) WaitEvent(args WaitEventArgs, reply *WaitEventReply) error {
+	sub := r.Node.SubscribeEvents()
+	defer r.Node.UnsubscribeEvents(sub)
+
+	var timeout <-chan time.Time
+	if args.Timeout > 0 {
+		timeout = time.After(time.Duration(args.Timeout) * time.Second)
+	}
+	select {
+	case reply.Event = <-sub:
+	case <-timeout:
+		reply.TimedOut = true
+	}
+	return nil
+}
+
+// ------------------------- syncchanges
+type SyncChangesArgs struct {
+	Since uint64 // Seq from the last reply; 0 for everything
+	Max   uint32 // most changes to return; 0 for no limit
+}
+
+type SyncChangesReply struct {
+	qln.SyncDelta
+}
+
+// SyncChanges returns the latest change to each channel, wallet output,
+// coin tip and paid invoice that's changed since the last call, for apps
+// that only get a moment to catch up.  See qln/syncdelta.go.
+func (r *LitRPC) SyncChanges(args SyncChangesArgs, reply *SyncChangesReply) error {
+	var err error
+	reply.SyncDelta, err = r.Node.SyncChanges(args.Since, args.Max)
+	return err
+}
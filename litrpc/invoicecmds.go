@@ -0,0 +1,484 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnurl"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// InvoiceInfo is an invoice as shown over RPC.  Doesn't include the
+// preimage unless the invoice is settled.
+type InvoiceInfo struct {
+	PaymentHash string
+	PayReq      string
+	Amt         int64
+	Description string
+	DescHash    string
+	Created     int64
+	ExpiresAt   int64
+	Expired     bool
+	Settled     bool
+	AmtPaid     int64
+	SettledAt   int64
+	Preimage    string
+	Fiat        string // Amt in the node's fiat currency, if it has one
+	// channels in to us the payer can use; litadr@blockxtxxoutput
+	RouteHints []string
+	Metadata   map[string]string // given when it was made
+	PayerData  map[string]string // what the payer sent with it
+}
+
+// invoiceInfo converts a qln invoice for the RPC reply
+func (r *LitRPC) invoiceInfo(inv *qln.Invoice) InvoiceInfo {
+	var idPub [33]byte
+	copy(idPub[:], r.Node.IdKey().PubKey().SerializeCompressed())
+
+	var ii InvoiceInfo
+	ii.PaymentHash = hex.EncodeToString(inv.PaymentHash[:])
+	ii.PayReq = inv.PayReq(lnutil.LitAdrFromPubkey(idPub))
+	ii.Amt = inv.Amt
+	ii.Description = inv.Description
+	ii.DescHash = hex.EncodeToString(inv.DescHash[:])
+	ii.Created = inv.Created
+	ii.ExpiresAt = inv.Created + inv.Expiry
+	ii.Expired = inv.Expired()
+	ii.Settled = inv.Settled
+	ii.AmtPaid = inv.AmtPaid
+	ii.SettledAt = inv.SettledAt
+	if inv.Settled {
+		ii.Preimage = hex.EncodeToString(inv.Preimage[:])
+	}
+	if inv.Amt > 0 {
+		ii.Fiat = r.Node.FiatString(inv.Amt)
+	}
+	for _, h := range inv.Hints {
+		ii.RouteHints = append(ii.RouteHints, h.String())
+	}
+	ii.Metadata = inv.Metadata
+	ii.PayerData = inv.PayerData
+	return ii
+}
+
+// decodeHash32 decodes a 32 byte hex string
+func decodeHash32(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != 32 {
+		return h, fmt.Errorf("hash %s is %d bytes, expect 32", s, len(b))
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// ------------------------- addinvoice
+type AddInvoiceArgs struct {
+	Amt         int64  // 0 lets the payer choose
+	Fiat        string // or a fiat amount, eg "5.00USD", at today's rate
+	Description string
+	DescHash    string // hex; optional, defaults to sha256(Description)
+	Expiry      int64  // seconds; 0 for default
+	// kept with the invoice for matching payments up, eg "order_id";
+	// see qln/invoicemeta.go
+	Metadata map[string]string
+}
+type InvoiceReply struct {
+	Invoice InvoiceInfo
+}
+
+func (r *LitRPC) AddInvoice(args AddInvoiceArgs, reply *InvoiceReply) error {
+	var descHash *[32]byte
+	if args.DescHash != "" {
+		h, err := decodeHash32(args.DescHash)
+		if err != nil {
+			return err
+		}
+		descHash = &h
+	}
+	amt := args.Amt
+	if args.Fiat != "" {
+		if amt != 0 {
+			return fmt.Errorf("give Amt or Fiat, not both")
+		}
+		hundredths, cur, err := lnutil.ParseFiat(args.Fiat)
+		if err != nil {
+			return err
+		}
+		amt, err = r.Node.FiatToSats(hundredths, cur)
+		if err != nil {
+			return err
+		}
+	}
+	inv, err := r.Node.AddInvoice(
+		amt, args.Description, descHash, args.Expiry, args.Metadata)
+	if err != nil {
+		return err
+	}
+	reply.Invoice = r.invoiceInfo(inv)
+	return nil
+}
+
+// ------------------------- lookupinvoice
+type InvoiceHashArgs struct {
+	PaymentHash string // hex
+}
+
+func (r *LitRPC) LookupInvoice(args InvoiceHashArgs, reply *InvoiceReply) error {
+	hash, err := decodeHash32(args.PaymentHash)
+	if err != nil {
+		return err
+	}
+	inv, err := r.Node.GetInvoice(hash)
+	if err != nil {
+		return err
+	}
+	reply.Invoice = r.invoiceInfo(inv)
+	return nil
+}
+
+// ------------------------- listinvoices
+type ListInvoicesArgs struct {
+	PendingOnly bool // only unsettled and unexpired
+	// only ones whose metadata or payer data has this key, with this value
+	// if it's not empty
+	MetaKey   string
+	MetaValue string
+}
+type ListInvoicesReply struct {
+	Invoices []InvoiceInfo
+}
+
+func (r *LitRPC) ListInvoices(
+	args ListInvoicesArgs, reply *ListInvoicesReply) error {
+	invs, err := r.Node.ListInvoices(args.PendingOnly)
+	if err != nil {
+		return err
+	}
+	for _, inv := range invs {
+		if args.MetaKey != "" && !invoiceHasMeta(inv, args.MetaKey, args.MetaValue) {
+			continue
+		}
+		reply.Invoices = append(reply.Invoices, r.invoiceInfo(inv))
+	}
+	return nil
+}
+
+// invoiceHasMeta says if an invoice's metadata or payer data has key, and
+// value if that's given
+func invoiceHasMeta(inv *qln.Invoice, key, value string) bool {
+	for _, m := range []map[string]string{inv.Metadata, inv.PayerData} {
+		v, ok := m[key]
+		if ok && (value == "" || v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------- subscribeinvoices
+// SubscribeInvoices blocks until the next invoice is settled, then returns
+// it.  Call it again to get the one after that (like GetMessages).
+func (r *LitRPC) SubscribeInvoices(args NoArgs, reply *InvoiceReply) error {
+	sub := r.Node.SubscribeEvents()
+	defer r.Node.UnsubscribeEvents(sub)
+
+	for ev := range sub {
+		if ev.Type != qln.EventInvoiceSettled {
+			continue
+		}
+		hash, err := decodeHash32(ev.PaymentHash)
+		if err != nil {
+			return err
+		}
+		inv, err := r.Node.GetInvoice(hash)
+		if err != nil {
+			return err
+		}
+		reply.Invoice = r.invoiceInfo(inv)
+		return nil
+	}
+	return nil
+}
+
+// ------------------------- newwithdraw
+type NewWithdrawArgs struct {
+	MaxAmt      int64 // satoshis
+	Description string
+}
+type NewWithdrawReply struct {
+	Lnurl string
+}
+
+// NewWithdraw makes a one-shot lnurl-withdraw link
+func (r *LitRPC) NewWithdraw(
+	args NewWithdrawArgs, reply *NewWithdrawReply) error {
+	if r.Lnurl == nil {
+		return fmt.Errorf("lnurl server not running; set lnurllisten")
+	}
+	// counted when made; whoever has the link can take it
+	done, err := r.Policy.spend(SpendOffChain, args.MaxAmt, "lnurl-withdraw")
+	if err != nil {
+		return err
+	}
+	u, err := r.Lnurl.NewWithdraw(args.MaxAmt, args.Description)
+	done(err == nil)
+	if err != nil {
+		return err
+	}
+	reply.Lnurl = u
+	return nil
+}
+
+// ------------------------- pay
+type PayArgs struct {
+	// either a payment request or a user@domain lightning address
+	Dest string
+	Amt  int64 // satoshis; needed for addresses and any-amount requests
+	// optional; a retry with the same key won't pay again
+	IdempotencyKey string
+	// return straight away with a PaymentID, and pay in the background,
+	// trying other channels if one fails; see TrackPayment
+	Async bool
+}
+type PayReply struct {
+	PayReq  string // the request that was paid
+	ChanIdx uint32
+	// true if this is the result of an earlier payment with the same key
+	Replayed bool
+	// for Async payments, which have no ChanIdx yet
+	PaymentID uint32
+}
+
+// Pay pays a payment request or a lightning address
+func (r *LitRPC) Pay(args PayArgs, reply *PayReply) error {
+	if args.Async {
+		if args.IdempotencyKey != "" {
+			// a request can't be paid twice anyway; see qln/payonce.go
+			return fmt.Errorf("idempotency keys are for payments that wait")
+		}
+		return r.payAsync(args, reply)
+	}
+	if args.IdempotencyKey == "" {
+		return r.pay(args, reply)
+	}
+	payReq, cIdx, ran, err := r.Node.PayOnce(args.IdempotencyKey,
+		func() (string, uint32, error) {
+			var rep PayReply
+			err := r.pay(args, &rep)
+			return rep.PayReq, rep.ChanIdx, err
+		})
+	reply.PayReq = payReq
+	reply.ChanIdx = cIdx
+	reply.Replayed = !ran && err == nil
+	return err
+}
+
+func (r *LitRPC) pay(args PayArgs, reply *PayReply) (err error) {
+	dest, amt := args.Dest, args.Amt
+	if !lnurl.IsAddress(args.Dest) {
+		pr, err := qln.DecodePayReq(args.Dest)
+		if err != nil {
+			return err
+		}
+		dest = pr.LitAdr
+		if pr.Amt != 0 {
+			amt = pr.Amt
+		}
+	}
+	done, err := r.Policy.spend(SpendOffChain, amt, dest)
+	if err != nil {
+		return err
+	}
+	defer func() { done(err == nil) }()
+
+	if lnurl.IsAddress(args.Dest) {
+		reply.PayReq, reply.ChanIdx, err =
+			lnurl.PayAddress(r.Node, args.Dest, args.Amt)
+		return err
+	}
+	reply.PayReq = args.Dest
+	reply.ChanIdx, err = r.Node.PayInvoice(args.Dest, args.Amt)
+	return err
+}
+
+// payAsync queues a payment.  A lightning address is looked up first, so
+// the request it gives can be queued.
+func (r *LitRPC) payAsync(args PayArgs, reply *PayReply) error {
+	payReq, amt := args.Dest, args.Amt
+	dest := args.Dest
+	if lnurl.IsAddress(args.Dest) {
+		if amt < 1 {
+			return fmt.Errorf("need an amount to pay %s", args.Dest)
+		}
+		p, err := lnurl.ResolveAddress(args.Dest)
+		if err != nil {
+			return err
+		}
+		payReq, err = p.FetchInvoice(amt)
+		if err != nil {
+			return err
+		}
+	}
+	pr, err := qln.DecodePayReq(payReq)
+	if err != nil {
+		return err
+	}
+	if !lnurl.IsAddress(args.Dest) {
+		dest = pr.LitAdr
+	}
+	if pr.Amt != 0 {
+		amt = pr.Amt
+	}
+	// counted now, and uncounted if it fails
+	done, err := r.Policy.spend(SpendOffChain, amt, dest)
+	if err != nil {
+		return err
+	}
+	id, err := r.Node.QueuePayment(payReq, args.Amt,
+		func(err error) { done(err == nil) })
+	if err != nil {
+		done(false)
+		return err
+	}
+	reply.PayReq = payReq
+	reply.PaymentID = id
+	return nil
+}
+
+// ------------------------- trackpayment
+type TrackPaymentArgs struct {
+	PaymentID uint32
+	// the Seq of the last update seen; waits for one after it.  0 returns
+	// the payment as it is.
+	Seq     uint32
+	Timeout int64 // seconds; 0 waits forever
+}
+type TrackPaymentReply struct {
+	Payment  QueuedPaymentInfo
+	TimedOut bool
+}
+type QueuedPaymentInfo struct {
+	ID       uint32
+	PayReq   string
+	Amt      int64
+	State    string // queued, in flight, retrying, succeeded or failed
+	Final    bool   // succeeded or failed; nothing more will happen
+	Attempts int
+	ChanIdx  uint32
+	Tried    []uint32
+	Err      string
+	Code     qln.FailCode // why the last try failed; see qln/htlcfail.go
+	Created  int64
+	Updated  int64
+	Seq      uint32
+}
+
+func queuedPaymentInfo(p qln.QueuedPayment) QueuedPaymentInfo {
+	return QueuedPaymentInfo{
+		ID:       p.ID,
+		PayReq:   p.PayReq,
+		Amt:      p.Amt,
+		State:    p.State.String(),
+		Final:    p.State.Final(),
+		Attempts: p.Attempts,
+		ChanIdx:  p.ChanIdx,
+		Tried:    p.Tried,
+		Err:      p.Err,
+		Code:     p.Code,
+		Created:  p.Created,
+		Updated:  p.Updated,
+		Seq:      p.Seq,
+	}
+}
+
+// TrackPayment returns an Async payment once it's changed since Seq, or
+// it's finished.  Call it again with the Seq it gives for the update after
+// that, until Final, to follow a payment through.
+func (r *LitRPC) TrackPayment(
+	args TrackPaymentArgs, reply *TrackPaymentReply) error {
+
+	// subscribed before looking, so an update in between isn't missed
+	sub := r.Node.SubscribeEvents()
+	defer r.Node.UnsubscribeEvents(sub)
+
+	var timeout <-chan time.Time
+	if args.Timeout > 0 {
+		timeout = time.After(time.Duration(args.Timeout) * time.Second)
+	}
+	for {
+		p, err := r.Node.GetQueuedPayment(args.PaymentID)
+		if err != nil {
+			return err
+		}
+		reply.Payment = queuedPaymentInfo(p)
+		if p.Seq > args.Seq || p.State.Final() {
+			return nil
+		}
+		// wait for the next update to this one; events can be dropped, so
+		// look again every so often anyway
+		select {
+		case <-timeout:
+			reply.TimedOut = true
+			return nil
+		case ev := <-sub:
+			if ev.Type != qln.EventPaymentUpdate ||
+				ev.PaymentID != args.PaymentID {
+				continue
+			}
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// ------------------------- listqueuedpayments
+type QueuedPaymentsReply struct {
+	Payments []QueuedPaymentInfo
+}
+
+// ListQueuedPayments lists Async payments, going and recently finished
+func (r *LitRPC) ListQueuedPayments(
+	args NoArgs, reply *QueuedPaymentsReply) error {
+	for _, p := range r.Node.QueuedPayments() {
+		reply.Payments = append(reply.Payments, queuedPaymentInfo(p))
+	}
+	return nil
+}
+
+// ------------------------- paykeystatus
+type PayKeyArgs struct {
+	IdempotencyKey string
+}
+type PayKeyReply struct {
+	State   string // pending, done or failed
+	Time    int64
+	PayReq  string
+	ChanIdx uint32
+	Err     string
+}
+
+// PayKeyStatus shows what the payment made with an idempotency key did
+func (r *LitRPC) PayKeyStatus(args PayKeyArgs, reply *PayKeyReply) error {
+	rec, err := r.Node.GetPayKey(args.IdempotencyKey)
+	if err != nil {
+		return err
+	}
+	switch rec.State {
+	case qln.PayKeyPending:
+		reply.State = "pending"
+	case qln.PayKeyDone:
+		reply.State = "done"
+	default:
+		reply.State = "failed"
+	}
+	reply.Time = rec.Time
+	reply.PayReq = rec.PayReq
+	reply.ChanIdx = rec.ChanIdx
+	reply.Err = rec.Err
+	return nil
+}
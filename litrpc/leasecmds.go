@@ -0,0 +1,127 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/adiabat/bech32"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// LeaseInfo is a channel lease we've offered or sold, as shown over RPC
+type LeaseInfo struct {
+	LeaseID  string // hex payment hash of its invoice
+	Peer     uint32
+	CoinType uint32
+	Capacity int64
+	Fee      int64
+	Blocks   uint32
+	Address  string // where it can be paid on chain
+	Created  int64
+	Expires  int64
+
+	State       string // offered, paid, open, expired or ended
+	AmtPaid     int64
+	PaidOnChain bool
+	ChanIdx     uint32 // once open
+	EndHeight   int32  // we keep the channel open until this height
+}
+
+// leaseAddress is the bech32 address for a lease's pubkey hash
+func (r *LitRPC) leaseAddress(coin uint32, pkh [20]byte) string {
+	wal, ok := r.Node.SubWallet[coin]
+	if !ok {
+		return ""
+	}
+	adr, err := bech32.SegWitV0Encode(wal.Params().Bech32Prefix, pkh[:])
+	if err != nil {
+		return ""
+	}
+	return adr
+}
+
+// ------------------------- listleases
+type ListLeasesReply struct {
+	Leases []LeaseInfo
+}
+
+// ListLeases shows the channel leases we've offered and sold, newest first
+func (r *LitRPC) ListLeases(args NoArgs, reply *ListLeasesReply) error {
+	leases, err := r.Node.ListLeases()
+	if err != nil {
+		return err
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Created > leases[j].Created
+	})
+	for _, l := range leases {
+		reply.Leases = append(reply.Leases, LeaseInfo{
+			LeaseID:     hex.EncodeToString(l.Hash[:]),
+			Peer:        l.PeerIdx,
+			CoinType:    l.CoinType,
+			Capacity:    l.Capacity,
+			Fee:         l.Fee,
+			Blocks:      l.Blocks,
+			Address:     r.leaseAddress(l.CoinType, l.PKH),
+			Created:     l.Created,
+			Expires:     l.Expires,
+			State:       l.StateString(),
+			AmtPaid:     l.AmtPaid,
+			PaidOnChain: l.PaidOnChain,
+			ChanIdx:     l.ChanIdx,
+			EndHeight:   l.EndHeight,
+		})
+	}
+	return nil
+}
+
+// ------------------------- buylease
+type BuyLeaseArgs struct {
+	Peer     uint32
+	CoinType uint32
+	Capacity int64 // one of the sizes the peer offers
+	Timeout  int64 // seconds to wait for terms; 0 for 30
+}
+type BuyLeaseReply struct {
+	Fee     int64
+	Blocks  uint32 // the peer keeps the channel open this long
+	Expires int64  // pay by then
+	PayReq  string // pay this, or
+	Address string // send Fee here
+}
+
+// BuyLease gets the terms for leasing a channel from a peer.  Nothing's
+// paid; pay the request, or send the fee to the address, and the peer
+// opens the channel.
+func (r *LitRPC) BuyLease(args BuyLeaseArgs, reply *BuyLeaseReply) error {
+	wait := 30 * time.Second
+	if args.Timeout > 0 {
+		wait = time.Duration(args.Timeout) * time.Second
+	}
+	terms, err := r.Node.BuyLease(args.Peer, args.CoinType, args.Capacity, wait)
+	if err != nil {
+		return err
+	}
+	reply.Fee = terms.Fee
+	reply.Blocks = terms.Blocks
+	reply.Expires = terms.Expires
+	reply.PayReq = terms.PayReq
+	reply.Address = r.leaseAddress(terms.CoinType, terms.PKH)
+	if reply.Address == "" {
+		return fmt.Errorf("can't make an address for coin %d", terms.CoinType)
+	}
+	return nil
+}
+
+// ------------------------- leasetiers
+type LeaseTiersReply struct {
+	Tiers []lnutil.LeaseTier
+}
+
+// LeaseTiers shows the channel sizes we sell, set with leasetier in lit.conf
+func (r *LitRPC) LeaseTiers(args NoArgs, reply *LeaseTiersReply) error {
+	reply.Tiers = r.Node.LeaseTiers()
+	return nil
+}
@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+	"net/url"
 
 	"golang.org/x/net/websocket"
 
+	"github.com/mit-dci/lit/lnurl"
 	"github.com/mit-dci/lit/qln"
 )
 
@@ -27,9 +30,22 @@ It ends up being the root of ~everything in the executable.
 type LitRPC struct {
 	Node      *qln.LitNode
 	OffButton chan bool
+	// Reload re-reads the config file; set by main
+	Reload func() error
+	// Lnurl is the lnurl server, if running
+	Lnurl *lnurl.Server
+	// Policy limits spending; nil for none.  See policy.go
+	Policy *SpendPolicy
+	// ReadOnly refuses the RPCs that move funds or change things; see
+	// readonly.go
+	ReadOnly bool
+	// Audit is where calls are logged; nil for nowhere.  See audit.go
+	Audit *AuditLog
+	// Account is the name of the account this serves; "" for the main node
+	Account string
 }
 
-func serveWS(ws *websocket.Conn) {
+func (r *LitRPC) serveWS(ws *websocket.Conn) {
 	body, err := ioutil.ReadAll(ws.Request().Body)
 	if err != nil {
 		log.Printf("Error reading body: %v", err)
@@ -39,15 +55,76 @@ func serveWS(ws *websocket.Conn) {
 	log.Printf(string(body))
 	ws.Request().Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
-	jsonrpc.ServeConn(ws)
+	rpc.ServeCodec(r.ServerCodec(
+		jsonrpc.NewServerCodec(ws), RequestCaller(ws.Request())))
 }
 
-func RPCListen(rpcl *LitRPC, port uint16) {
+// checkOrigin refuses websockets opened by web pages from anywhere but lit's
+// own, so a page open in a browser on this machine can't drive the node.
+// Browsers always send an Origin; other clients needn't.  The host has to
+// be a loopback one too, or a name rebound to 127.0.0.1 would get through.
+func checkOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != req.Host {
+		return fmt.Errorf("websocket from %s to %s refused", origin, req.Host)
+	}
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("websocket from %s refused; not loopback", origin)
+	}
+	return nil
+}
+
+// RPCListen serves rpcl on /ws, and each account's LitRPC on /ws/<name>.
+// They all register as LitRPC, so the calls are the same; only the path
+// changes.  They're served on localhost:port, unless port is 0, and on the
+// unix socket at socket if it's set; see unixsock.go.  Web pages can only
+// open them from lit's own origin; see checkOrigin.
+func RPCListen(rpcl *LitRPC, port uint16, socket string,
+	accounts map[string]*LitRPC) {
 
 	rpc.Register(rpcl)
 
 	listenString := fmt.Sprintf("localhost:%d", port)
 
-	http.Handle("/ws", websocket.Handler(serveWS))
+	http.Handle("/ws", websocket.Server{
+		Handler: rpcl.serveWS, Handshake: checkOrigin})
+	for name, arpc := range accounts {
+		arpc := arpc
+		srv := rpc.NewServer()
+		err := srv.RegisterName("LitRPC", arpc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("/ws/"+name, websocket.Server{
+			Handler: func(ws *websocket.Conn) {
+				srv.ServeCodec(arpc.ServerCodec(
+					jsonrpc.NewServerCodec(ws), RequestCaller(ws.Request())))
+			},
+			Handshake: checkOrigin})
+	}
+	http.HandleFunc("/healthz", rpcl.serveHealthz)
+
+	if socket != "" {
+		ln, err := listenUnix(socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("RPC on unix socket %s\n", socket)
+		if port == 0 {
+			log.Fatal(http.Serve(ln, nil))
+		}
+		go func() {
+			log.Fatal(http.Serve(ln, nil))
+		}()
+	}
 	log.Fatal(http.ListenAndServe(listenString, nil))
 }
@@ -3,6 +3,7 @@ package litrpc
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/qln"
@@ -136,3 +137,97 @@ func (r *LitRPC) Stop(args NoArgs, reply *StatusReply) error {
 	r.OffButton <- true
 	return nil
 }
+
+// ------------------------- peer report
+// PeerReportArgs says where payments and failures count from, in unix
+// seconds; 0 for the last 30 days
+type PeerReportArgs struct {
+	Since int64
+}
+
+type PeerReportReply struct {
+	Peers []qln.PeerScore // best first
+}
+
+// PeerReport scores the peers we've had channels with; see
+// qln/peerscore.go
+func (r *LitRPC) PeerReport(args PeerReportArgs, reply *PeerReportReply) error {
+	since := time.Now().Add(-30 * 24 * time.Hour).UnixNano()
+	if args.Since != 0 {
+		since = args.Since * 1e9
+	}
+	peers, err := r.Node.PeerReport(since)
+	if err != nil {
+		return err
+	}
+	reply.Peers = peers
+	return nil
+}
+
+// ------------------------- reload
+// ReloadConfig re-reads the config file and applies the settings which can
+// be changed while running (same as sending the node a SIGHUP)
+func (r *LitRPC) ReloadConfig(args NoArgs, reply *StatusReply) error {
+	if r.Reload == nil {
+		return fmt.Errorf("config reload not available")
+	}
+	err := r.Reload()
+	if err != nil {
+		return err
+	}
+	reply.Status = "config reloaded"
+	return nil
+}
+
+// ------------------------- log levels
+type LogLevelArgs struct {
+	// comma separated; a bare level sets all subsystems, subsystem=level
+	// sets one.  eg "info,qln=debug"
+	Levels string
+}
+type LogLevelReply struct {
+	Levels []string // subsystem=level for every subsystem
+}
+
+// SetLogLevels changes log levels while running.  An empty Levels just
+// returns the current levels.
+func (r *LitRPC) SetLogLevels(args LogLevelArgs, reply *LogLevelReply) error {
+	err := lnutil.SetLogLevels(args.Levels)
+	if err != nil {
+		return err
+	}
+	reply.Levels = lnutil.LogLevels()
+	return nil
+}
+
+// ------------------------- identity backup
+type IdentityBackupReply struct {
+	Backup qln.IdentityBackup
+}
+
+// ExportIdentity returns the node's ln address and address book, for
+// moving to another device.  Doesn't include any private keys.
+func (r *LitRPC) ExportIdentity(args NoArgs, reply *IdentityBackupReply) error {
+	b, err := r.Node.ExportIdentity()
+	if err != nil {
+		return err
+	}
+	reply.Backup = *b
+	return nil
+}
+
+type ImportIdentityArgs struct {
+	Backup qln.IdentityBackup
+}
+
+// ImportIdentity adds the address book from a backup of this same node.
+func (r *LitRPC) ImportIdentity(
+	args ImportIdentityArgs, reply *StatusReply) error {
+	added, err := r.Node.ImportIdentity(&args.Backup)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("imported %d new peers of %d",
+		added, len(args.Backup.Peers))
+	return nil
+}
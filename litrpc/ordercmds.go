@@ -0,0 +1,144 @@
+package litrpc
+
+import (
+	"encoding/hex"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// OrderInfo is a merchant order as shown over RPC, with its current
+// invoice
+type OrderInfo struct {
+	OrderID     string
+	Status      string // open, paid, expired or cancelled
+	Amt         int64  // satoshis; of the current invoice for fiat orders
+	FiatAmt     int64  // hundredths of Currency
+	Currency    string
+	Description string
+	Created     int64
+	ClosesAt    int64
+
+	Invoice     InvoiceInfo // the current one
+	NumInvoices int         // how many it's had
+
+	AmtPaid     int64
+	PaymentHash string // of the invoice that was paid
+	SettledAt   int64
+}
+
+func (r *LitRPC) orderInfo(o *qln.Order) OrderInfo {
+	var oi OrderInfo
+	oi.OrderID = o.ID
+	oi.Status = o.StatusString()
+	oi.Amt = o.Amt
+	oi.FiatAmt = o.FiatAmt
+	oi.Currency = o.Currency
+	oi.Description = o.Description
+	oi.Created = o.Created
+	oi.ClosesAt = o.ClosesAt
+	oi.NumInvoices = len(o.Invoices)
+	if inv, err := r.Node.GetInvoice(o.CurrentInvoice()); err == nil {
+		oi.Invoice = r.invoiceInfo(inv)
+	}
+	if o.Status == qln.OrderPaid {
+		oi.AmtPaid = o.AmtPaid
+		oi.PaymentHash = hex.EncodeToString(o.PaidHash[:])
+		oi.SettledAt = o.SettledAt
+	}
+	return oi
+}
+
+// ------------------------- createorder
+type CreateOrderArgs struct {
+	OrderID     string
+	Amt         int64 // satoshis, or
+	FiatAmt     int64 // hundredths of Currency, eg cents
+	Currency    string
+	Description string
+	Lifetime    int64 // seconds the order stays open; 0 for a day
+	Expiry      int64 // seconds each invoice lasts; 0 for 15 minutes
+}
+type OrderReply struct {
+	Order OrderInfo
+}
+
+// CreateOrder makes an order with a first invoice.  The node gives it a
+// new invoice whenever the current one expires, until it's paid or
+// closes.  Calling again with the same id and amount returns the order.
+func (r *LitRPC) CreateOrder(args CreateOrderArgs, reply *OrderReply) error {
+	o, err := r.Node.CreateOrder(args.OrderID, args.Amt, args.FiatAmt,
+		args.Currency, args.Description, args.Lifetime, args.Expiry)
+	if err != nil {
+		return err
+	}
+	reply.Order = r.orderInfo(o)
+	return nil
+}
+
+// ------------------------- getorder
+type OrderIDArgs struct {
+	OrderID string
+}
+
+// GetOrder shows an order, with the invoice to pay now
+func (r *LitRPC) GetOrder(args OrderIDArgs, reply *OrderReply) error {
+	o, err := r.Node.GetOrder(args.OrderID)
+	if err != nil {
+		return err
+	}
+	reply.Order = r.orderInfo(o)
+	return nil
+}
+
+// ------------------------- listorders
+type ListOrdersArgs struct {
+	OpenOnly bool
+}
+type ListOrdersReply struct {
+	Orders []OrderInfo
+}
+
+func (r *LitRPC) ListOrders(args ListOrdersArgs, reply *ListOrdersReply) error {
+	orders, err := r.Node.ListOrders(args.OpenOnly)
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		reply.Orders = append(reply.Orders, r.orderInfo(o))
+	}
+	return nil
+}
+
+// ------------------------- cancelorder
+// CancelOrder stops an open order getting new invoices.  Its current
+// invoice can still be paid until it expires.
+func (r *LitRPC) CancelOrder(args OrderIDArgs, reply *OrderReply) error {
+	o, err := r.Node.CancelOrder(args.OrderID)
+	if err != nil {
+		return err
+	}
+	reply.Order = r.orderInfo(o)
+	return nil
+}
+
+// ------------------------- waitorderpaid
+// WaitOrderPaid blocks until the next order is paid, then returns it.
+// Each order is paid exactly once, so each shows up here once, if you're
+// waiting when it happens; ListOrders shows the ones you missed.
+func (r *LitRPC) WaitOrderPaid(args NoArgs, reply *OrderReply) error {
+	sub := r.Node.SubscribeEvents()
+	defer r.Node.UnsubscribeEvents(sub)
+
+	for ev := range sub {
+		if ev.Type != qln.EventOrderPaid {
+			continue
+		}
+		o, err := r.Node.GetOrder(ev.OrderID)
+		if err != nil {
+			return err
+		}
+		reply.Order = r.orderInfo(o)
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package litrpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A spending policy limits what RPC callers can spend, for when lit is
+exposed to applications that are trusted to use it but not with all of
+it.  Every RPC that sends coins checks it first:
+
+	on chain:   Send, Sweep, Fanout, OfferSwap, AcceptSwap, SetHeir
+	off chain:  Push, Pay, FundChannel (the initial send), NewWithdraw
+
+Each spend has a kind, an amount and destinations: addresses on chain,
+the peer's lit address for channel spends, the lightning address or the
+payee's lit address for Pay, and "lnurl-withdraw" for withdraw links.
+
+  - DailyOnChain and DailyOffChain limit what's spent in any 24 hours.
+    Spends are counted when they start, and uncounted if they fail.
+  - Deny lists destinations that are never paid; if Allow has anything,
+    only those are.
+  - Spends over ConfirmAbove need approving first.  The approver, who
+    has ApprovalKey and the app doesn't, takes the spend as the error
+    describes it, eg "offchain 500000 to ln1abc", and calls ApproveSpend
+    with it and its HMAC-SHA256 under ApprovalKey; lit-af approve does
+    this.  The app then retries the same call within approvalLifetime.
+    Each approval is good for one spend.
+
+Spends in the last day are kept in a file, so restarting lit doesn't
+reset the limits.
+*/
+
+const (
+	SpendOnChain  = "onchain"
+	SpendOffChain = "offchain"
+
+	approvalLifetime = 10 * time.Minute
+	spendWindow      = 24 * time.Hour
+)
+
+// SpendPolicy limits spending over RPC.  A nil policy allows everything.
+type SpendPolicy struct {
+	DailyOnChain  int64 // satoshis; 0 for no limit
+	DailyOffChain int64
+	Allow         []string // if any, the only destinations allowed
+	Deny          []string
+	ConfirmAbove  int64 // satoshis; 0 for never
+	ApprovalKey   []byte
+
+	path string // where spends are kept
+
+	mtx       sync.Mutex
+	spends    []policySpend
+	approvals map[string]time.Time // spend description: when it expires
+}
+
+type policySpend struct {
+	Kind string
+	Amt  int64
+	At   int64 // unix time
+}
+
+// LoadSpendPolicy sets up p, reading spends so far from path
+func LoadSpendPolicy(p *SpendPolicy, path string) error {
+	if p.ConfirmAbove > 0 && len(p.ApprovalKey) < 16 {
+		return fmt.Errorf("confirming spends needs an approval key of 16+ bytes")
+	}
+	p.path = path
+	p.approvals = make(map[string]time.Time)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &p.spends)
+}
+
+// SpendDescription is what a spend is approved as
+func SpendDescription(kind string, amt int64, dests []string) string {
+	return fmt.Sprintf("%s %d to %s", kind, amt, strings.Join(dests, ","))
+}
+
+// SpendApproval is the approval code for a spend description
+func SpendApproval(key []byte, desc string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(desc))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// spend checks a spend against the policy, and counts it.  Call done with
+// whether the spend went through, so failed ones aren't counted.
+func (p *SpendPolicy) spend(kind string, amt int64, dests ...string) (
+	done func(ok bool), err error) {
+
+	if p == nil {
+		return func(bool) {}, nil
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	now := time.Now()
+	err = p.allowed(kind, amt, dests, now)
+	if err != nil {
+		return nil, err
+	}
+	if amt <= 0 {
+		return func(bool) {}, nil
+	}
+	desc := SpendDescription(kind, amt, dests)
+	if p.ConfirmAbove > 0 && amt > p.ConfirmAbove {
+		delete(p.approvals, desc)
+	}
+
+	rec := policySpend{Kind: kind, Amt: amt, At: now.Unix()}
+	p.spends = append(p.spends, rec)
+	p.save()
+	return func(ok bool) {
+		if ok {
+			return
+		}
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+		for i, s := range p.spends {
+			if s == rec {
+				p.spends = append(p.spends[:i], p.spends[i+1:]...)
+				break
+			}
+		}
+		p.save()
+	}, nil
+}
+
+// check says if the policy would let a spend through, without counting it
+// or using up an approval
+func (p *SpendPolicy) check(kind string, amt int64, dests ...string) error {
+	if p == nil {
+		return nil
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.allowed(kind, amt, dests, time.Now())
+}
+
+// allowed is check with mtx held
+func (p *SpendPolicy) allowed(
+	kind string, amt int64, dests []string, now time.Time) error {
+
+	for _, d := range dests {
+		if p.listed(p.Deny, d) {
+			return fmt.Errorf("policy: %s is denied", d)
+		}
+		if len(p.Allow) > 0 && !p.listed(p.Allow, d) {
+			return fmt.Errorf("policy: %s is not allowed", d)
+		}
+	}
+	if amt <= 0 {
+		return nil
+	}
+
+	limit := p.DailyOffChain
+	if kind == SpendOnChain {
+		limit = p.DailyOnChain
+	}
+	p.prune(now)
+	if limit > 0 {
+		var spent int64
+		for _, s := range p.spends {
+			if s.Kind == kind {
+				spent += s.Amt
+			}
+		}
+		if spent+amt > limit {
+			return fmt.Errorf(
+				"policy: %s limit %d a day; %d spent, %d more would be over",
+				kind, limit, spent, amt)
+		}
+	}
+
+	desc := SpendDescription(kind, amt, dests)
+	if p.ConfirmAbove > 0 && amt > p.ConfirmAbove {
+		exp, ok := p.approvals[desc]
+		if !ok || now.After(exp) {
+			return fmt.Errorf(
+				"policy: spends over %d need approval; approve \"%s\"",
+				p.ConfirmAbove, desc)
+		}
+	}
+	return nil
+}
+
+// listed says if dest is on a list; lit addresses and on chain
+// addresses are compared exactly, lightning addresses ignoring case
+func (p *SpendPolicy) listed(list []string, dest string) bool {
+	for _, l := range list {
+		if l == dest || (strings.Contains(dest, "@") && strings.EqualFold(l, dest)) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops spends and approvals too old to matter
+func (p *SpendPolicy) prune(now time.Time) {
+	cutoff := now.Add(-spendWindow).Unix()
+	var keep []policySpend
+	for _, s := range p.spends {
+		if s.At > cutoff {
+			keep = append(keep, s)
+		}
+	}
+	p.spends = keep
+	for desc, exp := range p.approvals {
+		if now.After(exp) {
+			delete(p.approvals, desc)
+		}
+	}
+}
+
+func (p *SpendPolicy) save() {
+	if p.path == "" {
+		return
+	}
+	b, err := json.Marshal(p.spends)
+	if err == nil {
+		err = ioutil.WriteFile(p.path, b, 0600)
+	}
+	if err != nil {
+		fmt.Printf("policy: saving spends: %s\n", err.Error())
+	}
+}
+
+// peerDest is a peer's lit address, to check against the lists
+func (r *LitRPC) peerDest(peerIdx uint32) string {
+	pub, _ := r.Node.GetPubHostFromPeerIdx(peerIdx)
+	return lnutil.LitAdrFromPubkey(pub)
+}
+
+// ------------------------- approvespend
+type ApproveSpendArgs struct {
+	Spend    string // as in the policy error, eg "offchain 500000 to ln1abc"
+	Approval string // hex HMAC-SHA256 of Spend under the approval key
+}
+
+// ApproveSpend lets one spend over the confirm threshold through, if it's
+// retried soon
+func (r *LitRPC) ApproveSpend(args ApproveSpendArgs, reply *StatusReply) error {
+	p := r.Policy
+	if p == nil || p.ConfirmAbove == 0 {
+		return fmt.Errorf("no spends need approval")
+	}
+	want := SpendApproval(p.ApprovalKey, args.Spend)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(args.Approval))) {
+		return fmt.Errorf("approval doesn't match")
+	}
+	p.mtx.Lock()
+	p.approvals[args.Spend] = time.Now().Add(approvalLifetime)
+	p.mtx.Unlock()
+	reply.Status = fmt.Sprintf("approved %s for %s", args.Spend, approvalLifetime)
+	return nil
+}
+
+// ------------------------- spendpolicy
+type SpendPolicyReply struct {
+	On            bool
+	DailyOnChain  int64
+	DailyOffChain int64
+	SpentOnChain  int64 // in the last 24 hours
+	SpentOffChain int64
+	Allow         []string
+	Deny          []string
+	ConfirmAbove  int64
+}
+
+// SpendPolicy shows the spending policy and what's been spent against it
+func (r *LitRPC) SpendPolicy(args NoArgs, reply *SpendPolicyReply) error {
+	p := r.Policy
+	if p == nil {
+		return nil
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.prune(time.Now())
+	reply.On = true
+	reply.DailyOnChain = p.DailyOnChain
+	reply.DailyOffChain = p.DailyOffChain
+	reply.Allow = p.Allow
+	reply.Deny = p.Deny
+	reply.ConfirmAbove = p.ConfirmAbove
+	for _, s := range p.spends {
+		if s.Kind == SpendOnChain {
+			reply.SpentOnChain += s.Amt
+		} else {
+			reply.SpentOffChain += s.Amt
+		}
+	}
+	return nil
+}
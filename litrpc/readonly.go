@@ -0,0 +1,141 @@
+package litrpc
+
+import (
+	"fmt"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+/*
+With readonlyrpc set, lit only answers the RPCs that look at things:
+balances, channels, payment and invoice history, the graph of peers,
+towers, and node health.  Nothing that moves funds, signs, changes config,
+or hands out keys.  That's for dashboards and analysts connecting to a
+node that's running for real.
+
+It's a list of what's allowed, so an RPC added later is refused until it's
+put here.  Refused calls never reach their method; they get an error back
+as if it had returned one.  /healthz and the events stream in litbamf
+aren't RPCs and stay up.  Calls made in process, like the lnurl server's,
+don't go through here either.
+*/
+
+// readOnlyMethods are the LitRPC methods a read only node still answers
+var readOnlyMethods = map[string]bool{
+	// wallet
+	"Balance":        true,
+	"TxoList":        true,
+	"GetFee":         true,
+	"VerifyReserves": true,
+	"ListBroadcasts": true,
+	"Heirs":          true,
+
+	// channels
+	"ChannelList":      true,
+	"ListHTLCFailures": true,
+	"ReplayChannel":    true,
+	"DryRunFund":       true,
+	"ChanOps":          true,
+
+	// payments and invoices
+	"ListPayments":       true,
+	"TrackPayment":       true,
+	"ListQueuedPayments": true,
+	"PayKeyStatus":       true,
+	"LookupInvoice":      true,
+	"ListInvoices":       true,
+	"SubscribeInvoices":  true,
+	"GetOrder":           true,
+	"ListOrders":         true,
+	"WaitOrderPaid":      true,
+	"ListSwaps":          true,
+	"ListLeases":         true,
+	"LeaseTiers":         true,
+
+	// fees and policy
+	"FeeReport":    true,
+	"ChartData":    true,
+	"DeferredList": true,
+	"SweepTargets": true,
+	"FiatRate":     true,
+	"SpendPolicy":  true,
+
+	// node and peers
+	"GetInfo":           true,
+	"WaitEvent":         true,
+	"SyncChanges":       true,
+	"ListConnections":   true,
+	"PeerReport":        true,
+	"GetListeningPorts": true,
+	"CloudBackupStatus": true,
+	"ListCheckpoints":   true,
+
+	// custom messages; reading doesn't take them from anyone else
+	"SubscribeCustomMessages": true,
+
+	// watchtower
+	"TowerStats":      true,
+	"ListKnownTowers": true,
+	"TowerAdmin":      true,
+	"ClientTowers":    true,
+}
+
+// readOnlyRefused is where a refused call is sent instead; there's no such
+// method, so net/rpc answers without calling anything
+const readOnlyRefused = "LitRPC.readOnlyRefused"
+
+// ReadOnlyAllowed says if a read only node answers method, given as
+// "LitRPC.Method" or just "Method"
+func ReadOnlyAllowed(method string) bool {
+	return readOnlyMethods[strings.TrimPrefix(method, "LitRPC.")]
+}
+
+// ServerCodec is c, writing calls to the audit log if there is one (see
+// audit.go), and refusing whatever's not allowed if r is read only.
+// Everything serving r's RPCs goes through it; caller is who's on the other
+// end, for the audit log.
+func (r *LitRPC) ServerCodec(c rpc.ServerCodec, caller string) rpc.ServerCodec {
+	if r.Audit != nil {
+		// under the read only codec, so it sees refused calls as they came
+		c = &auditCodec{ServerCodec: c, log: r.Audit, caller: caller,
+			account: r.Account, pending: make(map[uint64]*AuditEntry)}
+	}
+	if !r.ReadOnly {
+		return c
+	}
+	return &readOnlyCodec{ServerCodec: c, refused: make(map[uint64]string)}
+}
+
+// readOnlyCodec renames calls it refuses so net/rpc can't find them, then
+// puts the real reason in the error net/rpc sends back
+type readOnlyCodec struct {
+	rpc.ServerCodec
+
+	mtx     sync.Mutex
+	refused map[uint64]string // seq to the method asked for
+}
+
+func (c *readOnlyCodec) ReadRequestHeader(req *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(req)
+	if err != nil || ReadOnlyAllowed(req.ServiceMethod) {
+		return err
+	}
+	c.mtx.Lock()
+	c.refused[req.Seq] = req.ServiceMethod
+	c.mtx.Unlock()
+	req.ServiceMethod = readOnlyRefused
+	return nil
+}
+
+func (c *readOnlyCodec) WriteResponse(resp *rpc.Response, body interface{}) error {
+	c.mtx.Lock()
+	method, ok := c.refused[resp.Seq]
+	delete(c.refused, resp.Seq)
+	c.mtx.Unlock()
+	if ok {
+		resp.ServiceMethod = method
+		resp.Error = fmt.Sprintf("%s: node is read only", method)
+	}
+	return c.ServerCodec.WriteResponse(resp, body)
+}
@@ -0,0 +1,125 @@
+package litrpc
+
+import (
+	"encoding/hex"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+// SwapInfo is an atomic swap as shown over RPC.  Doesn't include the
+// preimage unless the swap is done.
+type SwapInfo struct {
+	Hash      string
+	Initiator bool
+	State     string
+	PeerIdx   uint32
+
+	MyCoin    uint32
+	MyAmt     int64
+	TheirCoin uint32
+	TheirAmt  int64
+
+	MyLocktime    uint32
+	TheirLocktime uint32
+	MyOutPoint    string
+	TheirOutPoint string
+
+	Created  int64
+	Preimage string
+}
+
+// swapInfo converts a qln swap for the RPC reply
+func swapInfo(s *qln.Swap) SwapInfo {
+	var si SwapInfo
+	si.Hash = hex.EncodeToString(s.Hash[:])
+	si.Initiator = s.Initiator
+	si.State = s.StateName()
+	si.PeerIdx = s.PeerIdx
+	si.MyCoin, si.MyAmt = s.MyCoin, s.MyAmt
+	si.TheirCoin, si.TheirAmt = s.TheirCoin, s.TheirAmt
+	si.MyLocktime = s.MyLocktime
+	si.TheirLocktime = s.TheirLocktime
+	si.MyOutPoint = s.MyOp.String()
+	si.TheirOutPoint = s.TheirOp.String()
+	si.Created = s.Created
+	if s.State == qln.SwapStateClaimed {
+		si.Preimage = hex.EncodeToString(s.Preimage[:])
+	}
+	return si
+}
+
+// ------------------------- offerswap
+type OfferSwapArgs struct {
+	Peer      uint32
+	MyCoin    uint32 // cointype we send
+	MyAmt     int64
+	TheirCoin uint32 // cointype we get
+	TheirAmt  int64
+	// blocks on MyCoin before we can take ours back; 0 for default
+	LockBlocks int32
+}
+type SwapReply struct {
+	Swap SwapInfo
+}
+
+// OfferSwap offers a peer an atomic swap.  The rest happens once they
+// accept.
+func (r *LitRPC) OfferSwap(args OfferSwapArgs, reply *SwapReply) error {
+	done, err := r.Policy.spend(SpendOnChain, args.MyAmt, r.peerDest(args.Peer))
+	if err != nil {
+		return err
+	}
+	s, err := r.Node.OfferSwap(args.Peer, args.MyCoin, args.MyAmt,
+		args.TheirCoin, args.TheirAmt, args.LockBlocks)
+	done(err == nil)
+	if err != nil {
+		return err
+	}
+	reply.Swap = swapInfo(s)
+	return nil
+}
+
+// ------------------------- acceptswap
+type SwapHashArgs struct {
+	Hash string // hex
+}
+
+// AcceptSwap accepts a swap a peer offered
+func (r *LitRPC) AcceptSwap(args SwapHashArgs, reply *SwapReply) error {
+	hash, err := decodeHash32(args.Hash)
+	if err != nil {
+		return err
+	}
+	offer, err := r.Node.GetSwap(hash)
+	if err != nil {
+		return err
+	}
+	done, err := r.Policy.spend(SpendOnChain, offer.MyAmt,
+		r.peerDest(offer.PeerIdx))
+	if err != nil {
+		return err
+	}
+	s, err := r.Node.AcceptSwap(hash)
+	done(err == nil)
+	if err != nil {
+		return err
+	}
+	reply.Swap = swapInfo(s)
+	return nil
+}
+
+// ------------------------- listswaps
+type ListSwapsReply struct {
+	Swaps []SwapInfo
+}
+
+func (r *LitRPC) ListSwaps(args NoArgs, reply *ListSwapsReply) error {
+	swaps, err := r.Node.ListSwaps()
+	if err != nil {
+		return err
+	}
+	for _, s := range swaps {
+		reply.Swaps = append(reply.Swaps, swapInfo(s))
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+// ------------------------- towerstats
+type TowerStatsReply struct {
+	Stats watchtower.TowerStats
+	// lit addresses of the clients it serves; none for anyone
+	Clients []string
+	// per coin, the last block checked and any left to go back for
+	Rescans []watchtower.RescanProgress
+}
+
+// TowerStats shows how much the watchtower is storing
+func (r *LitRPC) TowerStats(args NoArgs, reply *TowerStatsReply) error {
+	tower, ok := r.Node.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("no watchtower")
+	}
+	reply.Clients = tower.Clients()
+	var err error
+	reply.Rescans, err = tower.Rescans()
+	if err != nil {
+		return err
+	}
+	reply.Stats, err = tower.Stats()
+	return err
+}
+
+// ------------------------- listknowntowers
+type ListKnownTowersArgs struct {
+	CoinType uint32 // if set, also say which tower would be picked for it
+}
+
+type ListKnownTowersReply struct {
+	Towers []qln.KnownTower
+	Pick   string // pubkey of the tower PickTower chose, if any
+}
+
+// ListKnownTowers shows the towers heard of from peers and the directory
+func (r *LitRPC) ListKnownTowers(args ListKnownTowersArgs, reply *ListKnownTowersReply) error {
+	var err error
+	reply.Towers, err = r.Node.ListKnownTowers()
+	if err != nil {
+		return err
+	}
+	if args.CoinType != 0 {
+		kt, err := r.Node.PickTower(args.CoinType)
+		if err == nil {
+			reply.Pick = kt.TowerPub
+		}
+	}
+	return nil
+}
+
+// ------------------------- clienttowers
+type ClientTowersReply struct {
+	K      int // how many get every state
+	Towers []qln.ClientTower
+}
+
+// ClientTowers shows the towers this node uploads to, and the standbys
+func (r *LitRPC) ClientTowers(args NoArgs, reply *ClientTowersReply) error {
+	var err error
+	reply.K, reply.Towers, err = r.Node.ClientTowers()
+	return err
+}
+
+// ------------------------- addclienttower
+type ClientTowerArgs struct {
+	Tower string // pubkey hex, then @host unless it's a known tower
+}
+
+// AddClientTower adds a tower to upload to; see qln/towerset.go
+func (r *LitRPC) AddClientTower(args ClientTowerArgs, reply *StatusReply) error {
+	err := r.Node.AddClientTower(args.Tower)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("added tower %s", args.Tower)
+	return nil
+}
+
+// ------------------------- removeclienttower
+// RemoveClientTower stops uploading to a tower
+func (r *LitRPC) RemoveClientTower(args ClientTowerArgs, reply *StatusReply) error {
+	err := r.Node.RemoveClientTower(args.Tower)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("removed tower %s", args.Tower)
+	return nil
+}
+
+// ------------------------- settowerk
+type SetTowerKArgs struct {
+	K int
+}
+
+// SetTowerK sets how many of the added towers get every state
+func (r *LitRPC) SetTowerK(args SetTowerKArgs, reply *StatusReply) error {
+	err := r.Node.SetTowerK(args.K)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("uploading to %d towers", args.K)
+	return nil
+}
+
+// ------------------------- towerreport
+type TowerReportArgs struct {
+	Timeout int64 // seconds to wait for the tower; 0 for 30
+}
+type TowerReportReply struct {
+	Channels []qln.TowerReportChan
+}
+
+// TowerReport asks our watchtower what it has for each open channel, and
+// sends it again whatever it's missing
+func (r *LitRPC) TowerReport(args TowerReportArgs, reply *TowerReportReply) error {
+	wait := 30 * time.Second
+	if args.Timeout > 0 {
+		wait = time.Duration(args.Timeout) * time.Second
+	}
+	var err error
+	reply.Channels, err = r.Node.TowerReport(wait)
+	return err
+}
+
+// runningTower is the node's watchtower, if it's running
+func (r *LitRPC) runningTower() (*watchtower.WatchTower, error) {
+	tower, ok := r.Node.Tower.(*watchtower.WatchTower)
+	if !ok || tower.WatchDB == nil {
+		return nil, fmt.Errorf("no watchtower")
+	}
+	return tower, nil
+}
+
+// decodeAdminID reads a hex admin op id
+func decodeAdminID(s string) ([32]byte, error) {
+	var id [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return id, fmt.Errorf("admin op id %q isn't 32 bytes of hex", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// ------------------------- toweradmin
+type TowerAdminOpInfo struct {
+	ID        string
+	Op        string
+	Created   int64
+	Approvals int
+	Approved  bool // a restore, waiting for lit --restore
+}
+type TowerAdminReply struct {
+	Policy string // m:pubkey,...; empty if any one operator can do anything
+	Quota  uint32 // most channels; 0 for no limit
+	Ops    []TowerAdminOpInfo
+}
+
+// TowerAdmin shows the tower's admin policy and the ops waiting for
+// approval; see watchtower/admin.go
+func (r *LitRPC) TowerAdmin(args NoArgs, reply *TowerAdminReply) error {
+	tower, err := r.runningTower()
+	if err != nil {
+		return err
+	}
+	info, err := tower.Admin()
+	if err != nil {
+		return err
+	}
+	if info.Policy != nil {
+		reply.Policy = info.Policy.String()
+	}
+	reply.Quota = info.Quota
+	for _, op := range info.Ops {
+		id := op.ID()
+		reply.Ops = append(reply.Ops, TowerAdminOpInfo{
+			ID:        hex.EncodeToString(id[:]),
+			Op:        op.String(),
+			Created:   op.Created,
+			Approvals: len(op.Sigs),
+			Approved:  op.Approved,
+		})
+	}
+	return nil
+}
+
+type TowerAdminOpReply struct {
+	ID   string
+	Done bool // carried out, or for a restore, approved
+}
+
+// ------------------------- toweradminpropose
+type TowerAdminProposeArgs struct {
+	Kind string // drop, restore, quota or policy
+	Arg  string // channel pkh, archive sha256, channels, or m:pubkey,...
+}
+
+// TowerAdminPropose proposes an admin op for the operators to approve.
+// Without a policy it's done right away.
+func (r *LitRPC) TowerAdminPropose(
+	args TowerAdminProposeArgs, reply *TowerAdminOpReply) error {
+	tower, err := r.runningTower()
+	if err != nil {
+		return err
+	}
+	op, err := watchtower.ParseAdminOp(args.Kind, args.Arg)
+	if err != nil {
+		return err
+	}
+	id, done, err := tower.ProposeAdminOp(op)
+	if err != nil {
+		return err
+	}
+	reply.ID = hex.EncodeToString(id[:])
+	reply.Done = done
+	return nil
+}
+
+// ------------------------- toweradminapprove
+type TowerAdminApproveArgs struct {
+	ID  string
+	Pub string // operator's pubkey, hex
+	Sig string // their DER signature of the id, hex, from TowerAdminSign
+}
+
+// TowerAdminApprove adds an operator's approval to an admin op, and carries
+// it out once there are enough
+func (r *LitRPC) TowerAdminApprove(
+	args TowerAdminApproveArgs, reply *TowerAdminOpReply) error {
+	tower, err := r.runningTower()
+	if err != nil {
+		return err
+	}
+	id, err := decodeAdminID(args.ID)
+	if err != nil {
+		return err
+	}
+	var pub [33]byte
+	b, err := hex.DecodeString(args.Pub)
+	if err != nil || len(b) != 33 {
+		return fmt.Errorf("pubkey %q isn't 33 bytes of hex", args.Pub)
+	}
+	copy(pub[:], b)
+	sig, err := hex.DecodeString(args.Sig)
+	if err != nil {
+		return err
+	}
+	reply.ID = args.ID
+	reply.Done, err = tower.ApproveAdminOp(id, pub, sig)
+	return err
+}
+
+// ------------------------- toweradminsign
+type TowerAdminSignArgs struct {
+	ID string
+}
+type TowerAdminSignReply struct {
+	Pub string
+	Sig string
+}
+
+// TowerAdminSign signs a tower admin op id with this node's identity key,
+// for an operator to approve the op with on the tower
+func (r *LitRPC) TowerAdminSign(
+	args TowerAdminSignArgs, reply *TowerAdminSignReply) error {
+	id, err := decodeAdminID(args.ID)
+	if err != nil {
+		return err
+	}
+	sig, err := r.Node.IdKey().Sign(id[:])
+	if err != nil {
+		return err
+	}
+	reply.Pub = hex.EncodeToString(r.Node.IdKey().PubKey().SerializeCompressed())
+	reply.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
@@ -0,0 +1,52 @@
+package litrpc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+/*
+The RPCs can also be served on a unix socket, for scripts and tools on the
+same machine.  It's the same http server as the TCP listener, so /ws,
+/ws/<account> and /healthz all work, and lit-af -socket talks websocket
+over it.
+
+There's no login: whoever can open the socket can run every RPC, so the
+file permissions are the auth.  The socket is made owner only (0600), and
+by default lives in the lit dir, which is 0700.  To let a group in, chmod
+or chgrp the socket after lit starts, or put it in a directory the group
+can get to.  With rpcport=0 there's no TCP listener at all.
+*/
+
+// listenUnix listens on a unix socket at path, readable and writable only
+// by this user.  A socket left behind by a lit that's gone is removed; one
+// something's still listening on is an error.
+func listenUnix(path string) (net.Listener, error) {
+	fi, err := os.Lstat(path)
+	if err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		c, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			c.Close()
+			return nil, fmt.Errorf("something's already listening on %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -1,19 +1,29 @@
 package litrpc
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 
 	"golang.org/x/crypto/ripemd160"
 
 	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg"
 	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
 	"github.com/adiabat/btcutil/base58"
+	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/qln"
 )
 
 type TxidsReply struct {
 	Txids []string
+	// fee guard queue id, if it's waiting for fees to come down; see
+	// qln/feeguard.go
+	Deferred uint32
 }
 type StatusReply struct {
 	Status string
@@ -36,6 +46,37 @@ type CoinBalReply struct {
 	TxoTotal    int64 // all utxos
 	MatureWitty int64 // confirmed, spendable and witness
 	FeeRate     int64 // fee per byte
+
+	// breakdown of the above
+	Confirmed   int64 // utxos with at least 1 confirmation
+	Unconfirmed int64 // utxos not yet in a block
+	// utxos whose tx a reorg took out of the chain; not in Unconfirmed
+	Reorged int64
+	// utxos spent by txs not yet in a block; not in TxoTotal
+	SpendPending int64
+	// outputs from closed channels still timelocked or unconfirmed
+	Limbo      int64
+	ChanRemote int64 // the other sides' balances in our open channels
+	// amounts in state updates which haven't finished yet
+	InFlight int64
+	// part of ChanTotal which can't be pushed (min output + fee)
+	Reserve  int64
+	Channels []ChanBalance
+
+	// ChanTotal + TxoTotal in the node's fiat currency, eg "12.34 USD";
+	// empty if there's none
+	Fiat string
+}
+
+// ChanBalance is the balance breakdown of one open channel
+type ChanBalance struct {
+	CIdx     uint32
+	PeerIdx  uint32
+	Capacity int64
+	Local    int64
+	Remote   int64
+	Reserve  int64
+	InFlight int64 // negative if we're sending, positive if receiving
 }
 
 type BalanceReply struct {
@@ -71,13 +112,62 @@ func (r *LitRPC) Balance(args *NoArgs, reply *BalanceReply) error {
 		cbr.TxoTotal = allTxos.Sum()
 		cbr.MatureWitty = allTxos.SumWitness(cbr.SyncHeight)
 
+		states, err := wal.TxoStates()
+		if err != nil {
+			return err
+		}
+		reorged := make(map[wire.OutPoint]bool)
+		for _, ts := range states {
+			switch ts.State {
+			case lnutil.TxoReorged:
+				reorged[ts.Op] = true
+			case lnutil.TxoSpendPending:
+				cbr.SpendPending += ts.Value
+			}
+		}
+
+		for _, u := range allTxos {
+			if u.Height > 0 {
+				cbr.Confirmed += u.Value
+			} else if reorged[u.Op] {
+				cbr.Reorged += u.Value
+			} else {
+				cbr.Unconfirmed += u.Value
+			}
+			// only channel close outputs have a sequence delay
+			if u.Seq != 0 &&
+				(u.Height == 0 || u.Height+int32(u.Seq) > cbr.SyncHeight) {
+				cbr.Limbo += u.Value
+			}
+		}
+
 		// iterate through channels to figure out how much we have
 		for _, q := range qcs {
 			if q.Coin() == cointype && !q.CloseData.Closed {
 				cbr.ChanTotal += q.State.MyAmt
+
+				var cb ChanBalance
+				cb.CIdx = q.Idx()
+				cb.PeerIdx = q.Peer()
+				cb.Capacity = q.Value
+				cb.Local = q.State.MyAmt
+				cb.Remote = q.Value - q.State.MyAmt
+				cb.Reserve = q.LocalReserve()
+				cb.InFlight = int64(q.State.Delta)
+
+				cbr.ChanRemote += cb.Remote
+				cbr.Reserve += cb.Reserve
+				if cb.InFlight < 0 {
+					cbr.InFlight -= cb.InFlight
+				} else {
+					cbr.InFlight += cb.InFlight
+				}
+				cbr.Channels = append(cbr.Channels, cb)
 			}
 		}
 
+		cbr.Fiat = r.Node.FiatString(cbr.ChanTotal + cbr.TxoTotal)
+
 		// I thought slices were pointery enough that I could put this line
 		// near the top.  Guess not.
 		reply.Balances = append(reply.Balances, cbr)
@@ -92,6 +182,7 @@ type TxoInfo struct {
 	Delay    int32
 	CoinType string
 	Witty    bool
+	LockTime uint32 // can't be spent before the block after this
 
 	KeyPath string
 }
@@ -121,6 +212,12 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 			if u.Seq != 0 {
 				theseTxos[i].Delay = u.Height + int32(u.Seq) - syncHeight
 			}
+			if u.Hint == portxo.SpendHintCLTV {
+				theseTxos[i].LockTime = u.LockTime
+				if int32(u.LockTime) > syncHeight {
+					theseTxos[i].Delay = int32(u.LockTime) - syncHeight
+				}
+			}
 			theseTxos[i].Witty = u.Mode&portxo.FlagTxoWitness != 0
 			theseTxos[i].KeyPath = u.KeyGen.String()
 		}
@@ -136,9 +233,7 @@ type SendArgs struct {
 	Amts      []int64
 }
 
-func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
-	var err error
-
+func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) (err error) {
 	nOutputs := len(args.DestAddrs)
 	if nOutputs < 1 {
 		return fmt.Errorf("No destination address specified")
@@ -164,6 +259,16 @@ func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
 		}
 	}
 
+	var total int64
+	for _, amt := range args.Amts {
+		total += amt
+	}
+	done, err := r.Policy.spend(SpendOnChain, total, args.DestAddrs...)
+	if err != nil {
+		return err
+	}
+	defer func() { done(err == nil) }()
+
 	txOuts := make([]*wire.TxOut, nOutputs)
 	for i, s := range args.DestAddrs {
 		if args.Amts[i] < 10000 {
@@ -220,15 +325,160 @@ func (r *LitRPC) Sweep(args SweepArgs, reply *TxidsReply) error {
 		return fmt.Errorf("can't send %d txs", args.NumTx)
 	}
 
-	txids, err := wal.Sweep(outScript, args.NumTx)
+	// sweeps can wait out a fee spike; see qln/feeguard.go
+	var txids []string
+	act, err := r.Node.NonUrgent(coinType,
+		fmt.Sprintf("sweep %d txs to %s", args.NumTx, args.DestAdr),
+		func() error {
+			var err error
+			txids, err = r.sweep(wal, outScript, args)
+			return err
+		})
+	if act != nil {
+		reply.Deferred = act.ID
+		return nil
+	}
+	reply.Txids = txids
+	return err
+}
+
+// sweep does a Sweep, once the fee guard's let it
+func (r *LitRPC) sweep(
+	wal qln.UWallet, outScript []byte, args SweepArgs) ([]string, error) {
+	var err error
+	// the same utxos wal.Sweep takes: the biggest confirmed ones
+	var utxos portxo.TxoSliceByAmt
+	utxos, err = wal.UtxoDump()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(utxos))
+	var total int64
+	n := args.NumTx
+	for _, u := range utxos {
+		if n > 0 && u.Height != 0 && u.Value > 20000 {
+			total += u.Value
+			n--
+		}
+	}
+	// some of a sweep can go out before an error, so it stays counted
+	_, err = r.Policy.spend(SpendOnChain, total, args.DestAdr)
+	if err != nil {
+		return nil, err
+	}
+
+	// no hurry; see qln/sweepfees.go
+	feeRate, err := r.Node.SweepFeeRate(
+		wal.Params().HDCoinType, qln.SweepConsolidate, 0)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := wal.Sweep(outScript, args.NumTx, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var txids []string
+	for _, txid := range hashes {
+		txids = append(txids, txid.String())
+	}
+	return txids, nil
+}
+
+// ------------------------- sweepkey
+type SweepKeyArgs struct {
+	CoinType uint32
+	Key      string // WIF, or a raw 32 byte key in hex
+	From     int32  // height to look from; 0 for where the headers start
+}
+
+type SweepKeyReply struct {
+	Txid    string
+	Outputs int   // how many it swept
+	Total   int64 // what they had
+	Fee     int64
+}
+
+// SweepKey moves everything paying a private key from outside the wallet,
+// like a paper wallet's, into the wallet in one tx.  Going through the
+// blocks for it takes a while; see wallit/sweepkey.go.
+func (r *LitRPC) SweepKey(args SweepKeyArgs, reply *SweepKeyReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	param := wal.Params()
+
+	var priv *btcec.PrivateKey
+	var comp, uncomp bool
+	wif, err := btcutil.DecodeWIF(args.Key)
+	if err == nil {
+		if !wif.IsForNet(&chaincfg.Params{PrivateKeyID: param.PrivateKeyID}) {
+			return fmt.Errorf("key isn't for %s", param.Name)
+		}
+		priv = wif.PrivKey
+		comp = wif.CompressPubKey
+		uncomp = !wif.CompressPubKey
+	} else {
+		// a raw key could've been used with either pubkey
+		raw, hexErr := hex.DecodeString(args.Key)
+		if hexErr != nil || len(raw) != 32 {
+			return fmt.Errorf("key isn't WIF (%s) or 32 bytes of hex", err.Error())
+		}
+		priv, _ = btcec.PrivKeyFromBytes(btcec.S256(), raw)
+		comp, uncomp = true, true
+	}
+	if args.From == 0 {
+		args.From = param.StartHeight
+	}
+
+	tx, total, err := wal.SweepKey(priv, comp, uncomp, args.From, wal.Fee())
 	if err != nil {
 		return err
 	}
+	reply.Txid = tx.TxHash().String()
+	reply.Outputs = len(tx.TxIn)
+	reply.Total = total
+	reply.Fee = total - tx.TxOut[0].Value
+	return nil
+}
+
+// ------------------------- timelock
+type TimeLockArgs struct {
+	CoinType uint32
+	Amt      int64
+	Height   int32 // spendable in the block after this
+}
 
-	for _, txid := range txids {
-		reply.Txids = append(reply.Txids, txid.String())
+// TimeLock sends coins to the wallet in an output that can't be spent
+// until a block height.  They stay ours, so the spend policy doesn't count
+// them.
+func (r *LitRPC) TimeLock(args TimeLockArgs, reply *TxidsReply) error {
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	if args.Amt < 10000 {
+		return fmt.Errorf("Amt %d less than min 10000", args.Amt)
+	}
+
+	txo, err := wal.NewLockedOut(args.Amt, args.Height)
+	if err != nil {
+		return err
+	}
+	ops, err := wal.MaybeSend([]*wire.TxOut{txo}, false)
+	if err != nil {
+		return err
+	}
+	err = wal.ReallySend(&ops[0].Hash)
+	if err != nil {
+		return err
 	}
 
+	reply.Txids = append(reply.Txids, ops[0].String())
 	return nil
 }
 
@@ -239,7 +489,7 @@ type FanArgs struct {
 	AmtPerOutput int64
 }
 
-func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) error {
+func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) (err error) {
 	if args.NumOutputs < 1 {
 		return fmt.Errorf("Must have at least 1 output")
 	}
@@ -261,6 +511,13 @@ func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) error {
 		return err
 	}
 
+	done, err := r.Policy.spend(SpendOnChain,
+		int64(args.NumOutputs)*args.AmtPerOutput, args.DestAdr)
+	if err != nil {
+		return err
+	}
+	defer func() { done(err == nil) }()
+
 	txos := make([]*wire.TxOut, args.NumOutputs)
 
 	for i, _ := range txos {
@@ -295,6 +552,7 @@ type FeeArgs struct {
 }
 type FeeReply struct {
 	CurrentFee int64
+	Ceiling    int64 // fee guard ceiling; 0 if none.  See qln/feeguard.go
 }
 
 // SetFee allows you to set a fee rate for a wallet.
@@ -312,6 +570,7 @@ func (r *LitRPC) SetFee(args *SetFeeArgs, reply *FeeReply) error {
 		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
 	}
 	reply.CurrentFee = wal.SetFee(args.Fee)
+	reply.Ceiling = r.Node.FeeCeiling()
 	return nil
 }
 
@@ -327,22 +586,73 @@ func (r *LitRPC) GetFee(args *FeeArgs, reply *FeeReply) error {
 		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
 	}
 	reply.CurrentFee = wal.Fee()
+	reply.Ceiling = r.Node.FeeCeiling()
 	return nil
 }
 
 // ------------------------- address
+
+// Address types the wallet can make.  Both come from the same key, so the
+// wallet receives on either; it can't yet receive on p2sh-segwit or
+// bech32m (taproot) outputs, so won't give those out.
+const (
+	AdrTypeBech32     = "bech32" // p2wpkh; the default
+	AdrTypeLegacy     = "legacy" // p2pkh
+	AdrTypeP2SHSegwit = "p2sh-segwit"
+	AdrTypeBech32m    = "bech32m"
+)
+
 type AddressArgs struct {
 	NumToMake uint32
 	CoinType  uint32
+	// Type is which AdrType the Addresses in the reply are; "" for bech32
+	Type string
+	// Account is the account to make addresses in; 0 is the standard
+	// wallet.  When listing, non-0 lists only that account's.
+	Account uint32
 }
+
+// AddressInfo is an address and where its key is from
+type AddressInfo struct {
+	Address  string
+	Type     string
+	CoinType uint32
+	Account  uint32
+	Path     string // derivation path of its key
+}
+
 type AddressReply struct {
 	WitAddresses    []string
 	LegacyAddresses []string
+	// Addresses are the same addresses, as the type asked for
+	Addresses []AddressInfo
+}
+
+// checkAdrType gives the address type to use for what's asked for, or why
+// it can't be
+func checkAdrType(t string) (string, error) {
+	switch t {
+	case "", AdrTypeBech32:
+		return AdrTypeBech32, nil
+	case AdrTypeLegacy:
+		return AdrTypeLegacy, nil
+	case AdrTypeP2SHSegwit, AdrTypeBech32m:
+		return "", fmt.Errorf("wallet can't receive on %s addresses yet; use %s or %s",
+			t, AdrTypeBech32, AdrTypeLegacy)
+	}
+	return "", fmt.Errorf("unknown address type %q; use %s or %s",
+		t, AdrTypeBech32, AdrTypeLegacy)
 }
 
 func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 	var allAdr [][20]byte
 	var ctypesPerAdr []uint32
+	var kgsPerAdr []portxo.KeyGen
+
+	adrType, err := checkAdrType(args.Type)
+	if err != nil {
+		return err
+	}
 
 	// if cointype is 0, use the node's default coin
 	if args.CoinType == 0 {
@@ -360,10 +670,18 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 				return err
 			}
 
-			for _, _ = range walAdr {
+			for _, a := range walAdr {
+				kg, err := wal.AdrPath(a)
+				if err != nil {
+					return err
+				}
+				if args.Account != 0 && kg.Step[3]&0x7fffffff != args.Account {
+					continue
+				}
+				allAdr = append(allAdr, a)
 				ctypesPerAdr = append(ctypesPerAdr, cointype)
+				kgsPerAdr = append(kgsPerAdr, kg)
 			}
-			allAdr = append(allAdr, walAdr...)
 		}
 	} else {
 		// if you have non-zero NumToMake, then cointype matters
@@ -372,21 +690,23 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 			return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
 		}
 
-		// call NewAdr a bunch of times
+		// call NewAccountAdr a bunch of times
 		remaining := args.NumToMake
 		for remaining > 0 {
-			adr, err := wal.NewAdr()
+			adr, kg, err := wal.NewAccountAdr(args.Account)
 			if err != nil {
 				return err
 			}
 			allAdr = append(allAdr, adr)
 			ctypesPerAdr = append(ctypesPerAdr, args.CoinType)
+			kgsPerAdr = append(kgsPerAdr, kg)
 			remaining--
 		}
 	}
 
 	reply.WitAddresses = make([]string, len(allAdr))
 	reply.LegacyAddresses = make([]string, len(allAdr))
+	reply.Addresses = make([]AddressInfo, len(allAdr))
 
 	for i, a := range allAdr {
 		// convert 20 byte array to old address
@@ -406,6 +726,14 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 			return err
 		}
 		reply.WitAddresses[i] = bech32adr
+
+		info := AddressInfo{Address: bech32adr, Type: adrType,
+			CoinType: ctypesPerAdr[i], Account: kgsPerAdr[i].Step[3] & 0x7fffffff,
+			Path: "m" + kgsPerAdr[i].String()}
+		if adrType == AdrTypeLegacy {
+			info.Address = oldadr
+		}
+		reply.Addresses[i] = info
 	}
 
 	return nil
@@ -418,3 +746,41 @@ func oldAddressPubKeyHash(pkHash []byte, netID byte) (string, error) {
 	}
 	return base58.CheckEncode(pkHash, netID), nil
 }
+
+// ------------------------- proofofreserves
+type ReservesArgs struct {
+	CoinType uint32
+	Height   int32 // 0 for the wallet's current height, which is all it can do
+	// the auditor's; goes in every signature so the proof is fresh
+	Challenge string
+}
+type ReservesReply struct {
+	Proof qln.ReservesProof
+}
+
+// ProofOfReserves signs for a wallet's confirmed utxos and channel
+// balances at its current height; see qln/reserves.go
+func (r *LitRPC) ProofOfReserves(args ReservesArgs, reply *ReservesReply) error {
+	if args.Challenge == "" {
+		return fmt.Errorf("need a challenge from whoever's checking")
+	}
+	p, err := r.Node.ProofOfReserves(args.CoinType, args.Height, args.Challenge)
+	if err != nil {
+		return err
+	}
+	reply.Proof = *p
+	return nil
+}
+
+// VerifyReserves checks a proof's signatures and total.  The outputs being
+// on chain and unspent is for the checker to look up.
+func (r *LitRPC) VerifyReserves(args ReservesReply, reply *StatusReply) error {
+	err := args.Proof.Verify()
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("%s holds %d on coin %d at height %d: %d utxos, %d channels",
+		args.Proof.LitAdr, args.Proof.Total, args.Proof.CoinType,
+		args.Proof.Height, len(args.Proof.Utxos), len(args.Proof.Channels))
+	return nil
+}
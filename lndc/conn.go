@@ -65,7 +65,7 @@ func (c *LNDConn) Dial(
 		}
 
 		// First, open the TCP connection itself.
-		c.Conn, err = net.Dial("tcp", netAddress)
+		c.Conn, err = lnutil.Dial("tcp", netAddress)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,6 @@
+package lndc
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the lndc subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("lndc")
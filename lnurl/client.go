@@ -0,0 +1,181 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+The client side: paying a lightning address (user@domain).  We look up
+  https://domain/.well-known/lnurlp/user
+to get the payRequest, ask the callback for an invoice for the amount,
+check the invoice commits to the metadata we were shown and is for the
+right amount, and pay it.
+*/
+
+// how long to wait on the remote lnurl server
+const clientTimeout = 30 * time.Second
+
+var httpClient = lnutil.HTTPClient(clientTimeout)
+
+// PayParams is a fetched payRequest
+type PayParams struct {
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"` // msat
+	MaxSendable int64  `json:"maxSendable"` // msat
+	Metadata    string `json:"metadata"`
+	Tag         string `json:"tag"`
+}
+
+// errReply is what lnurl servers send when something's wrong
+type errReply struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// IsAddress says whether s looks like a lightning address rather than a
+// payment request.
+func IsAddress(s string) bool {
+	at := strings.Index(s, "@")
+	return at > 0 && at < len(s)-1 && !strings.Contains(s[at+1:], "@")
+}
+
+// AddressURL returns the well-known url for a lightning address.  Local
+// hosts get http, since they won't have certificates; everything else is
+// https only.
+func AddressURL(addr string) (string, error) {
+	if !IsAddress(addr) {
+		return "", fmt.Errorf("%s isn't a user@domain address", addr)
+	}
+	at := strings.Index(addr, "@")
+	user, domain := addr[:at], addr[at+1:]
+	scheme := "https"
+	if isLocalHost(domain) {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/.well-known/lnurlp/%s",
+		scheme, domain, url.PathEscape(user)), nil
+}
+
+// isLocalHost says whether a domain, maybe with a port, is this machine.
+// It has to be all of the host; localhost.example.com isn't local.
+func isLocalHost(domain string) bool {
+	host, _, err := net.SplitHostPort(domain)
+	if err != nil {
+		host = domain // no port
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// getJSON fetches a url into v, catching lnurl error replies
+func getJSON(u string, v interface{}) error {
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+
+	var raw json.RawMessage
+	err = json.NewDecoder(resp.Body).Decode(&raw)
+	if err != nil {
+		return err
+	}
+	var er errReply
+	if json.Unmarshal(raw, &er) == nil && er.Status == "ERROR" {
+		return fmt.Errorf("lnurl error: %s", er.Reason)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// ResolveAddress fetches the payRequest for a lightning address
+func ResolveAddress(addr string) (*PayParams, error) {
+	u, err := AddressURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	p := new(PayParams)
+	err = getJSON(u, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Tag != "payRequest" {
+		return nil, fmt.Errorf("%s gave tag %s, expect payRequest", addr, p.Tag)
+	}
+	if p.Callback == "" {
+		return nil, fmt.Errorf("%s gave no callback", addr)
+	}
+	return p, nil
+}
+
+// FetchInvoice asks the callback for a payment request for amt satoshis,
+// and checks it's the one we asked for.
+func (p *PayParams) FetchInvoice(amt int64) (string, error) {
+	msat := amt * 1000
+	if msat < p.MinSendable || msat > p.MaxSendable {
+		return "", fmt.Errorf("amount %d sat not between %d and %d msat",
+			amt, p.MinSendable, p.MaxSendable)
+	}
+
+	cb, err := url.Parse(p.Callback)
+	if err != nil {
+		return "", err
+	}
+	q := cb.Query()
+	q.Set("amount", fmt.Sprintf("%d", msat))
+	cb.RawQuery = q.Encode()
+
+	var resp struct {
+		PR string `json:"pr"`
+	}
+	err = getJSON(cb.String(), &resp)
+	if err != nil {
+		return "", err
+	}
+
+	pr, err := qln.DecodePayReq(resp.PR)
+	if err != nil {
+		return "", err
+	}
+	if pr.DescHash != sha256.Sum256([]byte(p.Metadata)) {
+		return "", fmt.Errorf("invoice description hash doesn't match metadata")
+	}
+	if pr.Amt != amt {
+		return "", fmt.Errorf("asked for invoice of %d, got %d", amt, pr.Amt)
+	}
+	return resp.PR, nil
+}
+
+// PayAddress pays amt satoshis to a lightning address from the node.
+// Returns the payment request that was paid and the channel it went
+// through.
+func PayAddress(node *qln.LitNode, addr string, amt int64) (string, uint32, error) {
+	if amt < 1 {
+		return "", 0, fmt.Errorf("need an amount to pay %s", addr)
+	}
+	p, err := ResolveAddress(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	payReq, err := p.FetchInvoice(amt)
+	if err != nil {
+		return "", 0, err
+	}
+	cIdx, err := node.PayInvoice(payReq, amt)
+	return payReq, cIdx, err
+}
@@ -0,0 +1,6 @@
+package lnurl
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the lnurl subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("lnurl")
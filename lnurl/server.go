@@ -0,0 +1,344 @@
+package lnurl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adiabat/bech32"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+LNURL lets a wallet get an invoice (or give us one to pay) over plain
+https, so a node can put a static QR code or user@host address somewhere
+and have people pay it without any other software in the way.
+
+LNURL-pay:
+  GET /.well-known/lnurlp/<user>  (lightning address form)
+  GET /lnurlp/<user>              (what the bech32 lnurl points to)
+returns a payRequest with min / max amounts and metadata.  The wallet
+then calls
+  GET /lnurlp/<user>/callback?amount=<msat>[&comment=<text>]
+and gets back {"pr": <payment request>}.  The invoice description hash is
+the sha256 of the metadata string, so the wallet can check it.  A comment
+(LUD-12) is kept with the invoice as its payer data.
+
+LNURL-withdraw:
+NewWithdraw makes a one-shot withdraw link for up to some amount.  The
+wallet calls
+  GET /lnurlw/<k1>
+to get the withdrawRequest, then
+  GET /lnurlw/<k1>/callback?k1=<k1>&pr=<payment request>
+and we pay their request.  Each link can only be used once.
+
+Amounts over LNURL are in millisatoshis; lit channels only do whole
+satoshis so anything under 1000 msat is rounded down.  Payment requests
+are lit's own format (see qln/invoice.go), not bolt11.
+*/
+
+// Server serves LNURL-pay and LNURL-withdraw for a lit node.
+type Server struct {
+	Node    *qln.LitNode
+	BaseURL string   // public base url wallets reach us at, eg https://host
+	Users   []string // names that can be paid; nil allows any
+
+	MinSend int64 // satoshis
+	MaxSend int64 // satoshis
+
+	mtx       sync.Mutex
+	withdraws map[string]*withdraw // keyed by hex k1
+}
+
+// a withdraw link which hasn't been used yet
+type withdraw struct {
+	MaxAmt int64 // satoshis
+	Desc   string
+}
+
+// default pay limits, in satoshis
+const (
+	defaultMinSend = 1
+	defaultMaxSend = 1000000
+)
+
+// longest comment a payer can send with a payment, kept as the invoice's
+// payer data
+const maxComment = 255
+
+// NewServer makes an lnurl server for the node.  baseURL is what wallets
+// will use to reach us, without a trailing slash.
+func NewServer(node *qln.LitNode, baseURL string, users []string) *Server {
+	s := new(Server)
+	s.Node = node
+	s.BaseURL = strings.TrimSuffix(baseURL, "/")
+	s.Users = users
+	s.MinSend = defaultMinSend
+	s.MaxSend = defaultMaxSend
+	s.withdraws = make(map[string]*withdraw)
+	return s
+}
+
+// Listen serves lnurl requests on addr.  Doesn't return unless the
+// listener fails.
+func (s *Server) Listen(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/lnurlp/", s.servePay)
+	mux.HandleFunc("/lnurlp/", s.servePay)
+	mux.HandleFunc("/lnurlw/", s.serveWithdraw)
+	logger.Infof("lnurl listening on %s as %s\n", addr, s.BaseURL)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Encode returns the bech32 "lnurl" form of a url, which is what goes in
+// QR codes.
+func Encode(u string) string {
+	return strings.ToUpper(bech32.Encode("lnurl", []byte(u)))
+}
+
+// PayURL returns the lnurl-pay url for a user
+func (s *Server) PayURL(user string) string {
+	return s.BaseURL + "/lnurlp/" + url.PathEscape(user)
+}
+
+// userOK says whether we take payments for this name
+func (s *Server) userOK(user string) bool {
+	if user == "" {
+		return false
+	}
+	if s.Users == nil {
+		return true
+	}
+	for _, u := range s.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata is the json metadata for a user; the invoice description hash
+// commits to this exact string.
+func (s *Server) metadata(user string) string {
+	host := s.BaseURL
+	if u, err := url.Parse(s.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	md := [][]string{
+		{"text/plain", fmt.Sprintf("Payment to %s", user)},
+		{"text/identifier", user + "@" + host},
+	}
+	b, _ := json.Marshal(md)
+	return string(b)
+}
+
+// splitPath breaks the path after a prefix into the name and whether it's
+// a callback.
+func splitPath(path, prefix string) (string, bool) {
+	rest := strings.TrimPrefix(path, prefix)
+	if strings.HasSuffix(rest, "/callback") {
+		return strings.TrimSuffix(rest, "/callback"), true
+	}
+	return rest, false
+}
+
+// ------------------------- pay
+
+type payRequest struct {
+	Tag         string `json:"tag"`
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"`
+	MaxSendable int64  `json:"maxSendable"`
+	Metadata    string `json:"metadata"`
+	// longest comment the payer can send (LUD-12)
+	CommentAllowed int `json:"commentAllowed,omitempty"`
+}
+
+type payResponse struct {
+	PR     string        `json:"pr"`
+	Routes []interface{} `json:"routes"`
+}
+
+func (s *Server) servePay(w http.ResponseWriter, r *http.Request) {
+	prefix := "/lnurlp/"
+	if strings.HasPrefix(r.URL.Path, "/.well-known/lnurlp/") {
+		prefix = "/.well-known/lnurlp/"
+	}
+	user, callback := splitPath(r.URL.Path, prefix)
+	if !s.userOK(user) {
+		writeError(w, fmt.Sprintf("no user %s", user))
+		return
+	}
+
+	if !callback {
+		var pr payRequest
+		pr.Tag = "payRequest"
+		pr.Callback = s.PayURL(user) + "/callback"
+		pr.MinSendable = s.MinSend * 1000
+		pr.MaxSendable = s.MaxSend * 1000
+		pr.Metadata = s.metadata(user)
+		pr.CommentAllowed = maxComment
+		writeJSON(w, pr)
+		return
+	}
+
+	msat, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+	if err != nil {
+		writeError(w, "bad amount")
+		return
+	}
+	amt := msat / 1000
+	if amt < s.MinSend || amt > s.MaxSend {
+		writeError(w, fmt.Sprintf("amount %d sat not between %d and %d",
+			amt, s.MinSend, s.MaxSend))
+		return
+	}
+
+	comment := r.URL.Query().Get("comment")
+	if len(comment) > maxComment {
+		writeError(w, fmt.Sprintf("comment over %d bytes", maxComment))
+		return
+	}
+
+	descHash := sha256.Sum256([]byte(s.metadata(user)))
+	inv, err := s.Node.AddInvoice(amt, "lnurl-pay to "+user, &descHash, 0,
+		map[string]string{"lnurl_user": user})
+	if err != nil {
+		logger.Errorf("lnurl AddInvoice error %s\n", err.Error())
+		writeError(w, "couldn't make invoice")
+		return
+	}
+	if comment != "" {
+		err = s.Node.AddPayerData(inv.PaymentHash,
+			map[string]string{"comment": comment})
+		if err != nil {
+			logger.Errorf("lnurl comment error %s\n", err.Error())
+			writeError(w, "couldn't save comment")
+			return
+		}
+	}
+
+	var resp payResponse
+	resp.PR = inv.PayReq(s.litAdr())
+	resp.Routes = []interface{}{}
+	writeJSON(w, resp)
+}
+
+// litAdr is the node's ln address
+func (s *Server) litAdr() string {
+	var idPub [33]byte
+	copy(idPub[:], s.Node.IdKey().PubKey().SerializeCompressed())
+	return lnutil.LitAdrFromPubkey(idPub)
+}
+
+// ------------------------- withdraw
+
+type withdrawRequest struct {
+	Tag                string `json:"tag"`
+	Callback           string `json:"callback"`
+	K1                 string `json:"k1"`
+	MinWithdrawable    int64  `json:"minWithdrawable"`
+	MaxWithdrawable    int64  `json:"maxWithdrawable"`
+	DefaultDescription string `json:"defaultDescription"`
+}
+
+// NewWithdraw makes a one-shot withdraw link for up to maxAmt satoshis.
+// Returns the bech32 lnurl to give the wallet.
+func (s *Server) NewWithdraw(maxAmt int64, desc string) (string, error) {
+	if maxAmt < 1 {
+		return "", fmt.Errorf("withdraw amount %d too small", maxAmt)
+	}
+	var k1 [32]byte
+	_, err := rand.Read(k1[:])
+	if err != nil {
+		return "", err
+	}
+	k1hex := hex.EncodeToString(k1[:])
+
+	s.mtx.Lock()
+	s.withdraws[k1hex] = &withdraw{MaxAmt: maxAmt, Desc: desc}
+	s.mtx.Unlock()
+
+	return Encode(s.BaseURL + "/lnurlw/" + k1hex), nil
+}
+
+func (s *Server) serveWithdraw(w http.ResponseWriter, r *http.Request) {
+	k1, callback := splitPath(r.URL.Path, "/lnurlw/")
+
+	s.mtx.Lock()
+	wd, ok := s.withdraws[k1]
+	if ok && callback {
+		// used up as soon as a callback comes in, whatever happens next
+		delete(s.withdraws, k1)
+	}
+	s.mtx.Unlock()
+	if !ok {
+		writeError(w, "unknown or used withdraw link")
+		return
+	}
+
+	if !callback {
+		var wr withdrawRequest
+		wr.Tag = "withdrawRequest"
+		wr.Callback = s.BaseURL + "/lnurlw/" + k1 + "/callback"
+		wr.K1 = k1
+		wr.MinWithdrawable = 1000
+		wr.MaxWithdrawable = wd.MaxAmt * 1000
+		wr.DefaultDescription = wd.Desc
+		writeJSON(w, wr)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("k1") != k1 {
+		writeError(w, "k1 mismatch")
+		return
+	}
+	pr, err := qln.DecodePayReq(q.Get("pr"))
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+	if pr.Amt > wd.MaxAmt {
+		writeError(w, fmt.Sprintf("request for %d, limit %d", pr.Amt, wd.MaxAmt))
+		return
+	}
+	amt := pr.Amt
+	if amt == 0 {
+		amt = wd.MaxAmt
+	}
+
+	// the spec lets us reply OK and pay after, but paying first means the
+	// wallet hears about failures
+	_, err = s.Node.PayInvoice(q.Get("pr"), amt)
+	if err != nil {
+		logger.Errorf("lnurl withdraw %s error %s\n", k1, err.Error())
+		writeError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "OK"})
+}
+
+// ------------------------- responses
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Errorf("lnurl write error %s\n", err.Error())
+	}
+}
+
+// writeError sends an lnurl error; these are still http 200 per the spec.
+func writeError(w http.ResponseWriter, reason string) {
+	writeJSON(w, map[string]string{"status": "ERROR", "reason": reason})
+}
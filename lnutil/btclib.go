@@ -16,6 +16,7 @@ import (
 type TxAndHeight struct {
 	Tx     *wire.MsgTx
 	Height int32
+	TxIdx  uint32 // position in the block; 0 if unconfirmed or not known
 }
 
 // OutPointEvent is a message describing events concerning an outpoint.
@@ -27,6 +28,7 @@ type OutPointEvent struct {
 	Op     wire.OutPoint // the outpoint being described
 	Height int32         // the height of the event
 	Tx     *wire.MsgTx   // the tx spending the outpoint
+	TxIdx  uint32        // position in the block of the tx creating it, if known
 }
 
 // need this because before I was comparing pointers maybe?
@@ -42,7 +42,21 @@ func OptColor(optional ...interface{}) string {
 	return s + tail
 }
 
+// SatoshiAnnotate, if set, is shown after every SatoshiColor amount, eg to
+// give it in fiat.  Front ends set it; the node doesn't.
+var SatoshiAnnotate func(value int64) string
+
 func SatoshiColor(value int64) string {
+	s := satoshiColor(value)
+	if SatoshiAnnotate != nil {
+		if note := SatoshiAnnotate(value); note != "" {
+			s += " " + Satoshi("("+note+")")
+		}
+	}
+	return s
+}
+
+func satoshiColor(value int64) string {
 
 	uBTC := value / 100
 	mBTC := uBTC / 1000
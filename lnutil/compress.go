@@ -0,0 +1,113 @@
+package lnutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+/*
+Compressed messages carry several messages in one, deflated:
+
+	MSGID_COMPRESSED (1) | algorithm (1) | compressed( length (2) | message ... )
+
+Each message inside is as it would have gone on its own, envelope and
+all.  It's for bulk sends, like a backlog of tower uploads: one message
+rarely shrinks, since most of it is keys, sigs and hashes, but a run of
+them repeats a lot (the same channel, coin and layout each time).
+
+Only sent to peers with FeatureCompression.  Deflate's the only
+algorithm, being in the standard library; the byte's there so others can
+be added.
+*/
+
+// compression algorithms
+const (
+	CompressDeflate = 1
+)
+
+// MaxCompressedMsg is the most a compressed message can be, to fit in an
+// lndc record
+const MaxCompressedMsg = 65000
+
+// maxInflated is the most a compressed message can inflate to, so a small
+// one can't take all the memory
+const maxInflated = 1 << 20
+
+// CompressedMsg is several messages sent deflated together
+type CompressedMsg struct {
+	PeerIdx uint32
+	Algo    uint8
+	Msgs    [][]byte // serialized, each as it'd be sent alone
+}
+
+// NewCompressedMsg packs as many of msgs as fit, in order, into a
+// compressed message.  Returns it and how many it took; 0 if not even the
+// first fits.
+func NewCompressedMsg(peerIdx uint32, msgs [][]byte) (CompressedMsg, int) {
+	cm := CompressedMsg{PeerIdx: peerIdx, Algo: CompressDeflate}
+	// deflate only grows data it can't shrink by a few bytes per 16K, so
+	// going by the raw size with some room keeps it under
+	raw := 0
+	for _, m := range msgs {
+		raw += 2 + len(m)
+		if raw > MaxCompressedMsg-64 || len(m) > 0xffff {
+			break
+		}
+		cm.Msgs = append(cm.Msgs, m)
+	}
+	return cm, len(cm.Msgs)
+}
+
+// NewCompressedMsgFromBytes inflates a compressed message
+func NewCompressedMsgFromBytes(b []byte, peerid uint32) (CompressedMsg, error) {
+	cm := CompressedMsg{PeerIdx: peerid}
+	if len(b) < 2 {
+		return cm, fmt.Errorf("got %d byte compressed msg, expect 2+", len(b))
+	}
+	cm.Algo = b[1]
+	if cm.Algo != CompressDeflate {
+		return cm, fmt.Errorf("unknown compression %d", cm.Algo)
+	}
+	r := flate.NewReader(bytes.NewReader(b[2:]))
+	defer r.Close()
+	inner, err := ioutil.ReadAll(io.LimitReader(r, maxInflated+1))
+	if err != nil {
+		return cm, err
+	}
+	if len(inner) > maxInflated {
+		return cm, fmt.Errorf("compressed msg inflates past %d bytes", maxInflated)
+	}
+	for len(inner) > 0 {
+		if len(inner) < 2 {
+			return cm, fmt.Errorf("compressed msg has %d stray bytes", len(inner))
+		}
+		n := int(binary.BigEndian.Uint16(inner[:2]))
+		if n < 1 || len(inner)-2 < n {
+			return cm, fmt.Errorf("compressed msg says %d bytes, has %d",
+				n, len(inner)-2)
+		}
+		cm.Msgs = append(cm.Msgs, inner[2:2+n])
+		inner = inner[2+n:]
+	}
+	return cm, nil
+}
+
+func (self CompressedMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.WriteByte(self.Algo)
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	for _, m := range self.Msgs {
+		binary.Write(w, binary.BigEndian, uint16(len(m)))
+		w.Write(m)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func (self CompressedMsg) Peer() uint32   { return self.PeerIdx }
+func (self CompressedMsg) MsgType() uint8 { return MSGID_COMPRESSED }
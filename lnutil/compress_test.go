@@ -0,0 +1,81 @@
+package lnutil
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressedMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msgs [][]byte
+	var pkh [20]byte
+	_, _ = rand.Read(pkh[:])
+	for i := 0; i < 50; i++ {
+		var sm WatchStateMsg
+		sm.CoinType = 1
+		sm.DestPKH = pkh
+		_, _ = rand.Read(sm.Elk[:])
+		_, _ = rand.Read(sm.ParTxid[:])
+		_, _ = rand.Read(sm.Sig[:])
+		msgs = append(msgs, sm.Bytes())
+	}
+
+	cm, n := NewCompressedMsg(peerid, msgs)
+	if n != len(msgs) {
+		t.Fatalf("packed %d of %d", n, len(msgs))
+	}
+	b := cm.Bytes()
+	raw := 0
+	for _, m := range msgs {
+		raw += len(m)
+	}
+	if len(b) >= raw {
+		t.Fatalf("compressed to %d bytes from %d", len(b), raw)
+	}
+
+	m2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm2 := m2.(CompressedMsg)
+	if len(cm2.Msgs) != len(msgs) {
+		t.Fatalf("got %d msgs back, expect %d", len(cm2.Msgs), len(msgs))
+	}
+	for i := range msgs {
+		if !bytes.Equal(cm2.Msgs[i], msgs[i]) {
+			t.Fatalf("msg %d:\n%x\n%x\n", i, cm2.Msgs[i], msgs[i])
+		}
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)/2], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored on a cut off msg, but didn't")
+	}
+}
+
+func TestCompressedMsgLimits(t *testing.T) {
+	// only as many as fit in a record
+	big := make([]byte, 30000)
+	_, _ = rand.Read(big)
+	cm, n := NewCompressedMsg(0, [][]byte{big, big, big})
+	if n != 2 {
+		t.Fatalf("packed %d 30K msgs, expect 2", n)
+	}
+	if len(cm.Bytes()) > MaxCompressedMsg {
+		t.Fatalf("compressed msg %d bytes, max %d",
+			len(cm.Bytes()), MaxCompressedMsg)
+	}
+
+	// and won't inflate forever
+	zeros := make([]byte, 60000)
+	var lots [][]byte
+	for i := 0; i < 20; i++ {
+		lots = append(lots, zeros)
+	}
+	bomb := CompressedMsg{Algo: CompressDeflate, Msgs: lots}
+	_, err := NewCompressedMsgFromBytes(bomb.Bytes(), 0)
+	if err == nil {
+		t.Fatalf("Should have errored inflating %d bytes, but didn't", 20*60000)
+	}
+}
@@ -0,0 +1,73 @@
+package lnutil
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfTier is the confirmations needed for amounts of From satoshis or more
+type ConfTier struct {
+	From  int64
+	Confs int32
+}
+
+// ConfPolicy is how many confirmations funds need before they're used,
+// by amount: bigger amounts can wait for more.  Tiers are sorted by From.
+// The empty policy needs none.
+type ConfPolicy []ConfTier
+
+// ParseConfPolicy reads a policy written as sats:confs pairs, eg
+// "0:1,1000000:6" for 1 conf under 0.01 BTC and 6 from there up.
+// Amounts under the lowest tier need no confirmations.
+func ParseConfPolicy(s string) (ConfPolicy, error) {
+	var p ConfPolicy
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return p, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.Split(strings.TrimSpace(part), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("conf tier %q should be sats:confs", part)
+		}
+		from, err := strconv.ParseInt(kv[0], 10, 64)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("conf tier %q: bad amount", part)
+		}
+		confs, err := strconv.ParseInt(kv[1], 10, 32)
+		if err != nil || confs < 0 {
+			return nil, fmt.Errorf("conf tier %q: bad confirmations", part)
+		}
+		p = append(p, ConfTier{From: from, Confs: int32(confs)})
+	}
+	sort.Slice(p, func(i, j int) bool { return p[i].From < p[j].From })
+	for i := 1; i < len(p); i++ {
+		if p[i].From == p[i-1].From {
+			return nil, fmt.Errorf("conf policy has %d twice", p[i].From)
+		}
+	}
+	return p, nil
+}
+
+// Confs is how many confirmations amt satoshis need
+func (p ConfPolicy) Confs(amt int64) int32 {
+	var confs int32
+	for _, t := range p {
+		if amt < t.From {
+			break
+		}
+		confs = t.Confs
+	}
+	return confs
+}
+
+// String writes the policy the way ParseConfPolicy reads it
+func (p ConfPolicy) String() string {
+	s := make([]string, len(p))
+	for i, t := range p {
+		s[i] = fmt.Sprintf("%d:%d", t.From, t.Confs)
+	}
+	return strings.Join(s, ",")
+}
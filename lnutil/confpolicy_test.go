@@ -0,0 +1,47 @@
+package lnutil
+
+import (
+	"testing"
+)
+
+func TestConfPolicy(t *testing.T) {
+	p, err := ParseConfPolicy("1000000:6, 0:1,50000000:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "0:1,1000000:6,50000000:12" {
+		t.Fatalf("got %s", p.String())
+	}
+	cases := []struct {
+		amt   int64
+		confs int32
+	}{
+		{0, 1}, {999999, 1}, {1000000, 6}, {49999999, 6}, {50000000, 12},
+	}
+	for _, c := range cases {
+		if got := p.Confs(c.amt); got != c.confs {
+			t.Fatalf("%d sat: got %d confs, expect %d", c.amt, got, c.confs)
+		}
+	}
+
+	// below the lowest tier needs nothing
+	p, err = ParseConfPolicy("100000:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Confs(99999) != 0 || p.Confs(100000) != 3 {
+		t.Fatalf("got %d and %d", p.Confs(99999), p.Confs(100000))
+	}
+
+	p, err = ParseConfPolicy("")
+	if err != nil || len(p) != 0 || p.Confs(1e8) != 0 {
+		t.Fatalf("empty policy: %v %v", p, err)
+	}
+
+	for _, bad := range []string{"5", "a:1", "1:b", "-1:2", "0:1,0:2", "0:-1"} {
+		_, err = ParseConfPolicy(bad)
+		if err == nil {
+			t.Fatalf("%q should have errored", bad)
+		}
+	}
+}
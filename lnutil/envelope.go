@@ -0,0 +1,78 @@
+package lnutil
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Message versions.  So message formats can change without splitting the
+network into old and new lits, messages between lits that both know
+about it go in an envelope saying which version of the formats the
+sender speaks:
+
+	MSGID_ENVELOPE (1) | version (1) | message length (2) | message | ext
+
+The message inside is a normal one, type byte first.  Ext is anything
+after it; a later version can put new fields for a message there, where
+older lits skip them, rather than change the message itself.
+
+The rules for changing a format, once this is in:
+
+  - New fields go on the end of a message, or in ext.  Parsers check for
+    a minimum length, never an exact one, so old lits read what they
+    know and ignore the rest.
+  - Anything a peer must understand needs MsgVersion bumped, and is only
+    sent to peers whose version is high enough; see PeerMsgVersion in qln.
+  - A message that can't be made to fit gets a new type.
+
+Each side says its version in the FeaturesMsg it sends on connecting,
+which is never wrapped.  Peers that don't say aren't sent envelopes,
+since lits from before this drop the connection on a message type they
+don't know.
+*/
+
+// MsgVersion is the message format version this lit speaks
+const MsgVersion = 1
+
+// Envelope is a message with the sender's message version
+type Envelope struct {
+	Version uint8
+	Msg     []byte // the message, type byte first
+	Ext     []byte // fields from later versions, outside the message
+}
+
+// WrapMsg puts a message in an envelope
+func WrapMsg(msg LitMsg, version uint8, ext []byte) []byte {
+	inner := msg.Bytes()
+	b := make([]byte, 4, 4+len(inner)+len(ext))
+	b[0] = MSGID_ENVELOPE
+	b[1] = version
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(inner)))
+	b = append(b, inner...)
+	return append(b, ext...)
+}
+
+// ParseEnvelope reads an envelope.  Messages not in one come back as
+// they are, with version 0.
+func ParseEnvelope(b []byte) (Envelope, error) {
+	var e Envelope
+	if len(b) < 1 || b[0] != MSGID_ENVELOPE {
+		e.Msg = b
+		return e, nil
+	}
+	if len(b) < 5 {
+		return e, fmt.Errorf("got %d byte envelope, expect 5+", len(b))
+	}
+	e.Version = b[1]
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if n < 1 || len(b)-4 < n {
+		return e, fmt.Errorf("envelope says %d byte message, has %d", n, len(b)-4)
+	}
+	e.Msg = b[4 : 4+n]
+	if e.Msg[0] == MSGID_ENVELOPE {
+		return e, fmt.Errorf("envelope in an envelope")
+	}
+	e.Ext = b[4+n:]
+	return e, nil
+}
@@ -0,0 +1,87 @@
+package lnutil
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	peerid := rand.Uint32()
+	msg := NewChatMsg(peerid, "hello")
+	ext := []byte{7, 7, 7}
+
+	b := WrapMsg(msg, MsgVersion+3, ext)
+
+	e, err := ParseEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != MsgVersion+3 {
+		t.Fatalf("got version %d, expect %d", e.Version, MsgVersion+3)
+	}
+	if !bytes.Equal(e.Msg, msg.Bytes()) || !bytes.Equal(e.Ext, ext) {
+		t.Fatalf("got %x %x, expect %x %x", e.Msg, e.Ext, msg.Bytes(), ext)
+	}
+
+	// a newer version's envelope still gives the message inside
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	// bare messages come through as they are
+	e, err = ParseEnvelope(msg.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != 0 || !bytes.Equal(e.Msg, msg.Bytes()) {
+		t.Fatalf("bare message changed: %d %x", e.Version, e.Msg)
+	}
+
+	_, err = ParseEnvelope(b[:6]) //purposely error to check working by not sending enough bytes
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+	_, err = ParseEnvelope(WrapMsg(FeaturesMsg{Features: nil}, 1, nil)[:4])
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestEnvelopeNested(t *testing.T) {
+	inner := WrapMsg(NewChatMsg(1, "hi"), MsgVersion, nil)
+	b := []byte{MSGID_ENVELOPE, MsgVersion, 0, byte(len(inner))}
+	b = append(b, inner...)
+	_, err := ParseEnvelope(b)
+	if err == nil {
+		t.Fatalf("nested envelope should error")
+	}
+}
+
+func TestFeaturesMsgVersion(t *testing.T) {
+	msg := NewFeaturesMsg(1, NewFeatures(FeatureSwaps+1))
+	if msg.MsgVersion != MsgVersion {
+		t.Fatalf("got version %d, expect %d", msg.MsgVersion, MsgVersion)
+	}
+	b := msg.Bytes()
+
+	// lits from before envelopes send no version
+	old, err := NewFeaturesMsgFromBytes(b[:len(b)-1], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.MsgVersion != 0 || !old.Features.Has(FeatureSwaps) {
+		t.Fatalf("got version %d features %s", old.MsgVersion, old.Features)
+	}
+	empty, err := NewFeaturesMsgFromBytes(NewFeaturesMsg(1, nil).Bytes(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.MsgVersion != MsgVersion {
+		t.Fatalf("got version %d, expect %d", empty.MsgVersion, MsgVersion)
+	}
+}
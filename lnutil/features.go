@@ -0,0 +1,236 @@
+package lnutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+Features say what a node or channel can do, so new things can be rolled
+out without breaking peers that don't know them.  It's the scheme from
+BOLT 9: a bit vector where each feature is a pair of bits, the even one
+meaning "required" and the odd one "optional".  A peer that gets a
+required bit it doesn't know has to disconnect (or refuse the channel);
+optional bits it doesn't know it ignores.
+
+Node features go in a FeaturesMsg, sent to each peer on connecting.
+Channel features go on the end of the ChanDesc and ChanAck, which older
+lits ignore since they only check for a minimum length.  The funder
+offers its channel features and the acceptor answers with the ones both
+have, which is what the channel then uses.
+
+New features get the next even number below.  Set the odd bit while a
+feature's new, and the even one once peers without it should be dropped.
+*/
+
+// node features
+const (
+	FeatureTowerAdverts = 2  // passes on watchtower adverts
+	FeatureSwaps        = 4  // atomic swaps, MSGID_SWAP_*
+	FeatureTower        = 6  // runs a watchtower
+	FeatureLiquidityAds = 10 // liquidity adverts and open requests, MSGID_LIQ_*
+	FeatureCustomMsgs   = 12 // application messages, MSGID_CUSTOM
+	FeatureCompression  = 14 // compressed messages, MSGID_COMPRESSED
+)
+
+// channel features
+const (
+	FeatureUpfrontShutdown = 8 // coop close pays scripts sent at open
+)
+
+// featureNames are for String
+var featureNames = map[int]string{
+	FeatureTowerAdverts: "tower-adverts",
+	FeatureSwaps:        "swaps",
+	FeatureTower:        "tower",
+	FeatureLiquidityAds: "liquidity-ads",
+	FeatureCustomMsgs:   "custom-msgs",
+	FeatureCompression:  "compression",
+
+	FeatureUpfrontShutdown: "upfront-shutdown",
+}
+
+// Features is a feature bit vector.  Bit 0 is the low bit of the last byte.
+type Features []byte
+
+// NewFeatures makes a feature vector with the given bits set
+func NewFeatures(bits ...int) Features {
+	var f Features
+	for _, b := range bits {
+		f.Set(b)
+	}
+	return f
+}
+
+// IsSet says if a bit is set
+func (f Features) IsSet(bit int) bool {
+	i := len(f) - 1 - bit/8
+	if bit < 0 || i < 0 {
+		return false
+	}
+	return f[i]&(1<<uint(bit%8)) != 0
+}
+
+// Set sets a bit, growing the vector if need be
+func (f *Features) Set(bit int) {
+	if bit < 0 {
+		return
+	}
+	need := bit/8 + 1
+	if len(*f) < need {
+		grown := make(Features, need)
+		copy(grown[need-len(*f):], *f)
+		*f = grown
+	}
+	(*f)[len(*f)-1-bit/8] |= 1 << uint(bit%8)
+}
+
+// Has says if a feature is on, required or optional.  feature is the
+// even bit of the pair.
+func (f Features) Has(feature int) bool {
+	feature &^= 1
+	return f.IsSet(feature) || f.IsSet(feature+1)
+}
+
+// Requires says if a feature is required
+func (f Features) Requires(feature int) bool {
+	return f.IsSet(feature &^ 1)
+}
+
+// bits lists the set bits, lowest first
+func (f Features) bits() []int {
+	var set []int
+	for bit := 0; bit < len(f)*8; bit++ {
+		if f.IsSet(bit) {
+			set = append(set, bit)
+		}
+	}
+	return set
+}
+
+// UnknownRequired lists the features f requires which known doesn't have
+func (f Features) UnknownRequired(known Features) []int {
+	var unknown []int
+	for _, bit := range f.bits() {
+		if bit%2 == 0 && !known.Has(bit) {
+			unknown = append(unknown, bit)
+		}
+	}
+	return unknown
+}
+
+// CommonFeatures is the features both a and b have; required if either
+// requires it, optional otherwise
+func CommonFeatures(a, b Features) Features {
+	var c Features
+	for _, bit := range a.bits() {
+		feature := bit &^ 1
+		if !b.Has(feature) || c.Has(feature) {
+			continue
+		}
+		if a.Requires(feature) || b.Requires(feature) {
+			c.Set(feature)
+		} else {
+			c.Set(feature + 1)
+		}
+	}
+	return c
+}
+
+// String lists the features, eg "swaps tower(required) 41"
+func (f Features) String() string {
+	var s []string
+	for _, bit := range f.bits() {
+		name, ok := featureNames[bit&^1]
+		if !ok {
+			name = fmt.Sprintf("%d", bit)
+		} else if bit%2 == 0 {
+			name += "(required)"
+		}
+		s = append(s, name)
+	}
+	return strings.Join(s, " ")
+}
+
+// trimmed drops leading zero bytes, so equal vectors serialize the same
+func (f Features) trimmed() Features {
+	for len(f) > 0 && f[0] == 0 {
+		f = f[1:]
+	}
+	return f
+}
+
+// featuresTail serializes features to go on the end of a message: a
+// 2 byte length and the vector.  Empty features are left off entirely.
+func featuresTail(f Features) []byte {
+	f = f.trimmed()
+	if len(f) == 0 {
+		return nil
+	}
+	if len(f) > 0xffff {
+		f = f[len(f)-0xffff:]
+	}
+	b := make([]byte, 2, 2+len(f))
+	binary.BigEndian.PutUint16(b, uint16(len(f)))
+	return append(b, f...)
+}
+
+// featuresFromTail reads features serialized by featuresTail; nothing
+// there is no features
+func featuresFromTail(b []byte) (Features, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) < 2 {
+		return nil, fmt.Errorf("features: %d bytes", len(b))
+	}
+	n := int(binary.BigEndian.Uint16(b[:2]))
+	if len(b)-2 < n {
+		return nil, fmt.Errorf("features: %d bytes, %d given", len(b)-2, n)
+	}
+	f := make(Features, n)
+	copy(f, b[2:2+n])
+	return f, nil
+}
+
+// FeaturesMsg tells a peer the node's features, and the message version
+// it speaks.  Sent on connecting.
+type FeaturesMsg struct {
+	PeerIdx    uint32
+	Features   Features
+	MsgVersion uint8 // 0 from lits before envelope.go
+}
+
+func NewFeaturesMsg(peerid uint32, f Features) FeaturesMsg {
+	return FeaturesMsg{PeerIdx: peerid, Features: f, MsgVersion: MsgVersion}
+}
+
+func NewFeaturesMsgFromBytes(b []byte, peerid uint32) (FeaturesMsg, error) {
+	fm := FeaturesMsg{PeerIdx: peerid}
+	if len(b) < 3 {
+		return fm, fmt.Errorf("got %d byte features msg, expect 3+", len(b))
+	}
+	f, err := featuresFromTail(b[1:])
+	if err != nil {
+		return fm, err
+	}
+	fm.Features = f
+	// version after the features
+	if rest := b[3+len(f):]; len(rest) > 0 {
+		fm.MsgVersion = rest[0]
+	}
+	return fm, nil
+}
+
+func (self FeaturesMsg) Bytes() []byte {
+	tail := featuresTail(self.Features)
+	if tail == nil {
+		tail = []byte{0, 0}
+	}
+	b := append([]byte{self.MsgType()}, tail...)
+	return append(b, self.MsgVersion)
+}
+
+func (self FeaturesMsg) Peer() uint32   { return self.PeerIdx }
+func (self FeaturesMsg) MsgType() uint8 { return MSGID_FEATURES }
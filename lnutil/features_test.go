@@ -0,0 +1,73 @@
+package lnutil
+
+import (
+	"testing"
+)
+
+func TestFeaturesBits(t *testing.T) {
+	f := NewFeatures(0, 9, 17)
+	if len(f) != 3 {
+		t.Fatalf("got %d bytes, expect 3", len(f))
+	}
+	for _, bit := range []int{0, 9, 17} {
+		if !f.IsSet(bit) {
+			t.Fatalf("bit %d not set in %x", bit, f)
+		}
+	}
+	if f.IsSet(1) || f.IsSet(100) || f.IsSet(-1) {
+		t.Fatalf("unset bit set in %x", f)
+	}
+	if !f.Has(8) || f.Requires(8) || !f.Requires(0) {
+		t.Fatalf("wrong required / optional for %x", f)
+	}
+}
+
+func TestFeaturesUnknownRequired(t *testing.T) {
+	known := NewFeatures(FeatureSwaps+1, FeatureTower+1)
+
+	// unknown optional bits are fine
+	if u := NewFeatures(41, FeatureSwaps).UnknownRequired(known); len(u) != 0 {
+		t.Fatalf("unknown required %v, expect none", u)
+	}
+	u := NewFeatures(40, FeatureTower).UnknownRequired(known)
+	if len(u) != 1 || u[0] != 40 {
+		t.Fatalf("unknown required %v, expect [40]", u)
+	}
+}
+
+func TestCommonFeatures(t *testing.T) {
+	a := NewFeatures(FeatureSwaps+1, FeatureTower+1, 41)
+	b := NewFeatures(FeatureSwaps, FeatureTower+1, FeatureTowerAdverts+1)
+
+	c := CommonFeatures(a, b)
+	if !c.Requires(FeatureSwaps) {
+		t.Fatalf("swaps not required in %s", c)
+	}
+	if !c.Has(FeatureTower) || c.Requires(FeatureTower) {
+		t.Fatalf("tower not optional in %s", c)
+	}
+	if c.Has(FeatureTowerAdverts) || c.Has(40) {
+		t.Fatalf("one sided feature in %s", c)
+	}
+	if c.String() != "swaps(required) tower" {
+		t.Fatalf("got %q", c.String())
+	}
+}
+
+func TestFeaturesTail(t *testing.T) {
+	if featuresTail(Features{0, 0}) != nil {
+		t.Fatalf("empty features serialized")
+	}
+	f := NewFeatures(3, 20)
+	got, err := featuresFromTail(featuresTail(append(Features{0}, f...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsSet(3) || !got.IsSet(20) || len(got) != len(f) {
+		t.Fatalf("got %x, expect %x", got, f)
+	}
+	_, err = featuresFromTail([]byte{0, 5, 1})
+	if err == nil {
+		t.Fatalf("short features should error")
+	}
+}
@@ -0,0 +1,61 @@
+package lnutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseFiat parses an amount like "5.00USD", "5 usd" or "12.5EUR" into
+// hundredths of the currency and the currency code, upper case.
+func ParseFiat(s string) (int64, string, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 1 {
+		return 0, "", fmt.Errorf("fiat amount %q should be like 5.00USD", s)
+	}
+	num, cur := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	if len(cur) < 3 || len(cur) > 5 {
+		return 0, "", fmt.Errorf("bad currency %q in %q", cur, s)
+	}
+	for _, r := range cur {
+		if r < 'A' || r > 'Z' {
+			return 0, "", fmt.Errorf("bad currency %q in %q", cur, s)
+		}
+	}
+	whole, frac := num, ""
+	if dot := strings.Index(num, "."); dot >= 0 {
+		whole, frac = num[:dot], num[dot+1:]
+	}
+	if len(frac) > 2 {
+		return 0, "", fmt.Errorf("%q has more than 2 decimal places", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad fiat amount %q", s)
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad fiat amount %q", s)
+	}
+	if w > (1<<62)/100 {
+		return 0, "", fmt.Errorf("fiat amount %q too big", s)
+	}
+	return w*100 + f, cur, nil
+}
+
+// FormatFiat shows hundredths of a currency, eg "5.00 USD"
+func FormatFiat(hundredths int64, cur string) string {
+	sign := ""
+	if hundredths < 0 {
+		sign = "-"
+		hundredths = -hundredths
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, hundredths/100, hundredths%100, cur)
+}
@@ -0,0 +1,40 @@
+package lnutil
+
+import (
+	"testing"
+)
+
+func TestParseFiat(t *testing.T) {
+	good := []struct {
+		in  string
+		amt int64
+		cur string
+	}{
+		{"5.00USD", 500, "USD"},
+		{"5USD", 500, "USD"},
+		{"12.5 eur", 1250, "EUR"},
+		{".99GBP", 99, "GBP"},
+		{"0.01 JPY", 1, "JPY"},
+	}
+	for _, g := range good {
+		amt, cur, err := ParseFiat(g.in)
+		if err != nil {
+			t.Fatalf("%s: %s", g.in, err.Error())
+		}
+		if amt != g.amt || cur != g.cur {
+			t.Fatalf("%s: got %d %s, expect %d %s", g.in, amt, cur, g.amt, g.cur)
+		}
+		if FormatFiat(amt, cur) == "" {
+			t.Fatalf("%s: empty format", g.in)
+		}
+	}
+	for _, bad := range []string{"", "USD", "5", "5.001USD", "5.00US", "5.0.0USD", "-5USD", "5U$D"} {
+		_, _, err := ParseFiat(bad)
+		if err == nil {
+			t.Fatalf("%q should have failed", bad)
+		}
+	}
+	if s := FormatFiat(-1205, "EUR"); s != "-12.05 EUR" {
+		t.Fatalf("format %s", s)
+	}
+}
@@ -0,0 +1,33 @@
+package lnutil
+
+// HeirRefreshBlocks is how close to its lock height a wallet's heir tx
+// gets pushed back, if the owner's checked in since it was signed
+const HeirRefreshBlocks = 1008
+
+// Heir is a wallet's dead man's switch: a tx sending everything in it to an
+// heir, signed ahead of time but only valid from a far off height.  While
+// the owner keeps checking in the height keeps being pushed back.
+type Heir struct {
+	Script []byte // the heir's output script
+	// blocks ahead of the tip the lock height's set to
+	Delay int32
+	// the tx is valid in the block after this
+	LockHeight int32
+	// heights the lock height was last set, and the owner last checked in
+	SignedAt int32
+	CheckIn  int32
+	// a tx's been exported since the last one was invalidated, so pushing
+	// the lock height back needs the wallet's coins moved
+	Exported bool
+	// the lock height came without a check in, and the tx went out
+	Sent bool
+	// the signed tx, serialized.  Empty with no confirmed coins to send.
+	Tx []byte
+}
+
+// Due says if the owner needs to check in to push the lock height back,
+// at height
+func (h *Heir) Due(height int32) bool {
+	return !h.Sent && h.CheckIn <= h.SignedAt &&
+		height >= h.LockHeight-HeirRefreshBlocks
+}
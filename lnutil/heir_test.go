@@ -0,0 +1,36 @@
+package lnutil
+
+import (
+	"testing"
+)
+
+func TestHeirDue(t *testing.T) {
+	h := &Heir{LockHeight: 100000, SignedAt: 50000, CheckIn: 50000}
+	cases := []struct {
+		height int32
+		due    bool
+	}{
+		{50000, false},
+		{100000 - HeirRefreshBlocks - 1, false},
+		{100000 - HeirRefreshBlocks, true},
+		{100000, true},
+	}
+	for _, c := range cases {
+		if got := h.Due(c.height); got != c.due {
+			t.Fatalf("at %d: got due %v, expect %v", c.height, got, c.due)
+		}
+	}
+
+	// checked in since it was signed, so it'll be pushed back
+	h.CheckIn = 60000
+	if h.Due(100000 - 10) {
+		t.Fatalf("due after a check in")
+	}
+
+	// already gone
+	h.CheckIn = 50000
+	h.Sent = true
+	if h.Due(100000) {
+		t.Fatalf("due after it was sent")
+	}
+}
@@ -0,0 +1,168 @@
+package lnutil
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+HTLC outputs on a commitment tx work like the commit script: pick a key
+with IFs, check the sig against it at the end.  There are three ways out:
+
+1: revoked.  The other side has the revocation key, same as CommitScript.
+0 1: preimage.  Whoever the htlc pays to shows the preimage of hash.
+0 0: timeout.  Whoever offered it gets it back once the chain gets to
+locktime.
+
+Whichever of the last two belongs to the owner of the commitment tx also
+waits out the CSV delay, so the other side has time to use the revoke
+branch if the tx is old.  (Instead of second level htlc txs.)
+
+Witnesses, from HTLCRevokeWitness, HTLCPreimageWitness, HTLCTimeoutWitness:
+revoke: <sig> <1> <script>
+preimage: <sig> <preimage> <1> <> <script>
+timeout: <sig> <> <> <script>, with nLockTime >= locktime
+
+The anchor output is a little output either side can spend right away to
+CPFP the commitment tx, and anyone can sweep once it's 16 blocks old so it
+doesn't clutter up the utxo set.
+*/
+
+// AnchorAmt is the value of an anchor output
+const AnchorAmt = 330
+
+// anchorDelay is how many blocks until anyone can sweep an anchor
+const anchorDelay = 16
+
+// OfferedHTLCScript is the script for an htlc on the commitment tx of the
+// side offering it.  localPub gets it back on timeout, after the delay;
+// remotePub can take it with the preimage.
+func OfferedHTLCScript(revokePub, localPub, remotePub [33]byte,
+	hash [32]byte, locktime uint32, delay uint16) []byte {
+	builder := txscript.NewScriptBuilder()
+
+	// 1 for revoked
+	builder.AddOp(txscript.OP_IF)
+	builder.AddData(revokePub[:])
+	builder.AddOp(txscript.OP_ELSE)
+
+	// 1 for preimage, 0 for timeout
+	builder.AddOp(txscript.OP_IF)
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(hash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddData(remotePub[:])
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_NOP2) // really OP_CHECKLOCKTIMEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddInt64(int64(delay))
+	builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(localPub[:])
+	builder.AddOp(txscript.OP_ENDIF)
+
+	builder.AddOp(txscript.OP_ENDIF)
+
+	// check whatever pubkey is left on the stack
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
+// ReceivedHTLCScript is the script for an htlc on the commitment tx of the
+// side receiving it.  localPub can take it with the preimage, after the
+// delay; remotePub gets it back on timeout.
+func ReceivedHTLCScript(revokePub, localPub, remotePub [33]byte,
+	hash [32]byte, locktime uint32, delay uint16) []byte {
+	builder := txscript.NewScriptBuilder()
+
+	// 1 for revoked
+	builder.AddOp(txscript.OP_IF)
+	builder.AddData(revokePub[:])
+	builder.AddOp(txscript.OP_ELSE)
+
+	// 1 for preimage, 0 for timeout
+	builder.AddOp(txscript.OP_IF)
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(hash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddInt64(int64(delay))
+	builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(localPub[:])
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_NOP2) // really OP_CHECKLOCKTIMEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(remotePub[:])
+	builder.AddOp(txscript.OP_ENDIF)
+
+	builder.AddOp(txscript.OP_ENDIF)
+
+	// check whatever pubkey is left on the stack
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
+// AnchorScript is the script for an anchor output.  pub can spend it any
+// time; after 16 blocks anyone can, with an empty sig.
+func AnchorScript(pub [33]byte) []byte {
+	builder := txscript.NewScriptBuilder()
+
+	builder.AddData(pub[:])
+	builder.AddOp(txscript.OP_CHECKSIG)
+	// a good sig leaves 1 and skips the NOTIF.  An empty one leaves 0
+	builder.AddOp(txscript.OP_IFDUP)
+	builder.AddOp(txscript.OP_NOTIF)
+	builder.AddInt64(anchorDelay)
+	builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+	builder.AddOp(txscript.OP_ENDIF)
+
+	s, _ := builder.Script()
+	return s
+}
+
+// HTLCTxOut makes the p2wsh txout for an htlc script from OfferedHTLCScript
+// or ReceivedHTLCScript
+func HTLCTxOut(script []byte, amt int64) (*wire.TxOut, error) {
+	if amt <= 0 {
+		return nil, fmt.Errorf("Can't make htlc output of %d", amt)
+	}
+	return wire.NewTxOut(amt, P2WSHify(script)), nil
+}
+
+// AnchorTxOut makes the p2wsh anchor txout for pub
+func AnchorTxOut(pub [33]byte) *wire.TxOut {
+	return wire.NewTxOut(AnchorAmt, P2WSHify(AnchorScript(pub)))
+}
+
+// HTLCRevokeWitness spends an htlc output of a revoked commitment
+func HTLCRevokeWitness(sig, script []byte) wire.TxWitness {
+	return wire.TxWitness{sig, {0x01}, script}
+}
+
+// HTLCPreimageWitness spends an htlc output with the preimage
+func HTLCPreimageWitness(sig []byte, preimage [32]byte, script []byte) wire.TxWitness {
+	return wire.TxWitness{sig, preimage[:], {0x01}, {}, script}
+}
+
+// HTLCTimeoutWitness spends an htlc output after it's timed out.  The tx's
+// nLockTime has to be at least the htlc's locktime.
+func HTLCTimeoutWitness(sig, script []byte) wire.TxWitness {
+	return wire.TxWitness{sig, {}, {}, script}
+}
+
+// AnchorWitness spends an anchor output with the key's sig, or with no sig
+// (nil) if the anchor is 16 blocks old.
+func AnchorWitness(sig, script []byte) wire.TxWitness {
+	if sig == nil {
+		sig = []byte{}
+	}
+	return wire.TxWitness{sig, script}
+}
@@ -0,0 +1,89 @@
+package lnutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+// OfferedHTLCScript, ReceivedHTLCScript
+func TestHTLCScripts(t *testing.T) {
+	var hash [32]byte
+	for i := range hash {
+		hash[i] = byte(i)
+	}
+	var revoke [33]byte
+	revoke[0] = 0x02
+
+	// both start with the revoke branch and the preimage check
+	head := []byte{0x63, 0x21}
+	head = append(head, revoke[:]...)
+	head = append(head, 0x67, 0x63, 0xa8, 0x20)
+	head = append(head, hash[:]...)
+	head = append(head, 0x88)
+
+	wantO := append([]byte{}, head...)
+	wantO = append(wantO, 0x21)
+	wantO = append(wantO, pubKeyCmpd1[:]...)
+	wantO = append(wantO, 0x67, 0x02, 0xf4, 0x01, 0xb1, 0x75) // 500 CLTV
+	wantO = append(wantO, 0x55, 0xb2, 0x75, 0x21)             // 5 CSV
+	wantO = append(wantO, pubKeyCmpd0[:]...)
+	wantO = append(wantO, 0x68, 0x68, 0xac)
+
+	got := OfferedHTLCScript(revoke, pubKeyCmpd0, pubKeyCmpd1, hash, 500, 5)
+	if !bytes.Equal(got, wantO) {
+		t.Fatalf("offered script mismatch:\n%x\n%x", got, wantO)
+	}
+
+	wantR := append([]byte{}, head...)
+	wantR = append(wantR, 0x55, 0xb2, 0x75, 0x21) // 5 CSV
+	wantR = append(wantR, pubKeyCmpd0[:]...)
+	wantR = append(wantR, 0x67, 0x02, 0xf4, 0x01, 0xb1, 0x75, 0x21) // 500 CLTV
+	wantR = append(wantR, pubKeyCmpd1[:]...)
+	wantR = append(wantR, 0x68, 0x68, 0xac)
+
+	got = ReceivedHTLCScript(revoke, pubKeyCmpd0, pubKeyCmpd1, hash, 500, 5)
+	if !bytes.Equal(got, wantR) {
+		t.Fatalf("received script mismatch:\n%x\n%x", got, wantR)
+	}
+
+	txo, err := HTLCTxOut(got, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(txo.PkScript, P2WSHify(got)) || txo.Value != 1000 {
+		t.Fatalf("htlc txout %d %x", txo.Value, txo.PkScript)
+	}
+	_, err = HTLCTxOut(got, 0)
+	if err == nil {
+		t.Fatalf("made a 0 value htlc output")
+	}
+
+	// witness stacks pick the branches
+	var preimage [32]byte
+	if len(HTLCRevokeWitness([]byte{1}, got)) != 3 ||
+		len(HTLCPreimageWitness([]byte{1}, preimage, got)) != 5 ||
+		len(HTLCTimeoutWitness([]byte{1}, got)) != 4 {
+		t.Fatalf("witness lengths wrong")
+	}
+}
+
+// AnchorScript
+func TestAnchorScript(t *testing.T) {
+	want := []byte{0x21}
+	want = append(want, pubKeyCmpd0[:]...)
+	want = append(want, 0xac, 0x73, 0x64, 0x60, 0xb2, 0x68)
+
+	got := AnchorScript(pubKeyCmpd0)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("anchor script mismatch:\n%x\n%x", got, want)
+	}
+	txo := AnchorTxOut(pubKeyCmpd0)
+	if txo.Value != AnchorAmt || !bytes.Equal(txo.PkScript, P2WSHify(want)) {
+		t.Fatalf("anchor txout %d %x", txo.Value, txo.PkScript)
+	}
+	// no sig still pushes an empty item
+	w := AnchorWitness(nil, got)
+	if len(w) != 2 || w[0] == nil || len(w[0]) != 0 {
+		t.Fatalf("anchor sweep witness %x", w)
+	}
+}
@@ -41,6 +41,57 @@ func CommitScript(RKey, TKey [33]byte, delay uint16) []byte {
 	return s
 }
 
+// SwapHTLCScript is the script for one side of an atomic swap.  claimPub
+// can spend with the preimage of hash; refundPub can spend once the chain
+// reaches locktime.
+// Claim witness: <sig> <preimage> <1> <script>
+// Refund witness: <sig> <> <script>, with nLockTime >= locktime
+func SwapHTLCScript(
+	hash [32]byte, claimPub, refundPub [33]byte, locktime uint32) []byte {
+	builder := txscript.NewScriptBuilder()
+
+	// 1 for claim, 0 for refund
+	builder.AddOp(txscript.OP_IF)
+
+	// check the preimage, then leave the claim key
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(hash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddData(claimPub[:])
+
+	builder.AddOp(txscript.OP_ELSE)
+
+	// CLTV, fails here if too early
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_NOP2) // really OP_CHECKLOCKTIMEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(refundPub[:])
+
+	builder.AddOp(txscript.OP_ENDIF)
+
+	// check whatever pubkey is left on the stack
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
+// CLTVScript locks coins to pub until the chain reaches locktime, a block
+// height.
+// Witness: <sig> <script>, with nLockTime >= locktime
+func CLTVScript(pub [33]byte, locktime uint32) []byte {
+	builder := txscript.NewScriptBuilder()
+
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_NOP2) // really OP_CHECKLOCKTIMEVERIFY
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(pub[:])
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
 // FundMultiPre generates the non-p2sh'd multisig script for 2 of 2 pubkeys.
 // useful for making transactions spending the fundtx.
 // returns a bool which is true if swapping occurs.
@@ -141,3 +141,45 @@ func TestFundTxOut(t *testing.T) {
 	}
 
 }
+
+// SwapHTLCScript
+func TestSwapHTLCScript(t *testing.T) {
+	var hash [32]byte
+	for i := range hash {
+		hash[i] = byte(i)
+	}
+	var locktime uint32 = 500
+
+	wantB := []byte{0x63, 0xa8, 0x20}
+	wantB = append(wantB, hash[:]...)
+	wantB = append(wantB, 0x88, 0x21)
+	wantB = append(wantB, pubKeyCmpd0[:]...)
+	wantB = append(wantB, 0x67)
+	wantB = append(wantB, 0x02, 0xf4, 0x01) // 500, little endian
+	wantB = append(wantB, 0xb1, 0x75, 0x21)
+	wantB = append(wantB, pubKeyCmpd1[:]...)
+	wantB = append(wantB, 0x68, 0xac)
+
+	got := SwapHTLCScript(hash, pubKeyCmpd0, pubKeyCmpd1, locktime)
+	if !bytes.Equal(got, wantB) {
+		t.Fatalf("swap script mismatch:\n%x\n%x", got, wantB)
+	}
+
+	// different locktime, different script
+	if bytes.Equal(got, SwapHTLCScript(hash, pubKeyCmpd0, pubKeyCmpd1, 501)) {
+		t.Fatalf("locktime not in script")
+	}
+}
+
+// CLTVScript
+func TestCLTVScript(t *testing.T) {
+	wantB := []byte{0x03, 0x40, 0x0d, 0x03} // 200000, little endian
+	wantB = append(wantB, 0xb1, 0x75, 0x21)
+	wantB = append(wantB, pubKeyCmpd0[:]...)
+	wantB = append(wantB, 0xac)
+
+	got := CLTVScript(pubKeyCmpd0, 200000)
+	if !bytes.Equal(got, wantB) {
+		t.Fatalf("cltv script mismatch:\n%x\n%x", got, wantB)
+	}
+}
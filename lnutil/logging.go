@@ -0,0 +1,231 @@
+package lnutil
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Leveled logging.  Each package (qln, wallit, uspv, tower, lndc...) makes a
+SubLogger with its own name and level.  Everything still goes through the
+standard library log package, so wherever main points log.SetOutput is where
+it ends up.  Levels can be changed while running with SetLogLevels.
+*/
+
+// LogLevel is how important a log message is.  Higher is more important.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+	LogOff // nothing gets logged at this level
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogOff {
+		return fmt.Sprintf("level%d", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel turns a string like "debug" or "WARN" into a LogLevel.
+func ParseLogLevel(s string) (LogLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range logLevelNames {
+		if s == name {
+			return LogLevel(i), nil
+		}
+	}
+	return LogOff, fmt.Errorf("unknown log level %s", s)
+}
+
+// SubLogger is the logger for one subsystem.
+type SubLogger struct {
+	name  string
+	level int32 // atomic, a LogLevel
+}
+
+var (
+	subLoggers   = make(map[string]*SubLogger)
+	subLoggerMtx sync.Mutex
+)
+
+// NewSubLogger returns the logger for a subsystem, making it if needed.
+// New loggers start at info level.
+func NewSubLogger(name string) *SubLogger {
+	subLoggerMtx.Lock()
+	defer subLoggerMtx.Unlock()
+	l, ok := subLoggers[name]
+	if !ok {
+		l = &SubLogger{name: name, level: int32(LogInfo)}
+		subLoggers[name] = l
+	}
+	return l
+}
+
+// Level returns the current level of the logger
+func (l *SubLogger) Level() LogLevel {
+	return LogLevel(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel changes the level of the logger
+func (l *SubLogger) SetLevel(lvl LogLevel) {
+	atomic.StoreInt32(&l.level, int32(lvl))
+}
+
+func (l *SubLogger) output(lvl LogLevel, format string, v ...interface{}) {
+	if lvl < l.Level() {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	log.Output(3, fmt.Sprintf("[%s] %s: %s", lvl, l.name, msg))
+}
+
+func (l *SubLogger) Debugf(format string, v ...interface{}) {
+	l.output(LogDebug, format, v...)
+}
+
+func (l *SubLogger) Infof(format string, v ...interface{}) {
+	l.output(LogInfo, format, v...)
+}
+
+func (l *SubLogger) Warnf(format string, v ...interface{}) {
+	l.output(LogWarn, format, v...)
+}
+
+func (l *SubLogger) Errorf(format string, v ...interface{}) {
+	l.output(LogError, format, v...)
+}
+
+// SetLogLevels sets levels from a spec string.  The spec is a comma
+// separated list; each item is either a level, which sets all subsystems,
+// or subsystem=level.  Example: "info,qln=debug,uspv=warn".
+// Items are applied in order, so put the "all" level first.
+func SetLogLevels(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	subLoggerMtx.Lock()
+	defer subLoggerMtx.Unlock()
+
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) == 1 {
+			lvl, err := ParseLogLevel(parts[0])
+			if err != nil {
+				return err
+			}
+			for _, l := range subLoggers {
+				l.SetLevel(lvl)
+			}
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		lvl, err := ParseLogLevel(parts[1])
+		if err != nil {
+			return err
+		}
+		l, ok := subLoggers[name]
+		if !ok {
+			return fmt.Errorf("unknown log subsystem %s", name)
+		}
+		l.SetLevel(lvl)
+	}
+	return nil
+}
+
+// LogLevels returns all subsystems and their levels as "name=level"
+// strings, sorted by name.
+func LogLevels() []string {
+	subLoggerMtx.Lock()
+	defer subLoggerMtx.Unlock()
+	var out []string
+	for name, l := range subLoggers {
+		out = append(out, name+"="+l.Level().String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RotatingLog is an io.Writer to a file which gets moved aside once it
+// passes MaxSize bytes.  lit.log becomes lit.log.1, lit.log.1 becomes
+// lit.log.2, and so on, keeping Keep old files.
+type RotatingLog struct {
+	Path    string
+	MaxSize int64
+	Keep    int
+
+	mtx  sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingLog opens (or creates) the log file at path for appending.
+func NewRotatingLog(path string, maxSize int64, keep int) (*RotatingLog, error) {
+	r := &RotatingLog{Path: path, MaxSize: maxSize, Keep: keep}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingLog) open() error {
+	f, err := os.OpenFile(r.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = fi.Size()
+	return nil
+}
+
+// rotate shifts the old files up by one and starts a new file
+func (r *RotatingLog) rotate() error {
+	r.file.Close()
+	for i := r.Keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.Path, i),
+			fmt.Sprintf("%s.%d", r.Path, i+1))
+	}
+	if r.Keep > 0 {
+		os.Rename(r.Path, r.Path+".1")
+	} else {
+		os.Remove(r.Path)
+	}
+	return r.open()
+}
+
+func (r *RotatingLog) Write(p []byte) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize && r.size > 0 {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file.
+func (r *RotatingLog) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,106 @@
+package lnutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ParseLogLevel
+//
+// every level name round trips, case doesn't matter, junk is an error
+func TestParseLogLevel(t *testing.T) {
+	for lvl := LogDebug; lvl <= LogOff; lvl++ {
+		got, err := ParseLogLevel(lvl.String())
+		if err != nil {
+			t.Fatalf("ParseLogLevel(%s) error %s", lvl, err.Error())
+		}
+		if got != lvl {
+			t.Fatalf("ParseLogLevel(%s) got %s", lvl, got)
+		}
+	}
+	got, err := ParseLogLevel(" WARN ")
+	if err != nil || got != LogWarn {
+		t.Fatalf("ParseLogLevel( WARN ) got %s, %v", got, err)
+	}
+	_, err = ParseLogLevel("loud")
+	if err == nil {
+		t.Fatalf("ParseLogLevel(loud) should fail")
+	}
+}
+
+// SetLogLevels
+//
+// a bare level sets everything, subsystem=level overrides after that
+func TestSetLogLevels(t *testing.T) {
+	a := NewSubLogger("testa")
+	b := NewSubLogger("testb")
+
+	err := SetLogLevels("error,testb=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Level() != LogError {
+		t.Fatalf("testa level %s, expect error", a.Level())
+	}
+	if b.Level() != LogDebug {
+		t.Fatalf("testb level %s, expect debug", b.Level())
+	}
+
+	// same name gets the same logger back
+	if NewSubLogger("testa") != a {
+		t.Fatalf("NewSubLogger made a second testa logger")
+	}
+
+	err = SetLogLevels("nosuchthing=info")
+	if err == nil {
+		t.Fatalf("unknown subsystem should fail")
+	}
+	err = SetLogLevels("testa=verbose")
+	if err == nil {
+		t.Fatalf("unknown level should fail")
+	}
+	// put things back
+	SetLogLevels("info")
+}
+
+// RotatingLog
+//
+// writing past MaxSize moves the file aside, keeping only Keep old files
+func TestRotatingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littestlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.log")
+	r, err := NewRotatingLog(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// 4 writes of 8 bytes; each one after the first rotates
+	for i := 0; i < 4; i++ {
+		_, err = r.Write([]byte("12345678"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"test.log", "test.log.1", "test.log.2"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("missing %s: %s", name, err.Error())
+		}
+		if fi.Size() != 8 {
+			t.Fatalf("%s is %d bytes, expect 8", name, fi.Size())
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, "test.log.3"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("test.log.3 shouldn't exist")
+	}
+}
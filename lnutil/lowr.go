@@ -0,0 +1,171 @@
+package lnutil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+DER sigs are 72 bytes when r has its high bit set, since it needs a 0 byte
+pad to stay positive.  That's half of all sigs.  SignLowR keeps making
+sigs until r doesn't need a pad, by feeding a counter into the RFC6979
+nonce as extra data (same as bitcoin core), so sigs are 71 bytes or less,
+always low-S, and still deterministic.  The first try has no extra data,
+so it's the same sig btcec would have made.
+
+Use RawTxInWitnessSignatureLowR and WPKHWitnessLowR instead of the txscript
+ones for anything going on chain.
+*/
+
+// maxLowRTries is how many nonces to try before giving up.  Each try has a
+// 1/2 chance, so this never happens.
+const maxLowRTries = 256
+
+// SignLowR makes a low-R, low-S sig of hash with priv
+func SignLowR(priv *btcec.PrivateKey, hash []byte) (*btcec.Signature, error) {
+	if priv == nil {
+		return nil, fmt.Errorf("SignLowR: nil key")
+	}
+	curve := btcec.S256()
+	halfOrder := new(big.Int).Rsh(curve.N, 1)
+	e := hashToInt(hash)
+
+	for i := uint32(0); i < maxLowRTries; i++ {
+		var extra []byte
+		if i > 0 {
+			extra = make([]byte, 32)
+			binary.LittleEndian.PutUint32(extra, i)
+		}
+		k := nonceRFC6979(priv.D, hash, extra)
+
+		r, _ := curve.ScalarBaseMult(k.Bytes())
+		r.Mod(r, curve.N)
+		// high bit set means a pad byte; try again
+		if r.Sign() == 0 || r.BitLen() > 255 {
+			continue
+		}
+		// s = (e + r*d) / k
+		s := new(big.Int).Mul(priv.D, r)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, curve.N))
+		s.Mod(s, curve.N)
+		if s.Sign() == 0 {
+			continue
+		}
+		if s.Cmp(halfOrder) == 1 {
+			s.Sub(curve.N, s)
+		}
+		return &btcec.Signature{R: r, S: s}, nil
+	}
+	return nil, fmt.Errorf("SignLowR: no low r in %d tries", maxLowRTries)
+}
+
+// RawTxInWitnessSignatureLowR is txscript.RawTxInWitnessSignature, but
+// low-R.  Returns the DER sig with the sighash type byte on the end.
+func RawTxInWitnessSignatureLowR(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes,
+	idx int, amt int64, subScript []byte, hashType txscript.SigHashType,
+	priv *btcec.PrivateKey) ([]byte, error) {
+
+	hash, err := txscript.CalcWitnessSigHash(
+		subScript, sigHashes, hashType, tx, idx, amt)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := SignLowR(priv, hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialize(), byte(hashType)), nil
+}
+
+// WPKHWitnessLowR is txscript.WitnessScript (for p2wpkh inputs), but low-R.
+// pkScript is the p2wpkh output being spent.
+func WPKHWitnessLowR(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes,
+	idx int, amt int64, pkScript []byte, hashType txscript.SigHashType,
+	priv *btcec.PrivateKey) (wire.TxWitness, error) {
+
+	sig, err := RawTxInWitnessSignatureLowR(
+		tx, sigHashes, idx, amt, pkScript, hashType, priv)
+	if err != nil {
+		return nil, err
+	}
+	return wire.TxWitness{sig, priv.PubKey().SerializeCompressed()}, nil
+}
+
+// nonceRFC6979 makes the RFC6979 nonce for privkey and hash, with extra
+// appended to the key and hash as in section 3.6.  A nil extra gives the
+// plain RFC6979 nonce.
+func nonceRFC6979(privkey *big.Int, hash []byte, extra []byte) *big.Int {
+	q := btcec.S256().N
+	qlen := q.BitLen()
+	rolen := (qlen + 7) >> 3
+
+	// x, h1 and the extra data, all together
+	bx := append(int2octets(privkey, rolen), bits2octets(hash, q, rolen)...)
+	bx = append(bx, extra...)
+
+	v := bytes.Repeat([]byte{0x01}, sha256.Size)
+	k := make([]byte, sha256.Size)
+
+	k = hmacSHA256(k, v, []byte{0x00}, bx)
+	v = hmacSHA256(k, v)
+	k = hmacSHA256(k, v, []byte{0x01}, bx)
+	v = hmacSHA256(k, v)
+
+	for {
+		var t []byte
+		for len(t)*8 < qlen {
+			v = hmacSHA256(k, v)
+			t = append(t, v...)
+		}
+		secret := hashToInt(t)
+		if secret.Sign() > 0 && secret.Cmp(q) < 0 {
+			return secret
+		}
+		k = hmacSHA256(k, v, []byte{0x00})
+		v = hmacSHA256(k, v)
+	}
+}
+
+// hmacSHA256 is the hmac under key of all the ms strung together
+func hmacSHA256(key []byte, ms ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, m := range ms {
+		mac.Write(m)
+	}
+	return mac.Sum(nil)
+}
+
+// hashToInt is the leftmost 256 bits of hash, as an int
+func hashToInt(hash []byte) *big.Int {
+	if len(hash) > 32 {
+		hash = hash[:32]
+	}
+	return new(big.Int).SetBytes(hash)
+}
+
+// int2octets is v as rolen big endian bytes
+func int2octets(v *big.Int, rolen int) []byte {
+	out := v.Bytes()
+	if len(out) > rolen {
+		return out[len(out)-rolen:]
+	}
+	return append(make([]byte, rolen-len(out)), out...)
+}
+
+// bits2octets is hash mod q, as rolen bytes
+func bits2octets(hash []byte, q *big.Int, rolen int) []byte {
+	z := hashToInt(hash)
+	if z.Cmp(q) >= 0 {
+		z.Sub(z, q)
+	}
+	return int2octets(z, rolen)
+}
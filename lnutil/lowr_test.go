@@ -0,0 +1,53 @@
+package lnutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+// TestSignLowR makes a bunch of sigs and checks they're all short, verify,
+// and match btcec's when btcec's happen to be low-R
+func TestSignLowR(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey(btcec.S256())
+	matched := 0
+	for i := 0; i < 64; i++ {
+		hash := chainhash.DoubleHashB([]byte{byte(i)})
+		sig, err := SignLowR(priv, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der := sig.Serialize()
+		if len(der) > 71 {
+			t.Fatalf("sig %d is %d bytes: %x", i, len(der), der)
+		}
+		if !sig.Verify(hash, priv.PubKey()) {
+			t.Fatalf("sig %d doesn't verify", i)
+		}
+		// same key and hash, same sig
+		again, err := SignLowR(priv, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(der, again.Serialize()) {
+			t.Fatalf("sig %d not deterministic", i)
+		}
+		// first try is plain RFC6979
+		plain, err := priv.Sign(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain.R.BitLen() <= 255 {
+			if !bytes.Equal(der, plain.Serialize()) {
+				t.Fatalf("sig %d low-R but differs from btcec:\n%x\n%x",
+					i, der, plain.Serialize())
+			}
+			matched++
+		}
+	}
+	if matched == 0 {
+		t.Fatalf("no btcec sigs were low-R")
+	}
+}
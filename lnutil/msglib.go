@@ -12,6 +12,9 @@ import (
 //id numbers for messages, semi-arbitrary
 const (
 	MSGID_TEXTCHAT = 0x00 // send a text message
+	MSGID_FEATURES = 0x01 // what the node can do; see features.go
+	MSGID_ENVELOPE = 0x02 // a message with its format version; see envelope.go
+	MSGID_COMPRESSED = 0x03 // several messages deflated; see compress.go
 
 	//Channel creation messages
 	MSGID_POINTREQ  = 0x10
@@ -20,15 +23,24 @@ const (
 	MSGID_CHANACK   = 0x13
 	MSGID_SIGPROOF  = 0x14
 
+	//Funding tx replacement, before the channel confirms
+	MSGID_FUNDREPLACE    = 0x15 // move the channel to a tx paying more fee
+	MSGID_FUNDREPLACEACK = 0x16 // moved; here's my sig for it
+	MSGID_FUNDABORT      = 0x17 // funding tx double spent; channel's off
+
 	//Channel destruction messages
 	MSGID_CLOSEREQ  = 0x20 // close channel
 	MSGID_CLOSERESP = 0x21
 
+	MSGID_FORCECLOSEREQ  = 0x22 // please break the channel; signed with its fund key
+	MSGID_FORCECLOSERESP = 0x23 // broke it, or won't and why
+
 	//Push Pull Messages
 	MSGID_DELTASIG  = 0x30 // pushing funds in channel; request to send
 	MSGID_SIGREV    = 0x31 // pulling funds; signing new state and revoking old
 	MSGID_GAPSIGREV = 0x32 // resolving collision
 	MSGID_REV       = 0x33 // pushing funds; revoking previous channel state
+	MSGID_PAYCLAIM  = 0x34 // the invoice a push just made was paying
 
 	//not implemented
 	MSGID_FWDMSG     = 0x40
@@ -41,6 +53,25 @@ const (
 	MSGID_WATCH_DESC     = 0x60 // desc describes a new channel
 	MSGID_WATCH_STATEMSG = 0x61 // commsg is a single state in the channel
 	MSGID_WATCH_DELETE   = 0x62 // Watch_clear marks a channel as ok to delete.  No further updates possible.
+	MSGID_WATCH_ADVERT   = 0x63 // a tower saying where it is and what it does
+	MSGID_WATCH_REPREQ   = 0x64 // client asking what the tower has for its channels
+	MSGID_WATCH_REPORT   = 0x65 // states the tower holds for each channel asked about
+
+	//Atomic swap messages
+	MSGID_SWAP_OFFER  = 0x70 // offer to swap coins on one chain for another
+	MSGID_SWAP_ACCEPT = 0x71 // accept the offer, with keys and locktime
+	MSGID_SWAP_FUNDED = 0x72 // here's the tx with my side's htlc
+
+	//Liquidity messages
+	MSGID_LIQ_ADVERT   = 0x80 // channel capacity, and whether we'll open on request
+	MSGID_LIQ_OPENREQ  = 0x81 // please open a channel to me
+	MSGID_LIQ_OPENRESP = 0x82 // will, or won't, and why
+	MSGID_LIQ_OFFERS   = 0x83 // prices for leasing a channel to the peer
+	MSGID_LIQ_LEASEREQ = 0x84 // what would a channel this big cost?
+	MSGID_LIQ_TERMS    = 0x85 // this much, paid here; or no, and why
+
+	//Application messages
+	MSGID_CUSTOM = 0x90 // typed bytes for an application; see qln/custommsg.go
 )
 
 //interface that all messages follow, for easy use
@@ -67,6 +98,16 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 	switch msgType {
 	case MSGID_TEXTCHAT:
 		return NewChatMsgFromBytes(b, peerid)
+	case MSGID_FEATURES:
+		return NewFeaturesMsgFromBytes(b, peerid)
+	case MSGID_ENVELOPE:
+		e, err := ParseEnvelope(b)
+		if err != nil {
+			return nil, err
+		}
+		return LitMsgFromBytes(e.Msg, peerid)
+	case MSGID_COMPRESSED:
+		return NewCompressedMsgFromBytes(b, peerid)
 	case MSGID_POINTREQ:
 		return NewPointReqMsgFromBytes(b, peerid)
 	case MSGID_POINTRESP:
@@ -77,9 +118,17 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewChanAckMsgFromBytes(b, peerid)
 	case MSGID_SIGPROOF:
 		return NewSigProofMsgFromBytes(b, peerid)
+	case MSGID_FUNDREPLACE, MSGID_FUNDREPLACEACK:
+		return NewFundReplaceMsgFromBytes(b, peerid)
+	case MSGID_FUNDABORT:
+		return NewFundAbortMsgFromBytes(b, peerid)
 
 	case MSGID_CLOSEREQ:
 		return NewCloseReqMsgFromBytes(b, peerid)
+	case MSGID_FORCECLOSEREQ:
+		return NewForceCloseReqMsgFromBytes(b, peerid)
+	case MSGID_FORCECLOSERESP:
+		return NewForceCloseRespMsgFromBytes(b, peerid)
 	/* not implemented
 	case MSGID_CLOSERESP:
 	*/
@@ -92,6 +141,8 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewGapSigRevFromBytes(b, peerid)
 	case MSGID_REV:
 		return NewRevMsgFromBytes(b, peerid)
+	case MSGID_PAYCLAIM:
+		return NewPayClaimMsgFromBytes(b, peerid)
 
 	/*
 		case MSGID_FWDMSG:
@@ -104,9 +155,37 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewWatchDescMsgFromBytes(b, peerid)
 	case MSGID_WATCH_STATEMSG:
 		return NewWatchStateMsgFromBytes(b, peerid)
-	/*
-		case MSGID_WATCH_DELETE:
-	*/
+	case MSGID_WATCH_DELETE:
+		return NewWatchDelMsgFromBytes(b, peerid)
+	case MSGID_WATCH_ADVERT:
+		return NewTowerAdvertMsgFromBytes(b, peerid)
+	case MSGID_WATCH_REPREQ:
+		return NewWatchReportReqMsgFromBytes(b, peerid)
+	case MSGID_WATCH_REPORT:
+		return NewWatchReportMsgFromBytes(b, peerid)
+
+	case MSGID_SWAP_OFFER:
+		return NewSwapOfferMsgFromBytes(b, peerid)
+	case MSGID_SWAP_ACCEPT:
+		return NewSwapAcceptMsgFromBytes(b, peerid)
+	case MSGID_SWAP_FUNDED:
+		return NewSwapFundedMsgFromBytes(b, peerid)
+
+	case MSGID_LIQ_ADVERT:
+		return NewLiquidityAdMsgFromBytes(b, peerid)
+	case MSGID_LIQ_OPENREQ:
+		return NewOpenChanReqMsgFromBytes(b, peerid)
+	case MSGID_LIQ_OPENRESP:
+		return NewOpenChanRespMsgFromBytes(b, peerid)
+	case MSGID_LIQ_OFFERS:
+		return NewLeaseOffersMsgFromBytes(b, peerid)
+	case MSGID_LIQ_LEASEREQ:
+		return NewLeaseReqMsgFromBytes(b, peerid)
+	case MSGID_LIQ_TERMS:
+		return NewLeaseTermsMsgFromBytes(b, peerid)
+
+	case MSGID_CUSTOM:
+		return NewCustomMsgFromBytes(b, peerid)
 
 	default:
 		return nil, fmt.Errorf("Unknown message of type %d ", msgType)
@@ -254,6 +333,11 @@ type ChanDescMsg struct {
 	ElkZero [33]byte //consider changing into array in future
 	ElkOne  [33]byte
 	ElkTwo  [33]byte
+
+	Features Features // channel features offered; optional, on the end
+
+	// where the funder's coop close output goes; see shutdown.go
+	ShutdownScript []byte
 }
 
 func NewChanDescMsg(
@@ -300,6 +384,13 @@ func NewChanDescMsgFromBytes(b []byte, peerid uint32) (ChanDescMsg, error) {
 	copy(cm.ElkOne[:], buf.Next(33))
 	copy(cm.ElkTwo[:], buf.Next(33))
 
+	f, shutdown, err := ChanTailFromBytes(buf.Bytes())
+	if err != nil {
+		return *cm, err
+	}
+	cm.Features = f
+	cm.ShutdownScript = shutdown
+
 	return *cm, nil
 }
 
@@ -321,6 +412,7 @@ func (self ChanDescMsg) Bytes() []byte {
 	msg = append(msg, self.ElkZero[:]...)
 	msg = append(msg, self.ElkOne[:]...)
 	msg = append(msg, self.ElkTwo[:]...)
+	msg = append(msg, ChanTail(self.Features, self.ShutdownScript)...)
 	return msg
 }
 
@@ -335,6 +427,11 @@ type ChanAckMsg struct {
 	ElkOne    [33]byte
 	ElkTwo    [33]byte
 	Signature [64]byte
+
+	Features Features // channel features agreed; optional, on the end
+
+	// where the acceptor's coop close output goes; see shutdown.go
+	ShutdownScript []byte
 }
 
 func NewChanAckMsg(peerid uint32, OP wire.OutPoint, ELKZero [33]byte, ELKOne [33]byte, ELKTwo [33]byte, SIG [64]byte) ChanAckMsg {
@@ -365,6 +462,12 @@ func NewChanAckMsgFromBytes(b []byte, peerid uint32) (ChanAckMsg, error) {
 	copy(cm.ElkOne[:], buf.Next(33))
 	copy(cm.ElkTwo[:], buf.Next(33))
 	copy(cm.Signature[:], buf.Next(64))
+	f, shutdown, err := ChanTailFromBytes(buf.Bytes())
+	if err != nil {
+		return *cm, err
+	}
+	cm.Features = f
+	cm.ShutdownScript = shutdown
 	return *cm, nil
 }
 
@@ -377,6 +480,7 @@ func (self ChanAckMsg) Bytes() []byte {
 	msg = append(msg, self.ElkOne[:]...)
 	msg = append(msg, self.ElkTwo[:]...)
 	msg = append(msg, self.Signature[:]...)
+	msg = append(msg, ChanTail(self.Features, self.ShutdownScript)...)
 	return msg
 }
 
@@ -429,6 +533,89 @@ func (self SigProofMsg) MsgType() uint8 { return MSGID_SIGPROOF }
 
 //----------
 
+// FundReplaceMsg moves a channel that hasn't confirmed to a new funding
+// outpoint, in a tx replacing the old one.  The funder sends it with its
+// sig on the recipient's commitment spending the new outpoint; the
+// recipient answers with Ack set and its sig on the funder's.
+type FundReplaceMsg struct {
+	PeerIdx     uint32
+	Ack         bool
+	Outpoint    wire.OutPoint // where the channel is now
+	NewOutpoint wire.OutPoint
+	Signature   [64]byte
+}
+
+func NewFundReplaceMsgFromBytes(b []byte, peerid uint32) (FundReplaceMsg, error) {
+	fr := new(FundReplaceMsg)
+	fr.PeerIdx = peerid
+
+	if len(b) < 137 {
+		return *fr, fmt.Errorf("got %d byte fund replace, expect 137", len(b))
+	}
+	fr.Ack = b[0] == MSGID_FUNDREPLACEACK
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	fr.Outpoint = *OutPointFromBytes(op)
+	copy(op[:], buf.Next(36))
+	fr.NewOutpoint = *OutPointFromBytes(op)
+	copy(fr.Signature[:], buf.Next(64))
+	return *fr, nil
+}
+
+func (self FundReplaceMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	opArr = OutPointToBytes(self.NewOutpoint)
+	msg = append(msg, opArr[:]...)
+	msg = append(msg, self.Signature[:]...)
+	return msg
+}
+
+func (self FundReplaceMsg) Peer() uint32 { return self.PeerIdx }
+func (self FundReplaceMsg) MsgType() uint8 {
+	if self.Ack {
+		return MSGID_FUNDREPLACEACK
+	}
+	return MSGID_FUNDREPLACE
+}
+
+//----------
+
+// FundAbortMsg says the funding tx for a channel that hasn't confirmed was
+// double spent, so the channel won't open
+type FundAbortMsg struct {
+	PeerIdx  uint32
+	Outpoint wire.OutPoint
+}
+
+func NewFundAbortMsgFromBytes(b []byte, peerid uint32) (FundAbortMsg, error) {
+	fa := new(FundAbortMsg)
+	fa.PeerIdx = peerid
+
+	if len(b) < 37 {
+		return *fa, fmt.Errorf("got %d byte fund abort, expect 37", len(b))
+	}
+	var op [36]byte
+	copy(op[:], b[1:37])
+	fa.Outpoint = *OutPointFromBytes(op)
+	return *fa, nil
+}
+
+func (self FundAbortMsg) Bytes() []byte {
+	opArr := OutPointToBytes(self.Outpoint)
+	return append([]byte{self.MsgType()}, opArr[:]...)
+}
+
+func (self FundAbortMsg) Peer() uint32   { return self.PeerIdx }
+func (self FundAbortMsg) MsgType() uint8 { return MSGID_FUNDABORT }
+
+//----------
+
 //message for closing a channel
 type CloseReqMsg struct {
 	PeerIdx   uint32
@@ -476,6 +663,116 @@ func (self CloseReqMsg) MsgType() uint8 { return MSGID_CLOSEREQ }
 
 //----------
 
+// ForceCloseReqMsg asks the peer to broadcast its latest state for a
+// channel, for when ours is lost or its keys are out.  It's signed with
+// the sender's fund key for the channel, over SigHash.
+type ForceCloseReqMsg struct {
+	PeerIdx   uint32
+	Outpoint  wire.OutPoint
+	Time      int64 // unix; stale requests are turned down
+	Signature [64]byte
+	Reason    string
+}
+
+func NewForceCloseReqMsgFromBytes(b []byte, peerid uint32) (ForceCloseReqMsg, error) {
+	fr := new(ForceCloseReqMsg)
+	fr.PeerIdx = peerid
+
+	if len(b) < 109 {
+		return *fr, fmt.Errorf("got %d byte force close request, expect 109+",
+			len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	fr.Outpoint = *OutPointFromBytes(op)
+	_ = binary.Read(buf, binary.BigEndian, &fr.Time)
+	copy(fr.Signature[:], buf.Next(64))
+	fr.Reason = string(buf.Bytes())
+	return *fr, nil
+}
+
+func (self ForceCloseReqMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	buf.Write(opArr[:])
+	binary.Write(&buf, binary.BigEndian, self.Time)
+	buf.Write(self.Signature[:])
+	buf.WriteString(self.Reason)
+	return buf.Bytes()
+}
+
+// SigHash is what the request's signature signs: the outpoint, time and
+// reason, so it can't be used for another channel or replayed later
+func (self ForceCloseReqMsg) SigHash() chainhash.Hash {
+	var buf bytes.Buffer
+	buf.WriteString("lit force close request")
+	opArr := OutPointToBytes(self.Outpoint)
+	buf.Write(opArr[:])
+	binary.Write(&buf, binary.BigEndian, self.Time)
+	buf.WriteString(self.Reason)
+	return chainhash.HashH(buf.Bytes())
+}
+
+func (self ForceCloseReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self ForceCloseReqMsg) MsgType() uint8 { return MSGID_FORCECLOSEREQ }
+
+//----------
+
+// ForceCloseRespMsg answers a ForceCloseReqMsg.  If Closed, Txid is the
+// close tx broadcast; if not, Reason says why.
+type ForceCloseRespMsg struct {
+	PeerIdx  uint32
+	Outpoint wire.OutPoint
+	Closed   bool
+	Txid     chainhash.Hash
+	Reason   string
+}
+
+func NewForceCloseRespMsgFromBytes(b []byte, peerid uint32) (ForceCloseRespMsg, error) {
+	fr := new(ForceCloseRespMsg)
+	fr.PeerIdx = peerid
+
+	if len(b) < 70 {
+		return *fr, fmt.Errorf("got %d byte force close response, expect 70+",
+			len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	fr.Outpoint = *OutPointFromBytes(op)
+	closed, _ := buf.ReadByte()
+	fr.Closed = closed != 0
+	copy(fr.Txid[:], buf.Next(32))
+	fr.Reason = string(buf.Bytes())
+	return *fr, nil
+}
+
+func (self ForceCloseRespMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	buf.Write(opArr[:])
+	if self.Closed {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(self.Txid[:])
+	buf.WriteString(self.Reason)
+	return buf.Bytes()
+}
+
+func (self ForceCloseRespMsg) Peer() uint32   { return self.PeerIdx }
+func (self ForceCloseRespMsg) MsgType() uint8 { return MSGID_FORCECLOSERESP }
+
+//----------
+
 //message for sending an amount with the signature
 type DeltaSigMsg struct {
 	PeerIdx   uint32
@@ -681,6 +978,56 @@ func (self RevMsg) Bytes() []byte {
 func (self RevMsg) Peer() uint32   { return self.PeerIdx }
 func (self RevMsg) MsgType() uint8 { return MSGID_REV }
 
+// PayClaimMsg follows a push that paid an invoice, telling the payee which
+// invoice it was for, since pushes don't say.  See qln/payclaim.go.
+type PayClaimMsg struct {
+	PeerIdx     uint32
+	Outpoint    wire.OutPoint
+	PaymentHash [32]byte
+	Amt         int64
+}
+
+func NewPayClaimMsg(peerid uint32, OP wire.OutPoint,
+	hash [32]byte, amt int64) PayClaimMsg {
+	p := new(PayClaimMsg)
+	p.PeerIdx = peerid
+	p.Outpoint = OP
+	p.PaymentHash = hash
+	p.Amt = amt
+	return *p
+}
+
+func NewPayClaimMsgFromBytes(b []byte, peerid uint32) (PayClaimMsg, error) {
+	p := new(PayClaimMsg)
+	p.PeerIdx = peerid
+
+	if len(b) < 77 {
+		return *p, fmt.Errorf("got %d byte PayClaim, expect 77", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	p.Outpoint = *OutPointFromBytes(op)
+	copy(p.PaymentHash[:], buf.Next(32))
+	p.Amt = BtI64(buf.Next(8))
+	return *p, nil
+}
+
+func (self PayClaimMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	msg = append(msg, self.PaymentHash[:]...)
+	msg = append(msg, I64tB(self.Amt)...)
+	return msg
+}
+
+func (self PayClaimMsg) Peer() uint32   { return self.PeerIdx }
+func (self PayClaimMsg) MsgType() uint8 { return MSGID_PAYCLAIM }
+
 //----------
 
 // 2 structs that the watchtower gets from clients: Descriptors and Msgs
@@ -692,6 +1039,10 @@ func (self RevMsg) MsgType() uint8 { return MSGID_REV }
 // HAKDbase 33
 // Timebase 33
 // Elk0 32
+// then, for taproot commitments (see taproot.go):
+// CommitType 1
+// InternalKey 32 (CommitTaprootScript only)
+// path length 1, path 32 each
 
 // WatchannelDescriptor is the initial message setting up a Watchannel
 type WatchDescMsg struct {
@@ -704,6 +1055,13 @@ type WatchDescMsg struct {
 
 	CustomerBasePoint  [33]byte // client's HAKD key base point
 	AdversaryBasePoint [33]byte // potential attacker's timeout basepoint
+
+	// how the commitment's to-self output is made; CommitLegacy unless
+	// it's taproot.  Taproot ones have the rest of what's in the control
+	// block of a revocation spend, besides the keys from the base points.
+	CommitType  uint8
+	InternalKey [32]byte   // for CommitTaprootScript
+	TapPath     [][32]byte // hashes above the revocation and delay leaves
 }
 
 // NewWatchDescMsg turns 96 bytes into a WatchannelDescriptor
@@ -744,6 +1102,29 @@ func NewWatchDescMsgFromBytes(b []byte, peerIDX uint32) (WatchDescMsg, error) {
 	copy(sd.CustomerBasePoint[:], buf.Next(33))
 	copy(sd.AdversaryBasePoint[:], buf.Next(33))
 
+	if buf.Len() == 0 {
+		return *sd, nil
+	}
+	sd.CommitType, _ = buf.ReadByte()
+	if sd.CommitType == CommitTaprootScript {
+		if buf.Len() < 32 {
+			return *sd, fmt.Errorf("WatchannelDescriptor missing internal key")
+		}
+		copy(sd.InternalKey[:], buf.Next(32))
+	}
+	pathLen, err := buf.ReadByte()
+	if err != nil {
+		return *sd, fmt.Errorf("WatchannelDescriptor missing tap path")
+	}
+	if buf.Len() != 32*int(pathLen) {
+		return *sd, fmt.Errorf("WatchannelDescriptor tap path %d bytes, expect %d",
+			buf.Len(), 32*int(pathLen))
+	}
+	sd.TapPath = make([][32]byte, pathLen)
+	for i := range sd.TapPath {
+		copy(sd.TapPath[i][:], buf.Next(32))
+	}
+
 	return *sd, nil
 }
 
@@ -757,6 +1138,16 @@ func (self WatchDescMsg) Bytes() []byte {
 	binary.Write(&buf, binary.BigEndian, self.Fee)
 	buf.Write(self.CustomerBasePoint[:])
 	buf.Write(self.AdversaryBasePoint[:])
+	if self.CommitType != CommitLegacy {
+		buf.WriteByte(self.CommitType)
+		if self.CommitType == CommitTaprootScript {
+			buf.Write(self.InternalKey[:])
+		}
+		buf.WriteByte(uint8(len(self.TapPath)))
+		for _, h := range self.TapPath {
+			buf.Write(h[:])
+		}
+	}
 	return buf.Bytes()
 }
 
@@ -779,6 +1170,7 @@ type WatchStateMsg struct {
 	Elk      chainhash.Hash // elkrem for this state index
 	ParTxid  [16]byte       // 16 bytes of txid
 	Sig      [64]byte       // 64 bytes of sig
+	HashType uint8          // sighash type of Sig; 0 means SIGHASH_ALL
 }
 
 func NewComMsg(peerIdx, cointype uint32, destPKH [20]byte,
@@ -809,6 +1201,10 @@ func NewWatchStateMsgFromBytes(b []byte, peerIDX uint32) (WatchStateMsg, error)
 	copy(sm.ParTxid[:], buf.Next(16))
 	copy(sm.Sig[:], buf.Next(64))
 	copy(sm.Elk[:], buf.Next(32))
+	// older clients don't send a sighash type
+	if buf.Len() > 0 {
+		sm.HashType, _ = buf.ReadByte()
+	}
 
 	return *sm, nil
 }
@@ -822,6 +1218,10 @@ func (self WatchStateMsg) Bytes() []byte {
 	buf.Write(self.ParTxid[:])
 	buf.Write(self.Sig[:])
 	buf.Write(self.Elk.CloneBytes())
+	// leave it off for SIGHASH_ALL so older towers still take it
+	if self.HashType != 0 && self.HashType != 1 {
+		buf.WriteByte(self.HashType)
+	}
 	return buf.Bytes()
 }
 
@@ -865,3 +1265,711 @@ func NewWatchDelMsgFromBytes(b []byte, peerIDX uint32) (WatchDelMsg, error) {
 }
 func (self WatchDelMsg) Peer() uint32   { return self.PeerIdx }
 func (self WatchDelMsg) MsgType() uint8 { return MSGID_WATCH_DELETE }
+
+//----------
+
+// tower feature bits, in TowerAdvertMsg.Features
+const (
+	TowerFeatAccepting = 1 << 0 // taking new channels
+	TowerFeatHTLC      = 1 << 1 // can watch channels with htlcs
+)
+
+// TowerAdvertMsg is a watchtower saying it exists.  Towers send their own
+// to every peer, and nodes pass on ones they've heard, so Sig (by TowerPub,
+// over SigHash) is what makes it believable, not who it came from.
+type TowerAdvertMsg struct {
+	PeerIdx   uint32
+	TowerPub  [33]byte // the tower's identity key; connect to its lit address
+	Time      int64    // unix seconds; newer adverts replace older ones
+	RewardPPM uint32   // cut of recovered funds the tower wants, per million
+	Features  uint32
+	CoinTypes []uint32 // coins it watches
+	Host      string   // host:port, or empty if not listening publicly
+	Sig       [64]byte
+}
+
+func NewTowerAdvertMsgFromBytes(b []byte, peerid uint32) (TowerAdvertMsg, error) {
+	ta := new(TowerAdvertMsg)
+	ta.PeerIdx = peerid
+
+	if len(b) < 116 {
+		return *ta, fmt.Errorf("got %d byte tower advert, expect 116+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(ta.TowerPub[:], buf.Next(33))
+	_ = binary.Read(buf, binary.BigEndian, &ta.Time)
+	_ = binary.Read(buf, binary.BigEndian, &ta.RewardPPM)
+	_ = binary.Read(buf, binary.BigEndian, &ta.Features)
+	nCoins, _ := buf.ReadByte()
+	if buf.Len() < int(nCoins)*4+1+64 {
+		return *ta, fmt.Errorf("tower advert with %d coins too short", nCoins)
+	}
+	ta.CoinTypes = make([]uint32, nCoins)
+	for i := range ta.CoinTypes {
+		_ = binary.Read(buf, binary.BigEndian, &ta.CoinTypes[i])
+	}
+	hostLen, _ := buf.ReadByte()
+	if buf.Len() != int(hostLen)+64 {
+		return *ta, fmt.Errorf("tower advert host %d bytes, %d left", hostLen, buf.Len())
+	}
+	ta.Host = string(buf.Next(int(hostLen)))
+	copy(ta.Sig[:], buf.Next(64))
+	return *ta, nil
+}
+
+// unsigned is everything but the sig
+func (self TowerAdvertMsg) unsigned() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.TowerPub[:])
+	binary.Write(&buf, binary.BigEndian, self.Time)
+	binary.Write(&buf, binary.BigEndian, self.RewardPPM)
+	binary.Write(&buf, binary.BigEndian, self.Features)
+	coins := self.CoinTypes
+	if len(coins) > 255 {
+		coins = coins[:255]
+	}
+	buf.WriteByte(uint8(len(coins)))
+	for _, c := range coins {
+		binary.Write(&buf, binary.BigEndian, c)
+	}
+	host := self.Host
+	if len(host) > 255 {
+		host = host[:255]
+	}
+	buf.WriteByte(uint8(len(host)))
+	buf.WriteString(host)
+	return buf.Bytes()
+}
+
+// SigHash is what the tower signs
+func (self TowerAdvertMsg) SigHash() [32]byte {
+	return chainhash.DoubleHashH(self.unsigned())
+}
+
+func (self TowerAdvertMsg) Bytes() []byte {
+	return append(self.unsigned(), self.Sig[:]...)
+}
+
+func (self TowerAdvertMsg) Peer() uint32   { return self.PeerIdx }
+func (self TowerAdvertMsg) MsgType() uint8 { return MSGID_WATCH_ADVERT }
+
+//----------
+
+// WatchReportMax is the most channels one report request can ask about
+const WatchReportMax = 1000
+
+// WatchReportReqMsg asks a tower what it has for channels, by the DestPKH
+// they were described with.  Only their owner knows those.
+type WatchReportReqMsg struct {
+	PeerIdx uint32
+	PKHs    [][20]byte
+}
+
+func NewWatchReportReqMsgFromBytes(b []byte, peerid uint32) (WatchReportReqMsg, error) {
+	rr := new(WatchReportReqMsg)
+	rr.PeerIdx = peerid
+
+	if len(b) < 3 {
+		return *rr, fmt.Errorf("got %d byte report request, expect 3+", len(b))
+	}
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	var n uint16
+	_ = binary.Read(buf, binary.BigEndian, &n)
+	if n > WatchReportMax || buf.Len() != int(n)*20 {
+		return *rr, fmt.Errorf("report request for %d channels, %d bytes",
+			n, buf.Len())
+	}
+	rr.PKHs = make([][20]byte, n)
+	for i := range rr.PKHs {
+		copy(rr.PKHs[i][:], buf.Next(20))
+	}
+	return *rr, nil
+}
+
+func (self WatchReportReqMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	pkhs := self.PKHs
+	if len(pkhs) > WatchReportMax {
+		pkhs = pkhs[:WatchReportMax]
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(pkhs)))
+	for _, pkh := range pkhs {
+		buf.Write(pkh[:])
+	}
+	return buf.Bytes()
+}
+
+func (self WatchReportReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self WatchReportReqMsg) MsgType() uint8 { return MSGID_WATCH_REPREQ }
+
+// WatchReportEntry.Flags
+const (
+	WatchReportKnown     = 1 << 0 // the tower has the channel's description
+	WatchReportUnwatched = 1 << 1 // and was told it closed
+	WatchReportHasStates = 1 << 2 // and got at least state 0; LastState is good
+)
+
+// WatchReportEntry is what a tower has for one channel: the justice txids
+// it holds, and the last state it got.  It takes states in order, so it has
+// elkrem for every state up to LastState; if States is less than
+// LastState+1, it lost txids.
+type WatchReportEntry struct {
+	PKH       [20]byte
+	Flags     uint8
+	States    uint64
+	LastState uint64
+}
+
+// WatchReportMsg answers a WatchReportReqMsg, a 37 byte entry per channel in
+// the order asked
+type WatchReportMsg struct {
+	PeerIdx uint32
+	Entries []WatchReportEntry
+}
+
+func NewWatchReportMsgFromBytes(b []byte, peerid uint32) (WatchReportMsg, error) {
+	wr := new(WatchReportMsg)
+	wr.PeerIdx = peerid
+
+	if len(b) < 3 {
+		return *wr, fmt.Errorf("got %d byte tower report, expect 3+", len(b))
+	}
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	var n uint16
+	_ = binary.Read(buf, binary.BigEndian, &n)
+	if n > WatchReportMax || buf.Len() != int(n)*37 {
+		return *wr, fmt.Errorf("tower report of %d channels, %d bytes",
+			n, buf.Len())
+	}
+	wr.Entries = make([]WatchReportEntry, n)
+	for i := range wr.Entries {
+		e := &wr.Entries[i]
+		copy(e.PKH[:], buf.Next(20))
+		e.Flags, _ = buf.ReadByte()
+		_ = binary.Read(buf, binary.BigEndian, &e.States)
+		_ = binary.Read(buf, binary.BigEndian, &e.LastState)
+	}
+	return *wr, nil
+}
+
+func (self WatchReportMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	entries := self.Entries
+	if len(entries) > WatchReportMax {
+		entries = entries[:WatchReportMax]
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(entries)))
+	for _, e := range entries {
+		buf.Write(e.PKH[:])
+		buf.WriteByte(e.Flags)
+		binary.Write(&buf, binary.BigEndian, e.States)
+		binary.Write(&buf, binary.BigEndian, e.LastState)
+	}
+	return buf.Bytes()
+}
+
+func (self WatchReportMsg) Peer() uint32   { return self.PeerIdx }
+func (self WatchReportMsg) MsgType() uint8 { return MSGID_WATCH_REPORT }
+
+//----------
+
+// SwapOfferMsg offers OfferAmt of OfferCoin for WantAmt of WantCoin.  The
+// offerer knows the preimage of Hash, and will fund an htlc on OfferCoin
+// which it can refund at height Locktime.
+type SwapOfferMsg struct {
+	PeerIdx   uint32
+	Hash      [32]byte
+	OfferCoin uint32
+	OfferAmt  int64
+	WantCoin  uint32
+	WantAmt   int64
+	RefundPub [33]byte // refunds the offerer's htlc, on OfferCoin
+	ClaimPub  [33]byte // claims the accepter's htlc, on WantCoin
+	Locktime  uint32   // OfferCoin height
+}
+
+func NewSwapOfferMsgFromBytes(b []byte, peerid uint32) (SwapOfferMsg, error) {
+	so := new(SwapOfferMsg)
+	so.PeerIdx = peerid
+
+	if len(b) < 127 {
+		return *so, fmt.Errorf("got %d byte swap offer, expect 127", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(so.Hash[:], buf.Next(32))
+	_ = binary.Read(buf, binary.BigEndian, &so.OfferCoin)
+	_ = binary.Read(buf, binary.BigEndian, &so.OfferAmt)
+	_ = binary.Read(buf, binary.BigEndian, &so.WantCoin)
+	_ = binary.Read(buf, binary.BigEndian, &so.WantAmt)
+	copy(so.RefundPub[:], buf.Next(33))
+	copy(so.ClaimPub[:], buf.Next(33))
+	_ = binary.Read(buf, binary.BigEndian, &so.Locktime)
+	return *so, nil
+}
+
+func (self SwapOfferMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.Hash[:])
+	binary.Write(&buf, binary.BigEndian, self.OfferCoin)
+	binary.Write(&buf, binary.BigEndian, self.OfferAmt)
+	binary.Write(&buf, binary.BigEndian, self.WantCoin)
+	binary.Write(&buf, binary.BigEndian, self.WantAmt)
+	buf.Write(self.RefundPub[:])
+	buf.Write(self.ClaimPub[:])
+	binary.Write(&buf, binary.BigEndian, self.Locktime)
+	return buf.Bytes()
+}
+
+func (self SwapOfferMsg) Peer() uint32   { return self.PeerIdx }
+func (self SwapOfferMsg) MsgType() uint8 { return MSGID_SWAP_OFFER }
+
+//----------
+
+// SwapAcceptMsg accepts the swap with Hash.  The accepter's htlc, on the
+// offer's WantCoin, can be refunded at height Locktime.
+type SwapAcceptMsg struct {
+	PeerIdx   uint32
+	Hash      [32]byte
+	RefundPub [33]byte // refunds the accepter's htlc, on WantCoin
+	ClaimPub  [33]byte // claims the offerer's htlc, on OfferCoin
+	Locktime  uint32   // WantCoin height
+}
+
+func NewSwapAcceptMsgFromBytes(b []byte, peerid uint32) (SwapAcceptMsg, error) {
+	sa := new(SwapAcceptMsg)
+	sa.PeerIdx = peerid
+
+	if len(b) < 103 {
+		return *sa, fmt.Errorf("got %d byte swap accept, expect 103", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(sa.Hash[:], buf.Next(32))
+	copy(sa.RefundPub[:], buf.Next(33))
+	copy(sa.ClaimPub[:], buf.Next(33))
+	_ = binary.Read(buf, binary.BigEndian, &sa.Locktime)
+	return *sa, nil
+}
+
+func (self SwapAcceptMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.Hash[:])
+	buf.Write(self.RefundPub[:])
+	buf.Write(self.ClaimPub[:])
+	binary.Write(&buf, binary.BigEndian, self.Locktime)
+	return buf.Bytes()
+}
+
+func (self SwapAcceptMsg) Peer() uint32   { return self.PeerIdx }
+func (self SwapAcceptMsg) MsgType() uint8 { return MSGID_SWAP_ACCEPT }
+
+//----------
+
+// SwapFundedMsg carries the tx funding the sender's htlc for swap Hash, so
+// the other side can check it before waiting for it to confirm.
+type SwapFundedMsg struct {
+	PeerIdx uint32
+	Hash    [32]byte
+	Tx      *wire.MsgTx
+}
+
+func NewSwapFundedMsgFromBytes(b []byte, peerid uint32) (SwapFundedMsg, error) {
+	sf := new(SwapFundedMsg)
+	sf.PeerIdx = peerid
+
+	if len(b) < 34 {
+		return *sf, fmt.Errorf("got %d byte swap funded, expect 34+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(sf.Hash[:], buf.Next(32))
+	sf.Tx = wire.NewMsgTx()
+	err := sf.Tx.Deserialize(buf)
+	if err != nil {
+		return *sf, err
+	}
+	return *sf, nil
+}
+
+func (self SwapFundedMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.Hash[:])
+	if self.Tx != nil {
+		self.Tx.Serialize(&buf)
+	}
+	return buf.Bytes()
+}
+
+func (self SwapFundedMsg) Peer() uint32   { return self.PeerIdx }
+func (self SwapFundedMsg) MsgType() uint8 { return MSGID_SWAP_FUNDED }
+
+//----------
+
+// liquidity advert flags, in LiquidityAdMsg.Flags
+const (
+	LiqFlagOpensOnRequest = 1 << 0 // answers OpenChanReqMsgs; see MaxOpen
+)
+
+// LiquidityCoin is a node's channel capacity on one coin
+type LiquidityCoin struct {
+	CoinType uint32
+	Inbound  int64 // what its channels can receive; their side, summed
+	Outbound int64 // what they can send; its side, summed
+	MaxOpen  int64 // biggest channel it'll open on request; 0 if it won't
+}
+
+// LiquidityAdMsg tells a peer how much a node can send and receive over its
+// channels, and whether it'll open a channel if asked.  It's only sent to
+// the peer directly and not passed on, so it isn't signed.
+type LiquidityAdMsg struct {
+	PeerIdx uint32
+	Time    int64 // unix seconds
+	Flags   uint8
+	Coins   []LiquidityCoin
+}
+
+func NewLiquidityAdMsgFromBytes(b []byte, peerid uint32) (LiquidityAdMsg, error) {
+	la := new(LiquidityAdMsg)
+	la.PeerIdx = peerid
+
+	if len(b) < 11 {
+		return *la, fmt.Errorf("got %d byte liquidity advert, expect 11+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &la.Time)
+	la.Flags, _ = buf.ReadByte()
+	nCoins, _ := buf.ReadByte()
+	if buf.Len() != int(nCoins)*28 {
+		return *la, fmt.Errorf("liquidity advert with %d coins has %d bytes",
+			nCoins, buf.Len())
+	}
+	la.Coins = make([]LiquidityCoin, nCoins)
+	for i := range la.Coins {
+		_ = binary.Read(buf, binary.BigEndian, &la.Coins[i].CoinType)
+		_ = binary.Read(buf, binary.BigEndian, &la.Coins[i].Inbound)
+		_ = binary.Read(buf, binary.BigEndian, &la.Coins[i].Outbound)
+		_ = binary.Read(buf, binary.BigEndian, &la.Coins[i].MaxOpen)
+	}
+	return *la, nil
+}
+
+func (self LiquidityAdMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.Time)
+	buf.WriteByte(self.Flags)
+	coins := self.Coins
+	if len(coins) > 255 {
+		coins = coins[:255]
+	}
+	buf.WriteByte(uint8(len(coins)))
+	for _, c := range coins {
+		binary.Write(&buf, binary.BigEndian, c.CoinType)
+		binary.Write(&buf, binary.BigEndian, c.Inbound)
+		binary.Write(&buf, binary.BigEndian, c.Outbound)
+		binary.Write(&buf, binary.BigEndian, c.MaxOpen)
+	}
+	return buf.Bytes()
+}
+
+func (self LiquidityAdMsg) Peer() uint32   { return self.PeerIdx }
+func (self LiquidityAdMsg) MsgType() uint8 { return MSGID_LIQ_ADVERT }
+
+//----------
+
+// OpenChanReqMsg asks a peer to fund a channel of Capacity on CoinType to
+// the sender, giving the sender that much inbound capacity.
+type OpenChanReqMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Capacity int64
+}
+
+func NewOpenChanReqMsgFromBytes(b []byte, peerid uint32) (OpenChanReqMsg, error) {
+	or := new(OpenChanReqMsg)
+	or.PeerIdx = peerid
+
+	if len(b) < 13 {
+		return *or, fmt.Errorf("got %d byte open request, expect 13", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &or.CoinType)
+	_ = binary.Read(buf, binary.BigEndian, &or.Capacity)
+	return *or, nil
+}
+
+func (self OpenChanReqMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.CoinType)
+	binary.Write(&buf, binary.BigEndian, self.Capacity)
+	return buf.Bytes()
+}
+
+func (self OpenChanReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self OpenChanReqMsg) MsgType() uint8 { return MSGID_LIQ_OPENREQ }
+
+//----------
+
+// OpenChanRespMsg answers an OpenChanReqMsg.  If Accepted, the channel's
+// funding starts right after; if not, Reason says why.
+type OpenChanRespMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Capacity int64
+	Accepted bool
+	Reason   string
+}
+
+func NewOpenChanRespMsgFromBytes(b []byte, peerid uint32) (OpenChanRespMsg, error) {
+	or := new(OpenChanRespMsg)
+	or.PeerIdx = peerid
+
+	if len(b) < 14 {
+		return *or, fmt.Errorf("got %d byte open response, expect 14+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &or.CoinType)
+	_ = binary.Read(buf, binary.BigEndian, &or.Capacity)
+	acc, _ := buf.ReadByte()
+	or.Accepted = acc != 0
+	or.Reason = string(buf.Bytes())
+	return *or, nil
+}
+
+func (self OpenChanRespMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.CoinType)
+	binary.Write(&buf, binary.BigEndian, self.Capacity)
+	if self.Accepted {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.WriteString(self.Reason)
+	return buf.Bytes()
+}
+
+func (self OpenChanRespMsg) Peer() uint32   { return self.PeerIdx }
+func (self OpenChanRespMsg) MsgType() uint8 { return MSGID_LIQ_OPENRESP }
+
+//----------
+
+// LeaseTier is a channel size a node sells, and for how much
+type LeaseTier struct {
+	CoinType uint32
+	Capacity int64
+	FeePPM   uint32 // price, per million of Capacity
+	Blocks   uint32 // it'll keep the channel open at least this long
+}
+
+// Fee is what a lease of this tier costs
+func (t LeaseTier) Fee() int64 {
+	return t.Capacity * int64(t.FeePPM) / 1000000
+}
+
+// LeaseOffersMsg lists the channels a node sells to the peer it's sent to.
+// Like LiquidityAdMsg it's only sent direct, and unsigned; the prices are
+// confirmed in the LeaseTermsMsg for a lease.
+type LeaseOffersMsg struct {
+	PeerIdx uint32
+	Tiers   []LeaseTier
+}
+
+func NewLeaseOffersMsgFromBytes(b []byte, peerid uint32) (LeaseOffersMsg, error) {
+	lo := new(LeaseOffersMsg)
+	lo.PeerIdx = peerid
+
+	if len(b) < 2 {
+		return *lo, fmt.Errorf("got %d byte lease offers, expect 2+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	nTiers, _ := buf.ReadByte()
+	if buf.Len() != int(nTiers)*20 {
+		return *lo, fmt.Errorf("lease offers with %d tiers has %d bytes",
+			nTiers, buf.Len())
+	}
+	lo.Tiers = make([]LeaseTier, nTiers)
+	for i := range lo.Tiers {
+		_ = binary.Read(buf, binary.BigEndian, &lo.Tiers[i].CoinType)
+		_ = binary.Read(buf, binary.BigEndian, &lo.Tiers[i].Capacity)
+		_ = binary.Read(buf, binary.BigEndian, &lo.Tiers[i].FeePPM)
+		_ = binary.Read(buf, binary.BigEndian, &lo.Tiers[i].Blocks)
+	}
+	return *lo, nil
+}
+
+func (self LeaseOffersMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	tiers := self.Tiers
+	if len(tiers) > 255 {
+		tiers = tiers[:255]
+	}
+	buf.WriteByte(uint8(len(tiers)))
+	for _, t := range tiers {
+		binary.Write(&buf, binary.BigEndian, t.CoinType)
+		binary.Write(&buf, binary.BigEndian, t.Capacity)
+		binary.Write(&buf, binary.BigEndian, t.FeePPM)
+		binary.Write(&buf, binary.BigEndian, t.Blocks)
+	}
+	return buf.Bytes()
+}
+
+func (self LeaseOffersMsg) Peer() uint32   { return self.PeerIdx }
+func (self LeaseOffersMsg) MsgType() uint8 { return MSGID_LIQ_OFFERS }
+
+//----------
+
+// LeaseReqMsg asks for the terms of leasing a Capacity channel on CoinType
+// from the peer; Capacity has to be one of its tiers.
+type LeaseReqMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Capacity int64
+}
+
+func NewLeaseReqMsgFromBytes(b []byte, peerid uint32) (LeaseReqMsg, error) {
+	lr := new(LeaseReqMsg)
+	lr.PeerIdx = peerid
+
+	if len(b) < 13 {
+		return *lr, fmt.Errorf("got %d byte lease request, expect 13", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &lr.CoinType)
+	_ = binary.Read(buf, binary.BigEndian, &lr.Capacity)
+	return *lr, nil
+}
+
+func (self LeaseReqMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.CoinType)
+	binary.Write(&buf, binary.BigEndian, self.Capacity)
+	return buf.Bytes()
+}
+
+func (self LeaseReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self LeaseReqMsg) MsgType() uint8 { return MSGID_LIQ_LEASEREQ }
+
+//----------
+
+// LeaseTermsMsg answers a LeaseReqMsg.  Pay Fee before Expires, either
+// over PayReq or on chain to PKH (p2wpkh), and the channel's opened, then
+// kept open Blocks.  If Reason isn't empty there's no lease, and it says
+// why.
+type LeaseTermsMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Capacity int64
+	Fee      int64
+	Blocks   uint32
+	Expires  int64 // unix seconds
+	PKH      [20]byte
+	PayReq   string
+	Reason   string
+}
+
+func NewLeaseTermsMsgFromBytes(b []byte, peerid uint32) (LeaseTermsMsg, error) {
+	lt := new(LeaseTermsMsg)
+	lt.PeerIdx = peerid
+
+	if len(b) < 55 {
+		return *lt, fmt.Errorf("got %d byte lease terms, expect 55+", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &lt.CoinType)
+	_ = binary.Read(buf, binary.BigEndian, &lt.Capacity)
+	_ = binary.Read(buf, binary.BigEndian, &lt.Fee)
+	_ = binary.Read(buf, binary.BigEndian, &lt.Blocks)
+	_ = binary.Read(buf, binary.BigEndian, &lt.Expires)
+	copy(lt.PKH[:], buf.Next(20))
+	var prLen uint16
+	_ = binary.Read(buf, binary.BigEndian, &prLen)
+	if buf.Len() < int(prLen) {
+		return *lt, fmt.Errorf("lease terms pay request %d bytes, %d left",
+			prLen, buf.Len())
+	}
+	lt.PayReq = string(buf.Next(int(prLen)))
+	lt.Reason = string(buf.Bytes())
+	return *lt, nil
+}
+
+func (self LeaseTermsMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.CoinType)
+	binary.Write(&buf, binary.BigEndian, self.Capacity)
+	binary.Write(&buf, binary.BigEndian, self.Fee)
+	binary.Write(&buf, binary.BigEndian, self.Blocks)
+	binary.Write(&buf, binary.BigEndian, self.Expires)
+	buf.Write(self.PKH[:])
+	pr := self.PayReq
+	if len(pr) > 65535 {
+		pr = pr[:65535]
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(pr)))
+	buf.WriteString(pr)
+	buf.WriteString(self.Reason)
+	return buf.Bytes()
+}
+
+func (self LeaseTermsMsg) Peer() uint32   { return self.PeerIdx }
+func (self LeaseTermsMsg) MsgType() uint8 { return MSGID_LIQ_TERMS }
+
+//----------
+
+// CustomMsgMax is the most data a CustomMsg carries, leaving room in a
+// 65535 byte read for the envelope
+const CustomMsgMax = 60000
+
+// CustomMsg is bytes for an application, of a type it picks.  lit only
+// passes them on.
+type CustomMsg struct {
+	PeerIdx uint32
+	Type    uint16
+	Data    []byte
+}
+
+func NewCustomMsgFromBytes(b []byte, peerid uint32) (CustomMsg, error) {
+	cm := new(CustomMsg)
+	cm.PeerIdx = peerid
+
+	if len(b) < 3 {
+		return *cm, fmt.Errorf("got %d byte custom msg, expect 3+", len(b))
+	}
+	if len(b)-3 > CustomMsgMax {
+		return *cm, fmt.Errorf("custom msg %d bytes, max %d",
+			len(b)-3, CustomMsgMax)
+	}
+	cm.Type = binary.BigEndian.Uint16(b[1:3])
+	cm.Data = append([]byte{}, b[3:]...)
+	return *cm, nil
+}
+
+func (self CustomMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.Type)
+	buf.Write(self.Data)
+	return buf.Bytes()
+}
+
+func (self CustomMsg) Peer() uint32   { return self.PeerIdx }
+func (self CustomMsg) MsgType() uint8 { return MSGID_CUSTOM }
@@ -1,10 +1,12 @@
 package lnutil
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
 )
 
 func TestChatMsg(t *testing.T) {
@@ -263,6 +265,49 @@ func TestSigProofMsg(t *testing.T) {
 	}
 }
 
+func TestFundReplaceMsgs(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+
+	var fr FundReplaceMsg
+	fr.PeerIdx = peerid
+	_, _ = rand.Read(outPoint[:])
+	fr.Outpoint = *OutPointFromBytes(outPoint)
+	_, _ = rand.Read(outPoint[:])
+	fr.NewOutpoint = *OutPointFromBytes(outPoint)
+	_, _ = rand.Read(fr.Signature[:])
+
+	for _, ack := range []bool{false, true} {
+		fr.Ack = ack
+		b := fr.Bytes()
+		msg, err := LitMsgFromBytes(b, peerid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !LitMsgEqual(fr, msg) || msg.(FundReplaceMsg).Ack != ack {
+			t.Fatalf("fund replace mismatch:\n%x\n%x\n", b, msg.Bytes())
+		}
+		_, err = LitMsgFromBytes(b[:136], peerid)
+		if err == nil {
+			t.Fatalf("Should have errored, but didn't")
+		}
+	}
+
+	fa := FundAbortMsg{PeerIdx: peerid, Outpoint: fr.Outpoint}
+	b := fa.Bytes()
+	msg, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(fa, msg) {
+		t.Fatalf("fund abort mismatch:\n%x\n%x\n", b, msg.Bytes())
+	}
+	_, err = LitMsgFromBytes(b[:36], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestCloseReqMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	var outPoint [36]byte
@@ -303,6 +348,98 @@ func TestCloseReqMsg(t *testing.T) {
 	}
 }
 
+func TestForceCloseReqMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	_, _ = rand.Read(outPoint[:])
+
+	var msg ForceCloseReqMsg
+	msg.PeerIdx = peerid
+	msg.Outpoint = *OutPointFromBytes(outPoint)
+	msg.Time = rand.Int63()
+	_, _ = rand.Read(msg.Signature[:])
+	msg.Reason = "lost my channel db"
+	b := msg.Bytes()
+
+	msg2, err := NewForceCloseReqMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	// the signature covers everything but itself
+	h := msg.SigHash()
+	msg.Signature[0]++
+	if msg.SigHash() != h {
+		t.Fatalf("sighash changed with the signature")
+	}
+	msg.Reason = "keys compromised"
+	if msg.SigHash() == h {
+		t.Fatalf("sighash didn't change with the reason")
+	}
+
+	_, err = LitMsgFromBytes(b[:108], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestForceCloseRespMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	_, _ = rand.Read(outPoint[:])
+
+	var msg ForceCloseRespMsg
+	msg.PeerIdx = peerid
+	msg.Outpoint = *OutPointFromBytes(outPoint)
+	msg.Reason = "no such channel"
+	b := msg.Bytes()
+
+	msg2, err := NewForceCloseRespMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg.Closed = true
+	msg.Reason = ""
+	_, _ = rand.Read(msg.Txid[:])
+	msg3, err := LitMsgFromBytes(msg.Bytes(), peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg3) || !msg3.(ForceCloseRespMsg).Closed {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:69], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestDeltaSigMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	var outPoint [36]byte
@@ -477,6 +614,46 @@ func TestRevMsg(t *testing.T) {
 	}
 }
 
+func TestPayClaimMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	var hash [32]byte
+
+	_, _ = rand.Read(outPoint[:])
+	_, _ = rand.Read(hash[:])
+
+	op := *OutPointFromBytes(outPoint)
+
+	msg := NewPayClaimMsg(peerid, op, hash, rand.Int63())
+	b := msg.Bytes()
+
+	msg2, err := NewPayClaimMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:76], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestWatchDescMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	cointype := rand.Uint32()
@@ -520,6 +697,78 @@ func TestWatchDescMsg(t *testing.T) {
 	}
 }
 
+func TestWatchDescMsgTaproot(t *testing.T) {
+	peerid := rand.Uint32()
+	var pkh [20]byte
+	var customerBP [33]byte
+	var adBP [33]byte
+	_, _ = rand.Read(pkh[:])
+	_, _ = rand.Read(customerBP[:])
+	_, _ = rand.Read(adBP[:])
+
+	msg := NewWatchDescMsg(peerid, rand.Uint32(), pkh, 144, 5000,
+		customerBP, adBP)
+	msg.CommitType = CommitTaprootScript
+	_, _ = rand.Read(msg.InternalKey[:])
+	msg.TapPath = make([][32]byte, 2)
+	_, _ = rand.Read(msg.TapPath[0][:])
+	_, _ = rand.Read(msg.TapPath[1][:])
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	// key path ones don't have an internal key
+	msg.CommitType = CommitTaprootKey
+	msg.TapPath = nil
+	b = msg.Bytes()
+	if len(b) != 101+2 {
+		t.Fatalf("key path descriptor %d bytes, expect 103", len(b))
+	}
+	msg2, err = LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:102], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored on a cut off tap path, but didn't")
+	}
+}
+
+func TestWatchDelMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg WatchDelMsg
+	msg.PeerIdx = peerid
+	_, _ = rand.Read(msg.DestPKH[:])
+	_, _ = rand.Read(msg.RevealPK[:])
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:53], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestComMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	var parTxid [16]byte
@@ -562,4 +811,590 @@ func TestComMsg(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Should have errored, but didn't")
 	}
+
+	// anyonecanpay sigs carry their sighash type on the end
+	msg.HashType = 0x83
+	b = msg.Bytes()
+	if len(b) != 138 {
+		t.Fatalf("com msg with hash type %d bytes, expect 138", len(b))
+	}
+	msg2, err = NewWatchStateMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg2 != msg {
+		t.Fatalf("hash type mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+}
+
+func TestTowerAdvertMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg TowerAdvertMsg
+	msg.PeerIdx = peerid
+	_, _ = rand.Read(msg.TowerPub[:])
+	msg.Time = rand.Int63()
+	msg.RewardPPM = rand.Uint32()
+	msg.Features = TowerFeatAccepting
+	msg.CoinTypes = []uint32{1, 257}
+	msg.Host = "tower.example.com:2448"
+	_, _ = rand.Read(msg.Sig[:])
+	b := msg.Bytes()
+
+	msg2, err := NewTowerAdvertMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg.Bytes(), msg2.Bytes()) || msg2.Host != msg.Host ||
+		len(msg2.CoinTypes) != 2 || msg2.SigHash() != msg.SigHash() {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestWatchReportMsgs(t *testing.T) {
+	peerid := rand.Uint32()
+	var req WatchReportReqMsg
+	req.PeerIdx = peerid
+	req.PKHs = make([][20]byte, 3)
+	for i := range req.PKHs {
+		_, _ = rand.Read(req.PKHs[i][:])
+	}
+	b := req.Bytes()
+
+	req2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(req, req2) ||
+		req2.(WatchReportReqMsg).PKHs[2] != req.PKHs[2] {
+		t.Fatalf("request mismatch:\n%x\n%x\n", b, req2.Bytes())
+	}
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+
+	var rep WatchReportMsg
+	rep.PeerIdx = peerid
+	for _, pkh := range req.PKHs {
+		rep.Entries = append(rep.Entries, WatchReportEntry{
+			PKH:       pkh,
+			Flags:     WatchReportKnown | WatchReportHasStates,
+			States:    rand.Uint64(),
+			LastState: rand.Uint64(),
+		})
+	}
+	rep.Entries[1].Flags = 0
+	b = rep.Bytes()
+	if len(b) != 3+37*3 {
+		t.Fatalf("report %d bytes, expect %d", len(b), 3+37*3)
+	}
+
+	rep2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(rep, rep2) {
+		t.Fatalf("report mismatch:\n%x\n%x\n", b, rep2.Bytes())
+	}
+	for i, e := range rep2.(WatchReportMsg).Entries {
+		if e != rep.Entries[i] {
+			t.Fatalf("entry %d: got %v, expect %v", i, e, rep.Entries[i])
+		}
+	}
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSwapOfferMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg SwapOfferMsg
+	msg.PeerIdx = peerid
+	_, _ = rand.Read(msg.Hash[:])
+	msg.OfferCoin = rand.Uint32()
+	msg.OfferAmt = rand.Int63()
+	msg.WantCoin = rand.Uint32()
+	msg.WantAmt = rand.Int63()
+	_, _ = rand.Read(msg.RefundPub[:])
+	_, _ = rand.Read(msg.ClaimPub[:])
+	msg.Locktime = rand.Uint32()
+	b := msg.Bytes()
+
+	msg2, err := NewSwapOfferMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:126], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSwapAcceptMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg SwapAcceptMsg
+	msg.PeerIdx = peerid
+	_, _ = rand.Read(msg.Hash[:])
+	_, _ = rand.Read(msg.RefundPub[:])
+	_, _ = rand.Read(msg.ClaimPub[:])
+	msg.Locktime = rand.Uint32()
+	b := msg.Bytes()
+
+	msg2, err := NewSwapAcceptMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:102], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSwapFundedMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var hash [32]byte
+	var prev [36]byte
+	_, _ = rand.Read(hash[:])
+	_, _ = rand.Read(prev[:])
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.AddTxIn(wire.NewTxIn(OutPointFromBytes(prev), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(rand.Int63(), P2WSHify(hash[:])))
+
+	msg := SwapFundedMsg{PeerIdx: peerid, Hash: hash, Tx: tx}
+	b := msg.Bytes()
+
+	msg2, err := NewSwapFundedMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+	if msg2.Tx.TxHash() != tx.TxHash() {
+		t.Fatalf("txid mismatch %s %s", msg2.Tx.TxHash(), tx.TxHash())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:40], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestFeaturesMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewFeaturesMsg(peerid, NewFeatures(FeatureSwaps+1, FeatureTower, 41))
+	b := msg.Bytes()
+
+	msg2, err := NewFeaturesMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:4], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestChanDescMsgFeatures(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	var pubKey [33]byte
+	_, _ = rand.Read(outPoint[:])
+	_, _ = rand.Read(pubKey[:])
+
+	msg := NewChanDescMsg(peerid, *OutPointFromBytes(outPoint),
+		pubKey, pubKey, pubKey, 1, 1000000, 0, pubKey, pubKey, pubKey)
+	old := msg.Bytes()
+	msg.Features = NewFeatures(19)
+	b := msg.Bytes()
+
+	// older lits just see a longer message
+	if !bytes.Equal(b[:len(old)], old) {
+		t.Fatalf("features changed the start of the message")
+	}
+
+	msg2, err := NewChanDescMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !msg2.Features.IsSet(19) {
+		t.Fatalf("lost features: %x", msg2.Features)
+	}
+
+	msg3, err := NewChanDescMsgFromBytes(old, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg3.Features) != 0 {
+		t.Fatalf("features from nothing: %x", msg3.Features)
+	}
+}
+
+func TestLiquidityAdMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg LiquidityAdMsg
+	msg.PeerIdx = peerid
+	msg.Time = rand.Int63()
+	msg.Flags = LiqFlagOpensOnRequest
+	for i := 0; i < 3; i++ {
+		msg.Coins = append(msg.Coins, LiquidityCoin{
+			CoinType: rand.Uint32(),
+			Inbound:  rand.Int63(),
+			Outbound: rand.Int63(),
+			MaxOpen:  rand.Int63(),
+		})
+	}
+	b := msg.Bytes()
+
+	msg2, err := NewLiquidityAdMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg.Bytes(), msg2.Bytes()) || msg2.Coins[2] != msg.Coins[2] {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestOpenChanReqMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg OpenChanReqMsg
+	msg.PeerIdx = peerid
+	msg.CoinType = rand.Uint32()
+	msg.Capacity = rand.Int63()
+	b := msg.Bytes()
+
+	msg2, err := NewOpenChanReqMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:12], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestOpenChanRespMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg OpenChanRespMsg
+	msg.PeerIdx = peerid
+	msg.CoinType = rand.Uint32()
+	msg.Capacity = rand.Int63()
+	msg.Reason = "not opening channels"
+	b := msg.Bytes()
+
+	msg2, err := NewOpenChanRespMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg.Accepted = true
+	msg.Reason = ""
+	msg3, err := LitMsgFromBytes(msg.Bytes(), peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg3) || !msg3.(OpenChanRespMsg).Accepted {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:13], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestLeaseOffersMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg LeaseOffersMsg
+	msg.PeerIdx = peerid
+	for i := 0; i < 3; i++ {
+		msg.Tiers = append(msg.Tiers, LeaseTier{
+			CoinType: rand.Uint32(),
+			Capacity: rand.Int63(),
+			FeePPM:   rand.Uint32(),
+			Blocks:   rand.Uint32(),
+		})
+	}
+	b := msg.Bytes()
+
+	msg2, err := NewLeaseOffersMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg.Bytes(), msg2.Bytes()) || msg2.Tiers[1] != msg.Tiers[1] {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestLeaseTierFee(t *testing.T) {
+	tier := LeaseTier{Capacity: 5000000, FeePPM: 2500}
+	if tier.Fee() != 12500 {
+		t.Fatalf("fee %d, expect 12500", tier.Fee())
+	}
+}
+
+func TestLeaseReqMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg LeaseReqMsg
+	msg.PeerIdx = peerid
+	msg.CoinType = rand.Uint32()
+	msg.Capacity = rand.Int63()
+	b := msg.Bytes()
+
+	msg2, err := NewLeaseReqMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:12], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestLeaseTermsMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var msg LeaseTermsMsg
+	msg.PeerIdx = peerid
+	msg.CoinType = rand.Uint32()
+	msg.Capacity = rand.Int63()
+	msg.Fee = rand.Int63()
+	msg.Blocks = rand.Uint32()
+	msg.Expires = rand.Int63()
+	_, _ = rand.Read(msg.PKH[:])
+	msg.PayReq = "ln1abc:00ff:12500:1500000000:00"
+	b := msg.Bytes()
+
+	msg2, err := NewLeaseTermsMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != msg2 {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	// a refusal has no pay request, just the reason
+	msg.PayReq = ""
+	msg.Reason = "no tier of that size"
+	msg3, err := LitMsgFromBytes(msg.Bytes(), peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg3) || msg3.(LeaseTermsMsg).Reason != msg.Reason {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:54], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestCustomMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var cm CustomMsg
+	cm.PeerIdx = peerid
+	cm.Type = 0x8001
+	cm.Data = make([]byte, 100)
+	_, _ = rand.Read(cm.Data)
+	b := cm.Bytes()
+
+	cm2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(cm, cm2) || cm2.(CustomMsg).Type != cm.Type {
+		t.Fatalf("custom msg mismatch:\n%x\n%x\n", b, cm2.Bytes())
+	}
+
+	// no data is fine
+	cm.Data = nil
+	cm2, err = LitMsgFromBytes(cm.Bytes(), peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm2.(CustomMsg).Data) != 0 {
+		t.Fatalf("empty custom msg has %d bytes", len(cm2.(CustomMsg).Data))
+	}
+	_, err = LitMsgFromBytes(b[:2], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+	cm.Data = make([]byte, CustomMsgMax+1)
+	_, err = LitMsgFromBytes(cm.Bytes(), peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
 }
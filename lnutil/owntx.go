@@ -0,0 +1,47 @@
+package lnutil
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+// BroadcastState is where a tx the wallet sent is on its way to a block
+type BroadcastState uint8
+
+const (
+	BroadcastPending     BroadcastState = iota // sent, not in a block yet
+	BroadcastConfirmed                         // in a block, not deep yet
+	BroadcastEvicted                           // seems dropped from mempools
+	BroadcastDoubleSpent                       // a conflicting tx is in a block
+)
+
+func (s BroadcastState) String() string {
+	switch s {
+	case BroadcastPending:
+		return "pending"
+	case BroadcastConfirmed:
+		return "confirmed"
+	case BroadcastEvicted:
+		return "evicted"
+	case BroadcastDoubleSpent:
+		return "double-spent"
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+// OwnTx is a tx the wallet sent, which it keeps announcing until it's in a
+// block
+type OwnTx struct {
+	Txid      chainhash.Hash
+	State     BroadcastState
+	FirstSent int64  // unix time
+	LastSent  int64  // unix time
+	Sends     uint32 // times announced
+	// rebroadcasts in a row peers asked for it again, so didn't have it
+	Misses uint32
+	// block it or the tx that double spent it is in; 0 for none
+	Height int32
+	// the tx that double spent it, if one did
+	Conflict chainhash.Hash
+}
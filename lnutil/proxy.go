@@ -0,0 +1,272 @@
+package lnutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Outgoing connections can go through a SOCKS5 proxy, like tor's.  Dial and
+the HTTP clients from HTTPClient connect through it when one's set, and
+hand it host names as they are, so it does the DNS lookups, not us.
+
+In strict mode nothing goes out any other way.  A connection that would
+go direct (no proxy, a kind of connection the proxy can't carry, the proxy
+being down) is refused and logged, never tried on the clearnet.  Without
+strict mode, when the proxy can't be reached, connections go direct with
+a warning.
+
+Loopback IPs, localhost and unix sockets always connect directly; they
+don't leave the machine.  Anything dialing with net.Dial instead of here
+isn't covered, so everything lit dials out with should come through here.
+*/
+
+var proxyLogger = NewSubLogger("proxy")
+
+// ErrProxyBypass is what a connection that would go around the proxy in
+// strict mode gets
+var ErrProxyBypass = errors.New("would bypass the proxy; blocked by strict proxy mode")
+
+var (
+	proxyAddr   string
+	proxyStrict bool
+	proxyMtx    sync.RWMutex
+)
+
+// how long HTTPClient connections wait to connect, proxy and all
+const proxyDialTimeout = 30 * time.Second
+
+// SetProxy sets the SOCKS5 proxy, host:port, to connect through, or none
+// with "".  strict blocks anything not through it.
+func SetProxy(addr string, strict bool) error {
+	if addr != "" {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("proxy %s: %s", addr, err.Error())
+		}
+		_, err = strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("proxy %s: bad port %s", addr, port)
+		}
+	} else if strict {
+		return fmt.Errorf("strict proxy mode needs a proxy")
+	}
+	proxyMtx.Lock()
+	proxyAddr, proxyStrict = addr, strict
+	proxyMtx.Unlock()
+	return nil
+}
+
+// Proxy returns the proxy and if it's strict; "" if there's none
+func Proxy() (string, bool) {
+	proxyMtx.RLock()
+	defer proxyMtx.RUnlock()
+	return proxyAddr, proxyStrict
+}
+
+// Dial connects like net.Dial, through the proxy if there is one
+func Dial(network, addr string) (net.Conn, error) {
+	return DialContext(context.Background(), network, addr)
+}
+
+// DialTimeout connects like net.DialTimeout, through the proxy if there is
+// one.  The timeout covers the proxy's handshake too.
+func DialTimeout(
+	network, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return Dial(network, addr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return DialContext(ctx, network, addr)
+}
+
+// DialContext connects like net.Dialer's DialContext, through the proxy if
+// there is one
+func DialContext(
+	ctx context.Context, network, addr string) (net.Conn, error) {
+
+	var d net.Dialer
+	if isLocalAddr(network, addr) {
+		return d.DialContext(ctx, network, addr)
+	}
+	proxy, strict := Proxy()
+	if proxy != "" {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			conn, err := d.DialContext(ctx, "tcp", proxy)
+			if err == nil {
+				err = socksConnect(ctx, conn, addr)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return conn, nil
+			}
+			if strict {
+				proxyLogger.Errorf("can't reach proxy %s for %s: %s; "+
+					"not connecting directly\n", proxy, addr, err.Error())
+				return nil, err
+			}
+			proxyLogger.Warnf("can't reach proxy %s for %s: %s; "+
+				"connecting directly\n", proxy, addr, err.Error())
+		}
+	}
+	if strict {
+		proxyLogger.Errorf("blocked %s connection to %s\n", network, addr)
+		return nil, fmt.Errorf("%s %s: %s", network, addr, ErrProxyBypass)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// HTTPClient is an http.Client that connects with DialContext.  It
+// ignores the HTTP_PROXY environment variables; all the proxying is ours.
+func HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: proxyTransport}
+}
+
+// proxyTransport is shared, so HTTPClients can reuse connections.  It
+// only looks at the proxy when connecting, so clients made before
+// SetProxy use it too.
+var proxyTransport = &http.Transport{
+	DialContext: func(
+		ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+		defer cancel()
+		return DialContext(ctx, network, addr)
+	},
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// isLocalAddr says if a connection stays on this machine, without any DNS
+// lookups to find out
+func isLocalAddr(network, addr string) bool {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// socks5 reply codes, RFC 1928
+var socksErrs = []string{
+	1: "general failure",
+	2: "not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksConnect asks the SOCKS5 proxy on conn to connect to addr.  Host
+// names go to the proxy to look up.
+func socksConnect(ctx context.Context, conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("%s: bad port %s", addr, portStr)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	// version 5, 1 method: no auth
+	_, err = conn.Write([]byte{5, 1, 0})
+	if err != nil {
+		return err
+	}
+	var reply [2]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		return fmt.Errorf("proxy: %s", err.Error())
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return fmt.Errorf("proxy wants auth method %d; only none's supported",
+			reply[1])
+	}
+
+	// version 5, CONNECT, reserved, address, port
+	req := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		req = append(req, 1)
+		req = append(req, ip.To4()...)
+	case ip != nil:
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	default:
+		if len(host) == 0 || len(host) > 255 {
+			return fmt.Errorf("%s: bad host name", addr)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	// version, reply, reserved, bound address type, address, port
+	var hdr [4]byte
+	_, err = io.ReadFull(conn, hdr[:])
+	if err != nil {
+		return fmt.Errorf("proxy: %s", err.Error())
+	}
+	if hdr[0] != 5 {
+		return fmt.Errorf("proxy replied version %d, expect 5", hdr[0])
+	}
+	if hdr[1] != 0 {
+		why := fmt.Sprintf("error %d", hdr[1])
+		if int(hdr[1]) < len(socksErrs) {
+			why = socksErrs[hdr[1]]
+		}
+		return fmt.Errorf("proxy can't connect to %s: %s", addr, why)
+	}
+	var skip int
+	switch hdr[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		var n [1]byte
+		_, err = io.ReadFull(conn, n[:])
+		if err != nil {
+			return fmt.Errorf("proxy: %s", err.Error())
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("proxy replied address type %d", hdr[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	if err != nil {
+		return fmt.Errorf("proxy: %s", err.Error())
+	}
+	return nil
+}
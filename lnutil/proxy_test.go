@@ -0,0 +1,108 @@
+package lnutil
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeSocks accepts one SOCKS5 CONNECT, sends back what it asked for, and
+// echoes whatever comes after
+func fakeSocks(t *testing.T) (string, chan []byte) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked := make(chan []byte, 1)
+	go func() {
+		defer lis.Close()
+		c, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		greet := make([]byte, 3)
+		io.ReadFull(c, greet)
+		c.Write([]byte{5, 0})
+		hdr := make([]byte, 5)
+		io.ReadFull(c, hdr)
+		rest := make([]byte, int(hdr[4])+2)
+		io.ReadFull(c, rest)
+		asked <- append(hdr, rest...)
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		io.Copy(c, c)
+	}()
+	return lis.Addr().String(), asked
+}
+
+func TestProxyDial(t *testing.T) {
+	defer SetProxy("", false)
+	proxy, asked := fakeSocks(t)
+	err := SetProxy(proxy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := DialTimeout("tcp", "example.onion:2448", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the name goes to the proxy as it is, not looked up
+	expect := append([]byte{5, 1, 0, 3, 13}, "example.onion"...)
+	expect = append(expect, 0x09, 0x90)
+	got := <-asked
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("proxy asked %x, expect %x", got, expect)
+	}
+	conn.Write([]byte("hi"))
+	back := make([]byte, 2)
+	_, err = io.ReadFull(conn, back)
+	if err != nil || string(back) != "hi" {
+		t.Fatalf("got %q, %v back through proxy", back, err)
+	}
+}
+
+func TestProxyStrict(t *testing.T) {
+	defer SetProxy("", false)
+	err := SetProxy("", true)
+	if err == nil {
+		t.Fatalf("Should have errored on strict with no proxy, but didn't")
+	}
+
+	// nothing listening there
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := lis.Addr().String()
+	lis.Close()
+	err = SetProxy(down, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no proxy to go through; mustn't go direct (or look the name up)
+	_, err = DialTimeout("tcp", "example.com:80", time.Second)
+	if err == nil {
+		t.Fatalf("Should have errored with the proxy down, but didn't")
+	}
+	_, err = Dial("udp", "192.0.2.1:53")
+	if err == nil {
+		t.Fatalf("Should have blocked udp, but didn't")
+	}
+
+	// loopback's still fine
+	lis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	conn, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
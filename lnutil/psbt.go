@@ -0,0 +1,170 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+Just enough of BIP 174 partially signed bitcoin transactions to get the
+transaction out of one that some other wallet made.  We don't sign or
+update PSBTs, only read the unsigned tx and any finalized inputs.
+*/
+
+// psbt key types we care about
+const (
+	psbtGlobalUnsignedTx   = 0x00
+	psbtInFinalScriptSig   = 0x07
+	psbtInFinalWitness     = 0x08
+	psbtMaxVarBytes        = 4000000 // bigger than any block
+	psbtMaxWitnessElements = 10000
+)
+
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff} // "psbt" 0xff
+
+// psbtKV is one key-value pair in a psbt map
+type psbtKV struct {
+	Key   []byte
+	Value []byte
+}
+
+// readPsbtMap reads key-value pairs up to the 0 length key that ends a map
+func readPsbtMap(r *bytes.Reader) ([]psbtKV, error) {
+	var kvs []psbtKV
+	for {
+		key, err := wire.ReadVarBytes(r, 0, psbtMaxVarBytes, "psbt key")
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return kvs, nil
+		}
+		val, err := wire.ReadVarBytes(r, 0, psbtMaxVarBytes, "psbt value")
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, psbtKV{key, val})
+	}
+}
+
+// TxFromPsbt gets the transaction out of a serialized psbt.  Inputs which
+// the psbt has finalized get their signature script and witness filled in;
+// complete is true if every input was finalized, so the tx can be broadcast.
+func TxFromPsbt(b []byte) (tx *wire.MsgTx, complete bool, err error) {
+	if !bytes.HasPrefix(b, psbtMagic) {
+		return nil, false, fmt.Errorf("not a psbt")
+	}
+	r := bytes.NewReader(b[len(psbtMagic):])
+
+	global, err := readPsbtMap(r)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, kv := range global {
+		if len(kv.Key) == 1 && kv.Key[0] == psbtGlobalUnsignedTx {
+			tx = wire.NewMsgTx()
+			err = tx.Deserialize(bytes.NewReader(kv.Value))
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	if tx == nil {
+		return nil, false, fmt.Errorf("psbt has no unsigned tx")
+	}
+
+	complete = true
+	for i, in := range tx.TxIn {
+		if len(in.SignatureScript) != 0 || len(in.Witness) != 0 {
+			return nil, false, fmt.Errorf("psbt unsigned tx input %d is signed", i)
+		}
+		kvs, err := readPsbtMap(r)
+		if err != nil {
+			return nil, false, fmt.Errorf("psbt input %d: %s", i, err.Error())
+		}
+		final := false
+		for _, kv := range kvs {
+			if len(kv.Key) != 1 {
+				continue
+			}
+			switch kv.Key[0] {
+			case psbtInFinalScriptSig:
+				in.SignatureScript = kv.Value
+				final = true
+			case psbtInFinalWitness:
+				in.Witness, err = readPsbtWitness(kv.Value)
+				if err != nil {
+					return nil, false, fmt.Errorf(
+						"psbt input %d witness: %s", i, err.Error())
+				}
+				final = true
+			}
+		}
+		if !final {
+			complete = false
+		}
+	}
+	// don't need anything from the output maps, but they should be there
+	for i := range tx.TxOut {
+		_, err := readPsbtMap(r)
+		if err != nil {
+			return nil, false, fmt.Errorf("psbt output %d: %s", i, err.Error())
+		}
+	}
+	return tx, complete, nil
+}
+
+// readPsbtWitness deserializes a finalized witness stack
+func readPsbtWitness(b []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(b)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > psbtMaxWitnessElements {
+		return nil, fmt.Errorf("%d witness elements", n)
+	}
+	wit := make(wire.TxWitness, n)
+	for i := range wit {
+		wit[i], err = wire.ReadVarBytes(r, 0, psbtMaxVarBytes, "witness item")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return wit, nil
+}
+
+// ParseExternalTx reads a transaction given as a base64 or hex psbt, or a
+// hex raw transaction (which counts as complete if every input has a
+// signature script or witness).
+func ParseExternalTx(s string) (*wire.MsgTx, bool, error) {
+	s = strings.TrimSpace(s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		b, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, false, fmt.Errorf("tx is neither hex nor base64")
+		}
+	}
+	if bytes.HasPrefix(b, psbtMagic) {
+		return TxFromPsbt(b)
+	}
+
+	tx := wire.NewMsgTx()
+	err = tx.Deserialize(bytes.NewReader(b))
+	if err != nil {
+		return nil, false, err
+	}
+	complete := true
+	for _, in := range tx.TxIn {
+		if len(in.SignatureScript) == 0 && len(in.Witness) == 0 {
+			complete = false
+		}
+	}
+	return tx, complete, nil
+}
@@ -0,0 +1,127 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+// testPsbtTx is a 1 in 1 out tx, unsigned
+func testPsbtTx() *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	var h chainhash.Hash
+	h[0] = 0x11
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&h, 3), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1234567, P2WSHify([]byte{0x51})))
+	return tx
+}
+
+// makePsbt serializes a psbt for tx; if wit is non-nil the input is
+// finalized with it
+func makePsbt(t *testing.T, tx *wire.MsgTx, wit wire.TxWitness) []byte {
+	var buf bytes.Buffer
+	buf.Write(psbtMagic)
+
+	var txb bytes.Buffer
+	err := tx.Serialize(&txb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire.WriteVarBytes(&buf, 0, []byte{psbtGlobalUnsignedTx})
+	wire.WriteVarBytes(&buf, 0, txb.Bytes())
+	buf.WriteByte(0x00)
+
+	// input map
+	if wit != nil {
+		var wb bytes.Buffer
+		wire.WriteVarInt(&wb, 0, uint64(len(wit)))
+		for _, w := range wit {
+			wire.WriteVarBytes(&wb, 0, w)
+		}
+		wire.WriteVarBytes(&buf, 0, []byte{psbtInFinalWitness})
+		wire.WriteVarBytes(&buf, 0, wb.Bytes())
+	}
+	buf.WriteByte(0x00)
+
+	// output map
+	buf.WriteByte(0x00)
+	return buf.Bytes()
+}
+
+func TestTxFromPsbt(t *testing.T) {
+	tx := testPsbtTx()
+
+	got, complete, err := TxFromPsbt(makePsbt(t, tx, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Fatalf("unsigned psbt says complete")
+	}
+	if got.TxHash() != tx.TxHash() {
+		t.Fatalf("txid %s, expect %s", got.TxHash(), tx.TxHash())
+	}
+
+	wit := wire.TxWitness{{0x30, 0x01}, {0x02, 0x03}}
+	got, complete, err = TxFromPsbt(makePsbt(t, tx, wit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Fatalf("finalized psbt says incomplete")
+	}
+	if got.TxHash() != tx.TxHash() {
+		t.Fatalf("signing changed txid")
+	}
+	if len(got.TxIn[0].Witness) != 2 ||
+		!bytes.Equal(got.TxIn[0].Witness[1], wit[1]) {
+		t.Fatalf("witness %x, expect %x", got.TxIn[0].Witness, wit)
+	}
+
+	_, _, err = TxFromPsbt([]byte{0x01, 0x02})
+	if err == nil {
+		t.Fatalf("no error on garbage")
+	}
+	// cut off the output map
+	b := makePsbt(t, tx, nil)
+	_, _, err = TxFromPsbt(b[:len(b)-1])
+	if err == nil {
+		t.Fatalf("no error on truncated psbt")
+	}
+}
+
+func TestParseExternalTx(t *testing.T) {
+	tx := testPsbtTx()
+	p := makePsbt(t, tx, nil)
+
+	for _, s := range []string{
+		hex.EncodeToString(p), base64.StdEncoding.EncodeToString(p)} {
+		got, _, err := ParseExternalTx(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TxHash() != tx.TxHash() {
+			t.Fatalf("txid %s, expect %s", got.TxHash(), tx.TxHash())
+		}
+	}
+
+	var txb bytes.Buffer
+	tx.Serialize(&txb)
+	got, complete, err := ParseExternalTx(hex.EncodeToString(txb.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete || got.TxHash() != tx.TxHash() {
+		t.Fatalf("raw tx: complete %v txid %s", complete, got.TxHash())
+	}
+
+	_, _, err = ParseExternalTx("not a tx!")
+	if err == nil {
+		t.Fatalf("no error on garbage")
+	}
+}
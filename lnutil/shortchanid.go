@@ -0,0 +1,66 @@
+package lnutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ShortChanID identifies a channel by where its funding output is on
+// chain: block height (3 bytes), the tx's index in the block (3 bytes) and
+// the output index (2 bytes), packed the same way as in BOLT 7, so other
+// nodes and tools can find it.  It's written block:tx:output.  0 means the
+// channel isn't confirmed, or was confirmed before these were recorded.
+type ShortChanID uint64
+
+// NewShortChanID packs a funding output's position into a ShortChanID
+func NewShortChanID(block int32, tx uint32, out uint32) (ShortChanID, error) {
+	if block < 1 || block > 0xffffff {
+		return 0, fmt.Errorf("block %d out of range", block)
+	}
+	if tx > 0xffffff {
+		return 0, fmt.Errorf("tx index %d out of range", tx)
+	}
+	if out > 0xffff {
+		return 0, fmt.Errorf("output %d out of range", out)
+	}
+	return ShortChanID(uint64(block)<<40 | uint64(tx)<<16 | uint64(out)), nil
+}
+
+// Block is the height of the block the funding tx is in
+func (s ShortChanID) Block() int32 { return int32(s >> 40) }
+
+// TxIdx is the funding tx's index in its block
+func (s ShortChanID) TxIdx() uint32 { return uint32(s>>16) & 0xffffff }
+
+// Output is the funding output's index in the tx
+func (s ShortChanID) Output() uint32 { return uint32(s) & 0xffff }
+
+func (s ShortChanID) String() string {
+	return fmt.Sprintf("%d:%d:%d", s.Block(), s.TxIdx(), s.Output())
+}
+
+// ParseShortChanID reads block:tx:output.  The blockxtxxoutput form other
+// implementations print is also taken.
+func ParseShortChanID(str string) (ShortChanID, error) {
+	sep := ":"
+	if !strings.Contains(str, sep) {
+		sep = "x"
+	}
+	parts := strings.Split(str, sep)
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("short channel id %q should be block:tx:output", str)
+	}
+	var n [3]uint64
+	for i, p := range parts {
+		var err error
+		n[i], err = strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("short channel id %q: %s", str, err.Error())
+		}
+	}
+	if n[0] > 0xffffff {
+		return 0, fmt.Errorf("block %d out of range", n[0])
+	}
+	return NewShortChanID(int32(n[0]), uint32(n[1]), uint32(n[2]))
+}
@@ -0,0 +1,40 @@
+package lnutil
+
+import (
+	"testing"
+)
+
+func TestShortChanID(t *testing.T) {
+	s, err := NewShortChanID(539268, 845, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// same packing as BOLT 7
+	if uint64(s) != 592931436542885889 {
+		t.Fatalf("got %d", uint64(s))
+	}
+	if s.Block() != 539268 || s.TxIdx() != 845 || s.Output() != 1 {
+		t.Fatalf("got %d %d %d", s.Block(), s.TxIdx(), s.Output())
+	}
+	if s.String() != "539268:845:1" {
+		t.Fatalf("got %s", s.String())
+	}
+
+	for _, str := range []string{"539268:845:1", "539268x845x1"} {
+		p, err := ParseShortChanID(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != s {
+			t.Fatalf("%s parsed to %s", str, p.String())
+		}
+	}
+
+	for _, bad := range []string{"", "1:2", "a:1:1", "0:1:1", "16777216:1:1",
+		"1:16777216:1", "1:1:65536", "1:1:1:1"} {
+		_, err = ParseShortChanID(bad)
+		if err == nil {
+			t.Fatalf("%q should have errored", bad)
+		}
+	}
+}
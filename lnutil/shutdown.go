@@ -0,0 +1,77 @@
+package lnutil
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+An upfront shutdown script is where a party's money goes on a cooperative
+close, fixed when the channel opens.  Each side sends its own in the
+ChanDesc or ChanAck, and both build the close tx paying to them, so a
+node that's been broken into can't get its peer to sign a close paying
+somewhere else: the peer's copy of the script is what counts.  Force
+closes still pay to the channel's refund key, since the other side can't
+sign for those.
+
+The script goes after the features on the end of the ChanDesc and ChanAck,
+with a 2 byte length of its own.  Lits that don't know it stop reading at
+the features; the feature bit says the other side does know it, and a
+script is only kept if both have the bit.
+*/
+
+// ChanTail serializes the optional end of ChanDesc and ChanAck: features,
+// then a shutdown script.  The features length is written even if there
+// are none, when there's a script after it.
+func ChanTail(f Features, shutdown []byte) []byte {
+	b := featuresTail(f)
+	if len(shutdown) == 0 {
+		return b
+	}
+	if b == nil {
+		b = []byte{0, 0}
+	}
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(shutdown)))
+	b = append(b, n[:]...)
+	return append(b, shutdown...)
+}
+
+// ChanTailFromBytes reads what ChanTail wrote
+func ChanTailFromBytes(b []byte) (Features, []byte, error) {
+	f, err := featuresFromTail(b)
+	if err != nil || len(b) == 0 {
+		return f, nil, err
+	}
+	b = b[2+int(binary.BigEndian.Uint16(b[:2])):]
+	if len(b) == 0 {
+		return f, nil, nil
+	}
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("shutdown script: %d bytes", len(b))
+	}
+	n := int(binary.BigEndian.Uint16(b[:2]))
+	if len(b)-2 < n {
+		return nil, nil, fmt.Errorf("shutdown script: %d bytes, %d given",
+			len(b)-2, n)
+	}
+	shutdown := make([]byte, n)
+	copy(shutdown, b[2:2+n])
+	return f, shutdown, nil
+}
+
+// CheckShutdownScript makes sure a shutdown script is one of the standard
+// output types, so a close paying to it will relay: p2pkh, p2sh, p2wpkh
+// or p2wsh.
+func CheckShutdownScript(s []byte) error {
+	switch {
+	case len(s) == 25 && s[0] == 0x76 && s[1] == 0xa9 && s[2] == 20 &&
+		s[23] == 0x88 && s[24] == 0xac: // p2pkh
+	case len(s) == 23 && s[0] == 0xa9 && s[1] == 20 && s[22] == 0x87: // p2sh
+	case len(s) == 22 && s[0] == 0 && s[1] == 20: // p2wpkh
+	case len(s) == 34 && s[0] == 0 && s[1] == 32: // p2wsh
+	default:
+		return fmt.Errorf("shutdown script %x isn't a standard output", s)
+	}
+	return nil
+}
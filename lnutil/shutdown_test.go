@@ -0,0 +1,73 @@
+package lnutil
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestChanTail(t *testing.T) {
+	script := DirectWPKHScriptFromPKH([20]byte{1, 2, 3})
+	for _, f := range []Features{nil, NewFeatures(FeatureUpfrontShutdown + 1)} {
+		gotF, gotS, err := ChanTailFromBytes(ChanTail(f, script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotF.trimmed(), f.trimmed()) || !bytes.Equal(gotS, script) {
+			t.Fatalf("got %x %x, expect %x %x", gotF, gotS, f, script)
+		}
+	}
+	// no script is just the features, as before
+	f := NewFeatures(3)
+	if !bytes.Equal(ChanTail(f, nil), featuresTail(f)) {
+		t.Fatalf("tail without script changed")
+	}
+	_, s, err := ChanTailFromBytes(featuresTail(f))
+	if err != nil || s != nil {
+		t.Fatalf("got script %x err %v from features only", s, err)
+	}
+	_, _, err = ChanTailFromBytes([]byte{0, 0, 0, 9, 1})
+	if err == nil {
+		t.Fatalf("short script should error")
+	}
+}
+
+func TestChanAckMsgShutdown(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	_, _ = rand.Read(outPoint[:])
+	var sig [64]byte
+	var elk [33]byte
+	msg := NewChanAckMsg(peerid, *OutPointFromBytes(outPoint), elk, elk, elk, sig)
+	msg.Features = NewFeatures(FeatureUpfrontShutdown + 1)
+	msg.ShutdownScript = P2WSHify([]byte{0x51})
+
+	msg2, err := NewChanAckMsgFromBytes(msg.Bytes(), peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg2.Features.Has(FeatureUpfrontShutdown) ||
+		!bytes.Equal(msg2.ShutdownScript, msg.ShutdownScript) {
+		t.Fatalf("got %x %x", msg2.Features, msg2.ShutdownScript)
+	}
+}
+
+func TestCheckShutdownScript(t *testing.T) {
+	good := [][]byte{
+		DirectWPKHScriptFromPKH([20]byte{}),
+		P2WSHify([]byte{0x51}),
+		append(append([]byte{0x76, 0xa9, 20}, make([]byte, 20)...), 0x88, 0xac),
+		append(append([]byte{0xa9, 20}, make([]byte, 20)...), 0x87),
+	}
+	for _, s := range good {
+		if err := CheckShutdownScript(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := [][]byte{nil, {0x51}, {0x6a, 1, 2}, make([]byte, 22)}
+	for _, s := range bad {
+		if CheckShutdownScript(s) == nil {
+			t.Fatalf("%x should be refused", s)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package lnutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+Taproot (BIP 341) outputs commit to an internal key and a tree of scripts.
+They can be spent with a sig from the internal key tweaked by the tree's
+root (the key path), or by showing one of the scripts with the path from
+it up to the root (the script path).  Keys are 32 byte x coordinates; the
+y is whichever's even, except for the output key, whose parity goes in the
+control block.
+
+Channels don't make taproot commitments yet, but watchtowers have to be
+able to grab them once they do, so the commitment's to-self output is laid
+out here.  Both kinds have a leaf paying the timeout key after the delay.
+The revocation key is either the internal key (CommitTaprootKey) or in a
+leaf of its own next to the delay one, with the internal key given in the
+channel's watch descriptor (CommitTaprootScript).  Any other leaves in the
+tree show up as extra hashes on the path above those.
+*/
+
+// commitment output types, for WatchDescMsg
+const (
+	CommitLegacy        = 0 // P2WSH of CommitScript
+	CommitTaprootKey    = 1 // revocable by key path
+	CommitTaprootScript = 2 // revocable by script path
+)
+
+// TapLeafVersion is the leaf version for tapscript (BIP 342)
+const TapLeafVersion = 0xc0
+
+// TaggedHash is BIP 340's sha256(sha256(tag) || sha256(tag) || msgs...)
+func TaggedHash(tag string, msgs ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// TapLeafHash is the hash of a tapscript leaf
+func TapLeafHash(script []byte) [32]byte {
+	var buf bytes.Buffer
+	buf.WriteByte(TapLeafVersion)
+	wire.WriteVarBytes(&buf, 0, script)
+	return TaggedHash("TapLeaf", buf.Bytes())
+}
+
+// TapBranchHash joins two nodes of a script tree, the smaller one first
+func TapBranchHash(a, b [32]byte) [32]byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return TaggedHash("TapBranch", a[:], b[:])
+}
+
+// TapRoot is the root of a script tree, from a leaf hash and the hashes on
+// the path up from it
+func TapRoot(leaf [32]byte, path [][32]byte) [32]byte {
+	node := leaf
+	for _, h := range path {
+		node = TapBranchHash(node, h)
+	}
+	return node
+}
+
+// XOnly drops a compressed pubkey's parity byte
+func XOnly(pub [33]byte) [32]byte {
+	var x [32]byte
+	copy(x[:], pub[1:])
+	return x
+}
+
+// TaprootOutputKey tweaks an internal key by a script tree's root, which can
+// be nil for no scripts.  Returns the output key, and if its y is odd.
+func TaprootOutputKey(internal [32]byte, root []byte) ([32]byte, bool, error) {
+	var out [32]byte
+	curve := btcec.S256()
+	// the internal key's the point with this x and an even y
+	p, err := btcec.ParsePubKey(append([]byte{0x02}, internal[:]...), curve)
+	if err != nil {
+		return out, false, fmt.Errorf("internal key %x: %s", internal, err.Error())
+	}
+	tweak := TaggedHash("TapTweak", internal[:], root)
+	if new(big.Int).SetBytes(tweak[:]).Cmp(curve.N) >= 0 {
+		return out, false, fmt.Errorf("taproot tweak %x out of range", tweak)
+	}
+	tx, ty := curve.ScalarBaseMult(tweak[:])
+	qx, qy := curve.Add(p.X, p.Y, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return out, false, fmt.Errorf("taproot output key is infinity")
+	}
+	xb := qx.Bytes()
+	copy(out[32-len(xb):], xb)
+	return out, qy.Bit(0) == 1, nil
+}
+
+// P2TRScript is the output script paying to a taproot output key
+func P2TRScript(key [32]byte) []byte {
+	return append([]byte{txscript.OP_1, txscript.OP_DATA_32}, key[:]...)
+}
+
+// TapControlBlock is what a script path spend shows after the script: the
+// leaf version and output key parity, the internal key, and the path up to
+// the root
+func TapControlBlock(internal [32]byte, oddY bool, path [][32]byte) []byte {
+	cb := make([]byte, 0, 33+32*len(path))
+	if oddY {
+		cb = append(cb, TapLeafVersion|1)
+	} else {
+		cb = append(cb, TapLeafVersion)
+	}
+	cb = append(cb, internal[:]...)
+	for _, h := range path {
+		cb = append(cb, h[:]...)
+	}
+	return cb
+}
+
+// TapRevokeScript is a taproot commitment's revocation leaf
+func TapRevokeScript(revKey [32]byte) []byte {
+	builder := txscript.NewScriptBuilder()
+	builder.AddData(revKey[:])
+	builder.AddOp(txscript.OP_CHECKSIG)
+	s, _ := builder.Script()
+	return s
+}
+
+// TapDelayScript is a taproot commitment's timeout leaf
+func TapDelayScript(timeoutKey [32]byte, delay uint16) []byte {
+	builder := txscript.NewScriptBuilder()
+	builder.AddData(timeoutKey[:])
+	builder.AddOp(txscript.OP_CHECKSIGVERIFY)
+	builder.AddInt64(int64(delay))
+	builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+	s, _ := builder.Script()
+	return s
+}
+
+// TapCommit is a taproot commitment's to-self output script, and how to
+// spend it with the revocation key: the script and control block for
+// CommitTaprootScript, nothing (it's a key path spend) for CommitTaprootKey.
+// internal is only used by CommitTaprootScript; path is any hashes above
+// the revocation and delay leaves.
+func TapCommit(commitType uint8, revKey, timeoutKey [33]byte, delay uint16,
+	internal [32]byte, path [][32]byte) (pkScript, script, control []byte, err error) {
+
+	delayLeaf := TapLeafHash(TapDelayScript(XOnly(timeoutKey), delay))
+	switch commitType {
+	case CommitTaprootKey:
+		root := TapRoot(delayLeaf, path)
+		outKey, _, err := TaprootOutputKey(XOnly(revKey), root[:])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return P2TRScript(outKey), nil, nil, nil
+
+	case CommitTaprootScript:
+		script = TapRevokeScript(XOnly(revKey))
+		revPath := append([][32]byte{delayLeaf}, path...)
+		root := TapRoot(TapLeafHash(script), revPath)
+		outKey, oddY, err := TaprootOutputKey(internal, root[:])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		control = TapControlBlock(internal, oddY, revPath)
+		return P2TRScript(outKey), script, control, nil
+	}
+	return nil, nil, nil, fmt.Errorf("commitment type %d isn't taproot", commitType)
+}
@@ -0,0 +1,109 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func h32(t *testing.T, s string) [32]byte {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("bad hex %s", s)
+	}
+	copy(h[:], b)
+	return h
+}
+
+// vectors from BIP 341's wallet test vectors
+func TestTaprootOutputKey(t *testing.T) {
+	// no scripts
+	internal := h32(t,
+		"d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d")
+	out, _, err := TaprootOutputKey(internal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := h32(t,
+		"53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343")
+	if out != want {
+		t.Fatalf("output key %x, expect %x", out, want)
+	}
+
+	// one leaf
+	internal = h32(t,
+		"187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	script, _ := hex.DecodeString(
+		"20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac")
+	leaf := TapLeafHash(script)
+	want = h32(t,
+		"5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21")
+	if leaf != want {
+		t.Fatalf("leaf hash %x, expect %x", leaf, want)
+	}
+	root := TapRoot(leaf, nil)
+	out, oddY, err := TaprootOutputKey(internal, root[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = h32(t,
+		"147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3")
+	if out != want {
+		t.Fatalf("output key %x, expect %x", out, want)
+	}
+	cb := TapControlBlock(internal, oddY, nil)
+	wantCB, _ := hex.DecodeString(
+		"c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	if !bytes.Equal(cb, wantCB) {
+		t.Fatalf("control block %x, expect %x", cb, wantCB)
+	}
+}
+
+func TestTapCommit(t *testing.T) {
+	var rev, timeout [33]byte
+	rev[0], timeout[0] = 0x02, 0x03
+	internal := h32(t,
+		"187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	// any x on the curve will do
+	copy(rev[1:], internal[:])
+	copy(timeout[1:], internal[:])
+
+	pk, script, control, err := TapCommit(
+		CommitTaprootKey, rev, timeout, 144, internal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pk) != 34 || script != nil || control != nil {
+		t.Fatalf("key path commit gave %x %x %x", pk, script, control)
+	}
+
+	extra := [][32]byte{TaggedHash("test")}
+	pk, script, control, err = TapCommit(
+		CommitTaprootScript, rev, timeout, 144, internal, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the control block has to get from the script to the output key
+	if len(control) != 33+64 {
+		t.Fatalf("control block %d bytes, expect 97", len(control))
+	}
+	path := [][32]byte{
+		TapLeafHash(TapDelayScript(XOnly(timeout), 144)), extra[0]}
+	root := TapRoot(TapLeafHash(script), path)
+	out, oddY, err := TaprootOutputKey(internal, root[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pk, P2TRScript(out)) {
+		t.Fatalf("output script %x, expect %x", pk, P2TRScript(out))
+	}
+	if oddY != (control[0]&1 == 1) {
+		t.Fatalf("control block parity wrong")
+	}
+
+	_, _, _, err = TapCommit(CommitLegacy, rev, timeout, 144, internal, nil)
+	if err == nil {
+		t.Fatalf("legacy commit should have errored")
+	}
+}
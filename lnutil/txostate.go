@@ -0,0 +1,48 @@
+package lnutil
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+)
+
+// TxoState is where a wallet output is in its life.  A reorg can send it
+// back from confirmed to reorged, or from spent to spend-pending.
+type TxoState uint8
+
+const (
+	TxoUnconfirmed  TxoState = iota // paid to us, not in a block yet
+	TxoConfirmed                    // paid to us in a block
+	TxoReorged                      // was in a block a reorg took out
+	TxoSpendPending                 // spent by a tx not in a block yet
+	TxoSpent                        // spent by a tx in a block
+)
+
+func (s TxoState) String() string {
+	switch s {
+	case TxoUnconfirmed:
+		return "unconfirmed"
+	case TxoConfirmed:
+		return "confirmed"
+	case TxoReorged:
+		return "reorged"
+	case TxoSpendPending:
+		return "spend-pending"
+	case TxoSpent:
+		return "spent"
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+// Spent says if the output's gone from the wallet's utxos
+func (s TxoState) Spent() bool {
+	return s == TxoSpendPending || s == TxoSpent
+}
+
+// TxoStatus is a wallet output and its state
+type TxoStatus struct {
+	Op     wire.OutPoint
+	State  TxoState
+	Height int32 // block it's in, or the spend's in once spent; 0 for none
+	Value  int64
+}
@@ -0,0 +1,6 @@
+package migrate
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for db migrations; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("migrate")
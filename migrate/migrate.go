@@ -0,0 +1,121 @@
+/*
+Package migrate keeps bolt DBs up to date as their formats change.
+
+Each DB stores its schema version under a key in its own bucket.  A DB
+without one is version 0: everything made before versions existed.  Each
+program that opens a DB has an ordered list of Migrations; migration i
+takes the DB from version i to i+1, so the current version is the length
+of the list.  Run applies whatever's missing, first copying the DB file
+aside so a bad migration can be undone by hand.  New DBs are version 0
+too, so migrations have to cope with empty buckets.
+
+To change a format, append a Migration to the list for that DB; never
+change or reorder ones already released.
+*/
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var (
+	// BKTSchema holds the version; it's the same in every DB
+	BKTSchema = []byte("schema")
+	// KEYVersion is the schema version, a uint32
+	KEYVersion = []byte("version")
+)
+
+// Migration takes a DB up one version.  It runs in the same tx as the
+// version bump, so if it errors nothing changes.
+type Migration func(btx *bolt.Tx) error
+
+// Version returns the schema version of a DB; 0 if it has none
+func Version(db *bolt.DB) (uint32, error) {
+	var v uint32
+	err := db.View(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSchema)
+		if sb == nil {
+			return nil
+		}
+		vb := sb.Get(KEYVersion)
+		if vb == nil {
+			return nil
+		}
+		if len(vb) != 4 {
+			return fmt.Errorf("schema version %x not 4 bytes", vb)
+		}
+		v = lnutil.BtU32(vb)
+		return nil
+	})
+	return v, err
+}
+
+// Run brings db up to version len(migrations).  If there's anything to do
+// the DB file is copied first, to the same path with ".v<version>.<time>.bak"
+// on the end.  A DB newer than the migrations know is an error: this is
+// older software than what last wrote it.
+func Run(db *bolt.DB, migrations []Migration) error {
+	have, err := Version(db)
+	if err != nil {
+		return err
+	}
+	want := uint32(len(migrations))
+	if have > want {
+		return fmt.Errorf("%s is schema version %d, this version of lit only "+
+			"knows up to %d", db.Path(), have, want)
+	}
+	if have == want {
+		// write it down if it's not there; a no-op otherwise
+		return setVersion(db, want)
+	}
+
+	backup := fmt.Sprintf("%s.v%d.%d.bak", db.Path(), have, time.Now().Unix())
+	err = db.View(func(btx *bolt.Tx) error {
+		return btx.CopyFile(backup, 0600)
+	})
+	if err != nil {
+		return fmt.Errorf("backing up %s before migrating: %s",
+			db.Path(), err.Error())
+	}
+	logger.Infof("backed up %s to %s\n", db.Path(), backup)
+
+	for v := have; v < want; v++ {
+		logger.Infof("migrating %s from version %d to %d\n", db.Path(), v, v+1)
+		m := migrations[v]
+		err = db.Update(func(btx *bolt.Tx) error {
+			err := m(btx)
+			if err != nil {
+				return err
+			}
+			return putVersion(btx, v+1)
+		})
+		if err != nil {
+			return fmt.Errorf("migrating %s to version %d: %s (backup at %s)",
+				db.Path(), v+1, err.Error(), backup)
+		}
+	}
+	return nil
+}
+
+// setVersion writes the version if it isn't already
+func setVersion(db *bolt.DB, v uint32) error {
+	return db.Update(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSchema)
+		if sb != nil && sb.Get(KEYVersion) != nil {
+			return nil
+		}
+		return putVersion(btx, v)
+	})
+}
+
+func putVersion(btx *bolt.Tx, v uint32) error {
+	sb, err := btx.CreateBucketIfNotExists(BKTSchema)
+	if err != nil {
+		return err
+	}
+	return sb.Put(KEYVersion, lnutil.U32tB(v))
+}
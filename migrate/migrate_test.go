@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func testDB(t *testing.T) (*bolt.DB, string) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, dir
+}
+
+// bump makes a migration which records that it ran in bucket "ran"
+func bump(n byte) Migration {
+	return func(btx *bolt.Tx) error {
+		b, err := btx.CreateBucketIfNotExists([]byte("ran"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte{n}, []byte{n})
+	}
+}
+
+func TestRun(t *testing.T) {
+	db, dir := testDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	// nothing to do on a new db; version gets written as 0
+	err := Run(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Version(db)
+	if err != nil || v != 0 {
+		t.Fatalf("version %d err %v, expect 0", v, err)
+	}
+
+	migrations := []Migration{bump(0), bump(1)}
+	err = Run(db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = Version(db)
+	if err != nil || v != 2 {
+		t.Fatalf("version %d err %v, expect 2", v, err)
+	}
+	db.View(func(btx *bolt.Tx) error {
+		b := btx.Bucket([]byte("ran"))
+		if b == nil || b.Get([]byte{0}) == nil || b.Get([]byte{1}) == nil {
+			t.Fatalf("migrations didn't run")
+		}
+		return nil
+	})
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "test.db.v0.*.bak"))
+	if len(backups) != 1 {
+		t.Fatalf("%d backups, expect 1", len(backups))
+	}
+
+	// older software can't open it
+	err = Run(db, migrations[:1])
+	if err == nil {
+		t.Fatalf("ran version 2 db with 1 migration")
+	}
+}
+
+func TestRunFail(t *testing.T) {
+	db, dir := testDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	fail := func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucket([]byte("half"))
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("nope")
+	}
+	err := Run(db, []Migration{bump(0), fail})
+	if err == nil {
+		t.Fatalf("failing migration didn't error")
+	}
+	// first one stays, second rolled back
+	v, err := Version(db)
+	if err != nil || v != 1 {
+		t.Fatalf("version %d err %v, expect 1", v, err)
+	}
+	db.View(func(btx *bolt.Tx) error {
+		if btx.Bucket([]byte("half")) != nil {
+			t.Fatalf("failed migration not rolled back")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// email sends alerts through an SMTP server
+type email struct {
+	addr string // host:port
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func newEmail(u *url.URL) (*email, error) {
+	e := &email{addr: u.Host, from: u.Query().Get("from"), to: u.Query()["to"]}
+	if u.Port() == "" {
+		e.addr = net.JoinHostPort(u.Host, "25")
+	}
+	if e.from == "" || len(e.to) == 0 {
+		return nil, fmt.Errorf("smtp transport needs from= and to=")
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		e.auth = smtp.PlainAuth("", u.User.Username(), pass, u.Hostname())
+	}
+	return e, nil
+}
+
+// message is the mail itself, headers and all
+func (e *email) message(subject, body string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.Replace(subject, "\n", " ", -1))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// Send does what smtp.SendMail does, but connects with lnutil.Dial so it
+// goes through the proxy if there is one
+func (e *email) Send(subject, body string) error {
+	conn, err := lnutil.Dial("tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(e.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if e.auth != nil {
+		err = c.Auth(e.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(e.from)
+	if err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(e.message(subject, body, time.Now()))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (e *email) String() string {
+	return fmt.Sprintf("email to %s via %s", strings.Join(e.to, ", "), e.addr)
+}
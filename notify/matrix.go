@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// matrix sends alerts to a room
+type matrix struct {
+	base  string // scheme and homeserver
+	token string
+	room  string
+	txn   uint64 // makes each send's transaction id new
+}
+
+func newMatrix(u *url.URL) (*matrix, error) {
+	m := &matrix{base: "https://" + u.Host,
+		room: strings.TrimPrefix(u.Path, "/")}
+	if u.Scheme == "matrix+http" {
+		m.base = "http://" + u.Host
+	}
+	if u.User != nil {
+		m.token = u.User.Username()
+	}
+	if m.token == "" || m.room == "" {
+		return nil, fmt.Errorf(
+			"matrix transport needs accesstoken@homeserver/!roomid:server")
+	}
+	return m, nil
+}
+
+func (m *matrix) Send(subject, body string) error {
+	msg, err := json.Marshal(map[string]string{
+		"msgtype": "m.text",
+		"body":    subject + "\n\n" + body,
+	})
+	if err != nil {
+		return err
+	}
+	// the homeserver drops a send whose transaction id it's seen, so each
+	// needs its own
+	txn := fmt.Sprintf("lit%d.%d", time.Now().UnixNano(),
+		atomic.AddUint64(&m.txn, 1))
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		m.base, url.PathEscape(m.room), txn)
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	return do(req)
+}
+
+func (m *matrix) String() string {
+	return "matrix room " + m.room
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const telegramAPI = "https://api.telegram.org"
+
+// telegram sends alerts from a bot to a chat
+type telegram struct {
+	api   string
+	token string
+	chat  string
+}
+
+func newTelegram(u *url.URL) (*telegram, error) {
+	t := &telegram{api: telegramAPI, chat: u.Host}
+	if u.User != nil {
+		// the token has a colon in it, so it parses as user:pass
+		t.token = u.User.String()
+		t.token, _ = url.PathUnescape(t.token)
+	}
+	if t.token == "" || t.chat == "" {
+		return nil, fmt.Errorf("telegram transport needs bottoken@chatid")
+	}
+	if api := u.Query().Get("api"); api != "" {
+		t.api = strings.TrimSuffix(api, "/")
+	}
+	return t, nil
+}
+
+func (t *telegram) Send(subject, body string) error {
+	msg, err := json.Marshal(map[string]string{
+		"chat_id": t.chat,
+		"text":    subject + "\n\n" + body,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST",
+		t.api+"/bot"+t.token+"/sendMessage", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req)
+}
+
+func (t *telegram) String() string {
+	return "telegram chat " + t.chat
+}
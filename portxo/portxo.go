@@ -32,6 +32,12 @@ now and you might as well put the sig check last.
 Also makes sense that with a MAST or pay-to-script-merkle-root type of structure,
 you'd want a bunch stuff before the sig.
 
+WitScript is the script a p2wsh output commits to, which goes last on the
+witness stack.  Older portxos put that in PkScript instead; WitnessScript()
+deals with both.  LockTime is the nLockTime a spending tx needs (for CLTV),
+and Hint says what kind of condition is holding up the spend, so a sweeper
+knows what it's waiting for without decoding scripts.
+
 */
 type PorTxo struct {
 	// got rid of NetID.  If you want to specify different networks / coins,
@@ -49,6 +55,66 @@ type PorTxo struct {
 	PkScript []byte // if empty, try to generate based on mode and priv key
 
 	PreSigStack [][]byte // items to push before the sig
+
+	WitScript []byte    // p2wsh script, if not in PkScript
+	LockTime  uint32    // nLockTime needed to spend
+	Hint      SpendHint // what the spend is waiting on
+}
+
+// SpendHint says what's needed, past a sig, to spend a txo
+type SpendHint uint8
+
+// Spend hints
+const (
+	SpendHintNone     SpendHint = iota // just a sig
+	SpendHintCSV                       // relative timelock, in Seq
+	SpendHintCLTV                      // absolute timelock, in LockTime
+	SpendHintPreimage                  // hash preimage, in PreSigStack
+)
+
+var hintStrings = map[SpendHint]string{
+	SpendHintNone:     "sig",
+	SpendHintCSV:      "csv",
+	SpendHintCLTV:     "cltv",
+	SpendHintPreimage: "preimage",
+}
+
+func (h SpendHint) String() string {
+	s, ok := hintStrings[h]
+	if ok {
+		return s
+	}
+	return fmt.Sprintf("unknown SpendHint %x", uint8(h))
+}
+
+// lockTimeThreshold is where nLockTime switches from heights to unix times
+const lockTimeThreshold = 500000000
+
+// max size of the witness script, which is pushed like any other stack item
+const maxWitScriptLen = 520
+
+// WitnessScript returns the script to sign and put last on the witness stack
+// for a p2wsh txo.  It's WitScript if set, otherwise PkScript (how they used
+// to be stored).
+func (u *PorTxo) WitnessScript() []byte {
+	if len(u.WitScript) != 0 {
+		return u.WitScript
+	}
+	return u.PkScript
+}
+
+// Mature says if the txo's timelocks let it be spent in the block after
+// curHeight.  Seq > 1 is a relative lock, and needs the txo confirmed.  Time
+// based LockTimes aren't checked here; the tx just won't relay until then.
+func (u *PorTxo) Mature(curHeight int32) bool {
+	if u.Seq > 1 &&
+		(u.Height < 100 || u.Height+int32(u.Seq) > curHeight) {
+		return false
+	}
+	if u.LockTime < lockTimeThreshold && int64(u.LockTime) > int64(curHeight) {
+		return false
+	}
+	return true
 }
 
 // Constants defining txo modes
@@ -126,6 +192,9 @@ func (u *PorTxo) Equal(z *PorTxo) bool {
 	if u.Value != z.Value || u.Seq != z.Seq || u.Mode != z.Mode || u.Height != z.Height {
 		return false
 	}
+	if u.LockTime != z.LockTime || u.Hint != z.Hint {
+		return false
+	}
 	if u.KeyGen.PrivKey != z.KeyGen.PrivKey {
 		return false
 	}
@@ -135,6 +204,9 @@ func (u *PorTxo) Equal(z *PorTxo) bool {
 	if !bytes.Equal(u.PkScript, z.PkScript) {
 		return false
 	}
+	if !bytes.Equal(u.WitScript, z.WitScript) {
+		return false
+	}
 
 	// compare pre sig stack lengths
 	if len(u.PreSigStack) != len(z.PreSigStack) {
@@ -180,6 +252,11 @@ func (u *PorTxo) String() string {
 	}
 	s += fmt.Sprintf("\n")
 
+	if u.Hint != SpendHintNone || u.LockTime != 0 || len(u.WitScript) != 0 {
+		s += fmt.Sprintf("\tspend: %s locktime:%d\n", u.Hint.String(), u.LockTime)
+		s += fmt.Sprintf("\tWitScript (len %d): %x\n", len(u.WitScript), u.WitScript)
+	}
+
 	return s
 }
 
@@ -201,6 +278,11 @@ PostStackNum (1 byte)
 PkScriptLen (1 byte)
 	PkScript (max 255 bytes)
 
+then optionally, if any are set (so older portxos still read):
+SpendHint (1 byte)
+LockTime (4 bytes)
+WitScriptLen (2 bytes)
+	WitScript (max 520 bytes)
 
 */
 
@@ -281,6 +363,32 @@ func PorTxoFromBytes(b []byte) (*PorTxo, error) {
 		}
 	}
 
+	// anything left is the spend requirements
+	if buf.Len() == 0 {
+		return &u, nil
+	}
+	err = binary.Read(buf, binary.BigEndian, &u.Hint)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &u.LockTime)
+	if err != nil {
+		return nil, err
+	}
+	var witScriptLen uint16
+	err = binary.Read(buf, binary.BigEndian, &witScriptLen)
+	if err != nil {
+		return nil, err
+	}
+	if witScriptLen > maxWitScriptLen || int(witScriptLen) != buf.Len() {
+		return nil, fmt.Errorf("WitScript length %d, %d bytes left",
+			witScriptLen, buf.Len())
+	}
+	if witScriptLen != 0 {
+		u.WitScript = make([]byte, witScriptLen)
+		copy(u.WitScript, buf.Next(int(witScriptLen)))
+	}
+
 	return &u, nil
 }
 
@@ -363,5 +471,30 @@ func (u *PorTxo) Bytes() ([]byte, error) {
 		}
 	}
 
+	// leave spend requirements off if there aren't any
+	if u.Hint == SpendHintNone && u.LockTime == 0 && len(u.WitScript) == 0 {
+		return buf.Bytes(), nil
+	}
+	if len(u.WitScript) > maxWitScriptLen {
+		return nil, fmt.Errorf("WitScript %d bytes (%d max)",
+			len(u.WitScript), maxWitScriptLen)
+	}
+	err = binary.Write(&buf, binary.BigEndian, u.Hint)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, u.LockTime)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, uint16(len(u.WitScript)))
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.Write(u.WitScript)
+	if err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
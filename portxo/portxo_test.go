@@ -157,3 +157,51 @@ func TestWithStack(t *testing.T) {
 		t.Fatalf("u2, u3 should be the same")
 	}
 }
+
+// TestSpendReqs tests serializing / deserializing a portxo with a witness
+// script and timelock, and that ones without still come out the old size
+func TestSpendReqs(t *testing.T) {
+	var u1 PorTxo
+	u1.Op.Hash = chainhash.DoubleHashH([]byte("test4"))
+	u1.Value = 5565989
+	u1.Mode = TxoP2WSHComp
+	u1.PkScript = []byte("00112233")
+	u1.PreSigStack = [][]byte{[]byte("preimage")}
+
+	b1, err := u1.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u2 := u1
+	u2.Hint = SpendHintCLTV
+	u2.LockTime = 500
+	u2.WitScript = []byte("witness script")
+	b2, err := u2.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b2) != len(b1)+7+len(u2.WitScript) {
+		t.Fatalf("%d bytes with spend reqs, %d without", len(b2), len(b1))
+	}
+	u3, err := PorTxoFromBytes(b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("u3: %s", u3.String())
+	if !u2.Equal(u3) {
+		t.Fatalf("u2, u3 should be the same")
+	}
+	if string(u3.WitnessScript()) != "witness script" {
+		t.Fatalf("witness script %x", u3.WitnessScript())
+	}
+	if u3.Mature(499) || !u3.Mature(500) {
+		t.Fatalf("locktime 500 maturity wrong")
+	}
+
+	// truncated
+	_, err = PorTxoFromBytes(b2[:len(b2)-1])
+	if err == nil {
+		t.Fatalf("truncated witness script read ok")
+	}
+}
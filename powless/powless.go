@@ -8,7 +8,6 @@ import (
 	"io"
 	"log"
 	"net"
-	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -73,6 +72,9 @@ ChainHook interface
 
 */
 
+// httpClient connects through the proxy if there is one
+var httpClient = lnutil.HTTPClient(0)
+
 // APILink is a link to a web API that can tell you about blockchain data.
 type APILink struct {
 	apiCon net.Conn
@@ -206,7 +208,7 @@ func (a *APILink) GetAdrTxos() error {
 	// chop off last comma, and add /utxo
 	adrlist = adrlist[:len(adrlist)-1] + "/utxo"
 
-	response, err := http.Get(apitxourl + "/addrs/" + adrlist)
+	response, err := httpClient.Get(apitxourl + "/addrs/" + adrlist)
 	if err != nil {
 		return err
 	}
@@ -268,7 +270,7 @@ func (a *APILink) GetOPTxs() error {
 		fmt.Printf("asking for %s\n", op.String())
 		// get full tx info for the outpoint's tx
 		// (if we have 2 outpoints with the same txid we query twice...)
-		response, err := http.Get(apitxourl + "tx/" + op.Hash.String())
+		response, err := httpClient.Get(apitxourl + "tx/" + op.Hash.String())
 		if err != nil {
 			return err
 		}
@@ -359,7 +361,7 @@ func (a *APILink) UpdateHeight(height int32) {
 // GetRawTx is a helper function to get a tx from the insight api
 func GetRawTx(txid string) (*wire.MsgTx, error) {
 	rawTxURL := "https://testnet.blockexplorer.com/api/rawtx/"
-	response, err := http.Get(rawTxURL + txid)
+	response, err := httpClient.Get(rawTxURL + txid)
 	if err != nil {
 		return nil, err
 	}
@@ -440,7 +442,7 @@ func (a *APILink) PushTx(tx *wire.MsgTx) error {
 	fmt.Printf("tx hex string is %s\n", txHexString)
 
 	apiurl := "https://testnet-api.smartbit.com.au/v1/blockchain/pushtx"
-	response, err := http.Post(
+	response, err := httpClient.Post(
 		apiurl, "application/json", bytes.NewBuffer([]byte(txHexString)))
 	fmt.Printf("respo	nse: %s", response.Status)
 	_, err = io.Copy(os.Stdout, response.Body)
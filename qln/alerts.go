@@ -0,0 +1,200 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Some trouble doesn't happen at a moment, so nothing else would publish an
+event for it: a peer with channels staying away, or the tower not taking
+what's queued for it.  AlertWatcher looks for those every alertInterval and
+publishes an event the first time each is seen, then again only once it's
+cleared up and come back.  A tx lit sent that's dropped out of mempools or
+been double spent is another; the wallets keep track of those, see
+wallit/rebroadcast.go.  So is a wallet's heir tx getting near its lock
+height without the owner checking in; see wallit/heir.go.  Breaches and
+force closes are published as they're seen on chain, in chanSpent.
+
+These are what the notify package sends out of band; see notify/notify.go.
+*/
+
+const (
+	alertInterval     = 5 * time.Minute
+	peerOfflineAlert  = 24 * time.Hour
+	towerStuckAlert   = time.Hour
+	towerStuckAlertID = "tower"
+)
+
+type alertState struct {
+	mtx sync.Mutex
+	// when the tower outbox was last seen empty
+	towerEmptied time.Time
+	// alerts out and not cleared yet, so they're only sent once
+	raised map[string]bool
+}
+
+func newAlertState() *alertState {
+	return &alertState{towerEmptied: time.Now(), raised: make(map[string]bool)}
+}
+
+// raise says if an alert's new, and marks it as out
+func (a *alertState) raise(id string) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.raised[id] {
+		return false
+	}
+	a.raised[id] = true
+	return true
+}
+
+// clear lets an alert go out again next time
+func (a *alertState) clear(id string) {
+	a.mtx.Lock()
+	delete(a.raised, id)
+	a.mtx.Unlock()
+}
+
+// towerBoxEmpty notes that the tower has everything queued for it
+func (a *alertState) towerBoxEmpty() {
+	a.mtx.Lock()
+	a.towerEmptied = time.Now()
+	a.mtx.Unlock()
+}
+
+// AlertWatcher publishes events for peers with channels that have been
+// away over a day, a tower outbox that's been stuck over an hour, sent
+// txs in trouble, and heir check ins due.
+// Runs until shutdown.
+func (nd *LitNode) AlertWatcher() {
+	for !nd.ShuttingDown() {
+		time.Sleep(alertInterval)
+		nd.checkPeersAway()
+		nd.checkTowerStuck()
+		nd.checkBroadcasts()
+		nd.checkHeirs()
+	}
+}
+
+// checkPeersAway looks for peers with open channels that haven't been
+// connected for peerOfflineAlert
+func (nd *LitNode) checkPeersAway() {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		logger.Warnf("alerts: %s\n", err.Error())
+		return
+	}
+	chans := make(map[uint32]int)
+	for _, q := range qcs {
+		if !q.CloseData.Closed {
+			chans[q.Peer()]++
+		}
+	}
+	for peer, n := range chans {
+		id := fmt.Sprintf("peer %d", peer)
+		since := nd.PeerOfflineSince(peer)
+		if since.IsZero() || time.Since(since) < peerOfflineAlert {
+			nd.alerts.clear(id)
+			continue
+		}
+		if !nd.alerts.raise(id) {
+			continue
+		}
+		nd.PublishEvent(NodeEvent{Type: EventPeerOffline, PeerIdx: peer,
+			Detail: fmt.Sprintf("%d open channels, away since %s",
+				n, since.Format(time.RFC822))})
+	}
+}
+
+// checkTowerStuck looks for tower outbox messages that haven't gone out
+// for towerStuckAlert
+func (nd *LitNode) checkTowerStuck() {
+	n, err := nd.TowerBoxLen()
+	if err != nil {
+		logger.Warnf("alerts: %s\n", err.Error())
+		return
+	}
+	if n == 0 {
+		nd.alerts.towerBoxEmpty()
+	}
+	nd.alerts.mtx.Lock()
+	since := nd.alerts.towerEmptied
+	nd.alerts.mtx.Unlock()
+	if n == 0 || time.Since(since) < towerStuckAlert {
+		nd.alerts.clear(towerStuckAlertID)
+		return
+	}
+	if !nd.alerts.raise(towerStuckAlertID) {
+		return
+	}
+	detail := fmt.Sprintf("%d messages waiting since %s", n,
+		since.Format(time.RFC822))
+	if !nd.towerConnected() {
+		detail += "; not connected"
+	}
+	nd.PublishEvent(NodeEvent{Type: EventTowerUnreachable, Detail: detail})
+}
+
+// checkBroadcasts looks for txs the wallets sent that have been evicted
+// from mempools or double spent
+func (nd *LitNode) checkBroadcasts() {
+	for coin, wal := range nd.SubWallet {
+		ots, err := wal.Broadcasts()
+		if err != nil {
+			logger.Warnf("alerts: %s\n", err.Error())
+			continue
+		}
+		for _, ot := range ots {
+			id := fmt.Sprintf("tx %s %s", ot.Txid.String(), ot.State)
+			ev := NodeEvent{CoinType: coin, Txid: ot.Txid.String()}
+			switch ot.State {
+			case lnutil.BroadcastEvicted:
+				ev.Type = EventTxEvicted
+				ev.Detail = fmt.Sprintf("sent %d times since %s; may need "+
+					"a higher fee", ot.Sends,
+					time.Unix(ot.FirstSent, 0).Format(time.RFC822))
+			case lnutil.BroadcastDoubleSpent:
+				ev.Type = EventTxDoubleSpent
+				ev.Detail = fmt.Sprintf("%s spending the same inputs is in "+
+					"block %d", ot.Conflict.String(), ot.Height)
+			default:
+				// back in mempools, so it can be raised again
+				nd.alerts.clear(fmt.Sprintf("tx %s %s",
+					ot.Txid.String(), lnutil.BroadcastEvicted))
+				continue
+			}
+			if nd.alerts.raise(id) {
+				nd.PublishEvent(ev)
+			}
+		}
+	}
+}
+
+// checkHeirs alerts for wallets whose heir tx will be pushed back no more
+// unless the owner checks in.  Each lock height alerts once.
+func (nd *LitNode) checkHeirs() {
+	for coin, wal := range nd.SubWallet {
+		h, err := wal.Heir()
+		if err != nil {
+			logger.Warnf("alerts: %s\n", err.Error())
+			continue
+		}
+		height := wal.CurrentHeight()
+		if h == nil || !h.Due(height) {
+			continue
+		}
+		if nd.alerts.raise(fmt.Sprintf("heir %d %d", coin, h.LockHeight)) {
+			nd.PublishEvent(NodeEvent{
+				Type:     EventHeirDue,
+				CoinType: coin,
+				Detail: fmt.Sprintf("heir tx valid after block %d, %d "+
+					"from now; check in to push it back",
+					h.LockHeight, h.LockHeight-height),
+			})
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Arbitration exports are for when a close is disputed and someone else,
+eg an auditor or the custodian of an LSP, has to decide who's right.  An
+export has everything a third party needs to check a channel's history
+without trusting either side:
+
+  - the channel: outpoint, capacity, both sides' keys and the fund script
+  - every state I signed off on: amounts, the txid of my state tx, the
+    counterparty's sig on it, and the revocation point it used
+  - for each state they've since revoked, the secret they revealed, which
+    hashes to that revocation point (lnutil.ElkPointFromHash)
+  - how the channel closed, if it has
+  - a hash chain over the states, signed with my node key, so the export
+    can't be edited after it's handed over
+
+Only the current state is kept with the channel, so states are also
+logged as they're saved, in a KEYStateLog bucket under the channel.  The
+log starts when this code first runs; states before that aren't in
+exports.  Their sigs are over my state tx (BuildStateTx(true)), spending
+the 2 of 2 in FundScript.
+*/
+
+// ArbitrationExportVersion is bumped when the format changes
+const ArbitrationExportVersion = 1
+
+// StateRecord is a state of a channel as it was signed
+type StateRecord struct {
+	StateIdx uint64
+	MyAmt    int64
+	Fee      int64
+	Delta    int32
+	ElkPoint [33]byte // their revocation point for this state
+	TheirSig [64]byte // on my state tx
+	Txid     chainhash.Hash
+	Time     int64 // unix time saved
+}
+
+const stateRecordLen = 8 + 8 + 8 + 4 + 33 + 64 + 32 + 8
+
+// ToBytes serializes a state record; all fixed size
+func (s *StateRecord) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, s.StateIdx)
+	binary.Write(&buf, binary.BigEndian, s.MyAmt)
+	binary.Write(&buf, binary.BigEndian, s.Fee)
+	binary.Write(&buf, binary.BigEndian, s.Delta)
+	buf.Write(s.ElkPoint[:])
+	buf.Write(s.TheirSig[:])
+	buf.Write(s.Txid[:])
+	binary.Write(&buf, binary.BigEndian, s.Time)
+	return buf.Bytes()
+}
+
+// StateRecordFromBytes deserializes a state record
+func StateRecordFromBytes(b []byte) (*StateRecord, error) {
+	if len(b) != stateRecordLen {
+		return nil, fmt.Errorf("state record %d bytes, expect %d",
+			len(b), stateRecordLen)
+	}
+	s := new(StateRecord)
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &s.StateIdx)
+	binary.Read(buf, binary.BigEndian, &s.MyAmt)
+	binary.Read(buf, binary.BigEndian, &s.Fee)
+	binary.Read(buf, binary.BigEndian, &s.Delta)
+	copy(s.ElkPoint[:], buf.Next(33))
+	copy(s.TheirSig[:], buf.Next(64))
+	copy(s.Txid[:], buf.Next(32))
+	binary.Read(buf, binary.BigEndian, &s.Time)
+	return s, nil
+}
+
+// stateRecord makes the record of a channel's current state, or nil if
+// there's no sig for it yet
+func (q *Qchan) stateRecord() *StateRecord {
+	var empty [64]byte
+	if q.State == nil || q.State.sig == empty {
+		return nil
+	}
+	s := new(StateRecord)
+	s.StateIdx = q.State.StateIdx
+	s.MyAmt = q.State.MyAmt
+	s.Fee = q.State.Fee
+	s.Delta = q.State.Delta
+	s.ElkPoint = q.State.ElkPoint
+	s.TheirSig = q.State.sig
+	s.Time = time.Now().Unix()
+	tx, err := q.BuildStateTx(true)
+	if err != nil {
+		// still worth keeping the sig; the txid can be worked out later
+		logger.Warnf("state record chan %d state %d: %s\n",
+			q.Idx(), s.StateIdx, err.Error())
+	} else {
+		s.Txid = tx.TxHash()
+	}
+	return s
+}
+
+// putStateRecord logs a state in a channel's bucket.  A state already
+// logged with the same sig is left alone, so its time stays when it was
+// first signed.
+func putStateRecord(qcBucket *bolt.Bucket, s *StateRecord) error {
+	if s == nil {
+		return nil
+	}
+	slb, err := qcBucket.CreateBucketIfNotExists(KEYStateLog)
+	if err != nil {
+		return err
+	}
+	k := lnutil.U64tB(s.StateIdx)
+	if old := slb.Get(k); old != nil {
+		o, err := StateRecordFromBytes(old)
+		if err == nil && o.TheirSig == s.TheirSig {
+			return nil
+		}
+	}
+	return slb.Put(k, s.ToBytes())
+}
+
+// StateLog returns the logged states of a channel, oldest first
+func (nd *LitNode) StateLog(q *Qchan) ([]*StateRecord, error) {
+	var recs []*StateRecord
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return fmt.Errorf("no channels")
+		}
+		opArr := lnutil.OutPointToBytes(q.Op)
+		qcBucket := cbk.Bucket(opArr[:])
+		if qcBucket == nil {
+			return fmt.Errorf("outpoint %s not in db", q.Op.String())
+		}
+		slb := qcBucket.Bucket(KEYStateLog)
+		if slb == nil {
+			return nil
+		}
+		return slb.ForEach(func(k, v []byte) error {
+			s, err := StateRecordFromBytes(v)
+			if err != nil {
+				return err
+			}
+			recs = append(recs, s)
+			return nil
+		})
+	})
+	return recs, err
+}
+
+// ExportState is a state in an arbitration export
+type ExportState struct {
+	StateIdx uint64
+	Time     int64
+	MyAmt    int64
+	TheirAmt int64
+	Fee      int64
+	Delta    int32 // in flight when signed
+
+	Txid     string // my state tx
+	TheirSig string // hex, compact; their sig on it
+
+	RevocationPoint string // hex
+	// the secret they revealed when they revoked this state; hashes to
+	// RevocationPoint.  Empty for states not revoked yet.
+	TheirRevocation string
+
+	// sha256 of the previous state's ChainHash and this record's bytes
+	// (StateRecord.ToBytes); the first chains from the channel outpoint
+	ChainHash string
+}
+
+// ArbitrationExport is everything about a channel a third party needs to
+// settle a dispute over it
+type ArbitrationExport struct {
+	Version int
+	Created int64
+
+	NodePub  string // hex; my node id key, which signs HistoryHash
+	PeerPub  string // hex; theirs
+	ChanIdx  uint32
+	PeerIdx  uint32
+	CoinType uint32
+	Outpoint string
+	Capacity int64
+	Height   int32 // of the fund tx
+
+	MyPub          string
+	TheirPub       string
+	MyRefundPub    string
+	TheirRefundPub string
+	MyHAKDBase     string
+	TheirHAKDBase  string
+	Delay          uint16
+	FundScript     string
+
+	Closed      bool
+	CloseTxid   string
+	CloseHeight int32
+
+	States []ExportState
+	// the current state tx with both sigs, if the channel's open
+	Current *CommitmentDump
+
+	HistoryHash string // ChainHash of the last state
+	Sig         string // hex DER; my node key over HistoryHash
+}
+
+// ExportArbitration makes an arbitration export for a channel, open or
+// closed
+func (nd *LitNode) ExportArbitration(cIdx uint32) (*ArbitrationExport, error) {
+	q, err := nd.GetQchanByIdx(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := nd.StateLog(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ex := new(ArbitrationExport)
+	ex.Version = ArbitrationExportVersion
+	ex.Created = time.Now().Unix()
+	ex.NodePub = hex.EncodeToString(nd.IdKey().PubKey().SerializeCompressed())
+	peerPub, _ := nd.GetPubHostFromPeerIdx(q.Peer())
+	ex.PeerPub = hex.EncodeToString(peerPub[:])
+	ex.ChanIdx = q.Idx()
+	ex.PeerIdx = q.Peer()
+	ex.CoinType = q.Coin()
+	ex.Outpoint = q.Op.String()
+	ex.Capacity = q.Value
+	ex.Height = q.Height
+	ex.MyPub = hex.EncodeToString(q.MyPub[:])
+	ex.TheirPub = hex.EncodeToString(q.TheirPub[:])
+	ex.MyRefundPub = hex.EncodeToString(q.MyRefundPub[:])
+	ex.TheirRefundPub = hex.EncodeToString(q.TheirRefundPub[:])
+	ex.MyHAKDBase = hex.EncodeToString(q.MyHAKDBase[:])
+	ex.TheirHAKDBase = hex.EncodeToString(q.TheirHAKDBase[:])
+	ex.Delay = q.Delay
+	fundScript, _, err := lnutil.FundTxScript(q.MyPub, q.TheirPub)
+	if err != nil {
+		return nil, err
+	}
+	ex.FundScript = hex.EncodeToString(fundScript)
+
+	ex.Closed = q.CloseData.Closed
+	if ex.Closed {
+		ex.CloseTxid = q.CloseData.CloseTxid.String()
+		ex.CloseHeight = q.CloseData.CloseHeight
+	}
+
+	opArr := lnutil.OutPointToBytes(q.Op)
+	chain := sha256.Sum256(opArr[:])
+	for _, r := range recs {
+		var es ExportState
+		es.StateIdx = r.StateIdx
+		es.Time = r.Time
+		es.MyAmt = r.MyAmt
+		es.TheirAmt = q.Value - r.MyAmt
+		es.Fee = r.Fee
+		es.Delta = r.Delta
+		es.Txid = r.Txid.String()
+		es.TheirSig = hex.EncodeToString(r.TheirSig[:])
+		es.RevocationPoint = hex.EncodeToString(r.ElkPoint[:])
+		// only give the secret if it really is the one for this point
+		if r.StateIdx < q.State.StateIdx {
+			elk, err := q.ElkRcv.AtIndex(r.StateIdx)
+			if err == nil && lnutil.ElkPointFromHash(elk) == r.ElkPoint {
+				es.TheirRevocation = hex.EncodeToString(elk[:])
+			}
+		}
+		chain = sha256.Sum256(append(chain[:], r.ToBytes()...))
+		es.ChainHash = hex.EncodeToString(chain[:])
+		ex.States = append(ex.States, es)
+	}
+
+	if !ex.Closed {
+		ex.Current, err = nd.DumpCommitment(cIdx, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ex.HistoryHash = hex.EncodeToString(chain[:])
+	sig, err := nd.IdKey().Sign(chain[:])
+	if err != nil {
+		return nil, err
+	}
+	ex.Sig = hex.EncodeToString(sig.Serialize())
+	return ex, nil
+}
@@ -0,0 +1,341 @@
+package qln
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+/*
+Backups are a gzipped tar of every bolt DB the node has open: ln.db, each
+wallet's <coin>/utxo.db and the tower's watch.db, under those names
+whichever folders they're in; see datadirs.go.  Each DB is written from inside a read tx, so it's a
+consistent snapshot even while the node keeps running; the DBs aren't
+snapshotted at the same instant as each other though.
+
+The scheduler makes one every Interval, keeps the newest Keep of them in
+Dir, and, if Remote is set, PUTs each to Remote/<file name> as well.
+
+Channel DBs are dangerous to restore: an old channel state looks just like
+a revoked one to the other side, and broadcasting it loses the channel.
+Restore is for when the alternative is losing everything anyway.
+A tower with an admin policy won't have its watch.db replaced without an
+approved restore op for the archive; see watchtower/admin.go.
+*/
+
+const (
+	backupPrefix = "lit-backup-"
+	backupSuffix = ".tar.gz"
+
+	backupUploadTimeout = time.Minute
+)
+
+// BackupConfig says when and where to make backups
+type BackupConfig struct {
+	Dir      string        // where to keep them
+	Interval time.Duration // how often
+	Keep     int           // how many to keep in Dir; 0 keeps them all
+	Remote   string        // url to also PUT each one to, if set
+}
+
+// boltHolder is a wallet or tower which can hand over its DB for backup
+type boltHolder interface {
+	BoltDB() *bolt.DB
+}
+
+// backupDB is a DB to back up, and its name in the archive
+type backupDB struct {
+	name string
+	db   *bolt.DB
+}
+
+// backupDBs returns every DB the node has open
+func (nd *LitNode) backupDBs() ([]backupDB, error) {
+	dbs := []backupDB{{"ln.db", nd.LitDB}}
+	for _, wal := range nd.SubWallet {
+		if h, ok := wal.(boltHolder); ok && h.BoltDB() != nil {
+			path := h.BoltDB().Path()
+			name, err := filepath.Rel(nd.Dirs.WalletDir(), path)
+			if err != nil || strings.HasPrefix(name, "..") {
+				return nil, fmt.Errorf("db %s not in wallet folder %s",
+					path, nd.Dirs.WalletDir())
+			}
+			dbs = append(dbs, backupDB{name, h.BoltDB()})
+		}
+	}
+	if h, ok := nd.Tower.(boltHolder); ok && h.BoltDB() != nil {
+		dbs = append(dbs, backupDB{"watch.db", h.BoltDB()})
+	}
+	return dbs, nil
+}
+
+// Backup writes a backup of every DB to dir and returns its path
+func (nd *LitNode) Backup(dir string) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%d%s", backupPrefix, time.Now().Unix(), backupSuffix)
+	path := filepath.Join(dir, name)
+	// write to a temp file so a half written backup never looks like one
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = nd.writeBackup(f)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+func (nd *LitNode) writeBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	dbs, err := nd.backupDBs()
+	if err != nil {
+		return err
+	}
+	for _, bdb := range dbs {
+		err = bdb.db.View(func(btx *bolt.Tx) error {
+			hdr := &tar.Header{
+				Name:    filepath.ToSlash(bdb.name),
+				Mode:    0600,
+				Size:    btx.Size(),
+				ModTime: time.Now(),
+			}
+			err := tw.WriteHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = btx.WriteTo(tw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// StartBackups makes backups on a schedule until shutdown.  Does nothing if
+// there's no Dir.
+func (nd *LitNode) StartBackups(cfg BackupConfig) {
+	if cfg.Dir == "" {
+		return
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 24 * time.Hour
+	}
+	go func() {
+		for !nd.ShuttingDown() {
+			time.Sleep(cfg.Interval)
+			path, err := nd.Backup(cfg.Dir)
+			if err != nil {
+				logger.Errorf("backup failed: %s\n", err.Error())
+				continue
+			}
+			logger.Infof("backed up to %s\n", path)
+			if cfg.Remote != "" {
+				err = uploadBackup(cfg.Remote, path)
+				if err != nil {
+					logger.Errorf("backup upload to %s failed: %s\n",
+						cfg.Remote, err.Error())
+				}
+			}
+			err = pruneBackups(cfg.Dir, cfg.Keep)
+			if err != nil {
+				logger.Errorf("pruning backups: %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+// uploadBackup PUTs a backup file to remote/<file name>
+func uploadBackup(remote, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(remote, "/") + "/" + filepath.Base(path)
+	req, err := http.NewRequest("PUT", url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+	client := lnutil.HTTPClient(backupUploadTimeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+	return nil
+}
+
+// pruneBackups deletes all but the newest keep backups in dir
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	names, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupSuffix))
+	if err != nil {
+		return err
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	// names are the same length for the next few centuries, so this is by time
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		err = os.Remove(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreFromBackup unpacks a backup into the folders in dirs.  Run it with
+// the node stopped.  DB files it replaces are renamed to <name>.pre-restore
+// first, so a restore can be undone.  See the warning above.
+func RestoreFromBackup(archive string, dirs DataDirs) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// a tower with an admin policy needs a restore op approved for this
+	// file before its DB is replaced; check before replacing anything
+	archiveHash, hasTower, err := scanBackup(f)
+	if err != nil {
+		return err
+	}
+	var keep *watchtower.AdminSnapshot
+	towerPath := filepath.Join(dirs.TowerDir(), "watch.db")
+	if _, err := os.Stat(towerPath); err == nil && hasTower {
+		keep, err = watchtower.RestoreAllowed(towerPath, archiveHash)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") ||
+			!strings.HasSuffix(name, ".db") {
+			return fmt.Errorf("backup has bad file name %s", hdr.Name)
+		}
+		dir := dirs.WalletDir()
+		switch name {
+		case "ln.db":
+			dir = dirs.ChannelDir()
+		case "watch.db":
+			dir = dirs.TowerDir()
+		}
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			err = os.Rename(path, path+".pre-restore")
+			if err != nil {
+				return err
+			}
+		}
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil && path == towerPath {
+			err = keep.KeepAdmin(path)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored %s\n", path)
+	}
+}
+
+// scanBackup hashes a backup archive and says if it has a tower DB
+func scanBackup(f io.Reader) (hash [32]byte, hasTower bool, err error) {
+	h := sha256.New()
+	tee := io.TeeReader(f, h)
+	gz, err := gzip.NewReader(tee)
+	if err != nil {
+		return hash, false, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return hash, false, err
+		}
+		if filepath.Clean(filepath.FromSlash(hdr.Name)) == "watch.db" {
+			hasTower = true
+		}
+	}
+	// whatever's past the end of the tar still counts
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return hash, false, err
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, hasTower, nil
+}
@@ -2,10 +2,12 @@ package qln
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/chainwatch"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
@@ -28,8 +30,23 @@ type UWallet interface {
 	// export the chainhook that the UWallet uses, for pushTx and fullblock
 	ExportHook() uspv.ChainHook
 
+	// Notifier tells subscribers about spends, payments and heights on
+	// the wallet's chain.  Use it rather than polling.
+	Notifier() *chainwatch.Notifier
+
+	// ConfPolicy is how many confirmations funds need, by amount
+	ConfPolicy() lnutil.ConfPolicy
+	SetConfPolicy(lnutil.ConfPolicy)
+
+	// PushTx sends a tx out, and keeps sending it until it's in a block
 	PushTx(tx *wire.MsgTx) error
 
+	// Broadcasts lists the txs the wallet's sent that aren't buried yet,
+	// and whether they look evicted or double spent
+	Broadcasts() ([]lnutil.OwnTx, error)
+	// DropBroadcast stops sending a tx
+	DropBroadcast(txid *chainhash.Hash) error
+
 	// ExportUtxo gives a utxo to the underlying wallet; that wallet saves it
 	// and can spend it later.  Doesn't return errors; error will exist only in
 	// base wallet.
@@ -54,15 +71,70 @@ type UWallet interface {
 	// NahDontSend cancels the MaybeSend transaction.
 	NahDontSend(txid *chainhash.Hash) error
 
+	// SwapFrozenOuts rebuilds a MaybeSend tx with other outputs of the same
+	// total and no bigger, keeping its inputs frozen.  Returns the new
+	// outpoints, like MaybeSend.
+	SwapFrozenOuts(txid *chainhash.Hash, txos []*wire.TxOut) ([]*wire.OutPoint, error)
+
+	// MaybeReplace builds, unsigned, a tx replacing an unconfirmed one the
+	// wallet sent, paying a higher fee from its change.  Its other outputs
+	// stay the same.  Send it with ReallySend or cancel with NahDontSend.
+	MaybeReplace(txid *chainhash.Hash, feePerByte int64) (*wire.MsgTx, error)
+
+	// DoubleSpend sends an unconfirmed tx's inputs back to the wallet at a
+	// higher fee, so it won't confirm.  Returns the new txid.
+	DoubleSpend(txid *chainhash.Hash, feePerByte int64) (*chainhash.Hash, error)
+
+	// SweepTxo sends one of the wallet's utxos, alone, to a new address of
+	// its own.  Returns the txid.
+	SweepTxo(op wire.OutPoint, feePerByte int64) (*chainhash.Hash, error)
+
+	// SweepKey sends everything paying a private key, from a height on, to
+	// a new address of the wallet's in one tx.  comp and uncomp say which of
+	// its pubkeys to look for.  Returns the tx and the total it took in.
+	SweepKey(priv *btcec.PrivateKey, comp, uncomp bool,
+		from int32, feePerByte int64) (*wire.MsgTx, int64, error)
+
+	// Heir is the wallet's dead man's switch, nil if it has none.  SetHeir
+	// has everything go to script if the owner doesn't check in for delay
+	// blocks; HeirCheckIn is a check in.  ExportHeirTx gives the signed tx
+	// to hand to the heir.
+	Heir() (*lnutil.Heir, error)
+	SetHeir(script []byte, delay int32) error
+	ClearHeir() error
+	HeirCheckIn() error
+	ExportHeirTx() (*wire.MsgTx, error)
+
+	// GetTx returns a tx the wallet has sent or received.
+	GetTx(txid *chainhash.Hash) (*wire.MsgTx, error)
+
+	// SpendOf returns the tx spending an outpoint, nil if there isn't one
+	SpendOf(op wire.OutPoint) (*wire.MsgTx, error)
+
 	// Return a new address
 	NewAdr() ([20]byte, error)
 
+	// NewAccountAdr returns a new address in an account, and the keygen
+	// it's from.  Account 0 is the one NewAdr uses.
+	NewAccountAdr(account uint32) ([20]byte, portxo.KeyGen, error)
+
+	// NewLockedOut makes an output to the wallet that can't be spent until
+	// a block height
+	NewLockedOut(amt int64, height int32) (*wire.TxOut, error)
+
 	// Dump all the utxos in the sub wallet
 	UtxoDump() ([]*portxo.PorTxo, error)
 
+	// TxoStates says where each output the wallet's had is in its life:
+	// unconfirmed, confirmed, reorged, spend-pending or spent
+	TxoStates() ([]lnutil.TxoStatus, error)
+
 	// Dump all the addresses the sub wallet is watching
 	AdrDump() ([][20]byte, error)
 
+	// AdrPath returns the keygen one of the wallet's addresses is from
+	AdrPath(adr [20]byte) (portxo.KeyGen, error)
+
 	// Return current height the wallet is synced to
 	CurrentHeight() int32
 
@@ -91,8 +163,46 @@ type UWallet interface {
 	SetFee(int64) int64
 
 	// ===== TESTING / SPAMMING ONLY, these funcs will not be in the real interface
-	// Sweep sends lots of txs (uint32 of them) to the specified address.
-	Sweep([]byte, uint32) ([]*chainhash.Hash, error)
+	// Sweep sends lots of txs (uint32 of them) to the specified address,
+	// at a fee rate.
+	Sweep([]byte, uint32, int64) ([]*chainhash.Hash, error)
+}
+
+// forwardBlocks passes on new blocks from a wallet's notifier to the
+// block waiters.  Runs until shutdown.
+func (nd *LitNode) forwardBlocks(wal UWallet) {
+	sub := wal.Notifier().WatchBlocks()
+	defer sub.Cancel()
+	for !nd.ShuttingDown() {
+		<-sub.Events
+		nd.blockMtx.Lock()
+		for _, w := range nd.blockWaiters {
+			select {
+			case w <- struct{}{}:
+			default: // already woken
+			}
+		}
+		nd.blockMtx.Unlock()
+	}
+}
+
+// blockWaiter returns a chan that gets a value when any wallet's chain
+// gets a new block, for things that check heights
+func (nd *LitNode) blockWaiter() chan struct{} {
+	w := make(chan struct{}, 1)
+	nd.blockMtx.Lock()
+	nd.blockWaiters = append(nd.blockWaiters, w)
+	nd.blockMtx.Unlock()
+	return w
+}
+
+// waitBlock waits for a value on a block waiter or for max, whichever
+// comes first
+func waitBlock(w chan struct{}, max time.Duration) {
+	select {
+	case <-w:
+	case <-time.After(max):
+	}
 }
 
 // GetUsePub gets a pubkey from the base wallet, but first modifies
@@ -121,3 +231,18 @@ func (nd *LitNode) GetElkremRoot(k portxo.KeyGen) (chainhash.Hash, error) {
 	}
 	return chainhash.DoubleHashH(pubArr[:]), nil
 }
+
+// chanConfs returns how many confirmations a channel needs before it's
+// used, by its capacity, and how many it has.  Without a policy set, non-test
+// coins need 1.
+func (nd *LitNode) chanConfs(wal UWallet, qc *Qchan) (int32, int32) {
+	need := wal.ConfPolicy().Confs(qc.Value)
+	if len(wal.ConfPolicy()) == 0 && !wal.Params().TestCoin {
+		need = 1
+	}
+	var confs int32
+	if qc.Height > 0 {
+		confs = wal.CurrentHeight() - qc.Height + 1
+	}
+	return need, confs
+}
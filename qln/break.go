@@ -2,11 +2,16 @@ package qln
 
 import (
 	"fmt"
-	"log"
 )
 
 // ------------------------- break
+
+// BreakChannel force closes a channel, in its op queue
 func (nd *LitNode) BreakChannel(q *Qchan) error {
+	return nd.chanDo(q, "break", func() error { return nd.breakChannel(q) })
+}
+
+func (nd *LitNode) breakChannel(q *Qchan) error {
 
 	if nd.SubWallet[q.Coin()] == nil {
 		return fmt.Errorf("Not connected to coin type %d\n", q.Coin())
@@ -21,17 +26,24 @@ func (nd *LitNode) BreakChannel(q *Qchan) error {
 		return fmt.Errorf("Can't break (%d,%d), already closed\n", q.Peer(), q.Idx())
 	}
 
-	log.Printf("breaking (%d,%d)\n", q.Peer(), q.Idx())
+	logger.Infof("breaking (%d,%d)\n", q.Peer(), q.Idx())
 	z, err := q.ElkSnd.AtIndex(0)
 	if err != nil {
 		return err
 	}
-	log.Printf("elk send 0: %s\n", z.String())
+	logger.Infof("elk send 0: %s\n", z.String())
 	z, err = q.ElkRcv.AtIndex(0)
 	if err != nil {
 		return err
 	}
-	log.Printf("elk recv 0: %s\n", z.String())
+	logger.Infof("elk recv 0: %s\n", z.String())
+
+	// so a break that doesn't go out can be undone; see checkpoint.go
+	cp, err := nd.writeCheckpoint(
+		fmt.Sprintf("force close channel %d", q.Idx()), q)
+	if err != nil {
+		return err
+	}
 
 	// set delta to 0... needed for break
 	q.State.Delta = 0
@@ -49,6 +61,12 @@ func (nd *LitNode) BreakChannel(q *Qchan) error {
 		return err
 	}
 
-	// broadcast break tx directly
-	return nd.SubWallet[q.Coin()].PushTx(tx)
+	// broadcast break tx directly.  If lit stops before it's back, it
+	// may have gone out, so the checkpoint's marked first.
+	markBroadcast(cp, tx.TxHash().String())
+	err = nd.SubWallet[q.Coin()].PushTx(tx)
+	if err != nil {
+		markBroadcast(cp, "")
+	}
+	return err
 }
@@ -67,6 +67,8 @@ func SetStateIdxBits(tx *wire.MsgTx, idx, x uint64) error {
 // The PKH addresses are my refund base with their r-elkrem point, and
 // their refund base with my r-elkrem point.  "Their" point means they have
 // the point but not the scalar.
+// If either side sent an upfront shutdown script when the channel opened,
+// their output pays that instead; see upfront.go.
 func (q *Qchan) SimpleCloseTx() (*wire.MsgTx, error) {
 	// sanity checks
 	if q == nil || q.State == nil {
@@ -75,12 +77,12 @@ func (q *Qchan) SimpleCloseTx() (*wire.MsgTx, error) {
 
 	fee := q.State.Fee // symmetric fee
 
+	myScript, theirScript := q.closeScripts()
+
 	// make my output
-	myScript := lnutil.DirectWPKHScript(q.MyRefundPub)
 	myAmt := q.State.MyAmt - fee
 	myOutput := wire.NewTxOut(myAmt, myScript)
 	// make their output
-	theirScript := lnutil.DirectWPKHScript(q.TheirRefundPub)
 	theirAmt := (q.Value - q.State.MyAmt) - fee
 	theirOutput := wire.NewTxOut(theirAmt, theirScript)
 
@@ -107,18 +109,67 @@ func (q *Qchan) SimpleCloseTx() (*wire.MsgTx, error) {
 // BuildStateTx constructs and returns a state tx.  As simple as I can make it.
 // This func just makes the tx with data from State in ram, and HAKD key arg
 func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
+	fancyScript, pkhPub, fancyAmt, pkhAmt, err := q.stateOutputs(mine)
+	if err != nil {
+		return nil, err
+	}
+	s := q.State
+
+	// now that everything is chosen, build pkh script
+	pkhScript := lnutil.DirectWPKHScript(pkhPub) // p2wpkh-ify
+
+	fancyScript = lnutil.P2WSHify(fancyScript) // p2wsh-ify
+
+	fmt.Printf("\t scripthash %x\n", fancyScript)
+
+	// create txouts by assigning amounts
+	outFancy := wire.NewTxOut(fancyAmt, fancyScript)
+	outPKH := wire.NewTxOut(pkhAmt, pkhScript)
+
+	fmt.Printf("\tcombined refund %x, pkh %x\n", pkhPub, outPKH.PkScript)
+
+	// make a new tx
+	tx := wire.NewMsgTx()
+	// add txouts
+	if fancyAmt != 0 {
+		tx.AddTxOut(outFancy)
+	}
+	if pkhAmt != 0 {
+		tx.AddTxOut(outPKH)
+	}
+
+	if len(tx.TxOut) < 1 {
+		return nil, fmt.Errorf("No outputs, all below minOutput")
+	}
+
+	// add unsigned txin
+	tx.AddTxIn(wire.NewTxIn(&q.Op, nil, nil))
+	// set index hints
+
+	// state 0 and 1 can't use mask?  Think they can now.
+	SetStateIdxBits(tx, s.StateIdx, q.GetChanHint(mine))
+
+	// sort outputs
+	txsort.InPlaceSort(tx)
+	return tx, nil
+}
+
+// stateOutputs works out the outputs of a state tx: the script (not yet
+// p2wsh'd) and amount of the timelocked output, and the key and amount of
+// the pkh output.  Zero amounts mean no output.
+func (q *Qchan) stateOutputs(mine bool) (
+	fancyScript []byte, pkhPub [33]byte, fancyAmt, pkhAmt int64, err error) {
 	if q == nil {
-		return nil, fmt.Errorf("BuildStateTx: nil chan")
+		return nil, pkhPub, 0, 0, fmt.Errorf("BuildStateTx: nil chan")
 	}
 	// sanity checks
 	s := q.State // use it a lot, make shorthand variable
 	if s == nil {
-		return nil, fmt.Errorf("channel (%d,%d) has no state", q.KeyGen.Step[3], q.KeyGen.Step[4])
+		return nil, pkhPub, 0, 0, fmt.Errorf("channel (%d,%d) has no state", q.KeyGen.Step[3], q.KeyGen.Step[4])
 	}
 
-	var fancyAmt, pkhAmt, theirAmt int64 // output amounts
-	var revPub, timePub [33]byte         // pubkeys
-	var pkhPub [33]byte                  // the simple output's pub key hash
+	var theirAmt int64           // output amounts
+	var revPub, timePub [33]byte // pubkeys
 
 	fee := s.Fee // fixed fee for now
 
@@ -132,7 +183,7 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 		// Create latest elkrem point (the one I create)
 		curElk, err := q.ElkPoint(false, q.State.StateIdx)
 		if err != nil {
-			return nil, err
+			return nil, pkhPub, 0, 0, err
 		}
 		revPub = lnutil.CombinePubs(q.TheirHAKDBase, curElk)
 		timePub = lnutil.AddPubsEZ(q.MyHAKDBase, curElk)
@@ -167,54 +218,18 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 	// check amounts.  Nonzero amounts below the minOutput is an error.
 	// Shouldn't happen and means some checks in push/pull went wrong.
 	if fancyAmt != 0 && fancyAmt < minOutput {
-		return nil, fmt.Errorf("SH amt %d too low", fancyAmt)
+		return nil, pkhPub, 0, 0, fmt.Errorf("SH amt %d too low", fancyAmt)
 	}
 	if pkhAmt != 0 && pkhAmt < minOutput {
-		return nil, fmt.Errorf("PKH amt %d too low", pkhAmt)
+		return nil, pkhPub, 0, 0, fmt.Errorf("PKH amt %d too low", pkhAmt)
 	}
 
-	// now that everything is chosen, build fancy script and pkh script
-	fancyScript := lnutil.CommitScript(revPub, timePub, q.Delay)
-	pkhScript := lnutil.DirectWPKHScript(pkhPub) // p2wpkh-ify
+	fancyScript = lnutil.CommitScript(revPub, timePub, q.Delay)
 
 	fmt.Printf("> made SH script, state %d\n", s.StateIdx)
 	fmt.Printf("\t revPub %x timeout pub %x \n", revPub, timePub)
 	fmt.Printf("\t script %x ", fancyScript)
-
-	fancyScript = lnutil.P2WSHify(fancyScript) // p2wsh-ify
-
-	fmt.Printf("\t scripthash %x\n", fancyScript)
-
-	// create txouts by assigning amounts
-	outFancy := wire.NewTxOut(fancyAmt, fancyScript)
-	outPKH := wire.NewTxOut(pkhAmt, pkhScript)
-
-	fmt.Printf("\tcombined refund %x, pkh %x\n", pkhPub, outPKH.PkScript)
-
-	// make a new tx
-	tx := wire.NewMsgTx()
-	// add txouts
-	if fancyAmt != 0 {
-		tx.AddTxOut(outFancy)
-	}
-	if pkhAmt != 0 {
-		tx.AddTxOut(outPKH)
-	}
-
-	if len(tx.TxOut) < 1 {
-		return nil, fmt.Errorf("No outputs, all below minOutput")
-	}
-
-	// add unsigned txin
-	tx.AddTxIn(wire.NewTxIn(&q.Op, nil, nil))
-	// set index hints
-
-	// state 0 and 1 can't use mask?  Think they can now.
-	SetStateIdxBits(tx, s.StateIdx, q.GetChanHint(mine))
-
-	// sort outputs
-	txsort.InPlaceSort(tx)
-	return tx, nil
+	return fancyScript, pkhPub, fancyAmt, pkhAmt, nil
 }
 
 // the scriptsig to put on a P2SH input.  Sigs need to be in order!
@@ -0,0 +1,323 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/cloudbak"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Channel backups are what it takes to get money out of channels when
+everything else is gone: for each open channel, the peer and where to
+reach them, the funding output and its key path, and the peer's keys.
+They don't hold any channel state, so they can't be used to broadcast an
+old one; restoring from one means asking the peer to close.  They only
+change when a channel opens or closes, so they stay small.
+
+They're sealed with a key derived from the seed, and with a cloud target
+set (see the cloudbak package) they're uploaded whenever they change.  On
+startup the uploaded copy is fetched and checked against ours, and put
+back if it's missing or wrong.
+*/
+
+const (
+	chanBackupVersion = 1
+
+	// how often to retry a failed upload, and look for changes missed
+	cloudBackupRetry = 10 * time.Minute
+)
+
+// ChanBackupEntry is one channel in a channel backup
+type ChanBackupEntry struct {
+	PeerPub        [33]byte
+	Host           string // where the peer was last reached, if known
+	Utxo           portxo.PorTxo
+	TheirPub       [33]byte
+	TheirRefundPub [33]byte
+	TheirHAKDBase  [33]byte
+	Delay          uint16
+}
+
+// ChanBackupToBytes serializes channel backup entries: a version byte,
+// then each entry with a 2 byte length
+func ChanBackupToBytes(entries []ChanBackupEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(chanBackupVersion)
+	for _, e := range entries {
+		utxoBytes, err := e.Utxo.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		var eb bytes.Buffer
+		eb.Write(e.PeerPub[:])
+		eb.Write(e.TheirPub[:])
+		eb.Write(e.TheirRefundPub[:])
+		eb.Write(e.TheirHAKDBase[:])
+		binary.Write(&eb, binary.BigEndian, e.Delay)
+		binary.Write(&eb, binary.BigEndian, uint16(len(utxoBytes)))
+		eb.Write(utxoBytes)
+		eb.WriteString(e.Host)
+		binary.Write(&buf, binary.BigEndian, uint16(eb.Len()))
+		buf.Write(eb.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// ChanBackupFromBytes deserializes what ChanBackupToBytes made
+func ChanBackupFromBytes(b []byte) ([]ChanBackupEntry, error) {
+	if len(b) < 1 || b[0] != chanBackupVersion {
+		return nil, fmt.Errorf("not a version %d channel backup",
+			chanBackupVersion)
+	}
+	var entries []ChanBackupEntry
+	buf := bytes.NewBuffer(b[1:])
+	for buf.Len() > 0 {
+		var n uint16
+		binary.Read(buf, binary.BigEndian, &n)
+		if buf.Len() < int(n) || n < 33*4+4 {
+			return nil, fmt.Errorf("channel backup entry truncated")
+		}
+		eb := bytes.NewBuffer(buf.Next(int(n)))
+		var e ChanBackupEntry
+		copy(e.PeerPub[:], eb.Next(33))
+		copy(e.TheirPub[:], eb.Next(33))
+		copy(e.TheirRefundPub[:], eb.Next(33))
+		copy(e.TheirHAKDBase[:], eb.Next(33))
+		binary.Read(eb, binary.BigEndian, &e.Delay)
+		var utxoLen uint16
+		binary.Read(eb, binary.BigEndian, &utxoLen)
+		if eb.Len() < int(utxoLen) {
+			return nil, fmt.Errorf("channel backup utxo truncated")
+		}
+		u, err := portxo.PorTxoFromBytes(eb.Next(int(utxoLen)))
+		if err != nil {
+			return nil, err
+		}
+		e.Utxo = *u
+		e.Host = eb.String()
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ChanBackup lists every open channel for a channel backup
+func (nd *LitNode) ChanBackup() ([]ChanBackupEntry, error) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	var entries []ChanBackupEntry
+	for _, qc := range qcs {
+		if qc.CloseData.Closed {
+			continue
+		}
+		var e ChanBackupEntry
+		e.PeerPub, e.Host = nd.GetPubHostFromPeerIdx(qc.Peer())
+		e.Utxo = qc.PorTxo
+		e.TheirPub = qc.TheirPub
+		e.TheirRefundPub = qc.TheirRefundPub
+		e.TheirHAKDBase = qc.TheirHAKDBase
+		e.Delay = qc.Delay
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// SealChanBackup serializes and encrypts channel backup entries
+func (nd *LitNode) SealChanBackup(entries []ChanBackupEntry) ([]byte, error) {
+	b, err := ChanBackupToBytes(entries)
+	if err != nil {
+		return nil, err
+	}
+	return seal(nd.chanBakKey, b)
+}
+
+// OpenChanBackup decrypts and deserializes a sealed channel backup.  Only
+// works for backups from this node's seed.
+func (nd *LitNode) OpenChanBackup(sealed []byte) ([]ChanBackupEntry, error) {
+	b, err := unseal(nd.chanBakKey, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("can't open channel backup: %s", err.Error())
+	}
+	return ChanBackupFromBytes(b)
+}
+
+// CloudBackupStatus is how uploading channel backups is going
+type CloudBackupStatus struct {
+	Target     string
+	Channels   int   // in the last backup uploaded
+	LastUpload int64 // unix time; 0 if none yet
+	Verified   bool  // the copy there matched at startup, or was fixed
+	LastErr    string
+}
+
+type cloudBackup struct {
+	target cloudbak.Target
+	name   string
+	poke   chan struct{}
+
+	mtx      sync.Mutex
+	lastHash [32]byte
+	status   CloudBackupStatus
+}
+
+// chanBackupChanged tells the uploader to look at the channels again
+func (nd *LitNode) chanBackupChanged() {
+	if nd.cloudBak == nil {
+		return
+	}
+	select {
+	case nd.cloudBak.poke <- struct{}{}:
+	default: // one's already waiting
+	}
+}
+
+// StartCloudBackup checks the channel backup at target matches ours,
+// then keeps it up to date as channels open and close, until shutdown
+func (nd *LitNode) StartCloudBackup(target cloudbak.Target) {
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+
+	cb := new(cloudBackup)
+	cb.target = target
+	// one file per node, so nodes can share a target
+	cb.name = lnutil.LitAdrFromPubkey(idPub) + ".chanbackup"
+	cb.poke = make(chan struct{}, 1)
+	cb.status.Target = target.String()
+	nd.cloudBak = cb
+
+	go func() {
+		nd.verifyCloudBackup()
+		sub := nd.SubscribeEvents()
+		defer nd.UnsubscribeEvents(sub)
+		tick := time.NewTicker(cloudBackupRetry)
+		defer tick.Stop()
+		for !nd.ShuttingDown() {
+			select {
+			case ev := <-sub:
+				if ev.Type != EventChanOpened && ev.Type != EventChanClosed {
+					continue
+				}
+			case <-cb.poke:
+			case <-tick.C:
+			}
+			nd.syncCloudBackup(false)
+		}
+	}()
+}
+
+// verifyCloudBackup gets the uploaded backup and compares it to ours,
+// uploading ours if it doesn't match
+func (nd *LitNode) verifyCloudBackup() {
+	cb := nd.cloudBak
+	sealed, err := cb.target.Get(cb.name)
+	if err == nil {
+		var theirs []ChanBackupEntry
+		theirs, err = nd.OpenChanBackup(sealed)
+		if err == nil {
+			err = nd.matchChanBackup(theirs)
+		}
+	}
+	if err == nil {
+		logger.Infof("channel backup at %s checks out\n", cb.target.String())
+		cb.mtx.Lock()
+		cb.status.Verified = true
+		cb.mtx.Unlock()
+		// no need to upload it again until something changes
+		nd.syncCloudBackup(true)
+		return
+	}
+	logger.Warnf("channel backup at %s: %s; uploading again\n",
+		cb.target.String(), err.Error())
+	if nd.syncCloudBackup(false) == nil {
+		cb.mtx.Lock()
+		cb.status.Verified = true
+		cb.mtx.Unlock()
+	}
+}
+
+// matchChanBackup returns an error if a backup doesn't have the same
+// channels as ours
+func (nd *LitNode) matchChanBackup(theirs []ChanBackupEntry) error {
+	ours, err := nd.ChanBackup()
+	if err != nil {
+		return err
+	}
+	a, err := ChanBackupToBytes(ours)
+	if err != nil {
+		return err
+	}
+	b, err := ChanBackupToBytes(theirs)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(a, b) {
+		return fmt.Errorf("has %d channels, differs from our %d",
+			len(theirs), len(ours))
+	}
+	return nil
+}
+
+// syncCloudBackup uploads the channel backup if it's changed since the
+// last upload, or the last upload failed.  With markOnly it just notes
+// the current backup as uploaded.
+func (nd *LitNode) syncCloudBackup(markOnly bool) error {
+	cb := nd.cloudBak
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	entries, err := nd.ChanBackup()
+	if err != nil {
+		cb.status.LastErr = err.Error()
+		return err
+	}
+	plain, err := ChanBackupToBytes(entries)
+	if err != nil {
+		cb.status.LastErr = err.Error()
+		return err
+	}
+	hash := sha256.Sum256(plain)
+	if markOnly {
+		cb.lastHash = hash
+		cb.status.Channels = len(entries)
+		return nil
+	}
+	if hash == cb.lastHash && cb.status.LastErr == "" {
+		return nil
+	}
+	sealed, err := seal(nd.chanBakKey, plain)
+	if err == nil {
+		err = cb.target.Put(cb.name, sealed)
+	}
+	if err != nil {
+		logger.Errorf("channel backup upload to %s failed: %s\n",
+			cb.target.String(), err.Error())
+		cb.status.LastErr = err.Error()
+		return err
+	}
+	logger.Infof("uploaded backup of %d channels to %s\n",
+		len(entries), cb.target.String())
+	cb.lastHash = hash
+	cb.status.Channels = len(entries)
+	cb.status.LastUpload = time.Now().Unix()
+	cb.status.LastErr = ""
+	return nil
+}
+
+// GetCloudBackupStatus says how uploading channel backups is going; false
+// if there's no target
+func (nd *LitNode) GetCloudBackupStatus() (CloudBackupStatus, bool) {
+	if nd.cloudBak == nil {
+		return CloudBackupStatus{}, false
+	}
+	nd.cloudBak.mtx.Lock()
+	defer nd.cloudBak.mtx.Unlock()
+	return nd.cloudBak.status, true
+}
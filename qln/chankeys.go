@@ -0,0 +1,123 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Every key a channel uses comes from the wallet seed, at
+
+	m / 44' / coin' / use' / peerIdx' / chanIdx'
+
+with use one of
+
+	20'   UseChannelFund         2 of 2 funding key
+	30'   UseChannelRefund       where my side of a close goes
+	31'   UseChannelWatchRefund  where watchtower justice txs pay me
+	40'   UseChannelHAKDBase     base point for per-state keys
+	8888' UseChannelElkrem       elkrem root (double sha256 of this pub)
+
+so nothing about a channel's keys is random.  The path is saved with the
+channel (Qchan.KeyGen, with use set to fund) and the channel's per-state
+keys all come from the base points and elkrem root above.
+
+After losing the DB, the seed alone doesn't say which peerIdx and chanIdx
+a channel had, but those are small and assigned in order, so they can be
+searched for: given the funding output and the peer's funding pubkey
+(which the peer can send, or which is in the close tx), FindChannelKeys
+tries every index pair until the 2 of 2 matches.  With the keys back, the
+peer can co-sign a close paying the refund key.
+*/
+
+// ChanKeyGen is the key path of a channel's funding key
+func ChanKeyGen(coin, peerIdx, cIdx uint32) portxo.KeyGen {
+	var k portxo.KeyGen
+	k.Depth = 5
+	k.Step[0] = 44 | 1<<31
+	k.Step[1] = coin | 1<<31
+	k.Step[2] = UseChannelFund
+	k.Step[3] = peerIdx | 1<<31
+	k.Step[4] = cIdx | 1<<31
+	return k
+}
+
+// ChannelKeys are the public keys of a channel, re-derived from the seed
+type ChannelKeys struct {
+	CoinType uint32
+	PeerIdx  uint32
+	ChanIdx  uint32
+	KeyPath  string // of the funding key
+
+	FundPub        [33]byte
+	RefundPub      [33]byte
+	WatchRefundPub [33]byte
+	HAKDBase       [33]byte
+}
+
+// DeriveChannelKeys derives the public keys of the channel at the given
+// indexes.  Doesn't look at the DB at all.  (The elkrem root is there
+// too, with GetElkremRoot, but it's secret so it's not in here.)
+func (nd *LitNode) DeriveChannelKeys(coin, peerIdx, cIdx uint32) (*ChannelKeys, error) {
+	k := ChanKeyGen(coin, peerIdx, cIdx)
+	ck := new(ChannelKeys)
+	ck.CoinType = coin
+	ck.PeerIdx = peerIdx
+	ck.ChanIdx = cIdx
+	ck.KeyPath = k.String()
+
+	var err error
+	ck.FundPub, err = nd.GetUsePub(k, UseChannelFund)
+	if err != nil {
+		return nil, err
+	}
+	ck.RefundPub, err = nd.GetUsePub(k, UseChannelRefund)
+	if err != nil {
+		return nil, err
+	}
+	ck.WatchRefundPub, err = nd.GetUsePub(k, UseChannelWatchRefund)
+	if err != nil {
+		return nil, err
+	}
+	ck.HAKDBase, err = nd.GetUsePub(k, UseChannelHAKDBase)
+	if err != nil {
+		return nil, err
+	}
+	return ck, nil
+}
+
+// FindChannelKeys searches peer and channel indexes up to maxPeer and
+// maxChan for the channel whose funding output has pkScript, given the
+// other side's funding pubkey.  Channel indexes are node-wide, not per
+// peer, so every pair gets tried.
+func (nd *LitNode) FindChannelKeys(coin uint32, pkScript []byte,
+	theirPub [33]byte, maxPeer, maxChan uint32) (*ChannelKeys, error) {
+
+	if nd.SubWallet[coin] == nil {
+		return nil, fmt.Errorf("coin type %d not in wallet", coin)
+	}
+	if maxPeer == 0 || maxChan == 0 {
+		return nil, fmt.Errorf("need a nonzero search range")
+	}
+	for cIdx := uint32(1); cIdx <= maxChan; cIdx++ {
+		for peerIdx := uint32(1); peerIdx <= maxPeer; peerIdx++ {
+			myPub, err := nd.GetUsePub(
+				ChanKeyGen(coin, peerIdx, cIdx), UseChannelFund)
+			if err != nil {
+				return nil, err
+			}
+			txo, err := lnutil.FundTxOut(myPub, theirPub, 0)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(txo.PkScript, pkScript) {
+				return nd.DeriveChannelKeys(coin, peerIdx, cIdx)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no channel key up to peer %d channel %d matches %x",
+		maxPeer, maxChan, pkScript)
+}
@@ -0,0 +1,275 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Everything that changes a channel goes through the channel's op queue, one
+at a time, in the order it came in: the peer's push / pull messages,
+pushes, resuming an update on reconnect, closes and breaks, and the
+justice sigs built after each update.  Otherwise an RPC push and a message
+from the peer can each load, change and save the same channel at once, and
+one of them loses.
+
+Each channel with ops waiting gets a goroutine running them, which is gone
+once the queue's empty.  Queues are by outpoint, so ops on different Qchan
+copies of the same channel still wait for each other.  chanDo waits for
+its op to run; chanLater doesn't, and is what an op uses to queue more for
+its own channel, since waiting on your own queue never comes back.
+
+A push is more than one op: the delta sig goes out in one, and each of the
+peer's answers comes in as another.  PushChannel waits for the rev outside
+the queue, on ClearToSend, as it always has.
+
+Each channel has a timeout, chanOpTimeout unless set with
+SetChanOpTimeout.  Once a caller's waited that long it gives up: the op's
+dropped if it hasn't started, and left to finish if it has.  An op still
+running after its timeout is taken as stuck, usually on something that's
+waiting for the channel itself, and is logged with what's queued behind
+it and published once as EventChanStuck.
+*/
+
+// how long an op can wait and run before it's taken as stuck
+const chanOpTimeout = 30 * time.Second
+
+// opRunningError is chanDo's error when it gave up on an op that had
+// already started, so the op may yet finish
+type opRunningError struct {
+	error
+}
+
+// chanOp is something to do to a channel
+type chanOp struct {
+	name   string
+	fn     func() error
+	done   chan error // nil if no one's waiting
+	queued time.Time
+}
+
+// chanQueue is a channel's ops: the one running and those waiting
+type chanQueue struct {
+	key              [36]byte
+	peerIdx, chanIdx uint32
+	running          *chanOp
+	started          time.Time
+	stuck            bool
+	pending          []*chanOp
+}
+
+type chanQueues struct {
+	mtx      sync.Mutex
+	queues   map[[36]byte]*chanQueue
+	timeouts map[[36]byte]time.Duration // set with SetChanOpTimeout
+}
+
+func newChanQueues() *chanQueues {
+	return &chanQueues{
+		queues:   make(map[[36]byte]*chanQueue),
+		timeouts: make(map[[36]byte]time.Duration),
+	}
+}
+
+// timeout is a channel's op timeout.  Hold mtx.
+func (cq *chanQueues) timeout(key [36]byte) time.Duration {
+	if t, ok := cq.timeouts[key]; ok {
+		return t
+	}
+	return chanOpTimeout
+}
+
+// ChanOpStatus is what a channel's op queue is doing
+type ChanOpStatus struct {
+	PeerIdx, ChanIdx uint32
+	Running          string // op running, if any
+	RunningFor       time.Duration
+	Stuck            bool     // running past its timeout
+	Waiting          []string // ops queued behind it, in order
+	Timeout          time.Duration
+}
+
+// ChanOpStatuses says what each channel with ops queued is doing
+func (nd *LitNode) ChanOpStatuses() []ChanOpStatus {
+	cq := nd.chanOps
+	cq.mtx.Lock()
+	defer cq.mtx.Unlock()
+	var s []ChanOpStatus
+	for key, c := range cq.queues {
+		st := ChanOpStatus{PeerIdx: c.peerIdx, ChanIdx: c.chanIdx,
+			Stuck: c.stuck, Timeout: cq.timeout(key)}
+		if c.running != nil {
+			st.Running = c.running.name
+			st.RunningFor = time.Since(c.started)
+		}
+		for _, op := range c.pending {
+			st.Waiting = append(st.Waiting, op.name)
+		}
+		s = append(s, st)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].ChanIdx < s[j].ChanIdx })
+	return s
+}
+
+// SetChanOpTimeout sets how long ops on a channel can wait and run before
+// they're taken as stuck.  0 goes back to chanOpTimeout.
+func (nd *LitNode) SetChanOpTimeout(q *Qchan, d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("negative timeout")
+	}
+	key := lnutil.OutPointToBytes(q.Op)
+	nd.chanOps.mtx.Lock()
+	if d == 0 {
+		delete(nd.chanOps.timeouts, key)
+	} else {
+		nd.chanOps.timeouts[key] = d
+	}
+	nd.chanOps.mtx.Unlock()
+	return nil
+}
+
+// chanDo runs fn in q's op queue and returns its error, or gives up after
+// the channel's timeout, with an opRunningError if fn had started.  Don't
+// call it from an op on the same channel.
+func (nd *LitNode) chanDo(q *Qchan, name string, fn func() error) error {
+	op := &chanOp{name: name, fn: fn, done: make(chan error, 1)}
+	timeout := nd.queueChanOp(q, op)
+	select {
+	case err := <-op.done:
+		return err
+	case <-time.After(timeout):
+	}
+
+	key := lnutil.OutPointToBytes(q.Op)
+	cq := nd.chanOps
+	cq.mtx.Lock()
+	defer cq.mtx.Unlock()
+	c := cq.queues[key]
+	if c != nil {
+		for i, p := range c.pending {
+			if p == op {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				return fmt.Errorf("channel %d busy; %s waited %s", q.Idx(),
+					name, timeout)
+			}
+		}
+	}
+	// it may have just finished
+	select {
+	case err := <-op.done:
+		return err
+	default:
+	}
+	return &opRunningError{fmt.Errorf("channel %d %s still going after %s",
+		q.Idx(), name, timeout)}
+}
+
+// chanLater queues fn on q without waiting for it.  Errors are logged.
+func (nd *LitNode) chanLater(q *Qchan, name string, fn func() error) {
+	nd.queueChanOp(q, &chanOp{name: name, fn: fn})
+}
+
+// queueChanOp puts op at the end of q's queue, starting it if need be, and
+// returns the channel's timeout
+func (nd *LitNode) queueChanOp(q *Qchan, op *chanOp) time.Duration {
+	op.queued = time.Now()
+	key := lnutil.OutPointToBytes(q.Op)
+	cq := nd.chanOps
+	cq.mtx.Lock()
+	defer cq.mtx.Unlock()
+	c, ok := cq.queues[key]
+	if !ok {
+		c = &chanQueue{key: key, peerIdx: q.Peer(), chanIdx: q.Idx()}
+		cq.queues[key] = c
+		go nd.runChanQueue(c)
+	}
+	c.pending = append(c.pending, op)
+	return cq.timeout(key)
+}
+
+// runChanQueue runs a channel's ops until there are none left
+func (nd *LitNode) runChanQueue(c *chanQueue) {
+	cq := nd.chanOps
+	alertID := fmt.Sprintf("chan op %x", c.key)
+	for {
+		cq.mtx.Lock()
+		if len(c.pending) == 0 {
+			delete(cq.queues, c.key)
+			cq.mtx.Unlock()
+			return
+		}
+		op := c.pending[0]
+		c.pending = c.pending[1:]
+		c.running, c.started, c.stuck = op, time.Now(), false
+		timeout := cq.timeout(c.key)
+		cq.mtx.Unlock()
+
+		watch := time.AfterFunc(timeout, func() { nd.chanStuck(c, op, timeout) })
+		err := op.fn()
+		watch.Stop()
+		if op.done != nil {
+			op.done <- err
+		} else if err != nil {
+			logger.Warnf("channel %d %s: %s\n", c.chanIdx, op.name, err.Error())
+		}
+
+		cq.mtx.Lock()
+		stuck := c.stuck
+		c.running, c.stuck = nil, false
+		cq.mtx.Unlock()
+		if stuck {
+			logger.Infof("channel %d %s done after %s\n",
+				c.chanIdx, op.name, time.Since(op.queued))
+			nd.alerts.clear(alertID)
+		}
+	}
+}
+
+// chanStuck logs an op that's run past its channel's timeout, and
+// publishes it the first time
+func (nd *LitNode) chanStuck(c *chanQueue, op *chanOp, timeout time.Duration) {
+	cq := nd.chanOps
+	cq.mtx.Lock()
+	if c.running != op {
+		cq.mtx.Unlock()
+		return
+	}
+	c.stuck = true
+	var waiting []string
+	for _, p := range c.pending {
+		waiting = append(waiting, p.name)
+	}
+	cq.mtx.Unlock()
+
+	detail := fmt.Sprintf("%s running over %s", op.name, timeout)
+	if len(waiting) > 0 {
+		detail += "; waiting: " + strings.Join(waiting, ", ")
+	}
+	logger.Errorf("channel %d stuck: %s\n", c.chanIdx, detail)
+	if nd.alerts.raise(fmt.Sprintf("chan op %x", c.key)) {
+		nd.PublishEvent(NodeEvent{Type: EventChanStuck, PeerIdx: c.peerIdx,
+			ChanIdx: c.chanIdx, Detail: detail})
+	}
+}
+
+// pushPullOpName names a push / pull message's op
+func pushPullOpName(msg lnutil.LitMsg) string {
+	switch msg.MsgType() {
+	case lnutil.MSGID_DELTASIG:
+		return "deltasig"
+	case lnutil.MSGID_SIGREV:
+		return "sigrev"
+	case lnutil.MSGID_GAPSIGREV:
+		return "gapsigrev"
+	case lnutil.MSGID_REV:
+		return "rev"
+	case lnutil.MSGID_PAYCLAIM:
+		return "payclaim"
+	}
+	return fmt.Sprintf("msg %x", msg.MsgType())
+}
@@ -0,0 +1,144 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Channels only find out they've closed from outpoint events, so one missed
+(the node was down, the wallet resynced, the outpoint never got registered)
+leaves a channel looking open after it's gone.  ChannelVerifier goes over
+the open channels on a coin when its wallet links and every
+chanVerifyInterval after that:
+
+- the funding output has to be what the channel says: right script, right
+  value.  If the wallet has the funding tx and it isn't, that's logged loudly;
+  there's nothing safe to do about it automatically.
+- the funding outpoint is re-registered with the wallet, in case it was lost.
+- if the wallet has a tx spending the funding outpoint, the spend is handled
+  as if the event had come in.  A tx we know (a state tx or the cooperative
+  close for the current state) just moves the channel to closed; anything
+  else may be an old revoked state, so it goes through breach handling too.
+
+"Unspent" only means as far as the wallet has synced.
+*/
+
+// how often to verify channels
+const chanVerifyInterval = 10 * time.Minute
+
+// ChannelVerifier verifies the channels on a coin.  Runs until shutdown.
+func (nd *LitNode) ChannelVerifier(coin uint32) {
+	for !nd.ShuttingDown() {
+		err := nd.VerifyChannels(coin)
+		if err != nil {
+			logger.Errorf("VerifyChannels coin %d: %s\n", coin, err.Error())
+		}
+		time.Sleep(chanVerifyInterval)
+	}
+}
+
+// VerifyChannels checks every open channel on a coin against the chain
+func (nd *LitNode) VerifyChannels(coin uint32) error {
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return fmt.Errorf("no wallet for coin %d", coin)
+	}
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return err
+	}
+	for _, q := range qcs {
+		if q.CloseData.Closed || q.Coin() != coin {
+			continue
+		}
+		err = nd.verifyChannel(wal, q)
+		if err != nil {
+			logger.Errorf("channel %d: %s\n", q.Idx(), err.Error())
+		}
+	}
+	return nil
+}
+
+func (nd *LitNode) verifyChannel(wal UWallet, q *Qchan) error {
+	err := verifyFundTx(wal, q)
+	if err != nil {
+		logger.Errorf("channel %d funding doesn't match: %s\n", q.Idx(), err.Error())
+	}
+
+	err = wal.WatchThis(q.Op)
+	if err != nil {
+		return err
+	}
+
+	spend, err := wal.SpendOf(q.Op)
+	if err != nil || spend == nil {
+		return err
+	}
+
+	txid := spend.TxHash()
+	if knownClose(q, txid) {
+		logger.Infof("channel %d closed by %s; catching up\n",
+			q.Idx(), txid.String())
+	} else {
+		logger.Warnf("channel %d spent by unknown tx %s; checking for breach\n",
+			q.Idx(), txid.String())
+	}
+	// we don't know when it was spent, but it can't have been after the
+	// current height.  Timelocks on our outputs count from then, so later
+	// is safe.
+	return nd.chanSpent(q, spend, wal.CurrentHeight())
+}
+
+// verifyFundTx checks the funding output, if the wallet has the funding tx
+func verifyFundTx(wal UWallet, q *Qchan) error {
+	fundTx, err := wal.GetTx(&q.Op.Hash)
+	if err != nil {
+		// only the funder's wallet has it for sure
+		return nil
+	}
+	if int(q.Op.Index) >= len(fundTx.TxOut) {
+		return fmt.Errorf("tx %s has no output %d",
+			q.Op.Hash.String(), q.Op.Index)
+	}
+	out := fundTx.TxOut[q.Op.Index]
+
+	fundScript, _, err := lnutil.FundTxScript(q.MyPub, q.TheirPub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(out.PkScript, lnutil.P2WSHify(fundScript)) {
+		return fmt.Errorf("output %s script %x isn't the channel 2 of 2",
+			q.Op.String(), out.PkScript)
+	}
+	if out.Value != q.Value {
+		return fmt.Errorf("output %s has %d, channel has %d",
+			q.Op.String(), out.Value, q.Value)
+	}
+	return nil
+}
+
+// knownClose says whether txid is a close we know about: the one recorded,
+// either side's current state tx, or the cooperative close.
+func knownClose(q *Qchan, txid chainhash.Hash) bool {
+	if txid == q.CloseData.CloseTxid {
+		return true
+	}
+	builders := []func() (*wire.MsgTx, error){
+		func() (*wire.MsgTx, error) { return q.BuildStateTx(true) },
+		func() (*wire.MsgTx, error) { return q.BuildStateTx(false) },
+		q.SimpleCloseTx,
+	}
+	for _, build := range builders {
+		tx, err := build()
+		if err == nil && tx.TxHash() == txid {
+			return true
+		}
+	}
+	return false
+}
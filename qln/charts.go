@@ -0,0 +1,195 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+Chart points are snapshots of the node taken every so often, so a
+dashboard can chart how it's doing over time without asking for
+everything and adding it up itself.  Each has the on-chain and channel
+balance of every wallet, and what we have in each open channel.
+
+They go in BKTCharts keyed like the other history buckets (history.go),
+by time then sequence, so a range is a seek and a walk.  A point with 2
+coins and 10 channels is under 200 bytes; at the default interval that's
+about 10MB a year.
+
+ChartData can thin a range out to one point per step, for charts over
+long stretches: each step gets its last point.
+*/
+
+// how often a chart point is taken, unless StartCharts is told otherwise
+const defaultChartInterval = 10 * time.Minute
+
+// ChartCoin is a wallet's balances at a chart point
+type ChartCoin struct {
+	CoinType uint32
+	Chain    int64 // all the wallet's outputs, confirmed or not
+	Channels int64 // ours in open channels
+}
+
+// ChartChan is what we have in an open channel at a chart point
+type ChartChan struct {
+	ChanIdx uint32
+	Local   int64
+}
+
+// ChartPoint is a snapshot of the node's balances
+type ChartPoint struct {
+	Time  int64 // unix nanoseconds
+	Coins []ChartCoin
+	Chans []ChartChan
+}
+
+// ToBytes serializes a ChartPoint: time, then the coins and the channels,
+// each with a 4 byte count
+func (p *ChartPoint) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, p.Time)
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Coins)))
+	for _, c := range p.Coins {
+		binary.Write(&buf, binary.BigEndian, c.CoinType)
+		binary.Write(&buf, binary.BigEndian, c.Chain)
+		binary.Write(&buf, binary.BigEndian, c.Channels)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Chans)))
+	for _, c := range p.Chans {
+		binary.Write(&buf, binary.BigEndian, c.ChanIdx)
+		binary.Write(&buf, binary.BigEndian, c.Local)
+	}
+	return buf.Bytes()
+}
+
+// ChartPointFromBytes deserializes a ChartPoint
+func ChartPointFromBytes(b []byte) (ChartPoint, error) {
+	var p ChartPoint
+	if len(b) < 16 {
+		return p, fmt.Errorf("%d bytes, chart point needs at least 16", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &p.Time)
+	var nCoins, nChans uint32
+	binary.Read(buf, binary.BigEndian, &nCoins)
+	if uint64(buf.Len()) < uint64(nCoins)*20+4 {
+		return p, fmt.Errorf("chart point coins truncated")
+	}
+	p.Coins = make([]ChartCoin, nCoins)
+	for i := range p.Coins {
+		binary.Read(buf, binary.BigEndian, &p.Coins[i].CoinType)
+		binary.Read(buf, binary.BigEndian, &p.Coins[i].Chain)
+		binary.Read(buf, binary.BigEndian, &p.Coins[i].Channels)
+	}
+	binary.Read(buf, binary.BigEndian, &nChans)
+	if uint64(buf.Len()) != uint64(nChans)*12 {
+		return p, fmt.Errorf("chart point channels %d bytes, expect %d",
+			buf.Len(), nChans*12)
+	}
+	p.Chans = make([]ChartChan, nChans)
+	for i := range p.Chans {
+		binary.Read(buf, binary.BigEndian, &p.Chans[i].ChanIdx)
+		binary.Read(buf, binary.BigEndian, &p.Chans[i].Local)
+	}
+	return p, nil
+}
+
+// StartCharts takes a chart point every interval; defaultChartInterval if
+// it's 0, and never if it's negative
+func (nd *LitNode) StartCharts(interval time.Duration) {
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultChartInterval
+	}
+	go func() {
+		for !nd.ShuttingDown() {
+			time.Sleep(interval)
+			_, err := nd.TakeChartPoint()
+			if err != nil {
+				logger.Errorf("chart point failed: %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+// TakeChartPoint takes and saves a chart point as of now
+func (nd *LitNode) TakeChartPoint() (ChartPoint, error) {
+	p := ChartPoint{Time: time.Now().UnixNano()}
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return p, err
+	}
+	coins := make(map[uint32]*ChartCoin)
+	for cointype, wal := range nd.SubWallet {
+		utxos, err := wal.UtxoDump()
+		if err != nil {
+			return p, err
+		}
+		coins[cointype] = &ChartCoin{CoinType: cointype}
+		for _, u := range utxos {
+			coins[cointype].Chain += u.Value
+		}
+	}
+	for _, q := range qcs {
+		if q.CloseData.Closed {
+			continue
+		}
+		p.Chans = append(p.Chans, ChartChan{ChanIdx: q.Idx(), Local: q.State.MyAmt})
+		c, ok := coins[q.Coin()]
+		if !ok {
+			c = &ChartCoin{CoinType: q.Coin()}
+			coins[q.Coin()] = c
+		}
+		c.Channels += q.State.MyAmt
+	}
+	for _, c := range coins {
+		p.Coins = append(p.Coins, *c)
+	}
+	sort.Slice(p.Coins, func(i, j int) bool {
+		return p.Coins[i].CoinType < p.Coins[j].CoinType
+	})
+	sort.Slice(p.Chans, func(i, j int) bool {
+		return p.Chans[i].ChanIdx < p.Chans[j].ChanIdx
+	})
+
+	return p, nd.putHistory(BKTCharts, p.Time, p.ToBytes())
+}
+
+// ChartData returns chart points with start <= time < end (unix
+// nanoseconds), oldest first.  With a step (nanoseconds) of more than 0
+// there's at most one point per step, counted from start, the last in it.
+// At most max are returned (0 for all).
+func (nd *LitNode) ChartData(
+	start, end, step int64, max uint32) ([]ChartPoint, error) {
+	var ps []ChartPoint
+	var lastStep int64
+	err := nd.getHistory(BKTCharts, start, end, 0, func(v []byte) error {
+		p, err := ChartPointFromBytes(v)
+		if err != nil {
+			return err
+		}
+		if step > 0 {
+			s := (p.Time - start) / step
+			if len(ps) > 0 && s == lastStep {
+				ps[len(ps)-1] = p
+				return nil
+			}
+			lastStep = s
+		}
+		ps = append(ps, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if max != 0 && uint32(len(ps)) > max {
+		ps = ps[:max]
+	}
+	return ps, nil
+}
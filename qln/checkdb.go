@@ -0,0 +1,349 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+CheckDB goes through every bucket in the lit DB (and the wallet and
+watchtower DBs, if they can check themselves) and makes sure everything in
+them deserializes, and that the index mappings agree with what they map
+to.  It only reads unless told to repair, and the only repairs are ones
+that can't lose anything: putting back a missing index mapping when the
+channel or peer it's for says what its index is.  Everything else is just
+reported; a channel that doesn't deserialize needs a person to look at it.
+*/
+
+// DBCheck is what checking one DB found
+type DBCheck struct {
+	DB       string
+	Problems []string
+}
+
+// dbChecker is a wallet or tower which can check its own DB
+type dbChecker interface {
+	CheckDB(repair bool) ([]string, error)
+}
+
+// CheckDB checks the lit DB, then each wallet's and the tower's
+func (nd *LitNode) CheckDB(repair bool) ([]DBCheck, error) {
+	problems, err := nd.checkLitDB(repair)
+	if err != nil {
+		return nil, err
+	}
+	checks := []DBCheck{{DB: "lit", Problems: problems}}
+
+	for coin, wal := range nd.SubWallet {
+		c, ok := wal.(dbChecker)
+		if !ok {
+			continue
+		}
+		problems, err = c.CheckDB(repair)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, DBCheck{
+			DB: fmt.Sprintf("wallet %d", coin), Problems: problems})
+	}
+
+	if c, ok := nd.Tower.(dbChecker); ok {
+		problems, err = c.CheckDB(repair)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, DBCheck{DB: "watchtower", Problems: problems})
+	}
+	return checks, nil
+}
+
+func (nd *LitNode) checkLitDB(repair bool) ([]string, error) {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	txFunc := nd.LitDB.View
+	if repair {
+		txFunc = nd.LitDB.Update
+	}
+	err := txFunc(func(btx *bolt.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		cmp := btx.Bucket(BKTChanMap)
+		prs := btx.Bucket(BKTPeers)
+		pmp := btx.Bucket(BKTPeerMap)
+		if cbk == nil || cmp == nil || prs == nil || pmp == nil {
+			return fmt.Errorf("channel or peer buckets missing")
+		}
+
+		// peers, by index, from the peer buckets
+		peerIdxs := make(map[uint32][]byte)
+		err := prs.ForEach(func(pub, _ []byte) error {
+			pb := prs.Bucket(pub)
+			if pb == nil {
+				report("peer %x not a bucket", pub)
+				return nil
+			}
+			idxBytes := pb.Get(KEYIdx)
+			if len(idxBytes) != 4 {
+				report("peer %x index %x not 4 bytes", pub, idxBytes)
+				return nil
+			}
+			peerIdxs[lnutil.BtU32(idxBytes)] = pub
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// peer map has to match
+		err = pmp.ForEach(func(k, v []byte) error {
+			if len(k) != 4 || len(v) != 33 {
+				report("peer map entry %x : %x wrong size", k, v)
+				return nil
+			}
+			idx := lnutil.BtU32(k)
+			pub, ok := peerIdxs[idx]
+			if !ok {
+				report("peer map %d : %x has no peer with that index", idx, v)
+			} else if !bytes.Equal(pub, v) {
+				report("peer map %d : %x but peer %x has that index", idx, v, pub)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for idx, pub := range peerIdxs {
+			if pmp.Get(lnutil.U32tB(idx)) != nil {
+				continue
+			}
+			if !repair {
+				report("peer %x index %d not in peer map", pub, idx)
+				continue
+			}
+			err = pmp.Put(lnutil.U32tB(idx), pub)
+			if err != nil {
+				return err
+			}
+			report("peer %x index %d not in peer map (fixed)", pub, idx)
+		}
+
+		// channels
+		chanIdxs := make(map[uint32][]byte)
+		err = cbk.ForEach(func(opBytes, _ []byte) error {
+			qb := cbk.Bucket(opBytes)
+			if qb == nil || len(opBytes) != 36 {
+				report("channel entry %x not an outpoint bucket", opBytes)
+				return nil
+			}
+			var opArr [36]byte
+			copy(opArr[:], opBytes)
+			op := lnutil.OutPointFromBytes(opArr)
+
+			q, err := QchanFromBytes(qb.Get(KEYutxo))
+			if err != nil {
+				report("channel %s: %s", op.String(), err.Error())
+				return nil
+			}
+			if !lnutil.OutPointsEqual(q.Op, *op) {
+				report("channel %s says it's %s", op.String(), q.Op.String())
+			}
+			_, err = QCloseFromBytes(qb.Get(KEYqclose))
+			if err != nil {
+				report("channel %d close data: %s", q.Idx(), err.Error())
+			}
+			stBytes := qb.Get(KEYState)
+			if stBytes != nil {
+				_, err = StatComFromBytes(stBytes)
+				if err != nil {
+					report("channel %d state: %s", q.Idx(), err.Error())
+				}
+			}
+			elkr, err := elkrem.ElkremReceiverFromBytes(qb.Get(KEYElkRecv))
+			if err == nil {
+				err = elkr.Verify()
+			}
+			if err != nil {
+				report("channel %d elkrem receiver: %s", q.Idx(), err.Error())
+			}
+			if _, ok := peerIdxs[q.Peer()]; !ok {
+				report("channel %d peer %d unknown", q.Idx(), q.Peer())
+			}
+			if other, ok := chanIdxs[q.Idx()]; ok {
+				report("channels %x and %s both index %d", other, op.String(), q.Idx())
+			}
+			chanIdxs[q.Idx()] = opBytes
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// channel map has to match
+		err = cmp.ForEach(func(k, v []byte) error {
+			if len(k) != 4 || len(v) != 36 {
+				report("channel map entry %x : %x wrong size", k, v)
+				return nil
+			}
+			idx := lnutil.BtU32(k)
+			opBytes, ok := chanIdxs[idx]
+			if !ok {
+				report("channel map %d : %x has no channel with that index", idx, v)
+			} else if !bytes.Equal(opBytes, v) {
+				report("channel map %d : %x but channel %x has that index",
+					idx, v, opBytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for idx, opBytes := range chanIdxs {
+			if cmp.Get(lnutil.U32tB(idx)) != nil {
+				continue
+			}
+			if !repair {
+				report("channel %x index %d not in channel map", opBytes, idx)
+				continue
+			}
+			err = cmp.Put(lnutil.U32tB(idx), opBytes)
+			if err != nil {
+				return err
+			}
+			report("channel %x index %d not in channel map (fixed)", opBytes, idx)
+		}
+
+		// justice sigs: a bucket per refund pkh, state : txid[:16] sig [hashtype]
+		wb := btx.Bucket(BKTWatch)
+		if wb != nil {
+			err = wb.ForEach(func(pkh, _ []byte) error {
+				jb := wb.Bucket(pkh)
+				if jb == nil || len(pkh) != 20 {
+					report("justice entry %x not a pkh bucket", pkh)
+					return nil
+				}
+				return jb.ForEach(func(k, v []byte) error {
+					if len(k) != 8 || (len(v) != 80 && len(v) != 81) {
+						report("justice sig %x under %x wrong size", k, pkh)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// everything else is one record per key
+		records := []struct {
+			name   string
+			bucket []byte
+			check  func([]byte) error
+		}{
+			{"payment", BKTPayments, func(b []byte) error {
+				_, err := PaymentRecordFromBytes(b)
+				return err
+			}},
+			{"invoice", BKTInvoices, func(b []byte) error {
+				_, err := InvoiceFromBytes(b)
+				return err
+			}},
+			{"invoice hints", BKTInvoiceHints, func(b []byte) error {
+				_, err := routeHintsFromBytes(b)
+				return err
+			}},
+			{"invoice metadata", BKTInvoiceMeta, func(b []byte) error {
+				buf := bytes.NewBuffer(b)
+				_, err := metaFromBytes(buf)
+				if err == nil {
+					_, err = metaFromBytes(buf)
+				}
+				return err
+			}},
+			{"lease", BKTLeases, func(b []byte) error {
+				_, err := LeaseFromBytes(b)
+				return err
+			}},
+			{"pay key", BKTPayKeys, func(b []byte) error {
+				_, err := PayKeyRecordFromBytes(b)
+				return err
+			}},
+			{"paid hash", BKTPaidHashes, func(b []byte) error {
+				if len(b) != 4 {
+					return fmt.Errorf("%d bytes, expect 4", len(b))
+				}
+				return nil
+			}},
+			{"swap", BKTSwaps, func(b []byte) error {
+				_, err := SwapFromBytes(b)
+				return err
+			}},
+			{"fee policy", BKTFeePolicy, func(b []byte) error {
+				_, err := FeePolicyFromBytes(b)
+				return err
+			}},
+			{"sync change", BKTSyncLog, func(b []byte) error {
+				_, _, err := syncChangeFromBytes(b)
+				return err
+			}},
+			{"tower", BKTTowers, func(b []byte) error {
+				_, err := knownTowerFromBytes(b)
+				return err
+			}},
+			{"order", BKTOrders, func(b []byte) error {
+				_, err := OrderFromBytes(b)
+				return err
+			}},
+			{"tower outbox", BKTTowerBox, func(b []byte) error {
+				_, err := nd.openTowerMsg(b)
+				return err
+			}},
+			{"client tower", BKTClientTowers, func(b []byte) error {
+				_, err := clientTowerFromBytes(b)
+				return err
+			}},
+			{"chart point", BKTCharts, func(b []byte) error {
+				_, err := ChartPointFromBytes(b)
+				return err
+			}},
+			{"peer stats", BKTPeerStats, func(b []byte) error {
+				_, err := PeerStatsFromBytes(b)
+				return err
+			}},
+			{"incoming push", BKTPushesIn, func(b []byte) error {
+				if len(b) != 8 {
+					return fmt.Errorf("%d bytes, expect 8", len(b))
+				}
+				return nil
+			}},
+			{"outgoing push", BKTPushesOut, func(b []byte) error {
+				if len(b) != 40 {
+					return fmt.Errorf("%d bytes, expect 40", len(b))
+				}
+				return nil
+			}},
+		}
+		for _, r := range records {
+			bkt := btx.Bucket(r.bucket)
+			if bkt == nil {
+				report("no %s bucket", r.name)
+				continue
+			}
+			err = bkt.ForEach(func(k, v []byte) error {
+				err := r.check(v)
+				if err != nil {
+					report("%s %x: %s", r.name, k, err.Error())
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return problems, err
+}
@@ -0,0 +1,314 @@
+package qln
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/migrate"
+)
+
+/*
+Before something that's hard to take back, the node writes a checkpoint: a
+copy of ln.db as it was, labeled with what was about to happen, in
+checkpoints/<id> in the lit folder.  That's before each force close and
+before migrating ln.db.  (There's no splicing yet; when there is, it goes
+here too.)
+
+Rollback puts a checkpoint back, as long as nothing went out because of
+it.  A force close's checkpoint is only good until its tx is handed to the
+wallet to broadcast; once anyone might have seen it, the channel's closing
+whatever the DB says.  If the broadcast fails, the channel's marked closed
+with nothing on chain, and rolling back opens it again.
+
+A force close rolls back only its channel, so whatever else the node did
+since stays.  A migration's checkpoint is the whole DB in the older
+format, which this lit can't run on; it's put back by hand, with lit
+stopped, by copying it over ln.db and running the lit that wrote it.
+
+The newest checkpointKeep are kept.
+*/
+
+const (
+	checkpointDir  = "checkpoints"
+	checkpointMeta = "checkpoint.json"
+	checkpointKeep = 50
+)
+
+// Checkpoint is a copy of ln.db from before something risky
+type Checkpoint struct {
+	ID    string
+	Label string
+	Time  time.Time
+	Path  string // the copy of ln.db
+
+	// the channel it's for; Op is empty if it's the whole DB
+	ChanIdx uint32 `json:",omitempty"`
+	Op      string `json:",omitempty"`
+	Schema  uint32 // ln.db schema version when it was taken
+
+	// set once the tx it was for might be out; it can't be rolled back then
+	Broadcast  string `json:",omitempty"`
+	RolledBack bool   `json:",omitempty"`
+}
+
+func (nd *LitNode) checkpointsDir() string {
+	return filepath.Join(nd.Dirs.Lit, checkpointDir)
+}
+
+// writeCheckpoint copies ln.db to a new checkpoint.  qc is the channel it's
+// for, or nil for the whole DB.
+func (nd *LitNode) writeCheckpoint(label string, qc *Qchan) (*Checkpoint, error) {
+	now := time.Now()
+	cp := &Checkpoint{
+		ID:    fmt.Sprintf("%d", now.UnixNano()),
+		Label: label,
+		Time:  now,
+	}
+	if qc != nil {
+		cp.ChanIdx = qc.Idx()
+		cp.Op = qc.Op.String()
+	}
+	var err error
+	cp.Schema, err = migrate.Version(nd.LitDB)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(nd.checkpointsDir(), cp.ID)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	cp.Path = filepath.Join(dir, "ln.db")
+	err = nd.LitDB.View(func(btx *bolt.Tx) error {
+		return btx.CopyFile(cp.Path, 0600)
+	})
+	if err == nil {
+		err = saveCheckpoint(cp)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	logger.Infof("checkpoint %s: %s\n", cp.ID, label)
+	err = pruneCheckpoints(nd.checkpointsDir(), checkpointKeep)
+	if err != nil {
+		logger.Errorf("pruning checkpoints: %s\n", err.Error())
+	}
+	return cp, nil
+}
+
+func saveCheckpoint(cp *Checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(cp.Path), checkpointMeta)
+	err = ioutil.WriteFile(path+".tmp", b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// markBroadcast says cp's tx is on its way out; "" says it didn't go
+func markBroadcast(cp *Checkpoint, txid string) {
+	cp.Broadcast = txid
+	err := saveCheckpoint(cp)
+	if err != nil {
+		logger.Errorf("checkpoint %s: %s\n", cp.ID, err.Error())
+	}
+}
+
+// ListCheckpoints returns the checkpoints there are, oldest first
+func (nd *LitNode) ListCheckpoints() ([]Checkpoint, error) {
+	metas, err := filepath.Glob(
+		filepath.Join(nd.checkpointsDir(), "*", checkpointMeta))
+	if err != nil {
+		return nil, err
+	}
+	var cps []Checkpoint
+	for _, meta := range metas {
+		cp, err := loadCheckpoint(meta)
+		if err != nil {
+			return nil, err
+		}
+		cps = append(cps, *cp)
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].Time.Before(cps[j].Time) })
+	return cps, nil
+}
+
+func loadCheckpoint(meta string) (*Checkpoint, error) {
+	b, err := ioutil.ReadFile(meta)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	err = json.Unmarshal(b, cp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", meta, err.Error())
+	}
+	// the folder may have moved since
+	cp.Path = filepath.Join(filepath.Dir(meta), "ln.db")
+	return cp, nil
+}
+
+// pruneCheckpoints deletes all but the newest keep checkpoints in dir
+func pruneCheckpoints(dir string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*", checkpointMeta))
+	if err != nil {
+		return err
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	// ids are nanosecond times, the same length for the next few centuries
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		err = os.RemoveAll(filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback puts a channel checkpoint back, if its tx never went out
+func (nd *LitNode) Rollback(id string) (*Checkpoint, error) {
+	cp, err := loadCheckpoint(filepath.Join(nd.checkpointsDir(), id, checkpointMeta))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no checkpoint %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cp.RolledBack {
+		return nil, fmt.Errorf("checkpoint %s already rolled back", id)
+	}
+	if cp.Broadcast != "" {
+		return nil, fmt.Errorf("can't roll back %s: tx %s was broadcast",
+			id, cp.Broadcast)
+	}
+	if cp.Op == "" {
+		return nil, fmt.Errorf("checkpoint %s is all of ln.db at schema "+
+			"version %d; stop lit and put %s back by hand",
+			id, cp.Schema, cp.Path)
+	}
+
+	qc, err := nd.GetQchanByIdx(cp.ChanIdx)
+	if err != nil {
+		return nil, err
+	}
+	if qc.Op.String() != cp.Op {
+		return nil, fmt.Errorf("channel %d is %s now, checkpoint has %s",
+			cp.ChanIdx, qc.Op.String(), cp.Op)
+	}
+	if qc.CloseData.CloseHeight != 0 {
+		return nil, fmt.Errorf("channel %d closed on chain at height %d",
+			cp.ChanIdx, qc.CloseData.CloseHeight)
+	}
+
+	snap, err := bolt.Open(cp.Path, 0600,
+		&bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	opArr := lnutil.OutPointToBytes(qc.Op)
+	err = snap.View(func(stx *bolt.Tx) error {
+		from := stx.Bucket(BKTChannel)
+		if from != nil {
+			from = from.Bucket(opArr[:])
+		}
+		if from == nil {
+			return fmt.Errorf("checkpoint %s has no channel %s", id, cp.Op)
+		}
+		return nd.LitDB.Update(func(btx *bolt.Tx) error {
+			cbk := btx.Bucket(BKTChannel)
+			if cbk == nil {
+				return fmt.Errorf("no channels")
+			}
+			// only if the channel's where it was: a later state isn't
+			// ours to throw away
+			err := sameChanState(cbk.Bucket(opArr[:]), from)
+			if err != nil {
+				return err
+			}
+			err = cbk.DeleteBucket(opArr[:])
+			if err != nil {
+				return err
+			}
+			to, err := cbk.CreateBucket(opArr[:])
+			if err != nil {
+				return err
+			}
+			return copyBucket(to, from)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the connected peer's copy has to match too
+	nd.RemoteMtx.Lock()
+	if peer, ok := nd.RemoteCons[qc.Peer()]; ok {
+		if pqc, ok := peer.QCs[qc.Idx()]; ok {
+			err = nd.ReloadQchanState(pqc)
+		}
+	}
+	nd.RemoteMtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	cp.RolledBack = true
+	err = saveCheckpoint(cp)
+	if err != nil {
+		return nil, err
+	}
+	logger.Warnf("rolled back channel %d to checkpoint %s: %s\n",
+		cp.ChanIdx, id, cp.Label)
+	return cp, nil
+}
+
+// sameChanState errors unless two channel buckets are at the same state
+func sameChanState(live, snap *bolt.Bucket) error {
+	if live == nil {
+		return fmt.Errorf("channel not in db")
+	}
+	liveState, err := StatComFromBytes(live.Get(KEYState))
+	if err != nil {
+		return err
+	}
+	snapState, err := StatComFromBytes(snap.Get(KEYState))
+	if err != nil {
+		return err
+	}
+	if liveState.StateIdx != snapState.StateIdx {
+		return fmt.Errorf("channel's at state %d, checkpoint has %d",
+			liveState.StateIdx, snapState.StateIdx)
+	}
+	return nil
+}
+
+// copyBucket copies everything in from, sub buckets too, into to
+func copyBucket(to, from *bolt.Bucket) error {
+	return from.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return to.Put(k, v)
+		}
+		sub, err := to.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(sub, from.Bucket(k))
+	})
+}
@@ -3,7 +3,6 @@ package qln
 import (
 	"bytes"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/txscript"
@@ -25,8 +24,12 @@ doesn't reply, as the channel is closed.
 
 */
 
-// CoopClose requests a cooperative close of the channel
+// CoopClose requests a cooperative close of the channel, in its op queue
 func (nd *LitNode) CoopClose(q *Qchan) error {
+	return nd.chanDo(q, "close", func() error { return nd.coopClose(q) })
+}
+
+func (nd *LitNode) coopClose(q *Qchan) error {
 
 	nd.RemoteMtx.Lock()
 	_, ok := nd.RemoteCons[q.Peer()]
@@ -40,6 +43,12 @@ func (nd *LitNode) CoopClose(q *Qchan) error {
 			q.KeyGen.Step[3]&0x7fffffff, q.KeyGen.Step[4]&0x7fffffff)
 	}
 
+	// we said we'd keep leased channels open; see leases.go
+	err := nd.leaseHolds(q)
+	if err != nil {
+		return err
+	}
+
 	tx, err := q.SimpleCloseTx()
 	if err != nil {
 		return err
@@ -85,12 +94,22 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	// get channel
 	q, err := nd.GetQchan(opArr)
 	if err != nil {
-		log.Printf("CloseReqHandler GetQchan err %s", err.Error())
+		logger.Errorf("CloseReqHandler GetQchan err %s", err.Error())
 		return
 	}
+	err = nd.chanDo(q, "close req", func() error {
+		nd.closeReq(msg, q)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("CloseReqHandler err %s", err.Error())
+	}
+}
 
+// closeReq signs and broadcasts the close a peer's asked for
+func (nd *LitNode) closeReq(msg lnutil.CloseReqMsg, q *Qchan) {
 	if nd.SubWallet[q.Coin()] == nil {
-		log.Printf("Not connected to coin type %d\n", q.Coin())
+		logger.Infof("Not connected to coin type %d\n", q.Coin())
 	}
 
 	// verify their sig?  should do that before signing our side just to be safe
@@ -99,14 +118,14 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	// build close tx
 	tx, err := q.SimpleCloseTx()
 	if err != nil {
-		log.Printf("CloseReqHandler SimpleCloseTx err %s", err.Error())
+		logger.Errorf("CloseReqHandler SimpleCloseTx err %s", err.Error())
 		return
 	}
 
 	// sign close
 	mySig, err := nd.SignSimpleClose(q, tx)
 	if err != nil {
-		log.Printf("CloseReqHandler SignSimpleClose err %s", err.Error())
+		logger.Errorf("CloseReqHandler SignSimpleClose err %s", err.Error())
 		return
 	}
 
@@ -119,7 +138,7 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 
 	pre, swap, err := lnutil.FundTxScript(q.MyPub, q.TheirPub)
 	if err != nil {
-		log.Printf("CloseReqHandler FundTxScript err %s", err.Error())
+		logger.Errorf("CloseReqHandler FundTxScript err %s", err.Error())
 		return
 	}
 
@@ -129,21 +148,21 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	} else {
 		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, myBigSig, theirBigSig)
 	}
-	log.Printf(lnutil.TxToString(tx))
+	logger.Infof(lnutil.TxToString(tx))
 
 	// save channel state to db as closed.
 	q.CloseData.Closed = true
 	q.CloseData.CloseTxid = tx.TxHash()
 	err = nd.SaveQchanUtxoData(q)
 	if err != nil {
-		log.Printf("CloseReqHandler SaveQchanUtxoData err %s", err.Error())
+		logger.Errorf("CloseReqHandler SaveQchanUtxoData err %s", err.Error())
 		return
 	}
 
 	// broadcast
 	err = nd.SubWallet[q.Coin()].PushTx(tx)
 	if err != nil {
-		log.Printf("CloseReqHandler NewOutgoingTx err %s", err.Error())
+		logger.Errorf("CloseReqHandler NewOutgoingTx err %s", err.Error())
 		return
 	}
 
@@ -180,6 +199,16 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		}
 	}
 
+	// a coop close paying our upfront shutdown script; the money's gone
+	// to the cold wallet and there's nothing for this one to grab
+	if !pkhIsMine && len(q.MyShutdown) > 0 {
+		for _, out := range tx.TxOut {
+			if bytes.Equal(q.MyShutdown, out.PkScript) {
+				return nil, nil
+			}
+		}
+	}
+
 	// if pkh is mine, grab it.
 	if pkhIsMine {
 		fmt.Printf("got PKH output from channel close")
@@ -262,8 +291,10 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		shTxo.Seq = uint32(q.Delay)
 		shTxo.PreSigStack = make([][]byte, 1) // revoke SH has one presig item
 		shTxo.PreSigStack[0] = nil            // and that item is a nil (timeout)
+		shTxo.Hint = portxo.SpendHintCSV
 
-		shTxo.PkScript = script
+		shTxo.PkScript = tx.TxOut[shIdx].PkScript
+		shTxo.WitScript = script
 		cTxos[0] = shTxo
 	}
 
@@ -309,7 +340,8 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		// just return the elkScalar and let
 		// something modify it before export due to the seq=1 flag.
 
-		shTxo.PkScript = script
+		shTxo.PkScript = tx.TxOut[shIdx].PkScript
+		shTxo.WitScript = script
 		shTxo.Value = tx.TxOut[shIdx].Value
 		shTxo.Mode = portxo.TxoP2WSHComp
 		shTxo.Seq = 1                         // 1 means grab immediately
@@ -0,0 +1,129 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/adiabat/btcd/txscript"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// CommitOutput is one output of a state tx
+type CommitOutput struct {
+	Index    uint32
+	Value    int64
+	PkScript string // hex
+	Kind     string // "commit" (timelocked p2wsh) or "refund" (p2wpkh)
+	Mine     bool   // whether it ends up ours, if the tx isn't revoked
+
+	// commit outputs only: the script the p2wsh commits to
+	Script       string // hex
+	ScriptDisasm string
+	Delay        uint16
+}
+
+// CommitmentDump is a state tx and everything that went into it
+type CommitmentDump struct {
+	ChanIdx  uint32
+	StateIdx uint64
+	Remote   bool // their state tx rather than mine
+
+	Txid   string
+	Tx     string // hex
+	Signed bool   // both sigs on; only ever my tx
+
+	Capacity int64
+	MyAmt    int64
+	Fee      int64 // each side's share
+
+	FundScript string // hex; the 2 of 2 the tx spends
+	TheirSig   string // hex; their sig on my current state
+
+	Outputs []CommitOutput
+}
+
+// DumpCommitment describes the current state tx for a channel: mine, or
+// theirs if remote is set.  My tx comes fully signed if I have their sig;
+// that's the tx BreakChannel would broadcast.  Don't hang on to it: once
+// the state moves on it's revoked, and broadcasting it loses the channel.
+func (nd *LitNode) DumpCommitment(cIdx uint32, remote bool) (*CommitmentDump, error) {
+	q, err := nd.GetQchanByIdx(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	if q.CloseData.Closed {
+		return nil, fmt.Errorf("channel %d closed", cIdx)
+	}
+
+	d := new(CommitmentDump)
+	d.ChanIdx = cIdx
+	d.StateIdx = q.State.StateIdx
+	d.Remote = remote
+	d.Capacity = q.Value
+	d.MyAmt = q.State.MyAmt
+	d.Fee = q.State.Fee
+
+	fundScript, _, err := lnutil.FundTxScript(q.MyPub, q.TheirPub)
+	if err != nil {
+		return nil, err
+	}
+	d.FundScript = hex.EncodeToString(fundScript)
+
+	var empty [64]byte
+	if q.State.sig != empty {
+		d.TheirSig = hex.EncodeToString(q.State.sig[:])
+	}
+
+	fancyScript, _, _, _, err := q.stateOutputs(!remote)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := q.BuildStateTx(!remote)
+	if err != nil {
+		return nil, err
+	}
+	if !remote && q.State.sig != empty {
+		tx, err = nd.signMyStateTx(q)
+		if err != nil {
+			return nil, err
+		}
+		d.Signed = true
+	}
+
+	var buf bytes.Buffer
+	err = tx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	d.Tx = hex.EncodeToString(buf.Bytes())
+	d.Txid = tx.TxHash().String()
+
+	disasm, err := txscript.DisasmString(fancyScript)
+	if err != nil {
+		return nil, err
+	}
+	fancyPk := lnutil.P2WSHify(fancyScript)
+
+	// the commit output belongs to whoever's tx it is, the refund to the other
+	for i, out := range tx.TxOut {
+		o := CommitOutput{
+			Index:    uint32(i),
+			Value:    out.Value,
+			PkScript: hex.EncodeToString(out.PkScript),
+		}
+		if bytes.Equal(out.PkScript, fancyPk) {
+			o.Kind = "commit"
+			o.Mine = !remote
+			o.Script = hex.EncodeToString(fancyScript)
+			o.ScriptDisasm = disasm
+			o.Delay = q.Delay
+		} else {
+			o.Kind = "refund"
+			o.Mine = remote
+		}
+		d.Outputs = append(d.Outputs, o)
+	}
+	return d, nil
+}
@@ -0,0 +1,60 @@
+package qln
+
+import (
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Peers that both have FeatureCompression can send several messages deflated
+together; see lnutil/compress.go.  Tower uploads do: FlushTowerBox sends a
+backlog towerFlushBatch at a time as one compressed message, which is
+where it pays, for phones and nodes on Tor catching up after time offline.
+Anything else still goes one message at a time.
+*/
+
+// how many outbox entries FlushTowerBox sends at once
+const towerFlushBatch = 256
+
+// peerCompresses says if a connected peer takes compressed messages
+func (nd *LitNode) peerCompresses(idx uint32) bool {
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	peer, ok := nd.RemoteCons[idx]
+	return ok && peer.Features.Has(lnutil.FeatureCompression) &&
+		nd.LocalFeatures().Has(lnutil.FeatureCompression)
+}
+
+// writeTowerMsgs sends serialized messages from the tower outbox to the
+// tower on peer peerIdx, or on WatchCon for 0, in order, and says how many
+// went.  More than one go compressed together if the tower takes that.
+func (nd *LitNode) writeTowerMsgs(peerIdx uint32, raws [][]byte) (int, error) {
+	if peerIdx != 0 && len(raws) > 1 && nd.peerCompresses(peerIdx) {
+		v := nd.PeerMsgVersion(peerIdx)
+		inner := make([][]byte, len(raws))
+		for i, raw := range raws {
+			inner[i] = raw
+			if v > 0 {
+				msg, err := lnutil.LitMsgFromBytes(raw, peerIdx)
+				if err != nil {
+					return 0, err
+				}
+				inner[i] = lnutil.WrapMsg(msg, v, nil)
+			}
+		}
+		cm, n := lnutil.NewCompressedMsg(peerIdx, inner)
+		if n > 1 {
+			err := nd.sendTowerUpload(cm)
+			if err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+	}
+	for i, raw := range raws {
+		err := nd.writeTowerMsg(peerIdx, raw)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(raws), nil
+}
@@ -0,0 +1,119 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Custom messages let an application talk to the same application on a
+connected peer without changing lit: it picks a 16 bit type and sends
+bytes, and lit passes them along in a CustomMsg.  Nothing in lit looks at
+what's in them.  Peers say they take them with FeatureCustomMsgs; sending
+to one that doesn't is an error here, rather than dropped over there.
+
+Types are the application's to pick.  Ones from 0x8000 up are for
+experiments; below that, check no one else is using it.
+
+What comes in goes in an inbox of the last customInboxSize, each with a
+sequence number, and out as an EventCustomMessage.  Readers ask for what's
+after the last number they saw, so nothing's missed between asks unless
+they fall that far behind.  The inbox is in memory only.
+*/
+
+// how many received custom messages are kept for readers
+const customInboxSize = 1000
+
+// CustomMessage is a custom message we got
+type CustomMessage struct {
+	Seq     uint64
+	Time    int64 // unix time
+	PeerIdx uint32
+	Type    uint16
+	Data    []byte
+}
+
+type customInbox struct {
+	mtx  sync.Mutex
+	seq  uint64
+	msgs []CustomMessage // oldest first
+}
+
+// SendCustomMessage sends an application's message to a connected peer
+func (nd *LitNode) SendCustomMessage(peerIdx uint32, typ uint16, data []byte) error {
+	if len(data) > lnutil.CustomMsgMax {
+		return fmt.Errorf("custom message %d bytes, max %d",
+			len(data), lnutil.CustomMsgMax)
+	}
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[peerIdx]
+	var features lnutil.Features
+	if ok {
+		features = peer.Features
+	}
+	nd.RemoteMtx.Unlock()
+	if !ok {
+		return fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	if !features.Has(lnutil.FeatureCustomMsgs) {
+		return fmt.Errorf("peer %d doesn't take custom messages", peerIdx)
+	}
+	nd.OmniOut <- lnutil.CustomMsg{PeerIdx: peerIdx, Type: typ, Data: data}
+	return nil
+}
+
+// CustomMsgHandler puts a custom message in the inbox for readers
+func (nd *LitNode) CustomMsgHandler(msg lnutil.CustomMsg) error {
+	in := nd.customInbox
+	in.mtx.Lock()
+	in.seq++
+	cm := CustomMessage{
+		Seq:     in.seq,
+		Time:    time.Now().Unix(),
+		PeerIdx: msg.PeerIdx,
+		Type:    msg.Type,
+		Data:    msg.Data,
+	}
+	in.msgs = append(in.msgs, cm)
+	if len(in.msgs) > customInboxSize {
+		in.msgs = in.msgs[len(in.msgs)-customInboxSize:]
+	}
+	in.mtx.Unlock()
+
+	nd.PublishEvent(NodeEvent{Type: EventCustomMessage, PeerIdx: msg.PeerIdx,
+		Detail: fmt.Sprintf("type %d, %d bytes", msg.Type, len(msg.Data))})
+	return nil
+}
+
+// CustomMessages returns received custom messages after seq, oldest first,
+// of the types given, or any type if there are none.  It also returns the
+// newest sequence number, to ask after next time.
+func (nd *LitNode) CustomMessages(
+	after uint64, types []uint16) ([]CustomMessage, uint64) {
+	in := nd.customInbox
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	var cms []CustomMessage
+	for _, cm := range in.msgs {
+		if cm.Seq <= after || !typeIn(cm.Type, types) {
+			continue
+		}
+		cms = append(cms, cm)
+	}
+	return cms, in.seq
+}
+
+func typeIn(typ uint16, types []uint16) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
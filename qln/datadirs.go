@@ -0,0 +1,322 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Everything lit keeps goes in the lit folder by default:
+
+  ln.db               channels, in Channel
+  <coin>/utxo.db      each wallet, in Wallet
+  <coin>/header.bin   each coin's block headers, in Headers
+  watch.db            the watchtower, in Tower
+
+Any of those folders can be somewhere else -- the tower's big txid bucket
+on a large slow disk, say, and ln.db on a fast one.  The lit folder keeps
+the rest: the key file, config and logs.
+
+The layout in use is kept in datadirs.json in the lit folder.  If the
+config says otherwise and there are files to move, PrepareDataDirs won't
+go on unless told to move them, rather than start over with empty DBs.
+
+For tests and throwaway nodes there's EphemeralDataDirs: everything the
+node keeps in a new temp folder, in memory on tmpfs where there is one,
+removed when the node's done with it.  There's no layout kept for that.
+*/
+
+const dataDirsFile = "datadirs.json"
+
+// DataDirs is where each part of the node keeps its files.  Empty means
+// the lit folder.
+type DataDirs struct {
+	Lit     string `json:"-"`
+	Channel string `json:",omitempty"`
+	Wallet  string `json:",omitempty"`
+	Headers string `json:",omitempty"`
+	Tower   string `json:",omitempty"`
+}
+
+func (d DataDirs) or(dir string) string {
+	if dir == "" {
+		return d.Lit
+	}
+	return dir
+}
+
+// ChannelDir is where ln.db goes
+func (d DataDirs) ChannelDir() string { return d.or(d.Channel) }
+
+// WalletDir has a folder per coin with its utxo.db
+func (d DataDirs) WalletDir() string { return d.or(d.Wallet) }
+
+// HeaderDir has a folder per coin with its header.bin
+func (d DataDirs) HeaderDir() string { return d.or(d.Headers) }
+
+// TowerDir is where watch.db goes
+func (d DataDirs) TowerDir() string { return d.or(d.Tower) }
+
+// Account is the layout for an account: accounts/<name> in each folder
+// that's set
+func (d DataDirs) Account(name string) DataDirs {
+	sub := func(dir string) string {
+		if dir == "" {
+			return ""
+		}
+		return filepath.Join(dir, "accounts", name)
+	}
+	return DataDirs{
+		Lit:     sub(d.Lit),
+		Channel: sub(d.Channel),
+		Wallet:  sub(d.Wallet),
+		Headers: sub(d.Headers),
+		Tower:   sub(d.Tower),
+	}
+}
+
+// same says if two layouts put everything in the same places
+func (d DataDirs) same(o DataDirs) bool {
+	return filepath.Clean(d.ChannelDir()) == filepath.Clean(o.ChannelDir()) &&
+		filepath.Clean(d.WalletDir()) == filepath.Clean(o.WalletDir()) &&
+		filepath.Clean(d.HeaderDir()) == filepath.Clean(o.HeaderDir()) &&
+		filepath.Clean(d.TowerDir()) == filepath.Clean(o.TowerDir())
+}
+
+// dataMove is a file to move from one place to another
+type dataMove struct {
+	from, to string
+}
+
+// moves lists the files there are in from, and where they go in to
+func (d DataDirs) moves(to DataDirs) ([]dataMove, error) {
+	var mv []dataMove
+	add := func(fromDir, toDir, name string) {
+		from := filepath.Join(fromDir, name)
+		dest := filepath.Join(toDir, name)
+		if from == dest {
+			return
+		}
+		_, err := os.Stat(from)
+		if err == nil {
+			mv = append(mv, dataMove{from, dest})
+		}
+	}
+	add(d.ChannelDir(), to.ChannelDir(), "ln.db")
+	add(d.TowerDir(), to.TowerDir(), "watch.db")
+	for _, f := range []struct {
+		from, to, name string
+	}{
+		{d.WalletDir(), to.WalletDir(), "utxo.db"},
+		{d.HeaderDir(), to.HeaderDir(), "header.bin"},
+	} {
+		coins, err := filepath.Glob(filepath.Join(f.from, "*", f.name))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range coins {
+			coin := filepath.Base(filepath.Dir(path))
+			add(f.from, f.to, filepath.Join(coin, f.name))
+		}
+	}
+	return mv, nil
+}
+
+// loadDataDirs reads the layout in use, or the default if none's recorded
+func loadDataDirs(lit string) (DataDirs, error) {
+	d := DataDirs{Lit: lit}
+	b, err := ioutil.ReadFile(filepath.Join(lit, dataDirsFile))
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return d, err
+	}
+	err = json.Unmarshal(b, &d)
+	d.Lit = lit
+	return d, err
+}
+
+func saveDataDirs(d DataDirs) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.Lit, dataDirsFile)
+	err = ioutil.WriteFile(path+".tmp", b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// where EphemeralDataDirs looks for tmpfs before the system temp folder
+const tmpfsDir = "/dev/shm"
+
+// EphemeralDataDirs makes a new temp folder for a node to keep everything
+// in, DBs and all, on tmpfs if there is one, so it starts fast and leaves
+// nothing behind.  Call done once the node's shut down to remove it.  Use
+// it instead of PrepareDataDirs.
+func EphemeralDataDirs() (d DataDirs, done func(), err error) {
+	dir, err := ioutil.TempDir(tmpfsDir, "lit-")
+	if err != nil {
+		dir, err = ioutil.TempDir("", "lit-")
+		if err != nil {
+			return d, nil, err
+		}
+	}
+	d.Lit = dir
+	return d, func() { os.RemoveAll(dir) }, nil
+}
+
+// PrepareDataDirs makes the folders in d, and checks the files already in
+// use are there.  With move set, files from the last layout used are moved
+// into d; otherwise it's an error for there to be any.  Run it before
+// NewLitNodeDirs.
+func PrepareDataDirs(d DataDirs, move bool) error {
+	for _, dir := range []string{
+		d.Lit, d.ChannelDir(), d.WalletDir(), d.HeaderDir(), d.TowerDir()} {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+	old, err := loadDataDirs(d.Lit)
+	if err != nil {
+		return fmt.Errorf("%s: %s", dataDirsFile, err.Error())
+	}
+	if !old.same(d) {
+		mv, err := old.moves(d)
+		if err != nil {
+			return err
+		}
+		if len(mv) != 0 && !move {
+			return fmt.Errorf("%s is in %s, but the config has moved it; "+
+				"run with --movedata to move it, or set the folders back",
+				filepath.Base(mv[0].from), filepath.Dir(mv[0].from))
+		}
+		err = moveDataFiles(mv)
+		if err != nil {
+			return err
+		}
+	}
+	return saveDataDirs(d)
+}
+
+// moveDataFiles moves each file, making sure it's in its new place before
+// it's gone from its old one.  It's safe to run again if it stops part way.
+func moveDataFiles(mv []dataMove) error {
+	// nothing can be using them
+	for _, m := range mv {
+		if filepath.Ext(m.from) != ".db" {
+			continue
+		}
+		db, err := bolt.Open(m.from, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return fmt.Errorf("%s: %s; is lit running?", m.from, err.Error())
+		}
+		db.Close()
+	}
+	for _, m := range mv {
+		err := moveDataFile(m.from, m.to)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("moved %s to %s\n", m.from, m.to)
+	}
+	return nil
+}
+
+// moveDataFile renames from to to, or on another volume copies it over
+// then removes it
+func moveDataFile(from, to string) error {
+	err := os.MkdirAll(filepath.Dir(to), 0700)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(to); err == nil {
+		// an earlier move may have got as far as copying; only finish it
+		// if it's the same file
+		same, err := sameContents(from, to)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return fmt.Errorf("can't move %s: %s is already there", from, to)
+		}
+		return os.Remove(from)
+	}
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := to + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, to)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copying %s to %s: %s", from, to, err.Error())
+	}
+	same, err := sameContents(from, to)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("copy of %s at %s doesn't match", from, to)
+	}
+	return os.Remove(from)
+}
+
+// sameContents says if two files have the same bytes
+func sameContents(a, b string) (bool, error) {
+	ha, err := fileHash(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := fileHash(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ha, hb), nil
+}
+
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,113 @@
+package qln
+
+import (
+	"time"
+)
+
+/*
+Node events are things that happen to the node that something outside of
+qln might want to know about, without having to poll the RPC.  Anything
+that wants events calls SubscribeEvents and reads from the returned chan.
+Events are dropped for a subscriber whose chan is full, so the node never
+blocks on a slow reader.
+*/
+
+// event type strings; these are what shows up in the json payloads
+const (
+	EventChanOpened  = "channel_opened"
+	EventChanClosed  = "channel_closed"
+	EventBreach      = "breach_detected"
+	EventPaymentRecv = "payment_received"
+	// a close we didn't sign for, published with EventChanClosed
+	EventForceClose = "force_close_observed"
+
+	// see alerts.go
+	EventPeerOffline      = "peer_offline"
+	EventTowerUnreachable = "tower_unreachable"
+	EventTxEvicted        = "tx_evicted"
+	EventTxDoubleSpent    = "tx_double_spent"
+	EventHeirDue          = "heir_checkin_due"
+	// see chanqueue.go
+	EventChanStuck = "channel_stuck"
+	// see towerset.go
+	EventTowerFailover = "tower_failover"
+
+	EventInvoiceSettled = "invoice_settled"
+	EventOrderPaid      = "order_paid"
+	EventPaymentUpdate  = "payment_update"
+
+	// see custommsg.go
+	EventCustomMessage = "custom_message"
+)
+
+// how many events can queue up for each subscriber before we drop them
+const eventQueueSize = 64
+
+// NodeEvent describes something that happened.  Not all fields are used
+// for all event types.
+type NodeEvent struct {
+	Type     string
+	Time     int64 // unix time
+	PeerIdx  uint32
+	ChanIdx  uint32
+	CoinType uint32
+	Amt      int64
+	OutPoint string
+	ShortID  string // block:tx:output, once the channel's confirmed
+	Txid     string
+
+	PaymentHash string // hex, for invoice events
+	OrderID     string // for order events
+	PaymentID   uint32 // for queued payment events
+	Detail      string // more about it, for people; alerts have it
+}
+
+// SubscribeEvents returns a chan which will get all node events from now on.
+func (nd *LitNode) SubscribeEvents() chan NodeEvent {
+	sub := make(chan NodeEvent, eventQueueSize)
+	nd.EventMtx.Lock()
+	nd.eventSubs = append(nd.eventSubs, sub)
+	nd.EventMtx.Unlock()
+	return sub
+}
+
+// UnsubscribeEvents stops sending events to the given chan.
+func (nd *LitNode) UnsubscribeEvents(sub chan NodeEvent) {
+	nd.EventMtx.Lock()
+	defer nd.EventMtx.Unlock()
+	for i, s := range nd.eventSubs {
+		if s == sub {
+			nd.eventSubs = append(nd.eventSubs[:i], nd.eventSubs[i+1:]...)
+			return
+		}
+	}
+}
+
+// PublishEvent sends an event to all subscribers.  Sets the time if not set.
+func (nd *LitNode) PublishEvent(ev NodeEvent) {
+	if ev.Time == 0 {
+		ev.Time = time.Now().Unix()
+	}
+	nd.EventMtx.Lock()
+	defer nd.EventMtx.Unlock()
+	for _, sub := range nd.eventSubs {
+		select {
+		case sub <- ev:
+		default: // full; drop rather than block the node
+		}
+	}
+}
+
+// chanEvent fills in the channel fields of an event from a qchan
+func chanEvent(evType string, q *Qchan) NodeEvent {
+	var ev NodeEvent
+	ev.Type = evType
+	ev.PeerIdx = q.Peer()
+	ev.ChanIdx = q.Idx()
+	ev.CoinType = q.Coin()
+	ev.OutPoint = q.Op.String()
+	if q.ShortID != 0 {
+		ev.ShortID = q.ShortID.String()
+	}
+	return ev
+}
@@ -0,0 +1,164 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+// Feature negotiation; the bits and how they work are in lnutil/features.go.
+
+// LocalFeatures are the node features this node sends its peers
+func (nd *LitNode) LocalFeatures() lnutil.Features {
+	f := lnutil.NewFeatures(
+		lnutil.FeatureTowerAdverts+1,
+		lnutil.FeatureSwaps+1,
+		lnutil.FeatureLiquidityAds+1,
+		lnutil.FeatureCustomMsgs+1,
+		lnutil.FeatureCompression+1)
+	if wt, ok := nd.Tower.(*watchtower.WatchTower); ok && wt.WatchDB != nil {
+		f.Set(lnutil.FeatureTower + 1)
+	}
+	return f
+}
+
+// LocalChanFeatures are the channel features this node offers, or
+// accepts, when a channel opens
+func (nd *LitNode) LocalChanFeatures() lnutil.Features {
+	return lnutil.NewFeatures(lnutil.FeatureUpfrontShutdown + 1)
+}
+
+// sendFeatures tells a peer that just connected what this node can do
+func (nd *LitNode) sendFeatures(peerIdx uint32) {
+	nd.OmniOut <- lnutil.NewFeaturesMsg(peerIdx, nd.LocalFeatures())
+}
+
+// FeaturesHandler takes a peer's features.  If it requires one this node
+// doesn't know the connection is dropped; otherwise they're kept.
+func (nd *LitNode) FeaturesHandler(msg lnutil.FeaturesMsg, peer *RemotePeer) error {
+	unknown := msg.Features.UnknownRequired(nd.LocalFeatures())
+	if len(unknown) > 0 {
+		logger.Warnf("peer %d requires unknown features %v; disconnecting\n",
+			peer.Idx, unknown)
+		// the reader sees the close and cleans up
+		return peer.Con.Close()
+	}
+
+	nd.RemoteMtx.Lock()
+	peer.Features = msg.Features
+	peer.MsgVer = msg.MsgVersion
+	nd.RemoteMtx.Unlock()
+	logger.Infof("peer %d features: %s, message version %d\n",
+		peer.Idx, msg.Features.String(), msg.MsgVersion)
+
+	if msg.Features.Has(lnutil.FeatureLiquidityAds) {
+		go nd.sendLiquidityAd(peer.Idx)
+	}
+
+	return nd.SavePeerFeatures(peer.Idx, msg.Features)
+}
+
+// PeerMsgVersion is the message version to use with a connected peer: the
+// lower of theirs and this node's.  0 if they didn't say, or aren't
+// connected, which means no envelopes and formats from before them.
+func (nd *LitNode) PeerMsgVersion(idx uint32) uint8 {
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	return peerMsgVersion(nd.RemoteCons[idx])
+}
+
+// peerMsgVersion is PeerMsgVersion with RemoteMtx held
+func peerMsgVersion(peer *RemotePeer) uint8 {
+	if peer == nil {
+		return 0
+	}
+	if peer.MsgVer < lnutil.MsgVersion {
+		return peer.MsgVer
+	}
+	return lnutil.MsgVersion
+}
+
+// SavePeerFeatures saves the features a peer sent, so they're known when
+// it's not connected
+func (nd *LitNode) SavePeerFeatures(idx uint32, f lnutil.Features) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		if mp == nil {
+			return fmt.Errorf("no PeerMap")
+		}
+		pubBytes := mp.Get(lnutil.U32tB(idx))
+		peerBkt := btx.Bucket(BKTPeers)
+		if peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		prBkt := peerBkt.Bucket(pubBytes)
+		if prBkt == nil {
+			return fmt.Errorf("no peer %x", pubBytes)
+		}
+		return prBkt.Put(KEYFeatures, f)
+	})
+}
+
+// PeerFeatures are a peer's features: what it sent this connection if
+// it's connected, or what it last sent if not.  nil if it's never sent any.
+func (nd *LitNode) PeerFeatures(idx uint32) (lnutil.Features, error) {
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[idx]
+	if ok && peer.Features != nil {
+		f := peer.Features
+		nd.RemoteMtx.Unlock()
+		return f, nil
+	}
+	nd.RemoteMtx.Unlock()
+
+	var f lnutil.Features
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		if mp == nil {
+			return fmt.Errorf("no PeerMap")
+		}
+		pubBytes := mp.Get(lnutil.U32tB(idx))
+		if pubBytes == nil {
+			return fmt.Errorf("no peer %d", idx)
+		}
+		peerBkt := btx.Bucket(BKTPeers)
+		if peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		prBkt := peerBkt.Bucket(pubBytes)
+		if prBkt == nil {
+			return fmt.Errorf("no peer %x", pubBytes)
+		}
+		if b := prBkt.Get(KEYFeatures); b != nil {
+			f = append(lnutil.Features(nil), b...)
+		}
+		return nil
+	})
+	return f, err
+}
+
+// saveChanFeatures saves the features agreed for a channel, and the
+// shutdown scripts that go with them, after it's been saved with SaveQChan
+func (nd *LitNode) saveChanFeatures(q *Qchan) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return fmt.Errorf("no channel bucket")
+		}
+		opArr := lnutil.OutPointToBytes(q.Op)
+		qcBucket := cbk.Bucket(opArr[:])
+		if qcBucket == nil {
+			return fmt.Errorf("no channel %s", q.Op.String())
+		}
+		err := putShutdownScripts(qcBucket, q)
+		if err != nil {
+			return err
+		}
+		if len(q.Features) == 0 {
+			return qcBucket.Delete(KEYChanFeatures)
+		}
+		return qcBucket.Put(KEYChanFeatures, q.Features)
+	})
+}
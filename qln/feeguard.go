@@ -0,0 +1,198 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+The fee guard holds back on chain actions that can wait when fees spike.
+With a ceiling set (feeceiling, in sat/byte), an action that goes through
+NonUrgent while its wallet's fee rate is above the ceiling isn't done;
+it's queued, and FeeGuard runs it once the rate's back at or under the
+ceiling.  The queue can be looked at, and an action run right away or
+dropped, over RPC.
+
+What goes through it: cooperative closes and sweeps.  A coop close's fee
+is fixed by the channel state, not the current rate, but closing with it
+during a spike just gets a tx that sits unconfirmed while the channel's
+already gone from the UI.  Autopilot skips its idle channel closes while
+fees are high instead of queueing them, and SweepWatcher its to_local
+sweeps.
+
+What never goes through it: justice txs, HTLC timeouts and sweeps of
+breached or expiring outputs, and BreakChannel.  Those are urgent by
+definition, or the user's explicitly asked for them; a deadline missed
+costs more than any fee.
+
+The queue is in memory.  Actions queued when lit stops are gone, and
+logged as such; ask again after restarting.
+*/
+
+const feeGuardInterval = time.Minute
+
+// DeferredAction is an on chain action waiting for fees to come down
+type DeferredAction struct {
+	ID      uint32
+	Coin    uint32
+	What    string // eg "close channel 3"
+	Queued  int64  // unix time
+	Tries   int
+	LastErr string
+
+	run func() error
+}
+
+type feeGuard struct {
+	mtx     sync.Mutex
+	ceiling int64 // sat/byte; 0 for none
+	nextID  uint32
+	queue   []*DeferredAction
+}
+
+// SetFeeCeiling sets the fee rate, in sat/byte, above which non urgent on
+// chain actions wait.  0 turns the guard off; anything queued then runs
+// on FeeGuard's next look.
+func (nd *LitNode) SetFeeCeiling(satPerByte int64) {
+	nd.feeGuard.mtx.Lock()
+	nd.feeGuard.ceiling = satPerByte
+	nd.feeGuard.mtx.Unlock()
+}
+
+// FeeCeiling is the fee guard's ceiling; 0 if there isn't one
+func (nd *LitNode) FeeCeiling() int64 {
+	nd.feeGuard.mtx.Lock()
+	defer nd.feeGuard.mtx.Unlock()
+	return nd.feeGuard.ceiling
+}
+
+// FeeSpiking says if a coin's fee rate is over the ceiling, and what the
+// rate is
+func (nd *LitNode) FeeSpiking(coin uint32) (bool, int64) {
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return false, 0
+	}
+	rate := wal.Fee()
+	ceiling := nd.FeeCeiling()
+	return ceiling != 0 && rate > ceiling, rate
+}
+
+// NonUrgent runs fn now, unless coin's fee rate is over the ceiling, in
+// which case it's queued and returned.  fn should load what it needs when
+// it runs, as it may be a while.
+func (nd *LitNode) NonUrgent(
+	coin uint32, what string, fn func() error) (*DeferredAction, error) {
+
+	spiking, rate := nd.FeeSpiking(coin)
+	if !spiking {
+		return nil, fn()
+	}
+	g := nd.feeGuard
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.nextID++
+	act := &DeferredAction{
+		ID:     g.nextID,
+		Coin:   coin,
+		What:   what,
+		Queued: time.Now().Unix(),
+		run:    fn,
+	}
+	g.queue = append(g.queue, act)
+	logger.Infof("fee rate %d over ceiling %d; %s deferred as #%d\n",
+		rate, g.ceiling, what, act.ID)
+	cp := *act
+	return &cp, nil
+}
+
+// DeferredActions lists what's waiting for fees to come down, oldest first
+func (nd *LitNode) DeferredActions() []DeferredAction {
+	g := nd.feeGuard
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	acts := make([]DeferredAction, len(g.queue))
+	for i, act := range g.queue {
+		acts[i] = *act
+	}
+	sort.Slice(acts, func(i, j int) bool { return acts[i].ID < acts[j].ID })
+	return acts
+}
+
+// takeDeferred takes an action off the queue
+func (nd *LitNode) takeDeferred(id uint32) (*DeferredAction, error) {
+	g := nd.feeGuard
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for i, act := range g.queue {
+		if act.ID == id {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			return act, nil
+		}
+	}
+	return nil, fmt.Errorf("no deferred action #%d", id)
+}
+
+// RunDeferred runs a queued action now, whatever the fee rate.  If it
+// fails it goes back on the queue.
+func (nd *LitNode) RunDeferred(id uint32) error {
+	act, err := nd.takeDeferred(id)
+	if err != nil {
+		return err
+	}
+	return nd.runDeferred(act)
+}
+
+// CancelDeferred drops a queued action without running it
+func (nd *LitNode) CancelDeferred(id uint32) error {
+	act, err := nd.takeDeferred(id)
+	if err != nil {
+		return err
+	}
+	logger.Infof("deferred #%d (%s) cancelled\n", act.ID, act.What)
+	return nil
+}
+
+// runDeferred runs an action taken off the queue, putting it back if it
+// fails
+func (nd *LitNode) runDeferred(act *DeferredAction) error {
+	err := act.run()
+	g := nd.feeGuard
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	act.Tries++
+	if err != nil {
+		act.LastErr = err.Error()
+		g.queue = append(g.queue, act)
+		logger.Warnf("deferred #%d (%s) failed, still queued: %s\n",
+			act.ID, act.What, err.Error())
+		return err
+	}
+	logger.Infof("deferred #%d (%s) done after %s\n", act.ID, act.What,
+		time.Since(time.Unix(act.Queued, 0)))
+	return nil
+}
+
+// FeeGuard runs queued actions whose coin's fee rate has come down.  Runs
+// until shutdown.
+func (nd *LitNode) FeeGuard() {
+	for !nd.ShuttingDown() {
+		time.Sleep(feeGuardInterval)
+		for _, act := range nd.DeferredActions() {
+			if spiking, _ := nd.FeeSpiking(act.Coin); spiking {
+				continue
+			}
+			taken, err := nd.takeDeferred(act.ID)
+			if err != nil {
+				continue // run or cancelled meanwhile
+			}
+			nd.runDeferred(taken)
+		}
+	}
+	for _, act := range nd.DeferredActions() {
+		logger.Warnf("shutting down with %s still deferred as #%d\n",
+			act.What, act.ID)
+	}
+}
@@ -0,0 +1,137 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Fee policies say what we'd charge to forward a payment out through a
+channel: a base fee plus a rate in millionths of the amount.  Each channel
+can have its own; channels without one use the default, kept under
+channel index 0.
+
+Forwarding isn't in yet, so nothing charges these or earns anything by
+them; the report is the policies, for a routing node operator to tune
+once it is.
+*/
+
+// FeePolicy is what we charge to forward through a channel
+type FeePolicy struct {
+	BaseFee int64  // satoshis per forward
+	FeeRate uint32 // millionths of the amount forwarded
+}
+
+// DefaultFeePolicy is used until someone sets one
+var DefaultFeePolicy = FeePolicy{BaseFee: 1, FeeRate: 1}
+
+// Fee is what the policy charges to forward amt
+func (p FeePolicy) Fee(amt int64) int64 {
+	return p.BaseFee + amt*int64(p.FeeRate)/1000000
+}
+
+// ToBytes serializes a policy; always 12 bytes
+func (p FeePolicy) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, p.BaseFee)
+	binary.Write(&buf, binary.BigEndian, p.FeeRate)
+	return buf.Bytes()
+}
+
+// FeePolicyFromBytes deserializes a policy
+func FeePolicyFromBytes(b []byte) (FeePolicy, error) {
+	var p FeePolicy
+	if len(b) != 12 {
+		return p, fmt.Errorf("%d bytes, fee policy is 12", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &p.BaseFee)
+	binary.Read(buf, binary.BigEndian, &p.FeeRate)
+	return p, nil
+}
+
+// SetFeePolicy sets the policy for a channel, or the default if cIdx is 0
+func (nd *LitNode) SetFeePolicy(cIdx uint32, p FeePolicy) error {
+	if p.BaseFee < 0 {
+		return fmt.Errorf("base fee %d negative", p.BaseFee)
+	}
+	if cIdx != 0 {
+		_, err := nd.GetQchanOPfromIdx(cIdx)
+		if err != nil {
+			return err
+		}
+	}
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		fb := btx.Bucket(BKTFeePolicy)
+		if fb == nil {
+			return fmt.Errorf("no fee policy bucket")
+		}
+		return fb.Put(lnutil.U32tB(cIdx), p.ToBytes())
+	})
+}
+
+// GetFeePolicy returns the policy for a channel; the default if the
+// channel doesn't have its own, or if cIdx is 0.
+func (nd *LitNode) GetFeePolicy(cIdx uint32) (FeePolicy, error) {
+	p := DefaultFeePolicy
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		fb := btx.Bucket(BKTFeePolicy)
+		if fb == nil {
+			return fmt.Errorf("no fee policy bucket")
+		}
+		b := fb.Get(lnutil.U32tB(cIdx))
+		if b == nil {
+			b = fb.Get(lnutil.U32tB(0))
+		}
+		if b == nil {
+			return nil
+		}
+		var err error
+		p, err = FeePolicyFromBytes(b)
+		return err
+	})
+	return p, err
+}
+
+// ChannelFees is one channel's line in the fee report
+type ChannelFees struct {
+	ChanIdx uint32
+	Policy  FeePolicy
+}
+
+// FeeReport is the fee policy of each open channel
+type FeeReport struct {
+	Default  FeePolicy
+	Channels []ChannelFees
+}
+
+// FeeReport lists the fee policies of the open channels
+func (nd *LitNode) FeeReport() (*FeeReport, error) {
+	r := new(FeeReport)
+	var err error
+	r.Default, err = nd.GetFeePolicy(0)
+	if err != nil {
+		return nil, err
+	}
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range qcs {
+		if q.CloseData.Closed {
+			continue
+		}
+		cf := ChannelFees{ChanIdx: q.Idx()}
+		cf.Policy, err = nd.GetFeePolicy(q.Idx())
+		if err != nil {
+			return nil, err
+		}
+		r.Channels = append(r.Channels, cf)
+	}
+	return r, nil
+}
@@ -0,0 +1,208 @@
+package qln
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Fiat rates, for pricing orders and invoices in fiat and showing amounts in
+a fiat currency.  Optional: nothing here runs unless --fiat or
+--fiatsource is set.
+
+A source is a URL returning json with the price of one bitcoin somewhere
+in it, then # and the dot separated path to it, eg
+	https://api.coinbase.com/v2/prices/BTC-{CUR}/spot#data.amount
+{CUR} and {cur} are replaced by the currency code in upper or lower case,
+in both the URL and the path.  The price can be a json number or string.
+With several sources the median of the ones that answer is used, so one
+broken or lying source doesn't move the price.  Rates are cached for
+fiatRefresh.
+
+Prices are of bitcoin, and are used for every coin type; on test networks
+the fiat amounts are just for show.
+*/
+
+// DefaultFiatSources are used when --fiat is set without --fiatsource
+var DefaultFiatSources = []string{
+	"https://api.coinbase.com/v2/prices/BTC-{CUR}/spot#data.amount",
+	"https://api.coingecko.com/api/v3/simple/price?ids=bitcoin&vs_currencies={cur}#bitcoin.{cur}",
+}
+
+const (
+	fiatRefresh = time.Minute
+	fiatTimeout = 10 * time.Second
+)
+
+// FiatConfig sets up fiat rates.  Currency is the one to show amounts in;
+// any currency the sources know can be used for pricing.
+type FiatConfig struct {
+	Currency string
+	Sources  []string
+}
+
+type fiatQuote struct {
+	satsPerUnit float64
+	at          time.Time
+}
+
+type fiatRates struct {
+	sources []string
+	client  *http.Client
+
+	mtx   sync.Mutex
+	cache map[string]fiatQuote
+}
+
+// StartFiat turns on fiat rates, setting FiatRate and FiatCurrency.  Does
+// nothing if cfg has neither a currency nor sources.
+func (nd *LitNode) StartFiat(cfg FiatConfig) {
+	if cfg.Currency == "" && len(cfg.Sources) == 0 {
+		return
+	}
+	fr := &fiatRates{
+		sources: cfg.Sources,
+		client:  lnutil.HTTPClient(fiatTimeout),
+		cache:   make(map[string]fiatQuote),
+	}
+	if len(fr.sources) == 0 {
+		fr.sources = DefaultFiatSources
+	}
+	nd.FiatCurrency = strings.ToUpper(cfg.Currency)
+	nd.FiatRate = fr.rate
+}
+
+// rate returns satoshis per whole unit of currency
+func (fr *fiatRates) rate(currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	fr.mtx.Lock()
+	q, ok := fr.cache[currency]
+	fr.mtx.Unlock()
+	if ok && time.Since(q.at) < fiatRefresh {
+		return q.satsPerUnit, nil
+	}
+
+	var prices []float64
+	var lastErr error
+	for _, src := range fr.sources {
+		p, err := fr.fetch(src, currency)
+		if err != nil {
+			logger.Warnf("fiat source %s: %s\n", src, err.Error())
+			lastErr = err
+			continue
+		}
+		prices = append(prices, p)
+	}
+	if len(prices) == 0 {
+		// a stale rate beats none
+		if ok {
+			return q.satsPerUnit, nil
+		}
+		return 0, fmt.Errorf("no %s price: %v", currency, lastErr)
+	}
+	sort.Float64s(prices)
+	median := prices[len(prices)/2]
+	if len(prices)%2 == 0 {
+		median = (prices[len(prices)/2-1] + median) / 2
+	}
+
+	q = fiatQuote{satsPerUnit: 1e8 / median, at: time.Now()}
+	fr.mtx.Lock()
+	fr.cache[currency] = q
+	fr.mtx.Unlock()
+	return q.satsPerUnit, nil
+}
+
+// fetch gets the price of 1 BTC from one source
+func (fr *fiatRates) fetch(src, currency string) (float64, error) {
+	src = strings.Replace(src, "{CUR}", currency, -1)
+	src = strings.Replace(src, "{cur}", strings.ToLower(currency), -1)
+	u, path := src, ""
+	if i := strings.LastIndex(src, "#"); i >= 0 {
+		u, path = src[:i], src[i+1:]
+	}
+	resp, err := fr.client.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %s", resp.Status)
+	}
+	var v interface{}
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	if err != nil {
+		return 0, err
+	}
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				v = node[key]
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return 0, fmt.Errorf("no %s in %s", key, path)
+				}
+				v = node[i]
+			default:
+				return 0, fmt.Errorf("no %s in %s", key, path)
+			}
+		}
+	}
+	var price float64
+	switch p := v.(type) {
+	case float64:
+		price = p
+	case string:
+		price, err = strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("price %q isn't a number", p)
+		}
+	default:
+		return 0, fmt.Errorf("no price at %s", path)
+	}
+	if price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return 0, fmt.Errorf("price %f", price)
+	}
+	return price, nil
+}
+
+// FiatToSats converts hundredths of currency to satoshis at the current
+// rate, rounding up
+func (nd *LitNode) FiatToSats(hundredths int64, currency string) (int64, error) {
+	if nd.FiatRate == nil {
+		return 0, fmt.Errorf("no fiat rates; set fiat or fiatsource")
+	}
+	rate, err := nd.FiatRate(currency)
+	if err != nil {
+		return 0, err
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("bad %s rate %f", currency, rate)
+	}
+	return int64(math.Ceil(float64(hundredths) / 100 * rate)), nil
+}
+
+// FiatString shows sats in FiatCurrency, eg "12.34 USD", or "" if there's
+// no currency set or no rate
+func (nd *LitNode) FiatString(sats int64) string {
+	if nd.FiatCurrency == "" || nd.FiatRate == nil {
+		return ""
+	}
+	rate, err := nd.FiatRate(nd.FiatCurrency)
+	if err != nil || rate <= 0 {
+		return ""
+	}
+	return lnutil.FormatFiat(
+		int64(math.Round(float64(sats)/rate*100)), nd.FiatCurrency)
+}
@@ -0,0 +1,242 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/sig64"
+)
+
+/*
+A node that's lost its channel data, or thinks its keys are out, can't
+safely break its channels itself: the state it has may be old, and an old
+state gets it all taken.  It can ask the peer to break them instead, with
+the peer's latest state, which is always safe for the peer to broadcast.
+
+The request is a ForceCloseReqMsg signed with the asking side's fund key
+for the channel, which it can get from the seed and a channel backup (see
+chanbackup.go), and which only it and the peer know goes with the channel.
+The peer checks the channel's from the peer asking, that the request's
+recent, and the signature against the channel's keys, then breaks it, as
+BreakChannel does, and answers with the txid.  A channel already closed
+gets the close txid back.  Anything else gets a refusal and why.
+
+When asking from a channel in the DB, the close is picked up like any
+other; our side's a plain output to the refund key.  When asking from a
+backup the channel isn't in the DB, so the refund key's swept to the
+wallet once the close is in a block, for up to forceCloseSweepFor.
+*/
+
+const (
+	// requests from further than this from now are turned down
+	forceCloseWindow = 10 * time.Minute
+	// how long to keep looking for the close of a channel from a backup
+	forceCloseSweepFor = 7 * 24 * time.Hour
+)
+
+// RequestForceClose asks the peer of a channel in the DB to break it, and
+// waits up to wait for the answer
+func (nd *LitNode) RequestForceClose(
+	q *Qchan, reason string, wait time.Duration) (lnutil.ForceCloseRespMsg, error) {
+
+	if !nd.ConnectedToPeer(q.Peer()) {
+		return lnutil.ForceCloseRespMsg{},
+			fmt.Errorf("not connected to peer %d", q.Peer())
+	}
+	// so the close isn't held against the peer; see peerscore.go
+	nd.askedBreaks.add(q.Op)
+	resp, err := nd.askForceClose(q.Peer(), q.KeyGen, q.Op, reason, wait)
+	if err == nil && !resp.Closed {
+		nd.askedBreaks.take(q.Op)
+	}
+	return resp, err
+}
+
+// RequestForceCloseFromBackup asks the peer of a channel in a sealed
+// channel backup to break it, connecting to it if need be, and waits up
+// to wait for the answer.  If it's closed, our side's swept to the wallet
+// once it confirms.
+func (nd *LitNode) RequestForceCloseFromBackup(sealed []byte, op wire.OutPoint,
+	reason string, wait time.Duration) (lnutil.ForceCloseRespMsg, error) {
+
+	var resp lnutil.ForceCloseRespMsg
+	entries, err := nd.OpenChanBackup(sealed)
+	if err != nil {
+		return resp, err
+	}
+	var e *ChanBackupEntry
+	for i := range entries {
+		if lnutil.OutPointsEqual(entries[i].Utxo.Op, op) {
+			e = &entries[i]
+		}
+	}
+	if e == nil {
+		return resp, fmt.Errorf("channel %s isn't in the backup", op.String())
+	}
+	coin := e.Utxo.KeyGen.Step[1] & 0x7fffffff
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return resp, fmt.Errorf("no wallet of type %d connected", coin)
+	}
+
+	pub, err := btcec.ParsePubKey(e.PeerPub[:], btcec.S256())
+	if err != nil {
+		return resp, err
+	}
+	peerIdx, err := nd.GetPeerIdx(pub, e.Host)
+	if err != nil {
+		return resp, err
+	}
+	if !nd.ConnectedToPeer(peerIdx) {
+		adr := lnutil.LitAdrFromPubkey(e.PeerPub)
+		if e.Host != "" {
+			adr += "@" + e.Host
+		}
+		err = nd.DialPeer(adr)
+		if err != nil {
+			return resp, fmt.Errorf("can't reach peer %s: %s", adr, err.Error())
+		}
+	}
+
+	// the close may already have happened; look from a while back
+	from := wal.CurrentHeight()
+	if e.Utxo.Height > 0 {
+		from = e.Utxo.Height
+	}
+	resp, err = nd.askForceClose(peerIdx, e.Utxo.KeyGen, op, reason, wait)
+	if err == nil && resp.Closed {
+		go nd.sweepForceClosed(coin, e.Utxo.KeyGen, from)
+	}
+	return resp, err
+}
+
+// askForceClose signs and sends a force close request, and waits for the
+// answer
+func (nd *LitNode) askForceClose(peerIdx uint32, kg portxo.KeyGen,
+	op wire.OutPoint, reason string,
+	wait time.Duration) (lnutil.ForceCloseRespMsg, error) {
+
+	var resp lnutil.ForceCloseRespMsg
+	coin := kg.Step[1] & 0x7fffffff
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return resp, fmt.Errorf("no wallet of type %d connected", coin)
+	}
+
+	req := lnutil.ForceCloseReqMsg{
+		PeerIdx:  peerIdx,
+		Outpoint: op,
+		Time:     time.Now().Unix(),
+		Reason:   reason,
+	}
+	kg.Step[2] = UseChannelFund
+	hash := req.SigHash()
+	sig, err := wal.GetPriv(kg).Sign(hash[:])
+	if err != nil {
+		return resp, err
+	}
+	req.Signature, err = sig64.SigCompress(sig.Serialize())
+	if err != nil {
+		return resp, err
+	}
+
+	logger.Infof("asking peer %d to force close %s\n", peerIdx, op.String())
+	answer, err := nd.askPeer(req, lnutil.MSGID_FORCECLOSERESP, wait)
+	if err != nil {
+		return resp, err
+	}
+	resp = answer.(lnutil.ForceCloseRespMsg)
+	if !lnutil.OutPointsEqual(resp.Outpoint, op) {
+		return resp, fmt.Errorf("peer %d answered about %s, not %s",
+			peerIdx, resp.Outpoint.String(), op.String())
+	}
+	return resp, nil
+}
+
+// ForceCloseReqHandler breaks a channel its peer's asked to, if the
+// request checks out, and answers either way
+func (nd *LitNode) ForceCloseReqHandler(msg lnutil.ForceCloseReqMsg) error {
+	resp := lnutil.ForceCloseRespMsg{
+		PeerIdx:  msg.Peer(),
+		Outpoint: msg.Outpoint,
+	}
+	refuse := func(why string) error {
+		logger.Warnf("won't force close %s for peer %d: %s\n",
+			msg.Outpoint.String(), msg.Peer(), why)
+		resp.Reason = why
+		nd.OmniOut <- resp
+		return nil
+	}
+
+	q, err := nd.GetQchan(lnutil.OutPointToBytes(msg.Outpoint))
+	// someone else's channel looks the same as none
+	if err != nil || q.Peer() != msg.Peer() {
+		return refuse("no such channel")
+	}
+
+	age := time.Since(time.Unix(msg.Time, 0))
+	if age > forceCloseWindow || age < -forceCloseWindow {
+		return refuse("request too old, or clock off")
+	}
+
+	theirPub, err := btcec.ParsePubKey(q.TheirPub[:], btcec.S256())
+	if err != nil {
+		return refuse("can't use channel's key")
+	}
+	sig, err := btcec.ParseDERSignature(
+		sig64.SigDecompress(msg.Signature), btcec.S256())
+	hash := msg.SigHash()
+	if err != nil || !sig.Verify(hash[:], theirPub) {
+		return refuse("bad signature")
+	}
+
+	if q.CloseData.Closed {
+		resp.Closed = true
+		resp.Txid = q.CloseData.CloseTxid
+		resp.Reason = "already closed"
+		nd.OmniOut <- resp
+		return nil
+	}
+
+	logger.Infof("peer %d asks to force close channel %d: %s\n",
+		msg.Peer(), q.Idx(), msg.Reason)
+	err = nd.BreakChannel(q)
+	if err != nil && !q.CloseData.Closed {
+		return refuse(fmt.Sprintf("can't close: %s", err.Error()))
+	}
+	if err != nil {
+		// saved as closed but the broadcast failed; the tx is ours to
+		// send again, and theirs to look for
+		logger.Errorf("force close %d broadcast: %s\n", q.Idx(), err.Error())
+	}
+	resp.Closed = true
+	resp.Txid = q.CloseData.CloseTxid
+	nd.OmniOut <- resp
+	return nil
+}
+
+// sweepForceClosed sweeps our side of a channel the peer's broken at our
+// request, which pays the refund key, once it's in a block
+func (nd *LitNode) sweepForceClosed(coin uint32, kg portxo.KeyGen, from int32) {
+	wal := nd.SubWallet[coin]
+	kg.Step[2] = UseChannelRefund
+	priv := wal.GetPriv(kg)
+	blocks := nd.blockWaiter()
+	give := time.Now().Add(forceCloseSweepFor)
+	for !nd.ShuttingDown() && time.Now().Before(give) {
+		waitBlock(blocks, time.Hour)
+		tx, total, err := wal.SweepKey(priv, true, false, from, wal.Fee())
+		if err != nil {
+			logger.Debugf("force close sweep: %s\n", err.Error())
+			continue
+		}
+		logger.Infof("swept %d from force closed channel in %s\n",
+			total, tx.TxHash().String())
+		return
+	}
+	logger.Warnf("gave up sweeping force closed channel from height %d\n", from)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/elkrem"
 	"github.com/mit-dci/lit/lnutil"
@@ -98,14 +99,40 @@ an exact timing for the payment.
 */
 
 // FundChannel opens a channel with a peer.  Doesn't return until the channel
-// has been created.  Maybe timeout if it takes too long?
+// has been created, or fundTimeout's gone by; see fundfreeze.go.
 func (nd *LitNode) FundChannel(
 	peerIdx, cointype uint32, ccap, initSend int64) (uint32, error) {
 
-	_, ok := nd.SubWallet[cointype]
+	cIdx, err := nd.startFund(peerIdx, cointype, ccap, initSend, false)
+	if err != nil {
+		return 0, err
+	}
+
+	outMsg := lnutil.NewPointReqMsg(peerIdx, cointype)
+
+	nd.OmniOut <- outMsg
+
+	// wait until it's done!
+	return nd.waitFund(peerIdx, cIdx)
+}
+
+// startFund checks a channel can be made, freezes the coins for it unless
+// it's funded from outside, and sets up InProg for it.  Returns the
+// channel's index.
+func (nd *LitNode) startFund(peerIdx, cointype uint32, ccap, initSend int64,
+	external bool) (uint32, error) {
+
+	wal, ok := nd.SubWallet[cointype]
 	if !ok {
 		return 0, fmt.Errorf("No wallet of type %d connected", cointype)
 	}
+	if nd.ShuttingDown() {
+		return 0, fmt.Errorf("node shutting down")
+	}
+	err := nd.DualRunCheck()
+	if err != nil {
+		return 0, err
+	}
 
 	nd.InProg.mtx.Lock()
 	//	defer nd.InProg.mtx.Lock()
@@ -139,21 +166,26 @@ func (nd *LitNode) FundChannel(
 		return 0, err
 	}
 
+	var reserved *chainhash.Hash
+	if !external {
+		reserved, err = nd.reserveFund(wal, ccap)
+		if err != nil {
+			nd.InProg.mtx.Unlock()
+			return 0, err
+		}
+	}
+
 	nd.InProg.ChanIdx = cIdx
 	nd.InProg.PeerIdx = peerIdx
 	nd.InProg.Amt = ccap
 	nd.InProg.InitSend = initSend
 
 	nd.InProg.Coin = cointype
+	nd.InProg.External = external
+	nd.InProg.reserved = reserved
 	nd.InProg.mtx.Unlock() // switch to defer
 
-	outMsg := lnutil.NewPointReqMsg(peerIdx, cointype)
-
-	nd.OmniOut <- outMsg
-
-	// wait until it's done!
-	idx := <-nd.InProg.done
-	return idx, nil
+	return cIdx, nil
 }
 
 // RECIPIENT
@@ -172,6 +204,13 @@ func (nd *LitNode) PointReqHandler(msg lnutil.PointReqMsg) {
 	// pub req; check that idx matches next idx of ours and create pubkey
 	// peerArr, _ := nd.GetPubHostFromPeerIdx(msg.Peer())
 
+	// don't make channels if this identity is running somewhere else too
+	err := nd.DualRunCheck()
+	if err != nil {
+		fmt.Printf("PointReqHandler err %s", err.Error())
+		return
+	}
+
 	cIdx, err := nd.NextChannelIdx()
 	if err != nil {
 		fmt.Printf("PointReqHandler err %s", err.Error())
@@ -184,13 +223,7 @@ func (nd *LitNode) PointReqHandler(msg lnutil.PointReqMsg) {
 		return
 	}
 
-	var kg portxo.KeyGen
-	kg.Depth = 5
-	kg.Step[0] = 44 | 1<<31
-	kg.Step[1] = msg.Cointype | 1<<31
-	kg.Step[2] = UseChannelFund
-	kg.Step[3] = msg.Peer() | 1<<31
-	kg.Step[4] = cIdx | 1<<31
+	kg := ChanKeyGen(msg.Cointype, msg.Peer(), cIdx)
 
 	myChanPub, _ := nd.GetUsePub(kg, UseChannelFund)
 	myRefundPub, _ := nd.GetUsePub(kg, UseChannelRefund)
@@ -237,12 +270,7 @@ func (nd LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 
 	q.Value = nd.InProg.Amt
 
-	q.KeyGen.Depth = 5
-	q.KeyGen.Step[0] = 44 | 1<<31
-	q.KeyGen.Step[1] = nd.InProg.Coin | 1<<31
-	q.KeyGen.Step[2] = UseChannelFund
-	q.KeyGen.Step[3] = nd.InProg.PeerIdx | 1<<31
-	q.KeyGen.Step[4] = nd.InProg.ChanIdx | 1<<31
+	q.KeyGen = ChanKeyGen(nd.InProg.Coin, nd.InProg.PeerIdx, nd.InProg.ChanIdx)
 
 	q.MyPub, _ = nd.GetUsePub(q.KeyGen, UseChannelFund)
 	q.MyRefundPub, _ = nd.GetUsePub(q.KeyGen, UseChannelRefund)
@@ -277,23 +305,44 @@ func (nd LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 		return err
 	}
 
-	// call MaybeSend, freezing inputs and learning the txid of the channel
-	// here, we require only witness inputs
-	outPoints, err := nd.SubWallet[q.Coin()].MaybeSend([]*wire.TxOut{txo}, true)
+	// someone else is making the fund tx; hand them the output and wait
+	if nd.InProg.External {
+		nd.InProg.extQ = q
+		nd.InProg.extTxo = txo
+		nd.InProg.extOut <- txo
+		return nil
+	}
+
+	// put the channel output in the tx the coins were frozen for in
+	// startFund, learning the txid of the channel
+	if nd.InProg.reserved == nil {
+		return fmt.Errorf("no coins frozen for channel %d", nd.InProg.ChanIdx)
+	}
+	outPoints, err := nd.SubWallet[q.Coin()].SwapFrozenOuts(
+		nd.InProg.reserved, []*wire.TxOut{txo})
 	if err != nil {
 		return err
 	}
 
-	// should only have 1 txout index from MaybeSend, which we use
+	// should only have 1 txout index from SwapFrozenOuts, which we use
 	if len(outPoints) != 1 {
-		return fmt.Errorf("got %d OPs from MaybeSend (expect 1)", len(outPoints))
+		return fmt.Errorf("got %d OPs from SwapFrozenOuts (expect 1)",
+			len(outPoints))
 	}
 
 	// save fund outpoint to inProg
+	nd.InProg.reserved = &outPoints[0].Hash
 	nd.InProg.op = outPoints[0]
 	// also set outpoint in channel
 	q.Op = *nd.InProg.op
 
+	return nd.describeChannel(q, msg.Peer())
+}
+
+// FUNDER
+// describeChannel saves a new channel once its outpoint is known and sends
+// the description to the peer.  Call with InProg locked.
+func (nd *LitNode) describeChannel(q *Qchan, peerIdx uint32) error {
 	// create initial state for elkrem points
 	q.State = new(StatCom)
 	q.State.StateIdx = 0
@@ -301,11 +350,13 @@ func (nd LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	// get fee from sub wallet.  Later should make fee per channel and update state
 	// based on size
 	q.State.Fee = nd.SubWallet[q.Coin()].Fee() * 1000
+	// dropped when the ack comes back if they don't do upfront shutdown
+	q.MyShutdown = nd.shutdownScript(q.Coin())
 
 	// save channel to db
-	err = nd.SaveQChan(q)
+	err := nd.SaveQChan(q)
 	if err != nil {
-		return fmt.Errorf("PointRespHandler SaveQchanState err %s", err.Error())
+		return fmt.Errorf("describeChannel SaveQchanState err %s", err.Error())
 	}
 
 	// when funding a channel, give them the first *3* elkpoints.
@@ -328,9 +379,11 @@ func (nd LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	// initial payment (8), ElkPoint0,1,2 (99)
 
 	outMsg := lnutil.NewChanDescMsg(
-		msg.Peer(), *nd.InProg.op, q.MyPub, q.MyRefundPub, q.MyHAKDBase,
+		peerIdx, *nd.InProg.op, q.MyPub, q.MyRefundPub, q.MyHAKDBase,
 		nd.InProg.Coin, nd.InProg.Amt, nd.InProg.InitSend,
 		elkPointZero, elkPointOne, elkPointTwo)
+	outMsg.Features = nd.LocalChanFeatures()
+	outMsg.ShutdownScript = q.MyShutdown
 
 	nd.OmniOut <- outMsg
 
@@ -348,6 +401,15 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 		return
 	}
 
+	// refuse channels needing something this node can't do
+	myFeatures := nd.LocalChanFeatures()
+	unknown := msg.Features.UnknownRequired(myFeatures)
+	if len(unknown) > 0 {
+		fmt.Printf("QChanDescHandler err channel requires unknown features %v\n",
+			unknown)
+		return
+	}
+
 	// deserialize desc
 	op := msg.Outpoint
 	opArr := lnutil.OutPointToBytes(op)
@@ -362,12 +424,7 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 	qc := new(Qchan)
 
 	qc.Height = -1
-	qc.KeyGen.Depth = 5
-	qc.KeyGen.Step[0] = 44 | 1<<31
-	qc.KeyGen.Step[1] = msg.CoinType | 1<<31
-	qc.KeyGen.Step[2] = UseChannelFund
-	qc.KeyGen.Step[3] = msg.Peer() | 1<<31
-	qc.KeyGen.Step[4] = cIdx | 1<<31
+	qc.KeyGen = ChanKeyGen(msg.CoinType, msg.Peer(), cIdx)
 	qc.Value = amt
 	qc.Mode = portxo.TxoP2WSHComp
 	qc.Op = op
@@ -378,6 +435,15 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 	qc.MyPub, _ = nd.GetUsePub(qc.KeyGen, UseChannelFund)
 	qc.MyRefundPub, _ = nd.GetUsePub(qc.KeyGen, UseChannelRefund)
 	qc.MyHAKDBase, _ = nd.GetUsePub(qc.KeyGen, UseChannelHAKDBase)
+	qc.Features = lnutil.CommonFeatures(msg.Features, myFeatures)
+	qc.TheirShutdown, err = theirShutdown(qc, msg.ShutdownScript)
+	if err != nil {
+		fmt.Printf("QChanDescHandler err %s\n", err.Error())
+		return
+	}
+	if qc.Features.Has(lnutil.FeatureUpfrontShutdown) {
+		qc.MyShutdown = nd.shutdownScript(msg.CoinType)
+	}
 
 	// it should go into the next bucket and get the right key index.
 	// but we can't actually check that.
@@ -445,6 +511,8 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 		msg.Peer(), op,
 		theirElkPointZero, theirElkPointOne, theirElkPointTwo,
 		sig)
+	outMsg.Features = qc.Features
+	outMsg.ShutdownScript = qc.MyShutdown
 	outMsg.Bytes()
 
 	nd.OmniOut <- outMsg
@@ -481,6 +549,23 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 		return
 	}
 
+	// the features they answered with are the ones both have
+	qc.Features = msg.Features
+	qc.TheirShutdown, err = theirShutdown(qc, msg.ShutdownScript)
+	if err != nil {
+		fmt.Printf("QChanAckHandler err %s\n", err.Error())
+		return
+	}
+	if !qc.Features.Has(lnutil.FeatureUpfrontShutdown) {
+		// they won't build the close to it, so it'd never get signed
+		qc.MyShutdown = nil
+	}
+	err = nd.saveChanFeatures(qc)
+	if err != nil {
+		fmt.Printf("QChanAckHandler saveChanFeatures err %s", err.Error())
+		return
+	}
+
 	// verify worked; Save state 1 to DB
 	err = nd.SaveQchanState(qc)
 	if err != nil {
@@ -497,15 +582,30 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 		return
 	}
 
-	// OK to fund.
-	err = nd.SubWallet[qc.Coin()].ReallySend(&qc.Op.Hash)
-	if err != nil {
-		fmt.Printf("QChanAckHandler ReallySend err %s", err.Error())
+	// OK to fund, unless it's been given up on; see fundfreeze.go.
+	// External funding txs get broadcast when the signed version comes in.
+	// InProg stays locked till it's cleared, so it can't be given up on
+	// once it's sent.
+	nd.InProg.mtx.Lock()
+	if !nd.fundInProg(msg.Peer(), qc.Op) {
+		nd.InProg.mtx.Unlock()
+		logger.Warnf("QChanAckHandler: funding %s isn't in progress\n",
+			qc.Op.String())
 		return
 	}
+	external := nd.InProg.External
+	if !external {
+		err = nd.SubWallet[qc.Coin()].ReallySend(&qc.Op.Hash)
+		if err != nil {
+			nd.InProg.mtx.Unlock()
+			fmt.Printf("QChanAckHandler ReallySend err %s", err.Error())
+			return
+		}
+	}
 
 	err = nd.SubWallet[qc.Coin()].WatchThis(qc.Op)
 	if err != nil {
+		nd.InProg.mtx.Unlock()
 		fmt.Printf("QChanAckHandler WatchThis err %s", err.Error())
 		return
 	}
@@ -521,9 +621,13 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 	// channel creation is ~complete, clear InProg.
 	// We may be asked to re-send the sig-proof
 
-	nd.InProg.mtx.Lock()
-	nd.InProg.done <- qc.KeyGen.Step[4] & 0x7fffffff
-	nd.InProg.Clear()
+	if external {
+		// stays in progress until FinishExternalFund
+		nd.InProg.extAcked = true
+	} else {
+		nd.InProg.done <- qc.KeyGen.Step[4] & 0x7fffffff
+		nd.InProg.Clear()
+	}
 	nd.InProg.mtx.Unlock()
 
 	peer.QCs[qc.Idx()] = qc
@@ -0,0 +1,526 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A funding tx can sit unconfirmed for a long time if its fee was too low.
+Until it confirms, the funder can do one of two things about it.
+
+BumpFund replaces it with a tx paying more fee.  The new tx has a new txid,
+so the channel gets a new outpoint, and each side needs the other's sig on
+its commitment spending it.  The funder sends a FundReplaceMsg with its
+sig, the peer moves the channel and answers with its own, and only then
+does the funder broadcast.  Without the peer's sig, coins sent to the new
+tx couldn't be got back.
+
+Both txs spend the same inputs, so only one confirms, but it may be the
+old one.  So the old outpoint and the sig for it stay in BKTFundReplaced
+until the channel confirms, and if the old tx is the one that does, the
+channel moves back.
+
+AbortFund double spends the funding tx back to the wallet, marks the
+channel closed, and tells the peer with a FundAbortMsg.  If the funding tx
+confirms anyway, the channel opens after all.
+
+Neither works once the channel's confirmed, or on a channel funded from
+outside the wallit (see fundext.go), since the wallit can't replace that tx.
+*/
+
+// how long BumpFund waits for the peer to sign for the new outpoint
+const fundBumpTimeout = time.Minute
+
+type fundBumps struct {
+	mtx     sync.Mutex
+	pending map[[36]byte]*fundBump // by the outpoint being replaced
+}
+
+type fundBump struct {
+	newOp wire.OutPoint
+	done  chan error
+}
+
+// unconfirmedFund returns a channel whose funding tx the wallet sent and
+// that hasn't confirmed
+func (nd *LitNode) unconfirmedFund(cIdx uint32) (*Qchan, UWallet, error) {
+	qc, err := nd.GetQchanByIdx(cIdx)
+	if err != nil {
+		return nil, nil, err
+	}
+	wal, ok := nd.SubWallet[qc.Coin()]
+	if !ok {
+		return nil, nil, fmt.Errorf("no wallet for coin type %d", qc.Coin())
+	}
+	if qc.CloseData.Closed {
+		return nil, nil, fmt.Errorf("channel %d is closed", cIdx)
+	}
+	if qc.Height > 0 {
+		return nil, nil, fmt.Errorf("channel %d confirmed at height %d",
+			cIdx, qc.Height)
+	}
+	_, err = wal.GetTx(&qc.Op.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("channel %d wasn't funded by this wallet",
+			cIdx)
+	}
+	return qc, wal, nil
+}
+
+// BumpFund replaces an unconfirmed channel's funding tx with one paying
+// feePerByte, once the peer has signed for it.  Returns the channel's new
+// outpoint.
+func (nd *LitNode) BumpFund(cIdx uint32, feePerByte int64) (*wire.OutPoint, error) {
+	qc, wal, err := nd.unconfirmedFund(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	if !nd.ConnectedToPeer(qc.Peer()) {
+		return nil, fmt.Errorf("not connected to peer %d, who has to sign "+
+			"for the new funding tx", qc.Peer())
+	}
+	opArr := lnutil.OutPointToBytes(qc.Op)
+
+	nd.fundBumps.mtx.Lock()
+	if _, ok := nd.fundBumps.pending[opArr]; ok {
+		nd.fundBumps.mtx.Unlock()
+		return nil, fmt.Errorf("channel %d is already being bumped", cIdx)
+	}
+	tx, err := wal.MaybeReplace(&qc.Op.Hash, feePerByte)
+	if err != nil {
+		nd.fundBumps.mtx.Unlock()
+		return nil, err
+	}
+	txid := tx.TxHash()
+	newOp, sig, err := nd.signFundBump(qc, tx)
+	if err != nil {
+		nd.fundBumps.mtx.Unlock()
+		wal.NahDontSend(&txid)
+		return nil, err
+	}
+	b := &fundBump{newOp: *newOp, done: make(chan error, 1)}
+	nd.fundBumps.pending[opArr] = b
+	nd.fundBumps.mtx.Unlock()
+
+	nd.OmniOut <- lnutil.FundReplaceMsg{PeerIdx: qc.Peer(),
+		Outpoint: qc.Op, NewOutpoint: *newOp, Signature: sig}
+	err = nd.waitFundBump(opArr, b, qc.Peer())
+	if err != nil {
+		wal.NahDontSend(&txid)
+		return nil, err
+	}
+	return newOp, nil
+}
+
+// signFundBump finds the channel's output in a replacement funding tx, and
+// signs the peer's commitment spending it
+func (nd *LitNode) signFundBump(
+	qc *Qchan, tx *wire.MsgTx) (*wire.OutPoint, [64]byte, error) {
+	var sig [64]byte
+	newOp, err := fundOutpoint(qc, tx)
+	if err != nil {
+		return nil, sig, err
+	}
+	oldOp := qc.Op
+	qc.Op = *newOp
+	sig, err = nd.SignState(qc)
+	qc.Op = oldOp
+	return newOp, sig, err
+}
+
+// waitFundBump waits for the peer's answer to a bump
+func (nd *LitNode) waitFundBump(opArr [36]byte, b *fundBump, peerIdx uint32) error {
+	select {
+	case err := <-b.done:
+		return err
+	case <-time.After(fundBumpTimeout):
+	}
+	nd.fundBumps.mtx.Lock()
+	_, ok := nd.fundBumps.pending[opArr]
+	delete(nd.fundBumps.pending, opArr)
+	nd.fundBumps.mtx.Unlock()
+	if !ok {
+		// the answer came just now
+		return <-b.done
+	}
+	return fmt.Errorf("peer %d didn't sign for the new funding tx", peerIdx)
+}
+
+// fundOutpoint finds a channel's output in a funding tx
+func fundOutpoint(qc *Qchan, tx *wire.MsgTx) (*wire.OutPoint, error) {
+	txo, err := lnutil.FundTxOut(qc.MyPub, qc.TheirPub, qc.Value)
+	if err != nil {
+		return nil, err
+	}
+	txid := tx.TxHash()
+	for i, out := range tx.TxOut {
+		if out.Value == txo.Value && bytes.Equal(out.PkScript, txo.PkScript) {
+			return wire.NewOutPoint(&txid, uint32(i)), nil
+		}
+	}
+	return nil, fmt.Errorf("tx %s doesn't fund channel %d",
+		txid.String(), qc.Idx())
+}
+
+// FundReplaceHandler moves a channel to the outpoint the funder is
+// replacing its funding tx with, and signs for it; or, with Ack set, takes
+// the peer's sig and broadcasts the replacement.
+func (nd *LitNode) FundReplaceHandler(msg lnutil.FundReplaceMsg) error {
+	if msg.Ack {
+		return nd.fundReplaceAck(msg)
+	}
+	qc, err := nd.GetQchan(lnutil.OutPointToBytes(msg.Outpoint))
+	if err != nil {
+		return err
+	}
+	if qc.Peer() != msg.Peer() {
+		return fmt.Errorf("peer %d asked to move channel %d of peer %d",
+			msg.Peer(), qc.Idx(), qc.Peer())
+	}
+	if qc.CloseData.Closed || qc.Height > 0 {
+		return fmt.Errorf("channel %d closed or confirmed; can't replace "+
+			"its funding", qc.Idx())
+	}
+	wal, ok := nd.SubWallet[qc.Coin()]
+	if !ok {
+		return fmt.Errorf("no wallet for coin type %d", qc.Coin())
+	}
+
+	oldSig := qc.State.sig
+	qc.Op = msg.NewOutpoint
+	err = qc.VerifySig(msg.Signature)
+	if err != nil {
+		return err
+	}
+	sig, err := nd.SignState(qc)
+	if err != nil {
+		return err
+	}
+	err = nd.moveFund(qc, msg.Outpoint, oldSig)
+	if err != nil {
+		return err
+	}
+	err = wal.WatchThis(qc.Op)
+	if err != nil {
+		return err
+	}
+	logger.Infof("channel %d funding replaced: %s now %s\n",
+		qc.Idx(), msg.Outpoint.String(), qc.Op.String())
+	nd.notePeerFeeBump(msg.Peer())
+
+	nd.OmniOut <- lnutil.FundReplaceMsg{PeerIdx: msg.Peer(), Ack: true,
+		Outpoint: msg.Outpoint, NewOutpoint: qc.Op, Signature: sig}
+	return nil
+}
+
+// fundReplaceAck hands the peer's answer to the BumpFund waiting for it
+func (nd *LitNode) fundReplaceAck(msg lnutil.FundReplaceMsg) error {
+	opArr := lnutil.OutPointToBytes(msg.Outpoint)
+	nd.fundBumps.mtx.Lock()
+	b, ok := nd.fundBumps.pending[opArr]
+	if ok && lnutil.OutPointsEqual(b.newOp, msg.NewOutpoint) {
+		delete(nd.fundBumps.pending, opArr)
+	} else {
+		ok = false
+	}
+	nd.fundBumps.mtx.Unlock()
+	if !ok {
+		return fmt.Errorf("fund replace ack for %s, but no bump to %s waiting",
+			msg.Outpoint.String(), msg.NewOutpoint.String())
+	}
+	b.done <- nd.finishFundBump(msg)
+	return nil
+}
+
+// finishFundBump moves the channel to the replacement tx the peer signed
+// for, and broadcasts it
+func (nd *LitNode) finishFundBump(msg lnutil.FundReplaceMsg) error {
+	qc, err := nd.GetQchan(lnutil.OutPointToBytes(msg.Outpoint))
+	if err != nil {
+		return err
+	}
+	if qc.Peer() != msg.Peer() {
+		return fmt.Errorf("peer %d signed for channel %d of peer %d",
+			msg.Peer(), qc.Idx(), qc.Peer())
+	}
+	wal, ok := nd.SubWallet[qc.Coin()]
+	if !ok {
+		return fmt.Errorf("no wallet for coin type %d", qc.Coin())
+	}
+	oldSig := qc.State.sig
+	qc.Op = msg.NewOutpoint
+	err = qc.VerifySig(msg.Signature)
+	if err != nil {
+		return err
+	}
+	err = nd.moveFund(qc, msg.Outpoint, oldSig)
+	if err != nil {
+		return err
+	}
+	err = wal.ReallySend(&qc.Op.Hash)
+	if err != nil {
+		// the old tx is still the one out there
+		newSig := qc.State.sig
+		qc.Op = msg.Outpoint
+		qc.State.sig = oldSig
+		moveErr := nd.moveFund(qc, msg.NewOutpoint, newSig)
+		if moveErr != nil {
+			logger.Errorf("channel %d moving back to %s: %s\n",
+				qc.Idx(), msg.Outpoint.String(), moveErr.Error())
+		}
+		return err
+	}
+	err = wal.WatchThis(qc.Op)
+	if err != nil {
+		return err
+	}
+	logger.Infof("channel %d funding replaced: %s now %s\n",
+		qc.Idx(), msg.Outpoint.String(), qc.Op.String())
+	return nil
+}
+
+// moveFund moves a channel, with its new outpoint and sig already set in
+// qc, from where it was.  The old outpoint and sig are kept in case that
+// tx confirms instead.
+func (nd *LitNode) moveFund(qc *Qchan, from wire.OutPoint, fromSig [64]byte) error {
+	fromArr := lnutil.OutPointToBytes(from)
+	toArr := lnutil.OutPointToBytes(qc.Op)
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return fmt.Errorf("no channels")
+		}
+		old := cbk.Bucket(fromArr[:])
+		if old == nil {
+			return fmt.Errorf("outpoint %s not in db", from.String())
+		}
+		to, err := cbk.CreateBucket(toArr[:])
+		if err != nil {
+			return fmt.Errorf("channel at %s: %s", qc.Op.String(), err.Error())
+		}
+		err = copyBucket(to, old)
+		if err != nil {
+			return err
+		}
+		qcBytes, err := qc.ToBytes()
+		if err != nil {
+			return err
+		}
+		err = to.Put(KEYutxo, qcBytes)
+		if err != nil {
+			return err
+		}
+		stBytes, err := qc.State.ToBytes()
+		if err != nil {
+			return err
+		}
+		err = to.Put(KEYState, stBytes)
+		if err != nil {
+			return err
+		}
+		err = cbk.DeleteBucket(fromArr[:])
+		if err != nil {
+			return err
+		}
+		err = btx.Bucket(BKTChanMap).Put(lnutil.U32tB(qc.Idx()), toArr[:])
+		if err != nil {
+			return err
+		}
+		rpb := btx.Bucket(BKTFundReplaced)
+		err = rpb.Put(fromArr[:], append(lnutil.U32tB(qc.Idx()), fromSig[:]...))
+		if err != nil {
+			return err
+		}
+		return rpb.Delete(toArr[:])
+	})
+	if err != nil {
+		return err
+	}
+
+	nd.RemoteMtx.Lock()
+	if peer, ok := nd.RemoteCons[qc.Peer()]; ok {
+		peer.QCs[qc.Idx()] = qc
+		delete(peer.OpMap, fromArr)
+		peer.OpMap[toArr] = qc.Idx()
+	}
+	nd.RemoteMtx.Unlock()
+
+	// the backup has outpoints
+	nd.chanBackupChanged()
+	return nil
+}
+
+// AbortFund double spends an unconfirmed channel's funding tx back to the
+// wallet at feePerByte, and closes the channel.  Returns the txid.
+func (nd *LitNode) AbortFund(cIdx uint32, feePerByte int64) (*chainhash.Hash, error) {
+	qc, wal, err := nd.unconfirmedFund(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	opArr := lnutil.OutPointToBytes(qc.Op)
+	nd.fundBumps.mtx.Lock()
+	_, bumping := nd.fundBumps.pending[opArr]
+	nd.fundBumps.mtx.Unlock()
+	if bumping {
+		return nil, fmt.Errorf("channel %d is being bumped", cIdx)
+	}
+
+	txid, err := wal.DoubleSpend(&qc.Op.Hash, feePerByte)
+	if err != nil {
+		return nil, err
+	}
+	err = nd.abortFund(qc, *txid)
+	if err != nil {
+		return nil, err
+	}
+	if nd.ConnectedToPeer(qc.Peer()) {
+		nd.OmniOut <- lnutil.FundAbortMsg{PeerIdx: qc.Peer(), Outpoint: qc.Op}
+	}
+	return txid, nil
+}
+
+// FundAbortHandler closes a channel whose funder double spent its funding
+func (nd *LitNode) FundAbortHandler(msg lnutil.FundAbortMsg) error {
+	// a fund we're still negotiating; its coins are let go too
+	nd.InProg.mtx.Lock()
+	inProg := nd.fundInProg(msg.Peer(), msg.Outpoint)
+	nd.InProg.mtx.Unlock()
+	if inProg && nd.failFund(msg.Peer(), 0,
+		fmt.Errorf("peer %d aborted the fund", msg.Peer())) {
+		return nil
+	}
+
+	qc, err := nd.GetQchan(lnutil.OutPointToBytes(msg.Outpoint))
+	if err != nil {
+		return err
+	}
+	if qc.Peer() != msg.Peer() {
+		return fmt.Errorf("peer %d aborted channel %d of peer %d",
+			msg.Peer(), qc.Idx(), qc.Peer())
+	}
+	if qc.CloseData.Closed {
+		return nil
+	}
+	if qc.Height > 0 {
+		return fmt.Errorf("peer %d aborted channel %d, confirmed at height %d",
+			msg.Peer(), qc.Idx(), qc.Height)
+	}
+	// we don't see the tx double spending it, so there's no txid
+	return nd.abortFund(qc, chainhash.Hash{})
+}
+
+// abortFund marks a channel closed without its funding tx confirming
+func (nd *LitNode) abortFund(qc *Qchan, txid chainhash.Hash) error {
+	qc.CloseData.Closed = true
+	qc.CloseData.CloseTxid = txid
+	qc.CloseData.CloseHeight = 0
+	err := nd.SaveQchanUtxoData(qc)
+	if err != nil {
+		return err
+	}
+	opArr := lnutil.OutPointToBytes(qc.Op)
+	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+		qcBucket := btx.Bucket(BKTChannel).Bucket(opArr[:])
+		if qcBucket == nil {
+			return fmt.Errorf("outpoint %s not in db", qc.Op.String())
+		}
+		return qcBucket.Put(KEYFundAbort, txid[:])
+	})
+	if err != nil {
+		return err
+	}
+	logger.Warnf("channel %d funding %s aborted\n", qc.Idx(), qc.Op.String())
+	ev := chanEvent(EventChanClosed, qc)
+	ev.Txid = txid.String()
+	nd.PublishEvent(ev)
+	return nil
+}
+
+// replacedFundConfirmed moves a channel back to a funding outpoint it was
+// moved off of, if ev says that tx confirmed after all.  Returns the
+// channel, or nil if ev isn't one of those.
+func (nd *LitNode) replacedFundConfirmed(ev lnutil.OutPointEvent) *Qchan {
+	if ev.Tx != nil || ev.Height < 1 {
+		return nil
+	}
+	opArr := lnutil.OutPointToBytes(ev.Op)
+	var v []byte
+	nd.LitDB.View(func(btx *bolt.Tx) error {
+		if rpb := btx.Bucket(BKTFundReplaced); rpb != nil {
+			v = append(v, rpb.Get(opArr[:])...)
+		}
+		return nil
+	})
+	if len(v) != 68 {
+		return nil
+	}
+	qc, err := nd.GetQchanByIdx(lnutil.BtU32(v[:4]))
+	if err != nil {
+		logger.Errorf("replaced funding %s: %s\n", ev.Op.String(), err.Error())
+		return nil
+	}
+	from, fromSig := qc.Op, qc.State.sig
+	qc.Op = ev.Op
+	copy(qc.State.sig[:], v[4:])
+	err = nd.moveFund(qc, from, fromSig)
+	if err != nil {
+		logger.Errorf("replaced funding %s: %s\n", ev.Op.String(), err.Error())
+		return nil
+	}
+	logger.Warnf("channel %d funding %s confirmed instead of its "+
+		"replacement %s\n", qc.Idx(), ev.Op.String(), from.String())
+	return qc
+}
+
+// fundConfirmed forgets the funding outpoints a channel had before the one
+// that confirmed, and opens it again if it was aborted
+func (nd *LitNode) fundConfirmed(qc *Qchan) error {
+	opArr := lnutil.OutPointToBytes(qc.Op)
+	aborted := false
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		rpb := btx.Bucket(BKTFundReplaced)
+		var kill [][]byte
+		err := rpb.ForEach(func(k, v []byte) error {
+			if len(v) >= 4 && lnutil.BtU32(v[:4]) == qc.Idx() {
+				kill = append(kill, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range kill {
+			err = rpb.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		qcBucket := btx.Bucket(BKTChannel).Bucket(opArr[:])
+		if qcBucket == nil || qcBucket.Get(KEYFundAbort) == nil {
+			return nil
+		}
+		aborted = true
+		err = qcBucket.Delete(KEYFundAbort)
+		if err != nil {
+			return err
+		}
+		return qcBucket.Delete(KEYqclose)
+	})
+	if err != nil {
+		return err
+	}
+	if aborted {
+		logger.Warnf("channel %d was aborted, but its funding confirmed\n",
+			qc.Idx())
+		qc.CloseData = QCloseData{}
+	}
+	return nil
+}
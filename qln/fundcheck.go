@@ -0,0 +1,213 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+)
+
+/*
+DryRunFund goes through what opening a channel needs, without opening it:
+nothing's sent to the peer, no coins are frozen and InProg isn't touched.
+It's for finding out why a fund would fail before asking for one.
+
+Each check gets a line in the report, passed or not, and all of them are
+done even after one fails, so the report says everything that's wrong at
+once.  The checks:
+
+	wallet    there's a wallet for the coin
+	node      not shutting down or running twice, no other fund going
+	amounts   capacity at least liqMinOpen, send within it
+	peer      connected to the peer
+	features  the peer doesn't need features this node doesn't know
+	funds     confirmed witness coins cover capacity and the fund tx fee
+	reserve   each side's starting balance, if not 0, can pay its output
+	          minimum and the commitment fee
+
+The fund tx fee is estimated the way the wallet picks coins: biggest
+first, at the wallet's fee rate.  Coins frozen for a tx that hasn't gone
+out yet are counted, since the wallet doesn't say which they are, so the
+fee and funds can come out a bit better than they will be.
+*/
+
+// FundCheck is one thing DryRunFund looked at
+type FundCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// FundReport is what DryRunFund found
+type FundReport struct {
+	OK     bool // all the checks passed
+	Checks []FundCheck
+
+	Spendable int64 // confirmed witness coins the fund tx can use
+	FeeRate   int64 // sat/byte
+	Inputs    int   // how many coins the fund tx would spend
+	Fee       int64 // fund tx fee estimate
+	CommitFee int64 // each side's fee in the channel's commitment txs
+}
+
+func (r *FundReport) check(name string, ok bool, format string, a ...interface{}) {
+	r.Checks = append(r.Checks, FundCheck{
+		Name: name, OK: ok, Detail: fmt.Sprintf(format, a...)})
+	if !ok {
+		r.OK = false
+	}
+}
+
+// DryRunFund checks whether a channel could be opened with a peer, and
+// what it'd cost, without opening it
+func (nd *LitNode) DryRunFund(
+	peerIdx, cointype uint32, ccap, initSend int64) (FundReport, error) {
+
+	r := FundReport{OK: true}
+
+	wal, ok := nd.SubWallet[cointype]
+	r.check("wallet", ok, "coin type %d", cointype)
+
+	var busy []string
+	if nd.ShuttingDown() {
+		busy = append(busy, "shutting down")
+	}
+	if err := nd.DualRunCheck(); err != nil {
+		busy = append(busy, err.Error())
+	}
+	nd.InProg.mtx.Lock()
+	if nd.InProg.PeerIdx != 0 {
+		busy = append(busy,
+			fmt.Sprintf("fund with peer %d not done yet", nd.InProg.PeerIdx))
+	}
+	nd.InProg.mtx.Unlock()
+	if len(busy) > 0 {
+		r.check("node", false, "%s", strings.Join(busy, "; "))
+	} else {
+		r.check("node", true, "ready")
+	}
+
+	switch {
+	case initSend < 0 || ccap < 0:
+		r.check("amounts", false, "can't have negative send or capacity")
+	case ccap < liqMinOpen:
+		r.check("amounts", false, "capacity %d under the minimum %d",
+			ccap, liqMinOpen)
+	case initSend > ccap:
+		r.check("amounts", false, "can't send %d in %d capacity channel",
+			initSend, ccap)
+	default:
+		r.check("amounts", true, "capacity %d, send %d", ccap, initSend)
+	}
+
+	connected := nd.ConnectedToPeer(peerIdx)
+	if connected {
+		_, host := nd.GetPubHostFromPeerIdx(peerIdx)
+		r.check("peer", true, "connected to peer %d %s", peerIdx, host)
+	} else {
+		r.check("peer", false, "not connected to peer %d", peerIdx)
+	}
+
+	feats, err := nd.PeerFeatures(peerIdx)
+	switch {
+	case err != nil:
+		r.check("features", false, "%s", err.Error())
+	case feats == nil && connected:
+		r.check("features", true,
+			"peer hasn't sent features; opens without upfront shutdown")
+	case feats == nil:
+		r.check("features", false, "peer %d has never sent features", peerIdx)
+	default:
+		unknown := feats.UnknownRequired(nd.LocalFeatures())
+		if len(unknown) > 0 {
+			r.check("features", false, "peer requires unknown features %v",
+				unknown)
+		} else {
+			r.check("features", true, "peer has %s", feats.String())
+		}
+	}
+
+	if wal == nil {
+		r.check("funds", false, "no wallet")
+		r.check("reserve", false, "no wallet")
+		return r, nil
+	}
+
+	r.FeeRate = wal.Fee()
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return r, err
+	}
+	height := wal.CurrentHeight()
+	r.Spendable = portxo.TxoSliceByAmt(utxos).SumWitness(height)
+	r.Inputs, r.Fee = fundFee(utxos, height, ccap, r.FeeRate)
+	// the fund RPC leaves at least this much for the fee
+	need := ccap + r.Fee
+	if r.Fee < liqFeeMargin {
+		need = ccap + liqFeeMargin
+	}
+	if r.Spendable < need {
+		r.check("funds", false,
+			"need %d (capacity %d, fee about %d, at least %d kept for it) "+
+				"but %d confirmed", need, ccap, r.Fee, liqFeeMargin, r.Spendable)
+	} else {
+		r.check("funds", true, "%d confirmed; fee about %d at %d sat/byte, "+
+			"%d inputs", r.Spendable, r.Fee, r.FeeRate, r.Inputs)
+	}
+
+	// each side's output has to clear minOutput after paying the fee,
+	// unless it's not there at all
+	r.CommitFee = r.FeeRate * 1000
+	least := minOutput + r.CommitFee
+	var low []string
+	if mine := ccap - initSend; mine != 0 && mine < least {
+		low = append(low, fmt.Sprintf("my %d", mine))
+	}
+	if initSend != 0 && initSend < least {
+		low = append(low, fmt.Sprintf("their %d", initSend))
+	}
+	if len(low) > 0 {
+		r.check("reserve", false, "%s under %d (output minimum %d, fee %d)",
+			strings.Join(low, ", "), least, minOutput, r.CommitFee)
+	} else {
+		r.check("reserve", true, "each side at least %d or nothing", least)
+	}
+
+	return r, nil
+}
+
+// fundFee picks coins for a fund tx the way the wallet does, biggest
+// first, and estimates the fee.  0 inputs if there aren't enough.
+func fundFee(
+	utxos portxo.TxoSliceByAmt, height int32, ccap, rate int64) (int, int64) {
+
+	var usable portxo.TxoSliceByAmt
+	for _, u := range utxos {
+		if u.Mode&portxo.FlagTxoWitness != 0 && u.Height > 0 &&
+			u.Value > 0 && u.Mature(height) {
+			usable = append(usable, u)
+		}
+	}
+	sort.Sort(sort.Reverse(usable))
+	// skip the biggest while the next 2 cover it, as PickUtxos does
+	for len(usable) > 2 && usable[1].Value+usable[2].Value > ccap {
+		usable = usable[1:]
+	}
+
+	// a p2wsh output's the same size whatever the keys
+	txos := []*wire.TxOut{wire.NewTxOut(ccap, make([]byte, 34))}
+	var ins []*portxo.PorTxo
+	var sum int64
+	for _, u := range usable {
+		ins = append(ins, u)
+		sum += u.Value
+		fee := wallit.EstFee(ins, txos, rate)
+		if sum >= ccap+fee {
+			return len(ins), fee
+		}
+	}
+	return 0, wallit.EstFee(ins, txos, rate)
+}
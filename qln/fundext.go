@@ -0,0 +1,153 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Channels can be funded by a tx the wallit doesn't make, like one from a
+hardware wallet or an exchange withdrawal, so coins don't have to go to
+the wallit first.
+
+FundChannelExternal  gets keys from the peer and returns the channel
+                     output (script & amount) the funding tx has to make.
+VerifyExternalFund   takes the funding tx, unsigned (a PSBT is fine), finds
+                     the channel output and sends the channel description.
+                     The peer signs our first commitment in reply.
+FinishExternalFund   takes the signed funding tx and broadcasts it.  Don't
+                     sign before VerifyExternalFund: until the peer's
+                     signature comes back there's no way to get coins sent
+                     to the channel back.
+CancelExternalFund   gives up, so another channel can be funded.
+
+The txid can't change between the unsigned and signed txs, so the funding
+tx must only spend segwit inputs.
+*/
+
+// FundChannelExternal starts a channel to be funded by an outside tx.
+// Returns the output that tx needs to have.
+func (nd *LitNode) FundChannelExternal(
+	peerIdx, cointype uint32, ccap, initSend int64) (*wire.TxOut, error) {
+
+	_, err := nd.startFund(peerIdx, cointype, ccap, initSend, true)
+	if err != nil {
+		return nil, err
+	}
+
+	nd.OmniOut <- lnutil.NewPointReqMsg(peerIdx, cointype)
+
+	// wait for the point response to get the channel pubkeys
+	return <-nd.InProg.extOut, nil
+}
+
+// VerifyExternalFund checks that an unsigned funding tx makes the channel
+// output, and sends the channel description to the peer.  The tx can be
+// a hex raw tx or a hex / base64 PSBT.
+func (nd *LitNode) VerifyExternalFund(txstr string) (*wire.OutPoint, error) {
+	tx, _, err := lnutil.ParseExternalTx(txstr)
+	if err != nil {
+		return nil, err
+	}
+
+	nd.InProg.mtx.Lock()
+	defer nd.InProg.mtx.Unlock()
+
+	if !nd.InProg.External || nd.InProg.extQ == nil {
+		return nil, fmt.Errorf("no external funding waiting for a tx")
+	}
+	if nd.InProg.op != nil {
+		return nil, fmt.Errorf("already have funding tx %s",
+			nd.InProg.op.Hash.String())
+	}
+
+	var op *wire.OutPoint
+	txid := tx.TxHash()
+	for i, out := range tx.TxOut {
+		if out.Value == nd.InProg.extTxo.Value &&
+			bytes.Equal(out.PkScript, nd.InProg.extTxo.PkScript) {
+			if op != nil {
+				return nil, fmt.Errorf("tx has more than one channel output")
+			}
+			op = wire.NewOutPoint(&txid, uint32(i))
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("tx %s has no output of %d to the channel",
+			txid.String(), nd.InProg.extTxo.Value)
+	}
+	// non-witness inputs would let the txid change when signed
+	for i, in := range tx.TxIn {
+		if len(in.SignatureScript) != 0 {
+			return nil, fmt.Errorf("input %d isn't segwit", i)
+		}
+	}
+
+	nd.InProg.op = op
+	q := nd.InProg.extQ
+	q.Op = *op
+	err = nd.describeChannel(q, nd.InProg.PeerIdx)
+	if err != nil {
+		nd.InProg.op = nil
+		return nil, err
+	}
+	return op, nil
+}
+
+// FinishExternalFund broadcasts the signed funding tx once the peer has
+// signed our commitment.  Returns the channel index.
+func (nd *LitNode) FinishExternalFund(txstr string) (uint32, error) {
+	tx, complete, err := lnutil.ParseExternalTx(txstr)
+	if err != nil {
+		return 0, err
+	}
+	if !complete {
+		return 0, fmt.Errorf("funding tx isn't fully signed")
+	}
+
+	nd.InProg.mtx.Lock()
+	defer nd.InProg.mtx.Unlock()
+
+	if !nd.InProg.External || nd.InProg.op == nil {
+		return 0, fmt.Errorf("no external funding waiting to broadcast")
+	}
+	if !nd.InProg.extAcked {
+		return 0, fmt.Errorf("peer %d hasn't signed yet; try again",
+			nd.InProg.PeerIdx)
+	}
+	txid := tx.TxHash()
+	if !txid.IsEqual(&nd.InProg.op.Hash) {
+		return 0, fmt.Errorf("signed tx %s isn't funding tx %s",
+			txid.String(), nd.InProg.op.Hash.String())
+	}
+
+	err = nd.SubWallet[nd.InProg.Coin].PushTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	idx := nd.InProg.ChanIdx
+	nd.InProg.Clear()
+	return idx, nil
+}
+
+// CancelExternalFund gives up on an external funding.  If the peer already
+// signed, the channel stays in the db but never opens unless the funding
+// tx is broadcast some other way.
+func (nd *LitNode) CancelExternalFund() error {
+	nd.InProg.mtx.Lock()
+	defer nd.InProg.mtx.Unlock()
+
+	if !nd.InProg.External {
+		return fmt.Errorf("no external funding in progress")
+	}
+	// drop an output nobody picked up
+	select {
+	case <-nd.InProg.extOut:
+	default:
+	}
+	nd.InProg.Clear()
+	return nil
+}
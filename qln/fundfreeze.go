@@ -0,0 +1,121 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+The coins for a funding tx are frozen as soon as the fund starts, before
+anything's asked of the peer, so a send made while the peer's answering
+can't take them.  The channel output isn't known until the peer gives its
+channel pubkey, so startFund freezes them with MaybeSend for a placeholder
+the size of the channel output, and PointRespHandler swaps in the real one
+with SwapFrozenOuts; the inputs and fee stay the same.
+
+If the fund doesn't get as far as broadcasting, the coins are let go and
+InProg cleared, so another fund can start, when:
+
+	the peer hasn't acked within fundTimeout of the start
+	the peer disconnects before acking
+	the peer sends a FundAbortMsg for it
+
+A channel already saved for it is marked aborted, as AbortFund does, and
+the peer's told.  The ack handler checks the fund's still in progress
+before broadcasting, so an ack that comes in after that is ignored.
+
+Funds from outside the wallet (fundext.go) have no coins of ours to hold
+and are left alone.
+*/
+
+// how long from starting a fund to the peer's ack before it's given up
+const fundTimeout = 2 * time.Minute
+
+// reserveFund freezes coins for a channel of ccap, returning the txid
+// they're frozen under.  Call with InProg locked.
+func (nd *LitNode) reserveFund(wal UWallet, ccap int64) (*chainhash.Hash, error) {
+	// a p2wsh output's the same size whatever the keys
+	placeholder := wire.NewTxOut(ccap, make([]byte, 34))
+	ops, err := wal.MaybeSend([]*wire.TxOut{placeholder}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("got %d OPs from MaybeSend (expect 1)", len(ops))
+	}
+	return &ops[0].Hash, nil
+}
+
+// waitFund waits for a fund startFund set up to finish, giving up on it
+// after fundTimeout
+func (nd *LitNode) waitFund(peerIdx, cIdx uint32) (uint32, error) {
+	select {
+	case idx := <-nd.InProg.done:
+		return idx, nil
+	case err := <-nd.InProg.failed:
+		return 0, err
+	case <-time.After(fundTimeout):
+	}
+	nd.failFund(peerIdx, cIdx,
+		fmt.Errorf("peer %d didn't finish the fund in %s", peerIdx, fundTimeout))
+	// either that failed it, or it finished just now
+	select {
+	case idx := <-nd.InProg.done:
+		return idx, nil
+	case err := <-nd.InProg.failed:
+		return 0, err
+	}
+}
+
+// failFund gives up on the fund in progress with a peer, if there is one
+// and it's not been broadcast: frees its coins, aborts any channel saved
+// for it, and clears InProg.  cIdx 0 means whichever channel it is.
+// Returns whether there was one.
+func (nd *LitNode) failFund(peerIdx, cIdx uint32, why error) bool {
+	nd.InProg.mtx.Lock()
+	defer nd.InProg.mtx.Unlock()
+	if nd.InProg.PeerIdx == 0 || nd.InProg.PeerIdx != peerIdx ||
+		nd.InProg.External || (cIdx != 0 && nd.InProg.ChanIdx != cIdx) {
+		return false
+	}
+	logger.Warnf("fund of channel %d with peer %d failed: %s\n",
+		nd.InProg.ChanIdx, peerIdx, why.Error())
+
+	wal, ok := nd.SubWallet[nd.InProg.Coin]
+	if ok && nd.InProg.reserved != nil {
+		err := wal.NahDontSend(nd.InProg.reserved)
+		if err != nil {
+			logger.Errorf("failFund NahDontSend err %s\n", err.Error())
+		}
+	}
+	if nd.InProg.op != nil {
+		qc, err := nd.GetQchan(lnutil.OutPointToBytes(*nd.InProg.op))
+		if err == nil {
+			err = nd.abortFund(qc, chainhash.Hash{})
+			if nd.ConnectedToPeer(peerIdx) {
+				nd.OmniOut <- lnutil.FundAbortMsg{PeerIdx: peerIdx, Outpoint: qc.Op}
+			}
+		}
+		if err != nil {
+			logger.Errorf("failFund abort err %s\n", err.Error())
+		}
+	}
+
+	nd.InProg.Clear()
+	select {
+	case nd.InProg.failed <- why:
+	default:
+	}
+	return true
+}
+
+// fundInProg says whether op is the funding outpoint of the fund in
+// progress with a peer.  Call with InProg locked.
+func (nd *LitNode) fundInProg(peerIdx uint32, op wire.OutPoint) bool {
+	return nd.InProg.PeerIdx == peerIdx && nd.InProg.op != nil &&
+		lnutil.OutPointsEqual(*nd.InProg.op, op)
+}
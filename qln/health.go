@@ -0,0 +1,92 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/watchtower"
+)
+
+// how many blocks behind the header tip a wallet can be and still be synced
+const syncSlack = 1
+
+// headerTipper is a chainhook which knows how many headers it has.
+// uspv does; other chainhooks might not.
+type headerTipper interface {
+	GetHeaderTipHeight() int32
+}
+
+// CoinHealth is the sync state of one wallet
+type CoinHealth struct {
+	CoinType   uint32
+	SyncHeight int32 // height the wallet has processed
+	HeaderTip  int32 // height of the best header; 0 if unknown
+	Synced     bool
+	// timelocked outputs from closed channels which still need to be
+	// swept to the wallet
+	PendingSweeps uint32
+}
+
+// NodeHealth is a summary of whether the node is working.  Problems has
+// a short description of anything wrong; Healthy is true if it's empty.
+type NodeHealth struct {
+	Healthy bool
+	Coins   []CoinHealth
+	Peers   uint32 // connected peers
+	// keys are decrypted at startup, so this is false while running.
+	// There's no runtime lock yet.
+	WalletLocked bool
+	TowerActive  bool
+	Problems     []string
+}
+
+// Health checks the wallets, peers and tower.
+func (nd *LitNode) Health() NodeHealth {
+	var h NodeHealth
+
+	if nd.LitDB == nil {
+		h.Problems = append(h.Problems, "ln db not open")
+	}
+
+	for cointype, wal := range nd.SubWallet {
+		var ch CoinHealth
+		ch.CoinType = cointype
+		ch.SyncHeight = wal.CurrentHeight()
+		ch.Synced = true
+
+		tipper, ok := wal.ExportHook().(headerTipper)
+		if ok {
+			ch.HeaderTip = tipper.GetHeaderTipHeight()
+			ch.Synced = ch.HeaderTip-ch.SyncHeight <= syncSlack
+		}
+		if !ch.Synced {
+			h.Problems = append(h.Problems,
+				fmt.Sprintf("coin %d syncing %d of %d",
+					cointype, ch.SyncHeight, ch.HeaderTip))
+		}
+
+		txos, err := wal.UtxoDump()
+		if err != nil {
+			h.Problems = append(h.Problems,
+				fmt.Sprintf("coin %d utxo error %s", cointype, err.Error()))
+		}
+		for _, u := range txos {
+			// only channel close outputs have a sequence delay
+			if u.Seq != 0 {
+				ch.PendingSweeps++
+			}
+		}
+		h.Coins = append(h.Coins, ch)
+	}
+
+	nd.RemoteMtx.Lock()
+	h.Peers = uint32(len(nd.RemoteCons))
+	nd.RemoteMtx.Unlock()
+
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if ok {
+		h.TowerActive = wt.WatchDB != nil
+	}
+
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
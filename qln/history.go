@@ -0,0 +1,151 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Payment history.
+
+Every outgoing payment attempt goes in the BKTPayments bucket.  Keys are 8
+byte big endian unix nanoseconds followed by the bucket's 8 byte sequence
+number, so keys sort by time and a time range is a cursor seek plus a walk.
+
+Payments are currently all direct pushes so the route is always the one
+channel.  There's no multi-hop forwarding yet (see FWDHandler), so there's
+no forwarding history either.
+*/
+
+// PaymentRecord is one outgoing payment attempt
+type PaymentRecord struct {
+	Time     int64 // unix nanoseconds
+	PeerIdx  uint32
+	Amt      int64
+	Fee      int64
+	StateIdx uint64   // channel state after the payment, if it worked
+	Route    []uint32 // channel indexes, first hop first
+	OK       bool
+	Code     FailCode // why it failed, if not OK; see htlcfail.go
+	Err      string   // failure reason if not OK
+}
+
+// ToBytes serializes a PaymentRecord.  Fixed fields, then the route with a
+// 4 byte count, the 2 byte failure code, then the error string to the end.
+func (p *PaymentRecord) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, p.Time)
+	binary.Write(&buf, binary.BigEndian, p.PeerIdx)
+	binary.Write(&buf, binary.BigEndian, p.Amt)
+	binary.Write(&buf, binary.BigEndian, p.Fee)
+	binary.Write(&buf, binary.BigEndian, p.StateIdx)
+	binary.Write(&buf, binary.BigEndian, p.OK)
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Route)))
+	for _, c := range p.Route {
+		binary.Write(&buf, binary.BigEndian, c)
+	}
+	binary.Write(&buf, binary.BigEndian, p.Code)
+	buf.WriteString(p.Err)
+	return buf.Bytes()
+}
+
+// PaymentRecordFromBytes deserializes a PaymentRecord
+func PaymentRecordFromBytes(b []byte) (PaymentRecord, error) {
+	var p PaymentRecord
+	if len(b) < 43 {
+		return p, fmt.Errorf("%d bytes, payment record needs at least 43",
+			len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &p.Time)
+	binary.Read(buf, binary.BigEndian, &p.PeerIdx)
+	binary.Read(buf, binary.BigEndian, &p.Amt)
+	binary.Read(buf, binary.BigEndian, &p.Fee)
+	binary.Read(buf, binary.BigEndian, &p.StateIdx)
+	binary.Read(buf, binary.BigEndian, &p.OK)
+	var nHops uint32
+	binary.Read(buf, binary.BigEndian, &nHops)
+	if buf.Len() < int(nHops)*4+2 {
+		return p, fmt.Errorf("payment record route truncated")
+	}
+	p.Route = make([]uint32, nHops)
+	for i := range p.Route {
+		binary.Read(buf, binary.BigEndian, &p.Route[i])
+	}
+	binary.Read(buf, binary.BigEndian, &p.Code)
+	p.Err = buf.String()
+	return p, nil
+}
+
+// putHistory adds a value to a history bucket keyed by time and sequence
+func (nd *LitNode) putHistory(bkt []byte, t int64, val []byte) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		hb := btx.Bucket(bkt)
+		if hb == nil {
+			return fmt.Errorf("no %s bucket", string(bkt))
+		}
+		seq, err := hb.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := append(lnutil.I64tB(t), lnutil.U64tB(seq)...)
+		return hb.Put(key, val)
+	})
+}
+
+// getHistory calls f on every value in a history bucket with time in
+// [start, end), oldest first, and stops after max values (0 means no max).
+func (nd *LitNode) getHistory(
+	bkt []byte, start, end int64, max uint32, f func([]byte) error) error {
+	return nd.LitDB.View(func(btx *bolt.Tx) error {
+		hb := btx.Bucket(bkt)
+		if hb == nil {
+			return fmt.Errorf("no %s bucket", string(bkt))
+		}
+		var n uint32
+		cur := hb.Cursor()
+		for k, v := cur.Seek(lnutil.I64tB(start)); k != nil; k, v = cur.Next() {
+			if lnutil.BtI64(k[:8]) >= end {
+				break
+			}
+			err := f(v)
+			if err != nil {
+				return err
+			}
+			n++
+			if max != 0 && n >= max {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// RecordPayment saves a payment attempt.  Sets the time if it's not set.
+func (nd *LitNode) RecordPayment(p PaymentRecord) error {
+	if p.Time == 0 {
+		p.Time = time.Now().UnixNano()
+	}
+	return nd.putHistory(BKTPayments, p.Time, p.ToBytes())
+}
+
+// ListPayments returns payment attempts with start <= time < end (unix
+// nanoseconds), oldest first, at most max of them (0 for all).
+func (nd *LitNode) ListPayments(
+	start, end int64, max uint32) ([]PaymentRecord, error) {
+	var ps []PaymentRecord
+	err := nd.getHistory(BKTPayments, start, end, max, func(v []byte) error {
+		p, err := PaymentRecordFromBytes(v)
+		if err != nil {
+			return err
+		}
+		ps = append(ps, p)
+		return nil
+	})
+	return ps, err
+}
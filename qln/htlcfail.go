@@ -0,0 +1,221 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+When an HTLC fails, the reason's one of a few codes, named as in BOLT 4, so
+whoever sent it can tell what to do next: try another channel, pay more
+fee, give it longer, or give up.  Errors carrying a code are HTLCErrors;
+FailCodeOf gets the code back out, or FailUnknown for any other error.
+
+Every failure, incoming or outgoing, goes in BKTHTLCFails with its code.
+Outgoing payments keep the code in their payment record and, if queued,
+in the QueuedPayment, so ListPayments and TrackPayment say why.
+
+Channels don't have HTLCs yet, only pushes, so the failures recorded are
+of outgoing pushes.
+*/
+
+// FailCode says why an HTLC failed
+type FailCode uint16
+
+const (
+	// no code: a failure from before there were codes, or a local error
+	FailUnknown FailCode = iota
+	// the channel can't take it now: too little in it, peer gone, or a
+	// limit hit.  Another channel, or later, may work.
+	FailTemporaryChannel
+	// the fee paid is less than the channel's policy
+	FailFeeInsufficient
+	// too few blocks left before it expires
+	FailExpiryTooSoon
+	// no invoice for the hash, or it's settled, expired or underpaid
+	FailUnknownPaymentHash
+	// not connected to the next node
+	FailUnknownNextPeer
+)
+
+var failCodeNames = []string{
+	FailUnknown:            "unknown",
+	FailTemporaryChannel:   "temporary_channel_failure",
+	FailFeeInsufficient:    "fee_insufficient",
+	FailExpiryTooSoon:      "expiry_too_soon",
+	FailUnknownPaymentHash: "unknown_payment_hash",
+	FailUnknownNextPeer:    "unknown_next_peer",
+}
+
+func (c FailCode) String() string {
+	if int(c) < len(failCodeNames) {
+		return failCodeNames[c]
+	}
+	return fmt.Sprintf("code_%d", uint16(c))
+}
+
+// MarshalText gives the code's name, so it's readable in json
+func (c FailCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText reads a code's name
+func (c *FailCode) UnmarshalText(b []byte) error {
+	for i, name := range failCodeNames {
+		if name == string(b) {
+			*c = FailCode(i)
+			return nil
+		}
+	}
+	var n uint16
+	_, err := fmt.Sscanf(string(b), "code_%d", &n)
+	if err != nil {
+		return fmt.Errorf("unknown failure code %q", string(b))
+	}
+	*c = FailCode(n)
+	return nil
+}
+
+// HTLCError is an HTLC failure with its code
+type HTLCError struct {
+	Code FailCode
+	Err  error
+}
+
+func (e *HTLCError) Error() string {
+	return e.Code.String() + ": " + e.Err.Error()
+}
+
+// htlcErr makes an HTLCError with a formatted message
+func htlcErr(code FailCode, format string, args ...interface{}) error {
+	return &HTLCError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// FailCodeOf is the code of err if it's an HTLCError, else FailUnknown
+func FailCodeOf(err error) FailCode {
+	if he, ok := err.(*HTLCError); ok {
+		return he.Code
+	}
+	return FailUnknown
+}
+
+// HTLCFailRecord is one failed HTLC
+type HTLCFailRecord struct {
+	Time     int64 // unix nanoseconds
+	ChanIdx  uint32
+	PeerIdx  uint32
+	Hash     [32]byte // zero for pushes, which don't have one
+	Amt      int64
+	Incoming bool
+	Code     FailCode
+	Err      string
+}
+
+// ToBytes serializes an HTLCFailRecord: the fixed fields, then the error
+// to the end
+func (f *HTLCFailRecord) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, f.Time)
+	binary.Write(&buf, binary.BigEndian, f.ChanIdx)
+	binary.Write(&buf, binary.BigEndian, f.PeerIdx)
+	buf.Write(f.Hash[:])
+	binary.Write(&buf, binary.BigEndian, f.Amt)
+	binary.Write(&buf, binary.BigEndian, f.Incoming)
+	binary.Write(&buf, binary.BigEndian, f.Code)
+	buf.WriteString(f.Err)
+	return buf.Bytes()
+}
+
+// HTLCFailRecordFromBytes deserializes an HTLCFailRecord
+func HTLCFailRecordFromBytes(b []byte) (HTLCFailRecord, error) {
+	var f HTLCFailRecord
+	if len(b) < 59 {
+		return f, fmt.Errorf("%d bytes, htlc fail record needs at least 59",
+			len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &f.Time)
+	binary.Read(buf, binary.BigEndian, &f.ChanIdx)
+	binary.Read(buf, binary.BigEndian, &f.PeerIdx)
+	copy(f.Hash[:], buf.Next(32))
+	binary.Read(buf, binary.BigEndian, &f.Amt)
+	binary.Read(buf, binary.BigEndian, &f.Incoming)
+	binary.Read(buf, binary.BigEndian, &f.Code)
+	f.Err = buf.String()
+	return f, nil
+}
+
+// RecordHTLCFailure saves a failed HTLC.  Sets the time if it's not set.
+func (nd *LitNode) RecordHTLCFailure(f HTLCFailRecord) error {
+	if f.Time == 0 {
+		f.Time = time.Now().UnixNano()
+	}
+	return nd.putHistory(BKTHTLCFails, f.Time, f.ToBytes())
+}
+
+// ListHTLCFailures returns failed HTLCs with start <= time < end (unix
+// nanoseconds), oldest first, at most max of them (0 for all).
+func (nd *LitNode) ListHTLCFailures(
+	start, end int64, max uint32) ([]HTLCFailRecord, error) {
+	var fs []HTLCFailRecord
+	err := nd.getHistory(BKTHTLCFails, start, end, max, func(v []byte) error {
+		f, err := HTLCFailRecordFromBytes(v)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, f)
+		return nil
+	})
+	return fs, err
+}
+
+// PushFailCode is the code for a push that failed with err
+func PushFailCode(err error) FailCode {
+	if code := FailCodeOf(err); code != FailUnknown {
+		return code
+	}
+	// pushes fail for things that pass: the channel's busy, too new, or
+	// hasn't enough in it
+	return FailTemporaryChannel
+}
+
+// migratePaymentCodes adds a failure code, FailUnknown, after the route in
+// every payment record, where PaymentRecord.ToBytes puts it
+func migratePaymentCodes(btx *bolt.Tx) error {
+	pb := btx.Bucket(BKTPayments)
+	if pb == nil {
+		return nil
+	}
+	type kv struct{ k, v []byte }
+	var recs []kv
+	err := pb.ForEach(func(k, v []byte) error {
+		if len(v) < 41 {
+			return fmt.Errorf("payment record %x is %d bytes", k, len(v))
+		}
+		at := 41 + 4*int(binary.BigEndian.Uint32(v[37:41]))
+		if len(v) < at {
+			return fmt.Errorf("payment record %x route truncated", k)
+		}
+		nv := make([]byte, 0, len(v)+2)
+		nv = append(nv, v[:at]...)
+		nv = append(nv, 0, 0)
+		nv = append(nv, v[at:]...)
+		recs = append(recs, kv{append([]byte{}, k...), nv})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// can't Put while in ForEach
+	for _, r := range recs {
+		err = pb.Put(r.k, r.v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
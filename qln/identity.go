@@ -0,0 +1,152 @@
+package qln
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Identity backups hold the node's ln address and its address book (every
+peer's pubkey, host and nickname).  They don't hold any private keys: the
+identity key is derived from the same root key as the wallets, so back up
+privkey.hex for that.  Restoring a node on a new device is then:
+copy privkey.hex, start lit, and ImportIdentity the backup.
+
+Running the same identity on two devices at once is dangerous; both will
+try to update the same channels with old state.  When a node announces
+itself to the tracker it remembers the url it announced.  Before making a
+channel it looks itself up again, and if the tracker now has a different
+url, some other instance has announced since, and we refuse.
+*/
+
+// PeerBackup is one address book entry
+type PeerBackup struct {
+	Idx      uint32
+	Pub      string // hex of 33 byte compressed pubkey
+	Host     string
+	Nickname string
+}
+
+// IdentityBackup is what ExportIdentity returns and ImportIdentity takes
+type IdentityBackup struct {
+	LitAdr string
+	IdPub  string // hex
+	Time   int64  // unix time of export
+	Peers  []PeerBackup
+}
+
+// ExportIdentity makes a backup of the identity and address book.
+func (nd *LitNode) ExportIdentity() (*IdentityBackup, error) {
+	b := new(IdentityBackup)
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	b.LitAdr = lnutil.LitAdrFromPubkey(idPub)
+	b.IdPub = hex.EncodeToString(idPub[:])
+	b.Time = time.Now().Unix()
+
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		prs := btx.Bucket(BKTPeers)
+		if mp == nil || prs == nil {
+			return fmt.Errorf("no peer buckets")
+		}
+		return mp.ForEach(func(idxBytes, pubBytes []byte) error {
+			var pb PeerBackup
+			pb.Idx = lnutil.BtU32(idxBytes)
+			pb.Pub = hex.EncodeToString(pubBytes)
+			prBkt := prs.Bucket(pubBytes)
+			if prBkt != nil {
+				pb.Host = string(prBkt.Get(KEYhost))
+				pb.Nickname = string(prBkt.Get(KEYnickname))
+			}
+			b.Peers = append(b.Peers, pb)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ImportIdentity adds the backup's address book to this node.  The backup
+// has to be from this same identity.  Peers already known are left alone
+// except for filling in a missing nickname.  Returns how many peers were
+// new.
+func (nd *LitNode) ImportIdentity(b *IdentityBackup) (uint32, error) {
+	myPub := hex.EncodeToString(nd.IdKey().PubKey().SerializeCompressed())
+	if b.IdPub != myPub {
+		return 0, fmt.Errorf("backup is for %s, this node is %s",
+			b.IdPub, myPub)
+	}
+
+	// see who we already know
+	existing, err := nd.ExportIdentity()
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool)
+	for _, pb := range existing.Peers {
+		known[pb.Pub] = true
+	}
+
+	var added uint32
+	for _, pb := range b.Peers {
+		pubBytes, err := hex.DecodeString(pb.Pub)
+		if err != nil {
+			return added, fmt.Errorf("peer %d pubkey: %s", pb.Idx, err.Error())
+		}
+		pub, err := btcec.ParsePubKey(pubBytes, btcec.S256())
+		if err != nil {
+			return added, fmt.Errorf("peer %d pubkey: %s", pb.Idx, err.Error())
+		}
+
+		idx, err := nd.GetPeerIdx(pub, pb.Host)
+		if err != nil {
+			return added, err
+		}
+		if !known[pb.Pub] {
+			added++
+		}
+		if pb.Nickname != "" && nd.GetNicknameFromPeerIdx(idx) == "" {
+			err = nd.SaveNicknameForPeerIdx(pb.Nickname, idx)
+			if err != nil {
+				return added, err
+			}
+		}
+	}
+	return added, nil
+}
+
+// DualRunCheck returns an error if the tracker says someone else has
+// announced our identity since we did.  If we never announced there's
+// nothing to compare to, and it passes.
+func (nd *LitNode) DualRunCheck() error {
+	nd.announcedMtx.Lock()
+	announced := nd.announcedURL
+	nd.announcedMtx.Unlock()
+	if announced == "" {
+		return nil
+	}
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	adr := lnutil.LitAdrFromPubkey(idPub)
+
+	nowURL, err := Lookup(adr, nd.TrackerURL)
+	if err != nil {
+		// tracker down; don't block channels on that
+		logger.Warnf("DualRunCheck lookup error %s\n", err.Error())
+		return nil
+	}
+	if nowURL != announced {
+		return fmt.Errorf("another node with this identity is online at %s "+
+			"(we announced %s); refusing to make channels",
+			nowURL, announced)
+	}
+	return nil
+}
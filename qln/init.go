@@ -3,11 +3,14 @@ package qln
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
+	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil/hdkeychain"
 	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/migrate"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wallit"
 	"github.com/mit-dci/lit/watchtower"
@@ -16,11 +19,19 @@ import (
 // Init starts up a lit node.  Needs priv key, and a path.
 // Does not activate a subwallet; do that after init.
 func NewLitNode(privKey *[32]byte, path string, trackerURL string) (*LitNode, error) {
+	return NewLitNodeDirs(privKey, DataDirs{Lit: path}, trackerURL)
+}
+
+// NewLitNodeDirs starts up a lit node keeping its DBs where dirs says; see
+// datadirs.go
+func NewLitNodeDirs(
+	privKey *[32]byte, dirs DataDirs, trackerURL string) (*LitNode, error) {
 
 	nd := new(LitNode)
-	nd.LitFolder = path
+	nd.LitFolder = dirs.Lit
+	nd.Dirs = dirs
 
-	litdbpath := filepath.Join(nd.LitFolder, "ln.db")
+	litdbpath := filepath.Join(dirs.ChannelDir(), "ln.db")
 	err := nd.OpenDB(litdbpath)
 	if err != nil {
 		return nil, err
@@ -44,6 +55,14 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string) (*LitNode, er
 	if err != nil {
 		return nil, err
 	}
+	nd.towerBoxKey, err = sealKey(rootPrivKey, 10)
+	if err != nil {
+		return nil, err
+	}
+	nd.chanBakKey, err = sealKey(rootPrivKey, 11)
+	if err != nil {
+		return nil, err
+	}
 
 	nd.TrackerURL = trackerURL
 
@@ -56,15 +75,46 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string) (*LitNode, er
 
 	nd.InProg = new(InFlightFund)
 	nd.InProg.done = make(chan uint32, 1)
+	nd.InProg.failed = make(chan error, 1)
+	nd.InProg.extOut = make(chan *wire.TxOut, 1)
 
 	nd.RemoteCons = make(map[uint32]*RemotePeer)
+	nd.uptime = newUptimeLog()
+	nd.askedBreaks = newAskedBreaks()
 
 	nd.SubWallet = make(map[uint32]UWallet)
+	nd.shutdownScripts = make(map[uint32][]byte)
+	nd.feeGuard = new(feeGuard)
+	nd.sweepFees = &sweepFees{targets: DefaultSweepTargets}
+	nd.payQueue = &payQueue{pays: make(map[uint32]*QueuedPayment)}
+	nd.customInbox = new(customInbox)
+	nd.fundBumps = &fundBumps{pending: make(map[[36]byte]*fundBump)}
+	nd.alerts = newAlertState()
+	nd.liquidity = newLiquidityState()
+	nd.chanOps = newChanQueues()
+	nd.towerK = 1
+	nd.towerFailover = defaultTowerFailover
+	nd.towerKick = make(chan struct{}, 1)
+
+	// see if we crashed in the middle of anything last time
+	err = nd.RecoverPending()
+	if err != nil {
+		return nil, err
+	}
 
 	nd.OmniOut = make(chan lnutil.LitMsg, 10)
 	nd.OmniIn = make(chan lnutil.LitMsg, 10)
+	nd.towerOut = make(chan towerUpload)
 	//	go nd.OmniHandler()
 	go nd.OutMessager()
+	go nd.SwapWatcher()
+	go nd.OrderWatcher()
+	go nd.FeeGuard()
+	go nd.SweepWatcher()
+	go nd.AlertWatcher()
+	go nd.LiquidityAdverts()
+	go nd.LeaseWatcher()
+	go nd.TowerKeeper()
 
 	return nd, nil
 }
@@ -95,9 +145,13 @@ func (nd *LitNode) LinkBaseWallet(
 	// if there aren't, Multiwallet will still be false; set new wallit to
 	// be the first & default
 	nd.SubWallet[WallitIdx] = wallit.NewWallit(
-		rootpriv, birthHeight, resync, host, nd.LitFolder, param)
+		rootpriv, birthHeight, resync, host,
+		nd.Dirs.WalletDir(), nd.Dirs.HeaderDir(), param)
 
 	go nd.OPEventHandler(nd.SubWallet[WallitIdx].LetMeKnow())
+	go nd.ChannelVerifier(WallitIdx)
+	go nd.forwardBlocks(nd.SubWallet[WallitIdx])
+	go nd.syncChanges(nd.SubWallet[WallitIdx])
 
 	if !nd.MultiWallet {
 		nd.DefaultCoin = param.HDCoinType
@@ -108,7 +162,7 @@ func (nd *LitNode) LinkBaseWallet(
 
 	if tower {
 		err = nd.Tower.HookLink(
-			nd.LitFolder, param, nd.SubWallet[WallitIdx].ExportHook())
+			nd.Dirs.TowerDir(), param, nd.SubWallet[WallitIdx].ExportHook())
 		if err != nil {
 			return err
 		}
@@ -117,6 +171,92 @@ func (nd *LitNode) LinkBaseWallet(
 	return nil
 }
 
+// SetTowerStore keeps the tower's txids in the store spec describes,
+// instead of in watch.db; see watchtower/watchstore.go.  Call before
+// linking wallets, since the first link opens the tower DB.
+func (nd *LitNode) SetTowerStore(spec string) error {
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("tower has no txid store")
+	}
+	if wt.WatchDB != nil {
+		return fmt.Errorf("tower already running")
+	}
+	store, err := watchtower.OpenWatchStore(spec)
+	if err != nil {
+		return err
+	}
+	wt.Store = store
+	return nil
+}
+
+// SetTowerWorkers sets how many breaches the tower builds justice txs for
+// at once
+func (nd *LitNode) SetTowerWorkers(n int) error {
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("tower has no workers setting")
+	}
+	wt.JusticeWorkers = n
+	return nil
+}
+
+// SetTowerBatch sets the longest a state the tower gets waits to be
+// committed with others; see watchtower/batch.go.  Call before linking
+// wallets.
+func (nd *LitNode) SetTowerBatch(d time.Duration) error {
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("tower has no batch setting")
+	}
+	if wt.WatchDB != nil {
+		return fmt.Errorf("tower already running")
+	}
+	wt.BatchDelay = d
+	return nil
+}
+
+// SetTowerAdmins sets the operators who have to approve destructive tower
+// admin ops, as m:pubkey,pubkey,...; see watchtower/admin.go.  Call before
+// linking wallets; it's only used if watch.db has no policy yet.
+func (nd *LitNode) SetTowerAdmins(spec string) error {
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("tower has no admin policy")
+	}
+	if wt.WatchDB != nil {
+		return fmt.Errorf("tower already running")
+	}
+	p, err := watchtower.ParseAdminPolicy(spec)
+	if err != nil {
+		return err
+	}
+	wt.AdminPolicy = p
+	return nil
+}
+
+// SetTowerClients has the tower serve only these clients, given as hex
+// node pubkeys or lit addresses; none for anyone.  See
+// watchtower/clients.go.
+func (nd *LitNode) SetTowerClients(entries []string) error {
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok {
+		return fmt.Errorf("tower has no client list")
+	}
+	adrs, err := watchtower.ParseClients(entries)
+	if err != nil {
+		return err
+	}
+	wt.SetClients(adrs)
+	return nil
+}
+
+// litDBMigrations update the lit DB to the current schema; see package
+// migrate.  Append only.
+var litDBMigrations = []migrate.Migration{
+	migratePaymentCodes, // 0 to 1
+}
+
 // Opens the DB file for the LnNode
 func (nd *LitNode) OpenDB(filename string) error {
 	var err error
@@ -151,10 +291,110 @@ func (nd *LitNode) OpenDB(filename string) error {
 			return err
 		}
 
+		_, err = btx.CreateBucketIfNotExists(BKTPayments)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTInvoices)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTInvoiceHints)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTInvoiceMeta)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTLeases)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTPayKeys)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTPaidHashes)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTSwaps)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTFeePolicy)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTHTLCFails)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTowers)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTOrders)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTOrderInv)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTowerBox)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTClientTowers)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTFundReplaced)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTSyncLog)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTSyncKeys)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTCharts)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTPeerStats)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTPushesIn)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTPushesOut)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
 		return err
 	}
-	return nil
+	have, err := migrate.Version(nd.LitDB)
+	if err != nil {
+		return err
+	}
+	if have < uint32(len(litDBMigrations)) {
+		_, err = nd.writeCheckpoint(fmt.Sprintf("migrate ln.db from "+
+			"version %d to %d", have, len(litDBMigrations)), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return migrate.Run(nd.LitDB, litDBMigrations)
 }
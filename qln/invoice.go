@@ -0,0 +1,344 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Invoices are requests for payment.  Each has a random preimage and is
+identified by the sha256 of it, the payment hash.  Invoices are kept in
+the BKTInvoices bucket keyed by payment hash.
+
+An amount of 0 means the payer picks the amount.  Invoices expire Expiry
+seconds after they're made and can't be settled after that.
+
+Invoices are settled when the payer claims a push it made for one (see
+payclaim.go), and go out as EventInvoiceSettled node events.
+
+Payment requests are strings of the form
+  litadr:paymenthash:amount:expiry:deschash[:hints]
+with the hashes in hex and expiry as a unix time.  Older requests without
+the description hash still decode, with a zero DescHash.  hints are route
+hints (see routehints.go), kept in BKTInvoiceHints apart from the invoice.
+*/
+
+// default invoice lifetime, in seconds
+const defaultInvoiceExpiry = 3600
+
+// Invoice is a request for payment
+type Invoice struct {
+	PaymentHash [32]byte
+	Preimage    [32]byte
+	Amt         int64 // 0 means any amount
+	DescHash    [32]byte
+	Created     int64 // unix time
+	Expiry      int64 // seconds after Created
+	Settled     bool
+	AmtPaid     int64
+	SettledAt   int64 // unix time
+	Description string
+	Hints       []RouteHint // not in ToBytes; saved under BKTInvoiceHints
+	// not in ToBytes either; saved under BKTInvoiceMeta.  See invoicemeta.go
+	Metadata  map[string]string // ours, eg an order id
+	PayerData map[string]string // what the payer sent with it
+}
+
+// Expired returns true if the invoice can't be paid anymore
+func (inv *Invoice) Expired() bool {
+	return time.Now().Unix() > inv.Created+inv.Expiry
+}
+
+// PayReq returns the payment request string for this invoice
+func (inv *Invoice) PayReq(litAdr string) string {
+	s := fmt.Sprintf("%s:%x:%d:%d:%x", litAdr, inv.PaymentHash, inv.Amt,
+		inv.Created+inv.Expiry, inv.DescHash)
+	if len(inv.Hints) > 0 {
+		hs := make([]string, len(inv.Hints))
+		for i, h := range inv.Hints {
+			hs[i] = h.String()
+		}
+		s += ":" + strings.Join(hs, ",")
+	}
+	return s
+}
+
+// PayReq is a decoded payment request
+type PayReq struct {
+	LitAdr      string
+	PaymentHash [32]byte
+	Amt         int64
+	ExpiresAt   int64
+	DescHash    [32]byte // zero if the request didn't have one
+	Hints       []RouteHint
+}
+
+// DecodePayReq parses a payment request string
+func DecodePayReq(s string) (*PayReq, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 4 || len(parts) > 6 {
+		return nil, fmt.Errorf("payment request has %d parts, expect 5 or 6",
+			len(parts))
+	}
+	pr := new(PayReq)
+	pr.LitAdr = parts[0]
+	if !lnutil.LitAdrOK(pr.LitAdr) {
+		return nil, fmt.Errorf("bad ln address %s", pr.LitAdr)
+	}
+	hash, err := hex.DecodeString(parts[1])
+	if err != nil || len(hash) != 32 {
+		return nil, fmt.Errorf("bad payment hash %s", parts[1])
+	}
+	copy(pr.PaymentHash[:], hash)
+	pr.Amt, err = strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || pr.Amt < 0 {
+		return nil, fmt.Errorf("bad amount %s", parts[2])
+	}
+	pr.ExpiresAt, err = strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad expiry %s", parts[3])
+	}
+	if len(parts) > 4 {
+		dh, err := hex.DecodeString(parts[4])
+		if err != nil || len(dh) != 32 {
+			return nil, fmt.Errorf("bad description hash %s", parts[4])
+		}
+		copy(pr.DescHash[:], dh)
+	}
+	if len(parts) == 6 {
+		for _, hs := range strings.Split(parts[5], ",") {
+			h, err := ParseRouteHint(hs)
+			if err != nil {
+				return nil, err
+			}
+			pr.Hints = append(pr.Hints, h)
+		}
+	}
+	return pr, nil
+}
+
+// ToBytes serializes an invoice.  Fixed size fields, then the description
+// to the end.
+func (inv *Invoice) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(inv.PaymentHash[:])
+	buf.Write(inv.Preimage[:])
+	buf.Write(inv.DescHash[:])
+	binary.Write(&buf, binary.BigEndian, inv.Amt)
+	binary.Write(&buf, binary.BigEndian, inv.Created)
+	binary.Write(&buf, binary.BigEndian, inv.Expiry)
+	binary.Write(&buf, binary.BigEndian, inv.Settled)
+	binary.Write(&buf, binary.BigEndian, inv.AmtPaid)
+	binary.Write(&buf, binary.BigEndian, inv.SettledAt)
+	buf.WriteString(inv.Description)
+	return buf.Bytes()
+}
+
+// InvoiceFromBytes deserializes an invoice
+func InvoiceFromBytes(b []byte) (*Invoice, error) {
+	if len(b) < 137 {
+		return nil, fmt.Errorf("%d bytes, invoice needs at least 137", len(b))
+	}
+	inv := new(Invoice)
+	buf := bytes.NewBuffer(b)
+	copy(inv.PaymentHash[:], buf.Next(32))
+	copy(inv.Preimage[:], buf.Next(32))
+	copy(inv.DescHash[:], buf.Next(32))
+	binary.Read(buf, binary.BigEndian, &inv.Amt)
+	binary.Read(buf, binary.BigEndian, &inv.Created)
+	binary.Read(buf, binary.BigEndian, &inv.Expiry)
+	binary.Read(buf, binary.BigEndian, &inv.Settled)
+	binary.Read(buf, binary.BigEndian, &inv.AmtPaid)
+	binary.Read(buf, binary.BigEndian, &inv.SettledAt)
+	inv.Description = buf.String()
+	return inv, nil
+}
+
+// AddInvoice makes and saves a new invoice.  If descHash is nil, the
+// sha256 of the description is used.  expiry of 0 gives the default.
+// meta is kept with it, for matching up payments; it can be nil.
+func (nd *LitNode) AddInvoice(amt int64, desc string, descHash *[32]byte,
+	expiry int64, meta map[string]string) (*Invoice, error) {
+	if amt < 0 {
+		return nil, fmt.Errorf("invoice amount %d negative", amt)
+	}
+	err := checkInvoiceMeta(meta)
+	if err != nil {
+		return nil, fmt.Errorf("invoice metadata: %s", err.Error())
+	}
+	if expiry < 0 {
+		return nil, fmt.Errorf("invoice expiry %d negative", expiry)
+	}
+	if expiry == 0 {
+		expiry = defaultInvoiceExpiry
+	}
+
+	inv := new(Invoice)
+	_, err = rand.Read(inv.Preimage[:])
+	if err != nil {
+		return nil, err
+	}
+	inv.PaymentHash = sha256.Sum256(inv.Preimage[:])
+	inv.Amt = amt
+	inv.Description = desc
+	inv.Metadata = meta
+	if descHash != nil {
+		inv.DescHash = *descHash
+	} else {
+		inv.DescHash = sha256.Sum256([]byte(desc))
+	}
+	inv.Created = time.Now().Unix()
+	inv.Expiry = expiry
+	// an invoice without hints is still payable by a direct peer
+	inv.Hints, err = nd.pickRouteHints(amt)
+	if err != nil {
+		logger.Warnf("no route hints for invoice: %s\n", err.Error())
+	}
+
+	err = nd.SaveInvoice(inv)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// SaveInvoice writes an invoice to the db, overwriting any with the same
+// payment hash.
+func (nd *LitNode) SaveInvoice(inv *Invoice) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		ib := btx.Bucket(BKTInvoices)
+		if ib == nil {
+			return fmt.Errorf("no invoice bucket")
+		}
+		if len(inv.Hints) > 0 {
+			err := btx.Bucket(BKTInvoiceHints).Put(
+				inv.PaymentHash[:], routeHintsToBytes(inv.Hints))
+			if err != nil {
+				return err
+			}
+		}
+		err := putInvoiceMeta(btx, inv)
+		if err != nil {
+			return err
+		}
+		return ib.Put(inv.PaymentHash[:], inv.ToBytes())
+	})
+}
+
+// GetInvoice looks up an invoice by payment hash.
+func (nd *LitNode) GetInvoice(hash [32]byte) (*Invoice, error) {
+	var inv *Invoice
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		ib := btx.Bucket(BKTInvoices)
+		if ib == nil {
+			return fmt.Errorf("no invoice bucket")
+		}
+		b := ib.Get(hash[:])
+		if b == nil {
+			return fmt.Errorf("no invoice with hash %x", hash)
+		}
+		var err error
+		inv, err = InvoiceFromBytes(b)
+		if err != nil {
+			return err
+		}
+		inv.Hints, err = loadInvoiceHints(btx, hash[:])
+		if err != nil {
+			return err
+		}
+		return loadInvoiceMeta(btx, inv)
+	})
+	return inv, err
+}
+
+// ListInvoices returns all invoices, or only unsettled, unexpired ones if
+// pendingOnly is set.
+func (nd *LitNode) ListInvoices(pendingOnly bool) ([]*Invoice, error) {
+	var invs []*Invoice
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		ib := btx.Bucket(BKTInvoices)
+		if ib == nil {
+			return fmt.Errorf("no invoice bucket")
+		}
+		return ib.ForEach(func(k, v []byte) error {
+			inv, err := InvoiceFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if pendingOnly && (inv.Settled || inv.Expired()) {
+				return nil
+			}
+			inv.Hints, err = loadInvoiceHints(btx, k)
+			if err != nil {
+				return err
+			}
+			err = loadInvoiceMeta(btx, inv)
+			if err != nil {
+				return err
+			}
+			invs = append(invs, inv)
+			return nil
+		})
+	})
+	return invs, err
+}
+
+// loadInvoiceHints gets the route hints saved with an invoice, if any
+func loadInvoiceHints(btx *bolt.Tx, hash []byte) ([]RouteHint, error) {
+	hb := btx.Bucket(BKTInvoiceHints)
+	if hb == nil {
+		return nil, nil
+	}
+	b := hb.Get(hash)
+	if b == nil {
+		return nil, nil
+	}
+	return routeHintsFromBytes(b)
+}
+
+// settleInvoice marks an invoice paid with amt in btx, so it can go in the
+// same transaction as whatever paid it.  Fails if the invoice is already
+// settled, expired, or amt is less than asked for.
+func (nd *LitNode) settleInvoice(
+	btx *bolt.Tx, hash [32]byte, amt int64) (*Invoice, error) {
+	ib := btx.Bucket(BKTInvoices)
+	if ib == nil {
+		return nil, fmt.Errorf("no invoice bucket")
+	}
+	b := ib.Get(hash[:])
+	if b == nil {
+		return nil, fmt.Errorf("no invoice with hash %x", hash)
+	}
+	inv, err := InvoiceFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Settled {
+		return nil, fmt.Errorf("invoice %x already settled", hash)
+	}
+	if inv.Expired() {
+		return nil, fmt.Errorf("invoice %x expired", hash)
+	}
+	if amt < inv.Amt || amt < 1 {
+		return nil, fmt.Errorf("invoice %x for %d, only paid %d",
+			hash, inv.Amt, amt)
+	}
+	inv.Settled = true
+	inv.AmtPaid = amt
+	inv.SettledAt = time.Now().Unix()
+	err = ib.Put(hash[:], inv.ToBytes())
+	if err != nil {
+		return nil, err
+	}
+	return inv, nd.syncInvoice(btx, inv)
+}
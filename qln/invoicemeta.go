@@ -0,0 +1,147 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Invoices can carry data for matching payments up with whatever they were
+for.  Metadata is ours, given when the invoice is made: an order id, a
+memo, a customer number.  Payer data is what the payer sent with the
+payment, eg the comment on an lnurl-pay callback.  Pay claims (see
+payclaim.go) only carry the payment hash, so for now that's the only way
+in.
+
+Both are small string maps, kept in BKTInvoiceMeta by payment hash, away
+from the invoice itself so invoices made before this still read.  Neither
+goes in the payment request.
+*/
+
+const (
+	// most entries in each of an invoice's metadata and payer data
+	maxInvoiceMetaKeys = 16
+	// longest key, and longest value
+	maxInvoiceMetaKey = 64
+	maxInvoiceMetaVal = 1024
+)
+
+// checkInvoiceMeta makes sure a map will fit
+func checkInvoiceMeta(m map[string]string) error {
+	if len(m) > maxInvoiceMetaKeys {
+		return fmt.Errorf("%d entries, most is %d", len(m), maxInvoiceMetaKeys)
+	}
+	for k, v := range m {
+		if k == "" || len(k) > maxInvoiceMetaKey {
+			return fmt.Errorf("key %q must be 1 to %d bytes", k, maxInvoiceMetaKey)
+		}
+		if len(v) > maxInvoiceMetaVal {
+			return fmt.Errorf("value for %s is %d bytes, most is %d",
+				k, len(v), maxInvoiceMetaVal)
+		}
+	}
+	return nil
+}
+
+// metaToBytes writes a map as a count, then for each entry, in key order,
+// a 1 byte key length, the key, a 2 byte value length and the value
+func metaToBytes(buf *bytes.Buffer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf.WriteByte(byte(len(keys)))
+	for _, k := range keys {
+		buf.WriteByte(byte(len(k)))
+		buf.WriteString(k)
+		binary.Write(buf, binary.BigEndian, uint16(len(m[k])))
+		buf.WriteString(m[k])
+	}
+}
+
+// metaFromBytes reads what metaToBytes wrote; nil if it's empty
+func metaFromBytes(buf *bytes.Buffer) (map[string]string, error) {
+	n, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("invoice metadata truncated")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, n)
+	for i := 0; i < int(n); i++ {
+		kl, err := buf.ReadByte()
+		if err != nil || buf.Len() < int(kl)+2 {
+			return nil, fmt.Errorf("invoice metadata truncated")
+		}
+		k := string(buf.Next(int(kl)))
+		var vl uint16
+		binary.Read(buf, binary.BigEndian, &vl)
+		if buf.Len() < int(vl) {
+			return nil, fmt.Errorf("invoice metadata truncated")
+		}
+		m[k] = string(buf.Next(int(vl)))
+	}
+	return m, nil
+}
+
+// putInvoiceMeta saves an invoice's metadata and payer data, or deletes
+// the entry if it has neither
+func putInvoiceMeta(btx *bolt.Tx, inv *Invoice) error {
+	mb := btx.Bucket(BKTInvoiceMeta)
+	if mb == nil {
+		return fmt.Errorf("no invoice metadata bucket")
+	}
+	if len(inv.Metadata) == 0 && len(inv.PayerData) == 0 {
+		return mb.Delete(inv.PaymentHash[:])
+	}
+	var buf bytes.Buffer
+	metaToBytes(&buf, inv.Metadata)
+	metaToBytes(&buf, inv.PayerData)
+	return mb.Put(inv.PaymentHash[:], buf.Bytes())
+}
+
+// loadInvoiceMeta fills in an invoice's metadata and payer data
+func loadInvoiceMeta(btx *bolt.Tx, inv *Invoice) error {
+	mb := btx.Bucket(BKTInvoiceMeta)
+	if mb == nil {
+		return nil
+	}
+	b := mb.Get(inv.PaymentHash[:])
+	if b == nil {
+		return nil
+	}
+	buf := bytes.NewBuffer(b)
+	var err error
+	inv.Metadata, err = metaFromBytes(buf)
+	if err != nil {
+		return err
+	}
+	inv.PayerData, err = metaFromBytes(buf)
+	return err
+}
+
+// AddPayerData adds what a payer sent to an invoice; later values for a
+// key replace earlier ones
+func (nd *LitNode) AddPayerData(hash [32]byte, data map[string]string) error {
+	inv, err := nd.GetInvoice(hash)
+	if err != nil {
+		return err
+	}
+	if inv.PayerData == nil {
+		inv.PayerData = make(map[string]string)
+	}
+	for k, v := range data {
+		inv.PayerData[k] = v
+	}
+	err = checkInvoiceMeta(inv.PayerData)
+	if err != nil {
+		return fmt.Errorf("payer data: %s", err.Error())
+	}
+	return nd.SaveInvoice(inv)
+}
@@ -30,10 +30,22 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	// justice-ing should be done in the background...
 	var parTxidSig [80]byte // 16 byte txid and 64 byte signature stuck together
 
+	// SINGLE|ANYONECANPAY lets the tower batch it or add fee inputs
+	hashType := txscript.SigHashAll
+	if nd.JusticeAnyoneCanPay {
+		hashType = txscript.SigHashSingle | txscript.SigHashAnyOneCanPay
+	}
+
 	// in this function, "bad" refers to the hypothetical transaction spending the
 	// com tx.  "justice" is the tx spending the bad tx
 
-	fee := int64(5000) // fixed fee for now
+	// the tower may not send it for a while, but when it does it's in a
+	// hurry; see sweepfees.go
+	feeRate, err := nd.SweepFeeRate(q.Coin(), SweepJustice, 0)
+	if err != nil {
+		return err
+	}
+	fee := feeRate * justiceTxSize
 
 	// first we need the keys in the bad script.  Start by getting the elk-scalar
 	// we should have it at the "current" state number
@@ -75,6 +87,10 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	if badIdx > uint32(len(badTx.TxOut)) {
 		return fmt.Errorf("BuildWatchTxidSig couldn't find revocable SH output")
 	}
+	// whatever fees are, keep some of it out of their hands
+	if fee > badAmt/2 {
+		fee = badAmt / 2
+	}
 
 	// make a keygen to get the private HAKD base scalar
 	kg := q.KeyGen
@@ -110,9 +126,12 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	hCache := txscript.NewTxSigHashes(justiceTx)
 
 	// sign with combined key.  Justice txs always have only 1 input, so txin is 0
-	bigSig, err := txscript.RawTxInWitnessSignature(
-		justiceTx, hCache, 0, badAmt, script, txscript.SigHashAll, combinedPrivKey)
-	// truncate sig (last byte is sighash type, always sighashAll)
+	bigSig, err := lnutil.RawTxInWitnessSignatureLowR(
+		justiceTx, hCache, 0, badAmt, script, hashType, combinedPrivKey)
+	if err != nil {
+		return err
+	}
+	// truncate sig (last byte is sighash type, saved separately)
 	bigSig = bigSig[:len(bigSig)-1]
 
 	sig, err := sig64.SigCompress(bigSig)
@@ -123,12 +142,18 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	copy(parTxidSig[:16], badTxid[:16])
 	copy(parTxidSig[16:], sig[:])
 
-	return nd.SaveJusticeSig(q.State.StateIdx, q.WatchRefundAdr, parTxidSig)
+	return nd.SaveJusticeSig(
+		q.State.StateIdx, q.WatchRefundAdr, parTxidSig, hashType)
 }
 
 // SaveJusticeSig save the txid/sig of a justice transaction to the db.  Pretty
-// straightforward
-func (nd *LitNode) SaveJusticeSig(comnum uint64, pkh [20]byte, txidsig [80]byte) error {
+// straightforward.  The sighash type goes on the end, unless it's ALL.
+func (nd *LitNode) SaveJusticeSig(comnum uint64, pkh [20]byte,
+	txidsig [80]byte, hashType txscript.SigHashType) error {
+	val := txidsig[:]
+	if hashType != txscript.SigHashAll {
+		val = append(val, byte(hashType))
+	}
 	return nd.LitDB.Update(func(btx *bolt.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
 		if sigs == nil {
@@ -140,12 +165,16 @@ func (nd *LitNode) SaveJusticeSig(comnum uint64, pkh [20]byte, txidsig [80]byte)
 			return err
 		}
 
-		return justBkt.Put(lnutil.U64tB(comnum), txidsig[:])
+		return justBkt.Put(lnutil.U64tB(comnum), val)
 	})
 }
 
-func (nd *LitNode) LoadJusticeSig(comnum uint64, pkh [20]byte) ([80]byte, error) {
+// LoadJusticeSig gets a txid/sig saved by SaveJusticeSig, and its sighash
+// type
+func (nd *LitNode) LoadJusticeSig(
+	comnum uint64, pkh [20]byte) ([80]byte, txscript.SigHashType, error) {
 	var txidsig [80]byte
+	hashType := txscript.SigHashAll
 
 	err := nd.LitDB.View(func(btx *bolt.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
@@ -162,9 +191,12 @@ func (nd *LitNode) LoadJusticeSig(comnum uint64, pkh [20]byte) ([80]byte, error)
 			return fmt.Errorf("state %d not in db under pkh %x", comnum, pkh)
 		}
 		copy(txidsig[:], sigbytes)
+		if len(sigbytes) == 81 {
+			hashType = txscript.SigHashType(sigbytes[80])
+		}
 		return nil
 	})
-	return txidsig, err
+	return txidsig, hashType, err
 }
 
 func (nd *LitNode) ShowJusticeDB() (string, error) {
@@ -192,7 +224,8 @@ func (nd *LitNode) ShowJusticeDB() (string, error) {
 	return s, err
 }
 
-// SendWatch syncs up the remote watchtower with all justice signatures
+// SyncWatch queues all new justice signatures for the remote watchtower,
+// and sends them if it's connected
 func (nd *LitNode) SyncWatch(qc *Qchan) error {
 
 	// if watchUpTo isn't 2 behind the state number, there's nothing to send
@@ -202,53 +235,83 @@ func (nd *LitNode) SyncWatch(qc *Qchan) error {
 		return fmt.Errorf("Channel at state %d, up to %d exported, nothing to do",
 			qc.State.StateIdx, qc.State.WatchUpTo)
 	}
+	var msgs []lnutil.LitMsg
+	upTo := qc.State.WatchUpTo
 	// send initial description if we haven't sent anything yet
-	if qc.State.WatchUpTo == 0 {
-		var peerIdx uint32
-		peerIdx = 0 // should be replaced
-
-		desc := lnutil.NewWatchDescMsg(peerIdx, qc.Coin(),
-			qc.WatchRefundAdr, qc.Delay, 5000, qc.TheirHAKDBase, qc.MyHAKDBase)
+	if upTo == 0 {
+		msgs = append(msgs, watchDescMsg(qc))
 
-		_, err := nd.WatchCon.Write(desc.Bytes())
-		if err != nil {
-			return err
-		}
 		// after sending description, must send at least states 0 and 1.
-		err = nd.SendWatchComMsg(qc, 0)
-		if err != nil {
-			return err
-		}
-		err = nd.SendWatchComMsg(qc, 1)
-		if err != nil {
-			return err
+		for idx := uint64(0); idx < 2; idx++ {
+			comMsg, err := nd.WatchComMsg(qc, idx)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, comMsg)
 		}
-		qc.State.WatchUpTo = 1
+		upTo = 1
 	}
 	// send messages to get up to 1 less than current state
-	for qc.State.WatchUpTo < qc.State.StateIdx-1 {
-		// increment watchupto number
-		qc.State.WatchUpTo++
-		err := nd.SendWatchComMsg(qc, qc.State.WatchUpTo)
+	for upTo < qc.State.StateIdx-1 {
+		upTo++
+		comMsg, err := nd.WatchComMsg(qc, upTo)
 		if err != nil {
 			return err
 		}
+		msgs = append(msgs, comMsg)
+	}
+	// queued and saved together, for tower backfills; see towerset.go
+	nd.towerBoxMtx.Lock()
+	err := nd.queueTowerMsgs(msgs...)
+	if err != nil {
+		nd.towerBoxMtx.Unlock()
+		return err
 	}
-	// save updated WatchUpTo number
-	return nd.SaveQchanState(qc)
+	// once queued they'll get there; save updated WatchUpTo number
+	qc.State.WatchUpTo = upTo
+	err = nd.SaveQchanState(qc)
+	nd.towerBoxMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return nd.FlushTowerBox()
 }
 
-// send WatchComMsg generates and sends the ComMsg to a watchtower
+// watchDescMsg describes a channel to the watchtower, before any states
+func watchDescMsg(qc *Qchan) lnutil.WatchDescMsg {
+	var peerIdx uint32
+	peerIdx = 0 // should be replaced
+
+	return lnutil.NewWatchDescMsg(peerIdx, qc.Coin(),
+		qc.WatchRefundAdr, qc.Delay, 5000, qc.TheirHAKDBase, qc.MyHAKDBase)
+}
+
+// SendWatchComMsg sends the ComMsg for a state to the watchtower, by way of
+// the outbox
 func (nd *LitNode) SendWatchComMsg(qc *Qchan, idx uint64) error {
-	// retreive the sig data from db
-	txidsig, err := nd.LoadJusticeSig(idx, qc.WatchRefundAdr)
+	comMsg, err := nd.WatchComMsg(qc, idx)
 	if err != nil {
 		return err
 	}
+	err = nd.queueTowerMsgs(comMsg)
+	if err != nil {
+		return err
+	}
+	return nd.FlushTowerBox()
+}
+
+// WatchComMsg generates the ComMsg for a state, for a watchtower
+func (nd *LitNode) WatchComMsg(qc *Qchan, idx uint64) (lnutil.WatchStateMsg, error) {
+	var comMsg lnutil.WatchStateMsg
+	// retreive the sig data from db
+	txidsig, hashType, err := nd.LoadJusticeSig(idx, qc.WatchRefundAdr)
+	if err != nil {
+		return comMsg, err
+	}
 	// get the elkrem
 	elk, err := qc.ElkRcv.AtIndex(idx)
 	if err != nil {
-		return err
+		return comMsg, err
 	}
 
 	var peerIdx uint32
@@ -259,12 +322,29 @@ func (nd *LitNode) SendWatchComMsg(qc *Qchan, idx uint64) error {
 	copy(parTx[:], txidsig[:16])
 	copy(sig[:], txidsig[16:])
 
-	comMsg := lnutil.NewComMsg(
+	comMsg = lnutil.NewComMsg(
 		peerIdx, qc.Coin(), qc.WatchRefundAdr, *elk, parTx, sig)
+	comMsg.HashType = uint8(hashType)
+	return comMsg, nil
+}
 
-	// stash to send all?  or just send once each time?  probably should
-	// set up some output buffering
-
-	_, err = nd.WatchCon.Write(comMsg.Bytes())
-	return err
+// UnwatchChannel tells the tower to stop watching a channel that closed
+// cooperatively, revealing the watch refund pubkey to prove it's us.  The
+// tower keeps watching for a while anyway, in case the close doesn't stick.
+func (nd *LitNode) UnwatchChannel(qc *Qchan) error {
+	if qc.State.WatchUpTo == 0 {
+		return nil // never told the tower about it
+	}
+	pub, err := nd.GetUsePub(qc.KeyGen, UseChannelWatchRefund)
+	if err != nil {
+		return err
+	}
+	var msg lnutil.WatchDelMsg
+	msg.DestPKH = qc.WatchRefundAdr
+	msg.RevealPK = pub
+	err = nd.queueTowerMsgs(msg)
+	if err != nil {
+		return err
+	}
+	return nd.FlushTowerBox()
 }
@@ -0,0 +1,561 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Channel leases: selling inbound capacity.  A node with lease tiers
+(leasetier, coin:capacity:feeppm:blocks) sends them to peers with its
+liquidity advert.  A peer wanting one sends a LeaseReqMsg for a tier's
+size, and gets back LeaseTermsMsg: the fee, an invoice for it, and a fresh
+wallet address it can be paid to on chain instead.  Terms last
+leaseTermsExpiry.
+
+LeaseWatcher looks at unpaid leases every leaseCheckInterval.  A lease is
+paid once its invoice is settled, or confirmed outputs to its address add
+up to the fee.  Invoices are settled by pay claims (payclaim.go), which
+mark the lease paid right away rather than at the next look.  Payments on
+chain are honoured up to leaseLatePayment after the terms were given, even
+if they've expired, since the coins are ours either way.  A paid lease's
+channel is funded as soon as the peer's connected and no other fund is
+going on, with nothing pushed, and is then kept open Blocks blocks:
+CoopClose refuses to close it before then.  The peer can close it any
+time, and breaking it is always possible; the lease is a promise, not a
+lock.
+
+Lease addresses are the wallet's like any other, so an output to one that
+gets spent before LeaseWatcher sees it isn't counted.
+
+Leases are kept in BKTLeases by payment hash, which is also their id.
+*/
+
+const (
+	leaseTermsExpiry   = 3600 // seconds
+	leaseCheckInterval = 30 * time.Second
+	leaseLatePayment   = 7 * 24 * 3600
+)
+
+const (
+	LeaseOffered = 0 // terms given, waiting for payment
+	LeasePaid    = 1 // waiting to fund the channel
+	LeaseOpen    = 2 // channel funded; we keep it open until EndHeight
+	LeaseExpired = 3 // not paid in time
+	LeaseEnded   = 4 // past EndHeight
+)
+
+// Lease is a channel we've sold, or offered to
+type Lease struct {
+	Hash     [32]byte // the invoice's payment hash
+	PeerIdx  uint32
+	CoinType uint32
+	Capacity int64
+	Fee      int64
+	Blocks   uint32
+	PKH      [20]byte // on chain payments go here
+	Created  int64    // unix time
+	Expires  int64
+
+	State       uint8
+	PaidOnChain bool
+	AmtPaid     int64
+	ChanIdx     uint32
+	EndHeight   int32 // we won't close it before this
+}
+
+// StateString is the lease state as a word
+func (l *Lease) StateString() string {
+	switch l.State {
+	case LeaseOffered:
+		return "offered"
+	case LeasePaid:
+		return "paid"
+	case LeaseOpen:
+		return "open"
+	case LeaseExpired:
+		return "expired"
+	case LeaseEnded:
+		return "ended"
+	}
+	return fmt.Sprintf("unknown %d", l.State)
+}
+
+// ToBytes serializes a lease; all fixed size fields, in order
+func (l *Lease) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(l.Hash[:])
+	binary.Write(&buf, binary.BigEndian, l.PeerIdx)
+	binary.Write(&buf, binary.BigEndian, l.CoinType)
+	binary.Write(&buf, binary.BigEndian, l.Capacity)
+	binary.Write(&buf, binary.BigEndian, l.Fee)
+	binary.Write(&buf, binary.BigEndian, l.Blocks)
+	buf.Write(l.PKH[:])
+	binary.Write(&buf, binary.BigEndian, l.Created)
+	binary.Write(&buf, binary.BigEndian, l.Expires)
+	buf.WriteByte(l.State)
+	binary.Write(&buf, binary.BigEndian, l.PaidOnChain)
+	binary.Write(&buf, binary.BigEndian, l.AmtPaid)
+	binary.Write(&buf, binary.BigEndian, l.ChanIdx)
+	binary.Write(&buf, binary.BigEndian, l.EndHeight)
+	return buf.Bytes()
+}
+
+// LeaseFromBytes deserializes a lease
+func LeaseFromBytes(b []byte) (*Lease, error) {
+	if len(b) != 114 {
+		return nil, fmt.Errorf("lease %d bytes, expect 114", len(b))
+	}
+	l := new(Lease)
+	buf := bytes.NewBuffer(b)
+	copy(l.Hash[:], buf.Next(32))
+	binary.Read(buf, binary.BigEndian, &l.PeerIdx)
+	binary.Read(buf, binary.BigEndian, &l.CoinType)
+	binary.Read(buf, binary.BigEndian, &l.Capacity)
+	binary.Read(buf, binary.BigEndian, &l.Fee)
+	binary.Read(buf, binary.BigEndian, &l.Blocks)
+	copy(l.PKH[:], buf.Next(20))
+	binary.Read(buf, binary.BigEndian, &l.Created)
+	binary.Read(buf, binary.BigEndian, &l.Expires)
+	l.State, _ = buf.ReadByte()
+	binary.Read(buf, binary.BigEndian, &l.PaidOnChain)
+	binary.Read(buf, binary.BigEndian, &l.AmtPaid)
+	binary.Read(buf, binary.BigEndian, &l.ChanIdx)
+	binary.Read(buf, binary.BigEndian, &l.EndHeight)
+	return l, nil
+}
+
+// ParseLeaseTier reads a tier from config: coin:capacity:feeppm:blocks,
+// eg 1:5000000:2500:4320 sells 5M sat channels on coin 1 for 0.25%, kept
+// open a month
+func ParseLeaseTier(s string) (lnutil.LeaseTier, error) {
+	var t lnutil.LeaseTier
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		return t, fmt.Errorf("lease tier %q isn't coin:capacity:feeppm:blocks", s)
+	}
+	var n [4]uint64
+	for i, p := range parts {
+		var err error
+		n[i], err = strconv.ParseUint(p, 10, 63)
+		if err != nil {
+			return t, fmt.Errorf("lease tier %q: %s", s, err.Error())
+		}
+	}
+	if n[0] > 0xffffffff || n[2] > 0xffffffff || n[3] > 0xffffffff {
+		return t, fmt.Errorf("lease tier %q out of range", s)
+	}
+	t.CoinType = uint32(n[0])
+	t.Capacity = int64(n[1])
+	t.FeePPM = uint32(n[2])
+	t.Blocks = uint32(n[3])
+	if t.Capacity < liqMinOpen {
+		return t, fmt.Errorf("lease tier %q: capacity under %d", s, liqMinOpen)
+	}
+	if t.Fee() < 1 {
+		return t, fmt.Errorf("lease tier %q: fee rounds to nothing", s)
+	}
+	return t, nil
+}
+
+// SetLeaseTiers sets the channels this node sells; none stops selling.
+// Leases already agreed carry on.
+func (nd *LitNode) SetLeaseTiers(tiers []lnutil.LeaseTier) {
+	nd.liquidity.mtx.Lock()
+	nd.liquidity.tiers = tiers
+	nd.liquidity.mtx.Unlock()
+}
+
+// LeaseTiers are the channels this node sells
+func (nd *LitNode) LeaseTiers() []lnutil.LeaseTier {
+	nd.liquidity.mtx.Lock()
+	defer nd.liquidity.mtx.Unlock()
+	return append([]lnutil.LeaseTier(nil), nd.liquidity.tiers...)
+}
+
+// LeaseReqHandler answers a peer asking what a channel would cost, with
+// terms or a reason there aren't any
+func (nd *LitNode) LeaseReqHandler(req lnutil.LeaseReqMsg) {
+	terms, err := nd.leaseTerms(req)
+	if err != nil {
+		logger.Infof("no lease of %d on coin %d for peer %d: %s\n",
+			req.Capacity, req.CoinType, req.PeerIdx, err.Error())
+		terms = lnutil.LeaseTermsMsg{
+			PeerIdx:  req.PeerIdx,
+			CoinType: req.CoinType,
+			Capacity: req.Capacity,
+			Reason:   err.Error(),
+		}
+	}
+	nd.OmniOut <- terms
+}
+
+// leaseTerms makes and saves a lease for a request, or gives the one the
+// peer already has for it
+func (nd *LitNode) leaseTerms(req lnutil.LeaseReqMsg) (lnutil.LeaseTermsMsg, error) {
+	var terms lnutil.LeaseTermsMsg
+	var tier *lnutil.LeaseTier
+	for _, t := range nd.LeaseTiers() {
+		if t.CoinType == req.CoinType && t.Capacity == req.Capacity {
+			t := t
+			tier = &t
+		}
+	}
+	if tier == nil {
+		return terms, fmt.Errorf("no lease of %d on coin %d for sale",
+			req.Capacity, req.CoinType)
+	}
+
+	leases, err := nd.ListLeases()
+	if err != nil {
+		return terms, err
+	}
+	now := time.Now().Unix()
+	for _, l := range leases {
+		if l.PeerIdx != req.PeerIdx || l.State != LeaseOffered || l.Expires < now {
+			continue
+		}
+		if l.CoinType != req.CoinType || l.Capacity != req.Capacity {
+			return terms, fmt.Errorf("you have an unpaid lease; pay it or wait %ds",
+				l.Expires-now)
+		}
+		return nd.termsOf(l)
+	}
+
+	spend, err := nd.spendable(req.CoinType)
+	if err != nil {
+		return terms, err
+	}
+	if spend < req.Capacity {
+		return terms, fmt.Errorf("can only fund %d on coin %d now", spend, req.CoinType)
+	}
+
+	l := new(Lease)
+	l.PeerIdx = req.PeerIdx
+	l.CoinType = tier.CoinType
+	l.Capacity = tier.Capacity
+	l.Fee = tier.Fee()
+	l.Blocks = tier.Blocks
+	l.Created = now
+	l.Expires = now + leaseTermsExpiry
+	l.PKH, err = nd.SubWallet[l.CoinType].NewAdr()
+	if err != nil {
+		return terms, err
+	}
+	inv, err := nd.AddInvoice(l.Fee,
+		fmt.Sprintf("lease of a %d channel on coin %d for %d blocks",
+			l.Capacity, l.CoinType, l.Blocks),
+		nil, leaseTermsExpiry,
+		map[string]string{"lease_peer": strconv.Itoa(int(l.PeerIdx))})
+	if err != nil {
+		return terms, err
+	}
+	l.Hash = inv.PaymentHash
+	err = nd.saveLease(l)
+	if err != nil {
+		return terms, err
+	}
+	logger.Infof("lease %x offered to peer %d: %d on coin %d for %d\n",
+		l.Hash[:4], l.PeerIdx, l.Capacity, l.CoinType, l.Fee)
+	return nd.termsOf(l)
+}
+
+// termsOf is the terms message for a lease
+func (nd *LitNode) termsOf(l *Lease) (lnutil.LeaseTermsMsg, error) {
+	inv, err := nd.GetInvoice(l.Hash)
+	if err != nil {
+		return lnutil.LeaseTermsMsg{}, err
+	}
+	adr, _ := nd.GetLisAddressAndPorts()
+	return lnutil.LeaseTermsMsg{
+		PeerIdx:  l.PeerIdx,
+		CoinType: l.CoinType,
+		Capacity: l.Capacity,
+		Fee:      l.Fee,
+		Blocks:   l.Blocks,
+		Expires:  l.Expires,
+		PKH:      l.PKH,
+		PayReq:   inv.PayReq(adr),
+	}, nil
+}
+
+func (nd *LitNode) saveLease(l *Lease) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		lb := btx.Bucket(BKTLeases)
+		if lb == nil {
+			return fmt.Errorf("no lease bucket")
+		}
+		return lb.Put(l.Hash[:], l.ToBytes())
+	})
+}
+
+// GetLease returns a lease by payment hash
+func (nd *LitNode) GetLease(hash [32]byte) (*Lease, error) {
+	var l *Lease
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		lb := btx.Bucket(BKTLeases)
+		if lb == nil {
+			return fmt.Errorf("no lease bucket")
+		}
+		b := lb.Get(hash[:])
+		if b == nil {
+			return fmt.Errorf("no lease %x", hash)
+		}
+		var err error
+		l, err = LeaseFromBytes(b)
+		return err
+	})
+	return l, err
+}
+
+// ListLeases returns all the leases we've offered
+func (nd *LitNode) ListLeases() ([]*Lease, error) {
+	var leases []*Lease
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		lb := btx.Bucket(BKTLeases)
+		if lb == nil {
+			return fmt.Errorf("no lease bucket")
+		}
+		return lb.ForEach(func(k, v []byte) error {
+			l, err := LeaseFromBytes(v)
+			if err != nil {
+				return err
+			}
+			leases = append(leases, l)
+			return nil
+		})
+	})
+	return leases, err
+}
+
+// leaseHolds says why a channel can't be closed yet, if it's leased
+func (nd *LitNode) leaseHolds(q *Qchan) error {
+	leases, err := nd.ListLeases()
+	if err != nil {
+		return err
+	}
+	wal, ok := nd.SubWallet[q.Coin()]
+	if !ok {
+		return nil
+	}
+	for _, l := range leases {
+		if l.State == LeaseOpen && l.ChanIdx == q.Idx() &&
+			wal.CurrentHeight() < l.EndHeight {
+			return fmt.Errorf("channel %d is leased to peer %d until height %d",
+				q.Idx(), l.PeerIdx, l.EndHeight)
+		}
+	}
+	return nil
+}
+
+// LeaseWatcher marks leases paid, opens their channels, and expires and
+// ends them.  Runs until shutdown.
+func (nd *LitNode) LeaseWatcher() {
+	for !nd.ShuttingDown() {
+		time.Sleep(leaseCheckInterval)
+		leases, err := nd.ListLeases()
+		if err != nil {
+			logger.Errorf("leases: %s\n", err.Error())
+			continue
+		}
+		for _, l := range leases {
+			err = nd.checkLease(l)
+			if err != nil {
+				logger.Warnf("lease %x: %s\n", l.Hash[:4], err.Error())
+			}
+		}
+	}
+}
+
+// leaseInvoiceSettled marks a lease paid once its invoice is settled
+func (nd *LitNode) leaseInvoiceSettled(hash [32]byte) error {
+	l, err := nd.GetLease(hash)
+	if err != nil || l.State != LeaseOffered {
+		return nil // not a lease's invoice, or already paid
+	}
+	return nd.checkLease(l)
+}
+
+// checkLease moves a lease on, if it's time
+func (nd *LitNode) checkLease(l *Lease) error {
+	now := time.Now().Unix()
+	wal, ok := nd.SubWallet[l.CoinType]
+	if !ok {
+		return fmt.Errorf("no wallet for coin type %d", l.CoinType)
+	}
+
+	switch l.State {
+	case LeaseOffered, LeaseExpired:
+		if now > l.Created+leaseLatePayment {
+			return nil
+		}
+		inv, err := nd.GetInvoice(l.Hash)
+		if err != nil {
+			return err
+		}
+		if inv.Settled {
+			l.AmtPaid = inv.AmtPaid
+		} else if paid, err := nd.leasePaidOnChain(l); err != nil {
+			return err
+		} else if paid >= l.Fee {
+			l.AmtPaid = paid
+			l.PaidOnChain = true
+		}
+		if l.AmtPaid != 0 {
+			l.State = LeasePaid
+			logger.Infof("lease %x paid %d\n", l.Hash[:4], l.AmtPaid)
+			return nd.saveLease(l)
+		}
+		if l.State == LeaseOffered && now > l.Expires {
+			l.State = LeaseExpired
+			return nd.saveLease(l)
+		}
+
+	case LeasePaid:
+		return nd.openLease(l)
+
+	case LeaseOpen:
+		if wal.CurrentHeight() >= l.EndHeight {
+			l.State = LeaseEnded
+			logger.Infof("lease %x on channel %d ended\n", l.Hash[:4], l.ChanIdx)
+			return nd.saveLease(l)
+		}
+	}
+	return nil
+}
+
+// leasePaidOnChain adds up the confirmed outputs paying a lease's address
+func (nd *LitNode) leasePaidOnChain(l *Lease) (int64, error) {
+	wal := nd.SubWallet[l.CoinType]
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return 0, err
+	}
+	script := lnutil.DirectWPKHScriptFromPKH(l.PKH)
+	var paid int64
+	for _, u := range utxos {
+		if u.Height < 1 {
+			continue
+		}
+		pk := u.PkScript
+		if len(pk) == 0 {
+			var pub [33]byte
+			copy(pub[:], wal.GetPub(u.KeyGen).SerializeCompressed())
+			pk = lnutil.DirectWPKHScript(pub)
+		}
+		if bytes.Equal(pk, script) {
+			paid += u.Value
+		}
+	}
+	return paid, nil
+}
+
+// openLease funds a paid lease's channel, if the peer's here and nothing
+// else is being funded
+func (nd *LitNode) openLease(l *Lease) error {
+	if !nd.ConnectedToPeer(l.PeerIdx) {
+		return nil // next time
+	}
+	liq := nd.liquidity
+	liq.mtx.Lock()
+	if liq.leasing {
+		liq.mtx.Unlock()
+		return nil
+	}
+	liq.leasing = true
+	liq.mtx.Unlock()
+
+	cIdx, err := nd.startFund(l.PeerIdx, l.CoinType, l.Capacity, 0, false)
+	if err != nil {
+		liq.mtx.Lock()
+		liq.leasing = false
+		liq.mtx.Unlock()
+		return err
+	}
+	logger.Infof("funding lease %x: %d channel for peer %d\n",
+		l.Hash[:4], l.Capacity, l.PeerIdx)
+	hash := l.Hash
+	nd.fundStarted(l.PeerIdx, l.CoinType, cIdx, func(idx uint32, err error) {
+		defer func() {
+			liq.mtx.Lock()
+			liq.leasing = false
+			liq.mtx.Unlock()
+		}()
+		if err != nil {
+			// still LeasePaid; tried again next time
+			logger.Warnf("lease %x fund: %s\n", hash[:4], err.Error())
+			return
+		}
+		l, err := nd.GetLease(hash)
+		if err != nil {
+			logger.Errorf("lease %x funded as channel %d: %s\n",
+				hash[:4], idx, err.Error())
+			return
+		}
+		l.State = LeaseOpen
+		l.ChanIdx = idx
+		l.EndHeight = nd.SubWallet[l.CoinType].CurrentHeight() + int32(l.Blocks)
+		err = nd.saveLease(l)
+		if err != nil {
+			logger.Errorf("lease %x: %s\n", hash[:4], err.Error())
+			return
+		}
+		logger.Infof("lease %x open as channel %d until height %d\n",
+			hash[:4], idx, l.EndHeight)
+		nd.sendLiquidityAd(l.PeerIdx)
+	})
+	return nil
+}
+
+// BuyLease asks a peer for the terms of one of the leases it offers, and
+// checks they're what it offered.  Pay them to get the channel.
+func (nd *LitNode) BuyLease(peerIdx, coin uint32, capacity int64,
+	wait time.Duration) (lnutil.LeaseTermsMsg, error) {
+
+	var terms lnutil.LeaseTermsMsg
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[peerIdx]
+	var offers []lnutil.LeaseTier
+	if ok {
+		offers = peer.LeaseOffers
+	}
+	nd.RemoteMtx.Unlock()
+	if !ok {
+		return terms, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	var tier *lnutil.LeaseTier
+	for _, t := range offers {
+		if t.CoinType == coin && t.Capacity == capacity {
+			t := t
+			tier = &t
+		}
+	}
+	if tier == nil {
+		return terms, fmt.Errorf("peer %d doesn't offer %d on coin %d",
+			peerIdx, capacity, coin)
+	}
+
+	req := lnutil.LeaseReqMsg{PeerIdx: peerIdx, CoinType: coin, Capacity: capacity}
+	answer, err := nd.askPeer(req, lnutil.MSGID_LIQ_TERMS, wait)
+	if err != nil {
+		return terms, err
+	}
+	terms = answer.(lnutil.LeaseTermsMsg)
+	if terms.Reason != "" {
+		return terms, fmt.Errorf("peer %d won't: %s", peerIdx, terms.Reason)
+	}
+	if terms.Fee > tier.Fee() || terms.Blocks < tier.Blocks ||
+		terms.CoinType != coin || terms.Capacity != capacity {
+		return terms, fmt.Errorf("peer %d's terms (%d for %d blocks) aren't "+
+			"what it offered (%d for %d blocks)",
+			peerIdx, terms.Fee, terms.Blocks, tier.Fee(), tier.Blocks)
+	}
+	return terms, nil
+}
@@ -0,0 +1,387 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Liquidity adverts.  Each node tells peers with FeatureLiquidityAds how much
+it can send and receive over its open channels, per coin, and whether it'll
+open a channel if asked: openonrequest is the biggest channel it'll fund,
+0 for none.  The advert goes out when a peer's features come in, and again
+every liqAdvertInterval, and the peer's latest is kept with the connection
+and shown in the peer list.
+
+A peer short of inbound capacity sends an OpenChanReqMsg.  If we open on
+request, the capacity's within our max and what the wallet can spend, and
+we haven't opened one for that peer in the last liqOpenInterval, we say
+yes and fund it, pushing nothing, so it's all their inbound.  Otherwise we
+say no and why.  RequestInbound is the asking side.
+
+Nodes can also sell channels; see leases.go.  Their offers go out with
+the advert.
+*/
+
+const (
+	liqAdvertInterval = time.Hour
+	// one channel on request per peer per this long
+	liqOpenInterval = 24 * time.Hour
+	// smallest channel startFund will make
+	liqMinOpen = 1000000
+	// left for the funding tx's fee, as the fund RPC does
+	liqFeeMargin = 50000
+)
+
+type liquidityState struct {
+	mtx     sync.Mutex
+	maxOpen int64 // sat; 0 doesn't open on request
+	// when we last opened a channel on request, by peer
+	opened map[uint32]time.Time
+	// requests we're waiting on an answer to
+	waiting map[liqWait]chan lnutil.LitMsg
+	// channels we sell, and whether one's being funded; see leases.go
+	tiers   []lnutil.LeaseTier
+	leasing bool
+}
+
+// liqWait is a peer and the type of message we're waiting on from it
+type liqWait struct {
+	peer    uint32
+	msgType uint8
+}
+
+func newLiquidityState() *liquidityState {
+	return &liquidityState{
+		opened:  make(map[uint32]time.Time),
+		waiting: make(map[liqWait]chan lnutil.LitMsg),
+	}
+}
+
+// SetOpenOnRequest sets the biggest channel, in satoshis, this node will
+// open when a peer asks for inbound capacity.  0 turns it off.
+func (nd *LitNode) SetOpenOnRequest(maxOpen int64) {
+	nd.liquidity.mtx.Lock()
+	nd.liquidity.maxOpen = maxOpen
+	nd.liquidity.mtx.Unlock()
+}
+
+// OpenOnRequest is the biggest channel this node opens on request; 0 if
+// it doesn't
+func (nd *LitNode) OpenOnRequest() int64 {
+	nd.liquidity.mtx.Lock()
+	defer nd.liquidity.mtx.Unlock()
+	return nd.liquidity.maxOpen
+}
+
+// spendable is what a coin's wallet could put in a channel now, after
+// the fee
+func (nd *LitNode) spendable(coin uint32) (int64, error) {
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return 0, fmt.Errorf("no wallet for coin type %d", coin)
+	}
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return 0, err
+	}
+	return portxo.TxoSliceByAmt(utxos).SumWitness(wal.CurrentHeight()) -
+		liqFeeMargin, nil
+}
+
+// Liquidity sums this node's open channels by coin.  Every wallet's coin
+// is in it, channels or not.  MaxOpen is what we'd open on request,
+// capped by what the wallet can spend.
+func (nd *LitNode) Liquidity() ([]lnutil.LiquidityCoin, error) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	coins := make(map[uint32]*lnutil.LiquidityCoin)
+	for coin := range nd.SubWallet {
+		coins[coin] = &lnutil.LiquidityCoin{CoinType: coin}
+	}
+	for _, q := range qcs {
+		if q.CloseData.Closed {
+			continue
+		}
+		lc, ok := coins[q.Coin()]
+		if !ok {
+			lc = &lnutil.LiquidityCoin{CoinType: q.Coin()}
+			coins[q.Coin()] = lc
+		}
+		lc.Outbound += q.State.MyAmt
+		lc.Inbound += q.Value - q.State.MyAmt
+	}
+
+	maxOpen := nd.OpenOnRequest()
+	liq := make([]lnutil.LiquidityCoin, 0, len(coins))
+	for coin, lc := range coins {
+		if maxOpen != 0 {
+			if spend, err := nd.spendable(coin); err == nil {
+				lc.MaxOpen = maxOpen
+				if spend < lc.MaxOpen {
+					lc.MaxOpen = spend
+				}
+				if lc.MaxOpen < liqMinOpen {
+					lc.MaxOpen = 0
+				}
+			}
+		}
+		liq = append(liq, *lc)
+	}
+	sort.Slice(liq, func(i, j int) bool {
+		return liq[i].CoinType < liq[j].CoinType
+	})
+	return liq, nil
+}
+
+// sendLiquidityAd tells a peer what our channels can send and receive,
+// and what channels we sell
+func (nd *LitNode) sendLiquidityAd(peerIdx uint32) {
+	liq, err := nd.Liquidity()
+	if err != nil {
+		logger.Warnf("liquidity advert: %s\n", err.Error())
+		return
+	}
+	la := lnutil.LiquidityAdMsg{
+		PeerIdx: peerIdx,
+		Time:    time.Now().Unix(),
+		Coins:   liq,
+	}
+	for _, lc := range liq {
+		if lc.MaxOpen != 0 {
+			la.Flags |= lnutil.LiqFlagOpensOnRequest
+		}
+	}
+	nd.OmniOut <- la
+	nd.OmniOut <- lnutil.LeaseOffersMsg{PeerIdx: peerIdx, Tiers: nd.LeaseTiers()}
+}
+
+// LiquidityAdverts sends connected peers that take them a fresh liquidity
+// advert every liqAdvertInterval.  Runs until shutdown.
+func (nd *LitNode) LiquidityAdverts() {
+	for !nd.ShuttingDown() {
+		time.Sleep(liqAdvertInterval)
+		var peers []uint32
+		nd.RemoteMtx.Lock()
+		for idx, peer := range nd.RemoteCons {
+			if peer.Features.Has(lnutil.FeatureLiquidityAds) {
+				peers = append(peers, idx)
+			}
+		}
+		nd.RemoteMtx.Unlock()
+		for _, idx := range peers {
+			nd.sendLiquidityAd(idx)
+		}
+	}
+}
+
+// LiquidityHandler takes liquidity adverts, lease offers, requests for
+// either, and answers
+func (nd *LitNode) LiquidityHandler(msg lnutil.LitMsg, peer *RemotePeer) error {
+	switch m := msg.(type) {
+	case lnutil.LiquidityAdMsg:
+		nd.RemoteMtx.Lock()
+		peer.Liquidity = &m
+		nd.RemoteMtx.Unlock()
+		return nil
+
+	case lnutil.LeaseOffersMsg:
+		nd.RemoteMtx.Lock()
+		peer.LeaseOffers = m.Tiers
+		nd.RemoteMtx.Unlock()
+		return nil
+
+	case lnutil.OpenChanReqMsg:
+		nd.openOnRequest(m)
+		return nil
+
+	case lnutil.LeaseReqMsg:
+		nd.LeaseReqHandler(m)
+		return nil
+
+	case lnutil.OpenChanRespMsg, lnutil.LeaseTermsMsg:
+		return nd.answered(msg)
+	}
+	return fmt.Errorf("unknown liquidity message type %x", msg.MsgType())
+}
+
+// askPeer sends req and waits up to wait for the peer's message of type
+// respType.  One at a time per peer and type.
+func (nd *LitNode) askPeer(
+	req lnutil.LitMsg, respType uint8, wait time.Duration) (lnutil.LitMsg, error) {
+
+	key := liqWait{peer: req.Peer(), msgType: respType}
+	answer := make(chan lnutil.LitMsg, 1)
+	liq := nd.liquidity
+	liq.mtx.Lock()
+	if _, asked := liq.waiting[key]; asked {
+		liq.mtx.Unlock()
+		return nil, fmt.Errorf("already waiting on peer %d", req.Peer())
+	}
+	liq.waiting[key] = answer
+	liq.mtx.Unlock()
+	defer func() {
+		liq.mtx.Lock()
+		delete(liq.waiting, key)
+		liq.mtx.Unlock()
+	}()
+
+	nd.OmniOut <- req
+	select {
+	case resp := <-answer:
+		return resp, nil
+	case <-time.After(wait):
+		return nil, fmt.Errorf("no answer from peer %d after %s", req.Peer(), wait)
+	}
+}
+
+// answered hands an answer to whoever's waiting on it in askPeer
+func (nd *LitNode) answered(msg lnutil.LitMsg) error {
+	key := liqWait{peer: msg.Peer(), msgType: msg.MsgType()}
+	nd.liquidity.mtx.Lock()
+	answer, ok := nd.liquidity.waiting[key]
+	nd.liquidity.mtx.Unlock()
+	if !ok {
+		return fmt.Errorf("message type %x from peer %d, which we didn't ask",
+			msg.MsgType(), msg.Peer())
+	}
+	select {
+	case answer <- msg:
+	default: // already answered
+	}
+	return nil
+}
+
+// openOnRequest funds a channel a peer's asked for, if we will
+func (nd *LitNode) openOnRequest(req lnutil.OpenChanReqMsg) {
+	resp := lnutil.OpenChanRespMsg{
+		PeerIdx:  req.PeerIdx,
+		CoinType: req.CoinType,
+		Capacity: req.Capacity,
+	}
+	var cIdx uint32
+	err := nd.checkOpenRequest(req)
+	if err == nil {
+		cIdx, err = nd.startFund(req.PeerIdx, req.CoinType, req.Capacity, 0, false)
+	}
+	if err != nil {
+		logger.Infof("not opening %d channel on coin %d for peer %d: %s\n",
+			req.Capacity, req.CoinType, req.PeerIdx, err.Error())
+		resp.Reason = err.Error()
+		nd.OmniOut <- resp
+		return
+	}
+
+	nd.liquidity.mtx.Lock()
+	nd.liquidity.opened[req.PeerIdx] = time.Now()
+	nd.liquidity.mtx.Unlock()
+	logger.Infof("opening %d channel on coin %d for peer %d on request\n",
+		req.Capacity, req.CoinType, req.PeerIdx)
+
+	resp.Accepted = true
+	nd.OmniOut <- resp
+	nd.fundStarted(req.PeerIdx, req.CoinType, cIdx, func(idx uint32, err error) {
+		if err != nil {
+			logger.Warnf("channel requested by peer %d not opened: %s\n",
+				req.PeerIdx, err.Error())
+			return
+		}
+		logger.Infof("channel %d opened on request of peer %d\n",
+			idx, req.PeerIdx)
+		nd.sendLiquidityAd(req.PeerIdx)
+	})
+}
+
+// fundStarted goes on with a fund startFund has set up, as FundChannel
+// does but without waiting; done gets the channel index once it's open, or
+// why it didn't
+func (nd *LitNode) fundStarted(
+	peerIdx, coin, cIdx uint32, done func(uint32, error)) {
+
+	nd.OmniOut <- lnutil.NewPointReqMsg(peerIdx, coin)
+	go func() {
+		done(nd.waitFund(peerIdx, cIdx))
+	}()
+}
+
+// checkOpenRequest says why we won't open a requested channel, if we won't
+func (nd *LitNode) checkOpenRequest(req lnutil.OpenChanReqMsg) error {
+	liq := nd.liquidity
+	liq.mtx.Lock()
+	maxOpen := liq.maxOpen
+	last := liq.opened[req.PeerIdx]
+	liq.mtx.Unlock()
+
+	if maxOpen == 0 {
+		return fmt.Errorf("not opening channels on request")
+	}
+	if req.Capacity < liqMinOpen || req.Capacity > maxOpen {
+		return fmt.Errorf("capacity must be %d to %d", liqMinOpen, maxOpen)
+	}
+	if since := time.Since(last); since < liqOpenInterval {
+		return fmt.Errorf("opened one for you %s ago; try again in %s",
+			since.Truncate(time.Minute), (liqOpenInterval - since).Truncate(time.Minute))
+	}
+	spend, err := nd.spendable(req.CoinType)
+	if err != nil {
+		return err
+	}
+	if spend < req.Capacity {
+		return fmt.Errorf("can only fund %d on coin %d now", spend, req.CoinType)
+	}
+	return nil
+}
+
+// RequestInbound asks a peer to open a channel of capacity to us on coin,
+// and waits up to wait for the answer.  If it's yes, the channel comes the
+// usual way, as if the peer had run fund.
+func (nd *LitNode) RequestInbound(
+	peerIdx, coin uint32, capacity int64,
+	wait time.Duration) (lnutil.OpenChanRespMsg, error) {
+
+	var resp lnutil.OpenChanRespMsg
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[peerIdx]
+	var features lnutil.Features
+	var ad *lnutil.LiquidityAdMsg
+	if ok {
+		features, ad = peer.Features, peer.Liquidity
+	}
+	nd.RemoteMtx.Unlock()
+	if !ok {
+		return resp, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	if !features.Has(lnutil.FeatureLiquidityAds) {
+		return resp, fmt.Errorf("peer %d doesn't take open requests", peerIdx)
+	}
+	if ad != nil {
+		var maxOpen int64
+		for _, lc := range ad.Coins {
+			if lc.CoinType == coin {
+				maxOpen = lc.MaxOpen
+			}
+		}
+		if capacity > maxOpen {
+			return resp, fmt.Errorf("peer %d opens at most %d on coin %d",
+				peerIdx, maxOpen, coin)
+		}
+	}
+
+	req := lnutil.OpenChanReqMsg{
+		PeerIdx:  peerIdx,
+		CoinType: coin,
+		Capacity: capacity,
+	}
+	answer, err := nd.askPeer(req, lnutil.MSGID_LIQ_OPENRESP, wait)
+	if err != nil {
+		return resp, err
+	}
+	return answer.(lnutil.OpenChanRespMsg), nil
+}
@@ -40,6 +40,17 @@ type Qchan struct {
 
 	State *StatCom // S current state of channel
 
+	Features lnutil.Features // S channel features agreed at open
+
+	// ShortID is where the funding output is on chain, for naming the
+	// channel to other nodes and tools; 0 until it's confirmed
+	ShortID lnutil.ShortChanID // S
+
+	// where each side's money goes on a coop close, if they said when the
+	// channel opened; otherwise their refund pubkey.  See upfront.go
+	MyShutdown    []byte // S
+	TheirShutdown []byte // S
+
 	ClearToSend chan bool // send a true here when you get a rev
 	// exists only in ram, doesn't touch disk
 }
@@ -165,6 +176,16 @@ func (q *Qchan) Coin() uint32 {
 	return q.KeyGen.Step[1] & 0x7fffffff
 }
 
+// LocalReserve is the part of my balance which can't be pushed away: the
+// minimum output size plus the fee.  Can't be more than my balance.
+func (q *Qchan) LocalReserve() int64 {
+	r := minOutput + q.State.Fee
+	if r > q.State.MyAmt {
+		r = q.State.MyAmt
+	}
+	return r
+}
+
 // ImFirst decides who goes first when it's unclear.  Smaller pubkey goes first.
 func (q *Qchan) ImFirst() bool {
 	return bytes.Compare(q.MyRefundPub[:], q.TheirRefundPub[:]) == -1
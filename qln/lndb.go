@@ -2,9 +2,12 @@ package qln
 
 import (
 	"fmt"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
 	"github.com/boltdb/bolt"
@@ -83,7 +86,8 @@ You can't remove wallets once they're attached; just restart instead.
 type LitNode struct {
 	LitDB *bolt.DB // place to write all this down
 
-	LitFolder string // path to save stuff
+	LitFolder string   // path to save stuff
+	Dirs      DataDirs // where the DBs go; see datadirs.go
 
 	IdentityKey *btcec.PrivateKey
 
@@ -101,13 +105,52 @@ type LitNode struct {
 
 	RemoteCons map[uint32]*RemotePeer
 	RemoteMtx  sync.Mutex
+	// how long each peer's been connected; see uptime.go
+	uptime *uptimeLog
+	// channels we've asked the peer to break; see peerscore.go
+	askedBreaks *askedBreaks
 
 	// WatchCon is currently just for the watchtower
 	WatchCon *lndc.LNDConn // merge these later
+	// key sealing the tower outbox; see towerbox.go
+	towerBoxKey [32]byte
+	towerBoxMtx sync.Mutex
+	// towers to upload to instead: how many at once, how long one can be
+	// away, TowerKeeper's wake up, and one KeepTowers at a time; see
+	// towerset.go
+	towerK        int
+	towerFailover time.Duration
+	towerKick     chan struct{}
+	towerKeepMtx  sync.Mutex
+	// key sealing channel backups, and where they go; see chanbackup.go
+	chanBakKey [32]byte
+	cloudBak   *cloudBackup
+	// where coop closes pay us, by coin, sent when channels open; see
+	// upfront.go
+	shutdownScripts map[uint32][]byte
+	// non urgent on chain actions held back while fees are high; see
+	// feeguard.go
+	feeGuard *feeGuard
+	// confirmation targets of the txs lit sends by itself; see sweepfees.go
+	sweepFees *sweepFees
+	// payments going in the background; see payqueue.go
+	payQueue *payQueue
+	// custom messages from peers; see custommsg.go
+	customInbox *customInbox
+	// funding tx replacements waiting for the peer; see fundbump.go
+	fundBumps *fundBumps
+	// trouble seen by AlertWatcher; see alerts.go
+	alerts *alertState
+	// opening channels on request, and asking; see liquidity.go
+	liquidity *liquidityState
+	// what's changing each channel, one at a time; see chanqueue.go
+	chanOps *chanQueues
 
 	// OmniChan is the channel for the OmniHandler
 	OmniIn  chan lnutil.LitMsg
 	OmniOut chan lnutil.LitMsg
+	// tower uploads, sent when OmniOut's empty; see streams.go
+	towerOut chan towerUpload
 
 	// the current channel that in the process of being created
 	// (1 at a time for now)
@@ -124,14 +167,58 @@ type LitNode struct {
 
 	// The URL from which lit attempts to resolve the LN address
 	TrackerURL string
+
+	// subscribers to node events (see events.go)
+	EventMtx  sync.Mutex
+	eventSubs []chan NodeEvent
+
+	// peer listeners, closed on shutdown.  Guarded by RemoteMtx
+	listeners []net.Listener
+	// non-zero once Shutdown has been called (atomic)
+	shutdown int32
+
+	// url the tracker had for us right after we announced; see identity.go.
+	// Set by the listener, read by channel creation, so guarded by
+	// announcedMtx
+	announcedURL string
+	announcedMtx sync.Mutex
+
+	// one swap message or event at a time; see swap.go
+	SwapMtx sync.Mutex
+
+	// sign justice for towers with SINGLE|ANYONECANPAY; see justicetx.go
+	JusticeAnyoneCanPay bool
+
+	// satoshis per whole unit of a fiat currency, for orders; see fiat.go
+	FiatRate func(currency string) (float64, error)
+	// currency to show amounts in, if any
+	FiatCurrency string
+
+	// sat/byte to confirm within some blocks on a coin, if there's a fee
+	// source; see sweepfees.go
+	FeeEstimate func(coin uint32, target int32) (int64, error)
+
+	// channel state machine log, if on; see replaylog.go
+	replay *replayLog
+
+	// woken when any wallet's chain gets a new block; see blockWaiter
+	blockMtx     sync.Mutex
+	blockWaiters []chan struct{}
 }
 
 type RemotePeer struct {
 	Idx      uint32 // the peer index
 	Nickname string
 	Con      *lndc.LNDConn
+	Features lnutil.Features     // what they said they can do; see features.go
+	MsgVer   uint8               // message version they speak; see envelope.go
 	QCs      map[uint32]*Qchan   // keep map of all peer's channels in ram
 	OpMap    map[[36]byte]uint32 // quick lookup for channels
+
+	// their latest liquidity advert, if any, and the channels they sell;
+	// see liquidity.go and leases.go
+	Liquidity   *lnutil.LiquidityAdMsg
+	LeaseOffers []lnutil.LeaseTier
 }
 
 // InFlightFund is a funding transaction that has not yet been broadcast
@@ -141,7 +228,18 @@ type InFlightFund struct {
 
 	op *wire.OutPoint
 
-	done chan uint32
+	// funded by a tx from outside the wallet; see fundext.go
+	External bool
+	extQ     *Qchan      // channel waiting for its funding tx
+	extTxo   *wire.TxOut // output the funding tx needs
+	extOut   chan *wire.TxOut
+	extAcked bool // peer signed; ok to broadcast
+
+	// coins frozen for the funding tx, by MaybeSend; see fundfreeze.go
+	reserved *chainhash.Hash
+
+	done   chan uint32
+	failed chan error // gets why, if it's given up on
 	// use this to avoid crashiness
 	mtx sync.Mutex
 }
@@ -152,6 +250,13 @@ func (inff *InFlightFund) Clear() {
 
 	inff.Amt = 0
 	inff.InitSend = 0
+
+	inff.op = nil
+	inff.reserved = nil
+	inff.External = false
+	inff.extQ = nil
+	inff.extTxo = nil
+	inff.extAcked = false
 }
 
 // GetPubHostFromPeerIdx gets the pubkey and internet host name for a peer
@@ -186,6 +291,39 @@ func (nd *LitNode) GetPubHostFromPeerIdx(idx uint32) ([33]byte, string) {
 	return pub, host
 }
 
+// KnownPeer is a peer in the db, whether or not we're connected
+type KnownPeer struct {
+	Idx      uint32
+	Pub      [33]byte
+	Host     string
+	Nickname string
+}
+
+// GetAllPeers returns every peer we've ever connected to
+func (nd *LitNode) GetAllPeers() ([]KnownPeer, error) {
+	var peers []KnownPeer
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		peerBkt := btx.Bucket(BKTPeers)
+		if mp == nil || peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		return mp.ForEach(func(k, v []byte) error {
+			var kp KnownPeer
+			kp.Idx = lnutil.BtU32(k)
+			copy(kp.Pub[:], v)
+			prBkt := peerBkt.Bucket(v)
+			if prBkt != nil {
+				kp.Host = string(prBkt.Get(KEYhost))
+				kp.Nickname = string(prBkt.Get(KEYnickname))
+			}
+			peers = append(peers, kp)
+			return nil
+		})
+	})
+	return peers, err
+}
+
 // GetNicknameFromPeerIdx gets the nickname for a peer
 func (nd *LitNode) GetNicknameFromPeerIdx(idx uint32) string {
 	var nickname string
@@ -312,7 +450,7 @@ func (nd *LitNode) SaveNicknameForPeerIdx(nickname string, idx uint32) error {
 
 // SaveQchanUtxoData saves utxo data such as outpoint and close tx / status
 func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no peers")
@@ -336,6 +474,14 @@ func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
 			}
 		}
 
+		// kept once known; a channel reconfirmed after a reorg gets a new one
+		if q.ShortID != 0 {
+			err := qcBucket.Put(KEYShortID, lnutil.U64tB(uint64(q.ShortID)))
+			if err != nil {
+				return err
+			}
+		}
+
 		// serialize channel
 		qcBytes, err := q.ToBytes()
 		if err != nil {
@@ -345,6 +491,10 @@ func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
 		// save qchannel
 		return qcBucket.Put(KEYutxo, qcBytes)
 	})
+	if err == nil {
+		nd.syncChannel(q)
+	}
+	return err
 }
 
 // register a new Qchan in the db
@@ -412,6 +562,24 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 			}
 		}
 
+		// channel features, if any were agreed
+		if len(q.Features) > 0 {
+			err = qcBucket.Put(KEYChanFeatures, q.Features)
+			if err != nil {
+				return err
+			}
+		}
+		if q.ShortID != 0 {
+			err = qcBucket.Put(KEYShortID, lnutil.U64tB(uint64(q.ShortID)))
+			if err != nil {
+				return err
+			}
+		}
+		err = putShutdownScripts(qcBucket, q)
+		if err != nil {
+			return err
+		}
+
 		// serialize state
 		b, err := q.State.ToBytes()
 		if err != nil {
@@ -424,6 +592,8 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 	if err != nil {
 		return err
 	}
+	// a new channel; the channel backup needs it
+	nd.chanBackupChanged()
 
 	return nil
 }
@@ -450,6 +620,18 @@ func (nd *LitNode) RestoreQchanFromBucket(bkt *bolt.Bucket) (*Qchan, error) {
 	if err != nil {
 		return nil, err
 	}
+	if f := bkt.Get(KEYChanFeatures); f != nil {
+		qc.Features = append(lnutil.Features(nil), f...)
+	}
+	if sid := bkt.Get(KEYShortID); len(sid) == 8 {
+		qc.ShortID = lnutil.ShortChanID(lnutil.BtU64(sid))
+	}
+	if b := bkt.Get(KEYMyShutdown); b != nil {
+		qc.MyShutdown = append([]byte(nil), b...)
+	}
+	if b := bkt.Get(KEYTheirShutdown); b != nil {
+		qc.TheirShutdown = append([]byte(nil), b...)
+	}
 
 	// get my channel pubkey
 	qc.MyPub, _ = nd.GetUsePub(qc.KeyGen, UseChannelFund)
@@ -582,7 +764,9 @@ func (nd *LitNode) SetQchanRefund(q *Qchan, refund, hakdBase [33]byte) error {
 // if we can make that it's own function.  Get channel bucket maybe?  But then
 // you have to close it...
 func (nd *LitNode) SaveQchanState(q *Qchan) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+	// logged for arbitration exports
+	rec := q.stateRecord()
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no channels")
@@ -608,10 +792,19 @@ func (nd *LitNode) SaveQchanState(q *Qchan) error {
 		if err != nil {
 			return err
 		}
+		err = putStateRecord(qcBucket, rec)
+		if err != nil {
+			return err
+		}
 		// save state
 		fmt.Printf("writing %d byte state to bucket\n", len(b))
 		return qcBucket.Put(KEYState, b)
 	})
+	if err == nil {
+		nd.replayState(q)
+		nd.syncChannel(q)
+	}
+	return err
 }
 
 // GetAllQchans returns a slice of all channels. empty slice is OK.
@@ -709,3 +902,17 @@ func (nd *LitNode) GetQchanByIdx(cIdx uint32) (*Qchan, error) {
 	}
 	return qc, nil
 }
+
+// GetQchanByShortID returns the channel with a short channel id
+func (nd *LitNode) GetQchanByShortID(sid lnutil.ShortChanID) (*Qchan, error) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	for _, qc := range qcs {
+		if qc.ShortID == sid {
+			return qc, nil
+		}
+	}
+	return nil, fmt.Errorf("no channel %s", sid.String())
+}
@@ -0,0 +1,6 @@
+package qln
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the qln subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("qln")
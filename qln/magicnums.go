@@ -9,6 +9,8 @@ const (
 	UseChannelWatchRefund = 31 | hdkeychain.HardenedKeyStart
 	UseChannelHAKDBase    = 40 | hdkeychain.HardenedKeyStart
 	UseChannelElkrem      = 8888 | hdkeychain.HardenedKeyStart
+	UseSwapRefund         = 50 | hdkeychain.HardenedKeyStart
+	UseSwapClaim          = 51 | hdkeychain.HardenedKeyStart
 	// links Id and channel. replaces UseChannelFund
 
 	UseIdKey = 111 | hdkeychain.HardenedKeyStart
@@ -25,12 +27,53 @@ var (
 	BKTChanMap = []byte("cmp") // map of channel index to outpoint
 	BKTWatch   = []byte("wch") // txids & signatures for export to watchtowers
 
+	BKTPayments     = []byte("pay") // outgoing payment history
+	BKTInvoices     = []byte("inv") // invoices by payment hash
+	BKTInvoiceHints = []byte("ivh") // route hints by payment hash
+	BKTInvoiceMeta  = []byte("ivm") // invoice metadata and payer data
+	BKTPayKeys      = []byte("pky") // payment results by idempotency key
+	BKTPaidHashes   = []byte("phs") // channel each paid request went through
+	BKTSwaps        = []byte("swp") // atomic swaps by hash
+
+	BKTFeePolicy = []byte("fee") // forwarding fee policy by channel index
+	BKTHTLCFails = []byte("hfl") // failed htlc history; see htlcfail.go
+	BKTTowers    = []byte("twr") // tower adverts by tower pubkey
+	BKTOrders    = []byte("ord") // merchant orders by order id
+	BKTOrderInv  = []byte("oin") // payment hash to order id
+	BKTTowerBox  = []byte("tbx") // sealed messages waiting for the tower
+	BKTLeases    = []byte("lse") // channel leases we've offered, by payment hash
+	// towers we upload to by pubkey; see towerset.go
+	BKTClientTowers = []byte("ctw")
+	// old funding outpoints of unconfirmed channels; see fundbump.go
+	BKTFundReplaced = []byte("frp")
+	// latest change to each channel, wallet output and so on, by sequence
+	// number, and the sequence number of each; see syncdelta.go
+	BKTSyncLog  = []byte("sql")
+	BKTSyncKeys = []byte("sqk")
+	// balances over time; see charts.go
+	BKTCharts = []byte("cht")
+	// force closes and fee bumps by peer index; see peerscore.go
+	BKTPeerStats = []byte("pst")
+	// pushes we've received, till claimed for an invoice; see payclaim.go
+	BKTPushesIn = []byte("pin")
+	// invoice each push we're making pays, till it's claimed
+	BKTPushesOut = []byte("pou")
+
 	KEYIdx      = []byte("idx")  // index for key derivation
 	KEYhost     = []byte("hst")  // hostname where peer lives
 	KEYnickname = []byte("nick") // nickname where peer lives
+	KEYFeatures = []byte("ftr")  // features the peer last sent
+
+	KEYutxo     = []byte("utx") // serialized utxo for the channel
+	KEYState    = []byte("now") // channel state
+	KEYElkRecv  = []byte("elk") // elkrem receiver
+	KEYqclose   = []byte("cls") // channel close outpoint & height
+	KEYStateLog = []byte("stl") // bucket of signed states; see arbitration.go
 
-	KEYutxo    = []byte("utx") // serialized utxo for the channel
-	KEYState   = []byte("now") // channel state
-	KEYElkRecv = []byte("elk") // elkrem receiver
-	KEYqclose  = []byte("cls") // channel close outpoint & height
+	KEYChanFeatures = []byte("cft") // channel features agreed at open
+	KEYShortID      = []byte("sid") // short channel id once confirmed
+	KEYFundAbort    = []byte("fab") // txid double spending the funding tx
+	// upfront shutdown scripts; see upfront.go
+	KEYMyShutdown    = []byte("msd")
+	KEYTheirShutdown = []byte("tsd")
 )
@@ -3,6 +3,7 @@ package qln
 import (
 	"fmt"
 
+	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/lnutil"
 )
 
@@ -10,6 +11,9 @@ import (
 func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) error {
 	switch msg.MsgType() & 0xf0 {
 	case 0x00: // TEXT MESSAGE.  SIMPLE
+		if msg.MsgType() == lnutil.MSGID_FEATURES {
+			return nd.FeaturesHandler(msg.(lnutil.FeaturesMsg), peer)
+		}
 		chat, ok := msg.(lnutil.ChatMsg)
 		if !ok {
 			return fmt.Errorf("can't cast to chat message")
@@ -28,7 +32,9 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 		if q == nil {
 			return fmt.Errorf("pushpull message but no matching channel")
 		}
-		return nd.PushPullHandler(msg, q)
+		return nd.chanDo(q, pushPullOpName(msg), func() error {
+			return nd.PushPullHandler(msg, q)
+		})
 
 	/* not yet implemented
 	case 0x40:
@@ -40,19 +46,46 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 	*/
 
 	case 0x60: //Tower Messages
+		// adverts are for the tower client side, not the tower
+		if msg.MsgType() == lnutil.MSGID_WATCH_ADVERT {
+			return nd.TowerAdvertHandler(msg.(lnutil.TowerAdvertMsg))
+		}
+		// as are reports, answering a TowerReport
+		if msg.MsgType() == lnutil.MSGID_WATCH_REPORT {
+			return nd.answered(msg)
+		}
 		//if !nd.Tower.Accepting {
 		//	return fmt.Errorf("Error: Got tower msg from %x but tower disabled\n",
 		//		msg.Peer())
 		//}
+		// the rest are to the tower, from the client lndc says sent them
+		var client [33]byte
+		copy(client[:], peer.Con.RemotePub.SerializeCompressed())
 		if msg.MsgType() == lnutil.MSGID_WATCH_DESC {
-			nd.Tower.NewChannel(msg.(lnutil.WatchDescMsg))
+			return nd.Tower.NewChannel(client, msg.(lnutil.WatchDescMsg))
 		}
 		if msg.MsgType() == lnutil.MSGID_WATCH_STATEMSG {
-			nd.Tower.UpdateChannel(msg.(lnutil.WatchStateMsg))
+			return nd.Tower.UpdateChannel(client, msg.(lnutil.WatchStateMsg))
 		}
 		if msg.MsgType() == lnutil.MSGID_WATCH_DELETE {
-			nd.Tower.DeleteChannel(msg.(lnutil.WatchDelMsg))
+			return nd.Tower.DeleteChannel(client, msg.(lnutil.WatchDelMsg))
+		}
+		if msg.MsgType() == lnutil.MSGID_WATCH_REPREQ {
+			return nd.TowerReportReqHandler(client, msg.(lnutil.WatchReportReqMsg))
+		}
+
+	case 0x70: // Atomic swaps
+		return nd.SwapHandler(msg)
+
+	case 0x80: // Liquidity adverts and open requests
+		return nd.LiquidityHandler(msg, peer)
+
+	case 0x90: // Application messages
+		if msg.MsgType() != lnutil.MSGID_CUSTOM {
+			return fmt.Errorf("unknown application message %x", msg.MsgType())
 		}
+		return nd.CustomMsgHandler(msg.(lnutil.CustomMsg))
+
 	default:
 		return fmt.Errorf("Unknown message id byte %x &f0", msg.MsgType())
 
@@ -60,6 +93,17 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 	return nil
 }
 
+// isCoopClose says whether a channel close tx is a cooperative one: only
+// pubkey hash outputs, nothing with a timeout
+func isCoopClose(tx *wire.MsgTx) bool {
+	for _, out := range tx.TxOut {
+		if len(out.PkScript) == 34 {
+			return false
+		}
+	}
+	return true
+}
+
 // Every lndc has one of these running
 // it listens for incoming messages on the lndc and hands it over
 // to the OmniHandler via omnichan
@@ -81,6 +125,20 @@ func (nd *LitNode) LNDCReader(peer *RemotePeer) error {
 		peer.OpMap[opArr] = q.Idx()
 	}
 
+	nd.peerUp(peer.Idx)
+
+	// tower uploads are handled apart from the rest; see streams.go
+	towerIn := make(chan lnutil.LitMsg, towerStreamDepth)
+	go nd.towerStreamer(peer, towerIn)
+	defer close(towerIn)
+
+	// finish any state updates interrupted by a crash or disconnect
+	nd.resumePending(peer)
+
+	// tell them what we can do, and about towers
+	go nd.sendFeatures(peer.Idx)
+	go nd.sendTowerAdverts(peer.Idx)
+
 	for {
 		msg := make([]byte, 65535)
 		//	fmt.Printf("read message from %x\n", l.RemoteLNId)
@@ -90,45 +148,88 @@ func (nd *LitNode) LNDCReader(peer *RemotePeer) error {
 			nd.RemoteMtx.Lock()
 			delete(nd.RemoteCons, peer.Idx)
 			nd.RemoteMtx.Unlock()
+			nd.peerDown(peer.Idx)
+			nd.failFund(peer.Idx, 0,
+				fmt.Errorf("peer %d disconnected", peer.Idx))
 			return peer.Con.Close()
 		}
 		msg = msg[:n]
 
 		fmt.Printf("decrypted message is %x\n", msg)
 
-		var routedMsg lnutil.LitMsg
-		routedMsg, err = lnutil.LitMsgFromBytes(msg, peer.Idx)
-		if err != nil {
-			return err
+		// a compressed message is several in one; see compress.go
+		msgs := [][]byte{msg}
+		if len(msg) > 0 && msg[0] == lnutil.MSGID_COMPRESSED {
+			cm, err := lnutil.NewCompressedMsgFromBytes(msg, peer.Idx)
+			if err != nil {
+				fmt.Printf("message from %d: %s\n", peer.Idx, err.Error())
+				continue
+			}
+			msgs = cm.Msgs
+		}
+		for _, m := range msgs {
+			nd.readMsg(peer, m, towerIn)
 		}
+	}
+}
 
-		fmt.Printf("peerIdx is %d\n", routedMsg.Peer())
-		fmt.Printf("routed bytes %x\n", routedMsg.Bytes())
+// readMsg handles a message from a peer, tower uploads by handing them to
+// towerIn
+func (nd *LitNode) readMsg(
+	peer *RemotePeer, msg []byte, towerIn chan lnutil.LitMsg) {
 
-		fmt.Printf("message type %x\n", routedMsg.MsgType())
+	env, err := lnutil.ParseEnvelope(msg)
+	if err != nil {
+		fmt.Printf("message from %d: %s\n", peer.Idx, err.Error())
+		return
+	}
+	msg = env.Msg
 
-		var chanIdx uint32
-		chanIdx = 0
-		if len(msg) > 38 {
-			copy(opArr[:], msg[1:37])
-			chanCheck, ok := peer.OpMap[opArr]
-			if ok {
-				chanIdx = chanCheck
-			}
-		}
+	var routedMsg lnutil.LitMsg
+	routedMsg, err = lnutil.LitMsgFromBytes(msg, peer.Idx)
+	if err != nil {
+		// probably a message from a newer lit; skip it rather than
+		// drop the peer
+		fmt.Printf("message from %d: %s\n", peer.Idx, err.Error())
+		return
+	}
 
-		fmt.Printf("chanIdx is %x\n", chanIdx)
+	fmt.Printf("peerIdx is %d\n", routedMsg.Peer())
+	fmt.Printf("routed bytes %x\n", routedMsg.Bytes())
 
-		if chanIdx != 0 {
-			err = nd.PeerHandler(routedMsg, peer.QCs[chanIdx], peer)
-		} else {
-			err = nd.PeerHandler(routedMsg, nil, peer)
-		}
+	fmt.Printf("message type %x\n", routedMsg.MsgType())
 
-		if err != nil {
-			fmt.Printf("PeerHandler error with %d: %s\n", peer.Idx, err.Error())
-		}
+	chanIdx := msgChanIdx(peer, msg)
+
+	fmt.Printf("chanIdx is %x\n", chanIdx)
+	nd.replayMsg(ReplayRecv, routedMsg, chanIdx)
+
+	if onTowerStream(routedMsg.MsgType()) {
+		towerIn <- routedMsg
+		return
+	}
+
+	if chanIdx != 0 {
+		err = nd.PeerHandler(routedMsg, peer.QCs[chanIdx], peer)
+	} else {
+		err = nd.PeerHandler(routedMsg, nil, peer)
 	}
+
+	if err != nil {
+		fmt.Printf("PeerHandler error with %d: %s\n", peer.Idx, err.Error())
+		nd.replayErr(routedMsg, chanIdx, err)
+	}
+}
+
+// msgChanIdx is the channel a raw message is about, from the outpoint
+// after its type byte, or 0 if it isn't about a known channel
+func msgChanIdx(peer *RemotePeer, msg []byte) uint32 {
+	if len(msg) <= 38 {
+		return 0
+	}
+	var opArr [36]byte
+	copy(opArr[:], msg[1:37])
+	return peer.OpMap[opArr]
 }
 
 func (nd *LitNode) PopulateQchanMap(peer *RemotePeer) error {
@@ -175,6 +276,14 @@ func (nd *LitNode) ChannelHandler(msg lnutil.LitMsg, peer *RemotePeer) error {
 		nd.SigProofHandler(message, peer)
 		return nil
 
+	case lnutil.FundReplaceMsg: // FUNDING TX REPLACED
+		fmt.Printf("Got funding replacement from %x\n", msg.Peer())
+		return nd.FundReplaceHandler(message)
+
+	case lnutil.FundAbortMsg: // FUNDING TX DOUBLE SPENT
+		fmt.Printf("Got funding abort from %x\n", msg.Peer())
+		return nd.FundAbortHandler(message)
+
 	default:
 		return fmt.Errorf("Unknown message type %x", msg.MsgType())
 	}
@@ -189,6 +298,12 @@ func (nd *LitNode) CloseHandler(msg lnutil.LitMsg) error {
 		nd.CloseReqHandler(message)
 		return nil
 
+	case lnutil.ForceCloseReqMsg:
+		return nd.ForceCloseReqHandler(message)
+
+	case lnutil.ForceCloseRespMsg:
+		return nd.answered(message)
+
 	/* - not yet implemented
 	case lnutil.MSGID_CLOSERESP: // CLOSE RESP
 		fmt.Printf("Got close response from %x\n", from)
@@ -222,6 +337,10 @@ func (nd *LitNode) PushPullHandler(routedMsg lnutil.LitMsg, q *Qchan) error {
 		fmt.Printf("Got REV from %x\n", routedMsg.Peer())
 		return nd.RevHandler(message, q)
 
+	case lnutil.PayClaimMsg: // PAYMENT CLAIM
+		fmt.Printf("Got PayClaim from %x\n", routedMsg.Peer())
+		return nd.PayClaimHandler(message, q)
+
 	default:
 		return fmt.Errorf("Unknown message type %x", routedMsg.MsgType())
 
@@ -261,8 +380,15 @@ func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 				theQ = q
 			}
 		}
+		// or the funding tx it had before a replacement confirmed
+		if theQ == nil {
+			theQ = nd.replacedFundConfirmed(curOPEvent)
+		}
 		// end if no associated channel
 		if theQ == nil {
+			if nd.swapOPEvent(curOPEvent) {
+				continue
+			}
 			fmt.Printf("OPEvent %s doesn't match any channel\n",
 				curOPEvent.Op.String())
 			continue
@@ -272,52 +398,107 @@ func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 		if curOPEvent.Tx == nil {
 			fmt.Printf("OP %s Confirmation event\n", curOPEvent.Op.String())
 			theQ.Height = curOPEvent.Height
+			if curOPEvent.Height > 0 {
+				err = nd.fundConfirmed(theQ)
+				if err != nil {
+					fmt.Printf("fundConfirmed error: %s", err.Error())
+				}
+			}
+			if curOPEvent.Height > 0 && curOPEvent.TxIdx != 0 {
+				theQ.ShortID, err = lnutil.NewShortChanID(curOPEvent.Height,
+					curOPEvent.TxIdx, curOPEvent.Op.Index)
+				if err != nil {
+					fmt.Printf("short channel id: %s\n", err.Error())
+				}
+			}
 			err = nd.SaveQchanUtxoData(theQ)
 			if err != nil {
 				fmt.Printf("SaveQchanUtxoData error: %s", err.Error())
 				continue
 			}
+			nd.PublishEvent(chanEvent(EventChanOpened, theQ))
 			// spend event (note: happens twice!)
 		} else {
 			fmt.Printf("OP %s Spend event\n", curOPEvent.Op.String())
-			// mark channel as closed
-			theQ.CloseData.Closed = true
-			theQ.CloseData.CloseTxid = curOPEvent.Tx.TxHash()
-			theQ.CloseData.CloseHeight = curOPEvent.Height
-			err = nd.SaveQchanUtxoData(theQ)
+			err = nd.chanSpent(theQ, curOPEvent.Tx, curOPEvent.Height)
 			if err != nil {
-				fmt.Printf("SaveQchanUtxoData error: %s", err.Error())
-				continue
+				fmt.Printf("chanSpent error: %s", err.Error())
 			}
+		}
+	}
+}
+
+// chanSpent marks a channel closed by tx, and grabs whatever outputs of tx
+// are ours, including revoked ones.
+func (nd *LitNode) chanSpent(theQ *Qchan, tx *wire.MsgTx, height int32) error {
+	// spend events come twice; only tell subscribers the first time
+	alreadyClosed := theQ.CloseData.Closed
+	wasConfirmed := theQ.CloseData.CloseHeight > 0
+	// mark channel as closed
+	theQ.CloseData.Closed = true
+	theQ.CloseData.CloseTxid = tx.TxHash()
+	theQ.CloseData.CloseHeight = height
+	err := nd.SaveQchanUtxoData(theQ)
+	if err != nil {
+		return err
+	}
 
-			// detect close tx outs.
-			txos, err := theQ.GetCloseTxos(curOPEvent.Tx)
+	if !alreadyClosed {
+		ev := chanEvent(EventChanClosed, theQ)
+		ev.Txid = theQ.CloseData.CloseTxid.String()
+		nd.PublishEvent(ev)
+		if !isCoopClose(tx) {
+			ev.Type = EventForceClose
+			nd.PublishEvent(ev)
+			// our own breaks are marked closed before they go out, so
+			// this one's the peer's
+			nd.notePeerBreak(theQ)
+		}
+	}
+
+	// a coop close has no script hash outputs; once it's in a block the
+	// tower doesn't need the channel any more
+	if height > 0 && !wasConfirmed && isCoopClose(tx) {
+		go func() {
+			err := nd.UnwatchChannel(theQ)
 			if err != nil {
-				fmt.Printf("GetCloseTxos error: %s", err.Error())
-				continue
+				logger.Warnf("unwatch channel %d: %s\n", theQ.Idx(), err.Error())
 			}
-			// if you have seq=1 txos, modify the privkey...
-			// pretty ugly as we need the private key to do that.
-			for _, portxo := range txos {
-				if portxo.Seq == 1 { // revoked key
-					// GetCloseTxos returns a porTxo with the elk scalar in the
-					// privkey field.  It isn't just added though; it needs to
-					// be combined with the private key in a way porTxo isn't
-					// aware of, so derive and subtract that here.
-					var elkScalar [32]byte
-					// swap out elkscalar, leaving privkey empty
-					elkScalar, portxo.KeyGen.PrivKey =
-						portxo.KeyGen.PrivKey, elkScalar
-
-					// TODO make sure this doesn't crash on nil wallet
-					privBase := nd.SubWallet[theQ.Coin()].GetPriv(portxo.KeyGen)
-
-					portxo.PrivKey = lnutil.CombinePrivKeyAndSubtract(
-						privBase, elkScalar[:])
-				}
-				// make this concurrent to avoid circular locking
-				go nd.SubWallet[theQ.Coin()].ExportUtxo(&portxo)
+		}()
+	}
+
+	// detect close tx outs.
+	txos, err := theQ.GetCloseTxos(tx)
+	if err != nil {
+		return err
+	}
+	// if you have seq=1 txos, modify the privkey...
+	// pretty ugly as we need the private key to do that.
+	for _, portxo := range txos {
+		if portxo.Seq == 1 { // revoked key
+			if !alreadyClosed {
+				ev := chanEvent(EventBreach, theQ)
+				ev.Txid = theQ.CloseData.CloseTxid.String()
+				ev.Amt = portxo.Value
+				nd.PublishEvent(ev)
 			}
+			// GetCloseTxos returns a porTxo with the elk scalar in the
+			// privkey field.  It isn't just added though; it needs to
+			// be combined with the private key in a way porTxo isn't
+			// aware of, so derive and subtract that here.
+			var elkScalar [32]byte
+			// swap out elkscalar, leaving privkey empty
+			elkScalar, portxo.KeyGen.PrivKey =
+				portxo.KeyGen.PrivKey, elkScalar
+
+			// TODO make sure this doesn't crash on nil wallet
+			privBase := nd.SubWallet[theQ.Coin()].GetPriv(portxo.KeyGen)
+
+			portxo.PrivKey = lnutil.CombinePrivKeyAndSubtract(
+				privBase, elkScalar[:])
 		}
+		// make this concurrent to avoid circular locking
+		go nd.SubWallet[theQ.Coin()].ExportUtxo(&portxo)
 	}
+	return nil
 }
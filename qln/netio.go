@@ -2,7 +2,6 @@ package qln
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/mit-dci/lit/lndc"
@@ -43,7 +42,18 @@ func (nd *LitNode) TCPListener(
 
 	err = Announce(idPriv, lisIpPort, adr, nd.TrackerURL)
 	if err != nil {
-		log.Printf("Announcement error %s", err.Error())
+		logger.Errorf("Announcement error %s", err.Error())
+	} else {
+		// remember what the tracker has for us, to notice if someone
+		// else announces the same identity later
+		var url string
+		url, err = Lookup(adr, nd.TrackerURL)
+		if err != nil {
+			logger.Warnf("Lookup after announce error %s", err.Error())
+		}
+		nd.announcedMtx.Lock()
+		nd.announcedURL = url
+		nd.announcedMtx.Unlock()
 	}
 
 	fmt.Printf("Listening on %s\n", listener.Addr().String())
@@ -52,8 +62,11 @@ func (nd *LitNode) TCPListener(
 	go func() {
 		for {
 			netConn, err := listener.Accept() // this blocks
+			if nd.ShuttingDown() {
+				return
+			}
 			if err != nil {
-				log.Printf("Listener error: %s\n", err.Error())
+				logger.Errorf("Listener error: %s\n", err.Error())
 				continue
 			}
 			newConn, ok := netConn.(*lndc.LNDConn)
@@ -67,7 +80,7 @@ func (nd *LitNode) TCPListener(
 			// don't save host/port for incomming connections
 			peerIdx, err := nd.GetPeerIdx(newConn.RemotePub, "")
 			if err != nil {
-				log.Printf("Listener error: %s\n", err.Error())
+				logger.Errorf("Listener error: %s\n", err.Error())
 				continue
 			}
 
@@ -87,6 +100,7 @@ func (nd *LitNode) TCPListener(
 	}()
 	nd.RemoteMtx.Lock()
 	nd.LisIpPorts = append(nd.LisIpPorts, lisIpPort)
+	nd.listeners = append(nd.listeners, listener)
 	nd.RemoteMtx.Unlock()
 	return adr, nil
 }
@@ -150,25 +164,42 @@ func (nd *LitNode) DialPeer(connectAdr string) error {
 }
 
 // OutMessager takes messages from the outbox and sends them to the ether. net.
+// Channel messages go before tower uploads; see streams.go.
 func (nd *LitNode) OutMessager() {
 	for {
-		msg := <-nd.OmniOut
+		msg, done := nd.nextOutMsg()
 		if !nd.ConnectedToPeer(msg.Peer()) {
 			fmt.Printf("message type %x to peer %d but not connected\n",
 				msg.MsgType(), msg.Peer())
+			if done != nil {
+				done <- fmt.Errorf("not connected to peer %d", msg.Peer())
+			}
 			continue
 		}
 
 		//rawmsg := append([]byte{msg.MsgType()}, msg.Data...)
 		rawmsg := msg.Bytes() // automatically includes messageType
 		nd.RemoteMtx.Lock()   // not sure this is needed...
-		n, err := nd.RemoteCons[msg.Peer()].Con.Write(rawmsg)
+		peer := nd.RemoteCons[msg.Peer()]
+		out := rawmsg
+		// envelopes for peers that said they take them.  The features
+		// message, which says so, is never wrapped, and compressed ones
+		// have theirs inside.
+		if v := peerMsgVersion(peer); v > 0 && msg.MsgType() != lnutil.MSGID_FEATURES &&
+			msg.MsgType() != lnutil.MSGID_COMPRESSED {
+			out = lnutil.WrapMsg(msg, v, nil)
+		}
+		n, err := peer.Con.Write(out)
 		if err != nil {
 			fmt.Printf("error writing to peer %d: %s\n", msg.Peer(), err.Error())
 		} else {
 			fmt.Printf("type %x %d bytes to peer %d\n", msg.MsgType(), n, msg.Peer())
+			nd.replayMsg(ReplaySend, msg, msgChanIdx(peer, rawmsg))
 		}
 		nd.RemoteMtx.Unlock()
+		if done != nil {
+			done <- err
+		}
 	}
 }
 
@@ -176,6 +207,13 @@ type PeerInfo struct {
 	PeerNumber uint32
 	RemoteHost string
 	Nickname   string
+	Features   string // what the peer said it can do
+	// what its channels can send and receive, and what it'll open on
+	// request, from its latest liquidity advert; see liquidity.go
+	Liquidity      []lnutil.LiquidityCoin
+	OpensOnRequest bool
+	// channel leases it sells; see leases.go
+	LeaseOffers []lnutil.LeaseTier
 }
 
 func (nd *LitNode) GetConnectedPeerList() []PeerInfo {
@@ -187,6 +225,13 @@ func (nd *LitNode) GetConnectedPeerList() []PeerInfo {
 		newPeer.PeerNumber = k
 		newPeer.RemoteHost = v.Con.RemoteAddr().String()
 		newPeer.Nickname = v.Nickname
+		newPeer.Features = v.Features.String()
+		if v.Liquidity != nil {
+			newPeer.Liquidity = v.Liquidity.Coins
+			newPeer.OpensOnRequest =
+				v.Liquidity.Flags&lnutil.LiqFlagOpensOnRequest != 0
+		}
+		newPeer.LeaseOffers = v.LeaseOffers
 		peers = append(peers, newPeer)
 	}
 	return peers
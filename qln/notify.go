@@ -0,0 +1,112 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/notify"
+)
+
+/*
+Notifications send the events an operator has to act on out of band,
+through the transports in the notify package: a breach, a force close, a
+peer with channels away over a day, the tower not taking what's queued
+for it, a tx lit sent being evicted or double spent, a wallet's heir tx
+nearing its lock height without a check in (see alerts.go), a channel op
+that isn't finishing (see chanqueue.go), and a standby tower taking over
+from one that's gone (see towerset.go).  Other events go to webhooks
+only.
+
+Sends that fail are retried with backoff like webhooks, then dropped and
+logged.
+*/
+
+const (
+	notifyMaxTries  = 5
+	notifyFirstWait = 5 * time.Second
+)
+
+// events that go out as notifications, and what they're called
+var notifyEvents = map[string]string{
+	EventBreach:           "breach detected",
+	EventForceClose:       "channel force closed",
+	EventPeerOffline:      "peer offline",
+	EventTowerUnreachable: "tower unreachable",
+	EventTxEvicted:        "tx dropped from mempool",
+	EventTxDoubleSpent:    "tx double spent",
+	EventHeirDue:          "heir check in due",
+	EventChanStuck:        "channel stuck",
+	EventTowerFailover:    "tower failed over",
+}
+
+// StartNotify sends alerts through the given transports.  Does nothing if
+// there are none.
+func (nd *LitNode) StartNotify(ts []notify.Transport) {
+	if len(ts) == 0 {
+		return
+	}
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	litAdr := lnutil.LitAdrFromPubkey(idPub)
+	for _, t := range ts {
+		logger.Infof("notifying %s\n", t.String())
+	}
+
+	sub := nd.SubscribeEvents()
+	go func() {
+		for ev := range sub {
+			what, ok := notifyEvents[ev.Type]
+			if !ok {
+				continue
+			}
+			subject, body := notifyMessage(litAdr, what, ev)
+			for _, t := range ts {
+				go sendNotify(t, subject, body)
+			}
+		}
+	}()
+}
+
+// notifyMessage says what happened, for people
+func notifyMessage(litAdr, what string, ev NodeEvent) (string, string) {
+	subject := "lit: " + what
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s on %s at %s\n", what, litAdr,
+		time.Unix(ev.Time, 0).UTC().Format(time.RFC1123))
+	if ev.ChanIdx != 0 {
+		subject += fmt.Sprintf(" on channel %d", ev.ChanIdx)
+		fmt.Fprintf(&b, "channel %d (%s), coin %d\n",
+			ev.ChanIdx, ev.OutPoint, ev.CoinType)
+	}
+	if ev.PeerIdx != 0 {
+		fmt.Fprintf(&b, "peer %d\n", ev.PeerIdx)
+	}
+	if ev.Txid != "" {
+		fmt.Fprintf(&b, "tx %s\n", ev.Txid)
+	}
+	if ev.Type == EventBreach {
+		fmt.Fprintf(&b, "revoked output of %d sat being taken back\n", ev.Amt)
+	}
+	if ev.Detail != "" {
+		fmt.Fprintf(&b, "%s\n", ev.Detail)
+	}
+	return subject, b.String()
+}
+
+// sendNotify sends one alert through one transport, retrying on failure
+func sendNotify(t notify.Transport, subject, body string) {
+	wait := notifyFirstWait
+	for try := 1; try <= notifyMaxTries; try++ {
+		err := t.Send(subject, body)
+		if err == nil {
+			return
+		}
+		logger.Warnf("notify %s try %d/%d failed: %s\n",
+			t.String(), try, notifyMaxTries, err.Error())
+		time.Sleep(wait)
+		wait *= 2
+	}
+	logger.Errorf("notify %s giving up on %s\n", t.String(), subject)
+}
@@ -0,0 +1,455 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Orders are for merchants: something a customer owes for, named by the
+merchant's own order id, which stays payable until it's paid, cancelled or
+it closes.  An order always has one live invoice.  Invoices are kept short
+(so a fiat price doesn't go stale) and when one expires while the order
+is still open, OrderWatcher makes a new one, re-priced if the order is in
+fiat.  Every invoice an order had is kept with it.
+
+When any of an order's invoices is settled by a pay claim (payclaim.go),
+the claim handler marks the order paid and an EventOrderPaid event goes
+out; OrderWatcher cleans up after a crash in between.  The event is
+published only by whoever moves the order from open to paid, in the same
+db transaction, so each order id is reported paid exactly once however
+many times the settlement is noticed.  Payment wins over cancel or close:
+an invoice still live when the order was cancelled can still be paid, and
+then the order is paid.
+
+Fiat amounts are in hundredths (cents) of Currency, and need FiatRate.
+
+Orders are in BKTOrders by order id, and BKTOrderInv maps each payment
+hash to its order id.
+*/
+
+const (
+	OrderOpen      = 0
+	OrderPaid      = 1
+	OrderExpired   = 2
+	OrderCancelled = 3
+
+	defaultOrderLifetime      = 24 * 3600 // seconds
+	defaultOrderInvoiceExpiry = 15 * 60
+
+	orderCheckInterval = 15 * time.Second
+)
+
+// Order is a merchant order
+type Order struct {
+	ID          string
+	Amt         int64 // sat amount, or of the current invoice for fiat orders
+	FiatAmt     int64 // hundredths of Currency; 0 for sat orders
+	Currency    string
+	Description string
+
+	Created       int64 // unix time
+	ClosesAt      int64 // unix time; no new invoices after this
+	InvoiceExpiry int64 // seconds each invoice lasts
+
+	Status    uint8
+	Invoices  [][32]byte // payment hashes, newest last
+	AmtPaid   int64
+	PaidHash  [32]byte
+	SettledAt int64
+}
+
+// StatusString is the order status as a word
+func (o *Order) StatusString() string {
+	switch o.Status {
+	case OrderOpen:
+		return "open"
+	case OrderPaid:
+		return "paid"
+	case OrderExpired:
+		return "expired"
+	case OrderCancelled:
+		return "cancelled"
+	}
+	return fmt.Sprintf("unknown %d", o.Status)
+}
+
+// CurrentInvoice is the payment hash of the newest invoice
+func (o *Order) CurrentInvoice() [32]byte {
+	var h [32]byte
+	if len(o.Invoices) > 0 {
+		h = o.Invoices[len(o.Invoices)-1]
+	}
+	return h
+}
+
+// ToBytes serializes an order.  The id and currency with 1 byte lengths,
+// fixed fields, the invoice hashes with a 4 byte count, then the
+// description to the end.
+func (o *Order) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(uint8(len(o.ID)))
+	buf.WriteString(o.ID)
+	buf.WriteByte(uint8(len(o.Currency)))
+	buf.WriteString(o.Currency)
+	binary.Write(&buf, binary.BigEndian, o.Amt)
+	binary.Write(&buf, binary.BigEndian, o.FiatAmt)
+	binary.Write(&buf, binary.BigEndian, o.Created)
+	binary.Write(&buf, binary.BigEndian, o.ClosesAt)
+	binary.Write(&buf, binary.BigEndian, o.InvoiceExpiry)
+	buf.WriteByte(o.Status)
+	binary.Write(&buf, binary.BigEndian, o.AmtPaid)
+	binary.Write(&buf, binary.BigEndian, o.SettledAt)
+	buf.Write(o.PaidHash[:])
+	binary.Write(&buf, binary.BigEndian, uint32(len(o.Invoices)))
+	for _, h := range o.Invoices {
+		buf.Write(h[:])
+	}
+	buf.WriteString(o.Description)
+	return buf.Bytes()
+}
+
+// OrderFromBytes deserializes an order
+func OrderFromBytes(b []byte) (*Order, error) {
+	o := new(Order)
+	buf := bytes.NewBuffer(b)
+	for _, s := range []*string{&o.ID, &o.Currency} {
+		if buf.Len() < 1 {
+			return nil, fmt.Errorf("order truncated")
+		}
+		n, _ := buf.ReadByte()
+		if buf.Len() < int(n) {
+			return nil, fmt.Errorf("order truncated")
+		}
+		*s = string(buf.Next(int(n)))
+	}
+	// 5 int64s, status, 2 int64s, paid hash, count
+	if buf.Len() < 40+1+16+32+4 {
+		return nil, fmt.Errorf("order %s truncated", o.ID)
+	}
+	binary.Read(buf, binary.BigEndian, &o.Amt)
+	binary.Read(buf, binary.BigEndian, &o.FiatAmt)
+	binary.Read(buf, binary.BigEndian, &o.Created)
+	binary.Read(buf, binary.BigEndian, &o.ClosesAt)
+	binary.Read(buf, binary.BigEndian, &o.InvoiceExpiry)
+	o.Status, _ = buf.ReadByte()
+	binary.Read(buf, binary.BigEndian, &o.AmtPaid)
+	binary.Read(buf, binary.BigEndian, &o.SettledAt)
+	copy(o.PaidHash[:], buf.Next(32))
+	var n uint32
+	binary.Read(buf, binary.BigEndian, &n)
+	if uint32(buf.Len()) < n*32 {
+		return nil, fmt.Errorf("order %s has %d invoices, not enough bytes", o.ID, n)
+	}
+	o.Invoices = make([][32]byte, n)
+	for i := range o.Invoices {
+		copy(o.Invoices[i][:], buf.Next(32))
+	}
+	o.Description = buf.String()
+	return o, nil
+}
+
+// CreateOrder makes an order and its first invoice.  Give either amt in
+// satoshis, or fiatAmt in hundredths of currency.  lifetime and invExpiry
+// of 0 give the defaults.  Creating an order id that already exists with
+// the same amount returns the existing order, so merchants can retry.
+func (nd *LitNode) CreateOrder(id string, amt, fiatAmt int64, currency,
+	desc string, lifetime, invExpiry int64) (*Order, error) {
+
+	if id == "" || len(id) > 255 {
+		return nil, fmt.Errorf("order id must be 1 to 255 bytes")
+	}
+	if (amt > 0) == (fiatAmt > 0) || amt < 0 || fiatAmt < 0 {
+		return nil, fmt.Errorf("need one of a sat or a fiat amount")
+	}
+	if fiatAmt > 0 && (currency == "" || len(currency) > 255) {
+		return nil, fmt.Errorf("fiat amount needs a currency")
+	}
+	if lifetime < 0 || invExpiry < 0 {
+		return nil, fmt.Errorf("negative lifetime or expiry")
+	}
+	if lifetime == 0 {
+		lifetime = defaultOrderLifetime
+	}
+	if invExpiry == 0 {
+		invExpiry = defaultOrderInvoiceExpiry
+	}
+
+	old, err := nd.GetOrder(id)
+	if err == nil {
+		if old.FiatAmt != fiatAmt || old.Currency != currency ||
+			(fiatAmt == 0 && old.Amt != amt) {
+			return nil, fmt.Errorf("order %s exists with a different amount", id)
+		}
+		return old, nil
+	}
+
+	o := new(Order)
+	o.ID = id
+	o.Amt = amt
+	o.FiatAmt = fiatAmt
+	if fiatAmt > 0 {
+		o.Currency = currency
+	}
+	o.Description = desc
+	o.Created = time.Now().Unix()
+	o.ClosesAt = o.Created + lifetime
+	o.InvoiceExpiry = invExpiry
+
+	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+		ob := btx.Bucket(BKTOrders)
+		if ob == nil {
+			return fmt.Errorf("no order bucket")
+		}
+		if ob.Get([]byte(id)) != nil {
+			return fmt.Errorf("order %s created twice at once", id)
+		}
+		return ob.Put([]byte(id), o.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nd.reissueOrder(o)
+}
+
+// orderSats is how much to ask for now
+func (nd *LitNode) orderSats(o *Order) (int64, error) {
+	if o.FiatAmt == 0 {
+		return o.Amt, nil
+	}
+	// rounds up; the merchant shouldn't lose the fraction
+	return nd.FiatToSats(o.FiatAmt, o.Currency)
+}
+
+// reissueOrder gives an open order a new invoice, or closes it if it's
+// past ClosesAt.  Returns the order as saved.
+func (nd *LitNode) reissueOrder(o *Order) (*Order, error) {
+	now := time.Now().Unix()
+	expiry := o.InvoiceExpiry
+	if o.ClosesAt-now < expiry {
+		expiry = o.ClosesAt - now
+	}
+	if expiry <= 0 {
+		return nd.updateOrder(o.ID, func(o *Order) bool {
+			if o.Status != OrderOpen {
+				return false
+			}
+			o.Status = OrderExpired
+			logger.Infof("order %s closed unpaid\n", o.ID)
+			return true
+		})
+	}
+	sats, err := nd.orderSats(o)
+	if err != nil {
+		return nil, err
+	}
+	// the invoice is saved first; if the order changed meanwhile it's
+	// just never handed out
+	inv, err := nd.AddInvoice(sats, o.Description, nil, expiry,
+		map[string]string{"order_id": o.ID})
+	if err != nil {
+		return nil, err
+	}
+	prev := o.CurrentInvoice()
+	return nd.updateOrder(o.ID, func(o *Order) bool {
+		if o.Status != OrderOpen || o.CurrentInvoice() != prev {
+			return false
+		}
+		o.Amt = sats
+		o.Invoices = append(o.Invoices, inv.PaymentHash)
+		return true
+	}, inv.PaymentHash)
+}
+
+// updateOrder reads an order, lets change modify it, and saves it if
+// change says it did, all in one transaction.  Payment hashes in newHashes
+// are indexed to the order.
+func (nd *LitNode) updateOrder(id string, change func(*Order) bool,
+	newHashes ...[32]byte) (*Order, error) {
+
+	var o *Order
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		ob := btx.Bucket(BKTOrders)
+		oi := btx.Bucket(BKTOrderInv)
+		if ob == nil || oi == nil {
+			return fmt.Errorf("no order buckets")
+		}
+		b := ob.Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("no order %s", id)
+		}
+		var err error
+		o, err = OrderFromBytes(b)
+		if err != nil {
+			return err
+		}
+		if !change(o) {
+			return nil
+		}
+		for _, h := range newHashes {
+			err = oi.Put(h[:], []byte(id))
+			if err != nil {
+				return err
+			}
+		}
+		return ob.Put([]byte(id), o.ToBytes())
+	})
+	return o, err
+}
+
+// GetOrder looks up an order by id
+func (nd *LitNode) GetOrder(id string) (*Order, error) {
+	var o *Order
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		ob := btx.Bucket(BKTOrders)
+		if ob == nil {
+			return fmt.Errorf("no order bucket")
+		}
+		b := ob.Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("no order %s", id)
+		}
+		var err error
+		o, err = OrderFromBytes(b)
+		return err
+	})
+	return o, err
+}
+
+// ListOrders returns all orders, or only open ones
+func (nd *LitNode) ListOrders(openOnly bool) ([]*Order, error) {
+	var orders []*Order
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		ob := btx.Bucket(BKTOrders)
+		if ob == nil {
+			return fmt.Errorf("no order bucket")
+		}
+		return ob.ForEach(func(k, v []byte) error {
+			o, err := OrderFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if openOnly && o.Status != OrderOpen {
+				return nil
+			}
+			orders = append(orders, o)
+			return nil
+		})
+	})
+	return orders, err
+}
+
+// CancelOrder stops an open order getting new invoices
+func (nd *LitNode) CancelOrder(id string) (*Order, error) {
+	var wasOpen bool
+	o, err := nd.updateOrder(id, func(o *Order) bool {
+		wasOpen = o.Status == OrderOpen
+		if wasOpen {
+			o.Status = OrderCancelled
+		}
+		return wasOpen
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !wasOpen {
+		return o, fmt.Errorf("order %s is %s, not open", id, o.StatusString())
+	}
+	return o, nil
+}
+
+// orderInvoiceSettled marks the order an invoice is for paid, and reports
+// it, if it isn't already
+func (nd *LitNode) orderInvoiceSettled(hash [32]byte) error {
+	var id string
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		oi := btx.Bucket(BKTOrderInv)
+		if oi == nil {
+			return fmt.Errorf("no order index bucket")
+		}
+		id = string(oi.Get(hash[:]))
+		return nil
+	})
+	if err != nil || id == "" {
+		return err // not an order's invoice
+	}
+	inv, err := nd.GetInvoice(hash)
+	if err != nil {
+		return err
+	}
+	if !inv.Settled {
+		return nil
+	}
+
+	var nowPaid bool
+	o, err := nd.updateOrder(id, func(o *Order) bool {
+		if o.Status == OrderPaid {
+			if o.PaidHash != hash {
+				logger.Warnf("order %s paid twice, second %d sat invoice %x\n",
+					o.ID, inv.AmtPaid, hash)
+			}
+			return false
+		}
+		o.Status = OrderPaid
+		o.AmtPaid = inv.AmtPaid
+		o.PaidHash = hash
+		o.SettledAt = inv.SettledAt
+		nowPaid = true
+		return true
+	})
+	if err != nil || !nowPaid {
+		return err
+	}
+	logger.Infof("order %s paid %d sat\n", o.ID, o.AmtPaid)
+	var ev NodeEvent
+	ev.Type = EventOrderPaid
+	ev.Amt = o.AmtPaid
+	ev.PaymentHash = hex.EncodeToString(hash[:])
+	ev.OrderID = o.ID
+	nd.PublishEvent(ev)
+	return nil
+}
+
+// OrderWatcher reissues expired invoices of open orders, and marks orders
+// paid whose settlement wasn't seen through to them
+func (nd *LitNode) OrderWatcher() {
+	for !nd.ShuttingDown() {
+		time.Sleep(orderCheckInterval)
+		nd.checkOrders()
+	}
+}
+
+// checkOrders catches settled invoices of orders not marked paid, and
+// gives open orders with an expired invoice a new one
+func (nd *LitNode) checkOrders() {
+	orders, err := nd.ListOrders(false)
+	if err != nil {
+		logger.Errorf("checkOrders %s\n", err.Error())
+		return
+	}
+	for _, o := range orders {
+		if o.Status == OrderPaid {
+			continue
+		}
+		// a cancelled or closed order's last invoice can still be paid
+		inv, err := nd.GetInvoice(o.CurrentInvoice())
+		if err == nil && inv.Settled {
+			err = nd.orderInvoiceSettled(inv.PaymentHash)
+		} else if o.Status != OrderOpen {
+			continue
+		} else if err != nil {
+			// never got its first invoice (eg no fiat rate); try again
+			_, err = nd.reissueOrder(o)
+		} else if inv.Expired() {
+			_, err = nd.reissueOrder(o)
+		}
+		if err != nil {
+			logger.Warnf("order %s: %s\n", o.ID, err.Error())
+		}
+	}
+}
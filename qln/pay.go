@@ -0,0 +1,175 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Paying a payment request means pushing the amount across a channel we have
+with the payee.  There's no routing, so we have to be connected to them
+directly with an open channel that has enough in it.
+
+Pushes don't carry the payment hash, so once the push is done a PayClaimMsg
+tells the payee which invoice it was for, and the payee settles it (see
+payclaim.go).  A push that fails may still go out, if its delta was saved
+(see PushInDoubt); then the request stays claimed, and is paid when it does.
+
+If the request has a route hint for one of our channels with the payee,
+that channel is tried first.
+
+A request is only paid once; see payonce.go.  Payments can also be queued
+to go in the background, trying other channels if one fails; see
+payqueue.go.
+*/
+
+// PayInvoice pays a payment request.  amt is only used if the request is
+// for any amount; otherwise it must be 0 or match.  Returns the channel
+// index it was paid through.
+func (nd *LitNode) PayInvoice(payReq string, amt int64) (uint32, error) {
+	pr, amt, err := checkPayReq(payReq, amt)
+	if err != nil {
+		return 0, err
+	}
+	cIdx, _, err := nd.payVia(pr, amt, nil)
+	return cIdx, err
+}
+
+// checkPayReq decodes a payment request and works out the amount to pay
+func checkPayReq(payReq string, amt int64) (*PayReq, int64, error) {
+	pr, err := DecodePayReq(payReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	if time.Now().Unix() > pr.ExpiresAt {
+		return nil, 0, fmt.Errorf("payment request expired")
+	}
+	if pr.Amt != 0 {
+		if amt != 0 && amt != pr.Amt {
+			return nil, 0, fmt.Errorf("request is for %d, not %d", pr.Amt, amt)
+		}
+		amt = pr.Amt
+	}
+	if amt < 1 {
+		return nil, 0, fmt.Errorf("need an amount to pay")
+	}
+	return pr, amt, nil
+}
+
+// payVia pays a decoded request through any channel with the payee but
+// those in skip.  Returns the channel tried, if it got that far, and if
+// trying again could work: false once it may have been paid, or can't be.
+// The claim goes when the push is done; see claimPushOut.
+func (nd *LitNode) payVia(
+	pr *PayReq, amt int64, skip map[uint32]bool) (uint32, bool, error) {
+
+	qc, err := nd.channelToPay(pr.LitAdr, amt, nd.hintedChannels(pr.Hints), skip)
+	if err != nil {
+		return 0, true, err
+	}
+
+	// claimed before pushing, so the same request paid twice at once fails
+	err = nd.claimPaymentHash(pr.PaymentHash, qc.Idx())
+	if err != nil {
+		return 0, false, err
+	}
+
+	// a channel can't take another while a push paying a request is on it
+	err = nd.setPushOut(qc, pr.PaymentHash, amt)
+	if err != nil {
+		uerr := nd.unclaimPaymentHash(pr.PaymentHash)
+		if uerr != nil {
+			logger.Errorf("unclaimPaymentHash error %s\n", uerr.Error())
+		}
+		return qc.Idx(), true, err
+	}
+
+	var rec PaymentRecord
+	rec.PeerIdx = qc.Peer()
+	rec.Amt = amt
+	rec.Route = []uint32{qc.Idx()}
+
+	retry := false
+	err = nd.PushChannel(qc, uint32(amt))
+	if err != nil {
+		// once the delta's saved the push goes out when the peer's back,
+		// so it's only safe to try elsewhere, or pay again, if it wasn't
+		retry = !PushInDoubt(err)
+		rec.Code = PushFailCode(err)
+		rec.Err = err.Error()
+		err = &HTLCError{Code: rec.Code, Err: err}
+		ferr := nd.RecordHTLCFailure(HTLCFailRecord{ChanIdx: qc.Idx(),
+			PeerIdx: qc.Peer(), Hash: pr.PaymentHash, Amt: amt,
+			Code: rec.Code, Err: rec.Err})
+		if ferr != nil {
+			logger.Errorf("RecordHTLCFailure error %s\n", ferr.Error())
+		}
+		if retry {
+			uerr := nd.dropPushOut(qc)
+			if uerr != nil {
+				logger.Errorf("dropPushOut error %s\n", uerr.Error())
+			}
+			uerr = nd.unclaimPaymentHash(pr.PaymentHash)
+			if uerr != nil {
+				logger.Errorf("unclaimPaymentHash error %s\n", uerr.Error())
+			}
+		}
+	} else {
+		rec.OK = true
+		rec.StateIdx = qc.State.StateIdx
+	}
+	herr := nd.RecordPayment(rec)
+	if herr != nil {
+		logger.Errorf("RecordPayment error %s\n", herr.Error())
+	}
+	return qc.Idx(), retry, err
+}
+
+// channelToPay finds an open channel with a connected peer whose ln address
+// is litAdr, which can push amt.  Channels in hinted go first; ones in
+// skip aren't used.
+func (nd *LitNode) channelToPay(litAdr string, amt int64,
+	hinted, skip map[uint32]bool) (*Qchan, error) {
+	var peer *RemotePeer
+	nd.RemoteMtx.Lock()
+	for _, p := range nd.RemoteCons {
+		var pub [33]byte
+		copy(pub[:], p.Con.RemotePub.SerializeCompressed())
+		if lnutil.LitAdrFromPubkey(pub) == litAdr {
+			peer = p
+			break
+		}
+	}
+	nd.RemoteMtx.Unlock()
+	if peer == nil {
+		return nil, htlcErr(FailUnknownNextPeer, "not connected to %s", litAdr)
+	}
+
+	var qcs []*Qchan
+	for _, qc := range peer.QCs {
+		if hinted[qc.Idx()] {
+			qcs = append([]*Qchan{qc}, qcs...)
+		} else {
+			qcs = append(qcs, qc)
+		}
+	}
+	for _, qc := range qcs {
+		if qc.CloseData.Closed || skip[qc.Idx()] {
+			continue
+		}
+		if qc.State.MyAmt-qc.LocalReserve() < amt {
+			continue
+		}
+		// height isn't kept in ram; get it from the db like the push rpc does
+		dbqc, err := nd.GetQchanByIdx(qc.Idx())
+		if err != nil {
+			return nil, err
+		}
+		qc.Height = dbqc.Height
+		return qc, nil
+	}
+	return nil, htlcErr(FailTemporaryChannel,
+		"no channel with %s can send %d", litAdr, amt)
+}
@@ -0,0 +1,189 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Pushes don't carry a payment hash, so once a push paying an invoice has
+gone through, the payer sends a PayClaimMsg with the channel, the amount
+and the payment hash.  It's a push/pull message, so it goes in the
+channel's op queue behind the REV that finished the push.
+
+The payer keeps the invoice a push is for in BKTPushesOut, by channel
+outpoint, from before the push starts.  Only one push at a time goes on a
+channel, so the claim goes from the handler that finishes it, SigRevHandler
+or GapSigRevHandler, however long after it was started: a push whose delta
+was saved before lit stopped or the peer went away is finished when they
+come back, and its claim goes then.
+
+Every push we receive is kept in BKTPushesIn, keyed by channel outpoint and
+the time it finished, until it's claimed or pushClaimWindow has passed.  A
+claim takes the oldest unclaimed push on its channel of exactly its amount
+and settles the invoice with it, both in one db transaction, so a push pays
+at most one invoice and an invoice is only paid once.  A claim that doesn't
+match a push, or whose invoice can't be settled, is refused and logged, and
+the push is left for another claim.
+
+A payer can only claim with pushes it made, so the worst it can do is pay
+an invoice with coins it pushed for something else.  If it goes away
+between the push and the claim the coins have moved but the invoice stays
+unpaid.
+*/
+
+// how long a push we've received can be claimed for an invoice
+const pushClaimWindow = 24 * time.Hour
+
+// recordPushIn keeps a push we've received for a claim, and drops those
+// too old to claim
+func (nd *LitNode) recordPushIn(q *Qchan, amt int64) error {
+	now := time.Now().UnixNano()
+	opArr := lnutil.OutPointToBytes(q.Op)
+	key := append(opArr[:], lnutil.I64tB(now)...)
+
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		pb := btx.Bucket(BKTPushesIn)
+		if pb == nil {
+			return fmt.Errorf("no incoming push bucket")
+		}
+		cutoff := now - int64(pushClaimWindow)
+		var old [][]byte
+		err := pb.ForEach(func(k, v []byte) error {
+			if len(k) != 44 || lnutil.BtI64(k[36:]) < cutoff {
+				old = append(old, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// can't Delete while in ForEach
+		for _, k := range old {
+			err = pb.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return pb.Put(key, lnutil.I64tB(amt))
+	})
+}
+
+// claimPushIn uses up the oldest unclaimed push of amt on a channel
+func claimPushIn(btx *bolt.Tx, opArr [36]byte, amt int64) error {
+	pb := btx.Bucket(BKTPushesIn)
+	if pb == nil {
+		return fmt.Errorf("no incoming push bucket")
+	}
+	cutoff := time.Now().Add(-pushClaimWindow).UnixNano()
+	cur := pb.Cursor()
+	prefix := opArr[:]
+	for k, v := cur.Seek(prefix); bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		if len(k) != 44 || len(v) != 8 || lnutil.BtI64(k[36:]) < cutoff {
+			continue
+		}
+		if lnutil.BtI64(v) == amt {
+			return cur.Delete()
+		}
+	}
+	return fmt.Errorf("no unclaimed push of %d", amt)
+}
+
+// PayClaimHandler settles the invoice a push we've received was for
+func (nd *LitNode) PayClaimHandler(msg lnutil.PayClaimMsg, q *Qchan) error {
+	var inv *Invoice
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		err := claimPushIn(btx, lnutil.OutPointToBytes(q.Op), msg.Amt)
+		if err != nil {
+			return err
+		}
+		inv, err = nd.settleInvoice(btx, msg.PaymentHash, msg.Amt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("chan %d claim for invoice %x: %s",
+			q.Idx(), msg.PaymentHash, err.Error())
+	}
+	logger.Infof("invoice %x paid %d through channel %d\n",
+		inv.PaymentHash, inv.AmtPaid, q.Idx())
+
+	ev := chanEvent(EventInvoiceSettled, q)
+	ev.Amt = inv.AmtPaid
+	ev.PaymentHash = hex.EncodeToString(inv.PaymentHash[:])
+	nd.PublishEvent(ev)
+
+	// if it was an order's or a lease's, that's paid now; see orders.go
+	// and leases.go
+	err = nd.orderInvoiceSettled(inv.PaymentHash)
+	if err != nil {
+		logger.Errorf("order invoice %x: %s\n", inv.PaymentHash, err.Error())
+	}
+	err = nd.leaseInvoiceSettled(inv.PaymentHash)
+	if err != nil {
+		logger.Errorf("lease invoice %x: %s\n", inv.PaymentHash, err.Error())
+	}
+	return nil
+}
+
+// setPushOut keeps the invoice a push of amt on q is about to pay.  Fails
+// if there's already one on q, still to go through.
+func (nd *LitNode) setPushOut(q *Qchan, hash [32]byte, amt int64) error {
+	opArr := lnutil.OutPointToBytes(q.Op)
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		pb := btx.Bucket(BKTPushesOut)
+		if pb == nil {
+			return fmt.Errorf("no outgoing push bucket")
+		}
+		if v := pb.Get(opArr[:]); v != nil {
+			return htlcErr(FailTemporaryChannel,
+				"channel %d has a payment of %x still going", q.Idx(), v[:32])
+		}
+		return pb.Put(opArr[:], append(hash[:], lnutil.I64tB(amt)...))
+	})
+}
+
+// dropPushOut forgets the invoice of a push on q that never went out
+func (nd *LitNode) dropPushOut(q *Qchan) error {
+	opArr := lnutil.OutPointToBytes(q.Op)
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(BKTPushesOut).Delete(opArr[:])
+	})
+}
+
+// claimPushOut sends the claim for a push of amt on q which has just gone
+// through, if it paid an invoice, and marks done any idempotency key left
+// pending while it was in doubt
+func (nd *LitNode) claimPushOut(q *Qchan, amt int64) {
+	opArr := lnutil.OutPointToBytes(q.Op)
+	var hash [32]byte
+	found := false
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		pb := btx.Bucket(BKTPushesOut)
+		if pb == nil {
+			return fmt.Errorf("no outgoing push bucket")
+		}
+		v := pb.Get(opArr[:])
+		if len(v) != 40 || lnutil.BtI64(v[32:]) != amt {
+			return nil
+		}
+		copy(hash[:], v[:32])
+		found = true
+		err := pb.Delete(opArr[:])
+		if err != nil {
+			return err
+		}
+		return payKeysPaid(btx, hash, q.Idx())
+	})
+	if err != nil {
+		logger.Errorf("chan %d push of %d claim: %s\n", q.Idx(), amt, err.Error())
+		return
+	}
+	if found {
+		nd.OmniOut <- lnutil.NewPayClaimMsg(q.Peer(), q.Op, hash, amt)
+	}
+}
@@ -0,0 +1,247 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Payments can be given an idempotency key, so an application that timed
+out waiting for a reply can send the same payment again without paying
+twice.  The first payment with a key runs; after that the key's result is
+returned without paying.  Keys and their results are kept in BKTPayKeys.
+
+A key whose payment failed can be used again, since nothing was paid.
+One whose push may still go out (see PushInDoubt) stays pending, and is
+marked done when the push goes through and its claim is sent (see
+payclaim.go).  One that was in flight when lit stopped stays pending too:
+whether it went through has to be checked in the payment history, so it's
+never retried.
+
+Separately, BKTPaidHashes has the payment hash of every request paid, and
+a request can't be paid twice whatever key it's given.
+*/
+
+// longest idempotency key
+const maxPayKeyLen = 128
+
+// PayKeyState is how far a keyed payment got
+type PayKeyState uint8
+
+const (
+	PayKeyPending PayKeyState = iota
+	PayKeyDone
+	PayKeyFailed
+)
+
+// PayKeyRecord is the result of the payment made with a key
+type PayKeyRecord struct {
+	State   PayKeyState
+	Time    int64 // unix time of the attempt
+	ChanIdx uint32
+	PayReq  string // request paid, if it got that far
+	Err     string
+}
+
+// ToBytes serializes a PayKeyRecord: state, time, channel, 2 byte length
+// and the request, then the error to the end
+func (p *PayKeyRecord) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(p.State))
+	binary.Write(&buf, binary.BigEndian, p.Time)
+	binary.Write(&buf, binary.BigEndian, p.ChanIdx)
+	binary.Write(&buf, binary.BigEndian, uint16(len(p.PayReq)))
+	buf.WriteString(p.PayReq)
+	buf.WriteString(p.Err)
+	return buf.Bytes()
+}
+
+// PayKeyRecordFromBytes deserializes a PayKeyRecord
+func PayKeyRecordFromBytes(b []byte) (PayKeyRecord, error) {
+	var p PayKeyRecord
+	if len(b) < 15 {
+		return p, fmt.Errorf("%d bytes, pay key record needs at least 15",
+			len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	st, _ := buf.ReadByte()
+	p.State = PayKeyState(st)
+	binary.Read(buf, binary.BigEndian, &p.Time)
+	binary.Read(buf, binary.BigEndian, &p.ChanIdx)
+	var prLen uint16
+	binary.Read(buf, binary.BigEndian, &prLen)
+	if buf.Len() < int(prLen) {
+		return p, fmt.Errorf("pay key record truncated")
+	}
+	p.PayReq = string(buf.Next(int(prLen)))
+	p.Err = buf.String()
+	return p, nil
+}
+
+// PayOnce runs pay unless a payment has already been made with key, in
+// which case it returns that payment's request and channel.  ran says if
+// pay was called.
+func (nd *LitNode) PayOnce(key string,
+	pay func() (string, uint32, error)) (string, uint32, bool, error) {
+	if key == "" || len(key) > maxPayKeyLen {
+		return "", 0, false, fmt.Errorf(
+			"idempotency key must be 1 to %d bytes", maxPayKeyLen)
+	}
+	// claim the key, or find what it already did
+	var old *PayKeyRecord
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		pkb := btx.Bucket(BKTPayKeys)
+		if pkb == nil {
+			return fmt.Errorf("no pay key bucket")
+		}
+		if b := pkb.Get([]byte(key)); b != nil {
+			rec, err := PayKeyRecordFromBytes(b)
+			if err != nil {
+				return err
+			}
+			if rec.State != PayKeyFailed {
+				old = &rec
+				return nil
+			}
+		}
+		rec := PayKeyRecord{State: PayKeyPending, Time: time.Now().Unix()}
+		return pkb.Put([]byte(key), rec.ToBytes())
+	})
+	if err != nil {
+		return "", 0, false, err
+	}
+	if old != nil {
+		if old.State == PayKeyPending {
+			return old.PayReq, 0, false, fmt.Errorf(
+				"payment with key %s pending since %s; check payment history",
+				key, time.Unix(old.Time, 0).Format(time.RFC822))
+		}
+		return old.PayReq, old.ChanIdx, false, nil
+	}
+
+	payReq, cIdx, payErr := pay()
+
+	rec := PayKeyRecord{State: PayKeyDone, Time: time.Now().Unix(),
+		ChanIdx: cIdx, PayReq: payReq}
+	if payErr != nil {
+		rec.State = PayKeyFailed
+		rec.Err = payErr.Error()
+		if PushInDoubt(payErr) {
+			rec.State = PayKeyPending
+		}
+	}
+	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+		err := btx.Bucket(BKTPayKeys).Put([]byte(key), rec.ToBytes())
+		if err != nil || rec.State != PayKeyPending {
+			return err
+		}
+		// the push may have gone through, and its claim been sent, already
+		pr, err := DecodePayReq(payReq)
+		if err != nil || pushOutPending(btx, pr.PaymentHash) {
+			return nil
+		}
+		return payKeysPaid(btx, pr.PaymentHash, cIdx)
+	})
+	if err != nil {
+		logger.Errorf("saving pay key %s: %s\n", key, err.Error())
+	}
+	return payReq, cIdx, true, payErr
+}
+
+// GetPayKey returns what the payment with an idempotency key did
+func (nd *LitNode) GetPayKey(key string) (PayKeyRecord, error) {
+	var rec PayKeyRecord
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		pkb := btx.Bucket(BKTPayKeys)
+		if pkb == nil {
+			return fmt.Errorf("no pay key bucket")
+		}
+		b := pkb.Get([]byte(key))
+		if b == nil {
+			return fmt.Errorf("no payment with key %s", key)
+		}
+		var err error
+		rec, err = PayKeyRecordFromBytes(b)
+		return err
+	})
+	return rec, err
+}
+
+// pushOutPending says if a push paying the request with hash is yet to be
+// claimed
+func pushOutPending(btx *bolt.Tx, hash [32]byte) bool {
+	found := false
+	btx.Bucket(BKTPushesOut).ForEach(func(k, v []byte) error {
+		if bytes.HasPrefix(v, hash[:]) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// payKeysPaid marks done the pending idempotency keys of the request with
+// hash, paid through channel chanIdx
+func payKeysPaid(btx *bolt.Tx, hash [32]byte, chanIdx uint32) error {
+	pkb := btx.Bucket(BKTPayKeys)
+	if pkb == nil {
+		return fmt.Errorf("no pay key bucket")
+	}
+	paid := make(map[string]PayKeyRecord)
+	err := pkb.ForEach(func(k, v []byte) error {
+		if len(v) == 0 || PayKeyState(v[0]) != PayKeyPending {
+			return nil
+		}
+		rec, err := PayKeyRecordFromBytes(v)
+		if err != nil {
+			return nil
+		}
+		pr, err := DecodePayReq(rec.PayReq)
+		if err != nil || pr.PaymentHash != hash {
+			return nil
+		}
+		rec.State = PayKeyDone
+		rec.ChanIdx = chanIdx
+		rec.Err = ""
+		paid[string(k)] = rec
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// can't Put while in ForEach
+	for k, rec := range paid {
+		err = pkb.Put([]byte(k), rec.ToBytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimPaymentHash marks a payment hash as being paid, and fails if it
+// already was.  unclaimPaymentHash undoes it if the payment fails.
+func (nd *LitNode) claimPaymentHash(hash [32]byte, chanIdx uint32) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		phb := btx.Bucket(BKTPaidHashes)
+		if phb == nil {
+			return fmt.Errorf("no paid hash bucket")
+		}
+		if b := phb.Get(hash[:]); b != nil {
+			return fmt.Errorf("request %x already paid through channel %d",
+				hash, lnutil.BtU32(b))
+		}
+		return phb.Put(hash[:], lnutil.U32tB(chanIdx))
+	})
+}
+
+func (nd *LitNode) unclaimPaymentHash(hash [32]byte) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(BKTPaidHashes).Delete(hash[:])
+	})
+}
@@ -0,0 +1,242 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Queued payments go in the background: QueuePayment checks the request and
+returns an id straight away, and a goroutine for the payment does the
+trying.  Each try pays through a channel with the payee that hasn't failed
+yet (hinted ones first, as with PayInvoice), so a channel that's busy, or
+too empty, or whose push the peer refused, is left for the next.  When
+there's no channel left to try, it waits, doubling each time up to
+payRetryMax, and starts over on all of them, since balances and
+connections change.  It stops after maxPayAttempts tries, when the request
+expires, or when lit shuts down.
+
+A push whose delta was saved before it failed goes out when the peer's
+back, so that payment isn't tried anywhere else: it fails with the error,
+and the payment history says if it went through.
+
+Each change to a payment goes out as an EventPaymentUpdate, which is what
+TrackPayment waits on.  Payments are kept in memory only, and finished
+ones for payKeepFinished after they finish; the payment history has all
+of them.
+*/
+
+const (
+	maxPayAttempts  = 8
+	payRetryMin     = 2 * time.Second
+	payRetryMax     = time.Minute
+	payKeepFinished = time.Hour
+)
+
+// PaymentState is where a queued payment's got to
+type PaymentState uint8
+
+const (
+	PaymentQueued PaymentState = iota
+	PaymentInFlight
+	PaymentRetrying  // a try failed; waiting for the next
+	PaymentSucceeded // done
+	PaymentFailed    // done
+)
+
+func (s PaymentState) String() string {
+	switch s {
+	case PaymentQueued:
+		return "queued"
+	case PaymentInFlight:
+		return "in flight"
+	case PaymentRetrying:
+		return "retrying"
+	case PaymentSucceeded:
+		return "succeeded"
+	case PaymentFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+// Final says if a payment in this state is finished
+func (s PaymentState) Final() bool {
+	return s == PaymentSucceeded || s == PaymentFailed
+}
+
+// QueuedPayment is a payment going in the background
+type QueuedPayment struct {
+	ID       uint32
+	PayReq   string
+	Amt      int64
+	State    PaymentState
+	Attempts int
+	ChanIdx  uint32   // channel of the last try; the one paid through if done
+	Tried    []uint32 // channels that failed since the last start over
+	Err      string   // why the last try failed
+	Code     FailCode // and its failure code; see htlcfail.go
+	Created  int64    // unix time
+	Updated  int64
+	// bumped on every change, so a tracker can tell what it's seen
+	Seq uint32
+}
+
+type payQueue struct {
+	mtx    sync.Mutex
+	nextID uint32
+	pays   map[uint32]*QueuedPayment
+}
+
+// QueuePayment starts paying a payment request in the background and
+// returns its id.  The request's checked first, so one that can't be paid
+// at all errors here.  done, if not nil, is called with the final result.
+func (nd *LitNode) QueuePayment(
+	payReq string, amt int64, done func(error)) (uint32, error) {
+
+	pr, amt, err := checkPayReq(payReq, amt)
+	if err != nil {
+		return 0, err
+	}
+	q := nd.payQueue
+	q.mtx.Lock()
+	q.prune()
+	q.nextID++
+	now := time.Now().Unix()
+	p := &QueuedPayment{
+		ID:      q.nextID,
+		PayReq:  payReq,
+		Amt:     amt,
+		Created: now,
+		Updated: now,
+	}
+	q.pays[p.ID] = p
+	q.mtx.Unlock()
+
+	go nd.runPayment(p.ID, pr, amt, done)
+	return p.ID, nil
+}
+
+// prune drops payments that finished more than payKeepFinished ago.
+// Call with the mutex held.
+func (q *payQueue) prune() {
+	cutoff := time.Now().Add(-payKeepFinished).Unix()
+	for id, p := range q.pays {
+		if p.State.Final() && p.Updated < cutoff {
+			delete(q.pays, id)
+		}
+	}
+}
+
+// QueuedPayments lists queued payments, oldest first
+func (nd *LitNode) QueuedPayments() []QueuedPayment {
+	q := nd.payQueue
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	ps := make([]QueuedPayment, 0, len(q.pays))
+	for _, p := range q.pays {
+		ps = append(ps, *p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	return ps
+}
+
+// GetQueuedPayment returns a queued payment as it is now
+func (nd *LitNode) GetQueuedPayment(id uint32) (QueuedPayment, error) {
+	q := nd.payQueue
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	p, ok := q.pays[id]
+	if !ok {
+		return QueuedPayment{}, fmt.Errorf("no queued payment %d", id)
+	}
+	return *p, nil
+}
+
+// updatePayment changes a queued payment and tells subscribers
+func (nd *LitNode) updatePayment(id uint32, f func(p *QueuedPayment)) {
+	q := nd.payQueue
+	q.mtx.Lock()
+	p := q.pays[id]
+	f(p)
+	p.Seq++
+	p.Updated = time.Now().Unix()
+	ev := NodeEvent{Type: EventPaymentUpdate, PaymentID: id, Amt: p.Amt,
+		ChanIdx: p.ChanIdx}
+	q.mtx.Unlock()
+	nd.PublishEvent(ev)
+}
+
+// runPayment tries a queued payment until it's paid or it gives up
+func (nd *LitNode) runPayment(
+	id uint32, pr *PayReq, amt int64, done func(error)) {
+
+	skip := make(map[uint32]bool)
+	wait := payRetryMin
+	for attempt := 1; ; attempt++ {
+		nd.updatePayment(id, func(p *QueuedPayment) {
+			p.State = PaymentInFlight
+			p.Attempts = attempt
+		})
+		cIdx, retry, err := nd.payVia(pr, amt, skip)
+		if err == nil {
+			nd.updatePayment(id, func(p *QueuedPayment) {
+				p.State = PaymentSucceeded
+				p.ChanIdx = cIdx
+				p.Err = ""
+				p.Code = FailUnknown
+			})
+			logger.Infof("queued payment %d paid through channel %d, try %d\n",
+				id, cIdx, attempt)
+			if done != nil {
+				done(nil)
+			}
+			return
+		}
+
+		// a channel that failed is left out next time; if there was none
+		// to try, wait and start over on them all
+		backoff := cIdx == 0
+		if cIdx != 0 {
+			skip[cIdx] = true
+		} else {
+			skip = make(map[uint32]bool)
+		}
+		if !retry || attempt >= maxPayAttempts || nd.ShuttingDown() ||
+			time.Now().Add(wait).Unix() > pr.ExpiresAt {
+			nd.updatePayment(id, func(p *QueuedPayment) {
+				p.State = PaymentFailed
+				p.ChanIdx = cIdx
+				p.Err = err.Error()
+				p.Code = FailCodeOf(err)
+			})
+			logger.Warnf("queued payment %d failed after %d tries: %s\n",
+				id, attempt, err.Error())
+			if done != nil {
+				done(err)
+			}
+			return
+		}
+		tried := make([]uint32, 0, len(skip))
+		for c := range skip {
+			tried = append(tried, c)
+		}
+		sort.Slice(tried, func(i, j int) bool { return tried[i] < tried[j] })
+		nd.updatePayment(id, func(p *QueuedPayment) {
+			p.State = PaymentRetrying
+			p.ChanIdx = cIdx
+			p.Tried = tried
+			p.Err = err.Error()
+			p.Code = FailCodeOf(err)
+		})
+		if backoff {
+			time.Sleep(wait)
+			wait *= 2
+			if wait > payRetryMax {
+				wait = payRetryMax
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+PeerReport scores each peer we've had channels with, best first, so it's
+easier to see which channels are worth growing and which closing.  What
+goes into it:
+
+	uptime        the fraction of the time since the node started the
+	              peer's been connected (uptime.go)
+	failures      outgoing payments through the peer that failed, of all
+	              those through it, and HTLC failures on its channels
+	              (htlcfail.go), since the time asked for
+	force closes  channels the peer broke instead of closing them coop.
+	              Ones we asked it to break (forceclose.go) don't count.
+	fee bumps     times the peer moved a channel to a funding tx paying
+	              more fee (fundbump.go)
+
+Peers don't send us their forwarding fee policies, so changes to those
+can't be counted; fee bumps are the only fee changes of theirs we see.
+
+Force closes and fee bumps are counted as they happen and kept in
+BKTPeerStats by peer index.  The rest comes from what's already kept.
+
+The score's from 0 to 1: the uptime, times the share of payments that went
+through, halved for each force close and cut by a tenth for each fee bump.
+Once the node's been up peerScoreSettle, so the uptimes mean something,
+peers with open channels scoring under peerCloseBelow are marked to close,
+and those at peerGrowAbove or over whose channels have had payments are
+marked to grow.
+*/
+
+const (
+	peerScoreSettle = time.Hour
+	peerCloseBelow  = 0.3
+	peerGrowAbove   = 0.8
+)
+
+// PeerStats are the things about a peer counted as they happen
+type PeerStats struct {
+	ForceCloses    uint32
+	FeeBumps       uint32
+	LastForceClose int64 // unix nanoseconds; 0 for never
+	LastFeeBump    int64
+}
+
+// ToBytes serializes PeerStats; always 24 bytes
+func (s *PeerStats) ToBytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, s.ForceCloses)
+	binary.Write(&buf, binary.BigEndian, s.FeeBumps)
+	binary.Write(&buf, binary.BigEndian, s.LastForceClose)
+	binary.Write(&buf, binary.BigEndian, s.LastFeeBump)
+	return buf.Bytes()
+}
+
+// PeerStatsFromBytes deserializes PeerStats
+func PeerStatsFromBytes(b []byte) (PeerStats, error) {
+	var s PeerStats
+	if len(b) != 24 {
+		return s, fmt.Errorf("%d bytes, peer stats are 24", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &s.ForceCloses)
+	binary.Read(buf, binary.BigEndian, &s.FeeBumps)
+	binary.Read(buf, binary.BigEndian, &s.LastForceClose)
+	binary.Read(buf, binary.BigEndian, &s.LastFeeBump)
+	return s, nil
+}
+
+// askedBreaks are the channels we've asked the peer to break, so their
+// closes aren't held against it.  Only kept in ram.
+type askedBreaks struct {
+	mtx sync.Mutex
+	ops map[[36]byte]bool
+}
+
+func newAskedBreaks() *askedBreaks {
+	return &askedBreaks{ops: make(map[[36]byte]bool)}
+}
+
+func (a *askedBreaks) add(op wire.OutPoint) {
+	a.mtx.Lock()
+	a.ops[lnutil.OutPointToBytes(op)] = true
+	a.mtx.Unlock()
+}
+
+// take says whether we asked for op to be broken, forgetting it
+func (a *askedBreaks) take(op wire.OutPoint) bool {
+	key := lnutil.OutPointToBytes(op)
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	asked := a.ops[key]
+	delete(a.ops, key)
+	return asked
+}
+
+// GetPeerStats returns what's been counted for a peer
+func (nd *LitNode) GetPeerStats(peerIdx uint32) (PeerStats, error) {
+	var s PeerStats
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTPeerStats)
+		if sb == nil {
+			return fmt.Errorf("no peer stats bucket")
+		}
+		b := sb.Get(lnutil.U32tB(peerIdx))
+		if b == nil {
+			return nil
+		}
+		var err error
+		s, err = PeerStatsFromBytes(b)
+		return err
+	})
+	return s, err
+}
+
+// updatePeerStats changes a peer's stats with f and saves them
+func (nd *LitNode) updatePeerStats(peerIdx uint32, f func(*PeerStats)) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTPeerStats)
+		if sb == nil {
+			return fmt.Errorf("no peer stats bucket")
+		}
+		var s PeerStats
+		b := sb.Get(lnutil.U32tB(peerIdx))
+		if b != nil {
+			var err error
+			s, err = PeerStatsFromBytes(b)
+			if err != nil {
+				return err
+			}
+		}
+		f(&s)
+		return sb.Put(lnutil.U32tB(peerIdx), s.ToBytes())
+	})
+}
+
+// notePeerBreak counts a channel's force close against its peer, unless
+// we asked for it
+func (nd *LitNode) notePeerBreak(q *Qchan) {
+	if nd.askedBreaks.take(q.Op) {
+		return
+	}
+	err := nd.updatePeerStats(q.Peer(), func(s *PeerStats) {
+		s.ForceCloses++
+		s.LastForceClose = time.Now().UnixNano()
+	})
+	if err != nil {
+		logger.Errorf("peer %d force close not counted: %s\n",
+			q.Peer(), err.Error())
+	}
+}
+
+// notePeerFeeBump counts a funding tx replacement by a peer
+func (nd *LitNode) notePeerFeeBump(peerIdx uint32) {
+	err := nd.updatePeerStats(peerIdx, func(s *PeerStats) {
+		s.FeeBumps++
+		s.LastFeeBump = time.Now().UnixNano()
+	})
+	if err != nil {
+		logger.Errorf("peer %d fee bump not counted: %s\n",
+			peerIdx, err.Error())
+	}
+}
+
+// PeerScore is a peer's line in the peer report
+type PeerScore struct {
+	PeerIdx  uint32
+	Nickname string
+	Open     uint32 // open channels
+	Closed   uint32
+	Capacity int64 // of the open channels
+	Local    int64 // ours in the open channels
+	Uptime   float64
+
+	Payments       uint32 // outgoing through the peer, since the report's start
+	FailedPayments uint32
+	HTLCFails      uint32 // failures on its channels, in and out
+	PeerStats
+
+	Score   float64
+	Suggest string // "close", "grow" or "" for neither
+}
+
+// PeerReport scores the peers we've had channels with, best first.  Payments
+// and failures count from since (unix nanoseconds).
+func (nd *LitNode) PeerReport(since int64) ([]PeerScore, error) {
+	now := time.Now().UnixNano()
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	byPeer := make(map[uint32]*PeerScore)
+	for _, q := range qcs {
+		ps, ok := byPeer[q.Peer()]
+		if !ok {
+			ps = &PeerScore{PeerIdx: q.Peer()}
+			byPeer[q.Peer()] = ps
+		}
+		if q.CloseData.Closed {
+			ps.Closed++
+			continue
+		}
+		ps.Open++
+		ps.Capacity += q.Value
+		ps.Local += q.State.MyAmt
+	}
+
+	pays, err := nd.ListPayments(since, now+1, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pays {
+		ps, ok := byPeer[p.PeerIdx]
+		if !ok {
+			continue
+		}
+		ps.Payments++
+		if !p.OK {
+			ps.FailedPayments++
+		}
+	}
+	fails, err := nd.ListHTLCFailures(since, now+1, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fails {
+		ps, ok := byPeer[f.PeerIdx]
+		if ok {
+			ps.HTLCFails++
+		}
+	}
+
+	settled := time.Since(nd.uptime.started) >= peerScoreSettle
+	var report []PeerScore
+	for idx, ps := range byPeer {
+		ps.Nickname = nd.GetNicknameFromPeerIdx(idx)
+		ps.Uptime = nd.PeerUptime(idx)
+		ps.PeerStats, err = nd.GetPeerStats(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		ps.Score = ps.Uptime
+		if ps.Payments > 0 {
+			ps.Score *= float64(ps.Payments-ps.FailedPayments) /
+				float64(ps.Payments)
+		}
+		for i := uint32(0); i < ps.ForceCloses; i++ {
+			ps.Score /= 2
+		}
+		for i := uint32(0); i < ps.FeeBumps; i++ {
+			ps.Score *= 0.9
+		}
+
+		if settled && ps.Open > 0 {
+			if ps.Score < peerCloseBelow {
+				ps.Suggest = "close"
+			} else if ps.Score >= peerGrowAbove && ps.Payments > 0 {
+				ps.Suggest = "grow"
+			}
+		}
+		report = append(report, *ps)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Score != report[j].Score {
+			return report[i].Score > report[j].Score
+		}
+		return report[i].PeerIdx < report[j].PeerIdx
+	})
+	return report, nil
+}
@@ -148,6 +148,23 @@ func (nd *LitNode) ReSendMsg(qc *Qchan) error {
 	return nd.SendREV(qc)
 }
 
+// pushInDoubtError is the error of a push that may still go out: its delta
+// was saved, so it's sent again when the peer's back, or PushChannel gave
+// up waiting while it was going
+type pushInDoubtError struct {
+	error
+}
+
+// PushInDoubt says if err is from a push that may still go through.  Any
+// other push error means nothing was or will be sent.
+func PushInDoubt(err error) bool {
+	if he, ok := err.(*HTLCError); ok {
+		err = he.Err
+	}
+	_, ok := err.(*pushInDoubtError)
+	return ok
+}
+
 // PushChannel initiates a state update by sending an DeltaSig
 func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 	// sanity checks
@@ -157,6 +174,42 @@ func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 	if amt == 0 {
 		return fmt.Errorf("have to send non-zero amount")
 	}
+	if nd.ShuttingDown() {
+		return fmt.Errorf("node shutting down")
+	}
+
+	// the DeltaSig goes out in the channel's op queue, but the rev's
+	// waited for outside it, since it comes in as an op itself
+	err := nd.chanDo(qc, "push", func() error {
+		return nd.startPush(qc, amt)
+	})
+	if _, ok := err.(*opRunningError); ok {
+		return &pushInDoubtError{err}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("got pre CTS... \n")
+	// block until clear to send is full again
+	<-qc.ClearToSend
+	fmt.Printf("got post CTS... \n")
+	// since we cleared with that statement, fill it again before returning
+	qc.ClearToSend <- true
+
+	return nil
+}
+
+// startPush takes the channel's clear to send, checks the push can go,
+// and sends the DeltaSig.  Clear to send comes back once the rev's in.
+// Errors once the delta's being saved are pushInDoubtErrors.
+func (nd *LitNode) startPush(qc *Qchan, amt uint32) (err error) {
+	saving := false
+	defer func() {
+		if err != nil && saving {
+			err = &pushInDoubtError{err}
+		}
+	}()
 
 	// see if channel is busy, error if so, lock if not
 	// lock this channel
@@ -170,7 +223,7 @@ func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 	// ClearToSend is now empty
 
 	// reload from disk here, after unlock
-	err := nd.ReloadQchanState(qc)
+	err = nd.ReloadQchanState(qc)
 	if err != nil {
 		// don't clear to send here; something is wrong with the channel
 		return err
@@ -183,10 +236,11 @@ func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 		return fmt.Errorf("Not connected to coin type %d\n", qc.Coin())
 	}
 
-	if !wal.Params().TestCoin && qc.Height < 100 {
+	need, confs := nd.chanConfs(wal, qc)
+	if confs < need {
 		qc.ClearToSend <- true
-		return fmt.Errorf(
-			"height %d; must wait min 1 conf for non-test coin\n", qc.Height)
+		return fmt.Errorf("channel has %d confirmations; %d sat needs %d\n",
+			confs, qc.Value, need)
 	}
 
 	// perform minOutput checks after reload
@@ -225,7 +279,8 @@ func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 	}
 
 	qc.State.Delta = int32(-amt)
-	// save to db with ONLY delta changed
+	// save to db with ONLY delta changed; from here it may go out
+	saving = true
 	err = nd.SaveQchanState(qc)
 	if err != nil {
 		// don't clear to send here; something is wrong with the channel
@@ -238,14 +293,6 @@ func (nd LitNode) PushChannel(qc *Qchan, amt uint32) error {
 		// don't clear; something is wrong with the network
 		return err
 	}
-
-	fmt.Printf("got pre CTS... \n")
-	// block until clear to send is full again
-	<-qc.ClearToSend
-	fmt.Printf("got post CTS... \n")
-	// since we cleared with that statement, fill it again before returning
-	qc.ClearToSend <- true
-
 	return nil
 }
 
@@ -492,6 +539,7 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 
 	// stash for justice tx
 	prevAmt := q.State.MyAmt - int64(q.State.Collision) // myAmt before collision
+	pushed := -int64(q.State.Delta)                     // our push, now done
 
 	q.State.MyAmt += int64(q.State.Delta) // delta should be negative
 	q.State.Delta = q.State.Collision     // now delta is positive
@@ -528,18 +576,15 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 	if err != nil {
 		return fmt.Errorf("GapSigRevHandler err %s", err.Error())
 	}
+	// if the push paid a request, tell them which; see payclaim.go
+	nd.claimPushOut(q, pushed)
 
 	// for justice, have to create signature for n-2.  Remember the n-2 amount
 
 	q.State.StateIdx -= 2
 	q.State.MyAmt = prevAmt
 
-	go func() {
-		err = nd.BuildJusticeSig(q)
-		if err != nil {
-			fmt.Printf("GapSigRevHandler BuildJusticeSig err %s", err.Error())
-		}
-	}()
+	nd.queueJustice(q)
 
 	return nil
 }
@@ -572,6 +617,7 @@ func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
 
 	// stash previous amount here for watchtower sig creation
 	prevAmt := qc.State.MyAmt
+	pushed := -int64(qc.State.Delta)
 
 	qc.State.StateIdx++
 	qc.State.MyAmt += int64(qc.State.Delta)
@@ -605,6 +651,8 @@ func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
 	if err != nil {
 		return fmt.Errorf("SIGREVHandler err %s", err.Error())
 	}
+	// if the push paid a request, tell them which; see payclaim.go
+	nd.claimPushOut(qc, pushed)
 
 	// now that we've saved & sent everything, before ending the function, we
 	// go BACK to create a txid/sig pair for watchtower.  This feels like a kindof
@@ -613,12 +661,7 @@ func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
 	qc.State.StateIdx--
 	qc.State.MyAmt = prevAmt
 
-	go func() {
-		err = nd.BuildJusticeSig(qc)
-		if err != nil {
-			fmt.Printf("SigRevHandler BuildJusticeSig err %s", err.Error())
-		}
-	}()
+	nd.queueJustice(qc)
 
 	// done updating channel, no new messages expected.  Set clear to send
 	qc.ClearToSend <- true
@@ -674,6 +717,7 @@ func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
 		return fmt.Errorf("REVHandler err %s", err.Error())
 	}
 	prevAmt := qc.State.MyAmt - int64(qc.State.Delta)
+	recvAmt := int64(qc.State.Delta)
 	qc.State.Delta = 0
 
 	// save to DB (new elkrem & point, delta zeroed)
@@ -682,16 +726,22 @@ func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
 		return fmt.Errorf("REVHandler err %s", err.Error())
 	}
 
+	// the payer may say which invoice it was for; see payclaim.go
+	err = nd.recordPushIn(qc, recvAmt)
+	if err != nil {
+		logger.Errorf("REVHandler chan %d push can't be claimed: %s\n",
+			qc.Idx(), err.Error())
+	}
+
+	ev := chanEvent(EventPaymentRecv, qc)
+	ev.Amt = recvAmt
+	nd.PublishEvent(ev)
+
 	// after saving cleared updated state, go back to previous state and build
 	// the justice signature
 	qc.State.StateIdx--      // back one state
 	qc.State.MyAmt = prevAmt // use stashed previous state amount
-	go func() {
-		err = nd.BuildJusticeSig(qc)
-		if err != nil {
-			fmt.Printf("RevHandler BuildJusticeSig err %s", err.Error())
-		}
-	}()
+	nd.queueJustice(qc)
 
 	// got rev, assert clear to send
 	qc.ClearToSend <- true
@@ -699,3 +749,15 @@ func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
 	fmt.Printf("REV OK, state %d all clear.\n", qc.State.StateIdx)
 	return nil
 }
+
+// queueJustice builds the justice sig for the state q's been set back to,
+// in q's op queue.  It works on a copy, since ops ahead of it can change
+// q, and BuildJusticeSig moves the elkpoint.
+func (nd *LitNode) queueJustice(q *Qchan) {
+	jq := *q
+	st := *q.State
+	jq.State = &st
+	nd.chanLater(q, "justice", func() error {
+		return nd.BuildJusticeSig(&jq)
+	})
+}
@@ -0,0 +1,380 @@
+package qln
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+The replay log is for "my channel's stuck" bug reports.  With it on, every
+channel message sent and received, every state saved and every error from
+a channel message handler is appended to a file, one json object a line.
+ReplayChannel then runs a channel's events back through the push / pull
+state machine, to show how it got where it is and where it went wrong.
+
+The log is scrubbed so it can be attached to a public bug report: there
+are no keys, sigs, elkrem hashes or points, outpoints, txids, peer pubkeys
+or hosts in it.  Channels and peers are by index.  Amounts are kept, since
+they're most of what goes wrong; long hex strings in error messages are
+blanked out.
+
+The push / pull machine, from pushpull.go:
+
+  pusher                          puller
+  DELTASIG (state n+1, delta) ->
+                               <- SIGREV (sig n+1, revoke n)
+  REV (revoke n)              ->
+
+When both push at once each gets a DELTASIG while waiting for a SIGREV.
+That's a collision; both send GAPSIGREV, and the pushes go through one
+after the other.
+*/
+
+// replay event kinds
+const (
+	ReplaySend  = "send"
+	ReplayRecv  = "recv"
+	ReplayState = "state"
+	ReplayError = "error"
+	ReplayEvent = "event" // node events for channels, eg closes
+)
+
+// ReplayRecord is one line of the replay log
+type ReplayRecord struct {
+	Seq  uint64
+	Time int64 // unix nanoseconds
+	Kind string
+	Peer uint32
+	Chan uint32 // 0 if the channel isn't known yet
+
+	Msg     string `json:",omitempty"` // message name, for send and recv
+	MsgType uint8  `json:",omitempty"`
+
+	// for DELTASIG messages, and states
+	Delta int32 `json:",omitempty"`
+
+	// saved state
+	StateIdx  uint64 `json:",omitempty"`
+	MyAmt     int64  `json:",omitempty"`
+	Collision int32  `json:",omitempty"`
+
+	Err   string `json:",omitempty"`
+	Event string `json:",omitempty"`
+}
+
+type replayLog struct {
+	mtx sync.Mutex
+	f   *os.File
+	seq uint64
+}
+
+// StartReplayLog appends channel state machine events to the file at path
+// from now on
+func (nd *LitNode) StartReplayLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	rl := &replayLog{f: f}
+	// carry on the sequence numbers
+	old, err := ReadReplayLog(path)
+	if err == nil && len(old) > 0 {
+		rl.seq = old[len(old)-1].Seq
+	}
+	nd.replay = rl
+
+	sub := nd.SubscribeEvents()
+	go func() {
+		for ev := range sub {
+			if ev.ChanIdx == 0 {
+				continue
+			}
+			nd.replayAdd(ReplayRecord{
+				Kind: ReplayEvent, Peer: ev.PeerIdx, Chan: ev.ChanIdx,
+				Event: ev.Type})
+		}
+	}()
+	logger.Infof("replay log: %s\n", path)
+	return nil
+}
+
+func (nd *LitNode) replayAdd(r ReplayRecord) {
+	rl := nd.replay
+	if rl == nil {
+		return
+	}
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	rl.seq++
+	r.Seq = rl.seq
+	r.Time = time.Now().UnixNano()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	_, err = rl.f.Write(append(b, '\n'))
+	if err != nil {
+		logger.Warnf("replay log: %s\n", err.Error())
+	}
+}
+
+// replayChanMsg says if a message type is part of a channel's state
+// machine, and its name
+func replayChanMsg(t uint8) (string, bool) {
+	switch t {
+	case lnutil.MSGID_POINTREQ:
+		return "POINTREQ", true
+	case lnutil.MSGID_POINTRESP:
+		return "POINTRESP", true
+	case lnutil.MSGID_CHANDESC:
+		return "CHANDESC", true
+	case lnutil.MSGID_CHANACK:
+		return "CHANACK", true
+	case lnutil.MSGID_SIGPROOF:
+		return "SIGPROOF", true
+	case lnutil.MSGID_CLOSEREQ:
+		return "CLOSEREQ", true
+	case lnutil.MSGID_CLOSERESP:
+		return "CLOSERESP", true
+	case lnutil.MSGID_DELTASIG:
+		return "DELTASIG", true
+	case lnutil.MSGID_SIGREV:
+		return "SIGREV", true
+	case lnutil.MSGID_GAPSIGREV:
+		return "GAPSIGREV", true
+	case lnutil.MSGID_REV:
+		return "REV", true
+	}
+	return "", false
+}
+
+// replayMsg logs a channel message sent or received
+func (nd *LitNode) replayMsg(kind string, msg lnutil.LitMsg, chanIdx uint32) {
+	if nd.replay == nil {
+		return
+	}
+	name, ok := replayChanMsg(msg.MsgType())
+	if !ok {
+		return
+	}
+	r := ReplayRecord{Kind: kind, Peer: msg.Peer(), Chan: chanIdx,
+		Msg: name, MsgType: msg.MsgType()}
+	if ds, ok := msg.(lnutil.DeltaSigMsg); ok {
+		r.Delta = ds.Delta
+	}
+	nd.replayAdd(r)
+}
+
+// replayState logs a channel state as it's saved
+func (nd *LitNode) replayState(q *Qchan) {
+	if nd.replay == nil || q.State == nil {
+		return
+	}
+	nd.replayAdd(ReplayRecord{Kind: ReplayState, Peer: q.Peer(), Chan: q.Idx(),
+		StateIdx: q.State.StateIdx, MyAmt: q.State.MyAmt,
+		Delta: q.State.Delta, Collision: q.State.Collision})
+}
+
+var replayHex = regexp.MustCompile(`[0-9a-fA-F]{16,}`)
+
+// replayErr logs an error handling a channel message
+func (nd *LitNode) replayErr(msg lnutil.LitMsg, chanIdx uint32, err error) {
+	if nd.replay == nil {
+		return
+	}
+	name, ok := replayChanMsg(msg.MsgType())
+	if !ok {
+		return
+	}
+	nd.replayAdd(ReplayRecord{Kind: ReplayError, Peer: msg.Peer(), Chan: chanIdx,
+		Msg: name, MsgType: msg.MsgType(),
+		Err: replayHex.ReplaceAllString(err.Error(), "<hex>")})
+}
+
+// ReadReplayLog reads a replay log file.  A torn last line, from a crash
+// mid-write, is skipped.
+func ReadReplayLog(path string) ([]ReplayRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReplayLog(f)
+}
+
+// ParseReplayLog reads replay log lines
+func ParseReplayLog(rd io.Reader) ([]ReplayRecord, error) {
+	var recs []ReplayRecord
+	sc := bufio.NewScanner(rd)
+	line := 0
+	var bad error
+	for sc.Scan() {
+		line++
+		if bad != nil {
+			// a bad line that wasn't the last
+			return nil, bad
+		}
+		var r ReplayRecord
+		err := json.Unmarshal(sc.Bytes(), &r)
+		if err != nil {
+			bad = fmt.Errorf("replay log line %d: %s", line, err.Error())
+			continue
+		}
+		recs = append(recs, r)
+	}
+	return recs, sc.Err()
+}
+
+// ReplayStep is an event replayed, and what the state machine made of it
+type ReplayStep struct {
+	ReplayRecord
+	Phase   string // where the update is after this event
+	AtState uint64 // the last state saved
+	Problem string `json:",omitempty"`
+}
+
+// replay phases
+const (
+	phaseIdle     = "idle"
+	phasePushing  = "pushing: sent DELTASIG, want SIGREV"
+	phasePulling  = "pulling: got DELTASIG, will send SIGREV"
+	phasePulled   = "pulling: sent SIGREV, want REV"
+	phaseRevoking = "pushing: got SIGREV, will send REV"
+	phaseGapSend  = "collision: both sent DELTASIG, will send GAPSIGREV"
+	phaseGapRecv  = "collision: sent GAPSIGREV, want GAPSIGREV"
+	phaseGapRev   = "collision: got GAPSIGREV, will send REV"
+)
+
+// ReplayResult is a channel's events run through the state machine
+type ReplayResult struct {
+	Chan     uint32
+	Steps    []ReplayStep
+	Phase    string // at the end
+	StateIdx uint64
+	Problems int
+	// if the update's not idle at the end, what it's waiting on
+	Stuck string `json:",omitempty"`
+}
+
+// ReplayChannel runs a channel's events through the push / pull state
+// machine.  Messages that don't fit it, states that skip or go back, and
+// errors logged are problems.  State numbers come from the saved states.
+func ReplayChannel(recs []ReplayRecord, chanIdx uint32) *ReplayResult {
+	res := &ReplayResult{Chan: chanIdx, Phase: phaseIdle}
+	known := false // no state seen yet
+	var waitSince uint64
+
+	// moves from one phase to another, or notes a problem if the
+	// machine isn't in a phase the event can come in
+	var problem string
+	step := func(r ReplayRecord, to string, from ...string) {
+		for _, f := range from {
+			if res.Phase == f {
+				res.Phase = to
+				return
+			}
+		}
+		problem = fmt.Sprintf("%s %s while %s", r.Msg, r.Kind, res.Phase)
+		res.Phase = to
+	}
+
+	for _, r := range recs {
+		if r.Chan != chanIdx {
+			continue
+		}
+		prev := res.Phase
+		problem = ""
+
+		switch {
+		case r.Kind == ReplayState:
+			if known && r.StateIdx < res.StateIdx {
+				problem = fmt.Sprintf("state went back from %d to %d",
+					res.StateIdx, r.StateIdx)
+			} else if known && r.StateIdx > res.StateIdx+1 {
+				problem = fmt.Sprintf("state jumped from %d to %d",
+					res.StateIdx, r.StateIdx)
+			}
+			res.StateIdx = r.StateIdx
+			known = true
+
+		case r.Kind == ReplayError:
+			problem = fmt.Sprintf("%s handler: %s", r.Msg, r.Err)
+
+		case r.Kind == ReplayEvent:
+			if r.Event == EventChanClosed && res.Phase != phaseIdle {
+				problem = fmt.Sprintf("closed while %s", res.Phase)
+			}
+
+		case r.Msg == "DELTASIG" && r.Kind == ReplaySend:
+			step(r, phasePushing, phaseIdle)
+		case r.Msg == "DELTASIG" && r.Kind == ReplayRecv:
+			if res.Phase == phasePushing {
+				res.Phase = phaseGapSend
+			} else {
+				step(r, phasePulling, phaseIdle)
+			}
+
+		case r.Msg == "SIGREV" && r.Kind == ReplaySend:
+			step(r, phasePulled, phasePulling)
+		case r.Msg == "SIGREV" && r.Kind == ReplayRecv:
+			step(r, phaseRevoking, phasePushing)
+
+		case r.Msg == "GAPSIGREV" && r.Kind == ReplaySend:
+			step(r, phaseGapRecv, phaseGapSend)
+		case r.Msg == "GAPSIGREV" && r.Kind == ReplayRecv:
+			step(r, phaseGapRev, phaseGapRecv)
+
+		case r.Msg == "REV" && r.Kind == ReplaySend:
+			// after a collision, the REV for their GAPSIGREV leaves our
+			// side of their push to finish
+			if res.Phase == phaseGapRev {
+				res.Phase = phasePulled
+			} else {
+				step(r, phaseIdle, phaseRevoking)
+			}
+		case r.Msg == "REV" && r.Kind == ReplayRecv:
+			step(r, phaseIdle, phasePulled)
+		}
+
+		if prev == phaseIdle && res.Phase != phaseIdle {
+			waitSince = r.Seq
+		}
+		if problem != "" {
+			res.Problems++
+		}
+		res.Steps = append(res.Steps, ReplayStep{
+			ReplayRecord: r, Phase: res.Phase, AtState: res.StateIdx,
+			Problem: problem})
+	}
+
+	if res.Phase != phaseIdle {
+		res.Stuck = fmt.Sprintf("%s, since event %d", res.Phase, waitSince)
+	}
+	return res
+}
+
+// ReplayLogChannel replays a channel from this node's replay log
+func (nd *LitNode) ReplayLogChannel(chanIdx uint32) (*ReplayResult, error) {
+	if nd.replay == nil {
+		return nil, fmt.Errorf("replay log is off")
+	}
+	nd.replay.mtx.Lock()
+	path := nd.replay.f.Name()
+	nd.replay.mtx.Unlock()
+	recs, err := ReadReplayLog(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReplayChannel(recs, chanIdx), nil
+}
@@ -0,0 +1,230 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A proof of reserves is a snapshot of what one wallet holds at its current
+height, signed so a custodian can show an auditor it's solvent without
+giving up keys.  Everything's signed over a header naming the node, coin,
+height and a challenge the auditor picks, so a proof can't be made ahead
+of time or passed off as another node's.
+
+Each confirmed utxo is signed with its own key: the auditor checks the
+pubkey's in the output's script (p2wpkh directly, p2wsh via the witness
+script given) and that the output's unspent at that height.  Each open
+channel is signed with our funding key: the funding output is the 2 of 2
+of both funding pubkeys, so the auditor can check it's on chain with that
+value.  Our balance in a channel is only what the latest state says;
+nothing on chain shows it, and the peer's word (or a force close) is what
+backs it.  HTLCs in flight aren't counted.
+
+The whole thing, header, lines and total, is then signed with the node's
+identity key.  A line is signed as sha256(header + line + "\n"); the
+identity signature is over sha256 of the header, every line with its
+"\n", utxos then channels, and "total <sats>".  Signatures are DER.
+*/
+
+// ReserveItem is one utxo or channel in a proof of reserves
+type ReserveItem struct {
+	Line      string // what's signed, after the header
+	OutPoint  string
+	Amt       int64  // ours; for channels, our side of the latest state
+	Pub       string // hex of the key that signed
+	WitScript string // hex, for p2wsh utxos
+	Sig       string // hex DER
+}
+
+// ReservesProof is a signed snapshot of a wallet's utxos and channels
+type ReservesProof struct {
+	Header   string
+	LitAdr   string
+	IdPub    string // hex; its hash is LitAdr
+	CoinType uint32
+	Height   int32
+	Time     int64 // unix time made; not signed
+	Utxos    []ReserveItem
+	Channels []ReserveItem
+	Total    int64
+	IdSig    string // hex DER, by the identity key, over all of it
+}
+
+// reservesHeader is what every signature in a proof starts with
+func reservesHeader(litAdr string, coin uint32, height int32, challenge string) string {
+	return fmt.Sprintf("lit proof of reserves\nnode %s\ncoin %d\nheight %d\nchallenge %s\n",
+		litAdr, coin, height, challenge)
+}
+
+// signReserveLine signs a line of a proof
+func signReserveLine(
+	priv *btcec.PrivateKey, header string, it *ReserveItem) error {
+	h := sha256.Sum256([]byte(header + it.Line + "\n"))
+	sig, err := priv.Sign(h[:])
+	if err != nil {
+		return err
+	}
+	it.Pub = hex.EncodeToString(priv.PubKey().SerializeCompressed())
+	it.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// ProofOfReserves signs for a coin's confirmed utxos and open channel
+// balances.  height, if not 0, has to be where the wallet's synced to;
+// reserves can't be proven for blocks already past.  challenge is the
+// auditor's, and goes in every signature.
+func (nd *LitNode) ProofOfReserves(
+	coin uint32, height int32, challenge string) (*ReservesProof, error) {
+
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return nil, fmt.Errorf("no wallet for coin type %d", coin)
+	}
+	if strings.Contains(challenge, "\n") {
+		return nil, fmt.Errorf("challenge can't have newlines")
+	}
+	cur := wal.CurrentHeight()
+	if height != 0 && height != cur {
+		return nil, fmt.Errorf("wallet is at height %d, not %d", cur, height)
+	}
+
+	p := new(ReservesProof)
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	p.LitAdr = lnutil.LitAdrFromPubkey(idPub)
+	p.IdPub = hex.EncodeToString(idPub[:])
+	p.CoinType = coin
+	p.Height = cur
+	p.Time = time.Now().Unix()
+	p.Header = reservesHeader(p.LitAdr, coin, cur, challenge)
+
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range utxos {
+		// unconfirmed ones aren't anything yet
+		if u.Height < 1 || u.Height > cur {
+			continue
+		}
+		it := ReserveItem{
+			Line:     fmt.Sprintf("utxo %s %d", u.Op.String(), u.Value),
+			OutPoint: u.Op.String(),
+			Amt:      u.Value,
+		}
+		if len(u.WitScript) != 0 {
+			it.WitScript = hex.EncodeToString(u.WitScript)
+		}
+		err = signReserveLine(wal.GetPriv(u.KeyGen), p.Header, &it)
+		if err != nil {
+			return nil, err
+		}
+		p.Utxos = append(p.Utxos, it)
+		p.Total += it.Amt
+	}
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range qcs {
+		if q.Coin() != coin || q.CloseData.Closed || q.Height < 1 {
+			continue
+		}
+		it := ReserveItem{
+			Line: fmt.Sprintf("channel %s %d of %d state %d with %x",
+				q.Op.String(), q.State.MyAmt, q.Value, q.State.StateIdx,
+				q.TheirPub),
+			OutPoint: q.Op.String(),
+			Amt:      q.State.MyAmt,
+		}
+		err = signReserveLine(wal.GetPriv(q.KeyGen), p.Header, &it)
+		if err != nil {
+			return nil, err
+		}
+		p.Channels = append(p.Channels, it)
+		p.Total += it.Amt
+	}
+
+	h := sha256.Sum256([]byte(p.signedBody()))
+	sig, err := nd.IdKey().Sign(h[:])
+	if err != nil {
+		return nil, err
+	}
+	p.IdSig = hex.EncodeToString(sig.Serialize())
+	return p, nil
+}
+
+// signedBody is what the identity key signs
+func (p *ReservesProof) signedBody() string {
+	var b bytes.Buffer
+	b.WriteString(p.Header)
+	for _, it := range p.Utxos {
+		b.WriteString(it.Line + "\n")
+	}
+	for _, it := range p.Channels {
+		b.WriteString(it.Line + "\n")
+	}
+	fmt.Fprintf(&b, "total %d", p.Total)
+	return b.String()
+}
+
+// Verify checks a proof's signatures and that its total adds up.  Whether
+// the outputs are on chain, unspent and pay to the keys is up to whoever's
+// checking; see the comment at the top.
+func (p *ReservesProof) Verify() error {
+	var total int64
+	items := append(append([]ReserveItem{}, p.Utxos...), p.Channels...)
+	for _, it := range items {
+		err := verifyReserveSig(it.Pub, it.Sig, p.Header+it.Line+"\n")
+		if err != nil {
+			return fmt.Errorf("%s: %s", it.OutPoint, err.Error())
+		}
+		total += it.Amt
+	}
+	if total != p.Total {
+		return fmt.Errorf("items add up to %d, total says %d", total, p.Total)
+	}
+	var idPub [33]byte
+	b, err := hex.DecodeString(p.IdPub)
+	if err != nil || len(b) != 33 {
+		return fmt.Errorf("bad identity pubkey %s", p.IdPub)
+	}
+	copy(idPub[:], b)
+	if lnutil.LitAdrFromPubkey(idPub) != p.LitAdr {
+		return fmt.Errorf("identity pubkey isn't %s's", p.LitAdr)
+	}
+	return verifyReserveSig(p.IdPub, p.IdSig, p.signedBody())
+}
+
+func verifyReserveSig(pubHex, sigHex, msg string) error {
+	pubBytes, err := hex.DecodeString(pubHex)
+	if err != nil {
+		return err
+	}
+	pub, err := btcec.ParsePubKey(pubBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	sigBytes, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return err
+	}
+	sig, err := btcec.ParseDERSignature(sigBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(msg))
+	if !sig.Verify(h[:], pub) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
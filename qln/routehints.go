@@ -0,0 +1,147 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Route hints go in invoices to tell a payer which channels lead in to us.
+Lit doesn't announce channels, so nobody can find them otherwise.  Each
+hint is the ln address of the node at the other end of one of our
+channels, and the channel's short id; in a payment request they look like
+  litadr@blockxtxxoutput
+separated by commas.
+
+Hints are picked when the invoice is made: confirmed, open channels that
+can take the invoice amount in, best first by how much they can take in
+weighted by how much of the time the peer's been connected.
+
+Without multi-hop payments a payer can only use a hint naming itself: it
+pays through that channel rather than any other it has with us.
+*/
+
+// most hints to put in an invoice
+const maxRouteHints = 3
+
+// RouteHint is a channel in to the node that made an invoice
+type RouteHint struct {
+	LitAdr  string // the node at the other end of the channel
+	ShortID lnutil.ShortChanID
+}
+
+// String gives the hint as it's written in a payment request
+func (h RouteHint) String() string {
+	return fmt.Sprintf("%s@%dx%dx%d", h.LitAdr,
+		h.ShortID.Block(), h.ShortID.TxIdx(), h.ShortID.Output())
+}
+
+// ParseRouteHint parses a hint from a payment request
+func ParseRouteHint(s string) (RouteHint, error) {
+	var h RouteHint
+	parts := strings.Split(s, "@")
+	if len(parts) != 2 {
+		return h, fmt.Errorf("bad route hint %s", s)
+	}
+	h.LitAdr = parts[0]
+	if !lnutil.LitAdrOK(h.LitAdr) {
+		return h, fmt.Errorf("bad ln address %s in route hint", h.LitAdr)
+	}
+	var err error
+	h.ShortID, err = lnutil.ParseShortChanID(parts[1])
+	if err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// routeHintsToBytes serializes hints for the db: for each, the 8 byte
+// short id, the address length, and the address
+func routeHintsToBytes(hints []RouteHint) []byte {
+	var buf bytes.Buffer
+	for _, h := range hints {
+		buf.Write(lnutil.U64tB(uint64(h.ShortID)))
+		buf.WriteByte(byte(len(h.LitAdr)))
+		buf.WriteString(h.LitAdr)
+	}
+	return buf.Bytes()
+}
+
+func routeHintsFromBytes(b []byte) ([]RouteHint, error) {
+	var hints []RouteHint
+	for len(b) > 0 {
+		if len(b) < 9 || len(b) < 9+int(b[8]) {
+			return nil, fmt.Errorf("route hints truncated")
+		}
+		var h RouteHint
+		h.ShortID = lnutil.ShortChanID(lnutil.BtU64(b[:8]))
+		n := 9 + int(b[8])
+		h.LitAdr = string(b[9:n])
+		hints = append(hints, h)
+		b = b[n:]
+	}
+	return hints, nil
+}
+
+// pickRouteHints chooses the channels to hint at in an invoice for amt
+func (nd *LitNode) pickRouteHints(amt int64) ([]RouteHint, error) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	type cand struct {
+		hint  RouteHint
+		score float64
+	}
+	var cands []cand
+	for _, qc := range qcs {
+		if qc.CloseData.Closed || qc.ShortID == 0 {
+			continue
+		}
+		// what they can push to us, leaving their own reserve
+		theirAmt := qc.Value - qc.State.MyAmt
+		inbound := theirAmt - minOutput - qc.State.Fee
+		if inbound < 1 || inbound < amt {
+			continue
+		}
+		pub, _ := nd.GetPubHostFromPeerIdx(qc.Peer())
+		var c cand
+		c.hint.LitAdr = lnutil.LitAdrFromPubkey(pub)
+		c.hint.ShortID = qc.ShortID
+		c.score = float64(inbound) * nd.PeerUptime(qc.Peer())
+		cands = append(cands, c)
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].score > cands[j].score
+	})
+	var hints []RouteHint
+	for i := 0; i < len(cands) && i < maxRouteHints; i++ {
+		hints = append(hints, cands[i].hint)
+	}
+	return hints, nil
+}
+
+// hintedChannels returns the indexes of our channels that a payment
+// request's hints name, for paying through
+func (nd *LitNode) hintedChannels(hints []RouteHint) map[uint32]bool {
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	me := lnutil.LitAdrFromPubkey(idPub)
+
+	hinted := make(map[uint32]bool)
+	for _, h := range hints {
+		if h.LitAdr != me {
+			continue
+		}
+		qc, err := nd.GetQchanByShortID(h.ShortID)
+		if err != nil {
+			continue
+		}
+		hinted[qc.Idx()] = true
+	}
+	return hinted
+}
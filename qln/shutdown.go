@@ -0,0 +1,147 @@
+package qln
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Shutdown order:
+ 1. refuse new pushes and fundings
+ 2. stop listening for new peers
+ 3. wait (a little while) for state updates already in flight to finish
+ 4. disconnect peers
+ 5. close the wallet and tower dbs, then the ln db
+
+Everything is written to bolt as it happens, so nothing needs to be
+flushed; closing the dbs just makes sure nothing is left half written.
+
+Startup recovery: a crash in the middle of a push leaves a channel with a
+non-zero delta saved to disk.  RecoverPending logs those at startup and
+resumePending re-sends the last message when the peer comes back, the
+same way PushChannel does when it finds a channel not at rest.
+*/
+
+// how long Shutdown waits for in-flight state updates
+const shutdownWait = 10 * time.Second
+
+// ShuttingDown returns true once Shutdown has started.
+func (nd *LitNode) ShuttingDown() bool {
+	return atomic.LoadInt32(&nd.shutdown) != 0
+}
+
+// Shutdown stops the node in order.  Call once, just before exiting.
+func (nd *LitNode) Shutdown() {
+	atomic.StoreInt32(&nd.shutdown, 1)
+	logger.Infof("shutting down\n")
+
+	nd.RemoteMtx.Lock()
+	for _, lis := range nd.listeners {
+		lis.Close()
+	}
+	nd.listeners = nil
+	peers := make([]*RemotePeer, 0, len(nd.RemoteCons))
+	var qcs []*Qchan
+	for _, peer := range nd.RemoteCons {
+		peers = append(peers, peer)
+		for _, q := range peer.QCs {
+			qcs = append(qcs, q)
+		}
+	}
+	nd.RemoteMtx.Unlock()
+
+	// take the clear to send token from every channel.  Once we have it
+	// there's no update in progress, and since we don't put it back no
+	// new one can start.  Not under RemoteMtx, which an update in progress
+	// may need to finish.
+	deadline := time.Now().Add(shutdownWait)
+	for _, q := range qcs {
+		select {
+		case <-q.ClearToSend:
+		case <-time.After(deadline.Sub(time.Now())):
+			logger.Warnf("channel %d still busy at shutdown\n", q.Idx())
+		}
+	}
+
+	for _, peer := range peers {
+		peer.Con.Close()
+	}
+
+	for cointype, wal := range nd.SubWallet {
+		c, ok := wal.(io.Closer)
+		if !ok {
+			continue
+		}
+		err := c.Close()
+		if err != nil {
+			logger.Errorf("close wallet %d error %s\n", cointype, err.Error())
+		}
+	}
+
+	c, ok := nd.Tower.(io.Closer)
+	if ok {
+		err := c.Close()
+		if err != nil {
+			logger.Errorf("close tower error %s\n", err.Error())
+		}
+	}
+
+	err := nd.LitDB.Close()
+	if err != nil {
+		logger.Errorf("close ln db error %s\n", err.Error())
+	}
+	logger.Infof("shutdown complete\n")
+}
+
+// RecoverPending looks for channels left in the middle of a state update,
+// and logs them.  They'll be finished when their peer connects.
+func (nd *LitNode) RecoverPending() error {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return err
+	}
+	for _, q := range qcs {
+		if !q.CloseData.Closed && q.State.Delta != 0 {
+			logger.Warnf("channel %d (peer %d) stopped mid-update, delta %d; "+
+				"will resume on reconnect\n", q.Idx(), q.Peer(), q.State.Delta)
+		}
+	}
+	return nil
+}
+
+// resumePending re-sends the last state update message for any of the
+// peer's channels which aren't at rest, in each channel's op queue.
+func (nd *LitNode) resumePending(peer *RemotePeer) {
+	for _, q := range peer.QCs {
+		if q.CloseData.Closed || q.State.Delta == 0 {
+			continue
+		}
+		q := q
+		err := nd.chanDo(q, "resume", func() error { return nd.resumeChan(q) })
+		if err != nil {
+			logger.Errorf("resume channel %d error %s\n", q.Idx(), err.Error())
+		}
+	}
+}
+
+// resumeChan re-sends a channel's last state update message.  The clear to
+// send token is taken here, and given back by the handler of the peer's
+// reply.
+func (nd *LitNode) resumeChan(q *Qchan) error {
+	select {
+	case <-q.ClearToSend:
+	default:
+		return nil // busy; something else is already on it
+	}
+	err := nd.ReloadQchanState(q)
+	if err == nil && q.State.Delta != 0 {
+		logger.Infof("resuming update on channel %d\n", q.Idx())
+		err = nd.ReSendMsg(q)
+		if err == nil {
+			return nil
+		}
+	}
+	q.ClearToSend <- true
+	return err
+}
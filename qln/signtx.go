@@ -12,6 +12,25 @@ import (
 
 // SignBreak signs YOUR tx, which you already have a sig for
 func (nd *LitNode) SignBreakTx(q *Qchan) (*wire.MsgTx, error) {
+	tx, err := nd.signMyStateTx(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// save channel state as closed
+	q.CloseData.Closed = true
+	q.CloseData.CloseTxid = tx.TxHash()
+	err = nd.SaveQchanUtxoData(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// signMyStateTx builds and fully signs YOUR current state tx, without
+// touching the channel.  Broadcasting it breaks the channel.
+func (nd *LitNode) signMyStateTx(q *Qchan) (*wire.MsgTx, error) {
 	tx, err := q.BuildStateTx(true)
 	if err != nil {
 		return nil, err
@@ -29,8 +48,11 @@ func (nd *LitNode) SignBreakTx(q *Qchan) (*wire.MsgTx, error) {
 	// get private signing key
 	priv := nd.SubWallet[q.Coin()].GetPriv(q.KeyGen)
 	// generate sig.
-	mySig, err := txscript.RawTxInWitnessSignature(
+	mySig, err := lnutil.RawTxInWitnessSignatureLowR(
 		tx, hCache, 0, q.Value, pre, txscript.SigHashAll, priv)
+	if err != nil {
+		return nil, err
+	}
 
 	theirSig := sig64.SigDecompress(q.State.sig)
 	// put the sighash all byte on the end of their signature
@@ -44,14 +66,6 @@ func (nd *LitNode) SignBreakTx(q *Qchan) (*wire.MsgTx, error) {
 		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, mySig, theirSig)
 	}
 
-	// save channel state as closed
-	q.CloseData.Closed = true
-	q.CloseData.CloseTxid = tx.TxHash()
-	err = nd.SaveQchanUtxoData(q)
-	if err != nil {
-		return nil, err
-	}
-
 	return tx, nil
 }
 
@@ -71,7 +85,7 @@ func (nd *LitNode) SignSimpleClose(q *Qchan, tx *wire.MsgTx) ([64]byte, error) {
 	// get private signing key
 	priv := nd.SubWallet[q.Coin()].GetPriv(q.KeyGen)
 	// generate sig
-	mySig, err := txscript.RawTxInWitnessSignature(
+	mySig, err := lnutil.RawTxInWitnessSignatureLowR(
 		tx, hCache, 0, q.Value, pre, txscript.SigHashAll, priv)
 	if err != nil {
 		return sig, err
@@ -113,7 +127,7 @@ func (nd *LitNode) SignState(q *Qchan) ([64]byte, error) {
 	priv := nd.SubWallet[q.Coin()].GetPriv(q.KeyGen)
 
 	// generate sig.
-	bigSig, err := txscript.RawTxInWitnessSignature(
+	bigSig, err := lnutil.RawTxInWitnessSignatureLowR(
 		tx, hCache, 0, q.Value, pre, txscript.SigHashAll, priv)
 	// truncate sig (last byte is sighash type, always sighashAll)
 	bigSig = bigSig[:len(bigSig)-1]
@@ -0,0 +1,100 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A node can use a channel peer as its watchtower, on the same lndc
+connection as the channels.  The connection then carries two streams:
+tower uploads (descriptors, states, unwatches and report requests), and
+everything else.  Which stream a message is on goes by its type, so
+there's nothing new on the wire.
+
+Going out, the OutMessager only takes a tower upload when there's no other
+message waiting, so a big outbox flush can't hold up channel updates; the
+uploads go in between.  Each upload waits for its write, so the outbox
+only drops what's gone out.
+
+Coming in, tower uploads are handed to a goroutine of their own for each
+peer, in order, so the tower's work on them (DB writes, batching; see
+watchtower/batch.go) doesn't hold up the channel messages behind them.
+Both streams stay in order; they just don't wait on each other.
+
+A tower on a connection that isn't a peer's still gets written to
+directly.
+*/
+
+// how many tower uploads from a peer can wait to be handled before its
+// reader waits too
+const towerStreamDepth = 64
+
+// towerUpload is a message from the tower outbox waiting for the
+// OutMessager, and where to say how the write went
+type towerUpload struct {
+	msg  lnutil.LitMsg
+	done chan error
+}
+
+// onTowerStream says if a message type is a tower upload
+func onTowerStream(msgType uint8) bool {
+	switch msgType {
+	case lnutil.MSGID_WATCH_DESC, lnutil.MSGID_WATCH_STATEMSG,
+		lnutil.MSGID_WATCH_DELETE, lnutil.MSGID_WATCH_REPREQ:
+		return true
+	}
+	return false
+}
+
+// writeTowerMsg sends a serialized message from the tower outbox to the
+// tower on peer peerIdx, returning once it's written.  0 is WatchCon when
+// it's not a peer's.
+func (nd *LitNode) writeTowerMsg(peerIdx uint32, raw []byte) error {
+	if peerIdx == 0 {
+		// not a peer; the connection's the tower's alone
+		_, err := nd.WatchCon.Write(raw)
+		return err
+	}
+	msg, err := lnutil.LitMsgFromBytes(raw, peerIdx)
+	if err != nil {
+		return err
+	}
+	return nd.sendTowerUpload(msg)
+}
+
+// sendTowerUpload hands a message to the OutMessager on the tower stream,
+// returning once it's written
+func (nd *LitNode) sendTowerUpload(msg lnutil.LitMsg) error {
+	up := towerUpload{msg: msg, done: make(chan error, 1)}
+	nd.towerOut <- up
+	return <-up.done
+}
+
+// nextOutMsg waits for the next message for the OutMessager, channel
+// messages before tower uploads.  done is nil for channel messages.
+func (nd *LitNode) nextOutMsg() (lnutil.LitMsg, chan error) {
+	select {
+	case msg := <-nd.OmniOut:
+		return msg, nil
+	default:
+	}
+	select {
+	case msg := <-nd.OmniOut:
+		return msg, nil
+	case up := <-nd.towerOut:
+		return up.msg, up.done
+	}
+}
+
+// towerStreamer handles a peer's tower uploads, in order, until in closes
+func (nd *LitNode) towerStreamer(peer *RemotePeer, in chan lnutil.LitMsg) {
+	for msg := range in {
+		err := nd.PeerHandler(msg, nil, peer)
+		if err != nil {
+			fmt.Printf("PeerHandler error with %d: %s\n", peer.Idx, err.Error())
+			nd.replayErr(msg, 0, err)
+		}
+	}
+}
@@ -0,0 +1,735 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Atomic swaps trade coins on one chain for coins on another with a peer,
+without either side having to trust the other.  Each side locks its coins
+in an htlc on its own chain; the htlcs use the same hash, and the offerer
+is the only one who knows the preimage.
+
+offerer (A)                                accepter (B)
+A -> B SwapOffer: hash, amounts, coins, A's keys, A's locktime
+   (B sees it in ListSwaps and calls AcceptSwap)
+B -> A SwapAccept: B's keys, B's locktime (about half as long as A's)
+A funds htlc on A's chain
+A -> B SwapFunded: the funding tx
+   (B checks the tx, waits for it to confirm)
+B funds htlc on B's chain
+B -> A SwapFunded: the funding tx
+   (A checks the tx, waits for it to confirm)
+A claims B's htlc, which puts the preimage on B's chain
+B sees the claim, gets the preimage, claims A's htlc
+
+If things stop partway, whoever funded an htlc gets it back once their
+chain reaches their locktime; SwapWatcher does that.  B's locktime is
+earlier than A's so B can always get its coins back before A could both
+claim B's and refund A's.
+
+Everything is from "my" point of view: my htlc is the one I fund, on
+MyCoin, which they claim and I refund.
+*/
+
+// swap states, from our side
+const (
+	SwapStateOffered  = uint8(iota) // offer sent or received, not accepted
+	SwapStateAccepted               // both sides have keys and locktimes
+	SwapStateFunded                 // my htlc is out
+	SwapStateClaimed                // I took their htlc; done
+	SwapStateRefunded               // I took my htlc back; done
+)
+
+var swapStateNames = []string{"offered", "accepted", "funded", "claimed",
+	"refunded"}
+
+const (
+	// default blocks on the offerer's chain before the offerer can refund
+	defaultSwapLock = 144
+	// fewest blocks from now either htlc can have before refund
+	minSwapBlocks = 6
+	// rough size of a claim or refund tx, for the fee
+	swapSweepSize = 200
+	// longest between looks for htlcs to refund, if no blocks come
+	swapCheckInterval = time.Minute
+)
+
+// Swap is one atomic swap
+type Swap struct {
+	Hash      [32]byte
+	Preimage  [32]byte // offerer knows it at the start, accepter on claim
+	Initiator bool     // true if we made the offer
+	State     uint8
+	PeerIdx   uint32
+	Idx       uint32 // for key derivation
+
+	MyCoin, TheirCoin uint32
+	MyAmt, TheirAmt   int64
+
+	MyRefundPub    [33]byte // refunds my htlc, on MyCoin
+	MyClaimPub     [33]byte // claims their htlc, on TheirCoin
+	TheirRefundPub [33]byte
+	TheirClaimPub  [33]byte
+
+	MyLocktime    uint32 // MyCoin height my htlc can be refunded at
+	TheirLocktime uint32 // TheirCoin height
+
+	MyOp      wire.OutPoint // my htlc, once funded
+	TheirOp   wire.OutPoint // their htlc, once we've seen the tx
+	TheirConf int32         // height their htlc confirmed at
+
+	Created int64 // unix time
+}
+
+// StateName returns a readable swap state
+func (s *Swap) StateName() string {
+	if int(s.State) < len(swapStateNames) {
+		return swapStateNames[s.State]
+	}
+	return fmt.Sprintf("unknown %d", s.State)
+}
+
+// HavePreimage is true if we know the preimage
+func (s *Swap) HavePreimage() bool {
+	return sha256.Sum256(s.Preimage[:]) == s.Hash
+}
+
+// MyScript is the script of my htlc
+func (s *Swap) MyScript() []byte {
+	return lnutil.SwapHTLCScript(
+		s.Hash, s.TheirClaimPub, s.MyRefundPub, s.MyLocktime)
+}
+
+// TheirScript is the script of their htlc
+func (s *Swap) TheirScript() []byte {
+	return lnutil.SwapHTLCScript(
+		s.Hash, s.MyClaimPub, s.TheirRefundPub, s.TheirLocktime)
+}
+
+// ToBytes serializes a swap
+func (s *Swap) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(s.Hash[:])
+	buf.Write(s.Preimage[:])
+	binary.Write(&buf, binary.BigEndian, s.Initiator)
+	binary.Write(&buf, binary.BigEndian, s.State)
+	binary.Write(&buf, binary.BigEndian, s.PeerIdx)
+	binary.Write(&buf, binary.BigEndian, s.Idx)
+	binary.Write(&buf, binary.BigEndian, s.MyCoin)
+	binary.Write(&buf, binary.BigEndian, s.TheirCoin)
+	binary.Write(&buf, binary.BigEndian, s.MyAmt)
+	binary.Write(&buf, binary.BigEndian, s.TheirAmt)
+	buf.Write(s.MyRefundPub[:])
+	buf.Write(s.MyClaimPub[:])
+	buf.Write(s.TheirRefundPub[:])
+	buf.Write(s.TheirClaimPub[:])
+	binary.Write(&buf, binary.BigEndian, s.MyLocktime)
+	binary.Write(&buf, binary.BigEndian, s.TheirLocktime)
+	myOp := lnutil.OutPointToBytes(s.MyOp)
+	buf.Write(myOp[:])
+	theirOp := lnutil.OutPointToBytes(s.TheirOp)
+	buf.Write(theirOp[:])
+	binary.Write(&buf, binary.BigEndian, s.TheirConf)
+	binary.Write(&buf, binary.BigEndian, s.Created)
+	return buf.Bytes()
+}
+
+// SwapFromBytes deserializes a swap
+func SwapFromBytes(b []byte) (*Swap, error) {
+	if len(b) < 322 {
+		return nil, fmt.Errorf("%d bytes, swap needs 322", len(b))
+	}
+	s := new(Swap)
+	buf := bytes.NewBuffer(b)
+	copy(s.Hash[:], buf.Next(32))
+	copy(s.Preimage[:], buf.Next(32))
+	binary.Read(buf, binary.BigEndian, &s.Initiator)
+	binary.Read(buf, binary.BigEndian, &s.State)
+	binary.Read(buf, binary.BigEndian, &s.PeerIdx)
+	binary.Read(buf, binary.BigEndian, &s.Idx)
+	binary.Read(buf, binary.BigEndian, &s.MyCoin)
+	binary.Read(buf, binary.BigEndian, &s.TheirCoin)
+	binary.Read(buf, binary.BigEndian, &s.MyAmt)
+	binary.Read(buf, binary.BigEndian, &s.TheirAmt)
+	copy(s.MyRefundPub[:], buf.Next(33))
+	copy(s.MyClaimPub[:], buf.Next(33))
+	copy(s.TheirRefundPub[:], buf.Next(33))
+	copy(s.TheirClaimPub[:], buf.Next(33))
+	binary.Read(buf, binary.BigEndian, &s.MyLocktime)
+	binary.Read(buf, binary.BigEndian, &s.TheirLocktime)
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	s.MyOp = *lnutil.OutPointFromBytes(op)
+	copy(op[:], buf.Next(36))
+	s.TheirOp = *lnutil.OutPointFromBytes(op)
+	binary.Read(buf, binary.BigEndian, &s.TheirConf)
+	binary.Read(buf, binary.BigEndian, &s.Created)
+	return s, nil
+}
+
+// SaveSwap writes a swap to the db, keyed by hash
+func (nd *LitNode) SaveSwap(s *Swap) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSwaps)
+		if sb == nil {
+			return fmt.Errorf("no swap bucket")
+		}
+		return sb.Put(s.Hash[:], s.ToBytes())
+	})
+}
+
+// GetSwap loads a swap by hash
+func (nd *LitNode) GetSwap(hash [32]byte) (*Swap, error) {
+	var s *Swap
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSwaps)
+		if sb == nil {
+			return fmt.Errorf("no swap bucket")
+		}
+		b := sb.Get(hash[:])
+		if b == nil {
+			return fmt.Errorf("no swap with hash %x", hash)
+		}
+		var err error
+		s, err = SwapFromBytes(b)
+		return err
+	})
+	return s, err
+}
+
+// ListSwaps returns all swaps
+func (nd *LitNode) ListSwaps() ([]*Swap, error) {
+	var swaps []*Swap
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSwaps)
+		if sb == nil {
+			return fmt.Errorf("no swap bucket")
+		}
+		return sb.ForEach(func(k, v []byte) error {
+			s, err := SwapFromBytes(v)
+			if err != nil {
+				return err
+			}
+			swaps = append(swaps, s)
+			return nil
+		})
+	})
+	return swaps, err
+}
+
+// nextSwapIdx returns a new index for swap key derivation
+func (nd *LitNode) nextSwapIdx() (uint32, error) {
+	var idx uint32
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		sb := btx.Bucket(BKTSwaps)
+		if sb == nil {
+			return fmt.Errorf("no swap bucket")
+		}
+		n, err := sb.NextSequence()
+		idx = uint32(n)
+		return err
+	})
+	return idx, err
+}
+
+// swapKeyGen is the path for a swap key on a coin
+func swapKeyGen(coin, peerIdx, idx, use uint32) portxo.KeyGen {
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = coin | 1<<31
+	kg.Step[2] = use
+	kg.Step[3] = peerIdx | 1<<31
+	kg.Step[4] = idx | 1<<31
+	return kg
+}
+
+// setSwapKeys derives our keys for a swap, which needs Idx set
+func (nd *LitNode) setSwapKeys(s *Swap) {
+	refund := nd.SubWallet[s.MyCoin].GetPub(
+		swapKeyGen(s.MyCoin, s.PeerIdx, s.Idx, UseSwapRefund))
+	copy(s.MyRefundPub[:], refund.SerializeCompressed())
+	claim := nd.SubWallet[s.TheirCoin].GetPub(
+		swapKeyGen(s.TheirCoin, s.PeerIdx, s.Idx, UseSwapClaim))
+	copy(s.MyClaimPub[:], claim.SerializeCompressed())
+}
+
+// blocksLeft returns how many blocks and how much time until a coin's chain
+// reaches a height, going by the coin's block interval
+func (nd *LitNode) blocksLeft(coin, height uint32) (int32, time.Duration) {
+	wal := nd.SubWallet[coin]
+	blocks := int32(height) - wal.CurrentHeight()
+	return blocks, time.Duration(blocks) * wal.Params().TargetTimePerBlock
+}
+
+// swapWallets checks that we can deal in both coins
+func (nd *LitNode) swapWallets(myCoin, theirCoin uint32) error {
+	if myCoin == theirCoin {
+		return fmt.Errorf("can't swap coin type %d for itself", myCoin)
+	}
+	for _, c := range []uint32{myCoin, theirCoin} {
+		if _, ok := nd.SubWallet[c]; !ok {
+			return fmt.Errorf("no wallet of type %d connected", c)
+		}
+	}
+	return nil
+}
+
+// OfferSwap offers myAmt of myCoin for theirAmt of theirCoin to a peer.
+// lockBlocks is how long (in myCoin blocks) before we can get our coins back
+// if the swap doesn't go through; 0 for the default.  Returns right away;
+// the rest of the swap happens as messages and blocks come in.
+func (nd *LitNode) OfferSwap(peerIdx, myCoin uint32, myAmt int64,
+	theirCoin uint32, theirAmt int64, lockBlocks int32) (*Swap, error) {
+
+	nd.SwapMtx.Lock()
+	defer nd.SwapMtx.Unlock()
+
+	err := nd.swapWallets(myCoin, theirCoin)
+	if err != nil {
+		return nil, err
+	}
+	if myAmt < minOutput || theirAmt < minOutput {
+		return nil, fmt.Errorf("swap amounts must be at least %d", minOutput)
+	}
+	if !nd.ConnectedToPeer(peerIdx) {
+		return nil, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	if lockBlocks == 0 {
+		lockBlocks = defaultSwapLock
+	}
+	if lockBlocks < 2*minSwapBlocks {
+		return nil, fmt.Errorf("lock %d blocks too short, min %d",
+			lockBlocks, 2*minSwapBlocks)
+	}
+
+	s := new(Swap)
+	s.Initiator = true
+	s.PeerIdx = peerIdx
+	s.MyCoin, s.MyAmt = myCoin, myAmt
+	s.TheirCoin, s.TheirAmt = theirCoin, theirAmt
+	s.MyLocktime = uint32(nd.SubWallet[myCoin].CurrentHeight() + lockBlocks)
+	s.Created = time.Now().Unix()
+	_, err = rand.Read(s.Preimage[:])
+	if err != nil {
+		return nil, err
+	}
+	s.Hash = sha256.Sum256(s.Preimage[:])
+	s.Idx, err = nd.nextSwapIdx()
+	if err != nil {
+		return nil, err
+	}
+	nd.setSwapKeys(s)
+
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg lnutil.SwapOfferMsg
+	msg.PeerIdx = peerIdx
+	msg.Hash = s.Hash
+	msg.OfferCoin, msg.OfferAmt = myCoin, myAmt
+	msg.WantCoin, msg.WantAmt = theirCoin, theirAmt
+	msg.RefundPub = s.MyRefundPub
+	msg.ClaimPub = s.MyClaimPub
+	msg.Locktime = s.MyLocktime
+	nd.OmniOut <- msg
+
+	return s, nil
+}
+
+// AcceptSwap accepts a swap a peer offered us.
+func (nd *LitNode) AcceptSwap(hash [32]byte) (*Swap, error) {
+	nd.SwapMtx.Lock()
+	defer nd.SwapMtx.Unlock()
+
+	s, err := nd.GetSwap(hash)
+	if err != nil {
+		return nil, err
+	}
+	if s.Initiator || s.State != SwapStateOffered {
+		return nil, fmt.Errorf("swap %x is %s, can't accept", hash, s.StateName())
+	}
+	err = nd.swapWallets(s.MyCoin, s.TheirCoin)
+	if err != nil {
+		return nil, err
+	}
+	if !nd.ConnectedToPeer(s.PeerIdx) {
+		return nil, fmt.Errorf("not connected to peer %d", s.PeerIdx)
+	}
+
+	// our locktime is about half of the time they have left on theirs
+	_, theirLeft := nd.blocksLeft(s.TheirCoin, s.TheirLocktime)
+	myParam := nd.SubWallet[s.MyCoin].Params()
+	myBlocks := int32(theirLeft / 2 / myParam.TargetTimePerBlock)
+	if myBlocks < minSwapBlocks {
+		return nil, fmt.Errorf("offer's locktime too soon; would only have %d blocks",
+			myBlocks)
+	}
+	s.MyLocktime = uint32(nd.SubWallet[s.MyCoin].CurrentHeight() + myBlocks)
+
+	s.Idx, err = nd.nextSwapIdx()
+	if err != nil {
+		return nil, err
+	}
+	nd.setSwapKeys(s)
+	s.State = SwapStateAccepted
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg lnutil.SwapAcceptMsg
+	msg.PeerIdx = s.PeerIdx
+	msg.Hash = s.Hash
+	msg.RefundPub = s.MyRefundPub
+	msg.ClaimPub = s.MyClaimPub
+	msg.Locktime = s.MyLocktime
+	nd.OmniOut <- msg
+
+	return s, nil
+}
+
+// SwapHandler handles swap messages from peers
+func (nd *LitNode) SwapHandler(msg lnutil.LitMsg) error {
+	nd.SwapMtx.Lock()
+	defer nd.SwapMtx.Unlock()
+
+	switch msg.MsgType() {
+	case lnutil.MSGID_SWAP_OFFER:
+		return nd.SwapOfferHandler(msg.(lnutil.SwapOfferMsg))
+	case lnutil.MSGID_SWAP_ACCEPT:
+		return nd.SwapAcceptHandler(msg.(lnutil.SwapAcceptMsg))
+	case lnutil.MSGID_SWAP_FUNDED:
+		return nd.SwapFundedHandler(msg.(lnutil.SwapFundedMsg))
+	}
+	return fmt.Errorf("unknown swap message %x", msg.MsgType())
+}
+
+// SwapOfferHandler saves an incoming offer for the user to accept or not
+func (nd *LitNode) SwapOfferHandler(msg lnutil.SwapOfferMsg) error {
+	_, err := nd.GetSwap(msg.Hash)
+	if err == nil {
+		return fmt.Errorf("already have swap %x", msg.Hash)
+	}
+	err = nd.swapWallets(msg.WantCoin, msg.OfferCoin)
+	if err != nil {
+		return err
+	}
+
+	s := new(Swap)
+	s.Hash = msg.Hash
+	s.PeerIdx = msg.Peer()
+	s.MyCoin, s.MyAmt = msg.WantCoin, msg.WantAmt
+	s.TheirCoin, s.TheirAmt = msg.OfferCoin, msg.OfferAmt
+	s.TheirRefundPub = msg.RefundPub
+	s.TheirClaimPub = msg.ClaimPub
+	s.TheirLocktime = msg.Locktime
+	s.Created = time.Now().Unix()
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("peer %d offers %d of coin %d for %d of coin %d, swap %x\n",
+		s.PeerIdx, s.TheirAmt, s.TheirCoin, s.MyAmt, s.MyCoin, s.Hash)
+	return nil
+}
+
+// SwapAcceptHandler checks the accepter's locktime and funds our htlc
+func (nd *LitNode) SwapAcceptHandler(msg lnutil.SwapAcceptMsg) error {
+	s, err := nd.GetSwap(msg.Hash)
+	if err != nil {
+		return err
+	}
+	if !s.Initiator || s.State != SwapStateOffered || s.PeerIdx != msg.Peer() {
+		return fmt.Errorf("unexpected accept for swap %x", msg.Hash)
+	}
+
+	// their htlc has to be refundable well before ours, or they could
+	// wait, claim ours and refund theirs
+	theirBlocks, theirLeft := nd.blocksLeft(s.TheirCoin, msg.Locktime)
+	_, myLeft := nd.blocksLeft(s.MyCoin, s.MyLocktime)
+	if theirBlocks < minSwapBlocks || theirLeft*4 > myLeft*3 {
+		return fmt.Errorf("swap %x: their locktime %d (%s) too close to ours (%s)",
+			s.Hash, msg.Locktime, theirLeft, myLeft)
+	}
+
+	s.TheirRefundPub = msg.RefundPub
+	s.TheirClaimPub = msg.ClaimPub
+	s.TheirLocktime = msg.Locktime
+	s.State = SwapStateAccepted
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+	return nd.fundSwap(s)
+}
+
+// SwapFundedHandler checks their htlc tx and starts watching for it
+func (nd *LitNode) SwapFundedHandler(msg lnutil.SwapFundedMsg) error {
+	s, err := nd.GetSwap(msg.Hash)
+	if err != nil {
+		return err
+	}
+	var empty wire.OutPoint
+	if s.State < SwapStateAccepted || s.TheirOp != empty ||
+		s.PeerIdx != msg.Peer() || msg.Tx == nil {
+		return fmt.Errorf("unexpected funding for swap %x", msg.Hash)
+	}
+
+	// find the htlc in the tx
+	script := lnutil.P2WSHify(s.TheirScript())
+	txid := msg.Tx.TxHash()
+	for i, out := range msg.Tx.TxOut {
+		if bytes.Equal(out.PkScript, script) && out.Value == s.TheirAmt {
+			s.TheirOp = *wire.NewOutPoint(&txid, uint32(i))
+		}
+	}
+	if s.TheirOp == empty {
+		return fmt.Errorf("swap %x funding tx %s has no matching htlc",
+			s.Hash, txid.String())
+	}
+
+	err = nd.SubWallet[s.TheirCoin].WatchThis(s.TheirOp)
+	if err != nil {
+		return err
+	}
+	return nd.SaveSwap(s)
+}
+
+// fundSwap sends out my htlc and tells the peer about it
+func (nd *LitNode) fundSwap(s *Swap) error {
+	wal := nd.SubWallet[s.MyCoin]
+	txo := wire.NewTxOut(s.MyAmt, lnutil.P2WSHify(s.MyScript()))
+	ops, err := wal.MaybeSend([]*wire.TxOut{txo}, true)
+	if err != nil {
+		return err
+	}
+	err = wal.ReallySend(&ops[0].Hash)
+	if err != nil {
+		return err
+	}
+	tx, err := wal.GetTx(&ops[0].Hash)
+	if err != nil {
+		return err
+	}
+	err = wal.WatchThis(*ops[0])
+	if err != nil {
+		return err
+	}
+
+	s.MyOp = *ops[0]
+	s.State = SwapStateFunded
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("swap %x funded htlc %s\n", s.Hash, s.MyOp.String())
+	nd.OmniOut <- lnutil.SwapFundedMsg{PeerIdx: s.PeerIdx, Hash: s.Hash, Tx: tx}
+	return nil
+}
+
+// swapOPEvent handles an outpoint event for a swap htlc.  Returns false if
+// the outpoint isn't part of any swap.
+func (nd *LitNode) swapOPEvent(ev lnutil.OutPointEvent) bool {
+	nd.SwapMtx.Lock()
+	defer nd.SwapMtx.Unlock()
+
+	swaps, err := nd.ListSwaps()
+	if err != nil {
+		logger.Errorf("swapOPEvent %s\n", err.Error())
+		return false
+	}
+	var empty wire.OutPoint
+	for _, s := range swaps {
+		if s.MyOp != empty && lnutil.OutPointsEqual(s.MyOp, ev.Op) {
+			err = nd.myHTLCEvent(s, ev)
+		} else if s.TheirOp != empty && lnutil.OutPointsEqual(s.TheirOp, ev.Op) {
+			err = nd.theirHTLCEvent(s, ev)
+		} else {
+			continue
+		}
+		if err != nil {
+			logger.Errorf("swap %x: %s\n", s.Hash, err.Error())
+		}
+		return true
+	}
+	return false
+}
+
+// theirHTLCEvent: once their htlc confirms, fund ours (if we're the
+// accepter) or claim theirs (if we're the offerer)
+func (nd *LitNode) theirHTLCEvent(s *Swap, ev lnutil.OutPointEvent) error {
+	if ev.Tx != nil || ev.Height < 1 || s.TheirConf != 0 {
+		// spends are our claim or their refund; nothing to do
+		return nil
+	}
+	s.TheirConf = ev.Height
+	err := nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+	if !s.Initiator && s.State == SwapStateAccepted {
+		return nd.fundSwap(s)
+	}
+	if s.Initiator && s.State == SwapStateFunded {
+		return nd.claimSwap(s)
+	}
+	return nil
+}
+
+// myHTLCEvent: when my htlc is claimed, the preimage is in the witness;
+// use it to claim theirs
+func (nd *LitNode) myHTLCEvent(s *Swap, ev lnutil.OutPointEvent) error {
+	if ev.Tx == nil || s.State != SwapStateFunded || s.HavePreimage() {
+		return nil
+	}
+	for _, in := range ev.Tx.TxIn {
+		if !lnutil.OutPointsEqual(in.PreviousOutPoint, s.MyOp) {
+			continue
+		}
+		// claim witness is sig, preimage, 1, script
+		if len(in.Witness) == 4 && len(in.Witness[1]) == 32 {
+			copy(s.Preimage[:], in.Witness[1])
+		}
+	}
+	if !s.HavePreimage() {
+		return nil // spent some other way; our refund, probably
+	}
+	logger.Infof("swap %x got preimage from claim tx\n", s.Hash)
+	return nd.claimSwap(s)
+}
+
+// claimSwap takes their htlc with the preimage
+func (nd *LitNode) claimSwap(s *Swap) error {
+	err := nd.sweepSwap(s, true)
+	if err != nil {
+		return err
+	}
+	s.State = SwapStateClaimed
+	return nd.SaveSwap(s)
+}
+
+// refundSwap takes my htlc back after the locktime
+func (nd *LitNode) refundSwap(s *Swap) error {
+	err := nd.sweepSwap(s, false)
+	if err != nil {
+		return err
+	}
+	s.State = SwapStateRefunded
+	return nd.SaveSwap(s)
+}
+
+// sweepSwap builds, signs and sends a tx spending their htlc (claim) or
+// mine (refund) to a new wallet address
+func (nd *LitNode) sweepSwap(s *Swap, claim bool) error {
+	coin, op, amt, script, use :=
+		s.MyCoin, s.MyOp, s.MyAmt, s.MyScript(), uint32(UseSwapRefund)
+	if claim {
+		coin, op, amt, script, use =
+			s.TheirCoin, s.TheirOp, s.TheirAmt, s.TheirScript(), UseSwapClaim
+	}
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return fmt.Errorf("no wallet of type %d connected", coin)
+	}
+
+	// a claim has to confirm before they can refund; a refund races their
+	// claim, but there's no height it has to beat
+	var left int32
+	if claim {
+		left, _ = nd.blocksLeft(coin, s.TheirLocktime)
+		if left < 1 {
+			left = 1
+		}
+	}
+	feeRate, err := nd.SweepFeeRate(coin, SweepHTLC, left)
+	if err != nil {
+		return err
+	}
+	fee := feeRate * swapSweepSize
+	if amt-fee < minOutput {
+		return fmt.Errorf("htlc of %d too small to sweep with fee %d", amt, fee)
+	}
+	adr, err := wal.NewAdr()
+	if err != nil {
+		return err
+	}
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	in := wire.NewTxIn(&op, nil, nil)
+	if !claim {
+		// nlocktime is only enforced if the sequence isn't final
+		in.Sequence = wire.MaxTxInSequenceNum - 1
+		tx.LockTime = s.MyLocktime
+	}
+	tx.AddTxIn(in)
+	tx.AddTxOut(wire.NewTxOut(amt-fee, lnutil.DirectWPKHScriptFromPKH(adr)))
+
+	priv := wal.GetPriv(swapKeyGen(coin, s.PeerIdx, s.Idx, use))
+	hCache := txscript.NewTxSigHashes(tx)
+	sig, err := lnutil.RawTxInWitnessSignatureLowR(
+		tx, hCache, 0, amt, script, txscript.SigHashAll, priv)
+	if err != nil {
+		return err
+	}
+	if claim {
+		tx.TxIn[0].Witness = wire.TxWitness{sig, s.Preimage[:], {0x01}, script}
+	} else {
+		tx.TxIn[0].Witness = wire.TxWitness{sig, {}, script}
+	}
+
+	logger.Infof("swap %x sweep (claim %v) tx %s\n", s.Hash, claim, tx.TxHash())
+	return wal.PushTx(tx)
+}
+
+// SwapWatcher refunds my htlcs which weren't claimed by their locktime,
+// checking each new block.  Runs until shutdown.
+func (nd *LitNode) SwapWatcher() {
+	blocks := nd.blockWaiter()
+	for !nd.ShuttingDown() {
+		waitBlock(blocks, swapCheckInterval)
+		nd.refundExpiredSwaps()
+	}
+}
+
+func (nd *LitNode) refundExpiredSwaps() {
+	nd.SwapMtx.Lock()
+	defer nd.SwapMtx.Unlock()
+
+	swaps, err := nd.ListSwaps()
+	if err != nil {
+		logger.Errorf("refundExpiredSwaps %s\n", err.Error())
+		return
+	}
+	for _, s := range swaps {
+		if s.State != SwapStateFunded {
+			continue
+		}
+		wal, ok := nd.SubWallet[s.MyCoin]
+		if !ok || wal.CurrentHeight() < int32(s.MyLocktime) {
+			continue
+		}
+		logger.Infof("swap %x timed out, refunding\n", s.Hash)
+		err = nd.refundSwap(s)
+		if err != nil {
+			logger.Errorf("swap %x refund: %s\n", s.Hash, err.Error())
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package qln
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Not every tx lit sends on its own is in the same hurry.  Each kind, or
+sweep class, has a confirmation target in blocks, and its fee rate is
+what's expected to get it confirmed within that many:
+
+	justice      2    taking a breached channel's outputs; the other side
+	                  can take them back once their CSV runs out
+	htlc         6    claiming or refunding an HTLC.  Also never more than
+	                  half the blocks left to its deadline, when it has one
+	tolocal      36   moving a closed channel's to_local into the wallet
+	                  once its CSV is done; nobody else can spend it
+	consolidate  144  the Sweep RPC, moving utxos elsewhere
+
+Targets are set with sweeptarget=class:blocks, and can be changed while
+running, over RPC or by reloading the config.
+
+Fee rates come from FeeEstimate, if lit was started with feesource, and
+otherwise from the wallet's fee rate (--fee, or SetFee), taken as the rate
+for 6 blocks: twice that for 2 blocks or less, half for up to 36, a
+quarter for up to 144, an eighth past that, but never under 1 sat/byte.
+
+A fee source is coin:URL, where the URL returns json mapping targets to
+sat/vbyte, like esplora's /fee-estimates:
+	0:https://blockstream.info/api/fee-estimates
+The rate used is that of the largest target in the table not over the one
+wanted.  Tables are cached for feeEstimateRefresh; if a source stops
+answering its last table is kept, and with none the wallet rate is used.
+
+Closed channels' matured to_local outputs, and revoked outputs taken from
+a breach, are swept by SweepWatcher.  The to_local sweeps wait while fees
+are over feeceiling; the justice ones don't.
+*/
+
+// SweepClass is a kind of tx lit sends by itself, by how soon it needs to
+// confirm
+type SweepClass uint8
+
+const (
+	SweepJustice SweepClass = iota
+	SweepHTLC
+	SweepToLocal
+	SweepConsolidate
+
+	numSweepClasses
+)
+
+var sweepClassNames = [numSweepClasses]string{
+	"justice", "htlc", "tolocal", "consolidate"}
+
+func (c SweepClass) String() string {
+	if c >= numSweepClasses {
+		return fmt.Sprintf("class%d", c)
+	}
+	return sweepClassNames[c]
+}
+
+// ParseSweepClass takes a class's name
+func ParseSweepClass(s string) (SweepClass, error) {
+	for i, name := range sweepClassNames {
+		if strings.EqualFold(s, name) {
+			return SweepClass(i), nil
+		}
+	}
+	return 0, fmt.Errorf("no sweep class %q; classes are %s",
+		s, strings.Join(sweepClassNames[:], ", "))
+}
+
+// SweepTargets are the confirmation targets, in blocks, by class
+type SweepTargets [numSweepClasses]int32
+
+// DefaultSweepTargets are used for classes without a sweeptarget
+var DefaultSweepTargets = SweepTargets{2, 6, 36, 144}
+
+const (
+	sweepCheckInterval = 10 * time.Minute
+
+	feeEstimateRefresh = 5 * time.Minute
+	feeEstimateTimeout = 10 * time.Second
+
+	// the wallet's fee rate is taken to be for this many blocks
+	walletFeeTarget = 6
+	// about the vsize of a tx taking one revoked output to one p2wpkh
+	justiceTxSize = 125
+)
+
+// ParseSweepTarget takes class:blocks
+func ParseSweepTarget(s string) (SweepClass, int32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("sweep target %q isn't class:blocks", s)
+	}
+	class, err := ParseSweepClass(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	blocks, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil || blocks == 0 {
+		return 0, 0, fmt.Errorf("sweep target %q: blocks should be 1 to 65535", s)
+	}
+	return class, int32(blocks), nil
+}
+
+type sweepFees struct {
+	mtx     sync.Mutex
+	targets SweepTargets
+}
+
+// SetSweepTargets sets every class's target; any left 0 gets its default
+func (nd *LitNode) SetSweepTargets(t SweepTargets) {
+	for i := range t {
+		if t[i] < 1 {
+			t[i] = DefaultSweepTargets[i]
+		}
+	}
+	nd.sweepFees.mtx.Lock()
+	nd.sweepFees.targets = t
+	nd.sweepFees.mtx.Unlock()
+}
+
+// SetSweepTarget sets one class's target
+func (nd *LitNode) SetSweepTarget(class SweepClass, blocks int32) error {
+	if class >= numSweepClasses {
+		return fmt.Errorf("no sweep class %d", class)
+	}
+	if blocks < 1 {
+		return fmt.Errorf("sweep target of %d blocks", blocks)
+	}
+	nd.sweepFees.mtx.Lock()
+	nd.sweepFees.targets[class] = blocks
+	nd.sweepFees.mtx.Unlock()
+	return nil
+}
+
+// SweepTargets are each class's target
+func (nd *LitNode) SweepTargets() SweepTargets {
+	nd.sweepFees.mtx.Lock()
+	defer nd.sweepFees.mtx.Unlock()
+	return nd.sweepFees.targets
+}
+
+// SweepTarget is the target a class's tx is sent with.  left is how many
+// blocks it has before its deadline, 0 if it has none.
+func (nd *LitNode) SweepTarget(class SweepClass, left int32) int32 {
+	target := nd.SweepTargets()[class]
+	// leave half the time for a bump if it doesn't make it
+	if class == SweepHTLC && left > 0 && left/2 < target {
+		target = left / 2
+		if target < 1 {
+			target = 1
+		}
+	}
+	return target
+}
+
+// SweepFeeRate is the fee rate, in sat/byte, to send a class's tx on coin
+// with; left is as for SweepTarget
+func (nd *LitNode) SweepFeeRate(
+	coin uint32, class SweepClass, left int32) (int64, error) {
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return 0, fmt.Errorf("no wallet of type %d connected", coin)
+	}
+	target := nd.SweepTarget(class, left)
+	if nd.FeeEstimate != nil {
+		rate, err := nd.FeeEstimate(coin, target)
+		if err == nil {
+			return rate, nil
+		}
+		logger.Warnf("fee estimate for coin %d: %s; using wallet rate\n",
+			coin, err.Error())
+	}
+	return scaleFee(wal.Fee(), target), nil
+}
+
+// scaleFee guesses a rate for target from one for walletFeeTarget
+func scaleFee(rate int64, target int32) int64 {
+	switch {
+	case target <= 2:
+		rate *= 2
+	case target <= walletFeeTarget:
+	case target <= 36:
+		rate /= 2
+	case target <= 144:
+		rate /= 4
+	default:
+		rate /= 8
+	}
+	if rate < 1 {
+		rate = 1
+	}
+	return rate
+}
+
+type feeTable struct {
+	targets []int32 // ascending
+	rates   map[int32]float64
+	at      time.Time
+}
+
+type feeEstimator struct {
+	sources map[uint32]string
+	client  *http.Client
+
+	mtx   sync.Mutex
+	cache map[uint32]*feeTable
+}
+
+// StartFeeEstimates sets FeeEstimate to get rates from sources, given as
+// coin:URL.  Does nothing without any.
+func (nd *LitNode) StartFeeEstimates(sources []string) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	fe := &feeEstimator{
+		sources: make(map[uint32]string),
+		client:  lnutil.HTTPClient(feeEstimateTimeout),
+		cache:   make(map[uint32]*feeTable),
+	}
+	for _, s := range sources {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("fee source %q isn't coin:URL", s)
+		}
+		coin, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("fee source %q: %s", s, err.Error())
+		}
+		fe.sources[uint32(coin)] = parts[1]
+	}
+	nd.FeeEstimate = fe.estimate
+	return nil
+}
+
+// estimate is the sat/byte rate to confirm on coin within target blocks
+func (fe *feeEstimator) estimate(coin uint32, target int32) (int64, error) {
+	src, ok := fe.sources[coin]
+	if !ok {
+		return 0, fmt.Errorf("no fee source for coin %d", coin)
+	}
+	fe.mtx.Lock()
+	t := fe.cache[coin]
+	fe.mtx.Unlock()
+	if t == nil || time.Since(t.at) > feeEstimateRefresh {
+		fresh, err := fe.fetch(src)
+		if err != nil {
+			if t == nil {
+				return 0, err
+			}
+			// a stale table beats none
+			logger.Warnf("fee source %s: %s\n", src, err.Error())
+		} else {
+			t = fresh
+			fe.mtx.Lock()
+			fe.cache[coin] = t
+			fe.mtx.Unlock()
+		}
+	}
+
+	pick := t.targets[0]
+	for _, n := range t.targets {
+		if n > target {
+			break
+		}
+		pick = n
+	}
+	rate := int64(math.Ceil(t.rates[pick]))
+	if rate < 1 {
+		rate = 1
+	}
+	return rate, nil
+}
+
+// fetch gets a table of target blocks to sat/vbyte
+func (fe *feeEstimator) fetch(src string) (*feeTable, error) {
+	resp, err := fe.client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var m map[string]float64
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	t := &feeTable{rates: make(map[int32]float64), at: time.Now()}
+	for k, v := range m {
+		n, err := strconv.ParseUint(k, 10, 16)
+		if err != nil || n == 0 || v < 0 {
+			continue
+		}
+		t.targets = append(t.targets, int32(n))
+		t.rates[int32(n)] = v
+	}
+	if len(t.targets) == 0 {
+		return nil, fmt.Errorf("no fee estimates from %s", src)
+	}
+	sort.Slice(t.targets, func(i, j int) bool {
+		return t.targets[i] < t.targets[j]
+	})
+	return t, nil
+}
+
+// SweepWatcher sweeps closed channels' outputs the wallet's been given
+// into plain ones: revoked ones right away, to_local ones once their CSV
+// is done.  Runs until shutdown.
+func (nd *LitNode) SweepWatcher() {
+	blocks := nd.blockWaiter()
+	for !nd.ShuttingDown() {
+		waitBlock(blocks, sweepCheckInterval)
+		for coin, wal := range nd.SubWallet {
+			nd.sweepChanOuts(coin, wal)
+		}
+	}
+}
+
+// sweepChanOuts sweeps what's ready on one wallet
+func (nd *LitNode) sweepChanOuts(coin uint32, wal UWallet) {
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		logger.Errorf("sweep coin %d: %s\n", coin, err.Error())
+		return
+	}
+	height := wal.CurrentHeight()
+	// to_local sweeps can wait out a fee spike; see feeguard.go
+	spiking, _ := nd.FeeSpiking(coin)
+	for _, u := range utxos {
+		var class SweepClass
+		switch {
+		case u.Seq == 1 && u.Height > 0: // revoked; see GetCloseTxos
+			class = SweepJustice
+		case u.Hint == portxo.SpendHintCSV && u.Mature(height) && !spiking:
+			class = SweepToLocal
+		default:
+			continue
+		}
+		rate, err := nd.SweepFeeRate(coin, class, 0)
+		if err != nil {
+			logger.Errorf("sweep %s: %s\n", u.Op.String(), err.Error())
+			continue
+		}
+		txid, err := wal.SweepTxo(u.Op, rate)
+		if err != nil {
+			logger.Warnf("sweep %s: %s\n", u.Op.String(), err.Error())
+			continue
+		}
+		logger.Infof("swept %s (%s) at %d sat/byte in %s\n",
+			u.Op.String(), class.String(), rate, txid.String())
+	}
+}
@@ -0,0 +1,411 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A phone app gets a few seconds in the background to catch up, not enough
+to replay the event stream or list everything.  So the node keeps a log of
+what's changed, numbered, and SyncChanges hands back whatever's changed
+since the number the app got last time.
+
+Only the latest change to each thing is kept: each coin's tip, each
+wallet output's state, each channel's state, each paid invoice.  When a
+thing changes again its old entry goes, so the log grows with how many
+things there are, not how many changes, and an app that's been away a
+week gets one entry per channel, not one per payment.  Asking from 0
+gets all of it, a snapshot.
+
+	block    a coin's wallet height and confirmed balance
+	txo      a wallet output's state and value
+	channel  a channel's state number, balance, and if it's closed
+	invoice  an invoice being paid
+
+Wallet outputs are looked at each block, and at startup, so ones seen
+between blocks show up with the next.  Channels are logged as they're
+saved, and checked at startup.  Invoice entries go after syncKeepInvoices;
+an app that last asked before one that's gone gets Reset, meaning it may
+have missed invoices and should list them.
+*/
+
+// how long paid invoices stay in the sync log
+const syncKeepInvoices = 30 * 24 * time.Hour
+
+// kinds of sync change; also the first byte of their keys in BKTSyncKeys
+const (
+	syncBlock = iota + 1
+	syncTxo
+	syncChannel
+	syncInvoice
+)
+
+var syncKindNames = []string{"", "block", "txo", "channel", "invoice"}
+
+// KEYSyncFloor in BKTSyncKeys is the last sequence number trimmed from the
+// sync log
+var KEYSyncFloor = []byte("flr")
+
+// SyncChange is the latest change to one thing.  Which fields mean
+// anything goes by Kind.
+type SyncChange struct {
+	Seq  uint64
+	Kind string // block, txo, channel or invoice
+	Time int64  // unix seconds
+
+	CoinType uint32 // all but invoices
+	// block: the wallet's height.  txo: the block it's in, or its spend's
+	// in once spent.  channel: the close's block, once closed.
+	Height int32
+	// block: confirmed balance.  txo: value.  channel: my balance.
+	// invoice: amount paid.
+	Amt int64
+
+	OutPoint string // txo; channel's funding outpoint
+	State    string // txo: as in TxoList.  channel: open or closed.
+
+	PeerIdx  uint32 // channel
+	ChanIdx  uint32 // channel
+	StateIdx uint64 // channel
+	Capacity int64  // channel
+
+	PaymentHash string // invoice
+}
+
+// SyncDelta is what's changed since a sequence number
+type SyncDelta struct {
+	Changes []SyncChange
+	Seq     uint64 // ask from this next time
+	More    bool   // stopped at the most asked for; ask again from Seq
+	// some changes since are gone, or since isn't from this log; list
+	// invoices again
+	Reset bool
+}
+
+// sameAs says if two changes say the same, whenever they were
+func (c SyncChange) sameAs(d SyncChange) bool {
+	c.Seq, c.Time, d.Seq, d.Time = 0, 0, 0, 0
+	return c == d
+}
+
+// syncChangeBytes serializes a change with the key of what it's about:
+// key length (1), key, kind (1), time (8), coin (4), height (4), amt (8),
+// peer (4), channel (4), state index (8), capacity (8), then state,
+// outpoint and payment hash each with a length byte.  The seq is its key
+// in the log.
+func syncChangeBytes(key []byte, c SyncChange) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(len(key)))
+	buf.Write(key)
+	kind := 0
+	for i, name := range syncKindNames {
+		if name == c.Kind {
+			kind = i
+		}
+	}
+	buf.WriteByte(byte(kind))
+	binary.Write(&buf, binary.BigEndian, c.Time)
+	binary.Write(&buf, binary.BigEndian, c.CoinType)
+	binary.Write(&buf, binary.BigEndian, c.Height)
+	binary.Write(&buf, binary.BigEndian, c.Amt)
+	binary.Write(&buf, binary.BigEndian, c.PeerIdx)
+	binary.Write(&buf, binary.BigEndian, c.ChanIdx)
+	binary.Write(&buf, binary.BigEndian, c.StateIdx)
+	binary.Write(&buf, binary.BigEndian, c.Capacity)
+	for _, s := range []string{c.State, c.OutPoint, c.PaymentHash} {
+		buf.WriteByte(byte(len(s)))
+		buf.WriteString(s)
+	}
+	return buf.Bytes()
+}
+
+// syncChangeFromBytes deserializes a change and the key of what it's about
+func syncChangeFromBytes(b []byte) ([]byte, SyncChange, error) {
+	var c SyncChange
+	if len(b) < 1 || len(b) < 1+int(b[0])+52 {
+		return nil, c, fmt.Errorf("%d bytes, sync change too short", len(b))
+	}
+	key := b[1 : 1+b[0]]
+	buf := bytes.NewBuffer(b[1+b[0]:])
+	kind, _ := buf.ReadByte()
+	if kind == 0 || int(kind) >= len(syncKindNames) {
+		return nil, c, fmt.Errorf("unknown sync change kind %d", kind)
+	}
+	c.Kind = syncKindNames[kind]
+	binary.Read(buf, binary.BigEndian, &c.Time)
+	binary.Read(buf, binary.BigEndian, &c.CoinType)
+	binary.Read(buf, binary.BigEndian, &c.Height)
+	binary.Read(buf, binary.BigEndian, &c.Amt)
+	binary.Read(buf, binary.BigEndian, &c.PeerIdx)
+	binary.Read(buf, binary.BigEndian, &c.ChanIdx)
+	binary.Read(buf, binary.BigEndian, &c.StateIdx)
+	binary.Read(buf, binary.BigEndian, &c.Capacity)
+	var strs [3]string
+	for i := range strs {
+		n, err := buf.ReadByte()
+		if err != nil || buf.Len() < int(n) {
+			return nil, c, fmt.Errorf("sync change cut off")
+		}
+		strs[i] = string(buf.Next(int(n)))
+	}
+	c.State, c.OutPoint, c.PaymentHash = strs[0], strs[1], strs[2]
+	return key, c, nil
+}
+
+// getSyncChange gets the latest change to what key's about; false if
+// there's none
+func getSyncChange(btx *bolt.Tx, key []byte) (SyncChange, bool) {
+	kb := btx.Bucket(BKTSyncKeys)
+	lb := btx.Bucket(BKTSyncLog)
+	if kb == nil || lb == nil {
+		return SyncChange{}, false
+	}
+	seq := kb.Get(key)
+	if seq == nil {
+		return SyncChange{}, false
+	}
+	_, c, err := syncChangeFromBytes(lb.Get(seq))
+	if err != nil {
+		return SyncChange{}, false
+	}
+	c.Seq = binary.BigEndian.Uint64(seq)
+	return c, true
+}
+
+// putSyncChange logs a change to what key's about, dropping the one before.
+// Does nothing if it's no change.
+func putSyncChange(btx *bolt.Tx, key []byte, c SyncChange) error {
+	old, ok := getSyncChange(btx, key)
+	if ok && old.sameAs(c) {
+		return nil
+	}
+	kb := btx.Bucket(BKTSyncKeys)
+	lb := btx.Bucket(BKTSyncLog)
+	if kb == nil || lb == nil {
+		return fmt.Errorf("no sync log")
+	}
+	if ok {
+		err := lb.Delete(lnutil.U64tB(old.Seq))
+		if err != nil {
+			return err
+		}
+	}
+	seq, err := lb.NextSequence()
+	if err != nil {
+		return err
+	}
+	if c.Time == 0 {
+		c.Time = time.Now().Unix()
+	}
+	err = lb.Put(lnutil.U64tB(seq), syncChangeBytes(key, c))
+	if err != nil {
+		return err
+	}
+	return kb.Put(key, lnutil.U64tB(seq))
+}
+
+// trimSync drops invoice changes older than syncKeepInvoices
+func trimSync(btx *bolt.Tx, now time.Time) error {
+	kb := btx.Bucket(BKTSyncKeys)
+	lb := btx.Bucket(BKTSyncLog)
+	if kb == nil || lb == nil {
+		return nil
+	}
+	cutoff := now.Add(-syncKeepInvoices).Unix()
+	var floor uint64
+	var gone [][]byte
+	cur := lb.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		key, c, err := syncChangeFromBytes(v)
+		if err != nil {
+			continue
+		}
+		if c.Kind == syncKindNames[syncInvoice] && c.Time < cutoff {
+			gone = append(gone,
+				append([]byte{}, k...), append([]byte{}, key...))
+			if seq := binary.BigEndian.Uint64(k); seq > floor {
+				floor = seq
+			}
+		}
+	}
+	for i := 0; i < len(gone); i += 2 {
+		err := lb.Delete(gone[i])
+		if err != nil {
+			return err
+		}
+		err = kb.Delete(gone[i+1])
+		if err != nil {
+			return err
+		}
+	}
+	old := kb.Get(KEYSyncFloor)
+	if floor == 0 || (old != nil && binary.BigEndian.Uint64(old) >= floor) {
+		return nil
+	}
+	return kb.Put(KEYSyncFloor, lnutil.U64tB(floor))
+}
+
+// SyncChanges returns what's changed since seq, up to max changes; 0 for
+// no limit
+func (nd *LitNode) SyncChanges(since uint64, max uint32) (SyncDelta, error) {
+	var d SyncDelta
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		kb := btx.Bucket(BKTSyncKeys)
+		lb := btx.Bucket(BKTSyncLog)
+		if kb == nil || lb == nil {
+			return fmt.Errorf("no sync log")
+		}
+		d.Seq = lb.Sequence()
+		if since > d.Seq {
+			// from before a restore, or another node
+			since = 0
+			d.Reset = true
+		}
+		floor := kb.Get(KEYSyncFloor)
+		if since != 0 && floor != nil &&
+			since < binary.BigEndian.Uint64(floor) {
+			d.Reset = true
+		}
+		cur := lb.Cursor()
+		for k, v := cur.Seek(lnutil.U64tB(since + 1)); k != nil; k, v = cur.Next() {
+			if max != 0 && uint32(len(d.Changes)) == max {
+				d.More = true
+				break
+			}
+			_, c, err := syncChangeFromBytes(v)
+			if err != nil {
+				return err
+			}
+			c.Seq = binary.BigEndian.Uint64(k)
+			d.Changes = append(d.Changes, c)
+		}
+		if d.More {
+			d.Seq = d.Changes[len(d.Changes)-1].Seq
+		}
+		return nil
+	})
+	return d, err
+}
+
+// chanSyncChange is the sync change for a channel as it is now
+func chanSyncChange(q *Qchan) ([]byte, SyncChange) {
+	c := SyncChange{
+		Kind:     syncKindNames[syncChannel],
+		CoinType: q.Coin(),
+		OutPoint: q.Op.String(),
+		State:    "open",
+		PeerIdx:  q.Peer(),
+		ChanIdx:  q.Idx(),
+		Capacity: q.Value,
+	}
+	c.StateIdx = q.State.StateIdx
+	c.Amt = q.State.MyAmt
+	if q.CloseData.Closed {
+		c.State = "closed"
+		c.Height = q.CloseData.CloseHeight
+	}
+	return append([]byte{syncChannel}, lnutil.U32tB(q.Idx())...), c
+}
+
+// syncChannel logs a channel's state, if it's changed.  Channels without a
+// state loaded are left for when there is one.
+func (nd *LitNode) syncChannel(q *Qchan) {
+	if q.State == nil {
+		return
+	}
+	key, c := chanSyncChange(q)
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		return putSyncChange(btx, key, c)
+	})
+	if err != nil {
+		logger.Warnf("sync log channel %d: %s\n", q.Idx(), err.Error())
+	}
+}
+
+// syncInvoice logs an invoice being paid, in the transaction settling it
+func (nd *LitNode) syncInvoice(btx *bolt.Tx, inv *Invoice) error {
+	c := SyncChange{
+		Kind:        syncKindNames[syncInvoice],
+		Time:        inv.SettledAt,
+		Amt:         inv.AmtPaid,
+		PaymentHash: hex.EncodeToString(inv.PaymentHash[:]),
+	}
+	key := append([]byte{syncInvoice}, inv.PaymentHash[:]...)
+	return putSyncChange(btx, key, c)
+}
+
+// syncWallet logs a wallet's height, balance and outputs, where changed,
+// and trims the log
+func (nd *LitNode) syncWallet(wal UWallet) error {
+	coin := wal.Params().HDCoinType
+	txos, err := wal.TxoStates()
+	if err != nil {
+		return err
+	}
+	height := wal.CurrentHeight()
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		var bal int64
+		for _, t := range txos {
+			if t.State == lnutil.TxoConfirmed {
+				bal += t.Value
+			}
+			opArr := lnutil.OutPointToBytes(t.Op)
+			err := putSyncChange(btx, append([]byte{syncTxo}, opArr[:]...),
+				SyncChange{
+					Kind:     syncKindNames[syncTxo],
+					CoinType: coin,
+					Height:   t.Height,
+					Amt:      t.Value,
+					OutPoint: t.Op.String(),
+					State:    t.State.String(),
+				})
+			if err != nil {
+				return err
+			}
+		}
+		err := putSyncChange(btx, append([]byte{syncBlock}, lnutil.U32tB(coin)...),
+			SyncChange{
+				Kind:     syncKindNames[syncBlock],
+				CoinType: coin,
+				Height:   height,
+				Amt:      bal,
+			})
+		if err != nil {
+			return err
+		}
+		return trimSync(btx, time.Now())
+	})
+}
+
+// syncChanges keeps the sync log up to date with a wallet and its coin's
+// channels: channels once at startup, since they're logged as they're
+// saved after that, and the wallet every block.  Runs until shutdown.
+func (nd *LitNode) syncChanges(wal UWallet) {
+	sub := wal.Notifier().WatchBlocks()
+	defer sub.Cancel()
+
+	qs, err := nd.GetAllQchans()
+	if err != nil {
+		logger.Warnf("sync log channels: %s\n", err.Error())
+	}
+	for _, q := range qs {
+		if q.Coin() == wal.Params().HDCoinType {
+			nd.syncChannel(q)
+		}
+	}
+	for !nd.ShuttingDown() {
+		err := nd.syncWallet(wal)
+		if err != nil {
+			logger.Warnf("sync log coin %d: %s\n",
+				wal.Params().HDCoinType, err.Error())
+		}
+		<-sub.Events
+	}
+}
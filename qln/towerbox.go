@@ -0,0 +1,199 @@
+package qln
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/adiabat/btcutil/hdkeychain"
+	"github.com/boltdb/bolt"
+	"github.com/codahale/chacha20poly1305"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Messages for the watchtower -- channel descriptions, justice data and
+unwatches -- go through an outbox in BKTTowerBox, so they're kept until
+the tower has them even if it's not connected.  They're sealed with a key
+derived from the seed, so a copy of the datadir alone doesn't show which
+channels are being watched or their revocation data.
+
+Entries are keyed by an 8 byte sequence number, so they go out in the
+order they were queued, and each is a random nonce then the ciphertext.
+*/
+
+// sealKey derives a symmetric key from the root key, on a path of its own
+// next to the identity key's.  The outbox uses branch 10, channel
+// backups 11.
+func sealKey(root *hdkeychain.ExtendedKey, branch uint32) ([32]byte, error) {
+	var key [32]byte
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = 513 | 1<<31
+	kg.Step[2] = branch | 1<<31
+	kg.Step[3] = 0 | 1<<31
+	kg.Step[4] = 0 | 1<<31
+	priv, err := kg.DerivePrivateKey(root)
+	if err != nil {
+		return key, err
+	}
+	key = sha256.Sum256(priv.Serialize())
+	return key, nil
+}
+
+// sealTowerMsg encrypts a tower message for the outbox
+func (nd *LitNode) sealTowerMsg(msg []byte) ([]byte, error) {
+	return seal(nd.towerBoxKey, msg)
+}
+
+// openTowerMsg decrypts an outbox entry
+func (nd *LitNode) openTowerMsg(box []byte) ([]byte, error) {
+	return unseal(nd.towerBoxKey, box)
+}
+
+// seal encrypts with a random nonce, which goes in front
+func seal(key [32]byte, msg []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, msg, nil), nil
+}
+
+// unseal decrypts what seal made
+func unseal(key [32]byte, box []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(box) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("sealed box %d bytes, too short", len(box))
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, box[:n], box[n:], nil)
+}
+
+// queueTowerMsgs adds messages to the end of the tower outbox, all or none
+func (nd *LitNode) queueTowerMsgs(msgs ...lnutil.LitMsg) error {
+	boxes := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		box, err := nd.sealTowerMsg(msg.Bytes())
+		if err != nil {
+			return err
+		}
+		boxes[i] = box
+	}
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		tbx := btx.Bucket(BKTTowerBox)
+		if tbx == nil {
+			return fmt.Errorf("no tower outbox")
+		}
+		for _, box := range boxes {
+			seq, err := tbx.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = tbx.Put(lnutil.U64tB(seq), box)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TowerBoxLen is how many messages are waiting for the tower
+func (nd *LitNode) TowerBoxLen() (int, error) {
+	var n int
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		tbx := btx.Bucket(BKTTowerBox)
+		if tbx == nil {
+			return fmt.Errorf("no tower outbox")
+		}
+		n = tbx.Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// FlushTowerBox sends what's in the outbox to the tower, in order, taking
+// each out once it's written.  Does nothing without a tower connection.
+// A backlog goes towerFlushBatch at a time, compressed if the tower takes
+// that; see compress.go.  With towers added it goes to those instead; see
+// towerset.go.
+func (nd *LitNode) FlushTowerBox() error {
+	nd.towerBoxMtx.Lock()
+	defer nd.towerBoxMtx.Unlock()
+	return nd.flushTowerBox()
+}
+
+// flushTowerBox is FlushTowerBox with towerBoxMtx held
+func (nd *LitNode) flushTowerBox() error {
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		return err
+	}
+	if len(towers) != 0 {
+		return nd.flushClientTowers(towers)
+	}
+	if nd.WatchCon == nil {
+		return nil
+	}
+	// 0 if the connection isn't a peer's
+	peerIdx, _ := nd.towerPeer()
+	for {
+		var keys, boxes [][]byte
+		err := nd.LitDB.View(func(btx *bolt.Tx) error {
+			tbx := btx.Bucket(BKTTowerBox)
+			if tbx == nil {
+				return fmt.Errorf("no tower outbox")
+			}
+			c := tbx.Cursor()
+			for ck, cv := c.First(); ck != nil && len(keys) < towerFlushBatch; ck, cv = c.Next() {
+				keys = append(keys, append([]byte{}, ck...))
+				boxes = append(boxes, append([]byte{}, cv...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			nd.alerts.towerBoxEmpty()
+			return nil
+		}
+		msgs := make([][]byte, len(boxes))
+		for i, box := range boxes {
+			msgs[i], err = nd.openTowerMsg(box)
+			if err != nil {
+				return fmt.Errorf("tower outbox entry %x: %s", keys[i], err.Error())
+			}
+		}
+		n, err := nd.writeTowerMsgs(peerIdx, msgs)
+		if n > 0 {
+			delErr := nd.LitDB.Update(func(btx *bolt.Tx) error {
+				tbx := btx.Bucket(BKTTowerBox)
+				for _, k := range keys[:n] {
+					err := tbx.Delete(k)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if delErr != nil {
+				return delErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
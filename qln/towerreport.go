@@ -0,0 +1,215 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A client can ask its tower what it has for each channel: whether it knows
+the channel, the last state it got, and how many justice txids it holds.
+Anything we sent that the tower doesn't have -- lost in a tower crash or
+restore, say -- goes back in the outbox to be sent again.
+
+The tower takes states in order and can't tell a repeat from a new one, so
+only states after its last are sent.  Txids it lost for states it already
+has can't be replaced; those show up as Lost.
+
+With towers added (see towerset.go) each active one that's connected is
+asked, and sent what it's missing directly, the same as a backfill.
+*/
+
+// TowerReportChan is one of our channels as the tower sees it
+type TowerReportChan struct {
+	ChanIdx  uint32
+	PeerIdx  uint32
+	SentUpTo uint64 // we'd sent states up to this when we asked
+	Tower    lnutil.WatchReportEntry
+	Resent   uint64 // states sent again since the tower didn't have them
+	Lost     uint64 // states the tower got but has no txid for
+	TowerPub string // which tower, with towers added; hex
+}
+
+// towerPeer is the peer index of our watchtower connection
+func (nd *LitNode) towerPeer() (uint32, error) {
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	if nd.WatchCon == nil {
+		return 0, fmt.Errorf("no watchtower")
+	}
+	for idx, rp := range nd.RemoteCons {
+		if rp.Con == nd.WatchCon {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("watchtower isn't a connected peer")
+}
+
+// TowerReport asks the tower what it has for our open channels, waiting up
+// to wait for each answer, and queues anything it's missing
+func (nd *LitNode) TowerReport(wait time.Duration) ([]TowerReportChan, error) {
+	// held throughout, so nothing's queued while the tower's asked
+	nd.towerBoxMtx.Lock()
+	defer nd.towerBoxMtx.Unlock()
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		return nil, err
+	}
+	if len(towers) != 0 {
+		return nd.clientTowersReport(towers, wait)
+	}
+	peerIdx, err := nd.towerPeer()
+	if err != nil {
+		return nil, err
+	}
+
+	// what's queued has to reach the tower before the request does, or
+	// it'll look missing
+	err = nd.flushTowerBox()
+	if err != nil {
+		return nil, err
+	}
+	chans, msgs, err := nd.askTowerReport(peerIdx, wait)
+	if err != nil || len(msgs) == 0 {
+		return chans, err
+	}
+	err = nd.queueTowerMsgs(msgs...)
+	if err != nil {
+		return nil, err
+	}
+	return chans, nd.flushTowerBox()
+}
+
+// clientTowersReport is TowerReport for each active tower that's connected.
+// Hold towerBoxMtx.
+func (nd *LitNode) clientTowersReport(
+	towers []ClientTower, wait time.Duration) ([]TowerReportChan, error) {
+
+	var chans []TowerReportChan
+	asked := false
+	for i := range towers {
+		t := &towers[i]
+		peerIdx, ok := nd.clientTowerPeer(t.pub)
+		if !t.Active || !ok {
+			continue
+		}
+		c, err := nd.backfillTower(t, peerIdx, wait)
+		if err != nil {
+			return nil, fmt.Errorf("tower %x: %s", t.pub, err.Error())
+		}
+		chans = append(chans, c...)
+		asked = true
+	}
+	if !asked {
+		return nil, fmt.Errorf("no watchtower connected")
+	}
+	return chans, nil
+}
+
+// askTowerReport asks the tower on peerIdx what it has for our open
+// channels, and returns that with the messages to send it for what it's
+// missing.  Hold towerBoxMtx, so WatchUpTo is what's been queued.
+func (nd *LitNode) askTowerReport(peerIdx uint32, wait time.Duration) (
+	[]TowerReportChan, []lnutil.LitMsg, error) {
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, nil, err
+	}
+	var chans []TowerReportChan
+	var watched []*Qchan
+	var pkhs [][20]byte
+	for _, qc := range qcs {
+		if qc.CloseData.Closed || qc.State.WatchUpTo == 0 {
+			continue
+		}
+		chans = append(chans, TowerReportChan{
+			ChanIdx:  qc.Idx(),
+			PeerIdx:  qc.Peer(),
+			SentUpTo: qc.State.WatchUpTo,
+		})
+		watched = append(watched, qc)
+		pkhs = append(pkhs, qc.WatchRefundAdr)
+	}
+
+	var msgs []lnutil.LitMsg
+	for start := 0; start < len(pkhs); start += lnutil.WatchReportMax {
+		end := start + lnutil.WatchReportMax
+		if end > len(pkhs) {
+			end = len(pkhs)
+		}
+		req := lnutil.WatchReportReqMsg{PeerIdx: peerIdx, PKHs: pkhs[start:end]}
+		answer, err := nd.askPeer(req, lnutil.MSGID_WATCH_REPORT, wait)
+		if err != nil {
+			return nil, nil, err
+		}
+		rep := answer.(lnutil.WatchReportMsg)
+		if len(rep.Entries) != end-start {
+			return nil, nil, fmt.Errorf("asked tower about %d channels, got %d",
+				end-start, len(rep.Entries))
+		}
+		for i, e := range rep.Entries {
+			if e.PKH != pkhs[start+i] {
+				return nil, nil, fmt.Errorf("tower report entry %d is for %x, not %x",
+					i, e.PKH, pkhs[start+i])
+			}
+			c := &chans[start+i]
+			c.Tower = e
+			missing, err := nd.missingWatchMsgs(watched[start+i], c)
+			if err != nil {
+				return nil, nil, err
+			}
+			msgs = append(msgs, missing...)
+		}
+	}
+
+	return chans, msgs, nil
+}
+
+// missingWatchMsgs are the messages to send the tower again for a channel,
+// from what it reported; also fills in Resent and Lost
+func (nd *LitNode) missingWatchMsgs(
+	qc *Qchan, c *TowerReportChan) ([]lnutil.LitMsg, error) {
+
+	e := c.Tower
+	if e.Flags&lnutil.WatchReportUnwatched != 0 {
+		return nil, nil
+	}
+	var msgs []lnutil.LitMsg
+	from := uint64(0)
+	if e.Flags&lnutil.WatchReportKnown == 0 {
+		msgs = append(msgs, watchDescMsg(qc))
+	} else if e.Flags&lnutil.WatchReportHasStates != 0 {
+		from = e.LastState + 1
+		if e.States < from {
+			c.Lost = from - e.States
+		}
+	}
+	for idx := from; idx <= c.SentUpTo; idx++ {
+		comMsg, err := nd.WatchComMsg(qc, idx)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, comMsg)
+		c.Resent++
+	}
+	if c.Resent != 0 || c.Lost != 0 {
+		logger.Warnf("tower missing channel %d states: resending %d, %d lost\n",
+			c.ChanIdx, c.Resent, c.Lost)
+	}
+	return msgs, nil
+}
+
+// TowerReportReqHandler answers a client asking what we have for its
+// channels, if we're a tower
+func (nd *LitNode) TowerReportReqHandler(
+	client [33]byte, req lnutil.WatchReportReqMsg) error {
+	entries, err := nd.Tower.Report(client, req.PKHs)
+	if err != nil {
+		return err
+	}
+	nd.OmniOut <- lnutil.WatchReportMsg{PeerIdx: req.PeerIdx, Entries: entries}
+	return nil
+}
@@ -0,0 +1,338 @@
+package qln
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/sig64"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+/*
+Tower discovery.  A node running a tower sends a signed TowerAdvertMsg to
+every peer that connects.  Nodes keep the adverts they get, newest per
+tower, in BKTTowers, and send the ones they know on to peers that connect
+later, so adverts spread a hop per connection.  The sig is by the tower's
+identity key, so it doesn't matter who passes one on.
+
+A directory (--towerdir) can also list towers: a URL serving a json array
+of KnownTowers (TowerPub, Host, RewardPPM, Features, CoinTypes), fetched at
+startup and then every towerDirInterval.  Directory entries aren't signed;
+they're trusted because you configured the URL, and aren't passed on.
+
+PickTower chooses, for a coin, the cheapest accepting tower that can be
+reached.
+*/
+
+const (
+	towerDirInterval = 6 * time.Hour
+	towerDirTimeout  = 30 * time.Second
+
+	// adverts from further than this in the future, or older than
+	// towerAdvertMaxAge, are ignored
+	towerAdvertMaxSkew = time.Hour
+	towerAdvertMaxAge  = 30 * 24 * time.Hour
+
+	// most adverts sent to a peer when it connects
+	towerAdvertsPerPeer = 50
+
+	towerFromPeer      = 0
+	towerFromDirectory = 1
+)
+
+// KnownTower is a tower this node has heard of
+type KnownTower struct {
+	TowerPub  string // hex
+	LitAdr    string // connect to LitAdr@Host
+	Host      string
+	RewardPPM uint32 // cut of recovered funds it wants, per million
+	Features  uint32 // lnutil.TowerFeat bits
+	CoinTypes []uint32
+	Time      int64  // when the tower made the advert
+	Source    string // "peer N" or "directory"
+
+	advert lnutil.TowerAdvertMsg
+	from   uint8
+}
+
+// Supports says whether the tower watches coin
+func (kt *KnownTower) Supports(coin uint32) bool {
+	for _, c := range kt.CoinTypes {
+		if c == coin {
+			return true
+		}
+	}
+	return false
+}
+
+func knownTowerFromAdvert(ta lnutil.TowerAdvertMsg, from uint8, peerIdx uint32) KnownTower {
+	var kt KnownTower
+	kt.TowerPub = hex.EncodeToString(ta.TowerPub[:])
+	kt.LitAdr = lnutil.LitAdrFromPubkey(ta.TowerPub)
+	kt.Host = ta.Host
+	kt.RewardPPM = ta.RewardPPM
+	kt.Features = ta.Features
+	kt.CoinTypes = ta.CoinTypes
+	kt.Time = ta.Time
+	kt.Source = "directory"
+	if from == towerFromPeer {
+		kt.Source = fmt.Sprintf("peer %d", peerIdx)
+	}
+	kt.advert = ta
+	kt.from = from
+	return kt
+}
+
+// knownTowerToBytes is from, peer index, then the advert
+func knownTowerToBytes(ta lnutil.TowerAdvertMsg, from uint8, peerIdx uint32) []byte {
+	b := []byte{from}
+	b = append(b, lnutil.U32tB(peerIdx)...)
+	return append(b, ta.Bytes()...)
+}
+
+func knownTowerFromBytes(b []byte) (KnownTower, error) {
+	if len(b) < 5 {
+		return KnownTower{}, fmt.Errorf("known tower %d bytes", len(b))
+	}
+	ta, err := lnutil.NewTowerAdvertMsgFromBytes(b[5:], 0)
+	if err != nil {
+		return KnownTower{}, err
+	}
+	return knownTowerFromAdvert(ta, b[0], lnutil.BtU32(b[1:5])), nil
+}
+
+// MakeTowerAdvert makes and signs this node's advert, if it's running a tower
+func (nd *LitNode) MakeTowerAdvert() (lnutil.TowerAdvertMsg, bool) {
+	var ta lnutil.TowerAdvertMsg
+	wt, ok := nd.Tower.(*watchtower.WatchTower)
+	if !ok || wt.WatchDB == nil {
+		return ta, false
+	}
+	copy(ta.TowerPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	ta.Time = time.Now().Unix()
+	ta.Features = lnutil.TowerFeatAccepting
+	for coin := range wt.Hooks {
+		ta.CoinTypes = append(ta.CoinTypes, coin)
+	}
+	sort.Slice(ta.CoinTypes, func(i, j int) bool {
+		return ta.CoinTypes[i] < ta.CoinTypes[j]
+	})
+	// a listener with a host is reachable there; a bare :port isn't
+	nd.RemoteMtx.Lock()
+	for _, lis := range nd.LisIpPorts {
+		if !strings.HasPrefix(lis, ":") {
+			ta.Host = lis
+			break
+		}
+	}
+	nd.RemoteMtx.Unlock()
+
+	hash := ta.SigHash()
+	sig, err := nd.IdKey().Sign(hash[:])
+	if err != nil {
+		logger.Errorf("tower advert sign error: %s\n", err.Error())
+		return ta, false
+	}
+	ta.Sig, err = sig64.SigCompress(sig.Serialize())
+	if err != nil {
+		logger.Errorf("tower advert sig error: %s\n", err.Error())
+		return ta, false
+	}
+	return ta, true
+}
+
+// checkTowerAdvert makes sure an advert is signed by its tower and recent
+func checkTowerAdvert(ta lnutil.TowerAdvertMsg) error {
+	pub, err := btcec.ParsePubKey(ta.TowerPub[:], btcec.S256())
+	if err != nil {
+		return err
+	}
+	sig, err := btcec.ParseDERSignature(sig64.SigDecompress(ta.Sig), btcec.S256())
+	if err != nil {
+		return err
+	}
+	hash := ta.SigHash()
+	if !sig.Verify(hash[:], pub) {
+		return fmt.Errorf("tower advert for %x has a bad sig", ta.TowerPub)
+	}
+	when := time.Unix(ta.Time, 0)
+	if when.After(time.Now().Add(towerAdvertMaxSkew)) {
+		return fmt.Errorf("tower advert for %x from the future", ta.TowerPub)
+	}
+	if when.Before(time.Now().Add(-towerAdvertMaxAge)) {
+		return fmt.Errorf("tower advert for %x too old", ta.TowerPub)
+	}
+	return nil
+}
+
+// TowerAdvertHandler saves an advert from a peer, unless there's already
+// a newer one for that tower
+func (nd *LitNode) TowerAdvertHandler(msg lnutil.TowerAdvertMsg) error {
+	err := checkTowerAdvert(msg)
+	if err != nil {
+		return err
+	}
+	return nd.saveKnownTower(msg, towerFromPeer, msg.Peer())
+}
+
+func (nd *LitNode) saveKnownTower(
+	ta lnutil.TowerAdvertMsg, from uint8, peerIdx uint32) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		twr := btx.Bucket(BKTTowers)
+		if twr == nil {
+			return fmt.Errorf("no towers bucket")
+		}
+		old := twr.Get(ta.TowerPub[:])
+		if old != nil {
+			kt, err := knownTowerFromBytes(old)
+			// directory entries don't get replaced by gossip
+			if err == nil && (kt.Time >= ta.Time ||
+				(kt.from == towerFromDirectory && from == towerFromPeer)) {
+				return nil
+			}
+		}
+		logger.Infof("tower %x at %s, %d ppm\n", ta.TowerPub, ta.Host, ta.RewardPPM)
+		return twr.Put(ta.TowerPub[:], knownTowerToBytes(ta, from, peerIdx))
+	})
+}
+
+// ListKnownTowers returns every tower heard of, newest advert first
+func (nd *LitNode) ListKnownTowers() ([]KnownTower, error) {
+	var towers []KnownTower
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		twr := btx.Bucket(BKTTowers)
+		if twr == nil {
+			return fmt.Errorf("no towers bucket")
+		}
+		return twr.ForEach(func(k, v []byte) error {
+			kt, err := knownTowerFromBytes(v)
+			if err != nil {
+				logger.Warnf("known tower %x: %s\n", k, err.Error())
+				return nil
+			}
+			towers = append(towers, kt)
+			return nil
+		})
+	})
+	sort.Slice(towers, func(i, j int) bool {
+		return towers[i].Time > towers[j].Time
+	})
+	return towers, err
+}
+
+// PickTower picks the tower to use for coin: accepting, watching that coin,
+// with a host to connect to, and the lowest reward; newest advert breaks
+// ties.
+func (nd *LitNode) PickTower(coin uint32) (*KnownTower, error) {
+	towers, err := nd.ListKnownTowers()
+	if err != nil {
+		return nil, err
+	}
+	var best *KnownTower
+	for i := range towers {
+		kt := &towers[i]
+		if kt.Features&lnutil.TowerFeatAccepting == 0 ||
+			!kt.Supports(coin) || kt.Host == "" {
+			continue
+		}
+		// newest first, so only strictly cheaper replaces
+		if best == nil || kt.RewardPPM < best.RewardPPM {
+			best = kt
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no known tower for coin %d", coin)
+	}
+	return best, nil
+}
+
+// sendTowerAdverts sends a newly connected peer our own advert, if we're
+// a tower, and the signed ones we've heard
+func (nd *LitNode) sendTowerAdverts(peerIdx uint32) {
+	var msgs []lnutil.TowerAdvertMsg
+	own, ok := nd.MakeTowerAdvert()
+	if ok {
+		msgs = append(msgs, own)
+	}
+	towers, err := nd.ListKnownTowers()
+	if err != nil {
+		logger.Warnf("tower adverts for peer %d: %s\n", peerIdx, err.Error())
+	}
+	for _, kt := range towers {
+		if len(msgs) >= towerAdvertsPerPeer {
+			break
+		}
+		if kt.from != towerFromPeer || kt.advert.TowerPub == own.TowerPub {
+			continue
+		}
+		// don't pass on ones that have gone stale since we got them
+		if checkTowerAdvert(kt.advert) != nil {
+			continue
+		}
+		msgs = append(msgs, kt.advert)
+	}
+	for _, ta := range msgs {
+		ta.PeerIdx = peerIdx
+		nd.OmniOut <- ta
+	}
+}
+
+// StartTowerDirectory polls url for towers; does nothing for an empty url
+func (nd *LitNode) StartTowerDirectory(url string) {
+	if url == "" {
+		return
+	}
+	go func() {
+		for {
+			err := nd.fetchTowerDirectory(url)
+			if err != nil {
+				logger.Warnf("tower directory %s: %s\n", url, err.Error())
+			}
+			time.Sleep(towerDirInterval)
+		}
+	}()
+}
+
+func (nd *LitNode) fetchTowerDirectory(url string) error {
+	client := lnutil.HTTPClient(towerDirTimeout)
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	var listed []KnownTower
+	err = json.NewDecoder(resp.Body).Decode(&listed)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, kt := range listed {
+		pubBytes, err := hex.DecodeString(kt.TowerPub)
+		if err != nil || len(pubBytes) != 33 {
+			logger.Warnf("tower directory: bad pubkey %q\n", kt.TowerPub)
+			continue
+		}
+		var ta lnutil.TowerAdvertMsg
+		copy(ta.TowerPub[:], pubBytes)
+		ta.Time = now
+		ta.Host = kt.Host
+		ta.RewardPPM = kt.RewardPPM
+		ta.Features = kt.Features
+		ta.CoinTypes = kt.CoinTypes
+		err = nd.saveKnownTower(ta, towerFromDirectory, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,675 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Instead of the one tower on WatchCon, a node can upload to towers of its
+own, so losing one doesn't leave its channels unwatched.  Of the N towers
+added (--watchtower, or AddClientTower), K are active at a time (--towerk,
+default 1) and get every state; the rest stand by.  With towers added,
+WatchCon isn't used.
+
+Everything for the towers still goes in the one outbox; see towerbox.go.
+Each tower keeps how far into the outbox it's been sent, SentSeq, and an
+entry's only taken out once every active tower has it.  As with WatchCon,
+a write that goes through counts as the tower having it.
+
+A tower that's just become active has nothing from before, so it's
+backfilled before it gets anything from the outbox: asked what it has for
+each open channel, as in TowerReport, and sent everything it's missing,
+from the channel description on.  It then carries on from the end of the
+outbox.  That's all done holding towerBoxMtx, which SyncWatch also holds
+to queue states and save WatchUpTo, so nothing's skipped or sent twice.
+
+TowerKeeper dials active towers that aren't connected, every
+towerKeepInterval and whenever the set changes.  One that's been
+unreachable for the failover time (--towerfailover, default
+defaultTowerFailover) is swapped for a standby that can be reached, which
+is backfilled, and EventTowerFailover is published.  The one swapped out
+becomes a standby; if it's made active again later, the backfill only
+sends it what it's missing.
+*/
+
+const (
+	towerKeepInterval    = time.Minute
+	defaultTowerFailover = 30 * time.Minute
+	// how long a tower has to answer each of the backfill's report requests
+	towerBackfillWait = 30 * time.Second
+)
+
+// ClientTower is a tower added to upload to
+type ClientTower struct {
+	TowerPub   string // hex
+	LitAdr     string
+	Host       string
+	Added      int64  // unix time
+	Active     bool   // one of the K getting every state
+	Backfilled bool   // has had everything from before it was active
+	SentSeq    uint64 // last outbox entry written to it
+	DownSince  int64  // unix time an active tower was first seen away; 0 if up
+
+	// filled in by ClientTowers
+	Connected bool
+	Waiting   int // outbox entries it hasn't had
+
+	pub [33]byte
+}
+
+// clientTowerToBytes is the pubkey, flags, Added, SentSeq, DownSince, then
+// the host
+func clientTowerToBytes(t *ClientTower) []byte {
+	b := append([]byte{}, t.pub[:]...)
+	var flags byte
+	if t.Active {
+		flags |= 1
+	}
+	if t.Backfilled {
+		flags |= 2
+	}
+	b = append(b, flags)
+	b = append(b, lnutil.I64tB(t.Added)...)
+	b = append(b, lnutil.U64tB(t.SentSeq)...)
+	b = append(b, lnutil.I64tB(t.DownSince)...)
+	return append(b, []byte(t.Host)...)
+}
+
+func clientTowerFromBytes(b []byte) (ClientTower, error) {
+	var t ClientTower
+	if len(b) < 58 {
+		return t, fmt.Errorf("client tower %d bytes, expect 58+", len(b))
+	}
+	copy(t.pub[:], b[:33])
+	t.Active = b[33]&1 != 0
+	t.Backfilled = b[33]&2 != 0
+	t.Added = lnutil.BtI64(b[34:42])
+	t.SentSeq = lnutil.BtU64(b[42:50])
+	t.DownSince = lnutil.BtI64(b[50:58])
+	t.Host = string(b[58:])
+	t.TowerPub = hex.EncodeToString(t.pub[:])
+	t.LitAdr = lnutil.LitAdrFromPubkey(t.pub)
+	return t, nil
+}
+
+// parseClientTower reads a tower as pubkey hex, with @host if given
+func parseClientTower(s string) ([33]byte, string, error) {
+	var pub [33]byte
+	pubHex, host := s, ""
+	if i := strings.Index(s, "@"); i >= 0 {
+		pubHex, host = s[:i], s[i+1:]
+	}
+	b, err := hex.DecodeString(pubHex)
+	if err != nil || len(b) != 33 {
+		return pub, "", fmt.Errorf("tower %q isn't a 33 byte hex pubkey", pubHex)
+	}
+	copy(pub[:], b)
+	return pub, host, nil
+}
+
+// AddClientTower adds a tower to upload to: its pubkey in hex, then @host
+// unless it's a known tower.  Adding one that's there already updates its
+// host.
+func (nd *LitNode) AddClientTower(s string) error {
+	pub, host, err := parseClientTower(s)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		known, err := nd.ListKnownTowers()
+		if err != nil {
+			return err
+		}
+		for _, kt := range known {
+			if kt.TowerPub == hex.EncodeToString(pub[:]) {
+				host = kt.Host
+				break
+			}
+		}
+		if host == "" {
+			return fmt.Errorf("no host known for tower %x; add it as pubkey@host",
+				pub)
+		}
+	}
+
+	nd.towerBoxMtx.Lock()
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		nd.towerBoxMtx.Unlock()
+		return err
+	}
+	t := &ClientTower{pub: pub, Added: time.Now().Unix()}
+	for i := range towers {
+		if towers[i].pub == pub {
+			t = &towers[i]
+		}
+	}
+	t.Host = host
+	err = nd.saveClientTower(t)
+	nd.towerBoxMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	logger.Infof("uploading to tower %x at %s\n", pub, host)
+	nd.kickTowers()
+	return nil
+}
+
+// RemoveClientTower stops uploading to a tower
+func (nd *LitNode) RemoveClientTower(s string) error {
+	pub, _, err := parseClientTower(s)
+	if err != nil {
+		return err
+	}
+	nd.towerBoxMtx.Lock()
+	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+		ctw := btx.Bucket(BKTClientTowers)
+		if ctw == nil {
+			return fmt.Errorf("no client towers bucket")
+		}
+		if ctw.Get(pub[:]) == nil {
+			return fmt.Errorf("not uploading to tower %x", pub)
+		}
+		return ctw.Delete(pub[:])
+	})
+	nd.towerBoxMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	nd.kickTowers()
+	return nil
+}
+
+// SetTowerK sets how many of the added towers get every state
+func (nd *LitNode) SetTowerK(k int) error {
+	if k < 1 {
+		return fmt.Errorf("need at least 1 tower at a time, not %d", k)
+	}
+	nd.towerBoxMtx.Lock()
+	nd.towerK = k
+	nd.towerBoxMtx.Unlock()
+	nd.kickTowers()
+	return nil
+}
+
+// SetTowerFailover sets how long an active tower can be unreachable before
+// a standby takes over.  0 goes back to defaultTowerFailover.
+func (nd *LitNode) SetTowerFailover(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("negative failover time")
+	}
+	if d == 0 {
+		d = defaultTowerFailover
+	}
+	nd.towerBoxMtx.Lock()
+	nd.towerFailover = d
+	nd.towerBoxMtx.Unlock()
+	return nil
+}
+
+// ClientTowers returns K and the towers added, active ones first
+func (nd *LitNode) ClientTowers() (int, []ClientTower, error) {
+	nd.towerBoxMtx.Lock()
+	defer nd.towerBoxMtx.Unlock()
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range towers {
+		t := &towers[i]
+		_, t.Connected = nd.clientTowerPeer(t.pub)
+		if !t.Active {
+			continue
+		}
+		err = nd.LitDB.View(func(btx *bolt.Tx) error {
+			tbx := btx.Bucket(BKTTowerBox)
+			if tbx == nil {
+				return fmt.Errorf("no tower outbox")
+			}
+			c := tbx.Cursor()
+			for k, _ := c.Seek(lnutil.U64tB(t.SentSeq + 1)); k != nil; k, _ = c.Next() {
+				t.Waiting++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	sort.SliceStable(towers, func(i, j int) bool {
+		return towers[i].Active && !towers[j].Active
+	})
+	return nd.towerK, towers, nil
+}
+
+// loadClientTowers reads the towers added, oldest first.  Hold towerBoxMtx
+// to change them.
+func (nd *LitNode) loadClientTowers() ([]ClientTower, error) {
+	var towers []ClientTower
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		ctw := btx.Bucket(BKTClientTowers)
+		if ctw == nil {
+			return fmt.Errorf("no client towers bucket")
+		}
+		return ctw.ForEach(func(k, v []byte) error {
+			t, err := clientTowerFromBytes(v)
+			if err != nil {
+				logger.Warnf("client tower %x: %s\n", k, err.Error())
+				return nil
+			}
+			towers = append(towers, t)
+			return nil
+		})
+	})
+	sort.Slice(towers, func(i, j int) bool {
+		return towers[i].Added < towers[j].Added
+	})
+	return towers, err
+}
+
+func (nd *LitNode) saveClientTower(t *ClientTower) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		ctw := btx.Bucket(BKTClientTowers)
+		if ctw == nil {
+			return fmt.Errorf("no client towers bucket")
+		}
+		return ctw.Put(t.pub[:], clientTowerToBytes(t))
+	})
+}
+
+// clientTowerPeer is the peer index a tower's connected as
+func (nd *LitNode) clientTowerPeer(pub [33]byte) (uint32, bool) {
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	for idx, rp := range nd.RemoteCons {
+		if rp.Con != nil && rp.Con.RemotePub != nil &&
+			bytes.Equal(rp.Con.RemotePub.SerializeCompressed(), pub[:]) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// towerConnected says if there's a tower to upload to: WatchCon, or an
+// active tower that's connected
+func (nd *LitNode) towerConnected() bool {
+	if nd.WatchCon != nil {
+		return true
+	}
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		return false
+	}
+	for _, t := range towers {
+		if _, ok := nd.clientTowerPeer(t.pub); ok && t.Active {
+			return true
+		}
+	}
+	return false
+}
+
+// lastTowerBoxSeq is the sequence number of the newest outbox entry, or 0
+func (nd *LitNode) lastTowerBoxSeq() (uint64, error) {
+	var last uint64
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		tbx := btx.Bucket(BKTTowerBox)
+		if tbx == nil {
+			return fmt.Errorf("no tower outbox")
+		}
+		k, _ := tbx.Cursor().Last()
+		if k != nil {
+			last = lnutil.BtU64(k)
+		}
+		return nil
+	})
+	return last, err
+}
+
+// kickTowers has TowerKeeper go round now, if it's not busy
+func (nd *LitNode) kickTowers() {
+	select {
+	case nd.towerKick <- struct{}{}:
+	default:
+	}
+}
+
+// TowerKeeper connects to, fails over and backfills the towers added,
+// every towerKeepInterval or when kicked.  Runs until shutdown.
+func (nd *LitNode) TowerKeeper() {
+	for !nd.ShuttingDown() {
+		nd.KeepTowers()
+		select {
+		case <-nd.towerKick:
+		case <-time.After(towerKeepInterval):
+		}
+	}
+}
+
+// KeepTowers goes round the towers once: dials active ones that are away,
+// swaps out any away too long, backfills new ones and flushes the outbox
+func (nd *LitNode) KeepTowers() {
+	// so two don't dial the same tower
+	nd.towerKeepMtx.Lock()
+	defer nd.towerKeepMtx.Unlock()
+	towers, err := nd.loadClientTowers()
+	if err != nil {
+		logger.Warnf("towers: %s\n", err.Error())
+		return
+	}
+	if len(towers) == 0 {
+		return
+	}
+	nd.towerBoxMtx.Lock()
+	failover := nd.towerFailover
+	nd.towerBoxMtx.Unlock()
+
+	// standbys only get dialed when one might have to take over
+	failing := false
+	for _, t := range towers {
+		if t.Active && t.DownSince != 0 &&
+			time.Since(time.Unix(t.DownSince, 0)) >= failover {
+			failing = true
+		}
+	}
+	for _, t := range towers {
+		if !t.Active && !failing {
+			continue
+		}
+		if _, ok := nd.clientTowerPeer(t.pub); ok || t.Host == "" {
+			continue
+		}
+		err = nd.DialPeer(t.LitAdr + "@" + t.Host)
+		if err != nil {
+			logger.Warnf("tower %x at %s: %s\n", t.pub, t.Host, err.Error())
+		}
+	}
+
+	nd.towerBoxMtx.Lock()
+	defer nd.towerBoxMtx.Unlock()
+	towers, err = nd.loadClientTowers()
+	if err != nil {
+		logger.Warnf("towers: %s\n", err.Error())
+		return
+	}
+	now := time.Now()
+	for i := range towers {
+		t := &towers[i]
+		if !t.Active {
+			continue
+		}
+		was := t.DownSince
+		if _, ok := nd.clientTowerPeer(t.pub); ok {
+			t.DownSince = 0
+		} else if t.DownSince == 0 {
+			t.DownSince = now.Unix()
+		}
+		if t.DownSince != was {
+			err = nd.saveClientTower(t)
+			if err != nil {
+				logger.Warnf("towers: %s\n", err.Error())
+				return
+			}
+		}
+	}
+	err = nd.pickClientTowers(towers, now)
+	if err != nil {
+		logger.Warnf("towers: %s\n", err.Error())
+		return
+	}
+	for i := range towers {
+		t := &towers[i]
+		if !t.Active || t.Backfilled {
+			continue
+		}
+		peerIdx, ok := nd.clientTowerPeer(t.pub)
+		if !ok {
+			continue
+		}
+		_, err = nd.backfillTower(t, peerIdx, towerBackfillWait)
+		if err != nil {
+			logger.Warnf("tower %x backfill: %s\n", t.pub, err.Error())
+		}
+	}
+	err = nd.flushClientTowers(towers)
+	if err != nil {
+		logger.Warnf("towers: %s\n", err.Error())
+	}
+}
+
+// pickClientTowers makes K of the towers active: swapping out active ones
+// that have been away for the failover time for standbys that are
+// connected, then activating or standing down towers to make K, ones
+// that are connected first.  Hold towerBoxMtx.
+func (nd *LitNode) pickClientTowers(towers []ClientTower, now time.Time) error {
+	var active, ready, away []*ClientTower
+	for i := range towers {
+		t := &towers[i]
+		_, up := nd.clientTowerPeer(t.pub)
+		switch {
+		case t.Active:
+			active = append(active, t)
+		case up:
+			ready = append(ready, t)
+		default:
+			away = append(away, t)
+		}
+	}
+
+	for i, t := range active {
+		if len(ready) == 0 {
+			break
+		}
+		if t.DownSince == 0 ||
+			now.Sub(time.Unix(t.DownSince, 0)) < nd.towerFailover {
+			continue
+		}
+		s := ready[0]
+		ready = ready[1:]
+		detail := fmt.Sprintf("tower %s away since %s; now using %s",
+			t.TowerPub, time.Unix(t.DownSince, 0).Format(time.RFC822), s.TowerPub)
+		err := nd.setTowerActive(t, false)
+		if err != nil {
+			return err
+		}
+		err = nd.setTowerActive(s, true)
+		if err != nil {
+			return err
+		}
+		active[i] = s
+		logger.Warnf("%s\n", detail)
+		nd.PublishEvent(NodeEvent{Type: EventTowerFailover, Detail: detail})
+	}
+
+	standby := append(ready, away...)
+	for len(active) < nd.towerK && len(standby) > 0 {
+		err := nd.setTowerActive(standby[0], true)
+		if err != nil {
+			return err
+		}
+		active = append(active, standby[0])
+		standby = standby[1:]
+	}
+	if len(active) <= nd.towerK {
+		return nil
+	}
+	// away longest goes first
+	sort.SliceStable(active, func(i, j int) bool {
+		a, b := active[i].DownSince, active[j].DownSince
+		return a != 0 && (b == 0 || a < b)
+	})
+	for _, t := range active[:len(active)-nd.towerK] {
+		err := nd.setTowerActive(t, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTowerActive makes a tower active or a standby.  An active one starts
+// from the end of the outbox, and needs backfilling.  Hold towerBoxMtx.
+func (nd *LitNode) setTowerActive(t *ClientTower, active bool) error {
+	t.Active = active
+	t.Backfilled = false
+	t.DownSince = 0
+	t.SentSeq = 0
+	if active {
+		last, err := nd.lastTowerBoxSeq()
+		if err != nil {
+			return err
+		}
+		t.SentSeq = last
+		logger.Infof("tower %x active\n", t.pub)
+	} else {
+		logger.Infof("tower %x standing by\n", t.pub)
+	}
+	return nd.saveClientTower(t)
+}
+
+// backfillTower sends an active tower whatever it's missing for our open
+// channels, as it reports it, and has it carry on from the end of the
+// outbox.  Returns the report.  Hold towerBoxMtx.
+func (nd *LitNode) backfillTower(
+	t *ClientTower, peerIdx uint32, wait time.Duration) ([]TowerReportChan, error) {
+
+	if t.Backfilled {
+		// what's queued has to reach it before the request does
+		err := nd.flushToTower(t, peerIdx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	last, err := nd.lastTowerBoxSeq()
+	if err != nil {
+		return nil, err
+	}
+	chans, msgs, err := nd.askTowerReport(peerIdx, wait)
+	if err != nil {
+		return nil, err
+	}
+	raws := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		raws[i] = msg.Bytes()
+	}
+	for len(raws) > 0 {
+		n, err := nd.writeTowerMsgs(peerIdx, raws)
+		if err != nil {
+			return nil, err
+		}
+		raws = raws[n:]
+	}
+	if !t.Backfilled {
+		logger.Infof("tower %x backfilled, %d messages\n", t.pub, len(msgs))
+	}
+	t.Backfilled = true
+	if t.SentSeq < last {
+		t.SentSeq = last
+	}
+	for i := range chans {
+		chans[i].TowerPub = t.TowerPub
+	}
+	return chans, nd.saveClientTower(t)
+}
+
+// flushToTower sends a tower the outbox entries after its SentSeq
+func (nd *LitNode) flushToTower(t *ClientTower, peerIdx uint32) error {
+	for {
+		var keys, boxes [][]byte
+		err := nd.LitDB.View(func(btx *bolt.Tx) error {
+			tbx := btx.Bucket(BKTTowerBox)
+			if tbx == nil {
+				return fmt.Errorf("no tower outbox")
+			}
+			c := tbx.Cursor()
+			for ck, cv := c.Seek(lnutil.U64tB(t.SentSeq + 1)); ck != nil && len(keys) < towerFlushBatch; ck, cv = c.Next() {
+				keys = append(keys, append([]byte{}, ck...))
+				boxes = append(boxes, append([]byte{}, cv...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		msgs := make([][]byte, len(boxes))
+		for i, box := range boxes {
+			msgs[i], err = nd.openTowerMsg(box)
+			if err != nil {
+				return fmt.Errorf("tower outbox entry %x: %s", keys[i], err.Error())
+			}
+		}
+		n, err := nd.writeTowerMsgs(peerIdx, msgs)
+		if n > 0 {
+			t.SentSeq = lnutil.BtU64(keys[n-1])
+			saveErr := nd.saveClientTower(t)
+			if saveErr != nil {
+				return saveErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// flushClientTowers sends each active tower that's connected and
+// backfilled what it hasn't had, then takes out of the outbox what every
+// active tower has.  Hold towerBoxMtx.
+func (nd *LitNode) flushClientTowers(towers []ClientTower) error {
+	var haveAll uint64
+	anyActive := false
+	for i := range towers {
+		t := &towers[i]
+		if !t.Active {
+			continue
+		}
+		if peerIdx, ok := nd.clientTowerPeer(t.pub); ok && t.Backfilled {
+			err := nd.flushToTower(t, peerIdx)
+			if err != nil {
+				logger.Warnf("tower %x: %s\n", t.pub, err.Error())
+			}
+		}
+		if !anyActive || t.SentSeq < haveAll {
+			haveAll = t.SentSeq
+		}
+		anyActive = true
+	}
+	if !anyActive {
+		return nil
+	}
+	empty := false
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		tbx := btx.Bucket(BKTTowerBox)
+		if tbx == nil {
+			return fmt.Errorf("no tower outbox")
+		}
+		var done [][]byte
+		c := tbx.Cursor()
+		for k, _ := c.First(); k != nil && lnutil.BtU64(k) <= haveAll; k, _ = c.Next() {
+			done = append(done, append([]byte{}, k...))
+		}
+		for _, k := range done {
+			err := tbx.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		k, _ := tbx.Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if err == nil && empty {
+		nd.alerts.towerBoxEmpty()
+	}
+	return err
+}
@@ -6,13 +6,17 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"net/http"
+	"fmt"
 	"net/url"
 	"strings"
 
 	"github.com/adiabat/btcd/btcec"
+	"github.com/mit-dci/lit/lnutil"
 )
 
+// trackerClient connects through the proxy if there is one
+var trackerClient = lnutil.HTTPClient(0)
+
 type announcement struct {
 	url  string
 	addr string
@@ -29,7 +33,11 @@ type nodeinfo struct {
 }
 
 func Announce(priv *btcec.PrivateKey, litport string, litadr string, trackerURL string) error {
-	resp, err := http.Get("http://myexternalip.com/raw")
+	// through a proxy we'd just find, and publish, the proxy's IP
+	if proxy, _ := lnutil.Proxy(); proxy != "" {
+		return fmt.Errorf("not announcing an IP; connecting through proxy %s", proxy)
+	}
+	resp, err := trackerClient.Get("http://myexternalip.com/raw")
 	if err != nil {
 		return err
 	}
@@ -56,7 +64,7 @@ func Announce(priv *btcec.PrivateKey, litport string, litadr string, trackerURL
 	ann.sig = hex.EncodeToString(urlSig.Serialize())
 	ann.pbk = hex.EncodeToString(priv.PubKey().SerializeCompressed())
 
-	_, err = http.PostForm(trackerURL+"/announce",
+	_, err = trackerClient.PostForm(trackerURL+"/announce",
 		url.Values{"url": {ann.url},
 			"addr": {ann.addr},
 			"sig":  {ann.sig},
@@ -70,7 +78,7 @@ func Announce(priv *btcec.PrivateKey, litport string, litadr string, trackerURL
 }
 
 func Lookup(litadr string, trackerURL string) (string, error) {
-	resp, err := http.Get(trackerURL + "/" + litadr)
+	resp, err := trackerClient.Get(trackerURL + "/" + litadr)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,79 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Upfront shutdown scripts: with one set for a coin, every channel opened in
+that coin tells the peer where our coop close output goes, and the peer
+won't sign a close paying anywhere else.  It's meant for an address in a
+cold wallet, so if the node's broken into the channels can still only be
+closed to it.  Set them at startup; changing one only affects channels
+opened after.  How they go over the wire is in lnutil/shutdown.go.
+*/
+
+// SetShutdownScript sets where coop closes of channels opened from now on
+// in this coin pay us.  Call before linking wallets.
+func (nd *LitNode) SetShutdownScript(coin uint32, script []byte) error {
+	err := lnutil.CheckShutdownScript(script)
+	if err != nil {
+		return err
+	}
+	nd.shutdownScripts[coin] = script
+	return nil
+}
+
+// shutdownScript is our shutdown script for new channels in a coin; nil
+// if there isn't one
+func (nd *LitNode) shutdownScript(coin uint32) []byte {
+	return nd.shutdownScripts[coin]
+}
+
+// theirShutdown checks the shutdown script a peer sent when opening a
+// channel, returning it if the channel uses them
+func theirShutdown(q *Qchan, script []byte) ([]byte, error) {
+	if !q.Features.Has(lnutil.FeatureUpfrontShutdown) || len(script) == 0 {
+		return nil, nil
+	}
+	err := lnutil.CheckShutdownScript(script)
+	if err != nil {
+		return nil, fmt.Errorf("peer's %s", err.Error())
+	}
+	return script, nil
+}
+
+// closeScripts are where a coop close pays each of us
+func (q *Qchan) closeScripts() (mine, theirs []byte) {
+	mine = q.MyShutdown
+	if len(mine) == 0 {
+		mine = lnutil.DirectWPKHScript(q.MyRefundPub)
+	}
+	theirs = q.TheirShutdown
+	if len(theirs) == 0 {
+		theirs = lnutil.DirectWPKHScript(q.TheirRefundPub)
+	}
+	return mine, theirs
+}
+
+// putShutdownScripts saves a channel's shutdown scripts in its bucket,
+// deleting ones it doesn't have
+func putShutdownScripts(qcBucket *bolt.Bucket, q *Qchan) error {
+	for _, ks := range []struct {
+		key, script []byte
+	}{{KEYMyShutdown, q.MyShutdown}, {KEYTheirShutdown, q.TheirShutdown}} {
+		var err error
+		if len(ks.script) == 0 {
+			err = qcBucket.Delete(ks.key)
+		} else {
+			err = qcBucket.Put(ks.key, ks.script)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
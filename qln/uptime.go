@@ -0,0 +1,91 @@
+package qln
+
+import (
+	"sync"
+	"time"
+)
+
+// peerUptime is how long a peer's been connected since the node started.
+// It's only kept in ram, so it starts over on restart.
+type peerUptime struct {
+	since time.Time     // when the current connection started; zero if down
+	up    time.Duration // total of earlier connections
+	down  time.Time     // when it last disconnected
+}
+
+type uptimeLog struct {
+	mtx     sync.Mutex
+	started time.Time
+	peers   map[uint32]*peerUptime
+}
+
+func newUptimeLog() *uptimeLog {
+	return &uptimeLog{started: time.Now(), peers: make(map[uint32]*peerUptime)}
+}
+
+// peerUp notes a peer connecting
+func (nd *LitNode) peerUp(idx uint32) {
+	nd.uptime.mtx.Lock()
+	defer nd.uptime.mtx.Unlock()
+	p, ok := nd.uptime.peers[idx]
+	if !ok {
+		p = new(peerUptime)
+		nd.uptime.peers[idx] = p
+	}
+	if p.since.IsZero() {
+		p.since = time.Now()
+	}
+}
+
+// peerDown notes a peer disconnecting
+func (nd *LitNode) peerDown(idx uint32) {
+	nd.uptime.mtx.Lock()
+	defer nd.uptime.mtx.Unlock()
+	p, ok := nd.uptime.peers[idx]
+	if !ok || p.since.IsZero() {
+		return
+	}
+	p.up += time.Since(p.since)
+	p.since = time.Time{}
+	p.down = time.Now()
+}
+
+// PeerOfflineSince is when a peer was last connected; zero if it is now.
+// One that hasn't connected since the node started has been away since
+// then, as far as we know.
+func (nd *LitNode) PeerOfflineSince(idx uint32) time.Time {
+	nd.uptime.mtx.Lock()
+	defer nd.uptime.mtx.Unlock()
+	p, ok := nd.uptime.peers[idx]
+	if !ok {
+		return nd.uptime.started
+	}
+	if !p.since.IsZero() {
+		return time.Time{}
+	}
+	return p.down
+}
+
+// PeerUptime is the fraction of the time since the node started that a
+// peer's been connected, from 0 to 1
+func (nd *LitNode) PeerUptime(idx uint32) float64 {
+	nd.uptime.mtx.Lock()
+	defer nd.uptime.mtx.Unlock()
+	p, ok := nd.uptime.peers[idx]
+	if !ok {
+		return 0
+	}
+	up := p.up
+	if !p.since.IsZero() {
+		up += time.Since(p.since)
+	}
+	total := time.Since(nd.uptime.started)
+	if total <= 0 {
+		return 1
+	}
+	frac := float64(up) / float64(total)
+	if frac > 1 {
+		frac = 1
+	}
+	return frac
+}
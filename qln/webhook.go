@@ -0,0 +1,97 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Webhooks POST every node event as json to user specified URLs.  If a
+secret is given, the body is signed with HMAC-SHA256 and the hex of the
+mac is put in the X-Lit-Signature header so receivers can check that it
+came from this node.
+
+Failed posts (network error or non-2xx status) are retried with
+exponential backoff, up to webhookMaxTries times, then dropped.
+*/
+
+const (
+	webhookMaxTries  = 6
+	webhookFirstWait = 2 * time.Second
+	webhookTimeout   = 10 * time.Second
+)
+
+// StartWebhooks subscribes to node events and posts them to the given urls.
+// Does nothing if there are no urls.
+func (nd *LitNode) StartWebhooks(urls []string, secret string) {
+	if len(urls) == 0 {
+		return
+	}
+	sub := nd.SubscribeEvents()
+	client := lnutil.HTTPClient(webhookTimeout)
+
+	go func() {
+		for ev := range sub {
+			body, err := json.Marshal(ev)
+			if err != nil {
+				logger.Errorf("webhook marshal error: %s\n", err.Error())
+				continue
+			}
+			for _, u := range urls {
+				go postWebhook(client, u, body, secret)
+			}
+		}
+	}()
+}
+
+// WebhookSignature returns the hex HMAC-SHA256 of body with the secret key.
+func WebhookSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook delivers one payload to one url, retrying on failure
+func postWebhook(client *http.Client, url string, body []byte, secret string) {
+	wait := webhookFirstWait
+	for try := 1; try <= webhookMaxTries; try++ {
+		err := postWebhookOnce(client, url, body, secret)
+		if err == nil {
+			return
+		}
+		logger.Warnf("webhook %s try %d/%d failed: %s\n",
+			url, try, webhookMaxTries, err.Error())
+		time.Sleep(wait)
+		wait *= 2
+	}
+	logger.Errorf("webhook %s giving up on %s\n", url, string(body))
+}
+
+func postWebhookOnce(
+	client *http.Client, url string, body []byte, secret string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Lit-Signature", WebhookSignature(body, secret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
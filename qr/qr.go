@@ -0,0 +1,511 @@
+package qr
+
+import (
+	"fmt"
+)
+
+/*
+A small QR code encoder, so lit can show addresses, node addresses and
+invoices without anything else installed.  Only byte mode (everything lit
+wants to show is short ascii), any version 1 to 40, picking the smallest
+that fits, and the mask with the lowest penalty score, per ISO 18004.
+
+The tables and the layout follow the spec; the block structure is worked
+out from the ecc codewords per block and the number of blocks, the way
+most small encoders do it, rather than from the full version table.
+*/
+
+// Level is how much of the code can be damaged and still read
+type Level int
+
+const (
+	L Level = iota // ~7%
+	M              // ~15%
+	Q              // ~25%
+	H              // ~30%
+)
+
+// format info bits for each level; not in order
+var levelBits = [4]uint{L: 1, M: 0, Q: 3, H: 2}
+
+// ecc codewords per block, by level and version
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// number of ecc blocks, by level and version
+var numBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is an encoded QR code, Size modules square, without the quiet zone
+type Code struct {
+	Size    int
+	Version int
+	Level   Level
+
+	dark [][]bool
+	fn   [][]bool // function modules, which masks skip
+}
+
+// Black says whether the module at x, y (0, 0 top left) is dark
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.dark[y][x]
+}
+
+// Encode makes the smallest code holding data at the given level
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, fmt.Errorf("qr: bad level %d", level)
+	}
+	ver := 1
+	for ; ver <= 40; ver++ {
+		if 4+countBits(ver)+8*len(data) <= 8*dataCodewords(ver, level) {
+			break
+		}
+	}
+	if ver > 40 {
+		return nil, fmt.Errorf("qr: %d bytes too long", len(data))
+	}
+
+	// mode, count, data, terminator, pad to bytes, pad codewords
+	var bb bitBuf
+	bb.append(4, 4) // byte mode
+	bb.append(uint(len(data)), countBits(ver))
+	for _, b := range data {
+		bb.append(uint(b), 8)
+	}
+	capBits := 8 * dataCodewords(ver, level)
+	term := capBits - bb.n
+	if term > 4 {
+		term = 4
+	}
+	bb.append(0, term)
+	bb.append(0, (8-bb.n%8)%8)
+	for pad := uint(0xec); len(bb.b) < dataCodewords(ver, level); pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	c := newCode(ver, level)
+	c.drawFunctions()
+	c.drawCodewords(c.interleave(bb.b))
+
+	// try every mask, keep the best
+	best, bestScore := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		score := c.penalty()
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		c.applyMask(mask) // xor again to undo
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c, nil
+}
+
+// EncodeString is Encode for strings, at level M
+func EncodeString(s string) (*Code, error) {
+	return Encode([]byte(s), M)
+}
+
+type bitBuf struct {
+	b []byte
+	n int // bits
+}
+
+func (bb *bitBuf) append(v uint, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if bb.n%8 == 0 {
+			bb.b = append(bb.b, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			bb.b[bb.n/8] |= 0x80 >> uint(bb.n%8)
+		}
+		bb.n++
+	}
+}
+
+// countBits is the size of the byte mode character count
+func countBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules is how many modules hold codewords (data and ecc), including
+// remainder bits
+func rawModules(ver int) int {
+	n := (16*ver+128)*ver + 64
+	if ver >= 2 {
+		numAlign := ver/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if ver >= 7 {
+			n -= 36 // version info
+		}
+	}
+	return n
+}
+
+func dataCodewords(ver int, level Level) int {
+	return rawModules(ver)/8 - eccPerBlock[level][ver]*numBlocks[level][ver]
+}
+
+// alignPositions are the centers of the alignment patterns on each axis
+func alignPositions(ver int) []int {
+	if ver == 1 {
+		return nil
+	}
+	numAlign := ver/7 + 2
+	step := (ver*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	if ver == 32 {
+		step = 26
+	}
+	pos := make([]int, numAlign)
+	pos[0] = 6
+	for i, p := numAlign-1, ver*4+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func newCode(ver int, level Level) *Code {
+	size := ver*4 + 17
+	c := &Code{Size: size, Version: ver, Level: level}
+	c.dark = make([][]bool, size)
+	c.fn = make([][]bool, size)
+	for i := range c.dark {
+		c.dark[i] = make([]bool, size)
+		c.fn[i] = make([]bool, size)
+	}
+	return c
+}
+
+func (c *Code) setFn(x, y int, dark bool) {
+	c.dark[y][x] = dark
+	c.fn[y][x] = true
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// drawFunctions draws everything but the codewords: timing, finders,
+// alignment, version info, and space for the format info
+func (c *Code) drawFunctions() {
+	for i := 0; i < c.Size; i++ {
+		c.setFn(6, i, i%2 == 0)
+		c.setFn(i, 6, i%2 == 0)
+	}
+	// finders, with their separators
+	for _, f := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := f[0]+dx, f[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				c.setFn(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	// alignment, except where the finders are
+	pos := alignPositions(c.Version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFn(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	c.drawFormat(0) // reserve; redrawn with the real mask
+	if c.Version >= 7 {
+		rem := c.Version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+		}
+		bits := c.Version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := bits>>uint(i)&1 == 1
+			a, b := c.Size-11+i%3, i/3
+			c.setFn(a, b, bit)
+			c.setFn(b, a, bit)
+		}
+	}
+}
+
+// formatBits is the 15 bit format info for a level and mask
+func formatBits(level Level, mask int) int {
+	data := int(levelBits[level])<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(c.Level, mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	// around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFn(8, i, bit(i))
+	}
+	c.setFn(8, 7, bit(6))
+	c.setFn(8, 8, bit(7))
+	c.setFn(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFn(14-i, 8, bit(i))
+	}
+	// split between the other two
+	for i := 0; i < 8; i++ {
+		c.setFn(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFn(8, c.Size-15+i, bit(i))
+	}
+	c.setFn(8, c.Size-8, true) // always dark
+}
+
+// interleave splits the data into blocks, adds ecc to each, and
+// interleaves them
+func (c *Code) interleave(data []byte) []byte {
+	nBlocks := numBlocks[c.Level][c.Version]
+	eccLen := eccPerBlock[c.Level][c.Version]
+	raw := rawModules(c.Version) / 8
+	numShort := nBlocks - raw%nBlocks
+	shortLen := raw / nBlocks
+
+	div := rsDivisor(eccLen)
+	blocks := make([][]byte, nBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dat := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(dat, div)
+		if i < numShort {
+			dat = append(dat, 0) // placeholder so all blocks line up
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+
+	out := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, b := range blocks {
+			// skip the short blocks' placeholders
+			if i != shortLen-eccLen || j >= numShort {
+				out = append(out, b[i])
+			}
+		}
+	}
+	return out
+}
+
+// drawCodewords fills the non function modules, two columns at a time
+// zigzagging up and down from the bottom right
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the timing column
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert // going up
+				}
+				if c.fn[y][x] || i >= len(data)*8 {
+					continue // remainder bits stay light
+				}
+				c.dark[y][x] = data[i/8]>>uint(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask xors the mask over the non function modules; doing it twice
+// undoes it
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !c.fn[y][x] {
+				c.dark[y][x] = !c.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked code; lower is easier to read
+func (c *Code) penalty() int {
+	score := 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return c.dark[x][y]
+		}
+		return c.dark[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < c.Size; y++ {
+			// runs of 5 or more the same
+			run := 1
+			for x := 1; x <= c.Size; x++ {
+				if x < c.Size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			// 1:1:3:1:1 with 4 light on one side
+			for x := 0; x+7 <= c.Size; x++ {
+				match := true
+				for i, d := range finder {
+					if at(x+i, y, vertical) != d {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				if c.lightRun(x-4, y, vertical) || c.lightRun(x+7, y, vertical) {
+					score += 40
+				}
+			}
+		}
+	}
+	// 2x2 blocks
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.dark[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size &&
+				c.dark[y][x] == c.dark[y][x+1] &&
+				c.dark[y][x] == c.dark[y+1][x] &&
+				c.dark[y][x] == c.dark[y+1][x+1] {
+				score += 3
+			}
+		}
+	}
+	// balance of dark and light
+	pct := dark * 100 / (c.Size * c.Size)
+	score += abs(pct-50) / 5 * 10
+	return score
+}
+
+// lightRun says whether the 4 modules from x are light; outside the code
+// counts as light
+func (c *Code) lightRun(x, y int, vertical bool) bool {
+	for i := x; i < x+4; i++ {
+		if i < 0 || i >= c.Size {
+			continue
+		}
+		if vertical && c.dark[i][y] || !vertical && c.dark[y][i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------------------- reed solomon over GF(256), poly 0x11d
+
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor is the generator polynomial of the given degree, highest
+// power first, without the leading 1
+func rsDivisor(degree int) []byte {
+	div := make([]byte, degree)
+	div[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range div {
+			div[j] = gfMul(div[j], root)
+			if j+1 < len(div) {
+				div[j] ^= div[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return div
+}
+
+// rsRemainder is the ecc codewords for data
+func rsRemainder(data, div []byte) []byte {
+	rem := make([]byte, len(div))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(div[i], factor)
+		}
+	}
+	return rem
+}
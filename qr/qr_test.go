@@ -0,0 +1,225 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// the worked example in the spec: 1-M, "HELLO WORLD"
+func TestRSRemainder(t *testing.T) {
+	data := []byte{0x20, 0x5b, 0x0b, 0x78, 0xd1, 0x72, 0xdc, 0x4d,
+		0x43, 0x40, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	want := []byte{0xc4, 0x23, 0x27, 0x77, 0xeb, 0xd7, 0xe7, 0xe2, 0x5d, 0x17}
+	got := rsRemainder(data, rsDivisor(10))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("ecc %x, expect %x", got, want)
+	}
+}
+
+func TestFormatVersionBits(t *testing.T) {
+	if fb := formatBits(M, 0); fb != 0x5412 {
+		t.Fatalf("M mask 0 format %x", fb)
+	}
+	if fb := formatBits(L, 0); fb != 0x77c4 {
+		t.Fatalf("L mask 0 format %x", fb)
+	}
+	c := newCode(7, L)
+	c.drawFunctions()
+	// version 7 info is 000111 110010 010100, low bit at the top right
+	// of the bottom left block
+	want := 0x07c94
+	var got int
+	for i := 0; i < 18; i++ {
+		if c.dark[c.Size-11+i%3][i/3] {
+			got |= 1 << uint(i)
+		}
+	}
+	if got != want {
+		t.Fatalf("version info %x, expect %x", got, want)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	// byte mode capacities from the spec
+	cases := []struct {
+		ver   int
+		level Level
+		bytes int
+	}{
+		{1, L, 17}, {1, M, 14}, {1, H, 7},
+		{7, L, 154}, {10, M, 213},
+		{40, L, 2953}, {40, M, 2331}, {40, H, 1273},
+	}
+	for _, c := range cases {
+		got := (8*dataCodewords(c.ver, c.level) - 4 - countBits(c.ver)) / 8
+		if got != c.bytes {
+			t.Errorf("version %d level %d holds %d, expect %d",
+				c.ver, c.level, got, c.bytes)
+		}
+	}
+	if pos := alignPositions(7); len(pos) != 3 || pos[1] != 22 || pos[2] != 38 {
+		t.Errorf("version 7 alignment %v", pos)
+	}
+	if pos := alignPositions(32); pos[1] != 34 || pos[5] != 138 {
+		t.Errorf("version 32 alignment %v", pos)
+	}
+}
+
+// readBack undoes Encode enough to get the data bytes out again
+func readBack(t *testing.T, c *Code) []byte {
+	// format info, first copy
+	var fb int
+	for i := 0; i <= 5; i++ {
+		if c.dark[i][8] {
+			fb |= 1 << uint(i)
+		}
+	}
+	for i, xy := range [][2]int{{8, 7}, {8, 8}, {7, 8}} {
+		if c.dark[xy[1]][xy[0]] {
+			fb |= 1 << uint(6+i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if c.dark[8][14-i] {
+			fb |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(c.Level, m) == fb {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format info %x doesn't match level %d", fb, c.Level)
+	}
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+
+	raw := make([]byte, rawModules(c.Version)/8)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.fn[y][x] || i >= len(raw)*8 {
+					continue
+				}
+				if c.dark[y][x] {
+					raw[i/8] |= 0x80 >> uint(i%8)
+				}
+				i++
+			}
+		}
+	}
+
+	// de-interleave the data part of each block, checking the ecc
+	nBlocks := numBlocks[c.Level][c.Version]
+	eccLen := eccPerBlock[c.Level][c.Version]
+	numShort := nBlocks - len(raw)%nBlocks
+	shortData := len(raw)/nBlocks - eccLen
+	blocks := make([][]byte, nBlocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	div := rsDivisor(eccLen)
+	var data []byte
+	for j := range blocks {
+		ecc := make([]byte, eccLen)
+		for i := range ecc {
+			ecc[i] = raw[k+i*nBlocks+j]
+		}
+		if !bytes.Equal(ecc, rsRemainder(blocks[j], div)) {
+			t.Fatalf("block %d ecc doesn't match", j)
+		}
+		data = append(data, blocks[j]...)
+	}
+
+	if data[0]>>4 != 4 {
+		t.Fatalf("mode %x, expect byte mode", data[0]>>4)
+	}
+	// skip the mode nibble
+	var bits []byte
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, b>>uint(i)&1)
+		}
+	}
+	read := func(pos, n int) int {
+		v := 0
+		for _, b := range bits[pos : pos+n] {
+			v = v<<1 | int(b)
+		}
+		return v
+	}
+	cb := countBits(c.Version)
+	n := read(4, cb)
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(read(4+cb+8*i, 8))
+	}
+	return out
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"HELLO WORLD",
+		"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+		"ln1xyz7890abcdefghijklmnopq@lit.example.com:2448",
+		strings.Repeat("lntb1500n1pwxyzabc", 30),
+	}
+	for _, in := range inputs {
+		for level := L; level <= H; level++ {
+			c, err := Encode([]byte(in), level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size != c.Version*4+17 {
+				t.Fatalf("size %d for version %d", c.Size, c.Version)
+			}
+			got := readBack(t, c)
+			if string(got) != in {
+				t.Fatalf("level %d read back %q, expect %q", level, got, in)
+			}
+		}
+	}
+	_, err := Encode(make([]byte, 2954), L)
+	if err == nil {
+		t.Fatalf("2954 bytes should be too long")
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := EncodeString("lit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.PNG(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(p, []byte("\x89PNG")) {
+		t.Fatalf("not a png")
+	}
+	if !strings.HasPrefix(c.SVG(4), "<svg") {
+		t.Fatalf("not an svg")
+	}
+	lines := strings.Split(strings.TrimSuffix(c.Terminal(), "\n"), "\n")
+	if len(lines) != (c.Size+quietZone+1)/2 {
+		t.Fatalf("%d terminal lines for size %d", len(lines), c.Size)
+	}
+}
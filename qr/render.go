@@ -0,0 +1,86 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// quietZone is the light border readers need around the code, in modules
+const quietZone = 4
+
+// PNG draws the code scale pixels per module, with the quiet zone
+func (c *Code) PNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for py := 0; py < side; py++ {
+		for px := 0; px < side; px++ {
+			shade := color.Gray{0xff}
+			if c.Black(px/scale-quietZone, py/scale-quietZone) {
+				shade = color.Gray{0}
+			}
+			img.SetGray(px, py, shade)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG draws the code as one path, scale units per module, with the quiet
+// zone
+func (c *Code) SVG(scale int) string {
+	if scale < 1 {
+		scale = 1
+	}
+	side := c.Size + 2*quietZone
+	var path bytes.Buffer
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/>`+
+		`<path fill="#000" d="%s"/></svg>`,
+		side*scale, side*scale, side, side, path.String())
+}
+
+// Terminal draws the code with unicode half blocks, two rows of modules
+// per line of text, in white on black so it reads the same whatever the
+// terminal colors are.  Half the quiet zone is enough on a screen.
+func (c *Code) Terminal() string {
+	var sb bytes.Buffer
+	lo, hi := -quietZone/2, c.Size+quietZone/2
+	for y := lo; y < hi; y += 2 {
+		sb.WriteString("\x1b[40;97m")
+		for x := lo; x < hi; x++ {
+			top, bottom := c.Black(x, y), c.Black(x, y+1)
+			// the block characters draw light, so they go where the code is
+			// light; dark modules are the black background
+			switch {
+			case top && bottom:
+				sb.WriteString(" ")
+			case top:
+				sb.WriteString("▄")
+			case bottom:
+				sb.WriteString("▀")
+			default:
+				sb.WriteString("█")
+			}
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	return sb.String()
+}
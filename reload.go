@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+Most settings are only read once at startup; changing coins, hosts,
+ports or the home dir needs a restart.  Some can be changed while
+running, by editing lit.conf and then either sending lit a SIGHUP or
+calling the ReloadConfig RPC.  Those are applied in applyHotConfig.
+
+On reload only the config file is read; command line flags are not
+re-applied.
+*/
+
+// applyHotConfig sets the values which can change while the node runs.
+// Zero values mean "not set" and leave the current setting alone.
+func applyHotConfig(node *qln.LitNode, conf *config) {
+	err := lnutil.SetLogLevels(conf.LogLevel)
+	if err != nil {
+		log.Printf("loglevel: %s\n", err.Error())
+	}
+	if conf.Fee != 0 {
+		for cointype, wal := range node.SubWallet {
+			wal.SetFee(conf.Fee)
+			log.Printf("set fee rate for coin %d to %d\n", cointype, conf.Fee)
+		}
+	}
+	node.JusticeAnyoneCanPay = conf.JusticeACP
+	// unlike the others, taking these out of the file turns them off
+	node.SetFeeCeiling(conf.FeeCeiling)
+	node.SetOpenOnRequest(conf.OpenOnRequest)
+	var tiers []lnutil.LeaseTier
+	for _, s := range conf.LeaseTiers {
+		t, err := qln.ParseLeaseTier(s)
+		if err != nil {
+			log.Printf("%s\n", err.Error())
+			continue
+		}
+		tiers = append(tiers, t)
+	}
+	node.SetLeaseTiers(tiers)
+	targets := qln.DefaultSweepTargets
+	for _, s := range conf.SweepTargets {
+		class, blocks, err := qln.ParseSweepTarget(s)
+		if err != nil {
+			log.Printf("%s\n", err.Error())
+			continue
+		}
+		targets[class] = blocks
+	}
+	node.SetSweepTargets(targets)
+	err = node.SetTowerClients(conf.TowerClients)
+	if err != nil {
+		log.Printf("towerclient: %s\n", err.Error())
+	}
+}
+
+// reloadConfig re-reads the config file and applies the hot values to
+// each node.
+func reloadConfig(confPath string, nodes ...*qln.LitNode) error {
+	var conf config
+	parser := newConfigParser(&conf, flags.Default)
+	err := flags.NewIniParser(parser).ParseFile(confPath)
+	if err != nil {
+		return fmt.Errorf("reload %s: %s", confPath, err.Error())
+	}
+	for _, node := range nodes {
+		applyHotConfig(node, &conf)
+	}
+	log.Printf("reloaded config from %s\n", confPath)
+	return nil
+}
+
+// reloadOnHUP reloads the config file each time lit gets a SIGHUP.
+func reloadOnHUP(confPath string, nodes ...*qln.LitNode) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		err := reloadConfig(confPath, nodes...)
+		if err != nil {
+			log.Printf("%s\n", err.Error())
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package sig64
 
-import "fmt"
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/adiabat/btcd/btcec"
+)
 
 // signatures are like 71 or 70 bytes, variable length... this really bugs me!
 // But I've lived with it.  But with this, the (txid, sig) pair is slightly over
@@ -8,6 +13,12 @@ import "fmt"
 // state data be exactly 100 bytes.  So these functions "compress" the signatures
 // into 65 bytes, and restore back into the ~71 byte normal lenght.
 
+// Compressed sigs are always low-S: if s is over half the curve order it's
+// replaced with N - s, which is the same signature as far as ecdsa is
+// concerned but the only one standardness rules will relay.
+
+var halfOrder = new(big.Int).Rsh(btcec.S256().N, 1)
+
 func SigCompress(sig []byte) (csig [64]byte, err error) {
 	if len(sig) < 68 || len(sig) > 72 {
 		err = fmt.Errorf("Can't compress; sig length is %d", len(sig))
@@ -32,6 +43,7 @@ func SigCompress(sig []byte) (csig [64]byte, err error) {
 	if int(sig[0]) != len(sig)-1 {
 		err = fmt.Errorf("length byte is %x (%d) but rest is %d bytes long",
 			sig[0], sig[0], len(sig)-1)
+		return
 	}
 	// pop off another byte (the length byte)
 	sig = sig[1:]
@@ -56,8 +68,13 @@ func SigCompress(sig []byte) (csig [64]byte, err error) {
 	if int(rlen) > len(sig) {
 		err = fmt.Errorf("length of r value %d but only %d bytes left ",
 			rlen, len(sig))
+		return
 	}
 	if rlen == 33 { //drop 0 byte if rlen is 33
+		if sig[0] != 0x00 {
+			err = fmt.Errorf("33 byte r starts with %x, not 00", sig[0])
+			return
+		}
 		sig = sig[1:]
 		rlen = 32
 	}
@@ -66,6 +83,11 @@ func SigCompress(sig []byte) (csig [64]byte, err error) {
 	// pop off r
 	sig = sig[rlen:]
 
+	// need at least the 0x02 and s length bytes
+	if len(sig) < 2 {
+		err = fmt.Errorf("sig ends after r")
+		return
+	}
 	// 0x02 again, just for fun
 	if sig[0] != 0x02 {
 		err = fmt.Errorf("should have an 0x02 byte, %x instead", sig[0])
@@ -86,14 +108,29 @@ func SigCompress(sig []byte) (csig [64]byte, err error) {
 	if int(slen) > len(sig) {
 		err = fmt.Errorf("length of s value %d but only %d bytes left ",
 			slen, len(sig))
+		return
 	}
 	if slen == 33 { //drop 0 byte if slen is 33
+		if sig[0] != 0x00 {
+			err = fmt.Errorf("33 byte s starts with %x, not 00", sig[0])
+			return
+		}
 		sig = sig[1:]
 		slen = 32
 	}
 	// copy s, leaving 0s at the MSB
 	copy(s[32-slen:], sig[:slen])
 	// we're done with sig
+
+	// flip s to the low side of the curve order if it isn't
+	sInt := new(big.Int).SetBytes(s[:])
+	if sInt.Cmp(halfOrder) == 1 {
+		sInt.Sub(btcec.S256().N, sInt)
+		s = [32]byte{}
+		sBytes := sInt.Bytes()
+		copy(s[32-len(sBytes):], sBytes)
+	}
+
 	// serialize compressed sig as r, s
 	copy(csig[0:32], r[:])
 	copy(csig[32:64], s[:])
@@ -171,3 +208,51 @@ func SigDecompress(csig [64]byte) (sig []byte) {
 
 	return
 }
+
+// SigCheck makes sure a compressed sig could be a valid low-S signature:
+// r and s both nonzero and under the curve order, and s at most half of it.
+// SigDecompress will happily expand anything, so sigs from the network
+// should go through this first.
+func SigCheck(csig [64]byte) error {
+	r := new(big.Int).SetBytes(csig[:32])
+	s := new(big.Int).SetBytes(csig[32:])
+	if r.Sign() == 0 || r.Cmp(btcec.S256().N) != -1 {
+		return fmt.Errorf("r %x out of range", csig[:32])
+	}
+	if s.Sign() == 0 {
+		return fmt.Errorf("s is 0")
+	}
+	if s.Cmp(halfOrder) == 1 {
+		return fmt.Errorf("s %x is high", csig[32:])
+	}
+	return nil
+}
+
+// BatchVerify checks a set of compressed sigs, each against its own pubkey
+// and message hash, and returns the indexes of the ones that don't verify.
+// Sigs failing SigCheck count as not verifying.  The sigs are checked
+// straight from their r, s values, skipping the DER round trip.  Only
+// errors if the slices aren't all the same length.
+func BatchVerify(
+	csigs [][64]byte, pubs []*btcec.PublicKey, hashes [][]byte) ([]int, error) {
+
+	if len(csigs) != len(pubs) || len(csigs) != len(hashes) {
+		return nil, fmt.Errorf("%d sigs, %d pubkeys, %d hashes",
+			len(csigs), len(pubs), len(hashes))
+	}
+	var bad []int
+	for i, csig := range csigs {
+		if pubs[i] == nil || SigCheck(csig) != nil {
+			bad = append(bad, i)
+			continue
+		}
+		sig := btcec.Signature{
+			R: new(big.Int).SetBytes(csig[:32]),
+			S: new(big.Int).SetBytes(csig[32:]),
+		}
+		if !sig.Verify(hashes[i], pubs[i]) {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
@@ -68,3 +68,106 @@ func TestHardCoded(t *testing.T) {
 	t.Logf("dec1:\n%x\n", r3)
 
 }
+
+// TestLowS makes sure high-S sigs come out of compression low-S, and still
+// verify
+func TestLowS(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey(btcec.S256())
+	hash := chainhash.DoubleHashB([]byte("low s"))
+	sig, err := priv.Sign(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowsig, err := SigCompress(sig.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip s to the high side
+	sig.S.Sub(btcec.S256().N, sig.S)
+	highsig, err := SigCompress(sig.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if highsig != lowsig {
+		t.Fatalf("high s not normalized:\n%x\n%x\n", highsig, lowsig)
+	}
+	err = SigCheck(highsig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := BatchVerify(
+		[][64]byte{highsig}, []*btcec.PublicKey{priv.PubKey()}, [][]byte{hash})
+	if err != nil || len(bad) != 0 {
+		t.Fatalf("normalized sig doesn't verify: %v %v", bad, err)
+	}
+}
+
+// TestBatchVerify checks a batch with some broken sigs in it
+func TestBatchVerify(t *testing.T) {
+	var csigs [][64]byte
+	var pubs []*btcec.PublicKey
+	var hashes [][]byte
+	for i := 0; i < 8; i++ {
+		priv, _ := btcec.NewPrivateKey(btcec.S256())
+		hash := chainhash.DoubleHashB([]byte{byte(i)})
+		sig, err := priv.Sign(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csig, err := SigCompress(sig.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		csigs = append(csigs, csig)
+		pubs = append(pubs, priv.PubKey())
+		hashes = append(hashes, hash)
+	}
+	// wrong message, flipped bit, zero s, missing key
+	hashes[1] = hashes[0]
+	csigs[3][5] ^= 0x01
+	copy(csigs[5][32:], make([]byte, 32))
+	pubs[6] = nil
+
+	bad, err := BatchVerify(csigs, pubs, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []int{1, 3, 5, 6}
+	if len(bad) != len(expect) {
+		t.Fatalf("bad sigs %v, expect %v", bad, expect)
+	}
+	for i := range bad {
+		if bad[i] != expect[i] {
+			t.Fatalf("bad sigs %v, expect %v", bad, expect)
+		}
+	}
+
+	_, err = BatchVerify(csigs, pubs[1:], hashes)
+	if err == nil {
+		t.Fatalf("mismatched batch didn't error")
+	}
+}
+
+// TestGarbage throws truncated and mangled sigs at compress and decompress;
+// they can error but mustn't panic
+func TestGarbage(t *testing.T) {
+	for _, big := range [][]byte{sig1big, sig2big, sig3big} {
+		for i := 0; i < len(big); i++ {
+			SigCompress(big[:i])
+			for b := 0; b < 256; b++ {
+				mangled := append([]byte{}, big...)
+				mangled[i] = byte(b)
+				SigCompress(mangled)
+			}
+		}
+	}
+	var csig [64]byte
+	for i := 0; i < 64; i++ {
+		SigDecompress(csig)
+		if SigCheck(csig) == nil {
+			t.Fatalf("%x passed check", csig)
+		}
+		csig[i] = 0xff
+	}
+	SigDecompress(csig)
+}
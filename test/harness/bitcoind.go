@@ -0,0 +1,222 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rpcUser = "regtestuser"
+	rpcPass = "regtestpass"
+)
+
+// Bitcoind is a regtest bitcoind run for a test, and its RPC
+type Bitcoind struct {
+	Dir     string
+	P2PPort int
+	RPCPort int
+
+	cmd    *exec.Cmd
+	mtx    sync.Mutex
+	nextID int
+}
+
+// StartBitcoind starts bitcoind -regtest in dir, on free ports, and waits
+// for its RPC to come up.  The binary is $BITCOIND if set, or bitcoind on
+// the path.
+func StartBitcoind(dir string) (*Bitcoind, error) {
+	bin := os.Getenv("BITCOIND")
+	if bin == "" {
+		bin = "bitcoind"
+	}
+	bin, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, err
+	}
+	b := new(Bitcoind)
+	b.Dir = filepath.Join(dir, "bitcoind")
+	err = os.MkdirAll(b.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	b.P2PPort, err = freePort()
+	if err != nil {
+		return nil, err
+	}
+	b.RPCPort, err = freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	b.cmd = exec.Command(bin, "-regtest",
+		"-datadir="+b.Dir,
+		fmt.Sprintf("-port=%d", b.P2PPort),
+		fmt.Sprintf("-rpcport=%d", b.RPCPort),
+		"-rpcuser="+rpcUser, "-rpcpassword="+rpcPass,
+		"-bind=127.0.0.1", "-whitelist=127.0.0.1",
+		"-fallbackfee=0.0002", "-listenonion=0", "-server")
+	logf, err := os.Create(filepath.Join(b.Dir, "stdout.log"))
+	if err == nil {
+		b.cmd.Stdout = logf
+		b.cmd.Stderr = logf
+	}
+	err = b.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	// wait for RPC; it errors while bitcoind is warming up
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		_, err = b.BlockCount()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			b.Stop()
+			return nil, fmt.Errorf("bitcoind didn't start: %s", err.Error())
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	// newer bitcoinds have no wallet until one's made; older ones have
+	// one already and don't know createwallet
+	err = b.Call("createwallet", nil, "harness")
+	if err != nil && !strings.Contains(err.Error(), "already exists") &&
+		!strings.Contains(err.Error(), "Method not found") {
+		b.Stop()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Host is where lit connects to bitcoind
+func (b *Bitcoind) Host() string {
+	return fmt.Sprintf("127.0.0.1:%d", b.P2PPort)
+}
+
+// Stop shuts bitcoind down
+func (b *Bitcoind) Stop() {
+	if b.cmd == nil || b.cmd.Process == nil {
+		return
+	}
+	b.Call("stop", nil)
+	done := make(chan error, 1)
+	go func() { done <- b.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		b.cmd.Process.Kill()
+	}
+}
+
+type rpcError struct {
+	Code    int
+	Message string
+}
+
+// Call makes a JSON-RPC call to bitcoind, putting the result in res if
+// it's not nil
+func (b *Bitcoind) Call(method string, res interface{}, params ...interface{}) error {
+	b.mtx.Lock()
+	b.nextID++
+	id := b.nextID
+	b.mtx.Unlock()
+	if params == nil {
+		params = []interface{}{}
+	}
+	req, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequest("POST",
+		fmt.Sprintf("http://127.0.0.1:%d/", b.RPCPort), bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	hreq.SetBasicAuth(rpcUser, rpcPass)
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var reply struct {
+		Result json.RawMessage
+		Error  *rpcError
+	}
+	err = json.Unmarshal(body, &reply)
+	if err != nil {
+		return fmt.Errorf("%s: %s %s", method, resp.Status, string(body))
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, reply.Error.Message, reply.Error.Code)
+	}
+	if res != nil {
+		return json.Unmarshal(reply.Result, res)
+	}
+	return nil
+}
+
+// BlockCount is the height of the chain
+func (b *Bitcoind) BlockCount() (int32, error) {
+	var h int32
+	err := b.Call("getblockcount", &h)
+	return h, err
+}
+
+// Generate mines n blocks to bitcoind's wallet
+func (b *Bitcoind) Generate(n int) error {
+	var adr string
+	err := b.Call("getnewaddress", &adr)
+	if err != nil {
+		return err
+	}
+	return b.Call("generatetoaddress", nil, n, adr)
+}
+
+// SendToAddress pays sats to adr from bitcoind's wallet, returning the
+// txid
+func (b *Bitcoind) SendToAddress(adr string, sats int64) (string, error) {
+	var txid string
+	err := b.Call("sendtoaddress", &txid, adr, float64(sats)/1e8)
+	return txid, err
+}
+
+// SendRawTransaction broadcasts a tx given in hex
+func (b *Bitcoind) SendRawTransaction(txHex string) (string, error) {
+	var txid string
+	err := b.Call("sendrawtransaction", &txid, txHex)
+	return txid, err
+}
+
+// MempoolSize is how many txs are waiting for a block
+func (b *Bitcoind) MempoolSize() (int, error) {
+	var txids []string
+	err := b.Call("getrawmempool", &txids)
+	return len(txids), err
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,343 @@
+/*
+Package harness runs lit nodes in-process against a regtest bitcoind, for
+end to end tests of lit and of things built on it.  A test makes a
+Harness, adds nodes, and scripts what happens:
+
+	h := harness.New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 20000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+	h.Push(alice, ch, 3000000)
+	h.AssertChannelBalance(bob, h.ChanIdx(bob, alice), 3000000)
+
+Each node is a qln.LitNode with its own directory and a litrpc.LitRPC,
+so the calls are the same ones lit-af makes.  Helpers that change the
+chain mine the blocks they need and wait for every node to sync, and
+anything that fails stops the test with t.Fatalf.
+
+Breaches are scripted by taking a Snapshot of a channel's signed state
+tx, moving the channel on, and broadcasting the snapshot with Breach.
+Towers are nodes made with tower set; WatchWith points a node at one, and
+UploadTo adds one to the towers a node uploads to (see qln/towerset.go).
+
+Tests using the harness are skipped if there's no bitcoind on the path
+(or at $BITCOIND).  Nodes keep their DBs in ephemeral folders (see
+qln.EphemeralDataDirs), on tmpfs where there is one; set LITHARNESS_KEEP
+to put them under the harness directory and keep them.
+*/
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+)
+
+// Harness is a regtest chain and the lit nodes on it
+type Harness struct {
+	T       testing.TB
+	Dir     string
+	Chain   *Bitcoind
+	Param   *coinparam.Params
+	Timeout time.Duration // how long WaitFor waits
+
+	mtx   sync.Mutex
+	nodes []*Node
+}
+
+// New starts bitcoind and mines past segwit activation and lit's birth
+// height.  It skips the test if there's no bitcoind.
+func New(t testing.TB) *Harness {
+	if os.Getenv("BITCOIND") == "" {
+		_, err := exec.LookPath("bitcoind")
+		if err != nil {
+			t.Skip("no bitcoind on the path; skipping regtest harness test")
+		}
+	}
+	h := new(Harness)
+	h.T = t
+	h.Param = &coinparam.RegressionNetParams
+	h.Timeout = 60 * time.Second
+	var err error
+	h.Dir, err = ioutil.TempDir("", "litharness")
+	if err != nil {
+		t.Fatalf("harness dir: %s", err.Error())
+	}
+	h.Chain, err = StartBitcoind(h.Dir)
+	if err != nil {
+		os.RemoveAll(h.Dir)
+		t.Fatalf("start bitcoind: %s", err.Error())
+	}
+	// coinbases need 100 blocks to mature; lit starts at BirthHeight
+	err = h.Chain.Generate(int(h.Param.BirthHeight) + 300)
+	if err != nil {
+		h.Close()
+		t.Fatalf("mine: %s", err.Error())
+	}
+	return h
+}
+
+// Close stops every node and bitcoind, and removes the harness directory
+// unless LITHARNESS_KEEP is set
+func (h *Harness) Close() {
+	h.mtx.Lock()
+	nodes := h.nodes
+	h.nodes = nil
+	h.mtx.Unlock()
+	for _, n := range nodes {
+		n.Stop()
+	}
+	if h.Chain != nil {
+		h.Chain.Stop()
+	}
+	if os.Getenv("LITHARNESS_KEEP") != "" {
+		h.T.Logf("harness files left in %s", h.Dir)
+		return
+	}
+	os.RemoveAll(h.Dir)
+}
+
+// WaitFor polls cond until it's true, failing the test after h.Timeout
+func (h *Harness) WaitFor(desc string, cond func() bool) {
+	deadline := time.Now().Add(h.Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.T.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Mine mines n blocks and waits for every running node to see them
+func (h *Harness) Mine(n int) {
+	err := h.Chain.Generate(n)
+	if err != nil {
+		h.T.Fatalf("mine %d: %s", n, err.Error())
+	}
+	h.Sync()
+}
+
+// Confirm waits for n txs in the mempool, then mines them
+func (h *Harness) Confirm(n int) {
+	h.WaitFor(fmt.Sprintf("%d txs in mempool", n), func() bool {
+		size, err := h.Chain.MempoolSize()
+		return err == nil && size >= n
+	})
+	h.Mine(1)
+}
+
+// Sync waits for every running node to reach the chain's height
+func (h *Harness) Sync() {
+	height, err := h.Chain.BlockCount()
+	if err != nil {
+		h.T.Fatalf("block count: %s", err.Error())
+	}
+	h.mtx.Lock()
+	nodes := append([]*Node(nil), h.nodes...)
+	h.mtx.Unlock()
+	for _, n := range nodes {
+		if n.stopped {
+			continue
+		}
+		h.WaitFor(fmt.Sprintf("%s to sync to %d", n.Name, height), func() bool {
+			return n.SyncHeight() >= height
+		})
+	}
+}
+
+// Fund sends sats from bitcoind to a node's wallet and confirms it
+func (h *Harness) Fund(n *Node, sats int64) {
+	before := n.WalletBalance()
+	adr := n.NewAddress()
+	_, err := h.Chain.SendToAddress(adr, sats)
+	if err != nil {
+		h.T.Fatalf("fund %s: %s", n.Name, err.Error())
+	}
+	h.Confirm(1)
+	h.WaitFor(fmt.Sprintf("%s to see its funds", n.Name), func() bool {
+		return n.WalletBalance()-before >= sats
+	})
+}
+
+// Connect connects from to to, and waits for both to see it
+func (h *Harness) Connect(from, to *Node) {
+	err := from.Connect(to)
+	if err != nil {
+		h.T.Fatalf("connect %s to %s: %s", from.Name, to.Name, err.Error())
+	}
+	h.WaitFor(fmt.Sprintf("%s to see %s", to.Name, from.Name), func() bool {
+		return to.PeerIdx(from) != 0
+	})
+}
+
+// OpenChannel funds a channel from from to to, pushing push to them in
+// it, mines it and returns from's channel index
+func (h *Harness) OpenChannel(from, to *Node, capacity, push int64) uint32 {
+	peer := from.PeerIdx(to)
+	if peer == 0 {
+		h.T.Fatalf("%s not connected to %s", from.Name, to.Name)
+	}
+	before := len(from.Channels())
+	err := from.FundChannel(peer, capacity, push)
+	if err != nil {
+		h.T.Fatalf("fund channel %s to %s: %s", from.Name, to.Name, err.Error())
+	}
+	h.Confirm(1)
+	var cIdx uint32
+	h.WaitFor(fmt.Sprintf("channel %s to %s", from.Name, to.Name), func() bool {
+		chans := from.Channels()
+		if len(chans) <= before {
+			return false
+		}
+		last := chans[len(chans)-1]
+		if last.Height <= 0 {
+			return false
+		}
+		cIdx = last.CIdx
+		return true
+	})
+	h.WaitFor(fmt.Sprintf("%s to see the channel", to.Name), func() bool {
+		return h.ChanIdx(to, from) != 0
+	})
+	return cIdx
+}
+
+// ChanIdx is the index of n's latest open channel with peer, or 0
+func (h *Harness) ChanIdx(n, peer *Node) uint32 {
+	peerIdx := n.PeerIdx(peer)
+	if peerIdx == 0 {
+		return 0
+	}
+	var cIdx uint32
+	for _, c := range n.Channels() {
+		if c.PeerIdx == peerIdx && !c.Closed && c.CIdx > cIdx {
+			cIdx = c.CIdx
+		}
+	}
+	return cIdx
+}
+
+// Push sends amt over a channel of n's
+func (h *Harness) Push(n *Node, cIdx uint32, amt int64) {
+	err := n.Push(cIdx, amt)
+	if err != nil {
+		h.T.Fatalf("%s push %d on channel %d: %s", n.Name, amt, cIdx, err.Error())
+	}
+}
+
+// CloseChannel closes a channel cooperatively and mines the close
+func (h *Harness) CloseChannel(n *Node, cIdx uint32) {
+	err := n.CloseChannel(cIdx)
+	if err != nil {
+		h.T.Fatalf("%s close channel %d: %s", n.Name, cIdx, err.Error())
+	}
+	h.Confirm(1)
+	h.WaitFor(fmt.Sprintf("%s channel %d closed", n.Name, cIdx), func() bool {
+		c := n.Channel(cIdx)
+		return c != nil && c.Closed
+	})
+}
+
+// BreakChannel force closes a channel with its current state and mines it
+func (h *Harness) BreakChannel(n *Node, cIdx uint32) {
+	err := n.BreakChannel(cIdx)
+	if err != nil {
+		h.T.Fatalf("%s break channel %d: %s", n.Name, cIdx, err.Error())
+	}
+	h.Confirm(1)
+}
+
+// Snapshot is n's current signed state tx for a channel, in hex, to
+// broadcast later with Breach
+func (h *Harness) Snapshot(n *Node, cIdx uint32) string {
+	tx, err := n.StateTx(cIdx)
+	if err != nil {
+		h.T.Fatalf("%s snapshot channel %d: %s", n.Name, cIdx, err.Error())
+	}
+	return tx
+}
+
+// Breach broadcasts a snapshot taken earlier, which has since been
+// revoked, mines it and returns its txid
+func (h *Harness) Breach(snapshot string) string {
+	txid, err := h.Chain.SendRawTransaction(snapshot)
+	if err != nil {
+		h.T.Fatalf("breach: %s", err.Error())
+	}
+	h.Mine(1)
+	return txid
+}
+
+// Spent says if an output on chain has been spent
+func (h *Harness) Spent(txid string, vout uint32) bool {
+	var out *struct{ Value float64 }
+	err := h.Chain.Call("gettxout", &out, txid, vout)
+	if err != nil {
+		h.T.Fatalf("gettxout %s:%d: %s", txid, vout, err.Error())
+	}
+	return out == nil
+}
+
+// WatchWith has n send its channels' justice data to tower, a node made
+// with tower set, and syncs every channel that's far enough along
+func (h *Harness) WatchWith(n, tower *Node) {
+	if !tower.Tower {
+		h.T.Fatalf("%s isn't a tower", tower.Name)
+	}
+	if n.PeerIdx(tower) == 0 {
+		h.Connect(n, tower)
+	}
+	err := n.WatchWith(tower)
+	if err != nil {
+		h.T.Fatalf("%s watch with %s: %s", n.Name, tower.Name, err.Error())
+	}
+	h.SyncWatch(n)
+}
+
+// UploadTo adds tower, a node made with tower set, to the towers n uploads
+// to
+func (h *Harness) UploadTo(n, tower *Node) {
+	if !tower.Tower {
+		h.T.Fatalf("%s isn't a tower", tower.Name)
+	}
+	err := n.LN.AddClientTower(tower.Pub() + "@" + tower.Host)
+	if err != nil {
+		h.T.Fatalf("%s upload to %s: %s", n.Name, tower.Name, err.Error())
+	}
+}
+
+// SyncWatch sends n's tower what's new in each open channel
+func (h *Harness) SyncWatch(n *Node) {
+	err := n.SyncWatch()
+	if err != nil {
+		h.T.Fatalf("%s sync watch: %s", n.Name, err.Error())
+	}
+}
+
+// AssertChannelBalance waits for n's side of a channel to be want
+func (h *Harness) AssertChannelBalance(n *Node, cIdx uint32, want int64) {
+	h.WaitFor(fmt.Sprintf("%s channel %d balance %d", n.Name, cIdx, want),
+		func() bool {
+			c := n.Channel(cIdx)
+			return c != nil && c.MyBalance == want
+		})
+}
+
+// AssertWalletBalance waits for n's wallet to hold want, give or take
+// slack for fees
+func (h *Harness) AssertWalletBalance(n *Node, want, slack int64) {
+	h.WaitFor(fmt.Sprintf("%s wallet balance %d (+/- %d)", n.Name, want, slack),
+		func() bool {
+			got := n.WalletBalance()
+			return got >= want-slack && got <= want+slack
+		})
+}
@@ -0,0 +1,462 @@
+package harness
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/watchtower"
+)
+
+// fees are small on regtest, but not zero
+const feeSlack = 100000
+
+func TestPushAndClose(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+
+	ch := h.OpenChannel(alice, bob, 10000000, 1000000)
+	h.AssertChannelBalance(alice, ch, 9000000)
+	bobCh := h.ChanIdx(bob, alice)
+	h.AssertChannelBalance(bob, bobCh, 1000000)
+
+	h.Push(alice, ch, 2500000)
+	h.Push(bob, bobCh, 500000)
+	h.AssertChannelBalance(alice, ch, 7000000)
+	h.AssertChannelBalance(bob, bobCh, 3000000)
+
+	h.CloseChannel(alice, ch)
+	h.AssertWalletBalance(alice, 50000000-3000000, feeSlack)
+	h.AssertWalletBalance(bob, 3000000, feeSlack)
+}
+
+// both sides pushing at once; pushes may be turned away as busy, but the
+// ones that go through have to add up on both sides
+func TestConcurrentPushes(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 5000000)
+	bobCh := h.ChanIdx(bob, alice)
+
+	var wg sync.WaitGroup
+	var aliceSent, bobSent int64
+	push := func(n *Node, cIdx uint32, sent *int64) {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if n.Push(cIdx, 100000) == nil {
+				atomic.AddInt64(sent, 100000)
+			}
+		}
+	}
+	wg.Add(2)
+	go push(alice, ch, &aliceSent)
+	go push(bob, bobCh, &bobSent)
+	wg.Wait()
+	if aliceSent == 0 && bobSent == 0 {
+		t.Fatalf("no pushes went through")
+	}
+
+	h.AssertChannelBalance(alice, ch, 5000000-aliceSent+bobSent)
+	h.AssertChannelBalance(bob, bobCh, 5000000-bobSent+aliceSent)
+	h.WaitFor("channel op queues empty", func() bool {
+		return len(alice.LN.ChanOpStatuses()) == 0 &&
+			len(bob.LN.ChanOpStatuses()) == 0
+	})
+}
+
+func TestBreach(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 20000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+
+	h.Push(alice, ch, 2000000)
+	old := h.Snapshot(alice, ch)
+	h.Push(alice, ch, 6000000)
+	h.AssertChannelBalance(bob, h.ChanIdx(bob, alice), 8000000)
+
+	// alice goes back to when she had 8M; bob takes it all
+	h.Breach(old)
+	h.Confirm(1)
+	h.AssertWalletBalance(bob, 10000000, feeSlack)
+}
+
+func TestTowerBreach(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	tower := h.NewNode("tower", true)
+	h.Connect(alice, bob)
+	h.Fund(alice, 20000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+
+	h.Push(alice, ch, 1000000)
+	old := h.Snapshot(alice, ch)
+	h.Push(alice, ch, 1000000)
+	h.Push(alice, ch, 6000000)
+	h.WatchWith(bob, tower)
+
+	// with bob gone, only the tower can stop alice
+	bob.Stop()
+	txid := h.Breach(old)
+	h.Confirm(1)
+	if !h.Spent(txid, 0) && !h.Spent(txid, 1) {
+		t.Fatalf("tower didn't spend the revoked state %s", txid)
+	}
+}
+
+func TestTowerReport(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	tower := h.NewNode("tower", true)
+	h.Connect(alice, bob)
+	h.Fund(alice, 20000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+
+	h.Push(alice, ch, 1000000)
+	h.Push(alice, ch, 1000000)
+	h.Push(alice, ch, 1000000)
+	h.WatchWith(bob, tower)
+
+	report := func() qln.TowerReportChan {
+		chans, err := bob.LN.TowerReport(10 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chans) != 1 {
+			t.Fatalf("report on %d channels, expect 1", len(chans))
+		}
+		return chans[0]
+	}
+	c := report()
+	if c.Tower.LastState != c.SentUpTo || c.Tower.States != c.SentUpTo+1 ||
+		c.Resent != 0 {
+		t.Fatalf("tower has %+v, sent up to %d", c.Tower, c.SentUpTo)
+	}
+
+	// the tower forgets the channel; bob notices and sends it all again
+	op, err := watchtower.ParseAdminOp("drop", hex.EncodeToString(c.Tower.PKH[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tower.LN.Tower.(*watchtower.WatchTower).ProposeAdminOp(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = report()
+	if c.Tower.Flags&lnutil.WatchReportKnown != 0 || c.Resent != c.SentUpTo+1 {
+		t.Fatalf("after drop tower has %+v, resent %d", c.Tower, c.Resent)
+	}
+	c = report()
+	if c.Tower.LastState != c.SentUpTo || c.Resent != 0 {
+		t.Fatalf("after resend tower has %+v, sent up to %d", c.Tower, c.SentUpTo)
+	}
+}
+
+// bob uploads to one tower with another standing by; when the first goes
+// away the second takes over, and gets everything from before
+func TestTowerFailover(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	tower1 := h.NewNode("tower1", true)
+	tower2 := h.NewNode("tower2", true)
+	h.Connect(alice, bob)
+	h.Fund(alice, 20000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+
+	h.Push(alice, ch, 1000000)
+	h.Push(alice, ch, 1000000)
+	h.Push(alice, ch, 1000000)
+	err := bob.LN.SetTowerFailover(time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tower with everything sent
+	caughtUp := func() string {
+		bob.LN.KeepTowers()
+		_, towers, err := bob.LN.ClientTowers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ct := range towers {
+			if ct.Active && ct.Backfilled && ct.Waiting == 0 {
+				return ct.TowerPub
+			}
+		}
+		return ""
+	}
+	h.UploadTo(bob, tower1)
+	h.SyncWatch(bob)
+	h.WaitFor("tower1 to have bob's states", func() bool {
+		return caughtUp() == tower1.Pub()
+	})
+	h.UploadTo(bob, tower2)
+
+	tower1.Stop()
+	h.WaitFor("tower2 to take over", func() bool {
+		return caughtUp() == tower2.Pub()
+	})
+	chans, err := bob.LN.TowerReport(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chans) != 1 {
+		t.Fatalf("report on %d channels, expect 1", len(chans))
+	}
+	c := chans[0]
+	if c.TowerPub != tower2.Pub() || c.Tower.LastState != c.SentUpTo ||
+		c.Resent != 0 {
+		t.Fatalf("tower2 has %+v, sent up to %d", c, c.SentUpTo)
+	}
+}
+
+// a legacy address in another account gets paid, has that account's path,
+// and the wallet can spend from it
+func TestAccountAddress(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+
+	reply := new(litrpc.AddressReply)
+	err := alice.RPC.Address(&litrpc.AddressArgs{NumToMake: 1, CoinType: alice.coin,
+		Type: litrpc.AdrTypeP2SHSegwit}, reply)
+	if err == nil {
+		t.Fatalf("made a p2sh-segwit address the wallet can't receive on")
+	}
+	err = alice.RPC.Address(&litrpc.AddressArgs{NumToMake: 1, CoinType: alice.coin,
+		Type: litrpc.AdrTypeLegacy, Account: 2}, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := reply.Addresses[0]
+	wantPath := fmt.Sprintf("m/44'/%d'/0'/2'/0'", h.Param.HDCoinType)
+	if a.Address != reply.LegacyAddresses[0] || a.Account != 2 ||
+		a.Path != wantPath {
+		t.Fatalf("got %+v, expect %s at %s", a, reply.LegacyAddresses[0], wantPath)
+	}
+
+	_, err = h.Chain.SendToAddress(a.Address, 20000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Confirm(1)
+	h.AssertWalletBalance(alice, 20000000, 0)
+
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+	h.AssertChannelBalance(alice, ch, 10000000)
+	h.AssertWalletBalance(alice, 10000000, feeSlack)
+}
+
+// chart points follow the wallet and channel balances, and thin out to
+// one per step
+func TestChartData(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+
+	before, err := alice.LN.TakeChartPoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+	after, err := alice.LN.TakeChartPoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Chans) != 1 || after.Chans[0].ChanIdx != ch ||
+		after.Chans[0].Local != 10000000 {
+		t.Fatalf("channels %+v, expect channel %d with 10000000", after.Chans, ch)
+	}
+	if len(before.Coins) != 1 || len(after.Coins) != 1 ||
+		after.Coins[0].Channels != 10000000 ||
+		before.Coins[0].Chain-after.Coins[0].Chain < 10000000 {
+		t.Fatalf("coins went from %+v to %+v", before.Coins, after.Coins)
+	}
+
+	all, err := alice.LN.ChartData(0, after.Time+1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[1].Time != after.Time {
+		t.Fatalf("got %d points, expect 2", len(all))
+	}
+	one, err := alice.LN.ChartData(before.Time, after.Time+1,
+		after.Time-before.Time+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(one) != 1 || one[0].Time != after.Time {
+		t.Fatalf("got %d points thinned, expect the last one", len(one))
+	}
+}
+
+// a peer's force close counts against it in the peer report; our own
+// doesn't count against the peer
+func TestPeerReport(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+
+	h.OpenChannel(alice, bob, 10000000, 1000000)
+	report, err := alice.LN.PeerReport(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].PeerIdx != alice.PeerIdx(bob) ||
+		report[0].Open != 1 || report[0].Uptime <= 0 {
+		t.Fatalf("alice's report %+v, expect bob with 1 open channel", report)
+	}
+
+	h.BreakChannel(bob, h.ChanIdx(bob, alice))
+	h.WaitFor("alice to count bob's force close", func() bool {
+		report, err = alice.LN.PeerReport(0)
+		return err == nil && len(report) == 1 && report[0].ForceCloses == 1 &&
+			report[0].Closed == 1 && report[0].Open == 0
+	})
+	report, err = bob.LN.PeerReport(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].ForceCloses != 0 {
+		t.Fatalf("bob's report %+v, expect alice with no force closes", report)
+	}
+}
+
+// paying an invoice over a channel settles it on the payee's side, once
+func TestPayInvoice(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+	ch := h.OpenChannel(alice, bob, 10000000, 0)
+
+	inv, err := bob.LN.AddInvoice(250000, "test", nil, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payReq := inv.PayReq(bob.Adr)
+	paidVia, err := alice.LN.PayInvoice(payReq, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paidVia != ch {
+		t.Fatalf("paid through channel %d, expect %d", paidVia, ch)
+	}
+	h.WaitFor("bob to settle the invoice", func() bool {
+		inv, err = bob.LN.GetInvoice(inv.PaymentHash)
+		return err == nil && inv.Settled
+	})
+	if inv.AmtPaid != 250000 {
+		t.Fatalf("invoice paid %d, expect 250000", inv.AmtPaid)
+	}
+	h.AssertChannelBalance(alice, ch, 10000000-250000)
+
+	_, err = alice.LN.PayInvoice(payReq, 0)
+	if err == nil {
+		t.Fatalf("paid the same request twice")
+	}
+}
+
+// paying an order's invoice marks the order paid
+func TestOrderPaid(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+	h.OpenChannel(alice, bob, 10000000, 0)
+
+	o, err := bob.LN.CreateOrder("order-1", 120000, 0, "", "widget", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := bob.LN.GetInvoice(o.CurrentInvoice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.LN.PayInvoice(inv.PayReq(bob.Adr), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.WaitFor("bob to mark the order paid", func() bool {
+		o, err = bob.LN.GetOrder("order-1")
+		return err == nil && o.Status == qln.OrderPaid
+	})
+	if o.AmtPaid != 120000 || o.PaidHash != inv.PaymentHash {
+		t.Fatalf("order paid %d with %x, expect 120000 with %x",
+			o.AmtPaid, o.PaidHash, inv.PaymentHash)
+	}
+}
+
+// paying a lease's invoice over a channel marks the lease paid
+func TestLeasePaid(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	alice := h.NewNode("alice", false)
+	bob := h.NewNode("bob", false)
+	bob.LN.SetLeaseTiers([]lnutil.LeaseTier{{CoinType: bob.coin,
+		Capacity: 5000000, FeePPM: 10000, Blocks: 144}})
+	h.Connect(alice, bob)
+	h.Fund(alice, 50000000)
+	h.Fund(bob, 50000000)
+	h.OpenChannel(alice, bob, 10000000, 0)
+
+	bobIdx := alice.PeerIdx(bob)
+	h.WaitFor("alice to get bob's lease offers", func() bool {
+		alice.LN.RemoteMtx.Lock()
+		defer alice.LN.RemoteMtx.Unlock()
+		p, ok := alice.LN.RemoteCons[bobIdx]
+		return ok && len(p.LeaseOffers) == 1
+	})
+	terms, err := alice.LN.BuyLease(bobIdx, alice.coin, 5000000, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = alice.LN.PayInvoice(terms.PayReq, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := qln.DecodePayReq(terms.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.WaitFor("bob to mark the lease paid", func() bool {
+		l, err := bob.LN.GetLease(pr.PaymentHash)
+		return err == nil && l.State != qln.LeaseOffered &&
+			l.AmtPaid == terms.Fee && !l.PaidOnChain
+	})
+}
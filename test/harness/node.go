@@ -0,0 +1,279 @@
+package harness
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// Node is a lit node run by the harness
+type Node struct {
+	Name  string
+	Dir   string // where its DBs are
+	Tower bool
+
+	LN  *qln.LitNode
+	RPC *litrpc.LitRPC
+
+	Adr  string // lit address
+	Host string // where it listens
+
+	coin    uint32
+	stopped bool
+	done    func() // removes an ephemeral node's folder
+}
+
+// NewNode starts a lit node with a new key, linked to the harness's
+// bitcoind and listening on a free port.  With tower set it also
+// watches channels for other nodes.
+func (h *Harness) NewNode(name string, tower bool) *Node {
+	n := new(Node)
+	n.Name = name
+	n.Tower = tower
+	n.coin = h.Param.HDCoinType
+
+	// in memory unless the files are to be kept for a look afterwards
+	var dirs qln.DataDirs
+	var err error
+	if os.Getenv("LITHARNESS_KEEP") != "" {
+		dirs.Lit = filepath.Join(h.Dir, name)
+		err = os.MkdirAll(dirs.Lit, 0700)
+	} else {
+		dirs, n.done, err = qln.EphemeralDataDirs()
+	}
+	if err != nil {
+		h.T.Fatalf("node %s: %s", name, err.Error())
+	}
+	n.Dir = dirs.Lit
+
+	key := new([32]byte)
+	_, err = rand.Read(key[:])
+	if err != nil {
+		h.T.Fatalf("node %s key: %s", name, err.Error())
+	}
+	n.LN, err = qln.NewLitNodeDirs(key, dirs, "")
+	if err != nil {
+		h.T.Fatalf("node %s: %s", name, err.Error())
+	}
+	err = n.LN.LinkBaseWallet(
+		key, h.Param.BirthHeight, false, tower, h.Chain.Host(), h.Param)
+	if err != nil {
+		h.T.Fatalf("node %s wallet: %s", name, err.Error())
+	}
+
+	n.RPC = new(litrpc.LitRPC)
+	n.RPC.Node = n.LN
+	n.RPC.OffButton = make(chan bool, 1)
+
+	port, err := freePort()
+	if err != nil {
+		h.T.Fatalf("node %s port: %s", name, err.Error())
+	}
+	lis := new(litrpc.ListeningPortsReply)
+	err = n.RPC.Listen(litrpc.ListenArgs{Port: fmt.Sprintf("127.0.0.1:%d", port)}, lis)
+	if err != nil {
+		h.T.Fatalf("node %s listen: %s", name, err.Error())
+	}
+	n.Adr = lis.Adr
+	n.Host = fmt.Sprintf("127.0.0.1:%d", port)
+
+	h.mtx.Lock()
+	h.nodes = append(h.nodes, n)
+	h.mtx.Unlock()
+	h.Sync()
+	return n
+}
+
+// Stop shuts the node down.  It can't be started again.
+func (n *Node) Stop() {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	n.LN.Shutdown()
+	if n.done != nil {
+		n.done()
+	}
+}
+
+// Connect connects to another node
+func (n *Node) Connect(to *Node) error {
+	reply := new(litrpc.StatusReply)
+	return n.RPC.Connect(litrpc.ConnectArgs{LNAddr: to.Adr + "@" + to.Host}, reply)
+}
+
+// Pub is n's identity pubkey, hex
+func (n *Node) Pub() string {
+	return hex.EncodeToString(n.LN.IdKey().PubKey().SerializeCompressed())
+}
+
+// PeerIdx is n's index for a connected peer, or 0 if it's not connected
+func (n *Node) PeerIdx(peer *Node) uint32 {
+	for _, p := range n.LN.GetConnectedPeerList() {
+		pub, _ := n.LN.GetPubHostFromPeerIdx(p.PeerNumber)
+		if lnutil.LitAdrFromPubkey(pub) == peer.Adr {
+			return p.PeerNumber
+		}
+	}
+	return 0
+}
+
+// NewAddress is a new legacy address for n's wallet; bitcoind doesn't
+// know lit's regtest bech32 prefix
+func (n *Node) NewAddress() string {
+	reply := new(litrpc.AddressReply)
+	err := n.RPC.Address(&litrpc.AddressArgs{NumToMake: 1, CoinType: n.coin}, reply)
+	if err != nil || len(reply.LegacyAddresses) == 0 {
+		return ""
+	}
+	return reply.LegacyAddresses[0]
+}
+
+func (n *Node) coinBalance() *litrpc.CoinBalReply {
+	reply := new(litrpc.BalanceReply)
+	err := n.RPC.Balance(new(litrpc.NoArgs), reply)
+	if err != nil {
+		return nil
+	}
+	for i := range reply.Balances {
+		if reply.Balances[i].CoinType == n.coin {
+			return &reply.Balances[i]
+		}
+	}
+	return nil
+}
+
+// WalletBalance is every utxo n's wallet has, confirmed or not
+func (n *Node) WalletBalance() int64 {
+	b := n.coinBalance()
+	if b == nil {
+		return 0
+	}
+	return b.TxoTotal
+}
+
+// SyncHeight is how far n's wallet has synced
+func (n *Node) SyncHeight() int32 {
+	b := n.coinBalance()
+	if b == nil {
+		return 0
+	}
+	return b.SyncHeight
+}
+
+// Channels lists n's channels, open and closed
+func (n *Node) Channels() []litrpc.ChannelInfo {
+	reply := new(litrpc.ChannelListReply)
+	err := n.RPC.ChannelList(litrpc.ChanArgs{}, reply)
+	if err != nil {
+		return nil
+	}
+	return reply.Channels
+}
+
+// Channel is one of n's channels, or nil if there's no such channel
+func (n *Node) Channel(cIdx uint32) *litrpc.ChannelInfo {
+	for _, c := range n.Channels() {
+		if c.CIdx == cIdx {
+			return &c
+		}
+	}
+	return nil
+}
+
+// FundChannel starts a channel with a peer
+func (n *Node) FundChannel(peer uint32, capacity, push int64) error {
+	args := litrpc.FundArgs{
+		Peer: peer, CoinType: n.coin, Capacity: capacity, InitialSend: push}
+	return n.RPC.FundChannel(args, new(litrpc.StatusReply))
+}
+
+// Push sends amt over a channel
+func (n *Node) Push(cIdx uint32, amt int64) error {
+	return n.RPC.Push(litrpc.PushArgs{ChanIdx: cIdx, Amt: amt}, new(litrpc.PushReply))
+}
+
+// CloseChannel closes a channel cooperatively
+func (n *Node) CloseChannel(cIdx uint32) error {
+	return n.RPC.CloseChannel(litrpc.ChanArgs{ChanIdx: cIdx}, new(litrpc.StatusReply))
+}
+
+// BreakChannel force closes a channel
+func (n *Node) BreakChannel(cIdx uint32) error {
+	return n.RPC.BreakChannel(litrpc.ChanArgs{ChanIdx: cIdx}, new(litrpc.StatusReply))
+}
+
+// StateTx is n's current state tx for a channel, signed by both sides,
+// in hex
+func (n *Node) StateTx(cIdx uint32) (string, error) {
+	reply := new(litrpc.DumpCommitmentReply)
+	err := n.RPC.DumpCommitment(litrpc.DumpCommitmentArgs{ChanIdx: cIdx}, reply)
+	if err != nil {
+		return "", err
+	}
+	if !reply.Commitment.Signed {
+		return "", fmt.Errorf("channel %d state %d not signed",
+			cIdx, reply.Commitment.StateIdx)
+	}
+	return reply.Commitment.Tx, nil
+}
+
+// WatchWith makes a connected tower node n's watchtower
+func (n *Node) WatchWith(tower *Node) error {
+	peer := n.PeerIdx(tower)
+	if peer == 0 {
+		return fmt.Errorf("not connected to %s", tower.Name)
+	}
+	n.LN.RemoteMtx.Lock()
+	defer n.LN.RemoteMtx.Unlock()
+	rp, ok := n.LN.RemoteCons[peer]
+	if !ok {
+		return fmt.Errorf("no connection to peer %d", peer)
+	}
+	n.LN.WatchCon = rp.Con
+	return nil
+}
+
+// SyncWatch sends n's towers the justice data for every open channel
+// that has states it hasn't sent yet
+func (n *Node) SyncWatch() error {
+	if n.LN.WatchCon == nil {
+		_, towers, err := n.LN.ClientTowers()
+		if err != nil {
+			return err
+		}
+		if len(towers) == 0 {
+			return fmt.Errorf("no watchtower")
+		}
+	}
+	// the channels in ram, so WatchUpTo isn't lost on the next save
+	var qcs []*qln.Qchan
+	n.LN.RemoteMtx.Lock()
+	for _, rp := range n.LN.RemoteCons {
+		for _, q := range rp.QCs {
+			qcs = append(qcs, q)
+		}
+	}
+	n.LN.RemoteMtx.Unlock()
+
+	for _, q := range qcs {
+		// hold the channel so no update runs alongside
+		<-q.ClearToSend
+		var err error
+		if !q.CloseData.Closed && q.State.StateIdx >= 2 &&
+			q.State.WatchUpTo+2 <= q.State.StateIdx {
+			err = n.LN.SyncWatch(q)
+		}
+		q.ClearToSend <- true
+		if err != nil {
+			return fmt.Errorf("channel %d: %s", q.Idx(), err.Error())
+		}
+	}
+	return nil
+}
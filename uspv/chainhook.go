@@ -1,7 +1,6 @@
 package uspv
 
 import (
-	"log"
 	"path/filepath"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
@@ -100,6 +99,7 @@ func (s *SPVCon) Start(
 	s.TxMap = make(map[chainhash.Hash]*wire.MsgTx)
 
 	s.OKTxids = make(map[chainhash.Hash]int32)
+	s.OKTxPos = make(map[chainhash.Hash]uint32)
 
 	s.TxUpToWallit = make(chan lnutil.TxAndHeight, 1)
 	s.CurrentHeightChan = make(chan int32, 1)
@@ -115,13 +115,13 @@ func (s *SPVCon) Start(
 
 	err = s.Connect(host)
 	if err != nil {
-		log.Printf("Can't connect to host %s\n", host)
+		logger.Errorf("Can't connect to host %s\n", host)
 		return nil, nil, err
 	}
 
 	err = s.AskForHeaders()
 	if err != nil {
-		log.Printf("AskForHeaders error\n")
+		logger.Errorf("AskForHeaders error\n")
 		return nil, nil, err
 	}
 
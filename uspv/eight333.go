@@ -2,7 +2,6 @@ package uspv
 
 import (
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
@@ -53,7 +52,7 @@ func (s *SPVCon) GimmeFilter() (*bloom.Filter, error) {
 	// send any to us, sometimes we don't see it and think the channel is still open.
 	// so not monitoring the channel outpoint properly?  here or in ingest()
 
-	log.Printf("made %d element filter\n", filterElements)
+	logger.Infof("made %d element filter\n", filterElements)
 	return f, nil
 }
 
@@ -110,16 +109,17 @@ func (s *SPVCon) MatchTx(tx *wire.MsgTx) bool {
 	return false
 }
 
-// OKTxid assigns a height to a txid.  This means that
-// the txid exists at that height, with whatever assurance (for height 0
-// it's no assurance at all)
-func (s *SPVCon) OKTxid(txid *chainhash.Hash, height int32) error {
+// OKTxid assigns a height, and position in the block, to a txid.  This
+// means that the txid exists at that height, with whatever assurance (for
+// height 0 it's no assurance at all)
+func (s *SPVCon) OKTxid(txid *chainhash.Hash, height int32, pos uint32) error {
 	if txid == nil {
 		return fmt.Errorf("tried to add nil txid")
 	}
-	log.Printf("added %s to OKTxids at height %d\n", txid.String(), height)
+	logger.Infof("added %s to OKTxids at height %d\n", txid.String(), height)
 	s.OKMutex.Lock()
 	s.OKTxids[*txid] = height
+	s.OKTxPos[*txid] = pos
 	s.OKMutex.Unlock()
 	return nil
 }
@@ -135,7 +135,7 @@ func (s *SPVCon) AskForTx(txid chainhash.Hash) {
 	//		inv.Type = wire.InvTypeWitnessTx
 	//	}
 	gdata.AddInvVect(inv)
-	log.Printf("asking for tx %s\n", txid.String())
+	logger.Infof("asking for tx %s\n", txid.String())
 	s.outMsgQueue <- gdata
 }
 
@@ -160,9 +160,9 @@ func NewRootAndHeight(b chainhash.Hash, h int32) (hah HashAndHeight) {
 
 func (s *SPVCon) IngestMerkleBlock(m *wire.MsgMerkleBlock) {
 
-	txids, err := checkMBlock(m) // check self-consistency
+	txids, poss, err := checkMBlock(m) // check self-consistency
 	if err != nil {
-		log.Printf("Merkle block error: %s\n", err.Error())
+		logger.Errorf("Merkle block error: %s\n", err.Error())
 		return
 	}
 	var hah HashAndHeight
@@ -170,7 +170,7 @@ func (s *SPVCon) IngestMerkleBlock(m *wire.MsgMerkleBlock) {
 	case hah = <-s.blockQueue: // pop height off mblock queue
 		break
 	default:
-		log.Printf("Unrequested merkle block")
+		logger.Infof("Unrequested merkle block")
 		return
 	}
 
@@ -178,17 +178,17 @@ func (s *SPVCon) IngestMerkleBlock(m *wire.MsgMerkleBlock) {
 	// into our SPV header file
 	newMerkBlockSha := m.Header.BlockHash()
 	if !hah.blockhash.IsEqual(&newMerkBlockSha) {
-		log.Printf("merkle block out of order got %s expect %s",
+		logger.Infof("merkle block out of order got %s expect %s",
 			m.Header.BlockHash().String(), hah.blockhash.String())
-		log.Printf("has %d hashes %d txs flags: %x",
+		logger.Infof("has %d hashes %d txs flags: %x",
 			len(m.Hashes), m.Transactions, m.Flags)
 		return
 	}
 
-	for _, txid := range txids {
-		err := s.OKTxid(txid, hah.height)
+	for i, txid := range txids {
+		err := s.OKTxid(txid, hah.height, poss[i])
 		if err != nil {
-			log.Printf("Txid store error: %s\n", err.Error())
+			logger.Errorf("Txid store error: %s\n", err.Error())
 			return
 		}
 	}
@@ -202,7 +202,7 @@ func (s *SPVCon) IngestMerkleBlock(m *wire.MsgMerkleBlock) {
 		// that way you are pretty sure you're synced up.
 		err = s.AskForHeaders()
 		if err != nil {
-			log.Printf("Merkle block error: %s\n", err.Error())
+			logger.Errorf("Merkle block error: %s\n", err.Error())
 			return
 		}
 	}
@@ -223,11 +223,11 @@ func (s *SPVCon) IngestHeaders(m *wire.MsgHeaders) (bool, error) {
 
 	gotNum := int64(len(m.Headers))
 	if gotNum > 0 {
-		log.Printf("got %d headers. Range:\n%s - %s\n",
+		logger.Infof("got %d headers. Range:\n%s - %s\n",
 			gotNum, m.Headers[0].BlockHash().String(),
 			m.Headers[len(m.Headers)-1].BlockHash().String())
 	} else {
-		log.Printf("got 0 headers, we're probably synced up")
+		logger.Infof("got 0 headers, we're probably synced up")
 		return false, nil
 	}
 
@@ -243,7 +243,7 @@ func (s *SPVCon) IngestHeaders(m *wire.MsgHeaders) (bool, error) {
 		// really, the re-org hasn't been proven; if the remote node
 		// provides us with a new block we'll ask again.
 		if reorgHeight == -1 {
-			log.Printf("Header error: %s\n", err.Error())
+			logger.Errorf("Header error: %s\n", err.Error())
 			return false, nil
 		}
 		// some other error
@@ -272,7 +272,7 @@ func (s *SPVCon) IngestHeaders(m *wire.MsgHeaders) (bool, error) {
 			return false, err
 		}
 	}
-	log.Printf("Added %d headers OK.", len(m.Headers))
+	logger.Infof("Added %d headers OK.", len(m.Headers))
 	return true, nil
 }
 
@@ -281,13 +281,13 @@ func (s *SPVCon) AskForHeaders() error {
 	ghdr.ProtocolVersion = s.localVersion
 
 	tipheight := s.GetHeaderTipHeight()
-	log.Printf("got header tip height %d\n", tipheight)
+	logger.Infof("got header tip height %d\n", tipheight)
 	// get tip header, as well as a few older ones (inefficient...?)
 	// yes, inefficient; really we should use "getheaders" and skip some of this
 
 	tipheader, err := s.GetHeaderAtHeight(tipheight)
 	if err != nil {
-		log.Printf("AskForHeaders GetHeaderAtHeight error\n")
+		logger.Errorf("AskForHeaders GetHeaderAtHeight error\n")
 		return err
 	}
 
@@ -320,7 +320,7 @@ func (s *SPVCon) AskForHeaders() error {
 		}
 	}
 
-	log.Printf("get headers message has %d header hashes, first one is %s\n",
+	logger.Infof("get headers message has %d header hashes, first one is %s\n",
 		len(ghdr.BlockLocatorHashes), ghdr.BlockLocatorHashes[0].String())
 
 	s.outMsgQueue <- ghdr
@@ -341,14 +341,14 @@ func (s *SPVCon) AskForBlocks() error {
 	// move back 1 header length to read
 	headerTip := int32(endPos/80) + (s.headerStartHeight - 1)
 
-	log.Printf("blockTip to %d headerTip %d\n", s.syncHeight, headerTip)
+	logger.Infof("blockTip to %d headerTip %d\n", s.syncHeight, headerTip)
 	if s.syncHeight > headerTip {
 		return fmt.Errorf("error- db longer than headers! shouldn't happen.")
 	}
 	if s.syncHeight == headerTip {
 		// nothing to ask for; set wait state and return
-		log.Printf("no blocks to request, entering wait state\n")
-		log.Printf("%d bytes received\n", s.RBytes)
+		logger.Infof("no blocks to request, entering wait state\n")
+		logger.Infof("%d bytes received\n", s.RBytes)
 		s.inWaitState <- true
 
 		// check if we can grab outputs
@@ -367,7 +367,7 @@ func (s *SPVCon) AskForBlocks() error {
 		return nil
 	}
 
-	log.Printf("will request blocks %d to %d\n", s.syncHeight+1, headerTip)
+	logger.Infof("will request blocks %d to %d\n", s.syncHeight+1, headerTip)
 	reqHeight := s.syncHeight
 
 	// loop through all heights where we want merkleblocks.
@@ -384,7 +384,7 @@ func (s *SPVCon) AskForBlocks() error {
 		err = hdr.Deserialize(s.headerFile) // read header, done w/ file for now
 		s.headerMutex.Unlock()              // unlock after reading 1 header
 		if err != nil {
-			log.Printf("header deserialize error!\n")
+			logger.Errorf("header deserialize error!\n")
 			return err
 		}
 
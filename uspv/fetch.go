@@ -0,0 +1,78 @@
+package uspv
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+// how long FetchBlock waits for the remote node to send a block
+const blockFetchTimeout = 2 * time.Minute
+
+// FetchBlock gets the full block at a height from the remote node, apart
+// from the sync: the block isn't ingested and the sync height doesn't move.
+// For going back through old blocks, like looking for a key's outputs.
+// Can be called from several goroutines at once.
+func (s *SPVCon) FetchBlock(height int32) (*wire.MsgBlock, error) {
+	if height > s.GetHeaderTipHeight() {
+		return nil, fmt.Errorf("no header at height %d yet", height)
+	}
+	hdr, err := s.GetHeaderAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	hash := hdr.BlockHash()
+
+	ch := make(chan *wire.MsgBlock, 1)
+	s.fetchMtx.Lock()
+	if s.fetches == nil {
+		s.fetches = make(map[chainhash.Hash]chan *wire.MsgBlock)
+	}
+	s.fetches[hash] = ch
+	s.fetchMtx.Unlock()
+	defer func() {
+		s.fetchMtx.Lock()
+		delete(s.fetches, hash)
+		s.fetchMtx.Unlock()
+	}()
+
+	gdata := wire.NewMsgGetData()
+	err = gdata.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &hash))
+	if err != nil {
+		return nil, err
+	}
+	s.outMsgQueue <- gdata
+
+	select {
+	case blk := <-ch:
+		if blk == nil {
+			return nil, fmt.Errorf("block %d %s is invalid", height, hash.String())
+		}
+		return blk, nil
+	case <-time.After(blockFetchTimeout):
+		return nil, fmt.Errorf("block %d %s didn't come", height, hash.String())
+	}
+}
+
+// fetched hands a block to the FetchBlock waiting for it.  Returns false
+// if none is, so it's one for the sync.
+func (s *SPVCon) fetched(m *wire.MsgBlock) bool {
+	hash := m.BlockHash()
+	s.fetchMtx.Lock()
+	ch, ok := s.fetches[hash]
+	delete(s.fetches, hash)
+	s.fetchMtx.Unlock()
+	if !ok {
+		return false
+	}
+	// the header's hash matched, so this just checks the txs are its
+	if !BlockOK(*m) {
+		logger.Warnf("fetched block %s doesn't match its merkle root\n",
+			hash.String())
+		m = nil
+	}
+	ch <- m
+	return true
+}
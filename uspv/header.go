@@ -9,7 +9,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"os"
 
@@ -27,12 +26,12 @@ func checkProofOfWork(header wire.BlockHeader, p *coinparam.Params) bool {
 
 	// The target must more than 0.  Why can you even encode negative...
 	if target.Sign() <= 0 {
-		log.Printf("block target %064x is neagtive(??)\n", target.Bytes())
+		logger.Warnf("block target %064x is neagtive(??)\n", target.Bytes())
 		return false
 	}
 	// The target must be less than the maximum allowed (difficulty 1)
 	if target.Cmp(p.PowLimit) > 0 {
-		log.Printf("block target %064x is "+
+		logger.Warnf("block target %064x is "+
 			"higher than max of %064x", target, p.PowLimit.Bytes())
 		return false
 	}
@@ -47,7 +46,7 @@ func checkProofOfWork(header wire.BlockHeader, p *coinparam.Params) bool {
 
 	hashNum = blockchain.HashToBig(&blockHash)
 	if hashNum.Cmp(target) > 0 {
-		log.Printf("block hash %064x is higher than "+
+		logger.Warnf("block hash %064x is higher than "+
 			"required target of %064x", hashNum, target)
 		return false
 	}
@@ -83,14 +82,14 @@ func (s *SPVCon) GetHeaderTipHeight() int32 {
 	defer s.headerMutex.Unlock()
 	info, err := s.headerFile.Stat()
 	if err != nil {
-		log.Printf("Header file error: %s", err.Error())
+		logger.Errorf("Header file error: %s", err.Error())
 		return 0
 	}
 	headerFileSize := info.Size()
 	if headerFileSize == 0 || headerFileSize%80 != 0 { // header file broken
 		// try to fix it!
 		s.headerFile.Truncate(headerFileSize - (headerFileSize % 80))
-		log.Printf("ERROR: Header file not a multiple of 80 bytes. Truncating")
+		logger.Errorf("ERROR: Header file not a multiple of 80 bytes. Truncating")
 	}
 	// subtract 1 as we want the start of the tip offset, not the end
 	return int32(headerFileSize/80) + s.Param.StartHeight - 1
@@ -178,7 +177,7 @@ func CheckHeaderChain(
 	if err != nil {
 		return 0, err
 	}
-	log.Printf("header file position: %d\n", pos)
+	logger.Infof("header file position: %d\n", pos)
 	if pos%80 != 0 {
 		return 0, fmt.Errorf(
 			"CheckHeaderChain: Header file not a multiple of 80 bytes.")
@@ -204,14 +203,14 @@ func CheckHeaderChain(
 
 	// weird off-by-1 stuff here; makes numheaders, incluing the 0th
 	oldHeaders := make([]*wire.BlockHeader, numheaders)
-	log.Printf("made %d header slice\n", len(oldHeaders))
+	logger.Infof("made %d header slice\n", len(oldHeaders))
 	// load a bunch of headers from disk into ram
 	for i, _ := range oldHeaders {
 		// read from file at current offset
 		oldHeaders[i] = new(wire.BlockHeader)
 		err = oldHeaders[i].Deserialize(r)
 		if err != nil {
-			log.Printf("CheckHeaderChain ran out of file at oldheader %d\n", i)
+			logger.Errorf("CheckHeaderChain ran out of file at oldheader %d\n", i)
 			return 0, err
 		}
 	}
@@ -233,7 +232,7 @@ func CheckHeaderChain(
 		// adjust attachHeight by adding the startheight
 		attachHeight += p.StartHeight
 
-		log.Printf("Header %s attaches at height %d\n",
+		logger.Infof("Header %s attaches at height %d\n",
 			inHeaders[0].BlockHash().String(), attachHeight)
 
 		// TODO check for more work here instead of length.  This is wrong...
@@ -245,7 +244,7 @@ func CheckHeaderChain(
 				attachHeight+int32(len(inHeaders)), height-1)
 		}
 
-		log.Printf("reorg from height %d to %d",
+		logger.Infof("reorg from height %d to %d",
 			height-1, attachHeight+int32(len(inHeaders)))
 
 		// reorg is go, snip to attach height
@@ -293,25 +292,25 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 	// seek to n-1 header
 	_, err = r.Seek(int64(80*(offsetHeight-1)), os.SEEK_SET)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 	// read in n-1
 	err = prev.Deserialize(r)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 
 	// seek to curHeight header and read in
 	_, err = r.Seek(int64(80*(offsetHeight)), os.SEEK_SET)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 	err = cur.Deserialize(r)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 
@@ -319,9 +318,9 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 	prevHash := prev.BlockHash()
 	// check if headers link together.  That whole 'blockchain' thing.
 	if prevHash.IsEqual(&cur.PrevBlock) == false {
-		log.Printf("Headers %d and %d don't link.\n",
+		logger.Warnf("Headers %d and %d don't link.\n",
 			height-1, height)
-		log.Printf("%s - %s",
+		logger.Warnf("%s - %s",
 			prev.BlockHash().String(), cur.BlockHash().String())
 		return false
 	}
@@ -331,13 +330,13 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 		//		rightBits, err := p.DiffCalcFunction(r, height, startheight, p)
 		rightBits, err := p.DiffCalcFunction(nil, height, p)
 		if err != nil {
-			log.Printf("Error calculating Block %d %s difficuly. %s\n",
+			logger.Errorf("Error calculating Block %d %s difficuly. %s\n",
 				height, cur.BlockHash().String(), err.Error())
 			return false
 		}
 
 		if cur.Bits != rightBits {
-			log.Printf("Block %d %s incorrect difficuly.  Read %x, expect %x\n",
+			logger.Warnf("Block %d %s incorrect difficuly.  Read %x, expect %x\n",
 				height, cur.BlockHash().String(), cur.Bits, rightBits)
 			return false
 		}
@@ -345,7 +344,7 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 
 	// check if there's a valid proof of work.  That whole "Bitcoin" thing.
 	if !checkProofOfWork(cur, p) {
-		log.Printf("Block %d Bad proof of work.\n", height)
+		logger.Errorf("Block %d Bad proof of work.\n", height)
 		return false
 	}
 
@@ -353,7 +352,7 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 	for _, checkpoint := range p.Checkpoints {
 		if checkpoint.Height == height {
 			if *checkpoint.Hash != cur.BlockHash() {
-				log.Printf("Block %d is not a valid checkpoint", height)
+				logger.Warnf("Block %d is not a valid checkpoint", height)
 				return false
 			}
 			break
@@ -364,12 +363,12 @@ func CheckHeader(r io.ReadSeeker, height, startheight int32, p *coinparam.Params
 	// can go missing
 	_, err = r.Seek(int64(80*(offsetHeight)), os.SEEK_SET)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 	err = cur.Deserialize(r)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 		return false
 	}
 
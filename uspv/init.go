@@ -3,18 +3,17 @@ package uspv
 import (
 	"bytes"
 	"io/ioutil"
-	"log"
-	"net"
 	"os"
 
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
 )
 
 // Connect dials out and connects to full nodes.
 func (s *SPVCon) Connect(remoteNode string) error {
 	var err error
 	// open TCP connection
-	s.con, err = net.Dial("tcp", remoteNode)
+	s.con, err = lnutil.Dial("tcp", remoteNode)
 	if err != nil {
 		return err
 	}
@@ -39,7 +38,7 @@ func (s *SPVCon) Connect(remoteNode string) error {
 		return err
 	}
 	s.WBytes += uint64(n)
-	log.Printf("wrote %d byte version message to %s\n",
+	logger.Infof("wrote %d byte version message to %s\n",
 		n, s.con.RemoteAddr().String())
 	n, m, b, err := wire.ReadMessageWithEncodingN(
 		s.con, s.localVersion, wire.BitcoinNet(s.Param.NetMagicBytes), wire.LatestEncoding)
@@ -47,13 +46,13 @@ func (s *SPVCon) Connect(remoteNode string) error {
 		return err
 	}
 	s.RBytes += uint64(n)
-	log.Printf("got %d byte response %x\n command: %s\n", n, b, m.Command())
+	logger.Infof("got %d byte response %x\n command: %s\n", n, b, m.Command())
 
 	mv, ok := m.(*wire.MsgVersion)
 	if ok {
-		log.Printf("connected to %s", mv.UserAgent)
+		logger.Infof("connected to %s", mv.UserAgent)
 	}
-	log.Printf("remote reports version %x (dec %d)\n",
+	logger.Infof("remote reports version %x (dec %d)\n",
 		mv.ProtocolVersion, mv.ProtocolVersion)
 
 	// set remote height
@@ -130,9 +129,9 @@ func (s *SPVCon) openHeaderFile(hfn string) error {
 			if err != nil {
 				return err
 			}
-			log.Printf("made genesis header %x\n", b.Bytes())
-			log.Printf("made genesis hash %s\n", s.Param.GenesisHash.String())
-			log.Printf("created hardcoded genesis header at %s\n", hfn)
+			logger.Infof("made genesis header %x\n", b.Bytes())
+			logger.Infof("made genesis hash %s\n", s.Param.GenesisHash.String())
+			logger.Infof("created hardcoded genesis header at %s\n", hfn)
 		}
 	}
   
@@ -144,6 +143,6 @@ func (s *SPVCon) openHeaderFile(hfn string) error {
 	if err != nil {
 		return err
 	}
-	log.Printf("opened header file %s\n", s.headerFile.Name())
+	logger.Infof("opened header file %s\n", s.headerFile.Name())
 	return nil
 }
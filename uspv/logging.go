@@ -0,0 +1,6 @@
+package uspv
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the uspv subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("uspv")
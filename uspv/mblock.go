@@ -2,7 +2,6 @@ package uspv
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
@@ -11,7 +10,7 @@ import (
 func MakeMerkleParent(left, right *chainhash.Hash) *chainhash.Hash {
 	// dupes can screw things up; CVE-2012-2459. check for them
 	if left != nil && right != nil && left.IsEqual(right) {
-		log.Printf("DUP HASH CRASH")
+		logger.Warnf("DUP HASH CRASH")
 		return nil
 	}
 	// if left child is nil, output nil.  Need this for hard mode.
@@ -55,7 +54,7 @@ func inDeadZone(pos, size uint32) bool {
 	msb := nextPowerOfTwo(size)
 	last := size - 1      // last valid position is 1 less than size
 	if pos > (msb<<1)-2 { // greater than root; not even in the tree
-		log.Printf(" ?? greater than root ")
+		logger.Warnf(" ?? greater than root ")
 		return true
 	}
 	h := msb
@@ -66,19 +65,21 @@ func inDeadZone(pos, size uint32) bool {
 	return pos > last
 }
 
-// take in a merkle block, parse through it, and return txids indicated
+// take in a merkle block, parse through it, and return txids indicated,
+// along with their positions in the block.
 // If there's any problem return an error.  Checks self-consistency only.
 // doing it with a stack instead of recursion.  Because...
 // OK I don't know why I'm just not in to recursion OK?
-func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
+func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, []uint32, error) {
 	if m.Transactions == 0 {
-		return nil, fmt.Errorf("No transactions in merkleblock")
+		return nil, nil, fmt.Errorf("No transactions in merkleblock")
 	}
 	if len(m.Flags) == 0 {
-		return nil, fmt.Errorf("No flag bits")
+		return nil, nil, fmt.Errorf("No flag bits")
 	}
 	var s []merkleNode      // the stack
 	var r []*chainhash.Hash // slice to return; txids we care about
+	var poss []uint32       // and where they are in the block
 
 	// set initial position to root of merkle tree
 	msb := nextPowerOfTwo(m.Transactions) // most significant bit possible
@@ -93,9 +94,9 @@ func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
 		// is stack one filled item?  that's complete.
 		if tip == 0 && s[0].h != nil {
 			if s[0].h.IsEqual(&m.Header.MerkleRoot) {
-				return r, nil
+				return r, poss, nil
 			}
-			return nil, fmt.Errorf("computed root %s but expect %s\n",
+			return nil, nil, fmt.Errorf("computed root %s but expect %s\n",
 				s[0].h.String(), m.Header.MerkleRoot.String())
 		}
 		// is current position in the tree's dead zone? partial parent
@@ -121,10 +122,10 @@ func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
 
 		// no stack ops to perform, so make new node from message hashes
 		if len(m.Hashes) == 0 {
-			return nil, fmt.Errorf("Ran out of hashes at position %d.", pos)
+			return nil, nil, fmt.Errorf("Ran out of hashes at position %d.", pos)
 		}
 		if len(m.Flags) == 0 {
-			return nil, fmt.Errorf("Ran out of flag bits.")
+			return nil, nil, fmt.Errorf("Ran out of flag bits.")
 		}
 		var n merkleNode // make new node
 		n.p = pos        // set current position for new node
@@ -145,12 +146,13 @@ func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
 		} else { // bottom row txid; flag bit indicates tx of interest
 			if pos >= m.Transactions {
 				// this can't happen because we check deadzone above...
-				return nil, fmt.Errorf("got into an invalid txid node")
+				return nil, nil, fmt.Errorf("got into an invalid txid node")
 			}
 			n.h = m.Hashes[0]           // copy hash from message
 			m.Hashes = m.Hashes[1:]     // pop off message
 			if m.Flags[0]&(1<<i) != 0 { //txid of interest
 				r = append(r, n.h)
+				poss = append(poss, pos)
 			}
 			if pos&1 == 0 { // left side, go to sibling
 				pos |= 1
@@ -165,5 +167,5 @@ func checkMBlock(m *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
 			m.Flags = m.Flags[1:]
 		}
 	}
-	return nil, fmt.Errorf("ran out of things to do?")
+	return nil, nil, fmt.Errorf("ran out of things to do?")
 }
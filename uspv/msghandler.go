@@ -1,8 +1,6 @@
 package uspv
 
 import (
-	"log"
-
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil/bloom"
 	"github.com/mit-dci/lit/lnutil"
@@ -13,27 +11,29 @@ func (s *SPVCon) incomingMessageHandler() {
 		n, xm, _, err := wire.ReadMessageWithEncodingN(s.con, s.localVersion,
 			wire.BitcoinNet(s.Param.NetMagicBytes), wire.LatestEncoding)
 		if err != nil {
-			log.Printf("ReadMessageWithEncodingN error.  Disconnecting: %s\n", err.Error())
+			logger.Errorf("ReadMessageWithEncodingN error.  Disconnecting: %s\n", err.Error())
 			return
 		}
 		s.RBytes += uint64(n)
 		//		log.Printf("Got %d byte %s message\n", n, xm.Command())
 		switch m := xm.(type) {
 		case *wire.MsgVersion:
-			log.Printf("Got version message.  Agent %s, version %d, at height %d\n",
+			logger.Infof("Got version message.  Agent %s, version %d, at height %d\n",
 				m.UserAgent, m.ProtocolVersion, m.LastBlock)
 			s.remoteVersion = uint32(m.ProtocolVersion) // weird cast! bug?
 		case *wire.MsgVerAck:
-			log.Printf("Got verack.  Whatever.\n")
+			logger.Infof("Got verack.  Whatever.\n")
 		case *wire.MsgAddr:
-			log.Printf("got %d addresses.\n", len(m.AddrList))
+			logger.Debugf("got %d addresses.\n", len(m.AddrList))
 		case *wire.MsgPing:
 			// log.Printf("Got a ping message.  We should pong back or they will kick us off.")
 			go s.PongBack(m.Nonce)
 		case *wire.MsgPong:
-			log.Printf("Got a pong response. OK.\n")
+			logger.Infof("Got a pong response. OK.\n")
 		case *wire.MsgBlock:
-			s.IngestBlock(m)
+			if !s.fetched(m) {
+				s.IngestBlock(m)
+			}
 		case *wire.MsgMerkleBlock:
 			s.IngestMerkleBlock(m)
 		case *wire.MsgHeaders: // concurrent because we keep asking for blocks
@@ -41,20 +41,20 @@ func (s *SPVCon) incomingMessageHandler() {
 		case *wire.MsgTx: // not concurrent! txs must be in order
 			s.TxHandler(m)
 		case *wire.MsgReject:
-			log.Printf("Rejected! cmd: %s code: %s tx: %s reason: %s",
+			logger.Warnf("Rejected! cmd: %s code: %s tx: %s reason: %s",
 				m.Cmd, m.Code.String(), m.Hash.String(), m.Reason)
 		case *wire.MsgInv:
 			s.InvHandler(m)
 		case *wire.MsgNotFound:
-			log.Printf("Got not found response from remote:")
+			logger.Infof("Got not found response from remote:")
 			for i, thing := range m.InvList {
-				log.Printf("\t$d) %s: %s", i, thing.Type, thing.Hash)
+				logger.Infof("\t$d) %s: %s", i, thing.Type, thing.Hash)
 			}
 		case *wire.MsgGetData:
 			s.GetDataHandler(m)
 
 		default:
-			log.Printf("Got unknown message type %s\n", m.Command())
+			logger.Infof("Got unknown message type %s\n", m.Command())
 		}
 	}
 	return
@@ -70,7 +70,7 @@ func (s *SPVCon) outgoingMessageHandler() {
 			wire.BitcoinNet(s.Param.NetMagicBytes), wire.LatestEncoding)
 
 		if err != nil {
-			log.Printf("Write message error: %s", err.Error())
+			logger.Errorf("Write message error: %s", err.Error())
 		}
 		s.WBytes += uint64(n)
 	}
@@ -85,13 +85,13 @@ func (s *SPVCon) fPositiveHandler() {
 		if fpAccumulator > 7 {
 			filt, err := s.GimmeFilter()
 			if err != nil {
-				log.Printf("Filter creation error: %s\n", err.Error())
-				log.Printf("uhoh, crashing filter handler")
+				logger.Errorf("Filter creation error: %s\n", err.Error())
+				logger.Infof("uhoh, crashing filter handler")
 				return
 			}
 			// send filter
 			s.Refilter(filt)
-			log.Printf("sent filter %x\n", filt.MsgFilterLoad().Filter)
+			logger.Debugf("sent filter %x\n", filt.MsgFilterLoad().Filter)
 
 			// clear the channel
 		finClear:
@@ -104,7 +104,7 @@ func (s *SPVCon) fPositiveHandler() {
 				}
 			}
 
-			log.Printf("reset %d false positives\n", fpAccumulator)
+			logger.Debugf("reset %d false positives\n", fpAccumulator)
 			// reset accumulator
 			fpAccumulator = 0
 		}
@@ -116,14 +116,14 @@ func (s *SPVCon) fPositiveHandler() {
 func (s *SPVCon) HeaderHandler(m *wire.MsgHeaders) {
 	moar, err := s.IngestHeaders(m)
 	if err != nil {
-		log.Printf("Header error: %s\n", err.Error())
+		logger.Errorf("Header error: %s\n", err.Error())
 		return
 	}
 	// more to get? if so, ask for them and return
 	if moar {
 		err = s.AskForHeaders()
 		if err != nil {
-			log.Printf("AskForHeaders error: %s", err.Error())
+			logger.Errorf("AskForHeaders error: %s", err.Error())
 		}
 		return
 	}
@@ -131,17 +131,17 @@ func (s *SPVCon) HeaderHandler(m *wire.MsgHeaders) {
 	if !s.HardMode { // don't send this in hardmode! that's the whole point
 		filt, err := s.GimmeFilter()
 		if err != nil {
-			log.Printf("AskForBlocks error: %s", err.Error())
+			logger.Errorf("AskForBlocks error: %s", err.Error())
 			return
 		}
 		// send filter
 		s.SendFilter(filt)
-		log.Printf("sent filter %x\n", filt.MsgFilterLoad().Filter)
+		logger.Debugf("sent filter %x\n", filt.MsgFilterLoad().Filter)
 	}
 
 	err = s.AskForBlocks()
 	if err != nil {
-		log.Printf("AskForBlocks error: %s", err.Error())
+		logger.Errorf("AskForBlocks error: %s", err.Error())
 		return
 	}
 }
@@ -149,16 +149,17 @@ func (s *SPVCon) HeaderHandler(m *wire.MsgHeaders) {
 // TxHandler takes in transaction messages that come in from either a request
 // after an inv message or after a merkle block message.
 func (s *SPVCon) TxHandler(tx *wire.MsgTx) {
-	log.Printf("received msgtx %s\n", tx.TxHash().String())
+	logger.Debugf("received msgtx %s\n", tx.TxHash().String())
 	// check if we have a height for this tx.
 	s.OKMutex.Lock()
 	height, ok := s.OKTxids[tx.TxHash()]
+	pos := s.OKTxPos[tx.TxHash()]
 	s.OKMutex.Unlock()
 	// if we don't have a height for this / it's not in the map, discard.
 	// currently CRASHES when this happens because I want to see if it ever does.
 	// it shouldn't if things are working properly.
 	if !ok {
-		log.Printf("Tx %s unknown, will not ingest\n", tx.TxHash().String())
+		logger.Infof("Tx %s unknown, will not ingest\n", tx.TxHash().String())
 		panic("unknown tx")
 		return
 	}
@@ -166,12 +167,12 @@ func (s *SPVCon) TxHandler(tx *wire.MsgTx) {
 	// check for double spends ...?
 	//	allTxs, err := s.TS.GetAllTxs()
 	//	if err != nil {
-	//		log.Printf("Can't get txs from db: %s", err.Error())
+	//		logger.Errorf("Can't get txs from db: %s", err.Error())
 	//		return
 	//	}
 	//	dubs, err := CheckDoubleSpends(m, allTxs)
 	//	if err != nil {
-	//		log.Printf("CheckDoubleSpends error: %s", err.Error())
+	//		logger.Errorf("CheckDoubleSpends error: %s", err.Error())
 	//		return
 	//	}
 	//	if len(dubs) > 0 {
@@ -183,17 +184,17 @@ func (s *SPVCon) TxHandler(tx *wire.MsgTx) {
 
 	// send txs up to wallit
 	if s.MatchTx(tx) {
-		s.TxUpToWallit <- lnutil.TxAndHeight{tx, height}
+		s.TxUpToWallit <- lnutil.TxAndHeight{Tx: tx, Height: height, TxIdx: pos}
 	}
 }
 
 // GetDataHandler responds to requests for tx data, which happen after
 // advertising our txs via an inv message
 func (s *SPVCon) GetDataHandler(m *wire.MsgGetData) {
-	log.Printf("got GetData.  Contains:\n")
+	logger.Debugf("got GetData.  Contains:\n")
 	var sent int32
 	for i, thing := range m.InvList {
-		log.Printf("\t%d)%s : %s",
+		logger.Debugf("\t%d)%s : %s",
 			i, thing.Type.String(), thing.Hash.String())
 
 		// I think we do the same thing for witTx or tx...
@@ -201,23 +202,25 @@ func (s *SPVCon) GetDataHandler(m *wire.MsgGetData) {
 		if thing.Type == wire.InvTypeWitnessTx || thing.Type == wire.InvTypeTx {
 			tx, ok := s.TxMap[thing.Hash]
 			if !ok || tx == nil {
-				log.Printf("tx %s requested by we don't have it\n",
+				logger.Infof("tx %s requested by we don't have it\n",
 					thing.Hash.String())
+			} else if s.TxRequested != nil {
+				s.TxRequested(thing.Hash)
 			}
 			s.outMsgQueue <- tx
 			sent++
 			continue
 		}
 		// didn't match, so it's not something we're responding to
-		log.Printf("We only respond to tx requests, ignoring")
+		logger.Infof("We only respond to tx requests, ignoring")
 	}
-	log.Printf("sent %d of %d requested items", sent, len(m.InvList))
+	logger.Infof("sent %d of %d requested items", sent, len(m.InvList))
 }
 
 func (s *SPVCon) InvHandler(m *wire.MsgInv) {
-	log.Printf("got inv.  Contains:\n")
+	logger.Debugf("got inv.  Contains:\n")
 	for i, thing := range m.InvList {
-		log.Printf("\t%d)%s : %s",
+		logger.Debugf("\t%d)%s : %s",
 			i, thing.Type.String(), thing.Hash.String())
 		if thing.Type == wire.InvTypeTx {
 			// ignore tx invs in ironman mode, or if we already have it
@@ -225,7 +228,7 @@ func (s *SPVCon) InvHandler(m *wire.MsgInv) {
 				// new tx, OK it at 0 and request
 				// also request if we already have it; might have new witness?
 				// needed for confirmed channels...
-				s.OKTxid(&thing.Hash, 0) // unconfirmed
+				s.OKTxid(&thing.Hash, 0, 0) // unconfirmed
 				s.AskForTx(thing.Hash)
 			}
 		}
@@ -233,14 +236,14 @@ func (s *SPVCon) InvHandler(m *wire.MsgInv) {
 			select {
 			case <-s.inWaitState:
 				// start getting headers
-				log.Printf("asking for headers due to inv block\n")
+				logger.Infof("asking for headers due to inv block\n")
 				err := s.AskForHeaders()
 				if err != nil {
-					log.Printf("AskForHeaders error: %s", err.Error())
+					logger.Errorf("AskForHeaders error: %s", err.Error())
 				}
 			default:
 				// drop it as if its component particles had high thermal energies
-				log.Printf("inv block but ignoring; not synced\n")
+				logger.Infof("inv block but ignoring; not synced\n")
 			}
 		}
 	}
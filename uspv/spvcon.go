@@ -25,7 +25,8 @@ type SPVCon struct {
 
 	syncHeight int32 // internal, in memory synchronization height
 
-	OKTxids map[chainhash.Hash]int32 // known good txids and their heights
+	OKTxids map[chainhash.Hash]int32  // known good txids and their heights
+	OKTxPos map[chainhash.Hash]uint32 // and their positions in the block
 	OKMutex sync.Mutex
 
 	// TrackingAdrs and OPs are slices of addresses and outpoints to watch for.
@@ -38,6 +39,12 @@ type SPVCon struct {
 
 	// TxMap is an in-memory map of all the Txs the SPVCon knows about
 	TxMap map[chainhash.Hash]*wire.MsgTx
+	// TxRequested, if set, is told when the remote node asks for one of them
+	TxRequested func(chainhash.Hash)
+
+	// fetches are blocks FetchBlock is waiting for; see fetch.go
+	fetches  map[chainhash.Hash]chan *wire.MsgBlock
+	fetchMtx sync.Mutex
 
 	//[doesn't work without fancy mutexes, nevermind, just use header file]
 	// localHeight   int32  // block height we're on
@@ -1,12 +1,13 @@
 package wallit
 
 import (
-	"log"
+	"fmt"
 	"sort"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/chainwatch"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
@@ -43,7 +44,7 @@ func (w *Wallit) GetPub(k portxo.KeyGen) *btcec.PublicKey {
 }
 
 func (w *Wallit) PushTx(tx *wire.MsgTx) error {
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 func (w *Wallit) Params() *coinparam.Params {
@@ -58,7 +59,7 @@ func (w *Wallit) LetMeKnow() chan lnutil.OutPointEvent {
 func (w *Wallit) CurrentHeight() int32 {
 	h, err := w.GetDBSyncHeight()
 	if err != nil {
-		log.Printf("can't get height from db...")
+		logger.Infof("can't get height from db...")
 		return -99
 	}
 	return h
@@ -68,10 +69,20 @@ func (w *Wallit) NewAdr() ([20]byte, error) {
 	return w.NewAdr160()
 }
 
+// Close closes the wallit's db.  Only call when shutting down.
+func (w *Wallit) Close() error {
+	return w.StateDB.Close()
+}
+
 func (w *Wallit) ExportHook() uspv.ChainHook {
 	return w.Hook
 }
 
+// Notifier is where to subscribe to chain events on this wallet's coin
+func (w *Wallit) Notifier() *chainwatch.Notifier {
+	return w.Notes
+}
+
 // ExportUtxo is really *IM*port utxo on this side.
 // Not implemented yet.  Fix "ingest many" at the same time eh?
 func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
@@ -80,12 +91,12 @@ func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
 	if u.Value == 0 {
 		err := w.AddPorTxoAdr(u.KeyGen)
 		if err != nil {
-			log.Printf(err.Error())
+			logger.Errorf(err.Error())
 		}
 	} else {
 		err := w.GainUtxo(*u)
 		if err != nil {
-			log.Printf(err.Error())
+			logger.Errorf(err.Error())
 		}
 	}
 
@@ -93,7 +104,7 @@ func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
 	adr160 := w.PathPubHash160(u.KeyGen)
 	err := w.Hook.RegisterAddress(adr160)
 	if err != nil {
-		log.Printf(err.Error())
+		logger.Errorf(err.Error())
 	}
 }
 
@@ -125,8 +136,45 @@ func (w *Wallit) SetFee(set int64) int64 {
 	return set
 }
 
+// SweepTxo sends one utxo, alone, to a new address of the wallet's at
+// feePerByte.  For moving outputs like a closed channel's to_local into
+// plain ones once they're spendable.
+func (w *Wallit) SweepTxo(
+	op wire.OutPoint, feePerByte int64) (*chainhash.Hash, error) {
+	utxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	var u *portxo.PorTxo
+	for _, utxo := range utxos {
+		if utxo.Op == op {
+			u = utxo
+			break
+		}
+	}
+	if u == nil {
+		return nil, fmt.Errorf("%s isn't an unspent output of the wallet's",
+			op.String())
+	}
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := w.SendOne(*u, lnutil.DirectWPKHScriptFromPKH(adr160), feePerByte)
+	if err != nil {
+		return nil, err
+	}
+	err = w.NewOutgoingTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	txid := tx.TxHash()
+	return &txid, nil
+}
+
 // ********* sweep is for testing / spamming, remove for real use
-func (w *Wallit) Sweep(outScript []byte, n uint32) ([]*chainhash.Hash, error) {
+func (w *Wallit) Sweep(
+	outScript []byte, n uint32, feePerByte int64) ([]*chainhash.Hash, error) {
 	var err error
 	var txids []*chainhash.Hash
 
@@ -146,7 +194,7 @@ func (w *Wallit) Sweep(outScript []byte, n uint32) ([]*chainhash.Hash, error) {
 
 		// this doesn't really work with maybeSend huh...
 		if u.Height != 0 && u.Value > 20000 {
-			tx, err := w.SendOne(*u, outScript)
+			tx, err := w.SendOne(*u, outScript, feePerByte)
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,193 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// CheckDB makes sure every utxo, stxo, tx and address in the wallet DB
+// deserializes and is filed under the right key.  With repair set, utxos
+// which are also recorded as spent are dropped from the utxo set, and
+// missing or wrong txo states are set from the utxo or stxo; nothing else
+// is changed.
+func (w *Wallit) CheckDB(repair bool) ([]string, error) {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	txFunc := w.StateDB.View
+	if repair {
+		txFunc = w.StateDB.Update
+	}
+	err := txFunc(func(btx *bolt.Tx) error {
+		dufb := btx.Bucket(BKToutpoint)
+		adrb := btx.Bucket(BKTadr)
+		old := btx.Bucket(BKTStxos)
+		txns := btx.Bucket(BKTTxns)
+		sta := btx.Bucket(BKTState)
+		if dufb == nil || adrb == nil || old == nil || txns == nil || sta == nil {
+			return fmt.Errorf("wallet buckets missing")
+		}
+
+		if len(sta.Get(KEYNumKeys)) != 4 {
+			report("key count %x not 4 bytes", sta.Get(KEYNumKeys))
+		}
+
+		// spent outputs
+		err := old.ForEach(func(k, v []byte) error {
+			_, err := StxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				report("stxo %x: %s", k, err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// unspent outputs; empty values are outpoints watched for the ln side
+		var spent [][]byte
+		err = dufb.ForEach(func(k, v []byte) error {
+			if len(k) != 36 {
+				report("utxo key %x not an outpoint", k)
+				return nil
+			}
+			if len(v) == 0 {
+				return nil
+			}
+			u, err := portxo.PorTxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				report("utxo %x: %s", k, err.Error())
+				return nil
+			}
+			if old.Get(k) != nil {
+				spent = append(spent, k)
+			}
+			if u.Value <= 0 {
+				report("utxo %s value %d", u.Op.String(), u.Value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// can't delete while in ForEach
+		for _, k := range spent {
+			var opArr [36]byte
+			copy(opArr[:], k)
+			op := lnutil.OutPointFromBytes(opArr)
+			if !repair {
+				report("utxo %s also spent", op.String())
+				continue
+			}
+			err = dufb.Delete(k)
+			if err != nil {
+				return err
+			}
+			report("utxo %s also spent (fixed)", op.String())
+		}
+
+		// every utxo and stxo has a state to match
+		type fix struct {
+			k      []byte
+			state  lnutil.TxoState
+			height int32
+			value  int64
+		}
+		var fixes []fix
+		checkState := func(k []byte, spent bool, state lnutil.TxoState,
+			height int32, value int64) {
+			have, ok := getTxoState(btx, k)
+			if ok && have.Spent() == spent {
+				return
+			}
+			var opArr [36]byte
+			copy(opArr[:], k)
+			op := lnutil.OutPointFromBytes(opArr)
+			if !ok {
+				report("txo %s has no state", op.String())
+			} else {
+				report("txo %s is %s", op.String(), have.String())
+			}
+			fixes = append(fixes, fix{append([]byte{}, k...), state, height, value})
+		}
+		err = dufb.ForEach(func(k, v []byte) error {
+			if len(k) != 36 || len(v) == 0 || old.Get(k) != nil {
+				return nil
+			}
+			u, err := portxo.PorTxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				return nil // reported above
+			}
+			state := lnutil.TxoUnconfirmed
+			if u.Height > 0 {
+				state = lnutil.TxoConfirmed
+			}
+			checkState(k, false, state, u.Height, u.Value)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = old.ForEach(func(k, v []byte) error {
+			st, err := StxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				return nil // reported above
+			}
+			checkState(k, true, spentState(st.SpendHeight), st.SpendHeight, st.Value)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if repair {
+			for _, f := range fixes {
+				err = setTxoState(btx, f.k, f.state, f.height, f.value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// txs, by txid
+		err = txns.ForEach(func(k, v []byte) error {
+			tx := wire.NewMsgTx()
+			err := tx.Deserialize(bytes.NewBuffer(v))
+			if err != nil {
+				report("tx %x: %s", k, err.Error())
+				return nil
+			}
+			txid := tx.TxHash()
+			if !bytes.Equal(txid[:], k) {
+				report("tx %x filed under %x", txid[:], k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// addresses: pubkey hash : keygen
+		return adrb.ForEach(func(k, v []byte) error {
+			if len(k) != 20 || len(v) != 53 {
+				report("address %x : %x wrong size", k, v)
+				return nil
+			}
+			var kgArr [53]byte
+			copy(kgArr[:], v)
+			kg := portxo.KeyGenFromBytes(kgArr)
+			adr := w.PathPubHash160(kg)
+			if !bytes.Equal(adr[:], k) {
+				report("address %x has keygen %s for %x", k, kg.String(), adr)
+			}
+			return nil
+		})
+	})
+	return problems, err
+}
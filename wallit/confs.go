@@ -0,0 +1,61 @@
+package wallit
+
+import (
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// SetConfPolicy sets how many confirmations utxos need, by amount, before
+// they're spent.  Change from the wallet's own txs doesn't wait.
+func (w *Wallit) SetConfPolicy(p lnutil.ConfPolicy) {
+	w.confMtx.Lock()
+	w.confPolicy = p
+	w.confMtx.Unlock()
+}
+
+// ConfPolicy returns the wallet's confirmation policy
+func (w *Wallit) ConfPolicy() lnutil.ConfPolicy {
+	w.confMtx.Lock()
+	defer w.confMtx.Unlock()
+	return w.confPolicy
+}
+
+// confirmed says if a utxo has the confirmations the policy wants for its
+// amount, or is change from a tx of ours
+func (w *Wallit) confirmed(u *portxo.PorTxo, curHeight int32) bool {
+	need := w.ConfPolicy().Confs(u.Value)
+	if need == 0 {
+		return true
+	}
+	if u.Height > 0 && curHeight-u.Height+1 >= need {
+		return true
+	}
+	return w.ownTx(u.Op)
+}
+
+// ownTx says if the tx making op spent any of the wallet's utxos, which
+// makes it ours
+func (w *Wallit) ownTx(op wire.OutPoint) bool {
+	tx, err := w.GetTx(&op.Hash)
+	if err != nil {
+		return false
+	}
+	own := false
+	w.StateDB.View(func(btx *bolt.Tx) error {
+		old := btx.Bucket(BKTStxos)
+		if old == nil {
+			return nil
+		}
+		for _, in := range tx.TxIn {
+			opArr := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			if old.Get(opArr[:]) != nil {
+				own = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return own
+}
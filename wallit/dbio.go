@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/blockchain"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
@@ -33,6 +32,11 @@ var (
 	KEYTipHeight = []byte("TipHeight") // height synced to
 )
 
+// BoltDB returns the wallet's DB, for backups
+func (w *Wallit) BoltDB() *bolt.DB {
+	return w.StateDB
+}
+
 // make a new change output.  I guess this is supposed to be on a different
 // branch than regular addresses...
 func (w *Wallit) NewChangeOut(amt int64) (*wire.TxOut, error) {
@@ -58,18 +62,28 @@ func (w *Wallit) AddPorTxoAdr(kg portxo.KeyGen) error {
 		}
 
 		adr160 := w.PathPubHash160(kg)
-		log.Printf("adding addr %x\n", adr160)
+		logger.Infof("adding addr %x\n", adr160)
 		// add the 20-byte key-hash into the db
 		return adrb.Put(adr160[:], kg.Bytes())
 	})
 }
 
-// AdrDump returns all the addresses in the wallit.
+// numKeysKey is where the number of keys made in an account is kept.
+// Account 0's is KEYNumKeys; others have the account number after it.
+func numKeysKey(account uint32) []byte {
+	if account == 0 {
+		return KEYNumKeys
+	}
+	return append(append([]byte{}, KEYNumKeys...), lnutil.U32tB(account)...)
+}
+
+// AdrDump returns all the addresses in the wallit, account 0's first.
 // currently returns 20 byte arrays, which
 // can then be converted somewhere else into bech32 addresses (or old base58)
 func (w *Wallit) AdrDump() ([][20]byte, error) {
-	var i, last uint32 // number of addresses made so far
+	var i uint32
 	var adrSlice [][20]byte
+	var accounts, lasts []uint32 // number of addresses made so far in each
 
 	err := w.StateDB.View(func(btx *bolt.Tx) error {
 		sta := btx.Bucket(BKTState)
@@ -77,35 +91,79 @@ func (w *Wallit) AdrDump() ([][20]byte, error) {
 			return fmt.Errorf("no state bucket")
 		}
 
-		oldNBytes := sta.Get(KEYNumKeys)
-		last = lnutil.BtU32(oldNBytes)
-		// update the db with number of created keys
+		accounts = append(accounts, 0)
+		lasts = append(lasts, lnutil.BtU32(sta.Get(KEYNumKeys)))
+		cur := sta.Cursor()
+		for k, v := cur.Seek(KEYNumKeys); bytes.HasPrefix(k, KEYNumKeys); k, v = cur.Next() {
+			if len(k) != len(KEYNumKeys)+4 {
+				continue
+			}
+			accounts = append(accounts, lnutil.BtU32(k[len(KEYNumKeys):]))
+			lasts = append(lasts, lnutil.BtU32(v))
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	if last > 1<<20 {
-		return nil, fmt.Errorf("Got %d keys stored, expect something reasonable", last)
-	}
+	for j, last := range lasts {
+		if last > 1<<20 {
+			return nil, fmt.Errorf("Got %d keys stored, expect something reasonable", last)
+		}
 
-	for i = 0; i < last; i++ {
-		nKg := GetWalletKeygen(i, w.Param.HDCoinType)
-		nAdr160 := w.PathPubHash160(nKg)
+		for i = 0; i < last; i++ {
+			nKg := GetAccountKeygen(i, w.Param.HDCoinType, accounts[j])
+			nAdr160 := w.PathPubHash160(nKg)
 
-		adrSlice = append(adrSlice, nAdr160)
+			adrSlice = append(adrSlice, nAdr160)
+		}
 	}
 	return adrSlice, nil
 }
 
+// AdrPath returns the keygen for one of the wallit's addresses
+func (w *Wallit) AdrPath(adr160 [20]byte) (portxo.KeyGen, error) {
+	var kg portxo.KeyGen
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		adrb := btx.Bucket(BKTadr)
+		if adrb == nil {
+			return fmt.Errorf("no adr bucket")
+		}
+		kgBytes := adrb.Get(adr160[:])
+		if kgBytes == nil {
+			return fmt.Errorf("address %x not in wallet", adr160)
+		}
+		if len(kgBytes) != 53 {
+			return fmt.Errorf("address %x keygen %d bytes, expect 53",
+				adr160, len(kgBytes))
+		}
+		var kgArr [53]byte
+		copy(kgArr[:], kgBytes)
+		kg = portxo.KeyGenFromBytes(kgArr)
+		return nil
+	})
+	return kg, err
+}
+
 // NewAdr creates a new, never before seen address, and increments the
 // DB counter, and returns the hash160 of the pubkey.
 func (w *Wallit) NewAdr160() ([20]byte, error) {
+	adr160, _, err := w.NewAccountAdr(0)
+	return adr160, err
+}
+
+// NewAccountAdr is NewAdr160 for an account, also returning the keygen
+// the address came from.  Account 0 is the standard wallet.
+func (w *Wallit) NewAccountAdr(account uint32) ([20]byte, portxo.KeyGen, error) {
 	var err error
 	var empty160 [20]byte
+	var nKg portxo.KeyGen
 	if w.Param == nil {
-		return empty160, fmt.Errorf("NewAdr error: nil param")
+		return empty160, nKg, fmt.Errorf("NewAdr error: nil param")
+	}
+	if account >= 1<<31 {
+		return empty160, nKg, fmt.Errorf("account %d too big", account)
 	}
 
 	var n uint32 // number of addresses made so far
@@ -116,22 +174,22 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 			return fmt.Errorf("no state bucket")
 		}
 
-		oldNBytes := sta.Get(KEYNumKeys)
+		oldNBytes := sta.Get(numKeysKey(account))
 		n = lnutil.BtU32(oldNBytes)
 		// update the db with number of created keys
 		return nil
 	})
 	if n > 1<<30 {
-		return empty160, fmt.Errorf("Got %d keys stored, expect something reasonable", n)
+		return empty160, nKg, fmt.Errorf("Got %d keys stored, expect something reasonable", n)
 	}
 
-	nKg := GetWalletKeygen(n, w.Param.HDCoinType)
+	nKg = GetAccountKeygen(n, w.Param.HDCoinType, account)
 	nAdr160 := w.PathPubHash160(nKg)
 
 	if nAdr160 == empty160 {
-		return empty160, fmt.Errorf("NewAdr error: got nil h160")
+		return empty160, nKg, fmt.Errorf("NewAdr error: got nil h160")
 	}
-	log.Printf("adr %d hash is %x\n", n, nAdr160)
+	logger.Infof("account %d adr %d hash is %x\n", account, n, nAdr160)
 
 	kgBytes := nKg.Bytes()
 
@@ -156,18 +214,18 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 		}
 
 		// update the db with number of created keys
-		return sta.Put(KEYNumKeys, nKeyNumBytes)
+		return sta.Put(numKeysKey(account), nKeyNumBytes)
 	})
 	if err != nil {
-		return empty160, err
+		return empty160, nKg, err
 	}
 
 	err = w.Hook.RegisterAddress(nAdr160)
 	if err != nil {
-		return empty160, err
+		return empty160, nKg, err
 	}
 
-	return nAdr160, nil
+	return nAdr160, nKg, nil
 }
 
 // SetDBSyncHeight sets sync height of the db, indicated the latest block
@@ -225,6 +283,54 @@ func (w *Wallit) SaveTx(tx *wire.MsgTx) error {
 	})
 }
 
+// GetTx returns a tx saved in the DB; txs the wallet sent or got money from
+// are saved.
+func (w *Wallit) GetTx(txid *chainhash.Hash) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx()
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		txbkt := btx.Bucket(BKTTxns)
+		if txbkt == nil {
+			return fmt.Errorf("tx bucket not in db")
+		}
+		txb := txbkt.Get(txid[:])
+		if txb == nil {
+			return fmt.Errorf("tx %s not in db", txid.String())
+		}
+		return tx.Deserialize(bytes.NewBuffer(txb))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// SpendOf returns the saved tx which spends an outpoint, or nil if there
+// isn't one.  Txs spending watched outpoints are always saved, so for those
+// nil means it's unspent as far as the wallet has synced.
+func (w *Wallit) SpendOf(op wire.OutPoint) (*wire.MsgTx, error) {
+	var spend *wire.MsgTx
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		txbkt := btx.Bucket(BKTTxns)
+		if txbkt == nil {
+			return fmt.Errorf("tx bucket not in db")
+		}
+		return txbkt.ForEach(func(k, v []byte) error {
+			tx := wire.NewMsgTx()
+			err := tx.Deserialize(bytes.NewBuffer(v))
+			if err != nil {
+				return err
+			}
+			for _, in := range tx.TxIn {
+				if lnutil.OutPointsEqual(in.PreviousOutPoint, op) {
+					spend = tx
+				}
+			}
+			return nil
+		})
+	})
+	return spend, err
+}
+
 func (w *Wallit) UtxoDump() ([]*portxo.PorTxo, error) {
 	return w.GetAllUtxos()
 }
@@ -284,7 +390,7 @@ func (w *Wallit) RegisterWatchOP(op wire.OutPoint) error {
 // GainUtxo registers the utxo in the duffel bag
 // don't register address; they shouldn't be re-used ever anyway.
 func (w *Wallit) GainUtxo(u portxo.PorTxo) error {
-	log.Printf("gaining exported utxo %s at height %d\n",
+	logger.Infof("gaining exported utxo %s at height %d\n",
 		u.Op.String(), u.Height)
 	// serialize porTxo
 	utxoBytes, err := u.Bytes()
@@ -301,7 +407,12 @@ func (w *Wallit) GainUtxo(u portxo.PorTxo) error {
 		}
 
 		// add utxo itself
-		return dufb.Put(utxoBytes[:36], utxoBytes[36:])
+		err = dufb.Put(utxoBytes[:36], utxoBytes[36:])
+		if err != nil {
+			return err
+		}
+		return setTxoState(btx, utxoBytes[:36],
+			gainedState(btx, utxoBytes[:36], u.Height), u.Height, u.Value)
 	})
 }
 
@@ -340,7 +451,10 @@ func NewPorTxoBytesFromKGBytes(
 	return ptxo.Bytes()
 }
 
-// Rollback rewinds the wallet state to a previous height.  It removes new UTXOs
+// Rollback rewinds the wallet state to a previous height.  UTXOs above it
+// go back to unconfirmed, marked reorged, and spends above it go back to
+// pending; most of those txs will be mined again.  Spent outputs aren't
+// brought back, so nothing's counted twice if they are.
 func (w *Wallit) RollBack(rollHeight int32) error {
 	// Assume this is an actual reord / rewind.  If you supply a height *greater*
 	// than the current height, all bets are off.  ( probably nothing will
@@ -349,7 +463,7 @@ func (w *Wallit) RollBack(rollHeight int32) error {
 	// I still don't 100% get how these bolt tx things get encapsulated.
 	return w.StateDB.Update(func(btx *bolt.Tx) error {
 		// range through utxos and remove all above target height
-		log.Printf("Rollback height %d\n", rollHeight)
+		logger.Infof("Rollback height %d\n", rollHeight)
 
 		dufb := btx.Bucket(BKToutpoint)
 
@@ -357,7 +471,7 @@ func (w *Wallit) RollBack(rollHeight int32) error {
 			return fmt.Errorf("no duffel bag")
 		}
 
-		// build slice of stuff to delete
+		// build slice of stuff taken out of the chain
 		var killOPs [][]byte
 
 		err := dufb.ForEach(func(k, v []byte) error {
@@ -379,11 +493,9 @@ func (w *Wallit) RollBack(rollHeight int32) error {
 				return err
 			}
 
-			log.Printf("tx height %d\n", txHeight)
+			logger.Infof("tx height %d\n", txHeight)
 			if txHeight > rollHeight {
-				// need to kill this TX.  we could save it somewhere else?
-				// just mark to get rid of it for now.
-				killOPs = append(killOPs, k)
+				killOPs = append(killOPs, append([]byte{}, k...))
 			}
 			return nil
 		})
@@ -391,22 +503,60 @@ func (w *Wallit) RollBack(rollHeight int32) error {
 			return err
 		}
 
-		// now delete em all
+		// now set em all back to unconfirmed
 		for _, op := range killOPs {
-			err = dufb.Delete(op)
+			v := append([]byte{}, dufb.Get(op)...)
+			copy(v[8:12], lnutil.I32tB(0))
+			err = dufb.Put(op, v)
+			if err != nil {
+				return err
+			}
+			err = setTxoState(btx, op, lnutil.TxoReorged, 0, lnutil.BtI64(v[:8]))
 			if err != nil {
 				return err
 			}
 		}
 
-		// Don't re-animate old txos at all; just hope that they get back into
-		// blocks, which they probably will.
+		// and spends above the height back to pending
+		old := btx.Bucket(BKTStxos)
+		var unspent []Stxo
+		err = old.ForEach(func(k, v []byte) error {
+			st, err := StxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				return err
+			}
+			if st.SpendHeight > rollHeight {
+				unspent = append(unspent, st)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, st := range unspent {
+			st.SpendHeight = 0
+			stxb, err := st.ToBytes()
+			if err != nil {
+				return err
+			}
+			err = old.Put(stxb[:36], stxb[36:])
+			if err != nil {
+				return err
+			}
+			err = setTxoState(btx, stxb[:36], lnutil.TxoSpendPending, 0, st.Value)
+			if err != nil {
+				return err
+			}
+		}
 
-		// The right way to do this (TODO) would be to make a rebroadcast pool
-		// where if the stored txs above the reorg height aren't re-confirmed,
-		// then it will attempt to rebroadcast them.
+		// our txs above the height get sent again until they're back in
+		err = unconfirmBroadcasts(btx, rollHeight)
+		if err != nil {
+			return err
+		}
 
-		log.Printf("Rollback db.  %d utxos lost\n", len(killOPs))
+		logger.Infof("Rollback db.  %d utxos and %d spends unconfirmed\n",
+			len(killOPs), len(unspent))
 
 		return nil
 	})
@@ -477,16 +627,21 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 			for j, out := range tx.TxOut {
 				// Don't try to Get() a nil.  I think? works ok though?
 				keygenBytes := adrb.Get(lnutil.KeyHashFromPkScript(out.PkScript))
+				var txob []byte
 				if keygenBytes != nil {
 					// address matches something we're watching, cool.
 					// fmt.Printf("txout script:%x matched kg: %x\n", out.PkScript, keygenBytes)
 
 					// build new portxo
-					txob, err := NewPorTxoBytesFromKGBytes(tx, uint32(j), height, keygenBytes)
-					if err != nil {
-						return err
-					}
-
+					txob, err = NewPorTxoBytesFromKGBytes(tx, uint32(j), height, keygenBytes)
+				} else {
+					// or it's one of our time locked outputs
+					txob, err = w.lockedTxoBytes(btx, tx, uint32(j), height)
+				}
+				if err != nil {
+					return err
+				}
+				if txob != nil {
 					// Make sure this isn't a duplicate / already been spent
 					// the first 36 bytes of the serialized portxo is the outpoint
 					spendTx := old.Get(txob[:36])
@@ -507,6 +662,11 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 					if err != nil {
 						return err
 					}
+					err = setTxoState(btx, txob[:36],
+						gainedState(btx, txob[:36], height), height, out.Value)
+					if err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -536,9 +696,10 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 
 					// build new outpoint event
 					var ev lnutil.OutPointEvent
-					ev.Op = *op         // assign outpoint
-					ev.Height = height  // assign height (may be 0)
-					ev.Tx = nil         // doesn't do anything but... for clarity
+					ev.Op = *op        // assign outpoint
+					ev.Height = height // assign height (may be 0)
+					ev.Tx = nil        // doesn't do anything but... for clarity
+					ev.TxIdx = w.txPosOf(txid)
 					w.OPEventChan <- ev // send into the channel...
 				}
 			}
@@ -571,7 +732,7 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 					return err
 				}
 				// print lost portxo
-				log.Printf(lostTxo.String())
+				logger.Infof(lostTxo.String())
 
 				// after marking for deletion, save stxo to old bucket
 				var st Stxo                               // generate spent txo
@@ -591,6 +752,23 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 				if err != nil {
 					return err
 				}
+				err = setTxoState(btx, curOP[:],
+					spentState(height), height, lostTxo.Value)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// already spent: confirming that spend, or a conflicting one
+			if sv := old.Get(curOP[:]); v == nil && sv != nil {
+				changed, err := respend(
+					btx, curOP, sv, *cachedShas[spentTxIdx[i]], height)
+				if err != nil {
+					return err
+				}
+				if changed {
+					hitTxs[spentTxIdx[i]] = true
+				}
 			}
 		}
 
@@ -609,6 +787,24 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 		return nil
 	})
 
-	log.Printf("ingest %d txs, %d hits\n", len(txs), hits)
-	return hits, err
+	logger.Infof("ingest %d txs, %d hits\n", len(txs), hits)
+	if err != nil {
+		return hits, err
+	}
+	err = w.broadcastSeen(txs, cachedShas, height)
+	if err != nil {
+		logger.Errorf("broadcast pool: %s\n", err.Error())
+	}
+	if w.Notes != nil {
+		w.Notes.AddTxs(txs, height)
+	}
+	return hits, nil
+}
+
+// txPosOf returns where in its block the hook said a tx is, or 0 if it
+// didn't
+func (w *Wallit) txPosOf(txid *chainhash.Hash) uint32 {
+	w.txPosMtx.Lock()
+	defer w.txPosMtx.Unlock()
+	return w.txPos[*txid]
 }
@@ -0,0 +1,450 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+An heir is a dead man's switch for the wallet: a tx sending all its
+confirmed coins to the heir's address, signed now with an nLockTime Delay
+blocks off, so it can't go in a block before then.  HeirWatcher keeps it
+current.  When the wallet's coins change it's signed again with the same
+lock height.  Once the tip is within HeirRefreshBlocks of the lock height,
+if the owner has checked in since it was signed, the lock height's pushed
+back to Delay blocks from the tip; if the lock height comes with no check
+in, the wallet sends the tx itself.
+
+Checking in is only ever done by hand (HeirCheckIn), since lit being up says
+nothing about whether its owner is.
+
+The tx can be exported for the heir to keep, in case this node's gone by
+then.  An exported tx can't be taken back, so pushing the lock height back
+after an export first spends the coins it spends to a new address of the
+wallet's, which costs a tx's fee; so does setting a different heir, or
+none.  After the wallet's coins change, export it again to give the heir
+one with all of them.  Its fee is the wallet's rate when it was signed;
+the heir can bump it by spending their output.
+*/
+
+// BKTHeir holds the wallet's heir, if it has one, at heirKey: delay (4),
+// lock height (4), signed at (4), checked in (4), flags (1), script length
+// (1), the script, then the signed tx
+var BKTHeir = []byte("Heir")
+
+var heirKey = []byte("heir")
+
+const (
+	// least blocks ahead an heir tx can be locked, about a month
+	minHeirDelay = 4320
+
+	heirFlagExported = 1
+	heirFlagSent     = 2
+)
+
+func heirBytes(h *lnutil.Heir) []byte {
+	var buf bytes.Buffer
+	buf.Write(lnutil.I32tB(h.Delay))
+	buf.Write(lnutil.I32tB(h.LockHeight))
+	buf.Write(lnutil.I32tB(h.SignedAt))
+	buf.Write(lnutil.I32tB(h.CheckIn))
+	var flags byte
+	if h.Exported {
+		flags |= heirFlagExported
+	}
+	if h.Sent {
+		flags |= heirFlagSent
+	}
+	buf.WriteByte(flags)
+	buf.WriteByte(byte(len(h.Script)))
+	buf.Write(h.Script)
+	buf.Write(h.Tx)
+	return buf.Bytes()
+}
+
+func heirFromBytes(b []byte) (*lnutil.Heir, error) {
+	if len(b) < 18 || len(b) < 18+int(b[17]) {
+		return nil, fmt.Errorf("heir %x wrong size", b)
+	}
+	h := &lnutil.Heir{
+		Delay:      lnutil.BtI32(b[0:4]),
+		LockHeight: lnutil.BtI32(b[4:8]),
+		SignedAt:   lnutil.BtI32(b[8:12]),
+		CheckIn:    lnutil.BtI32(b[12:16]),
+		Exported:   b[16]&heirFlagExported != 0,
+		Sent:       b[16]&heirFlagSent != 0,
+	}
+	end := 18 + int(b[17])
+	h.Script = append([]byte{}, b[18:end]...)
+	if len(b) > end {
+		h.Tx = append([]byte{}, b[end:]...)
+	}
+	return h, nil
+}
+
+// loadHeir gets the heir from the db, nil if there isn't one.  Hold heirMtx.
+func (w *Wallit) loadHeir() (*lnutil.Heir, error) {
+	var h *lnutil.Heir
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(BKTHeir).Get(heirKey)
+		if v == nil {
+			return nil
+		}
+		var err error
+		h, err = heirFromBytes(v)
+		return err
+	})
+	return h, err
+}
+
+// saveHeir puts the heir in the db; nil takes it out.  Hold heirMtx.
+func (w *Wallit) saveHeir(h *lnutil.Heir) error {
+	return w.StateDB.Update(func(btx *bolt.Tx) error {
+		hb := btx.Bucket(BKTHeir)
+		if h == nil {
+			return hb.Delete(heirKey)
+		}
+		return hb.Put(heirKey, heirBytes(h))
+	})
+}
+
+func heirTxFromBytes(b []byte) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx()
+	err := tx.Deserialize(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Heir is the wallet's heir, nil if it has none
+func (w *Wallit) Heir() (*lnutil.Heir, error) {
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	return w.loadHeir()
+}
+
+// SetHeir has the wallet's coins go to script if the owner stops checking
+// in, delay blocks from now.  Replaces any heir set before.
+func (w *Wallit) SetHeir(script []byte, delay int32) error {
+	if delay < minHeirDelay || delay > maxLockBlocks {
+		return fmt.Errorf("heir delay %d; should be %d to %d blocks",
+			delay, minHeirDelay, maxLockBlocks)
+	}
+	if len(script) == 0 || len(script) > 255 {
+		return fmt.Errorf("heir script %d bytes", len(script))
+	}
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	old, err := w.loadHeir()
+	if err != nil {
+		return err
+	}
+	if old != nil && !old.Sent {
+		err = w.invalidateHeirTx(old)
+		if err != nil {
+			return err
+		}
+	}
+	height := w.CurrentHeight()
+	h := &lnutil.Heir{
+		Script:     script,
+		Delay:      delay,
+		LockHeight: height + delay,
+		SignedAt:   height,
+		CheckIn:    height,
+	}
+	err = w.resignHeir(h, height)
+	if err != nil {
+		return err
+	}
+	return w.saveHeir(h)
+}
+
+// ClearHeir takes the wallet's heir away
+func (w *Wallit) ClearHeir() error {
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	h, err := w.loadHeir()
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return fmt.Errorf("no heir set")
+	}
+	if !h.Sent {
+		err = w.invalidateHeirTx(h)
+		if err != nil {
+			return err
+		}
+	}
+	return w.saveHeir(nil)
+}
+
+// HeirCheckIn says the owner's still around, so the heir tx's lock height
+// gets pushed back once it's near
+func (w *Wallit) HeirCheckIn() error {
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	h, err := w.loadHeir()
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return fmt.Errorf("no heir set")
+	}
+	if h.Sent {
+		return fmt.Errorf("too late; the heir tx went out at %d", h.LockHeight)
+	}
+	height := w.CurrentHeight()
+	h.CheckIn = height
+	err = w.refreshHeir(h, height)
+	if err != nil {
+		return err
+	}
+	return w.saveHeir(h)
+}
+
+// ExportHeirTx gives the signed heir tx, to hand to the heir
+func (w *Wallit) ExportHeirTx() (*wire.MsgTx, error) {
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	h, err := w.loadHeir()
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, fmt.Errorf("no heir set")
+	}
+	if len(h.Tx) == 0 {
+		return nil, fmt.Errorf("no heir tx; no confirmed coins to send")
+	}
+	tx, err := heirTxFromBytes(h.Tx)
+	if err != nil {
+		return nil, err
+	}
+	h.Exported = true
+	err = w.saveHeir(h)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// HeirWatcher keeps the heir tx current as blocks come in.  Runs forever.
+func (w *Wallit) HeirWatcher() {
+	sub := w.Notes.WatchBlocks()
+	defer sub.Cancel()
+	for {
+		<-sub.Events
+		err := w.heirTick()
+		if err != nil {
+			logger.Errorf("heir: %s\n", err.Error())
+		}
+	}
+}
+
+// heirTick does what a block calls for with the heir, if there is one
+func (w *Wallit) heirTick() error {
+	w.heirMtx.Lock()
+	defer w.heirMtx.Unlock()
+	h, err := w.loadHeir()
+	if err != nil || h == nil || h.Sent {
+		return err
+	}
+	before := heirBytes(h)
+	err = w.refreshHeir(h, w.CurrentHeight())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, heirBytes(h)) {
+		return nil
+	}
+	return w.saveHeir(h)
+}
+
+// refreshHeir pushes the lock height back if it's near and the owner's
+// checked in, signs the tx again if the coins have changed, and sends it
+// if the lock height's come.  Hold heirMtx.
+func (w *Wallit) refreshHeir(h *lnutil.Heir, height int32) error {
+	if h.Sent {
+		return nil
+	}
+	if height >= h.LockHeight-lnutil.HeirRefreshBlocks && h.CheckIn > h.SignedAt {
+		err := w.invalidateHeirTx(h)
+		if err != nil {
+			return err
+		}
+		h.LockHeight = height + h.Delay
+		h.SignedAt = height
+		h.Exported = false
+		h.Tx = nil
+		logger.Infof("heir tx lock height pushed back to %d\n", h.LockHeight)
+	}
+	err := w.resignHeir(h, height)
+	if err != nil {
+		return err
+	}
+	if len(h.Tx) == 0 || height < h.LockHeight {
+		return nil
+	}
+	tx, err := heirTxFromBytes(h.Tx)
+	if err != nil {
+		return err
+	}
+	err = w.broadcast(tx)
+	if err != nil {
+		return err
+	}
+	h.Sent = true
+	logger.Warnf("no check in by height %d; sent heir tx %s\n",
+		h.LockHeight, tx.TxHash().String())
+	return nil
+}
+
+// heirInputs are the utxos the heir tx spends: confirmed ones which can be
+// spent now, so the tx can always be invalidated.  Revoked channel outputs
+// are left out, as they're swept right away.
+func (w *Wallit) heirInputs(height int32) ([]*portxo.PorTxo, error) {
+	utxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	var ins []*portxo.PorTxo
+	for _, u := range utxos {
+		if u.Height < 1 || u.Seq == 1 || !u.Mature(height) {
+			continue
+		}
+		ins = append(ins, u)
+	}
+	return ins, nil
+}
+
+// resignHeir signs the heir tx again if the coins it would spend aren't
+// the ones it does
+func (w *Wallit) resignHeir(h *lnutil.Heir, height int32) error {
+	ins, err := w.heirInputs(height)
+	if err != nil {
+		return err
+	}
+	if len(h.Tx) > 0 {
+		tx, err := heirTxFromBytes(h.Tx)
+		if err != nil {
+			return err
+		}
+		if spendsJust(tx, ins) {
+			return nil
+		}
+	}
+	h.Tx = nil
+	if len(ins) == 0 {
+		return nil
+	}
+
+	var total int64
+	signIns := make([]*portxo.PorTxo, len(ins))
+	for i, u := range ins {
+		c := *u
+		if c.Seq <= 1 {
+			// a final sequence would let it in a block before the lock height
+			c.Seq = wire.MaxTxInSequenceNum - 1
+		}
+		signIns[i] = &c
+		total += u.Value
+	}
+	out := wire.NewTxOut(0, h.Script)
+	out.Value = total - EstFee(signIns, []*wire.TxOut{out}, w.FeeRate)
+	if out.Value < 1000 {
+		logger.Infof("heir tx would send %d; not signing one\n", out.Value)
+		return nil
+	}
+	tx, err := w.BuildAndSign(signIns, []*wire.TxOut{out}, uint32(h.LockHeight))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tx.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	h.Tx = buf.Bytes()
+	logger.Infof("heir tx %s sends %d from %d utxos at %d\n",
+		tx.TxHash().String(), out.Value, len(ins), h.LockHeight)
+	return nil
+}
+
+// spendsJust says if tx spends the utxos and nothing else
+func spendsJust(tx *wire.MsgTx, utxos []*portxo.PorTxo) bool {
+	if len(tx.TxIn) != len(utxos) {
+		return false
+	}
+	ops := make(map[wire.OutPoint]bool)
+	for _, u := range utxos {
+		ops[u.Op] = true
+	}
+	for _, in := range tx.TxIn {
+		if !ops[in.PreviousOutPoint] {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidateHeirTx spends what an exported heir tx spends to a new address
+// of the wallet's, so the heir can't send it.  Does nothing if it's never
+// been exported.
+func (w *Wallit) invalidateHeirTx(h *lnutil.Heir) error {
+	if !h.Exported || len(h.Tx) == 0 {
+		return nil
+	}
+	tx, err := heirTxFromBytes(h.Tx)
+	if err != nil {
+		return err
+	}
+	utxos, err := w.GetAllUtxos()
+	if err != nil {
+		return err
+	}
+	spent := make(map[wire.OutPoint]bool)
+	for _, in := range tx.TxIn {
+		spent[in.PreviousOutPoint] = true
+	}
+	height := w.CurrentHeight()
+	var ins []*portxo.PorTxo
+	var total int64
+	for _, u := range utxos {
+		if spent[u.Op] && u.Mature(height) {
+			ins = append(ins, u)
+			total += u.Value
+		}
+	}
+	// if it's lost any of them it's no good already
+	if len(ins) < len(tx.TxIn) {
+		return nil
+	}
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return err
+	}
+	out := wire.NewTxOut(0, lnutil.DirectWPKHScriptFromPKH(adr160))
+	out.Value = total - EstFee(ins, []*wire.TxOut{out}, w.FeeRate)
+	if out.Value < 1000 {
+		return fmt.Errorf("can't invalidate heir tx: %d left after fee", out.Value)
+	}
+	moveTx, err := w.BuildAndSign(ins, []*wire.TxOut{out}, uint32(height))
+	if err != nil {
+		return err
+	}
+	err = w.NewOutgoingTx(moveTx)
+	if err != nil {
+		return err
+	}
+	logger.Infof("invalidated exported heir tx %s with %s\n",
+		tx.TxHash().String(), moveTx.TxHash().String())
+	return nil
+}
@@ -1,26 +1,32 @@
 package wallit
 
 import (
-	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil/hdkeychain"
 	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/chainwatch"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/migrate"
 	"github.com/mit-dci/lit/uspv"
 )
 
+// NewWallit starts a wallet for a coin, keeping its DB in a folder named
+// for the coin in path, and the block headers in one in headerPath
 func NewWallit(
 	rootkey *hdkeychain.ExtendedKey, birthHeight int32, resync bool,
-	spvhost, path string, p *coinparam.Params) *Wallit {
+	spvhost, path, headerPath string, p *coinparam.Params) *Wallit {
 
 	var w Wallit
 	w.rootPrivKey = rootkey
 	w.Param = p
 	w.FreezeSet = make(map[wire.OutPoint]*FrozenTx)
+	w.txPos = make(map[chainhash.Hash]uint32)
+	w.bcastAsked = make(map[chainhash.Hash]bool)
 
 	w.FeeRate = w.Param.FeePerByte
 
@@ -31,6 +37,11 @@ func NewWallit(
 	if os.IsNotExist(err) {
 		os.Mkdir(wallitpath, 0700)
 	}
+	headerpath := filepath.Join(headerPath, p.Name)
+	_, err = os.Stat(headerpath)
+	if os.IsNotExist(err) {
+		os.Mkdir(headerpath, 0700)
+	}
 
 	// Tricky part here is that we want the sync height to tell the chainhook,
 	// so we have to open the db first, then turn on the chainhook, THEN tell
@@ -38,16 +49,17 @@ func NewWallit(
 
 	u := new(uspv.SPVCon)
 	//	u := new(powless.APILink)
+	u.TxRequested = w.txRequested
 	w.Hook = u
 
 	wallitdbname := filepath.Join(wallitpath, "utxo.db")
 	err = w.OpenDB(wallitdbname)
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		logger.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	// get height
 	height := w.CurrentHeight()
-	log.Printf("DB height %d\n", height)
+	logger.Infof("DB height %d\n", height)
 
 	// bring height up to birthheight, or back down in case of resync
 	if height < birthHeight || resync {
@@ -55,22 +67,23 @@ func NewWallit(
 		w.SetDBSyncHeight(height)
 	}
 
-	log.Printf("DB height %d\n", height)
-	incomingTx, incomingBlockheight, err := w.Hook.Start(height, spvhost, wallitpath, p)
+	logger.Infof("DB height %d\n", height)
+	incomingTx, incomingBlockheight, err := w.Hook.Start(height, spvhost, headerpath, p)
 	if err != nil {
-		log.Printf("NewWallit Hook.Start crash  %s ", err.Error())
+		logger.Errorf("NewWallit Hook.Start crash  %s ", err.Error())
 	}
+	w.Notes = chainwatch.New(w.Hook, height)
 
 	// check if there are any addresses.  If there aren't (initial wallet setup)
 	// then make an address.
 	adrs, err := w.AdrDump()
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		logger.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	if len(adrs) == 0 {
 		_, err := w.NewAdr()
 		if err != nil {
-			log.Printf("NewWallit crash  %s ", err.Error())
+			logger.Errorf("NewWallit crash  %s ", err.Error())
 		}
 	}
 
@@ -78,19 +91,19 @@ func NewWallit(
 	for _, a := range adrs {
 		err = w.Hook.RegisterAddress(a)
 		if err != nil {
-			log.Printf("NewWallit RegisterAddress crash %s ", err.Error())
+			logger.Errorf("NewWallit RegisterAddress crash %s ", err.Error())
 		}
 	}
 
 	// send outpoints (if any) to the hook
 	utxos, err := w.UtxoDump()
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		logger.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	for _, utxo := range utxos {
 		err = w.Hook.RegisterOutPoint(utxo.Op)
 		if err != nil {
-			log.Printf("NewWallit crash  %s ", err.Error())
+			logger.Errorf("NewWallit crash  %s ", err.Error())
 		}
 	}
 
@@ -100,14 +113,27 @@ func NewWallit(
 	// deal with incoming height
 	go w.HeightHandler(incomingBlockheight)
 
+	// keep sending our txs until they confirm
+	go w.Rebroadcaster()
+
+	// keep the heir tx, if there is one, current
+	go w.HeirWatcher()
+
 	return &w
 }
 
 func (w *Wallit) TxHandler(incomingTxAndHeight chan lnutil.TxAndHeight) {
 	for {
 		txah := <-incomingTxAndHeight
+		txid := txah.Tx.TxHash()
+		w.txPosMtx.Lock()
+		w.txPos[txid] = txah.TxIdx
+		w.txPosMtx.Unlock()
 		w.Ingest(txah.Tx, txah.Height)
-		log.Printf("got tx %s at height %d\n",
+		w.txPosMtx.Lock()
+		delete(w.txPos, txid)
+		w.txPosMtx.Unlock()
+		logger.Infof("got tx %s at height %d\n",
 			txah.Tx.TxHash().String(), txah.Height)
 	}
 }
@@ -118,21 +144,28 @@ func (w *Wallit) HeightHandler(incomingHeight chan int32) {
 		h := <-incomingHeight
 		// detect reorg
 		if h < prevHeight {
-			log.Printf("HeightHandler: oh no, reorg!\n")
+			logger.Warnf("HeightHandler: oh no, reorg!\n")
 			err := w.RollBack(h)
 			if err != nil {
-				log.Printf("Rollback crash  %s ", err.Error())
+				logger.Errorf("Rollback crash  %s ", err.Error())
 			}
 		}
 
 		err := w.SetDBSyncHeight(h)
 		if err != nil {
-			log.Printf("HeightHandler crash  %s ", err.Error())
+			logger.Errorf("HeightHandler crash  %s ", err.Error())
 		}
+		w.Notes.SetHeight(h)
 		prevHeight = h
 	}
 }
 
+// wallitDBMigrations update the wallet DB to the current schema; see
+// package migrate.  Append only.
+var wallitDBMigrations = []migrate.Migration{
+	addTxoStates, // 0 to 1
+}
+
 // OpenDB starts up the database.  Creates the file if it doesn't exist.
 func (w *Wallit) OpenDB(filename string) error {
 	var err error
@@ -159,6 +192,18 @@ func (w *Wallit) OpenDB(filename string) error {
 		if err != nil {
 			return err
 		}
+		_, err = btx.CreateBucketIfNotExists(BKTLocked)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTBroadcast)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTHeir)
+		if err != nil {
+			return err
+		}
 
 		sta, err := btx.CreateBucketIfNotExists(BKTState)
 		if err != nil {
@@ -168,9 +213,9 @@ func (w *Wallit) OpenDB(filename string) error {
 		numKeysBytes := sta.Get(KEYNumKeys)
 		if numKeysBytes != nil { // NumKeys exists, read into uint32
 			numKeys = lnutil.BtU32(numKeysBytes)
-			log.Printf("db says %d keys\n", numKeys)
+			logger.Infof("db says %d keys\n", numKeys)
 		} else { // no adrs yet, make it 0.  Then make an address.
-			log.Printf("NumKeys not in DB, must be new DB. 0 Keys\n")
+			logger.Infof("NumKeys not in DB, must be new DB. 0 Keys\n")
 			numKeys = 0
 			b0 := lnutil.U32tB(numKeys)
 			err = sta.Put(KEYNumKeys, b0)
@@ -185,5 +230,5 @@ func (w *Wallit) OpenDB(filename string) error {
 		return err
 	}
 
-	return nil
+	return migrate.Run(w.StateDB, wallitDBMigrations)
 }
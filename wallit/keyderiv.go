@@ -11,6 +11,7 @@ import (
 /*
 Key derivation for a TxStore has 3 levels: use case, peer index, and keyindex.
 Regular wallet addresses are use 0, peer 0, and then a linear index.
+Addresses in other accounts are use 0, with the account where the peer is.
 The identity key is use 11, peer 0, index 0.
 Channel multisig keys are use 2, peer and index per peer and channel.
 Channel refund keys are use 3, peer and index per peer / channel.
@@ -73,12 +74,19 @@ func (w *Wallit) GetWalletPrivkey(idx uint32) *btcec.PrivateKey {
 
 // GetWalletKeygen returns the keygen for a standard wallet address
 func GetWalletKeygen(idx, cointype uint32) portxo.KeyGen {
+	return GetAccountKeygen(idx, cointype, 0)
+}
+
+// GetAccountKeygen returns the keygen for a wallet address in an account.
+// The account's the level after the use, where channel keys have the peer;
+// peers start at 1, so account 0 is the standard wallet.
+func GetAccountKeygen(idx, cointype, account uint32) portxo.KeyGen {
 	var kg portxo.KeyGen
 	kg.Depth = 5
 	kg.Step[0] = 44 | 1<<31
 	kg.Step[1] = cointype | 1<<31
 	kg.Step[2] = 0 | 1<<31
-	kg.Step[3] = 0 | 1<<31
+	kg.Step[3] = account | 1<<31
 	kg.Step[4] = idx | 1<<31
 	return kg
 }
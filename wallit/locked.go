@@ -0,0 +1,108 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Time locked outputs are coins the wallet sends itself which can't be spent
+until a block height: savings, say.  Each pays a p2wsh CLTVScript to a new
+wallet key.  The script's hash is kept in BKTLocked with the key and the
+height, so Ingest knows the output as ours and saves it with its WitScript
+and LockTime.  From then on it's a utxo like any other, except that
+PickUtxos and SendOne pass over it until it's Mature, and the tx spending
+it gets a high enough nLockTime from applyLockTimes.
+
+Only this wallet's own txs make them, since the hook isn't watching the
+script; and since the heights aren't derivable from the seed, a wallet
+restored from it won't find them.
+*/
+
+// BKTLocked holds the time locked outputs we've made, by witness script
+// hash: the keygen (53 bytes) then the height (4 bytes)
+var BKTLocked = []byte("Locked")
+
+// most blocks ahead a lock can be, about 20 years
+const maxLockBlocks = 20 * 52560
+
+// NewLockedOut makes an output of amt that can't be spent until height, and
+// remembers it so it's ours once it's in a tx
+func (w *Wallit) NewLockedOut(amt int64, height int32) (*wire.TxOut, error) {
+	cur := w.CurrentHeight()
+	if height <= cur {
+		return nil, fmt.Errorf("lock height %d isn't past the tip at %d", height, cur)
+	}
+	if height > cur+maxLockBlocks {
+		return nil, fmt.Errorf("lock height %d is over %d blocks away",
+			height, maxLockBlocks)
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	var pkScript []byte
+	err = w.StateDB.Update(func(btx *bolt.Tx) error {
+		lockb := btx.Bucket(BKTLocked)
+		if lockb == nil {
+			return fmt.Errorf("no locked bucket")
+		}
+		kgb := btx.Bucket(BKTadr).Get(adr160[:])
+		if len(kgb) != 53 {
+			return fmt.Errorf("no keygen for new address %x", adr160)
+		}
+		var kga [53]byte
+		copy(kga[:], kgb)
+		pkScript = lnutil.P2WSHify(w.lockScript(portxo.KeyGenFromBytes(kga),
+			uint32(height)))
+		v := append(append([]byte{}, kgb...), lnutil.I32tB(height)...)
+		return lockb.Put(lnutil.KeyHashFromPkScript(pkScript), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("locked output %x until height %d\n", pkScript, height)
+	return wire.NewTxOut(amt, pkScript), nil
+}
+
+// lockScript is the witness script locking kg's key until locktime
+func (w *Wallit) lockScript(kg portxo.KeyGen, locktime uint32) []byte {
+	var pub [33]byte
+	copy(pub[:], w.PathPubkey(kg).SerializeCompressed())
+	return lnutil.CLTVScript(pub, locktime)
+}
+
+// lockedTxoBytes gives the serialized portxo for a tx output if it's one
+// of our time locked outputs, nil if it isn't
+func (w *Wallit) lockedTxoBytes(btx *bolt.Tx,
+	tx *wire.MsgTx, idx uint32, height int32) ([]byte, error) {
+
+	lockb := btx.Bucket(BKTLocked)
+	hash := lnutil.KeyHashFromPkScript(tx.TxOut[idx].PkScript)
+	if lockb == nil || len(hash) != 32 {
+		return nil, nil
+	}
+	v := lockb.Get(hash)
+	if v == nil {
+		return nil, nil
+	}
+	if len(v) != 57 {
+		return nil, fmt.Errorf("locked output %x is %d bytes, expect 57",
+			hash, len(v))
+	}
+	var kga [53]byte
+	copy(kga[:], v[:53])
+	u, err := NewPorTxo(tx, idx, height, portxo.KeyGenFromBytes(kga))
+	if err != nil {
+		return nil, err
+	}
+	u.LockTime = uint32(lnutil.BtI32(v[53:]))
+	u.Hint = portxo.SpendHintCLTV
+	u.WitScript = w.lockScript(u.KeyGen, u.LockTime)
+	return u.Bytes()
+}
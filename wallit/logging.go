@@ -0,0 +1,6 @@
+package wallit
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the wallit subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("wallit")
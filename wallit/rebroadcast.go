@@ -0,0 +1,424 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Every tx the wallet sends out, whether a send, a channel funding or close,
+a sweep or justice it takes itself, goes in the broadcast pool until it's
+buried.  Rebroadcaster announces the unconfirmed ones again every
+rebroadcastInterval: peers restart, full mempools drop low fee txs, and
+the SPV hook only remembers what it's sent since lit started.  The pool's
+in the wallet DB, so it lasts through restarts.
+
+A tx in a block is kept broadcastKeepConfs, so if a reorg takes it out
+it's sent again (see RollBack), then dropped.
+
+Over SPV there's no asking a peer what's in its mempool, but a peer asking
+for a tx it's already been told about doesn't have it.  A tx peers ask for
+again after broadcastEvictMisses rebroadcasts in a row, or that's gone
+broadcastEvictAge without a block (when Core expires it), is marked
+evicted.  It's still sent; if its fee is the trouble, replace it.
+
+A tx spending any of the same inputs showing up in a block marks it double
+spent, and it isn't sent any more.  Sending a tx that spends the same
+inputs as one in the pool, like a fee bump, drops the old one quietly.
+The inputs of pool txs are registered with the hook, so their spends are
+seen even when they're not the wallet's, like channel outputs.
+*/
+
+// BKTBroadcast is the broadcast pool.  k: txid, v: state (1), first sent
+// (8), last sent (8), sends (4), misses (4), height (4), conflicting txid
+// (32), then the tx.
+var BKTBroadcast = []byte("Broadcast")
+
+const (
+	rebroadcastInterval  = 30 * time.Minute
+	broadcastKeepConfs   = 6
+	broadcastEvictMisses = 3
+	broadcastEvictAge    = 14 * 24 * time.Hour
+
+	ownTxHeaderLen = 61
+)
+
+func ownTxBytes(ot lnutil.OwnTx, tx *wire.MsgTx) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(ot.State))
+	buf.Write(lnutil.I64tB(ot.FirstSent))
+	buf.Write(lnutil.I64tB(ot.LastSent))
+	buf.Write(lnutil.U32tB(ot.Sends))
+	buf.Write(lnutil.U32tB(ot.Misses))
+	buf.Write(lnutil.I32tB(ot.Height))
+	buf.Write(ot.Conflict[:])
+	err := tx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func ownTxFromBytes(k, v []byte) (lnutil.OwnTx, *wire.MsgTx, error) {
+	var ot lnutil.OwnTx
+	if len(k) != 32 || len(v) < ownTxHeaderLen {
+		return ot, nil, fmt.Errorf("broadcast %x : %x wrong size", k, v)
+	}
+	copy(ot.Txid[:], k)
+	ot.State = lnutil.BroadcastState(v[0])
+	ot.FirstSent = lnutil.BtI64(v[1:9])
+	ot.LastSent = lnutil.BtI64(v[9:17])
+	ot.Sends = lnutil.BtU32(v[17:21])
+	ot.Misses = lnutil.BtU32(v[21:25])
+	ot.Height = lnutil.BtI32(v[25:29])
+	copy(ot.Conflict[:], v[29:61])
+	tx := wire.NewMsgTx()
+	err := tx.Deserialize(bytes.NewReader(v[ownTxHeaderLen:]))
+	if err != nil {
+		return ot, nil, err
+	}
+	return ot, tx, nil
+}
+
+// unconfirmed says if a pool tx is still being sent
+func unconfirmed(ot lnutil.OwnTx) bool {
+	return ot.State == lnutil.BroadcastPending ||
+		ot.State == lnutil.BroadcastEvicted
+}
+
+// conflicts says if two txs spend any of the same outpoints
+func conflicts(a, b *wire.MsgTx) bool {
+	for _, ain := range a.TxIn {
+		for _, bin := range b.TxIn {
+			if ain.PreviousOutPoint == bin.PreviousOutPoint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// broadcast sends tx out through the hook and puts it in the pool
+func (w *Wallit) broadcast(tx *wire.MsgTx) error {
+	err := w.Hook.PushTx(tx)
+	if err != nil {
+		return err
+	}
+	w.watchInputs(tx)
+	err = w.poolTx(tx)
+	if err != nil {
+		// it's out; it just won't be sent again
+		logger.Errorf("broadcast pool: %s\n", err.Error())
+	}
+	return nil
+}
+
+// poolTx records a send of tx, dropping txs it replaces
+func (w *Wallit) poolTx(tx *wire.MsgTx) error {
+	txid := tx.TxHash()
+	now := time.Now().Unix()
+	return w.StateDB.Update(func(btx *bolt.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		ot := lnutil.OwnTx{Txid: txid, FirstSent: now}
+		var replaced [][]byte
+		err := bcb.ForEach(func(k, v []byte) error {
+			old, oldTx, err := ownTxFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			if old.Txid == txid {
+				ot = old
+			} else if unconfirmed(old) && conflicts(tx, oldTx) {
+				replaced = append(replaced, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range replaced {
+			var oldTxid chainhash.Hash
+			copy(oldTxid[:], k)
+			logger.Infof("%s replaces %s, no longer sending it\n",
+				txid.String(), oldTxid.String())
+			err = bcb.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		ot.State = lnutil.BroadcastPending
+		ot.LastSent = now
+		ot.Sends++
+		b, err := ownTxBytes(ot, tx)
+		if err != nil {
+			return err
+		}
+		return bcb.Put(txid[:], b)
+	})
+}
+
+// watchInputs has the hook tell us about spends of what tx spends
+func (w *Wallit) watchInputs(tx *wire.MsgTx) {
+	for _, in := range tx.TxIn {
+		err := w.Hook.RegisterOutPoint(in.PreviousOutPoint)
+		if err != nil {
+			logger.Warnf("watch %s: %s\n",
+				in.PreviousOutPoint.String(), err.Error())
+		}
+	}
+}
+
+// broadcastSeen moves pool txs along for txs the hook's seen in a block
+func (w *Wallit) broadcastSeen(
+	txs []*wire.MsgTx, txids []*chainhash.Hash, height int32) error {
+	if height < 1 {
+		return nil
+	}
+	return w.StateDB.Update(func(btx *bolt.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		var keys, vals [][]byte
+		err := bcb.ForEach(func(k, v []byte) error {
+			ot, otx, err := ownTxFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			if !unconfirmed(ot) {
+				return nil
+			}
+			for i, tx := range txs {
+				if *txids[i] == ot.Txid {
+					ot.State = lnutil.BroadcastConfirmed
+				} else if conflicts(tx, otx) {
+					logger.Warnf("%s double spent by %s at height %d\n",
+						ot.Txid.String(), txids[i].String(), height)
+					ot.State = lnutil.BroadcastDoubleSpent
+					ot.Conflict = *txids[i]
+				} else {
+					continue
+				}
+				ot.Height = height
+				b, err := ownTxBytes(ot, otx)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte{}, k...))
+				vals = append(vals, b)
+				break
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			err = bcb.Put(k, vals[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unconfirmBroadcasts puts pool txs in blocks over height back to pending,
+// for a reorg.  A double spend that's reorged out may not come back, so
+// those are tried again too.
+func unconfirmBroadcasts(btx *bolt.Tx, height int32) error {
+	bcb := btx.Bucket(BKTBroadcast)
+	var keys, vals [][]byte
+	err := bcb.ForEach(func(k, v []byte) error {
+		ot, otx, err := ownTxFromBytes(k, v)
+		if err != nil {
+			return err
+		}
+		if unconfirmed(ot) || ot.Height <= height {
+			return nil
+		}
+		ot.State = lnutil.BroadcastPending
+		ot.Height = 0
+		ot.Conflict = chainhash.Hash{}
+		b, err := ownTxBytes(ot, otx)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte{}, k...))
+		vals = append(vals, b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		err = bcb.Put(k, vals[i])
+		if err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		logger.Infof("%d sent txs back to pending\n", len(keys))
+	}
+	return nil
+}
+
+// txRequested notes a peer asking for a tx it's been told about before
+func (w *Wallit) txRequested(txid chainhash.Hash) {
+	var again bool
+	w.StateDB.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(BKTBroadcast).Get(txid[:])
+		if v == nil {
+			return nil
+		}
+		ot, _, err := ownTxFromBytes(txid[:], v)
+		again = err == nil && ot.Sends > 1
+		return nil
+	})
+	if !again {
+		return
+	}
+	w.bcastMtx.Lock()
+	w.bcastAsked[txid] = true
+	w.bcastMtx.Unlock()
+}
+
+// Rebroadcaster announces the pool's unconfirmed txs again every
+// rebroadcastInterval, and drops the buried ones.  Runs forever.
+func (w *Wallit) Rebroadcaster() {
+	// after a restart the hook doesn't know what they spend
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(BKTBroadcast).ForEach(func(k, v []byte) error {
+			ot, tx, err := ownTxFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			if unconfirmed(ot) {
+				w.watchInputs(tx)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Errorf("Rebroadcaster: %s\n", err.Error())
+	}
+	for {
+		time.Sleep(rebroadcastInterval)
+		err = w.rebroadcast()
+		if err != nil {
+			logger.Errorf("rebroadcast: %s\n", err.Error())
+		}
+	}
+}
+
+// rebroadcast does one round of Rebroadcaster's
+func (w *Wallit) rebroadcast() error {
+	height := w.CurrentHeight()
+	now := time.Now()
+	w.bcastMtx.Lock()
+	asked := w.bcastAsked
+	w.bcastAsked = make(map[chainhash.Hash]bool)
+	w.bcastMtx.Unlock()
+
+	var resend []*wire.MsgTx
+	err := w.StateDB.Update(func(btx *bolt.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		var kill, keys, vals [][]byte
+		err := bcb.ForEach(func(k, v []byte) error {
+			ot, tx, err := ownTxFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			if !unconfirmed(ot) {
+				if height-ot.Height+1 >= broadcastKeepConfs {
+					kill = append(kill, append([]byte{}, k...))
+				}
+				return nil
+			}
+			if asked[ot.Txid] {
+				ot.Misses++
+			} else {
+				ot.Misses = 0
+			}
+			state := lnutil.BroadcastPending
+			if ot.Misses >= broadcastEvictMisses ||
+				now.Sub(time.Unix(ot.FirstSent, 0)) > broadcastEvictAge {
+				state = lnutil.BroadcastEvicted
+			}
+			if state != ot.State {
+				logger.Warnf("sent tx %s now %s\n", ot.Txid.String(), state)
+			}
+			ot.State = state
+			ot.LastSent = now.Unix()
+			ot.Sends++
+			b, err := ownTxBytes(ot, tx)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte{}, k...))
+			vals = append(vals, b)
+			resend = append(resend, tx)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range kill {
+			err = bcb.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		for i, k := range keys {
+			err = bcb.Put(k, vals[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, tx := range resend {
+		err = w.Hook.PushTx(tx)
+		if err != nil {
+			logger.Warnf("rebroadcast %s: %s\n",
+				tx.TxHash().String(), err.Error())
+		}
+	}
+	if len(resend) > 0 {
+		logger.Infof("rebroadcast %d txs\n", len(resend))
+	}
+	return nil
+}
+
+// Broadcasts lists the txs the wallet's sent that aren't buried yet
+func (w *Wallit) Broadcasts() ([]lnutil.OwnTx, error) {
+	var ots []lnutil.OwnTx
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(BKTBroadcast).ForEach(func(k, v []byte) error {
+			ot, _, err := ownTxFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			ots = append(ots, ot)
+			return nil
+		})
+	})
+	return ots, err
+}
+
+// DropBroadcast takes a tx out of the pool, so it's not sent again
+func (w *Wallit) DropBroadcast(txid *chainhash.Hash) error {
+	return w.StateDB.Update(func(btx *bolt.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		if bcb.Get(txid[:]) == nil {
+			return fmt.Errorf("tx %s isn't in the broadcast pool", txid.String())
+		}
+		return bcb.Delete(txid[:])
+	})
+}
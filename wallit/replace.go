@@ -0,0 +1,200 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+A tx the wallet sent can be replaced while it's unconfirmed.  MaybeReplace
+takes a higher fee out of the change and keeps every other output as it
+was; DoubleSpend sends all of it back to the wallet instead.  Either way
+the inputs are the same, so only one of the txs can confirm.
+
+The replacement pays the old fee plus a sat per byte of its own, as BIP 125
+asks.  Txs lit makes don't signal replaceability, so replacements only get
+through nodes doing full RBF, which Core does by default since 28.
+*/
+
+// sentTx is an unconfirmed tx the wallet sent, and what it spent
+type sentTx struct {
+	tx     *wire.MsgTx
+	ins    []*portxo.PorTxo
+	fee    int64
+	change int // index of the output that's the wallet's, -1 if none
+}
+
+// getSentTx finds what an unconfirmed tx of ours spent and where its change
+// went.  Errors if it's confirmed, replaced, or its change is spent, since
+// replacing it then would take the spending tx out too.  Call with
+// FreezeMutex held.
+func (w *Wallit) getSentTx(txid *chainhash.Hash) (*sentTx, error) {
+	tx, err := w.GetTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	st := &sentTx{tx: tx, change: -1}
+	err = w.StateDB.View(func(btx *bolt.Tx) error {
+		dufb := btx.Bucket(BKToutpoint)
+		old := btx.Bucket(BKTStxos)
+		for _, in := range tx.TxIn {
+			opArr := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			v := old.Get(opArr[:])
+			if v == nil {
+				return fmt.Errorf("tx %s input %s isn't the wallet's",
+					txid.String(), in.PreviousOutPoint.String())
+			}
+			stxo, err := StxoFromBytes(append(opArr[:], v...))
+			if err != nil {
+				return err
+			}
+			if !stxo.SpendTxid.IsEqual(txid) {
+				return fmt.Errorf("tx %s already replaced by %s",
+					txid.String(), stxo.SpendTxid.String())
+			}
+			if stxo.SpendHeight != 0 {
+				return fmt.Errorf("tx %s confirmed at height %d",
+					txid.String(), stxo.SpendHeight)
+			}
+			u := stxo.PorTxo
+			st.ins = append(st.ins, &u)
+			st.fee += u.Value
+		}
+		for i, out := range tx.TxOut {
+			st.fee -= out.Value
+			opArr := lnutil.OutPointToBytes(*wire.NewOutPoint(txid, uint32(i)))
+			if old.Get(opArr[:]) != nil {
+				return fmt.Errorf("tx %s output %d already spent", txid.String(), i)
+			}
+			// watch only outpoints, like channels, have no value stored
+			if len(dufb.Get(opArr[:])) == 0 {
+				continue
+			}
+			if st.change != -1 {
+				return fmt.Errorf("tx %s has more than one output to the wallet",
+					txid.String())
+			}
+			st.change = i
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// replaceFee is the fee for a replacement paying feePerByte, raised to what
+// BIP 125 needs to replace a tx paying oldFee
+func replaceFee(ins []*portxo.PorTxo, outs []*wire.TxOut,
+	feePerByte, oldFee int64) int64 {
+	fee := EstFee(ins, outs, feePerByte)
+	if min := oldFee + EstFee(ins, outs, 1); fee < min {
+		fee = min
+	}
+	return fee
+}
+
+// MaybeReplace builds, but doesn't sign, a tx replacing the unconfirmed tx
+// txid at feePerByte, paying the extra fee from its change.  The other
+// outputs stay the same.  Like MaybeSend's, it's sent with ReallySend or
+// dropped with NahDontSend.
+func (w *Wallit) MaybeReplace(
+	txid *chainhash.Hash, feePerByte int64) (*wire.MsgTx, error) {
+	dustCutoff := int64(20000) // same as MaybeSend
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	st, err := w.getSentTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	if st.change == -1 {
+		return nil, fmt.Errorf("tx %s has no change to pay more fee from",
+			txid.String())
+	}
+
+	fTx := new(FrozenTx)
+	fTx.Ins = st.ins
+	for i, out := range st.tx.TxOut {
+		if i != st.change {
+			fTx.Outs = append(fTx.Outs, wire.NewTxOut(out.Value, out.PkScript))
+		}
+	}
+	// EstFee counts a change output whether there is one or not
+	fee := replaceFee(st.ins, fTx.Outs, feePerByte, st.fee)
+	changeAmt := st.tx.TxOut[st.change].Value + st.fee - fee
+	if changeAmt < 0 {
+		return nil, fmt.Errorf("change of %d can't pay fee %d (paying %d now)",
+			st.tx.TxOut[st.change].Value, fee, st.fee)
+	}
+	txos := fTx.Outs
+	if changeAmt > dustCutoff {
+		fTx.ChangeOut = wire.NewTxOut(
+			changeAmt, st.tx.TxOut[st.change].PkScript)
+		txos = append(txos, fTx.ChangeOut)
+	}
+	logger.Infof("replacing %s, fee %d to %d\n", txid.String(), st.fee, fee)
+
+	tx, err := w.BuildDontSign(st.ins, txos)
+	if err != nil {
+		return nil, err
+	}
+	fTx.Nlock = tx.LockTime
+	fTx.Txid = tx.TxHash()
+	for _, u := range st.ins {
+		w.FreezeSet[u.Op] = fTx
+	}
+	return tx, nil
+}
+
+// DoubleSpend sends everything the unconfirmed tx txid spent back to the
+// wallet at feePerByte, so that tx can't confirm.  A replacement from
+// MaybeReplace that's waiting is dropped.  Returns the new txid.
+func (w *Wallit) DoubleSpend(
+	txid *chainhash.Hash, feePerByte int64) (*chainhash.Hash, error) {
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	st, err := w.getSentTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	out := wire.NewTxOut(0, lnutil.DirectWPKHScriptFromPKH(adr160))
+	var inSum int64
+	for _, u := range st.ins {
+		inSum += u.Value
+	}
+	fee := replaceFee(st.ins, []*wire.TxOut{out}, feePerByte, st.fee)
+	out.Value = inSum - fee
+	if out.Value < 1000 {
+		return nil, fmt.Errorf("inputs of %d can't pay fee %d", inSum, fee)
+	}
+
+	tx, err := w.BuildAndSign(
+		st.ins, []*wire.TxOut{out}, uint32(w.CurrentHeight()))
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range st.ins {
+		delete(w.FreezeSet, u.Op)
+	}
+	logger.Infof("double spending %s with %s, fee %d to %d\n",
+		txid.String(), tx.TxHash().String(), st.fee, fee)
+	err = w.NewOutgoingTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	newTxid := tx.TxHash()
+	return &newTxid, nil
+}
@@ -3,7 +3,6 @@ package wallit
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"sort"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
@@ -56,7 +55,7 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 	// estimate needed fee with outputs, see if change should be truncated
 	fee := EstFee(utxos, txos, feePerByte)
 
-	log.Printf("MaybeSend has fee %d, %d inputs\n", fee, len(utxos))
+	logger.Infof("MaybeSend has fee %d, %d inputs\n", fee, len(utxos))
 
 	// input sum is not enough, we need more inputs.
 	// keep doing this until fee is sufficient or PickUtxos errors out
@@ -118,7 +117,7 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 // Sign and broadcast a tx previously built with MaybeSend.  This clears the freeze
 // on the utxos but they're not utxos anymore anyway.
 func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
-	log.Printf("Reallysend %s\n", txid.String())
+	logger.Infof("Reallysend %s\n", txid.String())
 	// start frozen set access
 	w.FreezeMutex.Lock()
 	defer w.FreezeMutex.Unlock()
@@ -129,7 +128,7 @@ func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
 	}
 	// delete inputs from frozen set (they're gone anyway, but just to clean it up)
 	for _, txin := range frozenTx.Ins {
-		log.Printf("\t remove %s from frozen outpoints\n", txin.Op.String())
+		logger.Infof("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
 
@@ -150,7 +149,7 @@ func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
 // Cancel the hold on a tx previously built with MaybeSend.  Clears freeze on
 // utxos so they can be used somewhere else.
 func (w *Wallit) NahDontSend(txid *chainhash.Hash) error {
-	log.Printf("Nahdontsend %s\n", txid.String())
+	logger.Infof("Nahdontsend %s\n", txid.String())
 	// start frozen set access
 	w.FreezeMutex.Lock()
 	defer w.FreezeMutex.Unlock()
@@ -161,12 +160,75 @@ func (w *Wallit) NahDontSend(txid *chainhash.Hash) error {
 	}
 	// go through all its inputs, and remove those outpoints from the frozen set
 	for _, txin := range frozenTx.Ins {
-		log.Printf("\t remove %s from frozen outpoints\n", txin.Op.String())
+		logger.Infof("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
 	return nil
 }
 
+// SwapFrozenOuts rebuilds a tx from MaybeSend with txos in place of the
+// outputs it was made for, keeping its inputs, change and freeze.  So coins
+// can be held for a tx before its outputs are known.  txos have to add up
+// to the same and be no bigger, so the fee still covers it.  Returns where
+// they ended up, as MaybeSend does; the old txid's gone.
+func (w *Wallit) SwapFrozenOuts(
+	txid *chainhash.Hash, txos []*wire.TxOut) ([]*wire.OutPoint, error) {
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+	frozenTx, err := w.FindFreezeTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	var oldAmt, newAmt int64
+	var oldSize, newSize int
+	for _, txo := range frozenTx.Outs {
+		oldAmt += txo.Value
+		oldSize += txo.SerializeSize()
+	}
+	for _, txo := range txos {
+		newAmt += txo.Value
+		newSize += txo.SerializeSize()
+	}
+	if newAmt != oldAmt {
+		return nil, fmt.Errorf("outputs add up to %d, frozen for %d",
+			newAmt, oldAmt)
+	}
+	if newSize > oldSize {
+		return nil, fmt.Errorf("outputs %d bytes, fee paid for %d",
+			newSize, oldSize)
+	}
+
+	fTx := new(FrozenTx)
+	fTx.Ins = frozenTx.Ins
+	fTx.Outs = txos
+	fTx.ChangeOut = frozenTx.ChangeOut
+	allOuts := make([]*wire.TxOut, len(txos))
+	copy(allOuts, txos)
+	if fTx.ChangeOut != nil {
+		allOuts = append(allOuts, fTx.ChangeOut)
+	}
+	tx, err := w.BuildDontSign(fTx.Ins, allOuts)
+	if err != nil {
+		return nil, err
+	}
+	fTx.Nlock = tx.LockTime
+	fTx.Txid = tx.TxHash()
+	for _, utxo := range fTx.Ins {
+		w.FreezeSet[utxo.Op] = fTx
+	}
+
+	ops := make([]*wire.OutPoint, len(txos))
+	for i, txo := range txos {
+		for j, out := range tx.TxOut {
+			if bytes.Equal(txo.PkScript, out.PkScript) {
+				ops[i] = wire.NewOutPoint(&fTx.Txid, uint32(j))
+			}
+		}
+	}
+	return ops, nil
+}
+
 // FindFreezeTx looks through the frozen map to find a tx.  Error if it can't find it
 func (w *Wallit) FindFreezeTx(txid *chainhash.Hash) (*FrozenTx, error) {
 	for op := range w.FreezeSet {
@@ -190,7 +252,7 @@ func (w *Wallit) GrabAll() error {
 	nothin := true
 	for _, u := range utxos {
 		if u.Seq == 1 && u.Height > 0 { // grabbable
-			log.Printf("found %s to grab!\n", u.String())
+			logger.Infof("found %s to grab!\n", u.String())
 			adr160, err := w.NewAdr160()
 			if err != nil {
 				return err
@@ -198,7 +260,7 @@ func (w *Wallit) GrabAll() error {
 
 			outScript := lnutil.DirectWPKHScriptFromPKH(adr160)
 
-			tx, err := w.SendOne(*u, outScript)
+			tx, err := w.SendOne(*u, outScript, w.FeeRate)
 			if err != nil {
 				return err
 			}
@@ -210,7 +272,7 @@ func (w *Wallit) GrabAll() error {
 		}
 	}
 	if nothin {
-		log.Printf("Nothing to grab\n")
+		logger.Infof("Nothing to grab\n")
 	}
 	return nil
 }
@@ -218,7 +280,7 @@ func (w *Wallit) GrabAll() error {
 // Directly send out a tx.  For things that plug in to the uspv wallet.
 func (w *Wallit) DirectSendTx(tx *wire.MsgTx) error {
 	// don't ingest, just push out
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 // NewOutgoingTx runs a tx though the db first, then sends it out to the network.
@@ -227,7 +289,7 @@ func (w *Wallit) NewOutgoingTx(tx *wire.MsgTx) error {
 	if err != nil {
 		return err
 	}
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 // PickUtxos Picks Utxos for spending.  Tell it how much money you want.
@@ -280,10 +342,15 @@ func (w *Wallit) PickUtxos(
 		//		if utxo.AtHeight == 0 {
 		//			continue
 		//		}
-		if utxo.Seq > 1 &&
-			(utxo.Height < 100 || utxo.Height+int32(utxo.Seq) > curHeight) {
+		if !utxo.Mature(curHeight) {
 			continue // skip immature or unconfirmed time-locked sh outputs
 		}
+		if !w.confirmed(utxo, curHeight) {
+			continue // not enough confirmations for the amount
+		}
+		if utxo.Height == 0 && w.reorged(utxo) && !w.ownTx(utxo.Op) {
+			continue // a reorg took out the tx paying us; wait for it again
+		}
 		if ow && utxo.Mode&portxo.FlagTxoWitness == 0 {
 			continue // skip non-witness
 		}
@@ -321,7 +388,8 @@ func (w *Wallit) PickUtxos(
 
 // SendOne is for the sweep function, and doesn't do change.
 // Probably can get rid of this for real txs.
-func (w *Wallit) SendOne(u portxo.PorTxo, outScript []byte) (*wire.MsgTx, error) {
+func (w *Wallit) SendOne(
+	u portxo.PorTxo, outScript []byte, feePerByte int64) (*wire.MsgTx, error) {
 
 	w.FreezeMutex.Lock()
 	defer w.FreezeMutex.Unlock()
@@ -335,18 +403,18 @@ func (w *Wallit) SendOne(u portxo.PorTxo, outScript []byte) (*wire.MsgTx, error)
 		return nil, err
 	}
 
-	if u.Seq > 1 &&
-		(u.Height < 100 || u.Height+int32(u.Seq) > curHeight) {
+	if !u.Mature(curHeight) {
 		// skip immature or unconfirmed time-locked sh outputs
 		return nil, fmt.Errorf("Can't spend, immature")
 	}
-	// fixed fee
-	fee := w.FeeRate * 200
-
-	sendAmt := u.Value - fee
-
 	// make user specified txout and add to tx
-	txout := wire.NewTxOut(sendAmt, outScript)
+	txout := wire.NewTxOut(0, outScript)
+	fee := EstFee([]*portxo.PorTxo{&u}, []*wire.TxOut{txout}, feePerByte)
+	txout.Value = u.Value - fee
+	if txout.Value < 1000 {
+		return nil, fmt.Errorf("%s of %d can't pay fee %d",
+			u.Op.String(), u.Value, fee)
+	}
 
 	return w.BuildAndSign(
 		[]*portxo.PorTxo{&u}, []*wire.TxOut{txout}, uint32(w.CurrentHeight()))
@@ -375,11 +443,47 @@ func (w *Wallit) BuildDontSign(
 			tx.TxIn[i].Sequence = u.Seq
 		}
 	}
+	err := applyLockTimes(tx, utxos)
+	if err != nil {
+		return nil, err
+	}
 	// sort in place before signing
 	txsort.InPlaceSort(tx)
 	return tx, nil
 }
 
+// applyLockTimes raises the tx locktime to cover any inputs which need one,
+// and makes sure those inputs aren't final so the locktime counts.  Inputs
+// are in the same order as utxos.  Txs without such inputs are left alone,
+// so their txids don't change.
+func applyLockTimes(tx *wire.MsgTx, utxos []*portxo.PorTxo) error {
+	var need uint32
+	for i, u := range utxos {
+		if u.LockTime == 0 {
+			continue
+		}
+		// can't mix heights and times
+		if need != 0 && (u.LockTime < 500000000) != (need < 500000000) {
+			return fmt.Errorf("%s needs locktime %d, other input needs %d",
+				u.Op.String(), u.LockTime, need)
+		}
+		if u.LockTime > need {
+			need = u.LockTime
+		}
+		if tx.TxIn[i].Sequence == wire.MaxTxInSequenceNum {
+			tx.TxIn[i].Sequence = wire.MaxTxInSequenceNum - 1
+		}
+	}
+	if need == 0 {
+		return nil
+	}
+	// the current height locktime can stay if it's the same kind and enough
+	if (tx.LockTime < 500000000) != (need < 500000000) || tx.LockTime < need {
+		tx.LockTime = need
+	}
+	return nil
+}
+
 // Build and sign builds a tx from a slice of utxos and txOuts.
 // It then signs all the inputs and returns the tx.  Should
 // pretty much always work for any inputs.
@@ -414,6 +518,10 @@ func (w *Wallit) BuildAndSign(
 			tx.TxIn[i].Sequence = u.Seq
 		}
 	}
+	err = applyLockTimes(tx, utxos)
+	if err != nil {
+		return nil, err
+	}
 	// sort txouts in place before signing.  txins are already sorted from above
 	txsort.InPlaceSort(tx)
 
@@ -427,7 +535,7 @@ func (w *Wallit) BuildAndSign(
 	for i, _ := range tx.TxIn {
 		// get key
 		priv := w.PathPrivkey(utxos[i].KeyGen)
-		log.Printf("signing with privkey pub %x\n", priv.PubKey().SerializeCompressed())
+		logger.Infof("signing with privkey pub %x\n", priv.PubKey().SerializeCompressed())
 
 		if priv == nil {
 			return nil, fmt.Errorf("SendCoins: nil privkey")
@@ -442,15 +550,15 @@ func (w *Wallit) BuildAndSign(
 			}
 		}
 		if utxos[i].Mode == portxo.TxoP2WPKHComp { // witness PKH
-			witStash[i], err = txscript.WitnessScript(tx, hCache, i,
-				utxos[i].Value, utxos[i].PkScript, txscript.SigHashAll, priv, true)
+			witStash[i], err = lnutil.WPKHWitnessLowR(tx, hCache, i,
+				utxos[i].Value, utxos[i].PkScript, txscript.SigHashAll, priv)
 			if err != nil {
 				return nil, err
 			}
 		}
 		if utxos[i].Mode == portxo.TxoP2WSHComp { // witness script hash
-			sig, err := txscript.RawTxInWitnessSignature(tx, hCache, i,
-				utxos[i].Value, utxos[i].PkScript, txscript.SigHashAll, priv)
+			sig, err := lnutil.RawTxInWitnessSignatureLowR(tx, hCache, i,
+				utxos[i].Value, utxos[i].WitnessScript(), txscript.SigHashAll, priv)
 			if err != nil {
 				return nil, err
 			}
@@ -465,8 +573,8 @@ func (w *Wallit) BuildAndSign(
 				witStash[i][j+1] = element
 			}
 
-			// last stack item is the pkscript
-			witStash[i][len(witStash[i])-1] = utxos[i].PkScript
+			// last stack item is the script
+			witStash[i][len(witStash[i])-1] = utxos[i].WitnessScript()
 		}
 
 	}
@@ -481,7 +589,7 @@ func (w *Wallit) BuildAndSign(
 		}
 	}
 
-	log.Printf("tx: %s", TxToString(tx))
+	logger.Infof("tx: %s", TxToString(tx))
 	return tx, nil
 }
 
@@ -511,6 +619,6 @@ func EstFee(txins []*portxo.PorTxo, txouts []*wire.TxOut, spB int64) int64 {
 	for _, txout := range txouts {
 		size += 8 + int64(len(txout.PkScript))
 	}
-	log.Printf("%d spB, est vsize %d, fee %d\n", spB, size, size*spB)
+	logger.Infof("%d spB, est vsize %d, fee %d\n", spB, size, size*spB)
 	return size * spB
 }
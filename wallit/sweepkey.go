@@ -0,0 +1,265 @@
+package wallit
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+	"github.com/adiabat/btcutil/txsort"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Sweeping a key moves money sent to a private key from outside the wallet,
+like a paper wallet's, into the wallet in one tx.  Outputs paying the key
+as p2pkh, p2wpkh or p2sh-p2wpkh are swept.  A WIF key says whether its
+pubkey is compressed; a raw key could have been used either way, so both
+are looked for.  Only a compressed key has the segwit kinds.
+
+Over SPV there's no asking what a key has, so every block from the height
+given (when the key was made, or any time before its first coins) to the
+tip is fetched whole and gone through.  What pays the key and isn't spent
+in a later block is swept; unconfirmed coins aren't seen.  That's slow from
+an old height, so give as late a one as is safe.  The key isn't kept.
+*/
+
+const (
+	// how many blocks SweepKey fetches at once
+	keyScanParallel = 8
+	// logs how far along a scan is every this many blocks
+	keyScanLogEvery = 1000
+	// coinbase outputs can't be spent for this many blocks
+	coinbaseMaturity = 100
+)
+
+// blockFetcher is a chain hook that can get old blocks, like uspv's
+type blockFetcher interface {
+	FetchBlock(height int32) (*wire.MsgBlock, error)
+}
+
+// keyTxoKind is how an output pays a swept key
+type keyTxoKind uint8
+
+const (
+	keyP2PKH keyTxoKind = iota
+	keyP2WPKH
+	keyP2SHP2WPKH
+)
+
+// keyScript is one way of paying a swept key
+type keyScript struct {
+	kind       keyTxoKind
+	compressed bool
+	redeem     []byte // the p2wpkh script, for p2sh-p2wpkh
+}
+
+// vsize is about how much spending it adds to a tx
+func (ks keyScript) vsize() int64 {
+	switch {
+	case ks.kind == keyP2WPKH:
+		return 68
+	case ks.kind == keyP2SHP2WPKH:
+		return 91
+	case ks.compressed:
+		return 148
+	}
+	return 180
+}
+
+// keyTxo is an output paying a swept key
+type keyTxo struct {
+	op       wire.OutPoint
+	value    int64
+	pkScript []byte
+	keyScript
+}
+
+func pkhScript(pkh []byte) []byte {
+	b, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(pkh).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	return b
+}
+
+func shScript(script []byte) []byte {
+	b, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(script)).AddOp(txscript.OP_EQUAL).Script()
+	return b
+}
+
+// keyScripts are the scripts paying pub, for its compressed and / or
+// uncompressed forms
+func keyScripts(pub *btcec.PublicKey, comp, uncomp bool) map[string]keyScript {
+	m := make(map[string]keyScript)
+	if uncomp {
+		pkh := btcutil.Hash160(pub.SerializeUncompressed())
+		m[string(pkhScript(pkh))] = keyScript{kind: keyP2PKH}
+	}
+	if comp {
+		var pkh [20]byte
+		copy(pkh[:], btcutil.Hash160(pub.SerializeCompressed()))
+		m[string(pkhScript(pkh[:]))] = keyScript{kind: keyP2PKH, compressed: true}
+		wpkh := lnutil.DirectWPKHScriptFromPKH(pkh)
+		m[string(wpkh)] = keyScript{kind: keyP2WPKH, compressed: true}
+		m[string(shScript(wpkh))] = keyScript{
+			kind: keyP2SHP2WPKH, compressed: true, redeem: wpkh}
+	}
+	return m
+}
+
+// SweepKey sends everything paying priv, from height from on, to a new
+// address of the wallet's in one tx.  comp and uncomp say which of its
+// pubkeys to look for.  Returns the tx and the total it took in.
+func (w *Wallit) SweepKey(priv *btcec.PrivateKey, comp, uncomp bool,
+	from int32, feePerByte int64) (*wire.MsgTx, int64, error) {
+	if !comp && !uncomp {
+		return nil, 0, fmt.Errorf("SweepKey: no pubkey to look for")
+	}
+	if from < w.Param.StartHeight {
+		return nil, 0, fmt.Errorf("headers start at %d, can't look from %d",
+			w.Param.StartHeight, from)
+	}
+	tip := w.CurrentHeight()
+	if from > tip {
+		return nil, 0, fmt.Errorf("height %d is past the tip, %d", from, tip)
+	}
+	txos, err := w.findKeyTxos(keyScripts(priv.PubKey(), comp, uncomp), from, tip)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(txos) == 0 {
+		return nil, 0, fmt.Errorf("nothing pays that key from height %d", from)
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, 0, err
+	}
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.LockTime = uint32(tip)
+	var total int64
+	size := int64(42) // version, locktime, counts and the one output
+	byOp := make(map[wire.OutPoint]*keyTxo)
+	for _, kt := range txos {
+		tx.AddTxIn(wire.NewTxIn(&kt.op, nil, nil))
+		total += kt.value
+		size += kt.vsize()
+		byOp[kt.op] = kt
+	}
+	out := wire.NewTxOut(total-size*feePerByte, lnutil.DirectWPKHScriptFromPKH(adr160))
+	if out.Value < 1000 {
+		return nil, 0, fmt.Errorf("%d outputs of %d total can't pay fee %d",
+			len(txos), total, size*feePerByte)
+	}
+	tx.AddTxOut(out)
+	txsort.InPlaceSort(tx)
+
+	hCache := txscript.NewTxSigHashes(tx)
+	for i, in := range tx.TxIn {
+		kt := byOp[in.PreviousOutPoint]
+		switch kt.kind {
+		case keyP2PKH:
+			in.SignatureScript, err = txscript.SignatureScript(tx, i,
+				kt.pkScript, txscript.SigHashAll, priv, kt.compressed)
+		case keyP2WPKH:
+			in.Witness, err = lnutil.WPKHWitnessLowR(tx, hCache, i,
+				kt.value, kt.pkScript, txscript.SigHashAll, priv)
+		case keyP2SHP2WPKH:
+			// signed like p2wpkh, with the script it's hashed from
+			in.Witness, err = lnutil.WPKHWitnessLowR(tx, hCache, i,
+				kt.value, kt.redeem, txscript.SigHashAll, priv)
+			if err != nil {
+				break
+			}
+			in.SignatureScript, err = txscript.NewScriptBuilder().
+				AddData(kt.redeem).Script()
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	err = w.NewOutgoingTx(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	logger.Infof("swept %d outputs, %d sat, from a key in %s\n",
+		len(txos), total, tx.TxHash().String())
+	return tx, total, nil
+}
+
+// findKeyTxos goes through blocks from to to for unspent outputs with
+// scripts in scripts
+func (w *Wallit) findKeyTxos(
+	scripts map[string]keyScript, from, to int32) ([]*keyTxo, error) {
+	fetcher, ok := w.Hook.(blockFetcher)
+	if !ok {
+		return nil, fmt.Errorf("this chain hook can't fetch old blocks")
+	}
+	logger.Infof("looking for a key's outputs in blocks %d to %d\n", from, to)
+
+	found := make(map[wire.OutPoint]*keyTxo)
+	coinbaseAt := make(map[wire.OutPoint]int32)
+	for start := from; start <= to; start += keyScanParallel {
+		end := start + keyScanParallel - 1
+		if end > to {
+			end = to
+		}
+		blks := make([]*wire.MsgBlock, end-start+1)
+		errs := make([]error, len(blks))
+		var wg sync.WaitGroup
+		for i := range blks {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				blks[i], errs[i] = fetcher.FetchBlock(start + int32(i))
+			}(i)
+		}
+		wg.Wait()
+
+		for i, blk := range blks {
+			height := start + int32(i)
+			if errs[i] != nil {
+				return nil, fmt.Errorf("block %d: %s", height, errs[i].Error())
+			}
+			for j, tx := range blk.Transactions {
+				for _, in := range tx.TxIn {
+					delete(found, in.PreviousOutPoint)
+				}
+				txid := tx.TxHash()
+				for k, out := range tx.TxOut {
+					ks, ok := scripts[string(out.PkScript)]
+					if !ok {
+						continue
+					}
+					op := wire.NewOutPoint(&txid, uint32(k))
+					found[*op] = &keyTxo{
+						op: *op, value: out.Value, pkScript: out.PkScript,
+						keyScript: ks}
+					if j == 0 {
+						coinbaseAt[*op] = height
+					}
+				}
+			}
+			if (height-from+1)%keyScanLogEvery == 0 {
+				logger.Infof("key scan at %d of %d, %d outputs so far\n",
+					height, to, len(found))
+			}
+		}
+	}
+
+	var txos []*keyTxo
+	for op, kt := range found {
+		at, ok := coinbaseAt[op]
+		if ok && to-at+1 < coinbaseMaturity {
+			logger.Warnf("%s is an immature coinbase, leaving it\n", op.String())
+			continue
+		}
+		txos = append(txos, kt)
+	}
+	return txos, nil
+}
@@ -0,0 +1,244 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// BKTTxoState tracks each wallet output through its life: unconfirmed,
+// confirmed, reorged, spend-pending, spent.  k: outpoint, v: state (1),
+// height (4), value (8).  Utxos in the duffel bag are in one of the first
+// three states, stxos in one of the last two.
+var BKTTxoState = []byte("TxoState")
+
+func txoStateBytes(state lnutil.TxoState, height int32, value int64) []byte {
+	b := []byte{byte(state)}
+	b = append(b, lnutil.I32tB(height)...)
+	return append(b, lnutil.I64tB(value)...)
+}
+
+func txoStateFromBytes(k, v []byte) (lnutil.TxoStatus, error) {
+	var ts lnutil.TxoStatus
+	if len(k) != 36 || len(v) != 13 {
+		return ts, fmt.Errorf("txo state %x : %x wrong size", k, v)
+	}
+	var opArr [36]byte
+	copy(opArr[:], k)
+	ts.Op = *lnutil.OutPointFromBytes(opArr)
+	ts.State = lnutil.TxoState(v[0])
+	ts.Height = lnutil.BtI32(v[1:5])
+	ts.Value = lnutil.BtI64(v[5:])
+	return ts, nil
+}
+
+// setTxoState records a wallet output's state
+func setTxoState(btx *bolt.Tx, op []byte,
+	state lnutil.TxoState, height int32, value int64) error {
+	stb := btx.Bucket(BKTTxoState)
+	if stb == nil {
+		return fmt.Errorf("no txo state bucket")
+	}
+	return stb.Put(op, txoStateBytes(state, height, value))
+}
+
+// getTxoState returns an output's state, and false if it has none
+func getTxoState(btx *bolt.Tx, op []byte) (lnutil.TxoState, bool) {
+	stb := btx.Bucket(BKTTxoState)
+	if stb == nil {
+		return 0, false
+	}
+	v := stb.Get(op)
+	if len(v) != 13 {
+		return 0, false
+	}
+	return lnutil.TxoState(v[0]), true
+}
+
+// gainedState is the state for a utxo seen at height.  One a reorg took
+// out stays reorged until it's back in a block.
+func gainedState(btx *bolt.Tx, op []byte, height int32) lnutil.TxoState {
+	if height > 0 {
+		return lnutil.TxoConfirmed
+	}
+	if old, ok := getTxoState(btx, op); ok && old == lnutil.TxoReorged {
+		return lnutil.TxoReorged
+	}
+	return lnutil.TxoUnconfirmed
+}
+
+func spentState(height int32) lnutil.TxoState {
+	if height > 0 {
+		return lnutil.TxoSpent
+	}
+	return lnutil.TxoSpendPending
+}
+
+// TxoStates returns the state of every output the wallet has had
+func (w *Wallit) TxoStates() ([]lnutil.TxoStatus, error) {
+	var states []lnutil.TxoStatus
+	err := w.StateDB.View(func(btx *bolt.Tx) error {
+		stb := btx.Bucket(BKTTxoState)
+		if stb == nil {
+			return fmt.Errorf("no txo state bucket")
+		}
+		return stb.ForEach(func(k, v []byte) error {
+			ts, err := txoStateFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			states = append(states, ts)
+			return nil
+		})
+	})
+	return states, err
+}
+
+// reorged says if a utxo's tx was taken out of the chain by a reorg and
+// hasn't come back
+func (w *Wallit) reorged(u *portxo.PorTxo) bool {
+	opArr := lnutil.OutPointToBytes(u.Op)
+	var state lnutil.TxoState
+	var ok bool
+	w.StateDB.View(func(btx *bolt.Tx) error {
+		state, ok = getTxoState(btx, opArr[:])
+		return nil
+	})
+	return ok && state == lnutil.TxoReorged
+}
+
+// respend handles a tx spending an outpoint already recorded as spent.
+// The same tx again moves it along to spent once it's in a block.  A
+// different tx means the first spend lost: its outputs still unconfirmed
+// are dropped so they aren't counted alongside whatever the new one pays.
+// Only the first spend's direct outputs go; anything built on them is
+// left.  Returns true if the stxo changed.
+func respend(btx *bolt.Tx, opArr [36]byte, stxoBytes []byte,
+	txid chainhash.Hash, height int32) (bool, error) {
+	st, err := StxoFromBytes(append(opArr[:], stxoBytes...))
+	if err != nil {
+		return false, err
+	}
+	if st.SpendTxid == txid {
+		if height == 0 || st.SpendHeight == height {
+			return false, nil
+		}
+	} else {
+		// a confirmed spend can only be undone by a reorg, which sets
+		// SpendHeight back to 0 first; anything else conflicting is invalid
+		if st.SpendHeight != 0 {
+			return false, nil
+		}
+		logger.Warnf("%s spent by %s, replacing %s\n",
+			st.Op.String(), txid.String(), st.SpendTxid.String())
+		err = dropUnconfirmedOuts(btx, st.SpendTxid)
+		if err != nil {
+			return false, err
+		}
+		st.SpendTxid = txid
+	}
+	st.SpendHeight = height
+	stxb, err := st.ToBytes()
+	if err != nil {
+		return false, err
+	}
+	err = btx.Bucket(BKTStxos).Put(stxb[:36], stxb[36:])
+	if err != nil {
+		return false, err
+	}
+	return true, setTxoState(btx, opArr[:], spentState(height), height, st.Value)
+}
+
+// dropUnconfirmedOuts removes the unconfirmed utxos a tx made, along with
+// their states; the tx can't confirm any more
+func dropUnconfirmedOuts(btx *bolt.Tx, txid chainhash.Hash) error {
+	dufb := btx.Bucket(BKToutpoint)
+	stb := btx.Bucket(BKTTxoState)
+	var kill [][]byte
+	cur := dufb.Cursor()
+	pre := txid.CloneBytes()
+	for k, v := cur.Seek(pre); bytes.HasPrefix(k, pre); k, v = cur.Next() {
+		// watch-only outpoints have no value; height is 8 bytes in
+		if len(v) < 12 || lnutil.BtI32(v[8:12]) != 0 {
+			continue
+		}
+		kill = append(kill, append([]byte{}, k...))
+	}
+	for _, k := range kill {
+		err := dufb.Delete(k)
+		if err != nil {
+			return err
+		}
+		err = stb.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	if len(kill) > 0 {
+		logger.Infof("dropped %d unconfirmed outputs of %s\n",
+			len(kill), txid.String())
+	}
+	return nil
+}
+
+// addTxoStates is the wallet DB migration from 0 to 1: it makes the txo
+// state bucket and fills it in from the utxos and stxos already there
+func addTxoStates(btx *bolt.Tx) error {
+	_, err := btx.CreateBucketIfNotExists(BKTTxoState)
+	if err != nil {
+		return err
+	}
+	dufb := btx.Bucket(BKToutpoint)
+	old := btx.Bucket(BKTStxos)
+	if dufb == nil || old == nil {
+		return fmt.Errorf("wallet buckets missing")
+	}
+	type rec struct {
+		k      []byte
+		state  lnutil.TxoState
+		height int32
+		value  int64
+	}
+	var recs []rec
+	err = dufb.ForEach(func(k, v []byte) error {
+		if len(v) == 0 {
+			return nil
+		}
+		u, err := portxo.PorTxoFromBytes(append(append([]byte{}, k...), v...))
+		if err != nil {
+			return err
+		}
+		state := lnutil.TxoUnconfirmed
+		if u.Height > 0 {
+			state = lnutil.TxoConfirmed
+		}
+		recs = append(recs, rec{append([]byte{}, k...), state, u.Height, u.Value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = old.ForEach(func(k, v []byte) error {
+		st, err := StxoFromBytes(append(append([]byte{}, k...), v...))
+		if err != nil {
+			return err
+		}
+		recs = append(recs, rec{append([]byte{}, k...),
+			spentState(st.SpendHeight), st.SpendHeight, st.Value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		err = setTxoState(btx, r.k, r.state, r.height, r.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"sync"
 
 	"github.com/adiabat/btcd/blockchain"
@@ -13,6 +12,7 @@ import (
 	"github.com/adiabat/btcutil"
 	"github.com/adiabat/btcutil/hdkeychain"
 	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/chainwatch"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
@@ -33,6 +33,26 @@ type Wallit struct {
 	// Gets initialized and activates when called by qln
 	OPEventChan chan lnutil.OutPointEvent
 
+	// Notes tells subscribers about txs and heights from the Hook
+	Notes *chainwatch.Notifier
+
+	// positions in their blocks of txs the hook's sent, until ingested;
+	// they go in confirmation events so channels get short ids
+	txPos    map[chainhash.Hash]uint32
+	txPosMtx sync.Mutex
+
+	// sent txs peers asked for again since the last rebroadcast; see
+	// rebroadcast.go
+	bcastAsked map[chainhash.Hash]bool
+	bcastMtx   sync.Mutex
+
+	// heirMtx guards the heir and its tx; see heir.go
+	heirMtx sync.Mutex
+
+	// confPolicy is how many confirmations utxos need before they're spent
+	confPolicy lnutil.ConfPolicy
+	confMtx    sync.Mutex
+
 	// Params live here...
 	Param *coinparam.Params // network parameters (testnet3, segnet, etc)
 
@@ -142,7 +162,7 @@ func StxoFromBytes(b []byte) (Stxo, error) {
 	// last 36 bytes are height & spend txid.
 	u, err := portxo.PorTxoFromBytes(b[:l-36])
 	if err != nil {
-		log.Printf(" eof? ")
+		logger.Warnf(" eof? ")
 		return s, err
 	}
 
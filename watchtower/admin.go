@@ -0,0 +1,641 @@
+package watchtower
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Towers run by teams can need M of N operators to agree to anything
+destructive done over RPC: dropping a channel's states, restoring watch.db
+from a backup, changing the channel quota, or changing who the operators
+are.
+
+One operator proposes an op, which gets an id: the sha256 of the op.  Each
+operator signs the id with their key (TowerAdminSign on their own node
+signs with its identity key) and the signatures are handed to the tower.
+Once M of the policy's keys have approved, the op is carried out.
+Restores are the exception: the tower can't replace its DB while it's
+running, so an approved restore waits for lit --restore with the backup
+whose sha256 it names.  Proposals expire after adminOpExpiry.
+
+The policy, the quota and the pending ops are kept in BUCKETAdmin in
+watch.db.  The policy is first set from toweradmins in lit.conf; after
+that only an approved policy op changes it, and a different one in
+lit.conf is ignored with a warning.  A restore keeps the admin bucket of
+the DB it replaces, so restoring an old backup doesn't bring back an old
+policy.  Without a policy, ops are carried out as soon as they're proposed.
+
+Anyone with the tower's files can still do what they like with them; this
+is about what the RPC lets one operator do.
+*/
+
+var (
+	BUCKETAdmin = []byte("adm") // admin policy, quota, and pending ops
+
+	KEYAdminPolicy = []byte("pol") // AdminPolicy bytes
+	KEYQuota       = []byte("quo") // most channels, 4 bytes; none for no limit
+	BUCKETAdminOps = []byte("ops") // in BUCKETAdmin; id : AdminOp bytes
+)
+
+// how long a proposed op waits for approvals, in seconds
+const adminOpExpiry = 7 * 24 * 3600
+
+// Admin op kinds
+const (
+	AdminDropChannel uint8 = 1 // Arg is the channel's 20 byte pkh
+	AdminRestore     uint8 = 2 // Arg is the backup archive's sha256
+	AdminSetQuota    uint8 = 3 // Arg is the most channels, 4 bytes
+	AdminSetPolicy   uint8 = 4 // Arg is the new AdminPolicy's bytes
+)
+
+var adminKindNames = map[uint8]string{
+	AdminDropChannel: "drop",
+	AdminRestore:     "restore",
+	AdminSetQuota:    "quota",
+	AdminSetPolicy:   "policy",
+}
+
+// AdminPolicy is how many of which keys have to approve an admin op
+type AdminPolicy struct {
+	M    uint8
+	Keys [][33]byte
+}
+
+// ParseAdminPolicy reads a policy from config: m:pubkey,pubkey,... with
+// the pubkeys in hex
+func ParseAdminPolicy(s string) (*AdminPolicy, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("admin policy %q isn't m:pubkey,pubkey,...", s)
+	}
+	m, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("admin policy %q: %s", s, err.Error())
+	}
+	p := &AdminPolicy{M: uint8(m)}
+	for _, k := range strings.Split(parts[1], ",") {
+		b, err := hex.DecodeString(strings.TrimSpace(k))
+		if err != nil || len(b) != 33 {
+			return nil, fmt.Errorf("admin policy key %q isn't a 33 byte hex pubkey", k)
+		}
+		var pub [33]byte
+		copy(pub[:], b)
+		if p.has(pub) {
+			return nil, fmt.Errorf("admin policy has key %x twice", pub)
+		}
+		p.Keys = append(p.Keys, pub)
+	}
+	return p, p.check()
+}
+
+func (p *AdminPolicy) check() error {
+	if p.M < 1 || int(p.M) > len(p.Keys) || len(p.Keys) > 255 {
+		return fmt.Errorf("admin policy %d of %d keys", p.M, len(p.Keys))
+	}
+	return nil
+}
+
+func (p *AdminPolicy) has(pub [33]byte) bool {
+	for _, k := range p.Keys {
+		if k == pub {
+			return true
+		}
+	}
+	return false
+}
+
+// String gives the policy as ParseAdminPolicy reads it
+func (p *AdminPolicy) String() string {
+	keys := make([]string, len(p.Keys))
+	for i, k := range p.Keys {
+		keys[i] = hex.EncodeToString(k[:])
+	}
+	return fmt.Sprintf("%d:%s", p.M, strings.Join(keys, ","))
+}
+
+// Bytes serializes a policy: M, N, then the N keys
+func (p *AdminPolicy) Bytes() []byte {
+	b := []byte{p.M, uint8(len(p.Keys))}
+	for _, k := range p.Keys {
+		b = append(b, k[:]...)
+	}
+	return b
+}
+
+// AdminPolicyFromBytes deserializes a policy
+func AdminPolicyFromBytes(b []byte) (*AdminPolicy, error) {
+	if len(b) < 2 || len(b) != 2+33*int(b[1]) {
+		return nil, fmt.Errorf("admin policy %d bytes", len(b))
+	}
+	p := &AdminPolicy{M: b[0]}
+	for i := 0; i < int(b[1]); i++ {
+		var k [33]byte
+		copy(k[:], b[2+33*i:])
+		p.Keys = append(p.Keys, k)
+	}
+	return p, p.check()
+}
+
+// AdminOp is a destructive admin operation, and who's approved it so far
+type AdminOp struct {
+	Kind     uint8
+	Arg      []byte
+	Created  int64 // unix time proposed
+	Approved bool  // enough approvals; restores wait for lit --restore
+	Sigs     map[[33]byte][]byte
+}
+
+// ParseAdminOp makes an op from its kind's name and its argument:
+// drop <channel pkh hex>, restore <archive sha256 hex>, quota <channels,
+// 0 for no limit> or policy <m:pubkey,pubkey,...>
+func ParseAdminOp(kind, arg string) (*AdminOp, error) {
+	op := &AdminOp{Sigs: make(map[[33]byte][]byte)}
+	for k, name := range adminKindNames {
+		if name == kind {
+			op.Kind = k
+		}
+	}
+	var err error
+	switch op.Kind {
+	case AdminDropChannel, AdminRestore:
+		want := 20
+		if op.Kind == AdminRestore {
+			want = 32
+		}
+		op.Arg, err = hex.DecodeString(arg)
+		if err != nil || len(op.Arg) != want {
+			return nil, fmt.Errorf("%s needs %d bytes of hex", kind, want)
+		}
+	case AdminSetQuota:
+		n, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		op.Arg = lnutil.U32tB(uint32(n))
+	case AdminSetPolicy:
+		p, err := ParseAdminPolicy(arg)
+		if err != nil {
+			return nil, err
+		}
+		op.Arg = p.Bytes()
+	default:
+		return nil, fmt.Errorf("no admin op %q; drop, restore, quota or policy", kind)
+	}
+	return op, nil
+}
+
+// ID is what operators sign: the sha256 of the kind, time and argument
+func (op *AdminOp) ID() [32]byte {
+	var buf bytes.Buffer
+	buf.WriteByte(op.Kind)
+	binary.Write(&buf, binary.BigEndian, op.Created)
+	buf.Write(op.Arg)
+	return sha256.Sum256(buf.Bytes())
+}
+
+// String says what the op does
+func (op *AdminOp) String() string {
+	switch op.Kind {
+	case AdminDropChannel:
+		return fmt.Sprintf("drop channel %x", op.Arg)
+	case AdminRestore:
+		return fmt.Sprintf("restore from backup %x", op.Arg)
+	case AdminSetQuota:
+		return fmt.Sprintf("quota %d channels", lnutil.BtU32(op.Arg))
+	case AdminSetPolicy:
+		p, err := AdminPolicyFromBytes(op.Arg)
+		if err != nil {
+			return "bad policy"
+		}
+		return "policy " + p.String()
+	}
+	return fmt.Sprintf("unknown admin op %d", op.Kind)
+}
+
+// Bytes serializes an op: kind, created, approved, 2 byte arg length, the
+// arg, number of sigs, and each sig as pubkey, 1 byte length, DER sig
+func (op *AdminOp) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(op.Kind)
+	binary.Write(&buf, binary.BigEndian, op.Created)
+	binary.Write(&buf, binary.BigEndian, op.Approved)
+	binary.Write(&buf, binary.BigEndian, uint16(len(op.Arg)))
+	buf.Write(op.Arg)
+	buf.WriteByte(uint8(len(op.Sigs)))
+	for pub, sig := range op.Sigs {
+		buf.Write(pub[:])
+		buf.WriteByte(uint8(len(sig)))
+		buf.Write(sig)
+	}
+	return buf.Bytes()
+}
+
+// AdminOpFromBytes deserializes an op
+func AdminOpFromBytes(b []byte) (*AdminOp, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("admin op %d bytes", len(b))
+	}
+	op := &AdminOp{Sigs: make(map[[33]byte][]byte)}
+	buf := bytes.NewBuffer(b)
+	op.Kind, _ = buf.ReadByte()
+	binary.Read(buf, binary.BigEndian, &op.Created)
+	binary.Read(buf, binary.BigEndian, &op.Approved)
+	var argLen uint16
+	binary.Read(buf, binary.BigEndian, &argLen)
+	if buf.Len() < int(argLen)+1 {
+		return nil, fmt.Errorf("admin op truncated")
+	}
+	op.Arg = append([]byte{}, buf.Next(int(argLen))...)
+	n, _ := buf.ReadByte()
+	for i := 0; i < int(n); i++ {
+		var pub [33]byte
+		if buf.Len() < 34 {
+			return nil, fmt.Errorf("admin op truncated")
+		}
+		copy(pub[:], buf.Next(33))
+		sl, _ := buf.ReadByte()
+		if buf.Len() < int(sl) {
+			return nil, fmt.Errorf("admin op truncated")
+		}
+		op.Sigs[pub] = append([]byte{}, buf.Next(int(sl))...)
+	}
+	return op, nil
+}
+
+// approvals counts the op's sigs from keys in the policy
+func (op *AdminOp) approvals(p *AdminPolicy) int {
+	var n int
+	for pub := range op.Sigs {
+		if p.has(pub) {
+			n++
+		}
+	}
+	return n
+}
+
+// policyOf reads the stored policy; nil if there isn't one
+func policyOf(adm *bolt.Bucket) (*AdminPolicy, error) {
+	b := adm.Get(KEYAdminPolicy)
+	if b == nil {
+		return nil, nil
+	}
+	return AdminPolicyFromBytes(b)
+}
+
+// initAdmin makes the admin buckets and stores the policy from config if
+// there isn't one yet
+func (w *WatchTower) initAdmin(btx *bolt.Tx) error {
+	adm, err := btx.CreateBucketIfNotExists(BUCKETAdmin)
+	if err != nil {
+		return err
+	}
+	_, err = adm.CreateBucketIfNotExists(BUCKETAdminOps)
+	if err != nil {
+		return err
+	}
+	if w.AdminPolicy == nil {
+		return nil
+	}
+	stored := adm.Get(KEYAdminPolicy)
+	if stored == nil {
+		logger.Infof("tower admin policy %s\n", w.AdminPolicy.String())
+		return adm.Put(KEYAdminPolicy, w.AdminPolicy.Bytes())
+	}
+	if !bytes.Equal(stored, w.AdminPolicy.Bytes()) {
+		logger.Warnf("toweradmins in lit.conf isn't watch.db's policy; " +
+			"keeping watch.db's.  Propose a policy op to change it.\n")
+	}
+	return nil
+}
+
+// quotaOf is the most channels the tower takes; 0 for no limit
+func quotaOf(btx *bolt.Tx) uint32 {
+	adm := btx.Bucket(BUCKETAdmin)
+	if adm == nil {
+		return 0
+	}
+	return lnutil.BtU32(adm.Get(KEYQuota))
+}
+
+// AdminInfo is the admin policy, quota and pending ops
+type AdminInfo struct {
+	Policy *AdminPolicy // nil if there isn't one
+	Quota  uint32
+	Ops    []*AdminOp
+}
+
+// Admin returns the admin policy, quota, and ops waiting for approvals
+func (w *WatchTower) Admin() (*AdminInfo, error) {
+	if w.WatchDB == nil {
+		return nil, fmt.Errorf("watchtower not running")
+	}
+	info := new(AdminInfo)
+	now := time.Now().Unix()
+	err := w.WatchDB.View(func(btx *bolt.Tx) error {
+		adm := btx.Bucket(BUCKETAdmin)
+		if adm == nil {
+			return fmt.Errorf("no admin bucket")
+		}
+		var err error
+		info.Policy, err = policyOf(adm)
+		if err != nil {
+			return err
+		}
+		info.Quota = quotaOf(btx)
+		return adm.Bucket(BUCKETAdminOps).ForEach(func(k, v []byte) error {
+			op, err := AdminOpFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if op.Created+adminOpExpiry > now {
+				info.Ops = append(info.Ops, op)
+			}
+			return nil
+		})
+	})
+	return info, err
+}
+
+// ProposeAdminOp records an op to wait for approvals, and returns its id.
+// With no policy, it's carried out right away and done is true.
+func (w *WatchTower) ProposeAdminOp(op *AdminOp) (id [32]byte, done bool, err error) {
+	if w.WatchDB == nil {
+		return id, false, fmt.Errorf("watchtower not running")
+	}
+	op.Created = time.Now().Unix()
+	op.Approved = false
+	op.Sigs = make(map[[33]byte][]byte)
+	id = op.ID()
+	err = w.WatchDB.Update(func(btx *bolt.Tx) error {
+		adm := btx.Bucket(BUCKETAdmin)
+		if adm == nil {
+			return fmt.Errorf("no admin bucket")
+		}
+		pol, err := policyOf(adm)
+		if err != nil {
+			return err
+		}
+		if pol == nil {
+			done = true
+			return w.doAdminOp(btx, op)
+		}
+		err = pruneAdminOps(adm.Bucket(BUCKETAdminOps), op.Created)
+		if err != nil {
+			return err
+		}
+		logger.Infof("tower admin op %x proposed: %s\n", id[:4], op.String())
+		return adm.Bucket(BUCKETAdminOps).Put(id[:], op.Bytes())
+	})
+	return id, done, err
+}
+
+// pruneAdminOps drops ops which have expired
+func pruneAdminOps(ops *bolt.Bucket, now int64) error {
+	var old [][]byte
+	err := ops.ForEach(func(k, v []byte) error {
+		op, err := AdminOpFromBytes(v)
+		if err != nil || op.Created+adminOpExpiry <= now {
+			old = append(old, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range old {
+		err = ops.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApproveAdminOp adds an operator's signature of an op's id, and carries
+// out the op if that makes enough.  done says if it was carried out, or
+// for restores, approved.
+func (w *WatchTower) ApproveAdminOp(
+	id [32]byte, pub [33]byte, sig []byte) (done bool, err error) {
+
+	if w.WatchDB == nil {
+		return false, fmt.Errorf("watchtower not running")
+	}
+	pk, err := btcec.ParsePubKey(pub[:], btcec.S256())
+	if err != nil {
+		return false, err
+	}
+	s, err := btcec.ParseDERSignature(sig, btcec.S256())
+	if err != nil {
+		return false, err
+	}
+	if !s.Verify(id[:], pk) {
+		return false, fmt.Errorf("bad signature from %x", pub)
+	}
+
+	err = w.WatchDB.Update(func(btx *bolt.Tx) error {
+		adm := btx.Bucket(BUCKETAdmin)
+		if adm == nil {
+			return fmt.Errorf("no admin bucket")
+		}
+		pol, err := policyOf(adm)
+		if err != nil {
+			return err
+		}
+		if pol == nil {
+			return fmt.Errorf("no admin policy")
+		}
+		if !pol.has(pub) {
+			return fmt.Errorf("%x isn't an operator key", pub)
+		}
+		ops := adm.Bucket(BUCKETAdminOps)
+		b := ops.Get(id[:])
+		if b == nil {
+			return fmt.Errorf("no admin op %x", id)
+		}
+		op, err := AdminOpFromBytes(b)
+		if err != nil {
+			return err
+		}
+		if op.Created+adminOpExpiry <= time.Now().Unix() {
+			return fmt.Errorf("admin op %x expired", id)
+		}
+		if op.Approved {
+			return fmt.Errorf("admin op %x already approved", id)
+		}
+		op.Sigs[pub] = sig
+		n := op.approvals(pol)
+		logger.Infof("tower admin op %x: %d of %d approvals\n", id[:4], n, pol.M)
+		if n < int(pol.M) {
+			return ops.Put(id[:], op.Bytes())
+		}
+		done = true
+		op.Approved = true
+		if op.Kind == AdminRestore {
+			return ops.Put(id[:], op.Bytes())
+		}
+		err = ops.Delete(id[:])
+		if err != nil {
+			return err
+		}
+		return w.doAdminOp(btx, op)
+	})
+	return done, err
+}
+
+// doAdminOp carries out an op
+func (w *WatchTower) doAdminOp(btx *bolt.Tx, op *AdminOp) error {
+	logger.Infof("tower admin: %s\n", op.String())
+	adm := btx.Bucket(BUCKETAdmin)
+	switch op.Kind {
+	case AdminDropChannel:
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("no Chandata bucket")
+		}
+		chanBucket := allChanbkt.Bucket(op.Arg)
+		if chanBucket == nil {
+			return fmt.Errorf("no bucket for channel %x", op.Arg)
+		}
+		idxBytes := chanBucket.Get(KEYIdx)
+		if len(idxBytes) != 4 {
+			return fmt.Errorf("channel %x has no index", op.Arg)
+		}
+		return w.dropChannels(btx, map[uint32][]byte{
+			lnutil.BtU32(idxBytes): append([]byte{}, op.Arg...)})
+	case AdminRestore:
+		return nil // lit --restore checks for it
+	case AdminSetQuota:
+		if lnutil.BtU32(op.Arg) == 0 {
+			return adm.Delete(KEYQuota)
+		}
+		return adm.Put(KEYQuota, op.Arg)
+	case AdminSetPolicy:
+		_, err := AdminPolicyFromBytes(op.Arg)
+		if err != nil {
+			return err
+		}
+		return adm.Put(KEYAdminPolicy, op.Arg)
+	}
+	return fmt.Errorf("unknown admin op %d", op.Kind)
+}
+
+// AdminSnapshot is a watch.db's admin bucket, kept over a restore
+type AdminSnapshot struct {
+	keys map[string][]byte
+	ops  map[string][]byte
+}
+
+// RestoreAllowed checks that the watch.db at path, if there is one, lets a
+// backup whose sha256 is archive replace it.  It returns the DB's admin
+// bucket, less the restore op, for KeepAdmin to put in the restored DB.
+// The tower mustn't be running.
+func RestoreAllowed(path string, archive [32]byte) (*AdminSnapshot, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	defer db.Close()
+
+	snap := &AdminSnapshot{
+		keys: make(map[string][]byte), ops: make(map[string][]byte)}
+	now := time.Now().Unix()
+	err = db.View(func(btx *bolt.Tx) error {
+		adm := btx.Bucket(BUCKETAdmin)
+		if adm == nil {
+			return nil
+		}
+		pol, err := policyOf(adm)
+		if err != nil {
+			return err
+		}
+		var used bool
+		err = adm.ForEach(func(k, v []byte) error {
+			if v != nil {
+				snap.keys[string(k)] = append([]byte{}, v...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ops := adm.Bucket(BUCKETAdminOps)
+		if ops != nil {
+			err = ops.ForEach(func(k, v []byte) error {
+				op, err := AdminOpFromBytes(v)
+				if err == nil && !used && op.Kind == AdminRestore &&
+					op.Approved && bytes.Equal(op.Arg, archive[:]) &&
+					op.Created+adminOpExpiry > now {
+					used = true
+					return nil
+				}
+				snap.ops[string(k)] = append([]byte{}, v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if pol != nil && !used {
+			return fmt.Errorf("restoring watch.db needs an approved restore "+
+				"op for backup %x; propose one with toweradmin", archive)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// KeepAdmin puts an admin bucket saved by RestoreAllowed into the watch.db
+// at path, replacing the one restored with it
+func (snap *AdminSnapshot) KeepAdmin(path string) error {
+	if snap == nil || len(snap.keys)+len(snap.ops) == 0 {
+		return nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(btx *bolt.Tx) error {
+		if btx.Bucket(BUCKETAdmin) != nil {
+			err := btx.DeleteBucket(BUCKETAdmin)
+			if err != nil {
+				return err
+			}
+		}
+		adm, err := btx.CreateBucket(BUCKETAdmin)
+		if err != nil {
+			return err
+		}
+		for k, v := range snap.keys {
+			err = adm.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		ops, err := adm.CreateBucket(BUCKETAdminOps)
+		if err != nil {
+			return err
+		}
+		for k, v := range snap.ops {
+			err = ops.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package watchtower
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Committing each state in its own bolt transaction costs a disk sync per
+state, which is what holds a tower back on a spinning disk.  So states go
+through one writer, which commits whatever's waiting in one transaction.
+When states come one at a time, each is written as it arrives; when they
+come faster than the disk syncs, they pile up behind the commit and go in
+together.
+
+A client sends its next state once the last is in, so right after a commit
+the writer would see just one state waiting.  To let the rest catch up, it
+waits up to BatchDelay for as many as it committed last time.  That's the
+most a state waits before its commit starts; a tower with one client at a
+time never waits at all.
+*/
+
+// DefaultBatchDelay is about one sync on a spinning disk
+const DefaultBatchDelay = 10 * time.Millisecond
+
+// most states committed in one transaction
+const maxBatch = 1000
+
+// addReq is one state for the writer to commit, and where to say how it went
+type addReq struct {
+	fn   func(*bolt.Tx) error
+	done chan error
+}
+
+func (w *WatchTower) batchDelay() time.Duration {
+	if w.BatchDelay == 0 {
+		return DefaultBatchDelay
+	}
+	return w.BatchDelay
+}
+
+// startAddWriter starts the writer; OpenDB calls it
+func (w *WatchTower) startAddWriter() {
+	w.adds = make(chan addReq)
+	w.addQuit = make(chan struct{})
+	w.addDone = make(chan struct{})
+	go w.addWriter()
+}
+
+// stopAddWriter stops the writer, once it's committed what it has
+func (w *WatchTower) stopAddWriter() {
+	if w.adds == nil {
+		return
+	}
+	close(w.addQuit)
+	<-w.addDone
+	w.adds = nil
+}
+
+// addMsgTx runs fn in a transaction with any others waiting, and returns
+// its error, or the commit's
+func (w *WatchTower) addMsgTx(fn func(*bolt.Tx) error) error {
+	if w.adds == nil {
+		return w.WatchDB.Update(fn)
+	}
+	req := addReq{fn: fn, done: make(chan error, 1)}
+	select {
+	case w.adds <- req:
+	case <-w.addQuit:
+		return fmt.Errorf("watchtower closed")
+	}
+	return <-req.done
+}
+
+func (w *WatchTower) addWriter() {
+	defer close(w.addDone)
+	last := 1
+	for {
+		var batch []addReq
+		select {
+		case req := <-w.adds:
+			batch = append(batch, req)
+		case <-w.addQuit:
+			return
+		}
+
+		delay := w.batchDelay()
+		waiting := delay > 0
+		timer := time.NewTimer(delay)
+	collect:
+		for len(batch) < maxBatch {
+			if !waiting || len(batch) >= last {
+				// take what's there, but don't wait for more
+				select {
+				case req := <-w.adds:
+					batch = append(batch, req)
+					continue
+				default:
+					break collect
+				}
+			}
+			select {
+			case req := <-w.adds:
+				batch = append(batch, req)
+			case <-timer.C:
+				waiting = false
+			case <-w.addQuit:
+				break collect
+			}
+		}
+		timer.Stop()
+		last = len(batch)
+		w.commitBatch(batch)
+	}
+}
+
+// commitBatch runs all of batch in one transaction.  If one fails, the
+// transaction's rolled back, so the rest go again without it.
+func (w *WatchTower) commitBatch(batch []addReq) {
+	for len(batch) > 0 {
+		failed := -1
+		var failErr error
+		err := w.WatchDB.Update(func(btx *bolt.Tx) error {
+			for i, req := range batch {
+				err := req.fn(btx)
+				if err != nil {
+					failed, failErr = i, err
+					return err
+				}
+			}
+			return nil
+		})
+		if failed >= 0 {
+			batch[failed].done <- failErr
+			batch = append(batch[:failed], batch[failed+1:]...)
+			continue
+		}
+		for _, req := range batch {
+			req.done <- err
+		}
+		return
+	}
+}
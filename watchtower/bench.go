@@ -0,0 +1,296 @@
+package watchtower
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/sig64"
+	"github.com/mit-dci/lit/uspv"
+)
+
+/*
+Bench loads a fresh tower with made up channels and states to see how it
+holds up before watching real ones.  It makes Channels channels, then
+sends States states to each, a state to every channel in turn the way
+real traffic mixes, timing UpdateChannel (AddMsg) and watching the DB
+grow.  With Clients over 1, that many send at once, each with its own
+share of the channels, so states get committed together as under real
+load; see batch.go.  Then it feeds Blocks blocks of BlockTxs random txids through
+MatchTxids, as a block would come in, with one breach in each so the hit
+path gets timed too.
+
+The states are real enough for the tower: elkrems from a sender per
+channel, and one valid signature shared by all of them.  Nothing is ever
+broadcast.
+*/
+
+// bench coin type; the tower only checks it's linked
+const benchCoin = 257
+
+// the client every bench channel belongs to
+var benchClient = [33]byte{2}
+
+// BenchConfig is what to bench
+type BenchConfig struct {
+	Dir      string // new folder for the bench watch.db; must not have one
+	Store    string // txid store, as for OpenWatchStore; "" for watch.db
+	Channels int
+	States   int // per channel
+	Blocks   int
+	BlockTxs int
+	Clients  int           // sending states at once; 0 for 1
+	Batch    time.Duration // the tower's BatchDelay
+}
+
+// BenchSample is the tower part way through adding states
+type BenchSample struct {
+	States     int     // added so far, over all channels
+	MsgsPerSec float64 // since the last sample
+	DBBytes    int64
+}
+
+// BenchResult is what Bench measured
+type BenchResult struct {
+	Config BenchConfig
+
+	ChanTime   time.Duration // to add all the channels
+	AddTime    time.Duration // to add all the states
+	MsgsPerSec float64
+	Samples    []BenchSample
+
+	Stats         TowerStats
+	BytesPerState float64
+
+	IngestTime   time.Duration // MatchTxids, over all blocks
+	PerBlock     time.Duration
+	PerTx        time.Duration
+	SlowestBlock time.Duration
+	Planted      int // blocks with a breach in them
+	Hits         int
+}
+
+// how many samples to take while adding states
+const benchSamples = 10
+
+// Bench runs a benchmark, writing progress to out
+func Bench(cfg BenchConfig, out io.Writer) (*BenchResult, error) {
+	if cfg.Channels < 1 || cfg.States < 1 {
+		return nil, fmt.Errorf("need at least 1 channel and 1 state")
+	}
+	dbPath := filepath.Join(cfg.Dir, "watch.db")
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, fmt.Errorf("%s already exists; bench needs a new folder", dbPath)
+	}
+	err := os.MkdirAll(cfg.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(WatchTower)
+	w.BatchDelay = cfg.Batch
+	w.Store, err = OpenWatchStore(cfg.Store)
+	if err != nil {
+		return nil, err
+	}
+	err = w.OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	// NewChannel only checks the coin is linked; no blocks come from here
+	w.Hooks = map[uint32]uspv.ChainHook{benchCoin: nil}
+
+	res := &BenchResult{Config: cfg}
+
+	sig, err := benchSig()
+	if err != nil {
+		return nil, err
+	}
+
+	// channels
+	pkhs := make([][20]byte, cfg.Channels)
+	senders := make([]*elkrem.ElkremSender, cfg.Channels)
+	start := time.Now()
+	for i := range pkhs {
+		var root chainhash.Hash
+		rand.Read(root[:])
+		rand.Read(pkhs[i][:])
+		senders[i] = elkrem.NewElkremSender(root)
+		var base [33]byte
+		base[0] = 2
+		rand.Read(base[1:])
+		desc := lnutil.NewWatchDescMsg(
+			0, benchCoin, pkhs[i], 5, 5000, base, base)
+		err = w.NewChannel(benchClient, desc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.ChanTime = time.Since(start)
+	fmt.Fprintf(out, "added %d channels in %s\n", cfg.Channels, res.ChanTime)
+
+	// states, keeping a few txids back to breach with later
+	total := cfg.Channels * cfg.States
+	every := total / benchSamples
+	if every < 1 {
+		every = 1
+	}
+	var breachable [][16]byte
+	added := 0
+	sampleStart := time.Now()
+	start = sampleStart
+	// addedOne counts a state in, and samples every so often
+	var mtx sync.Mutex
+	addedOne := func(parTxid [16]byte) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if len(breachable) < cfg.Blocks {
+			breachable = append(breachable, parTxid)
+		}
+		added++
+		if added%every == 0 || added == total {
+			var smp BenchSample
+			smp.States = added
+			n := every
+			if added == total && added%every != 0 {
+				n = added % every
+			}
+			smp.MsgsPerSec = float64(n) / time.Since(sampleStart).Seconds()
+			smp.DBBytes = fileSize(dbPath)
+			res.Samples = append(res.Samples, smp)
+			fmt.Fprintf(out, "%d/%d states  %.0f msg/s  watch.db %s\n",
+				added, total, smp.MsgsPerSec, byteString(smp.DBBytes))
+			sampleStart = time.Now()
+		}
+	}
+	clients := cfg.Clients
+	if clients < 1 {
+		clients = 1
+	}
+	if clients > cfg.Channels {
+		clients = cfg.Channels
+	}
+	errs := make(chan error, clients)
+	for k := 0; k < clients; k++ {
+		go func(k int) {
+			for s := 0; s < cfg.States; s++ {
+				for c := k; c < len(pkhs); c += clients {
+					elk, err := senders[c].AtIndex(uint64(s))
+					if err != nil {
+						errs <- err
+						return
+					}
+					var parTxid [16]byte
+					rand.Read(parTxid[:])
+					msg := lnutil.NewComMsg(0, benchCoin, pkhs[c], *elk, parTxid, sig)
+					err = w.UpdateChannel(benchClient, msg)
+					if err != nil {
+						errs <- err
+						return
+					}
+					addedOne(parTxid)
+				}
+			}
+			errs <- nil
+		}(k)
+	}
+	for k := 0; k < clients; k++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.AddTime = time.Since(start)
+	res.MsgsPerSec = float64(total) / res.AddTime.Seconds()
+
+	res.Stats, err = w.Stats()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Store == "" {
+		res.BytesPerState = float64(res.Stats.DBBytes) / float64(total)
+	} else {
+		res.BytesPerState = float64(res.Stats.TxidBytes) / float64(total)
+	}
+
+	// blocks
+	for b := 0; b < cfg.Blocks; b++ {
+		txids := make([]chainhash.Hash, cfg.BlockTxs)
+		for i := range txids {
+			rand.Read(txids[i][:])
+		}
+		// one breach per block, somewhere after the coinbase
+		if len(txids) > 1 && b < len(breachable) {
+			copy(txids[len(txids)/2][:16], breachable[b][:])
+			res.Planted++
+		}
+		start = time.Now()
+		hits, err := w.MatchTxids(benchCoin, txids)
+		took := time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+		res.IngestTime += took
+		if took > res.SlowestBlock {
+			res.SlowestBlock = took
+		}
+		res.Hits += len(hits)
+	}
+	if cfg.Blocks > 0 {
+		res.PerBlock = res.IngestTime / time.Duration(cfg.Blocks)
+		if cfg.BlockTxs > 0 {
+			res.PerTx = res.IngestTime / time.Duration(cfg.Blocks*cfg.BlockTxs)
+		}
+		fmt.Fprintf(out, "%d blocks of %d txids: %s per block, %s per txid, "+
+			"slowest %s, %d breaches found\n", cfg.Blocks, cfg.BlockTxs,
+			res.PerBlock, res.PerTx, res.SlowestBlock, res.Hits)
+	}
+	return res, nil
+}
+
+// benchSig makes one real, low-s signature to use for every state
+func benchSig() ([64]byte, error) {
+	var sig [64]byte
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return sig, err
+	}
+	hash := chainhash.DoubleHashB([]byte("lit tower bench"))
+	s, err := priv.Sign(hash)
+	if err != nil {
+		return sig, err
+	}
+	return sig64.SigCompress(s.Serialize())
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// byteString gives a size in the biggest unit that fits
+func byteString(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,123 @@
+package watchtower
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// CheckDB makes sure every channel and IdxSig in the tower DB deserializes,
+// and that the index : pkh map agrees with the channels.  With repair set,
+// channels missing from the map are put back in it; nothing else is changed.
+func (w *WatchTower) CheckDB(repair bool) ([]string, error) {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if w.WatchDB == nil {
+		return nil, nil // tower not running
+	}
+
+	txFunc := w.WatchDB.View
+	if repair {
+		txFunc = w.WatchDB.Update
+	}
+	err := txFunc(func(btx *bolt.Tx) error {
+		mapBucket := btx.Bucket(BUCKETPKHMap)
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if mapBucket == nil || allChanbkt == nil {
+			return fmt.Errorf("watchtower buckets missing")
+		}
+
+		// channels, by index
+		chanIdxs := make(map[uint32][]byte)
+		err := allChanbkt.ForEach(func(pkh, _ []byte) error {
+			chanBucket := allChanbkt.Bucket(pkh)
+			if chanBucket == nil || len(pkh) != 20 {
+				report("channel entry %x not a pkh bucket", pkh)
+				return nil
+			}
+			static := chanBucket.Get(KEYStatic)
+			if len(static) < 101 {
+				report("channel %x static data %d bytes, expect 101",
+					pkh, len(static))
+			} else if _, err := lnutil.NewWatchDescMsgFromBytes(static, 0); err != nil {
+				report("channel %x static data: %s", pkh, err.Error())
+			}
+			elkr, err := elkrem.ElkremReceiverFromBytes(chanBucket.Get(KEYElkRcv))
+			if err == nil {
+				err = elkr.Verify()
+			}
+			if err != nil {
+				report("channel %x elkrem receiver: %s", pkh, err.Error())
+			}
+			idxBytes := chanBucket.Get(KEYIdx)
+			if len(idxBytes) != 4 {
+				report("channel %x index %x not 4 bytes", pkh, idxBytes)
+				return nil
+			}
+			idx := lnutil.BtU32(idxBytes)
+			if other, ok := chanIdxs[idx]; ok {
+				report("channels %x and %x both index %d", other, pkh, idx)
+			}
+			chanIdxs[idx] = pkh
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// map has to match
+		err = mapBucket.ForEach(func(k, v []byte) error {
+			if len(k) != 4 || len(v) != 20 {
+				report("pkh map entry %x : %x wrong size", k, v)
+				return nil
+			}
+			idx := lnutil.BtU32(k)
+			pkh, ok := chanIdxs[idx]
+			if !ok {
+				report("pkh map %d : %x has no channel with that index", idx, v)
+			} else if !bytes.Equal(pkh, v) {
+				report("pkh map %d : %x but channel %x has that index", idx, v, pkh)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for idx, pkh := range chanIdxs {
+			if mapBucket.Get(lnutil.U32tB(idx)) != nil {
+				continue
+			}
+			if !repair {
+				report("channel %x index %d not in pkh map", pkh, idx)
+				continue
+			}
+			err = mapBucket.Put(lnutil.U32tB(idx), pkh)
+			if err != nil {
+				return err
+			}
+			report("channel %x index %d not in pkh map (fixed)", pkh, idx)
+		}
+
+		// txid[:16] : IdxSig
+		return w.forEachTxid(btx, func(k, v []byte) error {
+			if len(k) != 16 {
+				report("txid key %x not 16 bytes", k)
+			}
+			s, err := IdxSigFromBytes(v)
+			if err != nil {
+				report("txid %x: %s", k, err.Error())
+				return nil
+			}
+			if _, ok := chanIdxs[s.PKHIdx]; !ok {
+				report("txid %x for channel %d, which isn't in the db", k, s.PKHIdx)
+			}
+			return nil
+		})
+	})
+	return problems, err
+}
@@ -0,0 +1,126 @@
+package watchtower
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Clients are known by the node key they connect with.  lndc's handshake has
+each side prove it holds its key, so every message the tower gets comes
+with the pubkey that sent it.  A channel's bucket records the client that
+described it (KEYClient), and the tower only takes states, unwatches and
+report requests for the channel from that client.  So one client can't
+fill up or close out another's channels, and whatever a channel costs the
+tower is down to a key.
+
+Channels described before clients were recorded go to whichever client
+first sends them a state.
+
+The tower can serve only some clients: with an allow list (towerclient=),
+anyone else's descriptions, states and report requests are turned away.
+Channels already held for a client taken off the list are still watched;
+they just don't get new states.  An empty list serves anyone.
+*/
+
+// KEYClient is the pubkey of the client a channel belongs to (33 bytes)
+var KEYClient = []byte("cli")
+
+// clientList is who the tower serves; empty for anyone
+type clientList struct {
+	mtx   sync.Mutex
+	allow map[string]bool // by lit address
+}
+
+// ParseClients takes towerclient entries: hex node pubkeys or lit addresses
+func ParseClients(entries []string) ([]string, error) {
+	var adrs []string
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if lnutil.LitAdrOK(e) {
+			adrs = append(adrs, e)
+			continue
+		}
+		b, err := hex.DecodeString(e)
+		if err != nil || len(b) != 33 {
+			return nil, fmt.Errorf(
+				"tower client %q isn't a lit address or 33 byte hex pubkey", e)
+		}
+		var pub [33]byte
+		copy(pub[:], b)
+		adrs = append(adrs, lnutil.LitAdrFromPubkey(pub))
+	}
+	return adrs, nil
+}
+
+// SetClients has the tower serve only the clients with these lit
+// addresses; none for anyone
+func (w *WatchTower) SetClients(adrs []string) {
+	allow := make(map[string]bool)
+	for _, a := range adrs {
+		allow[a] = true
+	}
+	w.clients.mtx.Lock()
+	w.clients.allow = allow
+	w.clients.mtx.Unlock()
+}
+
+// Clients are the lit addresses of the clients the tower serves; none
+// means anyone
+func (w *WatchTower) Clients() []string {
+	w.clients.mtx.Lock()
+	defer w.clients.mtx.Unlock()
+	var adrs []string
+	for a := range w.clients.allow {
+		adrs = append(adrs, a)
+	}
+	sort.Strings(adrs)
+	return adrs
+}
+
+// clientAllowed says if the tower serves a client
+func (w *WatchTower) clientAllowed(client [33]byte) error {
+	w.clients.mtx.Lock()
+	defer w.clients.mtx.Unlock()
+	if len(w.clients.allow) == 0 ||
+		w.clients.allow[lnutil.LitAdrFromPubkey(client)] {
+		return nil
+	}
+	return fmt.Errorf("client %s isn't served by this tower",
+		lnutil.LitAdrFromPubkey(client))
+}
+
+// ownChannel checks a channel is client's, and makes it theirs if it
+// doesn't have one yet.  chanBucket has to be writable to do that.
+func ownChannel(chanBucket *bolt.Bucket, client [33]byte) error {
+	owner := chanBucket.Get(KEYClient)
+	if owner == nil {
+		if !chanBucket.Writable() {
+			return nil
+		}
+		logger.Infof("channel now belongs to client %x\n", client)
+		return chanBucket.Put(KEYClient, client[:])
+	}
+	if !bytes.Equal(owner, client[:]) {
+		return fmt.Errorf("channel belongs to another client")
+	}
+	return nil
+}
+
+// clientOf is the client a channel belongs to, if it has one recorded
+func clientOf(chanBucket *bolt.Bucket) ([33]byte, bool) {
+	var client [33]byte
+	owner := chanBucket.Get(KEYClient)
+	if len(owner) != 33 {
+		return client, false
+	}
+	copy(client[:], owner)
+	return client, true
+}
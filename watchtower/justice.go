@@ -3,7 +3,6 @@ package watchtower
 import (
 	"bytes"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
@@ -13,6 +12,17 @@ import (
 	"github.com/mit-dci/lit/sig64"
 )
 
+// justiceInput is one breach's half of a justice tx: the signed input
+// grabbing the bad output, the output it pays, and what's needed to check
+// the sig
+type justiceInput struct {
+	in       *wire.TxIn
+	out      *wire.TxOut
+	pkScript []byte // of the output being grabbed
+	amt      int64  // of the output being grabbed
+	taproot  bool   // txscript can't check these; see taproot.go
+}
+
 // BuildJusticeTx takes the badTx and IdxSig found by IngestTx, and returns a
 // Justice transaction moving funds with great vengance & furious anger.
 // Re-opens the DB which just was closed by IngestTx, but since this almost never
@@ -20,6 +30,25 @@ import (
 // Note that you should flag the channel for deletion after the JusticeTx is broadcast.
 func (w *WatchTower) BuildJusticeTx(
 	cointype uint32, badTx *wire.MsgTx) (*wire.MsgTx, error) {
+	ji, err := w.buildJusticeInput(cointype, badTx)
+	if err != nil {
+		return nil, err
+	}
+	return ji.tx(), nil
+}
+
+// tx makes the justice tx with just this input and output
+func (ji *justiceInput) tx() *wire.MsgTx {
+	justiceTx := wire.NewMsgTx()
+	justiceTx.Version = 2 // shouldn't matter, but standardize
+	justiceTx.AddTxIn(ji.in)
+	justiceTx.AddTxOut(ji.out)
+	return justiceTx
+}
+
+// buildJusticeInput does the work of BuildJusticeTx, up to making the tx
+func (w *WatchTower) buildJusticeInput(
+	cointype uint32, badTx *wire.MsgTx) (*justiceInput, error) {
 	var err error
 
 	// wd and elkRcv are the two things we need to get out of the db
@@ -30,13 +59,11 @@ func (w *WatchTower) BuildJusticeTx(
 	// open DB and get static channel info
 	err = w.WatchDB.View(func(btx *bolt.Tx) error {
 		// get
-		// open the big bucket
-		txidbkt := btx.Bucket(BUCKETTxid)
-		if txidbkt == nil {
-			return fmt.Errorf("no txid bucket")
-		}
 		txid := badTx.TxHash()
-		idxSigBytes := txidbkt.Get(txid[:16])
+		idxSigBytes, err := w.getTxid(btx, txid[:16])
+		if err != nil {
+			return err
+		}
 		if idxSigBytes == nil {
 			return fmt.Errorf("couldn't get txid %x")
 		}
@@ -115,13 +142,17 @@ func (w *WatchTower) BuildJusticeTx(
 	// revocable key is the customer's base point combined with same elk-point
 	Revkey := lnutil.CombinePubs(wd.CustomerBasePoint, elkPoint)
 
-	log.Printf("tower build revpub %x \ntimeoutpub %x\n", Revkey, TimeoutKey)
+	logger.Infof("tower build revpub %x \ntimeoutpub %x\n", Revkey, TimeoutKey)
+	if wd.CommitType != lnutil.CommitLegacy {
+		return taprootJusticeInput(wd, iSig, Revkey, TimeoutKey, badTx)
+	}
+
 	// build script from the two combined pubkeys and the channel delay
 	script := lnutil.CommitScript(Revkey, TimeoutKey, wd.Delay)
 
 	// get P2WSH output script
 	shOutputScript := lnutil.P2WSHify(script)
-	log.Printf("built script %x\npkscript %x\n", script, shOutputScript)
+	logger.Infof("built script %x\npkscript %x\n", script, shOutputScript)
 
 	// try to match WSH with output from tx
 	txoutNum := 999
@@ -150,7 +181,7 @@ func (w *WatchTower) BuildJusticeTx(
 	justiceIn := wire.NewTxIn(badOP, nil, nil)
 	// expand the sig back to 71 bytes
 	bigSig := sig64.SigDecompress(iSig.Sig)
-	bigSig = append(bigSig, byte(txscript.SigHashAll)) // put sighash_all byte on at the end
+	bigSig = append(bigSig, byte(iSig.HashType)) // put sighash byte on at the end
 
 	justiceIn.Sequence = 1                // sequence 1 means grab immediately
 	justiceIn.Witness = make([][]byte, 3) // timeout SH has one presig item
@@ -158,13 +189,78 @@ func (w *WatchTower) BuildJusticeTx(
 	justiceIn.Witness[1] = []byte{0x01}   // above sig is a 1, for justice
 	justiceIn.Witness[2] = script         // full script goes on at the top
 
-	// add in&out to the the final justiceTx
-	justiceTx := wire.NewMsgTx()
-	justiceTx.Version = 2 // shouldn't matter, but standardize
-	justiceTx.AddTxIn(justiceIn)
-	justiceTx.AddTxOut(justiceOut)
+	return &justiceInput{
+		in:       justiceIn,
+		out:      justiceOut,
+		pkScript: shOutputScript,
+		amt:      badTx.TxOut[txoutNum].Value,
+	}, nil
+}
+
+/*
+When one block breaches more than one channel, the justice inputs can go in
+one tx, as long as every sig still verifies in it.  Input i pays output i,
+so a sig made with SIGHASH_SINGLE|ANYONECANPAY (see JusticeHashTypes)
+covers only its own input and output and doesn't care what else is in
+the tx.  SIGHASH_ALL sigs
+cover the whole 1 in 1 out tx they were made for, so those fail and the
+batch falls back to one tx per breach.  The fee is the same either way
+(each output amount is fixed by its sig), but a batch spends it on fewer
+bytes, so it has a higher fee rate and confirms sooner.
+
+Taproot inputs can't be checked here, so they always get a tx each.
+*/
 
-	return justiceTx, nil
+// batchJustice puts justice inputs into as few txs as possible: one if all
+// the sigs verify together, otherwise one each.
+func batchJustice(all []*justiceInput) []*wire.MsgTx {
+	var txs []*wire.MsgTx
+	var jis []*justiceInput
+	for _, ji := range all {
+		if ji.taproot {
+			txs = append(txs, ji.tx())
+		} else {
+			jis = append(jis, ji)
+		}
+	}
+	if len(jis) == 1 {
+		return append(txs, jis[0].tx())
+	}
+	if len(jis) > 1 {
+		batch := wire.NewMsgTx()
+		batch.Version = 2
+		for _, ji := range jis {
+			batch.AddTxIn(ji.in)
+			batch.AddTxOut(ji.out)
+		}
+		err := verifyJustice(batch, jis)
+		if err == nil {
+			return append(txs, batch)
+		}
+		logger.Infof("can't batch %d justice txs: %s\n", len(jis), err.Error())
+	}
+	for _, ji := range jis {
+		txs = append(txs, ji.tx())
+	}
+	return txs
+}
+
+// verifyJustice runs the script for every input of tx, where input i is
+// grabbing jis[i]
+func verifyJustice(tx *wire.MsgTx, jis []*justiceInput) error {
+	hashCache := txscript.NewTxSigHashes(tx)
+	for i, ji := range jis {
+		vm, err := txscript.NewEngine(ji.pkScript, tx, i,
+			txscript.StandardVerifyFlags, nil, hashCache, ji.amt)
+		if err != nil {
+			return err
+		}
+		err = vm.Execute()
+		if err != nil {
+			return fmt.Errorf("input %d: %s", i, err.Error())
+		}
+	}
+	return nil
 }
 
 // don't use this?  inline is OK...
@@ -0,0 +1,6 @@
+package watchtower
+
+import "github.com/mit-dci/lit/lnutil"
+
+// logger for the tower subsystem; see lnutil/logging.go
+var logger = lnutil.NewSubLogger("tower")
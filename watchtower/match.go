@@ -0,0 +1,213 @@
+package watchtower
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+)
+
+/*
+Checking a block used to be one Get per txid, each walking down the txid
+bucket's B+tree from the root, in whatever order the block had them.  With
+thousands of txids a block and millions of states that's a lot of random
+page reads.  Now the block's txid prefixes get sorted and walked against
+one cursor in one read transaction, merge join style: the cursor only ever
+moves forward, seeking to the next txid when it's behind, so the pages it
+goes through are read in order and mostly only once.
+
+Hits are rare, but when there are some (a peer force closing lots of old
+channels at once, say) rebuilding each script means an elkrem derivation
+and a few point additions, so they're built JusticeWorkers at a time.
+Each worker opens its own read transaction; bolt lets as many of those run
+as there are readers.
+*/
+
+// txidMatcher is a WatchStore that can look up a sorted batch of keys in
+// one go.  Stores that aren't just get a Get per key.
+type txidMatcher interface {
+	// MatchKeys says which of keys (sorted, no duplicates needed) are there
+	MatchKeys(keys [][]byte) ([]bool, error)
+}
+
+// redis MGETs at most this many keys at once
+const redisMatchBatch = 500
+
+// matchSorted walks a cursor forward through sorted keys, saying which
+// are in its bucket
+func matchSorted(cur *bolt.Cursor, keys [][]byte) []bool {
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found
+	}
+	k, _ := cur.Seek(keys[0])
+	for i, key := range keys {
+		if k == nil {
+			break // past the end of the bucket; nothing else is there
+		}
+		if bytes.Compare(k, key) < 0 {
+			k, _ = cur.Seek(key)
+			if k == nil {
+				break
+			}
+		}
+		found[i] = bytes.Equal(k, key)
+	}
+	return found
+}
+
+// matchTxidIdxs returns the indexes into txids of the ones in the DB,
+// in block order
+func (w *WatchTower) matchTxidIdxs(txids []chainhash.Hash) ([]int, error) {
+	if len(txids) < 2 {
+		return nil, nil
+	}
+	// coinbase tx cannot be a bad tx, so start at 1
+	order := make([]int, len(txids)-1)
+	for i := range order {
+		order[i] = i + 1
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(
+			txids[order[a]][:16], txids[order[b]][:16]) < 0
+	})
+	keys := make([][]byte, len(order))
+	for i, ti := range order {
+		keys[i] = txids[ti][:16]
+	}
+
+	var found []bool
+	var err error
+	if w.Store != nil {
+		found, err = storeMatch(w.Store, keys)
+	} else {
+		err = w.WatchDB.View(func(btx *bolt.Tx) error {
+			txidbkt := btx.Bucket(BUCKETTxid)
+			if txidbkt == nil {
+				return fmt.Errorf("no txid bucket")
+			}
+			found = matchSorted(txidbkt.Cursor(), keys)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var idxs []int
+	for i, hit := range found {
+		if hit {
+			idxs = append(idxs, order[i])
+		}
+	}
+	sort.Ints(idxs)
+	return idxs, nil
+}
+
+// storeMatch looks up sorted keys in a separate store, all at once if it
+// can
+func storeMatch(s WatchStore, keys [][]byte) ([]bool, error) {
+	if m, ok := s.(txidMatcher); ok {
+		return m.MatchKeys(keys)
+	}
+	found := make([]bool, len(keys))
+	for i, k := range keys {
+		v, err := s.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = v != nil
+	}
+	return found, nil
+}
+
+func (s *boltStore) MatchKeys(keys [][]byte) ([]bool, error) {
+	var found []bool
+	err := s.db.View(func(btx *bolt.Tx) error {
+		found = matchSorted(btx.Bucket(BUCKETTxid).Cursor(), keys)
+		return nil
+	})
+	return found, err
+}
+
+func (s *redisStore) MatchKeys(keys [][]byte) ([]bool, error) {
+	found := make([]bool, len(keys))
+	for start := 0; start < len(keys); start += redisMatchBatch {
+		end := start + redisMatchBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		args := make([][]byte, end-start)
+		for i, k := range keys[start:end] {
+			args[i] = append([]byte(redisPrefix), k...)
+		}
+		r, err := s.cmd("MGET", args...)
+		if err != nil {
+			return nil, err
+		}
+		vals, ok := r.([]interface{})
+		if !ok || len(vals) != len(args) {
+			return nil, fmt.Errorf("redis: bad MGET reply")
+		}
+		for i, v := range vals {
+			b, _ := v.([]byte)
+			found[start+i] = b != nil
+		}
+	}
+	return found, nil
+}
+
+// justiceWorkers is how many justice inputs to build at once
+func (w *WatchTower) justiceWorkers() int {
+	if w.JusticeWorkers > 0 {
+		return w.JusticeWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// buildJusticeInputs builds a justice input for each breach tx, several
+// at once.  Ones that fail are logged and left out; the rest stay in order.
+func (w *WatchTower) buildJusticeInputs(
+	cointype uint32, badTxs []*wire.MsgTx) []*justiceInput {
+
+	built := make([]*justiceInput, len(badTxs))
+	workers := w.justiceWorkers()
+	if workers > len(badTxs) {
+		workers = len(badTxs)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				ji, err := w.buildJusticeInput(cointype, badTxs[i])
+				if err != nil {
+					logger.Errorf("BuildJusticeTx %s error: %s\n",
+						badTxs[i].TxHash().String(), err.Error())
+					continue
+				}
+				built[i] = ji
+			}
+		}()
+	}
+	for i := range badTxs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var jis []*justiceInput
+	for _, ji := range built {
+		if ji != nil {
+			jis = append(jis, ji)
+		}
+	}
+	return jis
+}
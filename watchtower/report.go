@@ -0,0 +1,75 @@
+package watchtower
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// Report says what the tower has for each channel asked about, so a client
+// can see where states it sent didn't arrive and send them again.  Counting
+// the txids means going through all of them, once per report.  Another
+// client's channels look unknown.
+func (w *WatchTower) Report(
+	client [33]byte, pkhs [][20]byte) ([]lnutil.WatchReportEntry, error) {
+	if w.WatchDB == nil {
+		return nil, fmt.Errorf("watchtower not running")
+	}
+	err := w.clientAllowed(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkhs) > lnutil.WatchReportMax {
+		return nil, fmt.Errorf("report on %d channels, max %d",
+			len(pkhs), lnutil.WatchReportMax)
+	}
+	entries := make([]lnutil.WatchReportEntry, len(pkhs))
+	err = w.WatchDB.View(func(btx *bolt.Tx) error {
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("watchtower buckets missing")
+		}
+		// channel index to the entries for it
+		byIdx := make(map[uint32]*lnutil.WatchReportEntry)
+		for i, pkh := range pkhs {
+			e := &entries[i]
+			e.PKH = pkh
+			chanBucket := allChanbkt.Bucket(pkh[:])
+			if chanBucket == nil || ownChannel(chanBucket, client) != nil {
+				continue
+			}
+			e.Flags |= lnutil.WatchReportKnown
+			if chanBucket.Get(KEYUnwatched) != nil {
+				e.Flags |= lnutil.WatchReportUnwatched
+			}
+			elkr, err := elkrem.ElkremReceiverFromBytes(chanBucket.Get(KEYElkRcv))
+			if err != nil {
+				return fmt.Errorf("channel %x: %s", pkh, err.Error())
+			}
+			if elkr.Stats().Nodes > 0 {
+				e.Flags |= lnutil.WatchReportHasStates
+				e.LastState = elkr.UpTo()
+			}
+			idxBytes := chanBucket.Get(KEYIdx)
+			if len(idxBytes) == 4 {
+				byIdx[lnutil.BtU32(idxBytes)] = e
+			}
+		}
+		if len(byIdx) == 0 {
+			return nil
+		}
+		return w.forEachTxid(btx, func(k, v []byte) error {
+			if len(v) < 4 {
+				return nil
+			}
+			e, ok := byIdx[lnutil.BtU32(v[:4])]
+			if ok {
+				e.States++
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
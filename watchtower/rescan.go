@@ -0,0 +1,300 @@
+package watchtower
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/uspv"
+)
+
+/*
+Blocks reach the tower as the wallet syncs them.  If lit stops after the
+wallet's synced a block but before the tower's checked it, or the tower
+was off while the wallet ran, the tower never sees those blocks, and a
+breach in one would go unpunished.
+
+So the tower remembers, per coin, the last block it checked as it came in
+(BUCKETSync).  When the next one isn't right after it, the blocks between
+are a gap, and a rescan goes back and fetches each from the chain hook to
+check it.  How far the rescan's got is saved as it goes, so stopping lit
+partway just has it pick up where it was next time.  Gaps found while one
+is running are added to it.
+
+A block's height comes from its being after the last one, or from its
+coinbase (BIP 34) checked against the headers, or failing those from
+looking through the last maxHeightSearch headers.
+
+The first time a tower runs there's nothing to go back to; it starts from
+the first block it gets.  The node's own channels don't need this: the
+wallet syncs from its own last height, so it sees everything.
+*/
+
+// BUCKETSync has the sync state for each coin, by coin type
+var BUCKETSync = []byte("syn")
+
+// how far back from the header tip to look for a block's height
+const maxHeightSearch = 2016
+
+// how often a rescan says how it's doing
+const rescanLogEvery = 100
+
+// how long to wait before trying a block that didn't come again
+const rescanRetry = time.Minute
+
+// rescanSource is a chain hook the tower can rescan with, like uspv's
+type rescanSource interface {
+	GetHeaderTipHeight() int32
+	GetHeaderAtHeight(int32) (*wire.BlockHeader, error)
+	FetchBlock(int32) (*wire.MsgBlock, error)
+}
+
+// RescanProgress is where a coin's at: the last block checked as it came
+// in, and the blocks still to go back for
+type RescanProgress struct {
+	CoinType uint32
+	Synced   int32
+	Next     int32 // next block to rescan; 0 if there's none to
+	To       int32 // last block to rescan
+}
+
+// syncState is RescanProgress and the synced block's hash, for the DB.
+// 44 bytes: synced height 4, hash 32, next 4, to 4.
+type syncState struct {
+	RescanProgress
+	hash chainhash.Hash
+}
+
+func (s *syncState) bytes() []byte {
+	b := make([]byte, 44)
+	binary.BigEndian.PutUint32(b[:4], uint32(s.Synced))
+	copy(b[4:36], s.hash[:])
+	binary.BigEndian.PutUint32(b[36:40], uint32(s.Next))
+	binary.BigEndian.PutUint32(b[40:], uint32(s.To))
+	return b
+}
+
+// loadSync gets a coin's sync state; false if there isn't one yet
+func loadSync(btx *bolt.Tx, coin uint32) (syncState, bool) {
+	s := syncState{RescanProgress: RescanProgress{CoinType: coin}}
+	bkt := btx.Bucket(BUCKETSync)
+	if bkt == nil {
+		return s, false
+	}
+	b := bkt.Get(lnutil.U32tB(coin))
+	if len(b) != 44 {
+		return s, false
+	}
+	s.Synced = int32(binary.BigEndian.Uint32(b[:4]))
+	copy(s.hash[:], b[4:36])
+	s.Next = int32(binary.BigEndian.Uint32(b[36:40]))
+	s.To = int32(binary.BigEndian.Uint32(b[40:]))
+	return s, true
+}
+
+func putSync(btx *bolt.Tx, s syncState) error {
+	bkt, err := btx.CreateBucketIfNotExists(BUCKETSync)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(lnutil.U32tB(s.CoinType), s.bytes())
+}
+
+// Rescans says where each coin's sync and rescan are at
+func (w *WatchTower) Rescans() ([]RescanProgress, error) {
+	var rps []RescanProgress
+	if w.WatchDB == nil {
+		return nil, nil
+	}
+	err := w.WatchDB.View(func(btx *bolt.Tx) error {
+		for coin := range w.Hooks {
+			s, ok := loadSync(btx, coin)
+			if ok {
+				rps = append(rps, s.RescanProgress)
+			}
+		}
+		return nil
+	})
+	return rps, err
+}
+
+// startRescan starts going back over a coin's gaps, picking up any rescan
+// left from last time.  Returns the chan to poke when there's a new gap,
+// nil if the hook can't rescan.
+func (w *WatchTower) startRescan(
+	coin uint32, hook uspv.ChainHook) chan struct{} {
+	src, ok := hook.(rescanSource)
+	if !ok {
+		logger.Warnf("coin %d chain hook can't fetch old blocks; "+
+			"tower can't rescan after downtime\n", coin)
+		return nil
+	}
+	kick := make(chan struct{}, 1)
+	kick <- struct{}{} // for one left from last time
+	go w.rescanner(coin, src, kick)
+	return kick
+}
+
+// rescanner does a coin's rescans, whenever kicked
+func (w *WatchTower) rescanner(
+	coin uint32, src rescanSource, kick chan struct{}) {
+
+	for range kick {
+		for {
+			var s syncState
+			err := w.WatchDB.View(func(btx *bolt.Tx) error {
+				s, _ = loadSync(btx, coin)
+				return nil
+			})
+			if err != nil || s.Next == 0 {
+				break
+			}
+			blk, err := src.FetchBlock(s.Next)
+			if err != nil {
+				logger.Errorf("rescan coin %d block %d: %s; trying again in %s\n",
+					coin, s.Next, err.Error(), rescanRetry)
+				time.Sleep(rescanRetry)
+				continue
+			}
+			w.checkBlock(coin, blk)
+			if (s.To-s.Next)%rescanLogEvery == 0 {
+				logger.Infof("rescan coin %d at block %d, %d to go\n",
+					coin, s.Next, s.To-s.Next)
+			}
+			err = w.rescanStep(coin, s.Next+1)
+			if err != nil {
+				logger.Errorf("rescan coin %d: %s\n", coin, err.Error())
+				break
+			}
+		}
+	}
+}
+
+// rescanStep saves that the rescan's up to next, finishing it if that's
+// past the end
+func (w *WatchTower) rescanStep(coin uint32, next int32) error {
+	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+		s, ok := loadSync(btx, coin)
+		if !ok || s.Next == 0 {
+			return nil
+		}
+		// a new gap further back may have come in meanwhile
+		if next > s.Next {
+			s.Next = next
+		}
+		if s.Next > s.To {
+			logger.Infof("rescan coin %d done, to block %d\n", coin, s.To)
+			s.Next, s.To = 0, 0
+		}
+		return putSync(btx, s)
+	})
+}
+
+// blockSynced records that a block that came in has been checked, adding
+// any blocks skipped since the last one to the coin's rescan
+func (w *WatchTower) blockSynced(coin uint32, blk *wire.MsgBlock) {
+	src, ok := w.Hooks[coin].(rescanSource)
+	if !ok {
+		return
+	}
+	var last syncState
+	var marked bool
+	err := w.WatchDB.View(func(btx *bolt.Tx) error {
+		last, marked = loadSync(btx, coin)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("coin %d sync: %s\n", coin, err.Error())
+		return
+	}
+	// outside the update, since it can mean reading a lot of headers
+	height, ok := blockHeight(src, blk, last, marked)
+	if !ok {
+		logger.Errorf("coin %d sync: can't find height of block %s\n",
+			coin, blk.BlockHash().String())
+		return
+	}
+
+	gap := false
+	err = w.WatchDB.Update(func(btx *bolt.Tx) error {
+		s, marked := loadSync(btx, coin)
+		// a lower height is a reorg; nothing to go back for
+		if marked && height > s.Synced+1 {
+			logger.Warnf("coin %d blocks %d to %d weren't checked; rescanning\n",
+				coin, s.Synced+1, height-1)
+			if s.Next == 0 || s.Synced+1 < s.Next {
+				s.Next = s.Synced + 1
+			}
+			if height-1 > s.To {
+				s.To = height - 1
+			}
+			gap = true
+		}
+		s.Synced = height
+		s.hash = blk.BlockHash()
+		return putSync(btx, s)
+	})
+	if err != nil {
+		logger.Errorf("coin %d sync: %s\n", coin, err.Error())
+		return
+	}
+	if gap {
+		select {
+		case w.rescanKicks[coin] <- struct{}{}:
+		default: // already kicked
+		}
+	}
+}
+
+// blockHeight works out the height of a block that came in; false if it
+// can't
+func blockHeight(src rescanSource, blk *wire.MsgBlock,
+	s syncState, marked bool) (int32, bool) {
+
+	if marked && blk.Header.PrevBlock == s.hash {
+		return s.Synced + 1, true
+	}
+	hash := blk.BlockHash()
+	at := func(h int32) bool {
+		hdr, err := src.GetHeaderAtHeight(h)
+		return err == nil && hdr.BlockHash() == hash
+	}
+	h, ok := coinbaseHeight(blk)
+	if ok && at(h) {
+		return h, true
+	}
+	tip := src.GetHeaderTipHeight()
+	for h := tip; h >= 0 && h > tip-maxHeightSearch; h-- {
+		if at(h) {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// coinbaseHeight is the height a block's coinbase says it's at (BIP 34)
+func coinbaseHeight(blk *wire.MsgBlock) (int32, bool) {
+	if len(blk.Transactions) == 0 || len(blk.Transactions[0].TxIn) == 0 {
+		return 0, false
+	}
+	script := blk.Transactions[0].TxIn[0].SignatureScript
+	if len(script) == 0 {
+		return 0, false
+	}
+	op := script[0]
+	switch {
+	case op >= txscript.OP_1 && op <= txscript.OP_16:
+		return int32(op-txscript.OP_1) + 1, true
+	case op >= 1 && op <= 4 && len(script) > int(op):
+		var h int32
+		for i := int(op); i > 0; i-- {
+			h = h<<8 | int32(script[i])
+		}
+		return h, true
+	}
+	return 0, false
+}
@@ -3,19 +3,21 @@ package watchtower
 import (
 	"fmt"
 
+	"github.com/adiabat/btcd/txscript"
 	"github.com/mit-dci/lit/lnutil"
 )
 
-// IdxSigs are 74 bytes
+// IdxSigs are 74 bytes, or 75 with a sighash type
 // PKHIdx 4
 // StateIdx 6
 // Sig 64
+// HashType 1 (only if not SIGHASH_ALL)
 
 // no idxSig to bytes function -- done inline in the addMsg db call
 
 func IdxSigFromBytes(b []byte) (*IdxSig, error) {
 	var s IdxSig
-	if len(b) != 74 {
+	if len(b) != 74 && len(b) != 75 {
 		return nil, fmt.Errorf("IdxSigFromBytes got %d bytes, expect 74", len(b))
 	}
 	s.PKHIdx = lnutil.BtU32(b[:4])
@@ -23,7 +25,11 @@ func IdxSigFromBytes(b []byte) (*IdxSig, error) {
 	// then set them to 0 after we've cast to uint64
 	s.StateIdx = lnutil.BtU64(b[2:10])
 	s.StateIdx &= 0x0000ffffffffffff
-	copy(s.Sig[:], b[10:])
+	copy(s.Sig[:], b[10:74])
+	s.HashType = txscript.SigHashAll
+	if len(b) == 75 {
+		s.HashType = txscript.SigHashType(b[74])
+	}
 	return &s, nil
 }
 
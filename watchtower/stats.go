@@ -0,0 +1,70 @@
+package watchtower
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/elkrem"
+)
+
+// TowerStats is how much the tower is storing
+type TowerStats struct {
+	Channels    int
+	Clients     int    // clients with channels; see clients.go
+	States      uint64 // states received, over all channels
+	ElkremBytes int    // stored elkrem receivers
+	ElkremMem   int    // ram the receivers take when loaded
+	Txids       int    // txids being watched for
+	TxidBytes   int    // stored txids and IdxSigs
+	DBBytes     int64  // whole DB file, not counting a separate txid store
+}
+
+// Stats adds up what's in the tower DB.  Elkrem receivers stay small; the
+// txid bucket grows by 90 bytes per state and is what needs bounding.
+func (w *WatchTower) Stats() (TowerStats, error) {
+	var st TowerStats
+	if w.WatchDB == nil {
+		return st, fmt.Errorf("watchtower not running")
+	}
+	clients := make(map[[33]byte]bool)
+	err := w.WatchDB.View(func(btx *bolt.Tx) error {
+		st.DBBytes = btx.Size()
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("watchtower buckets missing")
+		}
+		err := allChanbkt.ForEach(func(pkh, _ []byte) error {
+			chanBucket := allChanbkt.Bucket(pkh)
+			if chanBucket == nil {
+				return nil
+			}
+			st.Channels++
+			client, ok := clientOf(chanBucket)
+			if ok {
+				clients[client] = true
+			}
+			elkBytes := chanBucket.Get(KEYElkRcv)
+			elkr, err := elkrem.ElkremReceiverFromBytes(elkBytes)
+			if err != nil {
+				return fmt.Errorf("channel %x: %s", pkh, err.Error())
+			}
+			es := elkr.Stats()
+			if es.Nodes > 0 {
+				st.States += es.UpTo + 1
+			}
+			st.ElkremBytes += len(elkBytes)
+			st.ElkremMem += es.MemBytes
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		st.Clients = len(clients)
+		return w.forEachTxid(btx, func(k, v []byte) error {
+			st.Txids++
+			st.TxidBytes += len(k) + len(v)
+			return nil
+		})
+	})
+	return st, err
+}
@@ -0,0 +1,102 @@
+package watchtower
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Channels with taproot commitments (see lnutil/taproot.go) say so in their
+descriptor, along with whatever the tower can't work out from the base
+points and elkrem: the internal key, if revocation's by script path, and
+any hashes above the revocation and delay leaves.  For each state the
+tower builds the to-self output key from those, and on a breach grabs it
+with a key path witness (just the sig) or a script path one (sig,
+revocation script, control block).
+
+Sigs for taproot channels are BIP 340 schnorr sigs, 64 bytes like the
+compressed ones, kept as they are.  SIGHASH_ALL means SIGHASH_DEFAULT: no
+sighash byte on the end.  Other types get their byte as usual.
+
+This btcd can't run taproot scripts, so the tower can't check a taproot
+justice input will verify with others, and doesn't batch them.
+*/
+
+// commitTypeOf is the commitment type of a channel from its static data;
+// the byte after the legacy descriptor, if there is one
+func commitTypeOf(static []byte) uint8 {
+	if len(static) <= 101 {
+		return lnutil.CommitLegacy
+	}
+	return static[101]
+}
+
+// tapSigCheck makes sure a schnorr sig could verify: r is an x coordinate
+// and s is less than the curve order
+func tapSigCheck(sig [64]byte) error {
+	curve := btcec.S256()
+	if new(big.Int).SetBytes(sig[:32]).Cmp(curve.P) >= 0 {
+		return fmt.Errorf("sig r out of range")
+	}
+	if new(big.Int).SetBytes(sig[32:]).Cmp(curve.N) >= 0 {
+		return fmt.Errorf("sig s out of range")
+	}
+	return nil
+}
+
+// taprootJusticeInput is buildJusticeInput for taproot commitments, once
+// it's worked out the state's keys
+func taprootJusticeInput(wd lnutil.WatchDescMsg, iSig *IdxSig,
+	revKey, timeoutKey [33]byte, badTx *wire.MsgTx) (*justiceInput, error) {
+
+	pkScript, script, control, err := lnutil.TapCommit(wd.CommitType,
+		revKey, timeoutKey, wd.Delay, wd.InternalKey, wd.TapPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("built taproot pkscript %x\n", pkScript)
+
+	txoutNum := -1
+	for i, out := range badTx.TxOut {
+		if bytes.Equal(pkScript, out.PkScript) {
+			txoutNum = i
+			break
+		}
+	}
+	if txoutNum == -1 {
+		return nil, fmt.Errorf("couldn't match generated script with detected txout")
+	}
+
+	justiceAmt := badTx.TxOut[txoutNum].Value - wd.Fee
+	justicePkScript := lnutil.DirectWPKHScriptFromPKH(wd.DestPKHScript)
+	justiceOut := wire.NewTxOut(justiceAmt, justicePkScript)
+
+	badtxid := badTx.TxHash()
+	badOP := wire.NewOutPoint(&badtxid, uint32(txoutNum))
+	justiceIn := wire.NewTxIn(badOP, nil, nil)
+	justiceIn.Sequence = 1
+
+	sig := append([]byte{}, iSig.Sig[:]...)
+	if iSig.HashType != txscript.SigHashAll {
+		sig = append(sig, byte(iSig.HashType))
+	}
+	if wd.CommitType == lnutil.CommitTaprootKey {
+		justiceIn.Witness = [][]byte{sig}
+	} else {
+		justiceIn.Witness = [][]byte{sig, script, control}
+	}
+
+	return &justiceInput{
+		in:       justiceIn,
+		out:      justiceOut,
+		pkScript: pkScript,
+		amt:      badTx.TxOut[txoutNum].Value,
+		taproot:  true,
+	}, nil
+}
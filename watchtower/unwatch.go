@@ -0,0 +1,142 @@
+package watchtower
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcutil"
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+When a channel closes cooperatively the client sends a WatchDelMsg, which
+reveals the pubkey behind the channel's refund PKH.  Only the client has
+that key, so it proves the unwatch is from them.
+
+The tower doesn't delete the channel right away: the close the client saw
+might never confirm, and an old state could still show up.  The channel is
+quarantined -- no more updates, but its txids stay watched -- for
+unwatchQuarantine, then dropped along with its txids.
+*/
+
+// how long an unwatched channel's states are still watched
+const unwatchQuarantine = 14 * 24 * time.Hour
+
+// DeleteChannel quarantines a channel the client has closed
+func (w *WatchTower) DeleteChannel(client [33]byte, m lnutil.WatchDelMsg) error {
+	if !bytes.Equal(btcutil.Hash160(m.RevealPK[:]), m.DestPKH[:]) {
+		return fmt.Errorf("unwatch %x: revealed key doesn't match", m.DestPKH)
+	}
+	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("no Chandata bucket")
+		}
+		chanBucket := allChanbkt.Bucket(m.DestPKH[:])
+		if chanBucket == nil {
+			return fmt.Errorf("no bucket for channel %x", m.DestPKH)
+		}
+		err := ownChannel(chanBucket, client)
+		if err != nil {
+			return fmt.Errorf("unwatch %x: %s", m.DestPKH, err.Error())
+		}
+		// static data starts with the cointype then the pkh it was set up with
+		static := chanBucket.Get(KEYStatic)
+		if len(static) < 25 || !bytes.Equal(static[5:25], m.DestPKH[:]) {
+			return fmt.Errorf("unwatch %x: doesn't match channel data", m.DestPKH)
+		}
+		if chanBucket.Get(KEYUnwatched) != nil {
+			return nil // already
+		}
+		logger.Infof("channel %x unwatched; dropping it after %s\n",
+			m.DestPKH, unwatchQuarantine)
+		return chanBucket.Put(
+			KEYUnwatched, lnutil.U64tB(uint64(time.Now().Unix())))
+	})
+}
+
+// purgeUnwatched drops channels unwatched more than unwatchQuarantine
+// before now, and all their txids
+func (w *WatchTower) purgeUnwatched(now time.Time) error {
+	cutoff := now.Add(-unwatchQuarantine).Unix()
+
+	// see if there's anything to do first; usually there isn't
+	expired := make(map[uint32][]byte) // idx : pkh
+	err := w.WatchDB.View(func(btx *bolt.Tx) error {
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("no Chandata bucket")
+		}
+		return allChanbkt.ForEach(func(pkh, _ []byte) error {
+			chanBucket := allChanbkt.Bucket(pkh)
+			if chanBucket == nil {
+				return nil
+			}
+			when := chanBucket.Get(KEYUnwatched)
+			idxBytes := chanBucket.Get(KEYIdx)
+			if len(when) != 8 || len(idxBytes) != 4 ||
+				int64(lnutil.BtU64(when)) > cutoff {
+				return nil
+			}
+			expired[lnutil.BtU32(idxBytes)] = append([]byte{}, pkh...)
+			return nil
+		})
+	})
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+
+	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+		return w.dropChannels(btx, expired)
+	})
+}
+
+// dropChannels deletes channels (idx : pkh) and all their txids
+func (w *WatchTower) dropChannels(btx *bolt.Tx, drop map[uint32][]byte) error {
+	// can't delete while iterating, so find the txids first
+	var txids [][]byte
+	err := w.forEachTxid(btx, func(k, v []byte) error {
+		s, err := IdxSigFromBytes(v)
+		if err != nil {
+			return nil // checkdb's problem
+		}
+		if _, ok := drop[s.PKHIdx]; ok {
+			txids = append(txids, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range txids {
+		if w.Store != nil {
+			err = w.Store.Delete(k)
+		} else {
+			err = btx.Bucket(BUCKETTxid).Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	mapBucket := btx.Bucket(BUCKETPKHMap)
+	allChanbkt := btx.Bucket(BUCKETChandata)
+	if mapBucket == nil || allChanbkt == nil {
+		return fmt.Errorf("watchtower buckets missing")
+	}
+	for idx, pkh := range drop {
+		err = mapBucket.Delete(lnutil.U32tB(idx))
+		if err != nil {
+			return err
+		}
+		err = allChanbkt.DeleteBucket(pkh)
+		if err != nil {
+			return err
+		}
+		logger.Infof("dropped channel %x\n", pkh)
+	}
+	logger.Infof("dropped %d txids of %d channels\n", len(txids), len(drop))
+	return nil
+}
@@ -2,19 +2,22 @@ package watchtower
 
 import (
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/elkrem"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/migrate"
+	"github.com/mit-dci/lit/sig64"
 
 	"github.com/boltdb/bolt"
 )
 
 /*
-WatchDB has 3 top level buckets -- 2 small ones and one big one.
-(also could write it so that the big one is a different file or different machine)
+WatchDB has 4 top level buckets -- 3 small ones and one big one.
+(the big one can be a different file or different machine; see watchstore.go)
 
 PKHMapBucket is k:v
 localChannelId : PKH
@@ -22,11 +25,17 @@ localChannelId : PKH
 ChannelBucket is full of PKH sub-buckets
 PKH (lots)
   |
-  |-KEYElkRcv : Serialized elkrem receiver (couple KB)
+  |-KEYElkRcv : Serialized elkrem receiver, compact format (1.5KB max)
   |
   |-KEYIdx : channelIdx (4 bytes)
   |
-  |-KEYStatic : ChanStatic (~100 bytes)
+  |-KEYStatic : ChanStatic (101 bytes, more for taproot; see taproot.go)
+  |
+  |-KEYUnwatched : unix time the client unwatched it (8 bytes, if it did)
+  |
+  |-KEYClient : pubkey of the client it belongs to (33 bytes); see clients.go
+
+AdminBucket holds the admin policy, quota and pending ops; see admin.go
 
 
 (could also add some metrics, like last write timestamp)
@@ -34,7 +43,7 @@ PKH (lots)
 the big one:
 
 TxidBucket is k:v
-Txid[:16] : IdxSig (74 bytes)
+Txid[:16] : IdxSig (74 bytes, 75 with a sighash type)
 
 TODO: both ComMsgs and IdxSigs need to support multiple signatures for HTLCs.
 What's nice is that this is the *only* thing needed to support HTLCs.
@@ -68,11 +77,56 @@ var (
 	BUCKETChandata = []byte("cda") // bucket for channel data (elks, points)
 	BUCKETTxid     = []byte("txi") // big bucket with every txid
 
-	KEYStatic = []byte("sta") // static per channel data as value
-	KEYElkRcv = []byte("elk") // elkrem receiver
-	KEYIdx    = []byte("idx") // index mapping
+	KEYStatic    = []byte("sta") // static per channel data as value
+	KEYElkRcv    = []byte("elk") // elkrem receiver
+	KEYIdx       = []byte("idx") // index mapping
+	KEYUnwatched = []byte("unw") // when the client said stop; see unwatch.go
 )
 
+// watchDBMigrations update the watchtower DB to the current schema; see
+// package migrate.  Append only.
+var watchDBMigrations = []migrate.Migration{
+	compactElkrems, // 0 to 1
+}
+
+// compactElkrems rewrites every elkrem receiver in the compact format
+func compactElkrems(btx *bolt.Tx) error {
+	allChanbkt := btx.Bucket(BUCKETChandata)
+	if allChanbkt == nil {
+		return fmt.Errorf("no Chandata bucket")
+	}
+	var pkhs [][]byte
+	err := allChanbkt.ForEach(func(k, _ []byte) error {
+		pkhs = append(pkhs, k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pkh := range pkhs {
+		chanBucket := allChanbkt.Bucket(pkh)
+		if chanBucket == nil {
+			continue
+		}
+		elkr, err := elkrem.ElkremReceiverFromBytes(chanBucket.Get(KEYElkRcv))
+		if err != nil {
+			return fmt.Errorf("channel %x: %s", pkh, err.Error())
+		}
+		elkBytes, err := elkr.ToCompactBytes()
+		if err != nil {
+			return err
+		}
+		if elkBytes == nil {
+			continue
+		}
+		err = chanBucket.Put(KEYElkRcv, elkBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Opens the DB file for the LnNode
 func (w *WatchTower) OpenDB(filepath string) error {
 	var err error
@@ -95,21 +149,46 @@ func (w *WatchTower) OpenDB(filepath string) error {
 		if err != nil {
 			return err
 		}
+		_, err = btx.CreateBucketIfNotExists(BUCKETSync)
+		if err != nil {
+			return err
+		}
 		// if there are txids in the bucket, set watching to true
-		if txidBkt.Stats().KeyN != 0 {
+		if w.Store == nil && txidBkt.Stats().KeyN != 0 {
 			w.Watching = true
 		}
-		return nil
+		return w.initAdmin(btx)
 	})
 	if err != nil {
 		return err
 	}
+	if w.Store != nil {
+		n, err := w.Store.Count()
+		if err != nil {
+			return err
+		}
+		w.Watching = n != 0
+	}
+	err = migrate.Run(w.WatchDB, watchDBMigrations)
+	if err != nil {
+		return err
+	}
+	w.startAddWriter()
 	return nil
 }
 
-// AddNewChannel puts a new channel into the watchtower db.
+// BoltDB returns the tower's DB, for backups.  nil until HookLink.
+func (w *WatchTower) BoltDB() *bolt.DB {
+	return w.WatchDB
+}
+
+// AddNewChannel puts a new channel into the watchtower db, for client.
 // Probably need some way to prevent overwrites.
-func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
+func (w *WatchTower) NewChannel(client [33]byte, m lnutil.WatchDescMsg) error {
+	err := w.clientAllowed(client)
+	if err != nil {
+		return err
+	}
 
 	// quick check if we support the cointype
 	_, ok := w.Hooks[m.CoinType]
@@ -134,7 +213,10 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 		if k != nil {
 			newIdx = lnutil.BtU32(k) + 1 // and add 1
 		}
-		log.Printf("assigning new channel index %d\n", newIdx)
+		if q := quotaOf(btx); q != 0 && mapBucket.Stats().KeyN >= int(q) {
+			return fmt.Errorf("tower is full: %d channels", q)
+		}
+		logger.Infof("assigning new channel index %d\n", newIdx)
 		newIdxBytes := lnutil.U32tB(newIdx)
 
 		allChanbkt := btx.Bucket(BUCKETChandata)
@@ -146,18 +228,22 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 		if err != nil {
 			return err
 		}
-		// save truncated descriptor for static info (drop elk0)
+		// save descriptor for static info, taproot parts and all
 		wdBytes := m.Bytes()
-		if len(wdBytes) < 96 {
-			return fmt.Errorf("watchdescriptor %d bytes, expect 96")
+		if len(wdBytes) < 101 {
+			return fmt.Errorf("watchdescriptor %d bytes, expect 101", len(wdBytes))
 		}
-		chanBucket.Put(KEYStatic, wdBytes[:96])
-		log.Printf("saved new channel to pkh %x\n", m.DestPKHScript)
+		chanBucket.Put(KEYStatic, wdBytes)
+		logger.Infof("saved new channel to pkh %x\n", m.DestPKHScript)
 		// save index
 		err = chanBucket.Put(KEYIdx, newIdxBytes)
 		if err != nil {
 			return err
 		}
+		err = chanBucket.Put(KEYClient, client[:])
+		if err != nil {
+			return err
+		}
 		// even though we haven't actually added anything to watch for,
 		// we're pretty sure there will be soon; the watch tower is "on" at this
 		// point so assert "watching".
@@ -169,11 +255,21 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 	})
 }
 
-// AddMsg adds a new message describing a penalty tx to the db.
-// optimization would be to add a bunch of messages at once.  Not a huge speedup though.
-func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
+// AddMsg adds a new message describing a penalty tx to the db, from the
+// client the channel belongs to.
+// States arriving together are committed together; see batch.go.
+func (w *WatchTower) UpdateChannel(client [33]byte, m lnutil.WatchStateMsg) error {
+	err := w.clientAllowed(client)
+	if err != nil {
+		return err
+	}
 
-	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+	hashType, err := justiceHashType(m.HashType)
+	if err != nil {
+		return fmt.Errorf("channel %x: %s", m.DestPKH, err.Error())
+	}
+
+	return w.addMsgTx(func(btx *bolt.Tx) error {
 
 		// first get the channel bucket, update the elkrem and read the idx
 		allChanbkt := btx.Bucket(BUCKETChandata)
@@ -184,6 +280,25 @@ func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 		if chanBucket == nil {
 			return fmt.Errorf("no bucket for channel %x", m.DestPKH)
 		}
+		err := ownChannel(chanBucket, client)
+		if err != nil {
+			return fmt.Errorf("channel %x: %s", m.DestPKH, err.Error())
+		}
+		if chanBucket.Get(KEYUnwatched) != nil {
+			return fmt.Errorf("channel %x was unwatched", m.DestPKH)
+		}
+
+		// can't verify the sig until there's a breach to sign, but can at
+		// least make sure it's one that could verify, before storing it
+		// forever.  Taproot channels' are schnorr; see taproot.go.
+		if commitTypeOf(chanBucket.Get(KEYStatic)) == lnutil.CommitLegacy {
+			err = sig64.SigCheck(m.Sig)
+		} else {
+			err = tapSigCheck(m.Sig)
+		}
+		if err != nil {
+			return fmt.Errorf("channel %x bad justice sig: %s", m.DestPKH, err.Error())
+		}
 
 		// deserialize elkrems.  Future optimization: could keep
 		// all elkrem receivers in RAM for every channel, only writing here
@@ -202,7 +317,7 @@ func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 		// get state number, after elk insertion.  also convert to 8 bytes.
 		stateNumBytes := lnutil.U64tB(elkr.UpTo())
 		// worked, so save it back.  First serialize
-		elkBytes, err := elkr.ToBytes()
+		elkBytes, err := elkr.ToCompactBytes()
 		if err != nil {
 			return err
 		}
@@ -220,115 +335,105 @@ func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 		// we've updated the elkrem and saved it, so done with channel bucket.
 		// next go to txid bucket to save
 
-		txidbkt := btx.Bucket(BUCKETTxid)
-		if txidbkt == nil {
-			return fmt.Errorf("no txid bucket")
-		}
 		// create the sigIdx 74 bytes.  A little ugly but only called here and
 		// pretty quick.  Maybe make a function for this.
 		sigIdxBytes := make([]byte, 74)
 		copy(sigIdxBytes[:4], cIdxBytes)           // first 4 bytes is the PKH index
 		copy(sigIdxBytes[4:10], stateNumBytes[2:]) // next 6 is state number
 		copy(sigIdxBytes[10:], m.Sig[:])           // the rest is signature
+		// and the sighash type if it's not the usual
+		if hashType != txscript.SigHashAll {
+			sigIdxBytes = append(sigIdxBytes, byte(hashType))
+		}
 
-		log.Printf("chan %x (pkh %x) up to state %x\n",
+		logger.Infof("chan %x (pkh %x) up to state %x\n",
 			cIdxBytes, m.DestPKH, stateNumBytes)
 		// save sigIdx into the txid bucket.
 		// TODO truncate txid, and deal with collisions.
-		return txidbkt.Put(m.ParTxid[:16], sigIdxBytes)
+		return w.putTxid(btx, m.ParTxid[:16], sigIdxBytes)
 	})
 }
 
-// TODO implement DeleteChannel.  Would be nice to delete old channels.
-func (w *WatchTower) DeleteChannel(m lnutil.WatchDelMsg) error {
-	return nil
-}
-
-// MatchTxid takes in a txid, checks against the DB, and if there's a hit, returns a
-// IdxSig with which to make a JusticeTx.  Hits should be rare.
+// MatchTxids checks a block's txids against the DB, returning the ones that
+// are there, which can each make a JusticeTx.  Hits should be rare.  See
+// match.go for how.
 func (w *WatchTower) MatchTxids(
 	cointype uint32, txids []chainhash.Hash) ([]chainhash.Hash, error) {
 
-	var err error
-	var hits []chainhash.Hash
-
-	err = w.WatchDB.View(func(btx *bolt.Tx) error {
-		// open the big bucket
-		txidbkt := btx.Bucket(BUCKETTxid)
-		if txidbkt == nil {
-			return fmt.Errorf("no txid bucket")
-		}
-
-		for i, txid := range txids {
-			if i == 0 {
-				// coinbase tx cannot be a bad tx
-				continue
-			}
-			b := txidbkt.Get(txid[:16])
-			if b != nil {
-				log.Printf("zomg hit %s\n", txid.String())
-				hits = append(hits, txid)
-			}
-		}
-		return nil
-	})
-	return hits, err
+	idxs, err := w.matchTxidIdxs(txids)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]chainhash.Hash, len(idxs))
+	for i, ti := range idxs {
+		logger.Infof("zomg hit %s\n", txids[ti].String())
+		hits[i] = txids[ti]
+	}
+	return hits, nil
 }
 
 func (w *WatchTower) BlockHandler(
 	cointype uint32, bchan chan *wire.MsgBlock) {
 
-	log.Printf("-- started BlockHandler type %d, block channel cap %d\n",
+	logger.Infof("-- started BlockHandler type %d, block channel cap %d\n",
 		cointype, cap(bchan))
 
 	for {
 		// block here, take in blocks
 		block := <-bchan
 
-		log.Printf("tower check block %s %d txs\n",
+		logger.Infof("tower check block %s %d txs\n",
 			block.BlockHash().String(), len(block.Transactions))
 
-		// get all txids from the blocks
-		txids, err := block.TxHashes()
+		// drop channels whose quarantine is over
+		err := w.purgeUnwatched(time.Now())
 		if err != nil {
-			log.Printf("BlockHandler/TxHashes error: %s", err.Error())
+			logger.Errorf("BlockHandler/purgeUnwatched error: %s", err.Error())
 		}
 
-		// see if there are any hits from all the txids
-		// usually there aren't any so we can finish here
-		hits, err := w.MatchTxids(cointype, txids)
-		if err != nil {
-			log.Printf("BlockHandler/MatchTxids error: %s", err.Error())
-		}
-
-		// if there were hits, need to build justice txs and send out
-		if len(hits) > 0 {
-			for _, hitTxid := range hits {
-				log.Printf("zomg tx %s matched db\n", hitTxid.String())
-				for _, tx := range block.Transactions {
-					// inefficient here, iterating through whole block.
-					// probably OK because this rarely hapens
-					curTxid := tx.TxHash()
-					if curTxid.IsEqual(&hitTxid) {
-						justice, err := w.BuildJusticeTx(cointype, tx)
-						if err != nil {
-							log.Printf("BuildJusticeTx error: %s", err.Error())
-						}
-						log.Printf("made & sent out justice tx %s\n",
-							justice.TxHash().String())
-						err = w.Hooks[cointype].PushTx(justice)
-						if err != nil {
-							log.Printf("BuildJusticeTx error: %s", err.Error())
-						}
-					}
-				}
-			}
-		}
+		w.checkBlock(cointype, block)
+		w.blockSynced(cointype, block)
 	} // end of indefinite for
 
 	// never returns
 }
 
+// checkBlock looks for breaches in a block, and sends out justice for any
+func (w *WatchTower) checkBlock(cointype uint32, block *wire.MsgBlock) {
+	// get all txids from the blocks
+	txids, err := block.TxHashes()
+	if err != nil {
+		logger.Errorf("BlockHandler/TxHashes error: %s", err.Error())
+	}
+
+	// see if there are any hits from all the txids
+	// usually there aren't any so we can finish here
+	hits, err := w.matchTxidIdxs(txids)
+	if err != nil {
+		logger.Errorf("BlockHandler/MatchTxids error: %s", err.Error())
+	}
+
+	// if there were hits, need to build justice txs and send out
+	if len(hits) > 0 {
+		badTxs := make([]*wire.MsgTx, len(hits))
+		for i, ti := range hits {
+			logger.Infof("zomg tx %s matched db\n", txids[ti].String())
+			badTxs[i] = block.Transactions[ti]
+		}
+		jis := w.buildJusticeInputs(cointype, badTxs)
+		// every breach in the block, in as few txs as will verify
+		for _, justice := range batchJustice(jis) {
+			err = w.Hooks[cointype].PushTx(justice)
+			if err != nil {
+				logger.Errorf("PushTx justice error: %s", err.Error())
+				continue
+			}
+			logger.Infof("made & sent out justice tx %s, %d inputs\n",
+				justice.TxHash().String(), len(justice.TxIn))
+		}
+	}
+}
+
 // Status returns a string describing what's in the watchtower.
 /*
 func (w *WatchTower) Status() (string, error) {
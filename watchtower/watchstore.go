@@ -0,0 +1,423 @@
+package watchtower
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+The txid bucket is the one that grows with every state of every channel, so
+it can live somewhere other than watch.db.  The channel data (elkrems,
+static info, the pkh map) always stays in watch.db; only txid[:16] : IdxSig
+moves.  A separate store isn't in lit's backups; back it up on its own.
+
+--towerstore picks where:
+	(empty)                   txid bucket in watch.db, as always
+	bolt:/path/to/txids.db    its own bolt file, eg on a bigger disk
+	redis://[:pass@]host:port[/db]  a redis server, keys prefixed "lit:txi:"
+
+Anything else that can get and put bytes can go behind WatchStore.
+
+Writes to the store happen inside the watch.db transaction that updates the
+channel's elkrem, so if the store write fails the elkrem isn't advanced and
+the client's next update fails too.  If the store write works but watch.db
+doesn't commit, the store has a txid the tower won't find the channel
+state for, which BuildJusticeTx just errors on.
+*/
+
+// WatchStore holds the txid[:16] : IdxSig pairs for a tower
+type WatchStore interface {
+	Put(k, v []byte) error
+	Get(k []byte) ([]byte, error) // nil, nil if not there
+	Delete(k []byte) error
+	ForEach(func(k, v []byte) error) error
+	Count() (int, error)
+	Close() error
+}
+
+// OpenWatchStore opens the store described by spec, or returns nil for an
+// empty spec, meaning keep the txids in watch.db
+func OpenWatchStore(spec string) (WatchStore, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "bolt:"):
+		return openBoltStore(strings.TrimPrefix(spec, "bolt:"))
+	case strings.HasPrefix(spec, "redis://"):
+		return openRedisStore(spec)
+	}
+	return nil, fmt.Errorf("unknown tower store %q; use bolt:<file> or redis://", spec)
+}
+
+// putTxid, getTxid and forEachTxid use the store if there is one, and
+// otherwise the txid bucket in btx
+
+func (w *WatchTower) putTxid(btx *bolt.Tx, k, v []byte) error {
+	if w.Store != nil {
+		return w.Store.Put(k, v)
+	}
+	txidbkt := btx.Bucket(BUCKETTxid)
+	if txidbkt == nil {
+		return fmt.Errorf("no txid bucket")
+	}
+	return txidbkt.Put(k, v)
+}
+
+func (w *WatchTower) getTxid(btx *bolt.Tx, k []byte) ([]byte, error) {
+	if w.Store != nil {
+		return w.Store.Get(k)
+	}
+	txidbkt := btx.Bucket(BUCKETTxid)
+	if txidbkt == nil {
+		return nil, fmt.Errorf("no txid bucket")
+	}
+	return txidbkt.Get(k), nil
+}
+
+func (w *WatchTower) forEachTxid(btx *bolt.Tx, fn func(k, v []byte) error) error {
+	if w.Store != nil {
+		return w.Store.ForEach(fn)
+	}
+	txidbkt := btx.Bucket(BUCKETTxid)
+	if txidbkt == nil {
+		return fmt.Errorf("no txid bucket")
+	}
+	return txidbkt.ForEach(fn)
+}
+
+// ------------------------- bolt file
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("bolt tower store needs a file name")
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BUCKETTxid)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Put(k, v []byte) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(BUCKETTxid).Put(k, v)
+	})
+}
+
+func (s *boltStore) Delete(k []byte) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(BUCKETTxid).Delete(k)
+	})
+}
+
+func (s *boltStore) Get(k []byte) ([]byte, error) {
+	var v []byte
+	err := s.db.View(func(btx *bolt.Tx) error {
+		// copy out; bolt's bytes go away with the tx
+		if b := btx.Bucket(BUCKETTxid).Get(k); b != nil {
+			v = append([]byte{}, b...)
+		}
+		return nil
+	})
+	return v, err
+}
+
+func (s *boltStore) ForEach(fn func(k, v []byte) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(BUCKETTxid).ForEach(fn)
+	})
+}
+
+func (s *boltStore) Count() (int, error) {
+	var n int
+	err := s.db.View(func(btx *bolt.Tx) error {
+		n = btx.Bucket(BUCKETTxid).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// ------------------------- redis
+
+// redisPrefix goes in front of every key, so the tower can share a server
+const redisPrefix = "lit:txi:"
+
+// redisStore talks RESP to one redis server over one connection.  Tower
+// writes are one at a time anyway.
+type redisStore struct {
+	mtx  sync.Mutex
+	addr string
+	pass string
+	db   int
+
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func openRedisStore(spec string) (*redisStore, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisStore{addr: u.Host}
+	if !strings.Contains(s.addr, ":") {
+		s.addr += ":6379"
+	}
+	if u.User != nil {
+		s.pass, _ = u.User.Password()
+	}
+	if dbStr := strings.Trim(u.Path, "/"); dbStr != "" {
+		s.db, err = strconv.Atoi(dbStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad redis db %q", dbStr)
+		}
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials, and logs in and picks the db if needed.  Call with mtx.
+func (s *redisStore) connect() error {
+	conn, err := lnutil.DialTimeout("tcp", s.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.rd = bufio.NewReader(conn)
+	if s.pass != "" {
+		_, err = s.do("AUTH", []byte(s.pass))
+		if err != nil {
+			s.drop()
+			return err
+		}
+	}
+	if s.db != 0 {
+		_, err = s.do("SELECT", []byte(strconv.Itoa(s.db)))
+		if err != nil {
+			s.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+}
+
+// cmd sends one command and reads its reply, reconnecting once if the
+// connection went away
+func (s *redisStore) cmd(name string, args ...[]byte) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	r, err := s.do(name, args...)
+	if _, isRedisErr := err.(redisError); err != nil && !isRedisErr {
+		// network trouble; try again on a new connection
+		s.drop()
+		err = s.connect()
+		if err != nil {
+			return nil, err
+		}
+		r, err = s.do(name, args...)
+	}
+	return r, err
+}
+
+// do writes the command and reads the reply
+func (s *redisStore) do(name string, args ...[]byte) (interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	buf := []byte(fmt.Sprintf("*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(name), name))
+	for _, a := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(a))...)
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := s.conn.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	return readRESP(s.rd)
+}
+
+// redisError is an error reply from the server, not a connection problem
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readRESP reads one reply: string, int64, []byte (nil for nil) or
+// []interface{}
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: bad reply line %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(rd, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readRESP(rd)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
+
+func (s *redisStore) Put(k, v []byte) error {
+	_, err := s.cmd("SET", append([]byte(redisPrefix), k...), v)
+	return err
+}
+
+func (s *redisStore) Delete(k []byte) error {
+	_, err := s.cmd("DEL", append([]byte(redisPrefix), k...))
+	return err
+}
+
+func (s *redisStore) Get(k []byte) ([]byte, error) {
+	r, err := s.cmd("GET", append([]byte(redisPrefix), k...))
+	if err != nil {
+		return nil, err
+	}
+	v, ok := r.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: GET replied %T", r)
+	}
+	return v, nil
+}
+
+// scanKeys calls fn with every key with the prefix (prefix taken off);
+// order is whatever redis gives.  Keys added during the scan may or may
+// not show up.
+func (s *redisStore) scanKeys(fn func(k []byte) error) error {
+	cursor := []byte("0")
+	for {
+		r, err := s.cmd("SCAN", cursor,
+			[]byte("MATCH"), []byte(redisPrefix+"*"), []byte("COUNT"), []byte("1000"))
+		if err != nil {
+			return err
+		}
+		items, ok := r.([]interface{})
+		if !ok || len(items) != 2 {
+			return fmt.Errorf("redis: bad SCAN reply")
+		}
+		cursor, _ = items[0].([]byte)
+		keys, _ := items[1].([]interface{})
+		for _, ki := range keys {
+			k, _ := ki.([]byte)
+			if len(k) < len(redisPrefix) {
+				continue
+			}
+			err = fn(k[len(redisPrefix):])
+			if err != nil {
+				return err
+			}
+		}
+		if cursor == nil || string(cursor) == "0" {
+			return nil
+		}
+	}
+}
+
+func (s *redisStore) ForEach(fn func(k, v []byte) error) error {
+	return s.scanKeys(func(k []byte) error {
+		v, err := s.Get(k)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return nil // deleted since the scan
+		}
+		return fn(k, v)
+	})
+}
+
+func (s *redisStore) Count() (int, error) {
+	var n int
+	err := s.scanKeys(func(_ []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func (s *redisStore) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.drop()
+	return nil
+}
@@ -3,7 +3,9 @@ package watchtower
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
+	"github.com/adiabat/btcd/txscript"
 	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
@@ -18,14 +20,20 @@ type Watcher interface {
 	// The uint32 is the cointype, the string is the folder to put all db files.
 	HookLink(string, *coinparam.Params, uspv.ChainHook) error
 
+	// Each takes the pubkey of the client it's from, as lndc
+	// authenticated it; see clients.go
+
 	// New Channel to watch
-	NewChannel(lnutil.WatchDescMsg) error
+	NewChannel([33]byte, lnutil.WatchDescMsg) error
 
 	// Update a channel being watched
-	UpdateChannel(lnutil.WatchStateMsg) error
+	UpdateChannel([33]byte, lnutil.WatchStateMsg) error
 
 	// Delete a channel being watched
-	DeleteChannel(lnutil.WatchDelMsg) error
+	DeleteChannel([33]byte, lnutil.WatchDelMsg) error
+
+	// What's held for each of a client's channels, by DestPKH
+	Report([33]byte, [][20]byte) ([]lnutil.WatchReportEntry, error)
 
 	// Later on, allow users to recover channel state from
 	// the data in a watcher.  Like if they wipe their ln.db files but
@@ -41,6 +49,25 @@ type WatchTower struct {
 	// ... but that's less anonymous.  To get that efficiency; make a bunch of
 	// towers, I guess.
 
+	// where the txids go, if not in WatchDB; see watchstore.go
+	Store WatchStore
+
+	// how many breaches to build justice for at once; 0 for one per cpu
+	JusticeWorkers int
+
+	// longest a state waits to be committed with others arriving at the
+	// same time; 0 for DefaultBatchDelay, negative to not wait.  See batch.go
+	BatchDelay time.Duration
+	adds       chan addReq
+	addQuit    chan struct{}
+	addDone    chan struct{}
+
+	// operators who have to approve admin ops, from config; see admin.go
+	AdminPolicy *AdminPolicy
+
+	// who the tower serves; see clients.go
+	clients clientList
+
 	Accepting bool // true if new channels and sigs are allowed in
 	Watching  bool // true if there are txids to watch for
 
@@ -48,6 +75,8 @@ type WatchTower struct {
 
 	// map of cointypes to chainhooks
 	Hooks map[uint32]uspv.ChainHook
+	// to poke a coin's rescanner with a new gap; see rescan.go
+	rescanKicks map[uint32]chan struct{}
 }
 
 // Chainlink is the connection between the watchtower and the blockchain
@@ -61,6 +90,7 @@ func (w *WatchTower) HookLink(dbPath string, param *coinparam.Params,
 	// if the hooks map hasn't been initialized, make it. also open DB
 	if len(w.Hooks) == 0 {
 		w.Hooks = make(map[uint32]uspv.ChainHook)
+		w.rescanKicks = make(map[uint32]chan struct{})
 
 		towerDBName := filepath.Join(dbPath, "watch.db")
 		err := w.OpenDB(towerDBName)
@@ -78,6 +108,7 @@ func (w *WatchTower) HookLink(dbPath string, param *coinparam.Params,
 
 	// only need this for the pushTx() method
 	w.Hooks[cointype] = hook
+	w.rescanKicks[cointype] = w.startRescan(cointype, hook)
 
 	go w.BlockHandler(cointype, hook.RawBlocks())
 
@@ -88,9 +119,33 @@ func (w *WatchTower) HookLink(dbPath string, param *coinparam.Params,
 
 // IdxSig is what we save in the DB for each txid
 type IdxSig struct {
-	PKHIdx   uint32   // Who
-	StateIdx uint64   // When
-	Sig      [64]byte // What
+	PKHIdx   uint32               // Who
+	StateIdx uint64               // When
+	Sig      [64]byte             // What
+	HashType txscript.SigHashType // How
+}
+
+// JusticeHashTypes are the sighash types a client can sign justice with.
+// SINGLE|ANYONECANPAY only commits to its own input and output, so the
+// tower can batch it with others, or add inputs and outputs (eg for fees)
+// after the ones the sigs cover.
+var JusticeHashTypes = []txscript.SigHashType{
+	txscript.SigHashAll,
+	txscript.SigHashSingle | txscript.SigHashAnyOneCanPay,
+}
+
+// justiceHashType returns the sighash type from a client's state message,
+// or an error if it's not one the tower takes
+func justiceHashType(b uint8) (txscript.SigHashType, error) {
+	if b == 0 {
+		return txscript.SigHashAll, nil
+	}
+	for _, ht := range JusticeHashTypes {
+		if txscript.SigHashType(b) == ht {
+			return ht, nil
+		}
+	}
+	return 0, fmt.Errorf("sighash type %x not allowed for justice", b)
 }
 
 /*
@@ -125,3 +180,16 @@ func (w *WatchTower) HandleMessage(msg lnutil.LitMsg) error {
 	return nil
 }
 */
+
+// Close closes the watch db, if the tower was ever linked, and the txid
+// store if there is one.
+func (w *WatchTower) Close() error {
+	w.stopAddWriter()
+	if w.Store != nil {
+		w.Store.Close()
+	}
+	if w.WatchDB == nil {
+		return nil
+	}
+	return w.WatchDB.Close()
+}